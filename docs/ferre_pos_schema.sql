@@ -223,13 +223,30 @@ CREATE TABLE codigos_barra_adicionales (
     UNIQUE(codigo_barra)
 );
 
+-- Tabla: unidades_medida_producto (unidades de venta alternativas con su conversión a la unidad base)
+CREATE TABLE unidades_medida_producto (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    producto_id UUID NOT NULL REFERENCES productos(id) ON DELETE CASCADE,
+    unidad TEXT NOT NULL, -- Ej: 'CAJA', 'ROLLO'
+    factor_conversion NUMERIC(12,4) NOT NULL, -- Unidades base por unidad (caja = 100 UN, rollo = 50 M)
+    codigo_barra TEXT UNIQUE, -- Código propio de la presentación
+    precio_unitario NUMERIC(12,2), -- Precio de la presentación; NULL usa precio base por factor
+    activo BOOLEAN DEFAULT true,
+    fecha_creacion TIMESTAMP DEFAULT NOW(),
+    fecha_modificacion TIMESTAMP DEFAULT NOW(),
+    UNIQUE(producto_id, unidad),
+    CONSTRAINT chk_factor_conversion CHECK (factor_conversion > 0),
+    CONSTRAINT chk_precio_unidad_medida CHECK (precio_unitario IS NULL OR precio_unitario >= 0)
+);
+
 -- Tabla: stock_central (altamente optimizada para api_pos)
 CREATE TABLE stock_central (
     producto_id UUID REFERENCES productos(id),
     sucursal_id UUID REFERENCES sucursales(id),
-    cantidad INTEGER DEFAULT 0,
-    cantidad_reservada INTEGER DEFAULT 0,
-    cantidad_disponible INTEGER GENERATED ALWAYS AS (cantidad - cantidad_reservada) STORED,
+    cantidad NUMERIC(12,3) DEFAULT 0, -- En unidad base del producto; admite fracciones (metros, kilos)
+    cantidad_reservada NUMERIC(12,3) DEFAULT 0,
+    cantidad_disponible NUMERIC(12,3) GENERATED ALWAYS AS (cantidad - cantidad_reservada) STORED,
+    cantidad_en_transito NUMERIC(12,3) DEFAULT 0, -- Despachada desde otra sucursal y aún no recibida
     costo_promedio NUMERIC(12,2),
     fecha_ultima_entrada TIMESTAMP,
     fecha_ultima_salida TIMESTAMP,
@@ -237,11 +254,12 @@ CREATE TABLE stock_central (
     -- Campos optimizados para rendimiento
     version_optimistic_lock INTEGER DEFAULT 1, -- Control de concurrencia optimista
     cache_validez TIMESTAMP DEFAULT NOW(), -- Validez del cache
-    alertas_configuradas JSONB, -- Configuración de alertas de stock
+    alertas_configuradas JSONB, -- Umbrales de alerta del producto en la sucursal (ver evaluar_alertas_stock)
     PRIMARY KEY (producto_id, sucursal_id),
     CONSTRAINT chk_cantidad_positiva CHECK (cantidad >= 0),
     CONSTRAINT chk_reservada_positiva CHECK (cantidad_reservada >= 0),
-    CONSTRAINT chk_reservada_menor_cantidad CHECK (cantidad_reservada <= cantidad)
+    CONSTRAINT chk_reservada_menor_cantidad CHECK (cantidad_reservada <= cantidad),
+    CONSTRAINT chk_en_transito_positiva CHECK (cantidad_en_transito >= 0)
 );
 
 -- Tabla: movimientos_stock (particionada por fecha para rendimiento)
@@ -250,9 +268,9 @@ CREATE TABLE movimientos_stock (
     producto_id UUID REFERENCES productos(id),
     sucursal_id UUID REFERENCES sucursales(id),
     tipo_movimiento TEXT NOT NULL,
-    cantidad INTEGER NOT NULL,
-    cantidad_anterior INTEGER,
-    cantidad_nueva INTEGER,
+    cantidad NUMERIC(12,3) NOT NULL,
+    cantidad_anterior NUMERIC(12,3),
+    cantidad_nueva NUMERIC(12,3),
     costo_unitario NUMERIC(12,2),
     documento_referencia TEXT,
     usuario_id UUID REFERENCES usuarios(id),
@@ -282,6 +300,336 @@ CREATE TABLE movimientos_stock_2025_q4 PARTITION OF movimientos_stock
 CREATE TABLE movimientos_stock_futuro PARTITION OF movimientos_stock
     FOR VALUES FROM ('2026-01-01') TO (MAXVALUE);
 
+-- Tabla: series_productos (inventario por número de serie de productos con requiere_serie)
+CREATE TABLE series_productos (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    producto_id UUID NOT NULL REFERENCES productos(id),
+    sucursal_id UUID NOT NULL REFERENCES sucursales(id),
+    numero_serie TEXT NOT NULL,
+    estado TEXT NOT NULL DEFAULT 'disponible',
+    documento_ingreso TEXT,
+    fecha_ingreso TIMESTAMP DEFAULT NOW(),
+    usuario_ingreso_id UUID REFERENCES usuarios(id),
+    venta_id UUID, -- Referencia sin FK para particiones
+    detalle_venta_id UUID,
+    fecha_venta TIMESTAMP,
+    UNIQUE(producto_id, numero_serie),
+    CONSTRAINT chk_estado_serie CHECK (estado IN ('disponible', 'vendida', 'baja', 'en_transito'))
+);
+
+-- Tabla: lotes_stock (existencias por lote con vencimiento para despacho FEFO)
+CREATE TABLE lotes_stock (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    producto_id UUID NOT NULL REFERENCES productos(id),
+    sucursal_id UUID NOT NULL REFERENCES sucursales(id),
+    lote TEXT NOT NULL,
+    fecha_vencimiento DATE,
+    cantidad_inicial NUMERIC(12,3) NOT NULL,
+    cantidad NUMERIC(12,3) NOT NULL, -- Saldo vigente del lote en unidad base
+    documento_ingreso TEXT,
+    fecha_ingreso TIMESTAMP DEFAULT NOW(),
+    UNIQUE(producto_id, sucursal_id, lote),
+    CONSTRAINT chk_cantidad_lote CHECK (cantidad >= 0 AND cantidad <= cantidad_inicial)
+);
+
+-- Tabla: lotes_venta (cantidades de cada lote consumidas por una línea de venta)
+CREATE TABLE lotes_venta (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    lote_id UUID NOT NULL REFERENCES lotes_stock(id),
+    venta_id UUID NOT NULL, -- Referencia sin FK para particiones
+    detalle_venta_id UUID NOT NULL,
+    cantidad NUMERIC(12,3) NOT NULL,
+    fecha TIMESTAMP DEFAULT NOW(),
+    CONSTRAINT chk_cantidad_lote_venta CHECK (cantidad > 0)
+);
+
+-- Tabla: transferencias_stock (traslado de mercadería entre sucursales)
+CREATE TABLE transferencias_stock (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    numero_transferencia BIGSERIAL UNIQUE,
+    sucursal_origen_id UUID NOT NULL REFERENCES sucursales(id),
+    sucursal_destino_id UUID NOT NULL REFERENCES sucursales(id),
+    estado TEXT NOT NULL DEFAULT 'solicitada',
+    motivo TEXT,
+    observaciones TEXT,
+    usuario_solicitud_id UUID NOT NULL REFERENCES usuarios(id),
+    fecha_solicitud TIMESTAMP DEFAULT NOW(),
+    usuario_aprobacion_id UUID REFERENCES usuarios(id), -- Aprobó o rechazó
+    fecha_aprobacion TIMESTAMP,
+    motivo_rechazo TEXT,
+    usuario_despacho_id UUID REFERENCES usuarios(id),
+    fecha_despacho TIMESTAMP,
+    usuario_recepcion_id UUID REFERENCES usuarios(id),
+    fecha_recepcion TIMESTAMP,
+    observaciones_recepcion TEXT,
+    batch_id UUID, -- Compartido por los movimientos de salida y de entrada
+    total_items INTEGER DEFAULT 0,
+    CONSTRAINT chk_sucursales_transferencia CHECK (sucursal_origen_id <> sucursal_destino_id),
+    CONSTRAINT chk_estado_transferencia CHECK (estado IN (
+        'solicitada', 'aprobada', 'rechazada', 'en_transito', 'recibida', 'recibida_con_diferencias', 'cancelada'
+    ))
+);
+
+-- Tabla: detalle_transferencias_stock (cantidades solicitadas, despachadas y contadas en destino)
+CREATE TABLE detalle_transferencias_stock (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    transferencia_id UUID NOT NULL REFERENCES transferencias_stock(id) ON DELETE CASCADE,
+    producto_id UUID NOT NULL REFERENCES productos(id),
+    cantidad_solicitada NUMERIC(12,3) NOT NULL, -- En unidad base del producto
+    cantidad_despachada NUMERIC(12,3),
+    cantidad_recibida NUMERIC(12,3),
+    diferencia NUMERIC(12,3) GENERATED ALWAYS AS (cantidad_recibida - cantidad_despachada) STORED,
+    costo_unitario NUMERIC(12,2), -- Costo promedio de origen al despachar
+    numeros_serie TEXT[], -- Series despachadas de productos con requiere_serie
+    lotes JSONB, -- Desglose por lote de lo despachado
+    motivo_diferencia TEXT,
+    UNIQUE(transferencia_id, producto_id),
+    CONSTRAINT chk_cantidad_solicitada_transferencia CHECK (cantidad_solicitada > 0),
+    CONSTRAINT chk_cantidad_despachada_transferencia CHECK (cantidad_despachada IS NULL OR cantidad_despachada >= 0),
+    CONSTRAINT chk_cantidad_recibida_transferencia CHECK (cantidad_recibida IS NULL OR cantidad_recibida >= 0)
+);
+
+-- Tabla: conteos_inventario (tomas de inventario físico cíclicas o anuales por sucursal)
+CREATE TABLE conteos_inventario (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    numero_conteo BIGSERIAL UNIQUE,
+    sucursal_id UUID NOT NULL REFERENCES sucursales(id),
+    tipo TEXT NOT NULL DEFAULT 'ciclico',
+    estado TEXT NOT NULL DEFAULT 'abierto',
+    categoria_id UUID REFERENCES categorias_productos(id), -- Alcance: categoría
+    pasillo TEXT, -- Alcance: ubicación de bodega
+    estante TEXT,
+    observaciones TEXT,
+    usuario_apertura_id UUID NOT NULL REFERENCES usuarios(id),
+    fecha_apertura TIMESTAMP DEFAULT NOW(), -- Momento de la foto de stock_central
+    usuario_cierre_id UUID REFERENCES usuarios(id),
+    fecha_cierre TIMESTAMP,
+    usuario_aprobacion_id UUID REFERENCES usuarios(id), -- Aprobó o canceló
+    fecha_aprobacion TIMESTAMP,
+    motivo_cancelacion TEXT,
+    batch_id UUID, -- Movimientos de ajuste generados al aprobar
+    total_productos INTEGER DEFAULT 0,
+    valor_diferencia NUMERIC(14,2), -- Diferencia neta valorizada al aprobar
+    CONSTRAINT chk_tipo_conteo CHECK (tipo IN ('ciclico', 'anual')),
+    CONSTRAINT chk_estado_conteo CHECK (estado IN ('abierto', 'en_revision', 'aprobado', 'cancelado'))
+);
+
+-- Tabla: detalle_conteo_inventario (foto de stock y cantidad contada por producto)
+CREATE TABLE detalle_conteo_inventario (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    conteo_id UUID NOT NULL REFERENCES conteos_inventario(id) ON DELETE CASCADE,
+    producto_id UUID NOT NULL REFERENCES productos(id),
+    ubicacion TEXT, -- Pasillo-estante-nivel al abrir el conteo
+    cantidad_sistema NUMERIC(12,3) NOT NULL, -- Stock congelado al abrir, en unidad base
+    costo_unitario NUMERIC(12,2), -- Costo promedio congelado al abrir
+    cantidad_contada NUMERIC(12,3), -- Suma de las lecturas de la ronda vigente
+    ronda INTEGER NOT NULL DEFAULT 1, -- Se incrementa con cada reconteo
+    en_reconteo BOOLEAN DEFAULT false,
+    cantidad_ajustada NUMERIC(12,3), -- Ajuste aplicado a stock_central al aprobar
+    UNIQUE(conteo_id, producto_id),
+    CONSTRAINT chk_cantidad_contada_conteo CHECK (cantidad_contada IS NULL OR cantidad_contada >= 0)
+);
+
+-- Tabla: lecturas_conteo_inventario (lecturas enviadas por los terminales de conteo)
+CREATE TABLE lecturas_conteo_inventario (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    conteo_id UUID NOT NULL REFERENCES conteos_inventario(id) ON DELETE CASCADE,
+    detalle_id UUID NOT NULL REFERENCES detalle_conteo_inventario(id) ON DELETE CASCADE,
+    ronda INTEGER NOT NULL,
+    cantidad NUMERIC(12,3) NOT NULL, -- En unidad base del producto
+    codigo_barra TEXT,
+    terminal_id UUID REFERENCES terminales(id),
+    usuario_id UUID NOT NULL REFERENCES usuarios(id),
+    fecha TIMESTAMP DEFAULT NOW(),
+    CONSTRAINT chk_cantidad_lectura_conteo CHECK (cantidad > 0)
+);
+
+-- Tabla: proveedores (proveedores de mercadería)
+CREATE TABLE proveedores (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    rut TEXT UNIQUE NOT NULL,
+    razon_social TEXT NOT NULL,
+    nombre_fantasia TEXT,
+    giro TEXT,
+    direccion TEXT,
+    comuna TEXT,
+    telefono TEXT,
+    email TEXT,
+    contacto TEXT,
+    dias_credito INTEGER NOT NULL DEFAULT 30, -- Plazo de pago de sus documentos
+    dias_entrega INTEGER NOT NULL DEFAULT 7, -- Tiempo de reposición desde que se emite la orden
+    activo BOOLEAN DEFAULT true,
+    fecha_creacion TIMESTAMP DEFAULT NOW(),
+    fecha_modificacion TIMESTAMP DEFAULT NOW(),
+    CONSTRAINT chk_dias_credito_proveedor CHECK (dias_credito >= 0),
+    CONSTRAINT chk_dias_entrega_proveedor CHECK (dias_entrega >= 0)
+);
+
+-- Tabla: productos_proveedores (condiciones de compra de cada producto por proveedor)
+CREATE TABLE productos_proveedores (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    producto_id UUID NOT NULL REFERENCES productos(id) ON DELETE CASCADE,
+    proveedor_id UUID NOT NULL REFERENCES proveedores(id) ON DELETE CASCADE,
+    codigo_proveedor TEXT, -- Código del producto en el catálogo del proveedor
+    unidad_compra NUMERIC(12,3) NOT NULL DEFAULT 1, -- Unidades base por empaque; se pide en múltiplos
+    costo_referencia NUMERIC(12,2), -- Último costo pactado, propuesto en las órdenes sugeridas
+    dias_entrega INTEGER, -- NULL usa el tiempo de reposición del proveedor
+    preferido BOOLEAN DEFAULT false, -- Proveedor al que se sugiere reponer el producto
+    activo BOOLEAN DEFAULT true,
+    fecha_creacion TIMESTAMP DEFAULT NOW(),
+    fecha_modificacion TIMESTAMP DEFAULT NOW(),
+    UNIQUE(producto_id, proveedor_id),
+    CONSTRAINT chk_unidad_compra_positiva CHECK (unidad_compra > 0),
+    CONSTRAINT chk_costo_referencia_positivo CHECK (costo_referencia IS NULL OR costo_referencia >= 0),
+    CONSTRAINT chk_dias_entrega_producto CHECK (dias_entrega IS NULL OR dias_entrega >= 0)
+);
+
+-- Tabla: ordenes_compra (pedidos de mercadería a proveedores por sucursal)
+CREATE TABLE ordenes_compra (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    numero_orden BIGSERIAL UNIQUE,
+    proveedor_id UUID NOT NULL REFERENCES proveedores(id),
+    sucursal_id UUID NOT NULL REFERENCES sucursales(id),
+    estado TEXT NOT NULL DEFAULT 'borrador',
+    fecha_entrega_estimada DATE,
+    observaciones TEXT,
+    total_neto NUMERIC(14,2) DEFAULT 0,
+    usuario_creacion_id UUID NOT NULL REFERENCES usuarios(id),
+    fecha_creacion TIMESTAMP DEFAULT NOW(),
+    usuario_emision_id UUID REFERENCES usuarios(id),
+    fecha_emision TIMESTAMP,
+    fecha_cancelacion TIMESTAMP,
+    motivo_cancelacion TEXT,
+    CONSTRAINT chk_estado_orden_compra CHECK (estado IN (
+        'borrador', 'emitida', 'recibida_parcial', 'recibida', 'cancelada'
+    ))
+);
+
+-- Tabla: detalle_ordenes_compra (productos pedidos y cantidad recibida acumulada)
+CREATE TABLE detalle_ordenes_compra (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    orden_compra_id UUID NOT NULL REFERENCES ordenes_compra(id) ON DELETE CASCADE,
+    producto_id UUID NOT NULL REFERENCES productos(id),
+    cantidad NUMERIC(12,3) NOT NULL, -- En unidad base del producto
+    costo_unitario NUMERIC(12,2) NOT NULL, -- Neto pactado con el proveedor
+    cantidad_recibida NUMERIC(12,3) DEFAULT 0,
+    UNIQUE(orden_compra_id, producto_id),
+    CONSTRAINT chk_cantidad_orden_compra CHECK (cantidad > 0),
+    CONSTRAINT chk_costo_orden_compra CHECK (costo_unitario >= 0)
+);
+
+-- Tabla: recepciones_compra (ingresos de mercadería con el documento del proveedor)
+CREATE TABLE recepciones_compra (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    numero_recepcion BIGSERIAL UNIQUE,
+    proveedor_id UUID NOT NULL REFERENCES proveedores(id),
+    sucursal_id UUID NOT NULL REFERENCES sucursales(id),
+    orden_compra_id UUID REFERENCES ordenes_compra(id), -- NULL para compras sin orden
+    tipo_documento TEXT NOT NULL,
+    numero_documento TEXT NOT NULL,
+    fecha_documento DATE NOT NULL,
+    fecha_vencimiento DATE NOT NULL, -- Fecha del documento más los días de crédito del proveedor
+    monto_neto NUMERIC(14,2) NOT NULL,
+    monto_iva NUMERIC(14,2) NOT NULL,
+    monto_total NUMERIC(14,2) NOT NULL,
+    monto_pagado NUMERIC(14,2) DEFAULT 0,
+    estado_pago TEXT NOT NULL DEFAULT 'pendiente',
+    observaciones TEXT,
+    usuario_id UUID NOT NULL REFERENCES usuarios(id),
+    fecha_recepcion TIMESTAMP DEFAULT NOW(),
+    batch_id UUID, -- Movimientos de entrada de la recepción
+    trabajo_etiquetas_id UUID, -- Trabajo de etiquetas de precio de los productos con cambio de costo
+    UNIQUE(proveedor_id, tipo_documento, numero_documento),
+    CONSTRAINT chk_tipo_documento_recepcion CHECK (tipo_documento IN ('factura', 'guia_despacho')),
+    CONSTRAINT chk_estado_pago_recepcion CHECK (estado_pago IN ('pendiente', 'parcial', 'pagada')),
+    CONSTRAINT chk_monto_pagado_recepcion CHECK (monto_pagado >= 0 AND monto_pagado <= monto_total)
+);
+
+-- Tabla: detalle_recepciones_compra (productos recibidos con el costo promedio antes y después del ingreso)
+CREATE TABLE detalle_recepciones_compra (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    recepcion_id UUID NOT NULL REFERENCES recepciones_compra(id) ON DELETE CASCADE,
+    producto_id UUID NOT NULL REFERENCES productos(id),
+    detalle_orden_id UUID REFERENCES detalle_ordenes_compra(id), -- NULL si el producto no estaba pedido
+    cantidad NUMERIC(12,3) NOT NULL, -- En unidad base del producto
+    costo_unitario NUMERIC(12,2) NOT NULL,
+    costo_promedio_anterior NUMERIC(12,2),
+    costo_promedio_nuevo NUMERIC(12,2),
+    lote TEXT,
+    fecha_vencimiento DATE,
+    numeros_serie TEXT[],
+    CONSTRAINT chk_cantidad_recepcion CHECK (cantidad > 0)
+);
+
+-- Tabla: pagos_proveedores (abonos a documentos de proveedores)
+CREATE TABLE pagos_proveedores (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    recepcion_id UUID NOT NULL REFERENCES recepciones_compra(id),
+    monto NUMERIC(14,2) NOT NULL,
+    medio_pago TEXT NOT NULL,
+    referencia TEXT,
+    fecha_pago TIMESTAMP DEFAULT NOW(),
+    usuario_id UUID NOT NULL REFERENCES usuarios(id),
+    CONSTRAINT chk_monto_pago_proveedor CHECK (monto > 0),
+    CONSTRAINT chk_medio_pago_proveedor CHECK (medio_pago IN ('efectivo', 'transferencia', 'cheque'))
+);
+
+-- Tabla: alertas_stock_categorias (umbrales de alerta por defecto para los productos de una categoría)
+CREATE TABLE alertas_stock_categorias (
+    categoria_id UUID PRIMARY KEY REFERENCES categorias_productos(id) ON DELETE CASCADE,
+    configuracion JSONB NOT NULL,
+    usuario_modificacion UUID REFERENCES usuarios(id),
+    fecha_modificacion TIMESTAMP DEFAULT NOW()
+);
+
+-- Tabla: alertas_stock (alertas generadas por los movimientos de stock, con su atención)
+CREATE TABLE alertas_stock (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    producto_id UUID NOT NULL REFERENCES productos(id),
+    sucursal_id UUID NOT NULL REFERENCES sucursales(id),
+    tipo TEXT NOT NULL,
+    estado TEXT NOT NULL DEFAULT 'activa',
+    cantidad_actual NUMERIC(12,3), -- Stock resultante del movimiento que la generó
+    umbral NUMERIC(12,3),
+    mensaje TEXT NOT NULL,
+    movimiento_id UUID, -- Sin FK: movimientos_stock está particionada
+    tipo_movimiento TEXT,
+    documento_referencia TEXT,
+    fecha_creacion TIMESTAMP DEFAULT NOW(),
+    usuario_reconocimiento_id UUID REFERENCES usuarios(id),
+    fecha_reconocimiento TIMESTAMP,
+    usuario_resolucion_id UUID REFERENCES usuarios(id), -- NULL si se resolvió sola al reponer stock
+    fecha_resolucion TIMESTAMP,
+    observaciones_resolucion TEXT,
+    CONSTRAINT chk_tipo_alerta_stock CHECK (tipo IN (
+        'stock_bajo', 'sin_stock', 'ajuste_negativo', 'merma_inusual'
+    )),
+    CONSTRAINT chk_estado_alerta_stock CHECK (estado IN ('activa', 'reconocida', 'resuelta'))
+);
+
+-- Tabla: reservas_stock (stock comprometido por una nota de venta, cotización o carrito con vencimiento)
+CREATE TABLE reservas_stock (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    producto_id UUID NOT NULL REFERENCES productos(id),
+    sucursal_id UUID NOT NULL REFERENCES sucursales(id),
+    cantidad NUMERIC(12,3) NOT NULL, -- En unidad base del producto
+    tipo_origen TEXT NOT NULL,
+    origen_id UUID NOT NULL, -- Nota de venta, cotización o carrito generado por el terminal
+    usuario_id UUID NOT NULL REFERENCES usuarios(id), -- Dueño de la reserva
+    terminal_id UUID REFERENCES terminales(id),
+    estado TEXT NOT NULL DEFAULT 'activa',
+    fecha_creacion TIMESTAMP DEFAULT NOW(),
+    fecha_modificacion TIMESTAMP DEFAULT NOW(),
+    fecha_expiracion TIMESTAMP NOT NULL,
+    fecha_liberacion TIMESTAMP,
+    usuario_liberacion_id UUID REFERENCES usuarios(id),
+    motivo_liberacion TEXT,
+    observaciones TEXT,
+    CONSTRAINT chk_cantidad_reserva CHECK (cantidad > 0),
+    CONSTRAINT chk_tipo_origen_reserva CHECK (tipo_origen IN ('nota_venta', 'cotizacion', 'carrito')),
+    CONSTRAINT chk_estado_reserva CHECK (estado IN ('activa', 'liberada', 'consumida', 'expirada'))
+);
+
 -- Tabla: terminales (optimizada para conexiones frecuentes)
 CREATE TABLE terminales (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -401,6 +749,8 @@ CREATE TABLE detalle_ventas (
     -- Campos optimizados
     margen_unitario NUMERIC(12,2), -- Margen calculado
     categoria_producto_id UUID, -- Desnormalizado para reportes rápidos
+    unidad_medida TEXT, -- Unidad de venta; NULL es la unidad base del producto
+    factor_conversion NUMERIC(12,4) NOT NULL DEFAULT 1, -- Unidades base por unidad vendida
     CONSTRAINT chk_cantidad_positiva CHECK (cantidad > 0),
     CONSTRAINT chk_precios_positivos CHECK (
         precio_unitario >= 0 AND descuento_unitario >= 0 AND 
@@ -439,7 +789,7 @@ CREATE TABLE medios_pago_venta (
     comision NUMERIC(12,2), -- Comisión del medio de pago
     CONSTRAINT chk_medio_pago CHECK (medio_pago IN (
         'efectivo', 'tarjeta_debito', 'tarjeta_credito', 
-        'transferencia', 'cheque', 'puntos_fidelizacion', 'otro'
+        'transferencia', 'cheque', 'puntos_fidelizacion', 'cuenta_corriente', 'otro'
     )),
     CONSTRAINT chk_monto_positivo CHECK (monto > 0),
     CONSTRAINT chk_estado_conciliacion CHECK (estado_conciliacion IN (
@@ -447,6 +797,166 @@ CREATE TABLE medios_pago_venta (
     ))
 );
 
+-- Tabla: idempotencia_ventas (optimizada para reintentos de terminales de caja)
+CREATE TABLE idempotencia_ventas (
+    clave TEXT PRIMARY KEY, -- Idempotency-Key o UUID de venta generado por el cliente
+    hash_peticion TEXT NOT NULL, -- Hash del cuerpo de la petición original
+    terminal_id UUID REFERENCES terminales(id),
+    venta_id UUID,
+    codigo_http INTEGER,
+    respuesta JSONB, -- models.APIResponse original
+    fecha_creacion TIMESTAMP DEFAULT NOW(),
+    fecha_expiracion TIMESTAMP NOT NULL,
+    CONSTRAINT chk_clave_longitud CHECK (length(clave) BETWEEN 1 AND 255)
+);
+
+-- Tabla: sesiones_caja (optimizada para apertura y cierre de turnos en api_pos)
+CREATE TABLE sesiones_caja (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    numero_sesion BIGSERIAL UNIQUE,
+    terminal_id UUID NOT NULL REFERENCES terminales(id),
+    sucursal_id UUID NOT NULL REFERENCES sucursales(id),
+    usuario_apertura_id UUID NOT NULL REFERENCES usuarios(id),
+    usuario_cierre_id UUID REFERENCES usuarios(id),
+    monto_apertura NUMERIC(12,2) NOT NULL DEFAULT 0,
+    estado TEXT NOT NULL DEFAULT 'abierta',
+    fecha_apertura TIMESTAMP DEFAULT NOW(),
+    fecha_cierre TIMESTAMP,
+    total_esperado NUMERIC(12,2),
+    total_contado NUMERIC(12,2),
+    diferencia NUMERIC(12,2),
+    arqueo JSONB, -- Conteo ciego por denominación y medio de pago
+    reporte_z JSONB, -- Reporte Z generado al cierre
+    observaciones TEXT,
+    CONSTRAINT chk_estado_sesion_caja CHECK (estado IN ('abierta', 'cerrada')),
+    CONSTRAINT chk_monto_apertura_positivo CHECK (monto_apertura >= 0)
+);
+
+-- Tabla: movimientos_caja (retiros y depósitos de efectivo durante el turno, y devoluciones en efectivo
+-- de ventas de turnos anteriores anuladas en el turno)
+CREATE TABLE movimientos_caja (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    sesion_caja_id UUID NOT NULL REFERENCES sesiones_caja(id),
+    tipo TEXT NOT NULL,
+    monto NUMERIC(12,2) NOT NULL,
+    motivo TEXT NOT NULL,
+    usuario_id UUID NOT NULL REFERENCES usuarios(id),
+    autorizado_por UUID REFERENCES usuarios(id),
+    fecha TIMESTAMP DEFAULT NOW(),
+    CONSTRAINT chk_tipo_movimiento_caja CHECK (tipo IN ('retiro', 'deposito', 'anulacion')),
+    CONSTRAINT chk_monto_movimiento_caja CHECK (monto > 0)
+);
+
+-- Tabla: politicas_descuento (límites de descuento por rol y categoría)
+CREATE TABLE politicas_descuento (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    rol rol_usuario NOT NULL,
+    categoria_id UUID REFERENCES categorias_productos(id), -- NULL aplica a todas las categorías
+    porcentaje_maximo NUMERIC(5,2) NOT NULL DEFAULT 0,
+    monto_maximo NUMERIC(12,2), -- Descuento máximo por línea, NULL sin límite de monto
+    activo BOOLEAN DEFAULT true,
+    fecha_creacion TIMESTAMP DEFAULT NOW(),
+    fecha_modificacion TIMESTAMP DEFAULT NOW(),
+    CONSTRAINT chk_porcentaje_maximo_descuento CHECK (porcentaje_maximo BETWEEN 0 AND 100),
+    CONSTRAINT chk_monto_maximo_descuento CHECK (monto_maximo IS NULL OR monto_maximo >= 0)
+);
+
+-- Tabla: autorizaciones_descuento (códigos de un solo uso emitidos por supervisores)
+CREATE TABLE autorizaciones_descuento (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    codigo_hash TEXT NOT NULL, -- SHA-256 del código entregado al cajero
+    supervisor_id UUID NOT NULL REFERENCES usuarios(id),
+    sucursal_id UUID NOT NULL REFERENCES sucursales(id),
+    motivo TEXT NOT NULL,
+    fecha_creacion TIMESTAMP DEFAULT NOW(),
+    fecha_expiracion TIMESTAMP NOT NULL,
+    usada BOOLEAN DEFAULT false,
+    venta_id UUID, -- Referencia sin FK para particiones
+    fecha_uso TIMESTAMP,
+    CONSTRAINT chk_expiracion_autorizacion CHECK (fecha_expiracion > fecha_creacion)
+);
+
+-- Tabla: promociones (motor de promociones aplicado en caja)
+CREATE TABLE promociones (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    codigo TEXT UNIQUE NOT NULL,
+    nombre TEXT NOT NULL,
+    descripcion TEXT,
+    tipo TEXT NOT NULL,
+    producto_id UUID REFERENCES productos(id),
+    categoria_id UUID REFERENCES categorias_productos(id),
+    lleva_cantidad INTEGER, -- Tipo lleva_paga: unidades que lleva el cliente
+    paga_cantidad INTEGER, -- Tipo lleva_paga: unidades que paga el cliente
+    porcentaje NUMERIC(5,2), -- Tipo porcentaje: descuento sobre el precio
+    tramos JSONB, -- Tipo volumen: [{"cantidad_minima": 10, "porcentaje": 5}]
+    prioridad INTEGER DEFAULT 0,
+    activa BOOLEAN DEFAULT true,
+    usuario_creacion_id UUID REFERENCES usuarios(id),
+    fecha_creacion TIMESTAMP DEFAULT NOW(),
+    fecha_modificacion TIMESTAMP DEFAULT NOW(),
+    CONSTRAINT chk_tipo_promocion CHECK (tipo IN ('lleva_paga', 'volumen', 'porcentaje')),
+    CONSTRAINT chk_objetivo_promocion CHECK (producto_id IS NOT NULL OR categoria_id IS NOT NULL),
+    CONSTRAINT chk_lleva_paga CHECK (
+        tipo <> 'lleva_paga' OR (lleva_cantidad > paga_cantidad AND paga_cantidad > 0)
+    ),
+    CONSTRAINT chk_porcentaje_promocion CHECK (
+        tipo <> 'porcentaje' OR (porcentaje > 0 AND porcentaje <= 100)
+    ),
+    CONSTRAINT chk_tramos_promocion CHECK (tipo <> 'volumen' OR jsonb_array_length(tramos) > 0)
+);
+
+-- Tabla: promociones_sucursales (ventana de vigencia de cada promoción por sucursal)
+CREATE TABLE promociones_sucursales (
+    promocion_id UUID NOT NULL REFERENCES promociones(id) ON DELETE CASCADE,
+    sucursal_id UUID NOT NULL REFERENCES sucursales(id),
+    fecha_inicio TIMESTAMP NOT NULL,
+    fecha_fin TIMESTAMP NOT NULL,
+    PRIMARY KEY (promocion_id, sucursal_id),
+    CONSTRAINT chk_vigencia_promocion CHECK (fecha_fin > fecha_inicio)
+);
+
+-- Tabla: cuentas_corrientes (crédito a clientes empresa con estado de cuenta mensual)
+CREATE TABLE cuentas_corrientes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    cliente_rut TEXT NOT NULL UNIQUE,
+    razon_social TEXT NOT NULL,
+    giro TEXT,
+    direccion TEXT,
+    email TEXT,
+    telefono TEXT,
+    limite_credito NUMERIC(12,2) NOT NULL DEFAULT 0,
+    dias_plazo INTEGER NOT NULL DEFAULT 30, -- Condición de pago de cada cargo
+    saldo NUMERIC(12,2) NOT NULL DEFAULT 0, -- Deuda vigente del cliente
+    estado TEXT NOT NULL DEFAULT 'activa',
+    usuario_creacion_id UUID REFERENCES usuarios(id),
+    fecha_creacion TIMESTAMP DEFAULT NOW(),
+    fecha_modificacion TIMESTAMP DEFAULT NOW(),
+    CONSTRAINT chk_estado_cuenta_corriente CHECK (estado IN ('activa', 'bloqueada', 'cerrada')),
+    CONSTRAINT chk_limite_credito CHECK (limite_credito >= 0),
+    CONSTRAINT chk_dias_plazo CHECK (dias_plazo BETWEEN 0 AND 180)
+);
+
+-- Tabla: movimientos_cuenta_corriente (libro de cargos y abonos de cada cuenta)
+CREATE TABLE movimientos_cuenta_corriente (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    cuenta_id UUID NOT NULL REFERENCES cuentas_corrientes(id),
+    sucursal_id UUID REFERENCES sucursales(id),
+    tipo TEXT NOT NULL,
+    monto NUMERIC(12,2) NOT NULL, -- Positivo aumenta la deuda, negativo la disminuye
+    saldo_anterior NUMERIC(12,2) NOT NULL,
+    saldo_nuevo NUMERIC(12,2) NOT NULL,
+    saldo_pendiente NUMERIC(12,2) NOT NULL DEFAULT 0, -- Parte impaga de un cargo, para antigüedad de deuda
+    fecha TIMESTAMP DEFAULT NOW(),
+    fecha_vencimiento TIMESTAMP, -- Solo cargos
+    venta_id UUID, -- Referencia sin FK para particiones
+    medio_pago TEXT, -- Solo pagos
+    referencia TEXT,
+    observaciones TEXT,
+    usuario_id UUID REFERENCES usuarios(id),
+    CONSTRAINT chk_tipo_movimiento_cuenta CHECK (tipo IN ('cargo', 'pago', 'anulacion', 'ajuste')),
+    CONSTRAINT chk_saldo_pendiente_cuenta CHECK (saldo_pendiente >= 0)
+);
+
 -- =====================================================
 -- TABLAS ESPECÍFICAS PARA MÓDULO DE ETIQUETAS (api_labels)
 -- =====================================================
@@ -722,6 +1232,47 @@ CREATE TABLE detalle_notas_venta (
     )
 );
 
+-- Tabla: cotizaciones (presupuestos formales para contratistas con precios congelados)
+CREATE TABLE cotizaciones (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    numero_cotizacion BIGSERIAL UNIQUE,
+    sucursal_id UUID NOT NULL REFERENCES sucursales(id),
+    vendedor_id UUID REFERENCES usuarios(id),
+    cliente_rut TEXT NOT NULL,
+    cliente_nombre TEXT,
+    cliente_email TEXT,
+    subtotal NUMERIC(12,2) NOT NULL DEFAULT 0,
+    descuento_total NUMERIC(12,2) NOT NULL DEFAULT 0,
+    impuesto_total NUMERIC(12,2) NOT NULL DEFAULT 0,
+    total NUMERIC(12,2) NOT NULL DEFAULT 0,
+    estado TEXT NOT NULL DEFAULT 'vigente',
+    fecha TIMESTAMP DEFAULT NOW(),
+    fecha_validez TIMESTAMP NOT NULL,
+    observaciones TEXT,
+    -- Conversión al ser aceptada por el cliente
+    nota_venta_id UUID REFERENCES notas_venta(id),
+    venta_id UUID, -- Referencia sin FK para particiones
+    fecha_conversion TIMESTAMP,
+    usuario_conversion_id UUID REFERENCES usuarios(id),
+    CONSTRAINT chk_estado_cotizacion CHECK (estado IN ('vigente', 'convertida', 'vencida', 'anulada')),
+    CONSTRAINT chk_validez_cotizacion CHECK (fecha_validez > fecha)
+);
+
+-- Tabla: detalle_cotizaciones (precios y descripción congelados al cotizar)
+CREATE TABLE detalle_cotizaciones (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    cotizacion_id UUID NOT NULL REFERENCES cotizaciones(id) ON DELETE CASCADE,
+    producto_id UUID NOT NULL REFERENCES productos(id),
+    codigo_interno TEXT NOT NULL,
+    descripcion TEXT NOT NULL,
+    cantidad NUMERIC(10,3) NOT NULL,
+    precio_unitario NUMERIC(12,2) NOT NULL,
+    descuento_unitario NUMERIC(12,2) NOT NULL DEFAULT 0,
+    total_item NUMERIC(12,2) NOT NULL,
+    CONSTRAINT chk_cantidad_cotizacion CHECK (cantidad > 0),
+    CONSTRAINT chk_descuento_cotizacion CHECK (descuento_unitario >= 0 AND descuento_unitario <= precio_unitario)
+);
+
 -- Tabla: proveedores_dte (optimizada para integración)
 CREATE TABLE proveedores_dte (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -951,6 +1502,7 @@ CREATE TABLE notas_credito (
     sucursal_id UUID REFERENCES sucursales(id),
     documento_origen_id UUID REFERENCES documentos_dte(id),
     venta_origen_id UUID, -- Referencia sin FK para particiones
+    terminal_id UUID REFERENCES terminales(id), -- Caja donde se entrega el reembolso
     supervisor_id UUID REFERENCES usuarios(id),
     cajero_id UUID REFERENCES usuarios(id),
     motivo TEXT NOT NULL,
@@ -979,8 +1531,9 @@ CREATE TABLE notas_credito (
 CREATE TABLE detalle_notas_credito (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     nota_credito_id UUID REFERENCES notas_credito(id) ON DELETE CASCADE,
+    detalle_venta_id UUID, -- Línea devuelta de la venta de origen, sin FK para particiones
     producto_id UUID REFERENCES productos(id),
-    cantidad NUMERIC(10,3) NOT NULL,
+    cantidad NUMERIC(10,3) NOT NULL, -- En la unidad en que se vendió la línea
     precio_unitario NUMERIC(12,2) NOT NULL,
     total_item NUMERIC(12,2) NOT NULL,
     motivo_item TEXT,
@@ -993,6 +1546,21 @@ CREATE TABLE detalle_notas_credito (
     )
 );
 
+-- Tabla: reembolsos_nota_credito (medios con que se devuelve el dinero de una nota de crédito)
+CREATE TABLE reembolsos_nota_credito (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    nota_credito_id UUID NOT NULL REFERENCES notas_credito(id) ON DELETE CASCADE,
+    medio_pago TEXT NOT NULL,
+    monto NUMERIC(12,2) NOT NULL,
+    referencia_transaccion TEXT,
+    sesion_caja_id UUID REFERENCES sesiones_caja(id), -- Asignada al autorizar, cuando se entrega el reembolso
+    fecha TIMESTAMP DEFAULT NOW(),
+    CONSTRAINT chk_medio_reembolso CHECK (medio_pago IN (
+        'efectivo', 'tarjeta_debito', 'tarjeta_credito', 'transferencia', 'cuenta_corriente'
+    )),
+    CONSTRAINT chk_monto_reembolso CHECK (monto > 0)
+);
+
 -- Tabla: despachos (optimizada para control de entregas)
 CREATE TABLE despachos (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -1014,6 +1582,13 @@ CREATE TABLE despachos (
     tiempo_estimado_minutos INTEGER,
     codigo_seguimiento TEXT,
     ubicacion_picking TEXT,
+    -- Recepción conforme del cliente
+    firma_imagen BYTEA, -- PNG o JPEG capturado en la tablet de despacho
+    firma_tipo_contenido TEXT,
+    firma_nombre TEXT,
+    firma_rut TEXT,
+    fecha_firma TIMESTAMP,
+    lista_picking_id UUID, -- FK agregada tras crear listas_picking
     CONSTRAINT chk_estado_despacho CHECK (estado IN (
         'pendiente', 'en_proceso', 'completo', 'parcial', 'rechazado'
     )),
@@ -1044,6 +1619,62 @@ CREATE TABLE detalle_despacho (
     )
 );
 
+-- Tabla: incidencias_despacho (problemas registrados durante la preparación o entrega)
+CREATE TABLE incidencias_despacho (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    despacho_id UUID NOT NULL REFERENCES despachos(id) ON DELETE CASCADE,
+    detalle_despacho_id UUID REFERENCES detalle_despacho(id) ON DELETE CASCADE, -- NULL si afecta al despacho completo
+    tipo TEXT NOT NULL,
+    descripcion TEXT NOT NULL,
+    cantidad_afectada NUMERIC(10,3),
+    usuario_id UUID REFERENCES usuarios(id),
+    fecha TIMESTAMP DEFAULT NOW(),
+    datos_adicionales JSONB,
+    CONSTRAINT chk_tipo_incidencia_despacho CHECK (tipo IN (
+        'producto_faltante', 'producto_danado', 'producto_incorrecto',
+        'cliente_ausente', 'direccion_incorrecta', 'rechazo_cliente', 'otro'
+    )),
+    CONSTRAINT chk_cantidad_incidencia_positiva CHECK (cantidad_afectada IS NULL OR cantidad_afectada > 0)
+);
+
+-- Tabla: ubicaciones_producto (ubicación en bodega de cada producto por sucursal)
+CREATE TABLE ubicaciones_producto (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    producto_id UUID NOT NULL REFERENCES productos(id) ON DELETE CASCADE,
+    sucursal_id UUID NOT NULL REFERENCES sucursales(id),
+    pasillo TEXT NOT NULL,
+    estante TEXT NOT NULL,
+    nivel TEXT NOT NULL,
+    observaciones TEXT,
+    fecha_creacion TIMESTAMP DEFAULT NOW(),
+    fecha_modificacion TIMESTAMP DEFAULT NOW(),
+    usuario_modificacion_id UUID REFERENCES usuarios(id),
+    CONSTRAINT uk_ubicacion_producto_sucursal UNIQUE (producto_id, sucursal_id)
+);
+
+-- Tabla: listas_picking (recolección conjunta de varios despachos en orden de recorrido)
+CREATE TABLE listas_picking (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    numero_lista BIGSERIAL UNIQUE,
+    sucursal_id UUID NOT NULL REFERENCES sucursales(id),
+    estado TEXT NOT NULL DEFAULT 'pendiente',
+    usuario_creacion_id UUID REFERENCES usuarios(id),
+    usuario_picking_id UUID REFERENCES usuarios(id),
+    ubicacion_consolidacion TEXT, -- Zona donde se dejan los despachos preparados
+    total_despachos INTEGER NOT NULL DEFAULT 0,
+    total_lineas INTEGER NOT NULL DEFAULT 0,
+    fecha_creacion TIMESTAMP DEFAULT NOW(),
+    fecha_inicio TIMESTAMP,
+    fecha_completado TIMESTAMP,
+    tiempo_total_segundos INTEGER,
+    CONSTRAINT chk_estado_lista_picking CHECK (estado IN (
+        'pendiente', 'en_proceso', 'completada', 'cancelada'
+    ))
+);
+
+ALTER TABLE despachos ADD CONSTRAINT fk_despachos_lista_picking
+    FOREIGN KEY (lista_picking_id) REFERENCES listas_picking(id);
+
 -- Tabla: reimpresiones_documentos (optimizada para auditoría)
 CREATE TABLE reimpresiones_documentos (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -1061,7 +1692,28 @@ CREATE TABLE reimpresiones_documentos (
     -- Campos optimizados
     hash_documento_original TEXT,
     tiempo_procesamiento_ms INTEGER,
-    tamaño_archivo_bytes INTEGER
+    tamaño_archivo_bytes INTEGER,
+    CONSTRAINT chk_tipo_documento_reimpresion CHECK (tipo_documento IN (
+        'boleta', 'factura', 'comprobante_despacho'
+    )),
+    CONSTRAINT chk_reimpresiones_previas CHECK (reimpresiones_previas >= 0)
+);
+
+-- Tabla: autorizaciones_reimpresion (códigos de un solo uso para reimprimir sobre el límite libre)
+CREATE TABLE autorizaciones_reimpresion (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    codigo_hash TEXT NOT NULL, -- SHA-256 del código entregado al cajero
+    supervisor_id UUID NOT NULL REFERENCES usuarios(id),
+    sucursal_id UUID NOT NULL REFERENCES sucursales(id),
+    tipo_documento TEXT NOT NULL,
+    documento_id UUID NOT NULL, -- Venta o despacho, sin FK para particiones
+    motivo TEXT NOT NULL,
+    fecha_creacion TIMESTAMP DEFAULT NOW(),
+    fecha_expiracion TIMESTAMP NOT NULL,
+    usada BOOLEAN DEFAULT false,
+    reimpresion_id UUID REFERENCES reimpresiones_documentos(id),
+    fecha_uso TIMESTAMP,
+    CONSTRAINT chk_expiracion_autorizacion_reimpresion CHECK (fecha_expiracion > fecha_creacion)
 );
 
 -- =====================================================
@@ -1294,6 +1946,76 @@ CREATE INDEX idx_detalle_ventas_producto_fecha ON detalle_ventas(producto_id, ve
 CREATE INDEX idx_medios_pago_venta_medio ON medios_pago_venta(venta_id, medio_pago);
 CREATE INDEX idx_medios_pago_fecha_medio ON medios_pago_venta(fecha_procesamiento, medio_pago) WHERE fecha_procesamiento >= CURRENT_DATE;
 
+-- Índices para idempotencia de ventas
+CREATE INDEX idx_idempotencia_ventas_expiracion ON idempotencia_ventas(fecha_expiracion);
+
+-- Índices para sesiones de caja (una sesión abierta por terminal)
+CREATE UNIQUE INDEX idx_sesiones_caja_terminal_abierta ON sesiones_caja(terminal_id) WHERE estado = 'abierta';
+CREATE INDEX idx_movimientos_caja_sesion ON movimientos_caja(sesion_caja_id);
+CREATE INDEX idx_ventas_sesion_caja ON ventas((datos_adicionales->>'sesion_caja_id'));
+
+-- Índices para políticas y autorizaciones de descuento
+CREATE UNIQUE INDEX idx_politicas_descuento_rol_categoria ON politicas_descuento(rol, COALESCE(categoria_id, '00000000-0000-0000-0000-000000000000'::uuid));
+CREATE UNIQUE INDEX idx_autorizaciones_descuento_codigo ON autorizaciones_descuento(codigo_hash) WHERE usada = false;
+CREATE INDEX idx_autorizaciones_descuento_expiracion ON autorizaciones_descuento(fecha_expiracion) WHERE usada = false;
+
+-- Índices para promociones vigentes por sucursal
+CREATE INDEX idx_promociones_sucursales_vigencia ON promociones_sucursales(sucursal_id, fecha_inicio, fecha_fin);
+CREATE INDEX idx_promociones_producto ON promociones(producto_id) WHERE activa = true AND producto_id IS NOT NULL;
+CREATE INDEX idx_promociones_categoria ON promociones(categoria_id) WHERE activa = true AND categoria_id IS NOT NULL;
+
+-- Índices para cotizaciones
+CREATE INDEX idx_cotizaciones_sucursal_estado ON cotizaciones(sucursal_id, estado, fecha DESC);
+CREATE INDEX idx_cotizaciones_cliente ON cotizaciones(cliente_rut, fecha DESC);
+CREATE INDEX idx_detalle_cotizaciones_cotizacion ON detalle_cotizaciones(cotizacion_id);
+
+-- Índices para cuentas corrientes
+CREATE INDEX idx_movimientos_cuenta_corriente_cuenta ON movimientos_cuenta_corriente(cuenta_id, fecha);
+CREATE INDEX idx_movimientos_cuenta_corriente_pendientes ON movimientos_cuenta_corriente(cuenta_id, fecha_vencimiento) WHERE saldo_pendiente > 0;
+CREATE INDEX idx_movimientos_cuenta_corriente_venta ON movimientos_cuenta_corriente(venta_id) WHERE venta_id IS NOT NULL;
+
+-- Índices para unidades de medida por producto
+CREATE INDEX idx_unidades_medida_producto ON unidades_medida_producto(producto_id) WHERE activo = true;
+
+-- Índices para trazabilidad por serie y lote
+CREATE INDEX idx_series_productos_disponibles ON series_productos(producto_id, sucursal_id) WHERE estado = 'disponible';
+CREATE INDEX idx_series_productos_numero ON series_productos(numero_serie);
+CREATE INDEX idx_series_productos_venta ON series_productos(venta_id) WHERE venta_id IS NOT NULL;
+CREATE INDEX idx_lotes_stock_fefo ON lotes_stock(producto_id, sucursal_id, fecha_vencimiento NULLS LAST, fecha_ingreso) WHERE cantidad > 0;
+CREATE INDEX idx_lotes_venta_venta ON lotes_venta(venta_id);
+CREATE INDEX idx_transferencias_origen_estado ON transferencias_stock(sucursal_origen_id, estado, fecha_solicitud);
+CREATE INDEX idx_transferencias_destino_estado ON transferencias_stock(sucursal_destino_id, estado, fecha_solicitud);
+CREATE INDEX idx_detalle_transferencias_transferencia ON detalle_transferencias_stock(transferencia_id);
+CREATE INDEX idx_conteos_inventario_sucursal_estado ON conteos_inventario(sucursal_id, estado, fecha_apertura);
+CREATE INDEX idx_detalle_conteo_producto ON detalle_conteo_inventario(producto_id);
+CREATE INDEX idx_lecturas_conteo_detalle ON lecturas_conteo_inventario(detalle_id, ronda);
+CREATE INDEX idx_productos_proveedores_proveedor ON productos_proveedores(proveedor_id);
+CREATE UNIQUE INDEX idx_productos_proveedores_preferido ON productos_proveedores(producto_id) WHERE preferido = true;
+CREATE INDEX idx_ordenes_compra_sucursal_estado ON ordenes_compra(sucursal_id, estado, fecha_creacion);
+CREATE INDEX idx_ordenes_compra_proveedor ON ordenes_compra(proveedor_id, fecha_creacion);
+CREATE INDEX idx_detalle_ordenes_compra_producto ON detalle_ordenes_compra(producto_id);
+CREATE INDEX idx_recepciones_compra_sucursal ON recepciones_compra(sucursal_id, fecha_recepcion);
+CREATE INDEX idx_recepciones_compra_pendientes ON recepciones_compra(proveedor_id, fecha_vencimiento) WHERE estado_pago <> 'pagada';
+CREATE INDEX idx_detalle_recepciones_compra_recepcion ON detalle_recepciones_compra(recepcion_id);
+CREATE INDEX idx_pagos_proveedores_recepcion ON pagos_proveedores(recepcion_id);
+CREATE INDEX idx_alertas_stock_sucursal_estado ON alertas_stock(sucursal_id, estado, fecha_creacion);
+CREATE INDEX idx_alertas_stock_abiertas ON alertas_stock(producto_id, sucursal_id, tipo) WHERE estado <> 'resuelta';
+CREATE INDEX idx_reservas_stock_producto ON reservas_stock(producto_id, sucursal_id) WHERE estado = 'activa';
+CREATE UNIQUE INDEX idx_reservas_stock_origen ON reservas_stock(tipo_origen, origen_id, producto_id) WHERE estado = 'activa';
+CREATE INDEX idx_reservas_stock_expiracion ON reservas_stock(fecha_expiracion) WHERE estado = 'activa';
+
+-- Índices para notas de crédito por devolución
+CREATE INDEX idx_notas_credito_venta_origen ON notas_credito(venta_origen_id) WHERE venta_origen_id IS NOT NULL;
+CREATE INDEX idx_notas_credito_sucursal_estado ON notas_credito(sucursal_id, estado, fecha_solicitud DESC);
+CREATE INDEX idx_detalle_notas_credito_nota ON detalle_notas_credito(nota_credito_id);
+CREATE INDEX idx_detalle_notas_credito_detalle_venta ON detalle_notas_credito(detalle_venta_id) WHERE detalle_venta_id IS NOT NULL;
+CREATE INDEX idx_reembolsos_nota_credito_sesion ON reembolsos_nota_credito(sesion_caja_id) WHERE sesion_caja_id IS NOT NULL;
+
+-- Índices para control de reimpresiones
+CREATE INDEX idx_reimpresiones_documento ON reimpresiones_documentos(documento_id, tipo_documento);
+CREATE INDEX idx_reimpresiones_sucursal_fecha ON reimpresiones_documentos(sucursal_id, fecha DESC);
+CREATE UNIQUE INDEX idx_autorizaciones_reimpresion_codigo ON autorizaciones_reimpresion(codigo_hash) WHERE usada = false;
+
 -- Índices para notas de venta (flujo POS Tienda -> Caja)
 CREATE INDEX idx_notas_venta_qr ON notas_venta(qr_code) WHERE qr_code IS NOT NULL;
 CREATE INDEX idx_notas_venta_estado_sucursal ON notas_venta(estado, sucursal_id) WHERE estado = 'pendiente';
@@ -1301,7 +2023,7 @@ CREATE INDEX idx_notas_venta_prioridad ON notas_venta(prioridad_atencion, fecha)
 
 -- Índices para fidelización (consultas frecuentes en POS)
 CREATE UNIQUE INDEX idx_fidelizacion_rut_activo ON fidelizacion_clientes(rut) WHERE activo = true;
-CREATE INDEX idx_fidelizacion_qr ON fidelizacion_clientes(qr_fidelizacion) WHERE qr_fidelizacion IS NOT NULL;
+CREATE UNIQUE INDEX idx_fidelizacion_qr ON fidelizacion_clientes(qr_fidelizacion) WHERE qr_fidelizacion IS NOT NULL;
 CREATE INDEX idx_fidelizacion_hash_busqueda ON fidelizacion_clientes(hash_busqueda) WHERE activo = true;
 
 -- ÍNDICES PARA API_SYNC (PRIORIDAD MEDIA)
@@ -1403,6 +2125,14 @@ CREATE INDEX idx_folios_dte_agotamiento ON folios_dte(sucursal_id, tipo_document
 CREATE INDEX idx_despachos_estado_fecha ON despachos(estado, fecha_programada) WHERE estado IN ('pendiente', 'en_proceso');
 CREATE INDEX idx_despachos_prioridad ON despachos(prioridad_despacho, fecha_programada) WHERE estado = 'pendiente';
 CREATE INDEX idx_despachos_usuario_fecha ON despachos(usuario_despacho_id, fecha_programada);
+CREATE INDEX idx_despachos_cliente_estado ON despachos(cliente_rut, estado) WHERE cliente_rut IS NOT NULL;
+CREATE UNIQUE INDEX idx_despachos_venta ON despachos(venta_id) WHERE venta_id IS NOT NULL;
+CREATE INDEX idx_detalle_despacho_despacho ON detalle_despacho(despacho_id, orden_picking);
+CREATE INDEX idx_incidencias_despacho_despacho ON incidencias_despacho(despacho_id, fecha);
+CREATE INDEX idx_despachos_lista_picking ON despachos(lista_picking_id) WHERE lista_picking_id IS NOT NULL;
+CREATE INDEX idx_detalle_despacho_usuario_fecha ON detalle_despacho(usuario_despacho_id, fecha_despacho) WHERE tiempo_picking_segundos IS NOT NULL;
+CREATE INDEX idx_ubicaciones_producto_recorrido ON ubicaciones_producto(sucursal_id, pasillo, estante, nivel);
+CREATE INDEX idx_listas_picking_sucursal_estado ON listas_picking(sucursal_id, estado, fecha_creacion);
 
 -- Índices para logs de seguridad
 CREATE INDEX idx_logs_seguridad_evento_fecha ON logs_seguridad(evento, fecha);
@@ -1452,7 +2182,7 @@ CREATE OR REPLACE FUNCTION validar_stock_disponible_optimizado(
     p_cantidad NUMERIC
 ) RETURNS BOOLEAN AS $$
 DECLARE
-    v_stock_disponible INTEGER;
+    v_stock_disponible NUMERIC;
     v_version_lock INTEGER;
 BEGIN
     -- Consulta optimizada con lock para evitar condiciones de carrera
@@ -1483,7 +2213,7 @@ CREATE OR REPLACE FUNCTION descontar_stock_optimizado(
     p_usuario_id UUID
 ) RETURNS BOOLEAN AS $$
 DECLARE
-    v_stock_actual INTEGER;
+    v_stock_actual NUMERIC;
     v_version_actual INTEGER;
     v_filas_afectadas INTEGER;
 BEGIN
@@ -1541,7 +2271,7 @@ CREATE OR REPLACE FUNCTION buscar_producto_pos(
     codigo_barra TEXT,
     descripcion TEXT,
     precio_unitario NUMERIC,
-    stock_disponible INTEGER,
+    stock_disponible NUMERIC,
     score REAL
 ) AS $$
 BEGIN
@@ -1570,6 +2300,18 @@ BEGIN
         
         UNION ALL
         
+        -- Búsqueda por código de barras de presentaciones (caja, rollo)
+        SELECT p.id, p.codigo_interno, p.codigo_barra, p.descripcion,
+               p.precio_unitario, COALESCE(sc.cantidad_disponible, 0) as stock,
+               0.9::REAL as score
+        FROM productos p
+        JOIN unidades_medida_producto ump ON p.id = ump.producto_id
+        LEFT JOIN stock_central sc ON p.id = sc.producto_id AND sc.sucursal_id = p_sucursal_id
+        WHERE p.activo = true AND ump.activo = true
+          AND ump.codigo_barra = p_termino_busqueda
+        
+        UNION ALL
+        
         -- Búsqueda por texto en descripción
         SELECT p.id, p.codigo_interno, p.codigo_barra, p.descripcion,
                p.precio_unitario, COALESCE(sc.cantidad_disponible, 0) as stock,
@@ -1761,7 +2503,7 @@ BEGIN
 END;
 $$ LANGUAGE plpgsql;
 
--- Trigger optimizado para descuento de stock
+-- Trigger optimizado para descuento de stock (no aplicado, ver trg_descontar_stock_opt)
 CREATE OR REPLACE FUNCTION trigger_descontar_stock_optimizado()
 RETURNS TRIGGER AS $$
 DECLARE
@@ -1828,6 +2570,123 @@ BEGIN
 END;
 $$ LANGUAGE plpgsql;
 
+-- Registra una alerta de stock y la notifica a api_pos por el canal alertas_stock. Salvo los ajustes negativos,
+-- que se alertan uno a uno, no se repite una alerta del mismo tipo mientras la anterior siga sin resolver
+CREATE OR REPLACE FUNCTION registrar_alerta_stock(
+    p_movimiento movimientos_stock,
+    p_tipo TEXT,
+    p_umbral NUMERIC,
+    p_mensaje TEXT
+) RETURNS VOID AS $$
+DECLARE
+    v_alerta_id UUID;
+BEGIN
+    IF p_tipo <> 'ajuste_negativo' AND EXISTS (
+        SELECT 1 FROM alertas_stock
+        WHERE producto_id = p_movimiento.producto_id AND sucursal_id = p_movimiento.sucursal_id
+          AND tipo = p_tipo AND estado <> 'resuelta'
+    ) THEN
+        RETURN;
+    END IF;
+
+    INSERT INTO alertas_stock (
+        producto_id, sucursal_id, tipo, cantidad_actual, umbral, mensaje,
+        movimiento_id, tipo_movimiento, documento_referencia
+    ) VALUES (
+        p_movimiento.producto_id, p_movimiento.sucursal_id, p_tipo, p_movimiento.cantidad_nueva, p_umbral, p_mensaje,
+        p_movimiento.id, p_movimiento.tipo_movimiento, p_movimiento.documento_referencia
+    ) RETURNING id INTO v_alerta_id;
+
+    PERFORM pg_notify('alertas_stock', v_alerta_id::text);
+END;
+$$ LANGUAGE plpgsql;
+
+-- Trigger que evalúa los umbrales de alerta con cada movimiento de stock (ventas vía descontar_stock_optimizado,
+-- ajustes, transferencias y recepciones). La configuración del producto en la sucursal
+-- (stock_central.alertas_configuradas) se superpone a la de su categoría (alertas_stock_categorias). Claves:
+--   activas          false desactiva las alertas del producto
+--   stock_minimo     umbral de stock bajo; por defecto productos.stock_minimo
+--   sin_stock        alertar cuando el stock se agota; por defecto true
+--   ajuste_negativo  alertar cada ajuste negativo de al menos esta cantidad
+--   merma_maxima     alertar cuando los ajustes negativos de los últimos merma_dias (30) superan esta cantidad
+-- Las alertas de stock bajo y sin stock se resuelven solas cuando un movimiento repone sobre el umbral
+CREATE OR REPLACE FUNCTION evaluar_alertas_stock()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_config JSONB;
+    v_stock_minimo NUMERIC;
+    v_umbral NUMERIC;
+    v_merma NUMERIC;
+    v_alerta_id UUID;
+BEGIN
+    IF NEW.cantidad_anterior IS NULL OR NEW.cantidad_nueva IS NULL THEN
+        RETURN NEW;
+    END IF;
+
+    SELECT COALESCE(ac.configuracion, '{}'::jsonb) || COALESCE(sc.alertas_configuradas, '{}'::jsonb),
+           p.stock_minimo
+    INTO v_config, v_stock_minimo
+    FROM productos p
+    LEFT JOIN alertas_stock_categorias ac ON ac.categoria_id = p.categoria_id
+    LEFT JOIN stock_central sc ON sc.producto_id = p.id AND sc.sucursal_id = NEW.sucursal_id
+    WHERE p.id = NEW.producto_id;
+
+    IF NOT FOUND OR COALESCE((v_config ->> 'activas')::BOOLEAN, true) = false THEN
+        RETURN NEW;
+    END IF;
+
+    v_umbral := COALESCE((v_config ->> 'stock_minimo')::NUMERIC, v_stock_minimo, 0);
+
+    -- Stock bajo y sin stock se alertan al cruzar el umbral hacia abajo
+    IF NEW.cantidad_nueva <= 0 AND NEW.cantidad_anterior > 0
+       AND COALESCE((v_config ->> 'sin_stock')::BOOLEAN, true) THEN
+        PERFORM registrar_alerta_stock(NEW, 'sin_stock', 0,
+            format('Producto sin stock tras %s', NEW.tipo_movimiento));
+    ELSIF NEW.cantidad_nueva > 0 AND NEW.cantidad_nueva <= v_umbral AND NEW.cantidad_anterior > v_umbral THEN
+        PERFORM registrar_alerta_stock(NEW, 'stock_bajo', v_umbral,
+            format('Stock %s bajo el mínimo de %s', NEW.cantidad_nueva, v_umbral));
+    END IF;
+
+    IF NEW.cantidad_nueva > NEW.cantidad_anterior AND NEW.cantidad_nueva > 0 THEN
+        FOR v_alerta_id IN
+            UPDATE alertas_stock
+            SET estado = 'resuelta', fecha_resolucion = NOW(),
+                observaciones_resolucion = format('Stock repuesto por %s', NEW.tipo_movimiento)
+            WHERE producto_id = NEW.producto_id AND sucursal_id = NEW.sucursal_id AND estado <> 'resuelta'
+              AND (tipo = 'sin_stock' OR (tipo = 'stock_bajo' AND NEW.cantidad_nueva > v_umbral))
+            RETURNING id
+        LOOP
+            PERFORM pg_notify('alertas_stock', v_alerta_id::text);
+        END LOOP;
+    END IF;
+
+    IF NEW.tipo_movimiento = 'ajuste' AND NEW.cantidad < 0 THEN
+        IF v_config ? 'ajuste_negativo' AND -NEW.cantidad >= (v_config ->> 'ajuste_negativo')::NUMERIC THEN
+            PERFORM registrar_alerta_stock(NEW, 'ajuste_negativo', (v_config ->> 'ajuste_negativo')::NUMERIC,
+                format('Ajuste negativo de %s', -NEW.cantidad));
+        END IF;
+
+        -- La merma se alerta cuando el acumulado del período supera el máximo con este ajuste
+        IF v_config ? 'merma_maxima' THEN
+            SELECT COALESCE(SUM(-cantidad), 0) INTO v_merma
+            FROM movimientos_stock
+            WHERE producto_id = NEW.producto_id AND sucursal_id = NEW.sucursal_id
+              AND tipo_movimiento = 'ajuste' AND cantidad < 0
+              AND fecha >= NOW() - make_interval(days => COALESCE((v_config ->> 'merma_dias')::INTEGER, 30));
+
+            IF v_merma > (v_config ->> 'merma_maxima')::NUMERIC
+               AND v_merma + NEW.cantidad <= (v_config ->> 'merma_maxima')::NUMERIC THEN
+                PERFORM registrar_alerta_stock(NEW, 'merma_inusual', (v_config ->> 'merma_maxima')::NUMERIC,
+                    format('Merma de %s en los últimos %s días', v_merma,
+                        COALESCE((v_config ->> 'merma_dias')::INTEGER, 30)));
+            END IF;
+        END IF;
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
 -- Aplicar triggers optimizados
 CREATE TRIGGER trg_productos_fecha_mod_opt
     BEFORE UPDATE ON productos
@@ -1837,9 +2696,14 @@ CREATE TRIGGER trg_usuarios_fecha_mod_opt
     BEFORE UPDATE ON usuarios
     FOR EACH ROW EXECUTE FUNCTION actualizar_fecha_modificacion_optimizado();
 
-CREATE TRIGGER trg_descontar_stock_opt
-    AFTER INSERT ON detalle_ventas
-    FOR EACH ROW EXECUTE FUNCTION trigger_descontar_stock_optimizado();
+-- El descuento de stock por venta lo ejecuta api_pos dentro de la transacción de la venta
+-- (VentasHandler.Create llama a descontar_stock_optimizado por cada línea), por lo que
+-- trg_descontar_stock_opt ya no se aplica para evitar un doble descuento.
+DROP TRIGGER IF EXISTS trg_descontar_stock_opt ON detalle_ventas;
+
+CREATE TRIGGER trg_alertas_stock
+    AFTER INSERT ON movimientos_stock
+    FOR EACH ROW EXECUTE FUNCTION evaluar_alertas_stock();
 
 CREATE TRIGGER trg_popularidad_producto
     AFTER INSERT ON detalle_ventas
@@ -1878,6 +2742,7 @@ SELECT
     sc.cantidad,
     sc.cantidad_reservada,
     sc.cantidad_disponible,
+    sc.cantidad_en_transito,
     sc.costo_promedio,
     sc.fecha_ultima_entrada,
     sc.fecha_ultima_salida,
@@ -2193,6 +3058,26 @@ BEGIN
     SELECT limpiar_cache_reportes() INTO v_cache_limpiado;
     v_resultado := v_resultado || 'Cache reportes limpiado: ' || v_cache_limpiado || E'\n';
     
+    -- Limpiar claves de idempotencia de ventas expiradas
+    DELETE FROM idempotencia_ventas WHERE fecha_expiracion < NOW();
+    GET DIAGNOSTICS v_cache_limpiado = ROW_COUNT;
+    v_resultado := v_resultado || 'Claves de idempotencia limpiadas: ' || v_cache_limpiado || E'\n';
+    
+    -- Limpiar autorizaciones de descuento vencidas sin usar
+    DELETE FROM autorizaciones_descuento WHERE usada = false AND fecha_expiracion < NOW();
+    GET DIAGNOSTICS v_cache_limpiado = ROW_COUNT;
+    v_resultado := v_resultado || 'Autorizaciones de descuento limpiadas: ' || v_cache_limpiado || E'\n';
+    
+    -- Limpiar autorizaciones de reimpresión vencidas sin usar
+    DELETE FROM autorizaciones_reimpresion WHERE usada = false AND fecha_expiracion < NOW();
+    GET DIAGNOSTICS v_cache_limpiado = ROW_COUNT;
+    v_resultado := v_resultado || 'Autorizaciones de reimpresión limpiadas: ' || v_cache_limpiado || E'\n';
+    
+    -- Vencer cotizaciones fuera de su fecha de validez
+    UPDATE cotizaciones SET estado = 'vencida' WHERE estado = 'vigente' AND fecha_validez < NOW();
+    GET DIAGNOSTICS v_cache_limpiado = ROW_COUNT;
+    v_resultado := v_resultado || 'Cotizaciones vencidas: ' || v_cache_limpiado || E'\n';
+    
     -- Limpiar cache de códigos de barras expirados
     DELETE FROM etiquetas_cache_codigos_barras WHERE valido_hasta < NOW();
     GET DIAGNOSTICS v_cache_limpiado = ROW_COUNT;
@@ -2336,6 +3221,13 @@ INSERT INTO configuracion_sistema (clave, valor, tipo_dato, descripcion, categor
 ('mantenimiento.limpieza_logs_dias', '30', 'integer', 'Días de retención de logs', 'mantenimiento', true, 'minima', 3600),
 ('mantenimiento.limpieza_cache_horas', '6', 'integer', 'Intervalo para limpieza de cache', 'mantenimiento', true, 'minima', 3600);
 
+-- Políticas de descuento por rol (sobre este límite se requiere autorización de supervisor)
+INSERT INTO politicas_descuento (rol, porcentaje_maximo, monto_maximo) VALUES
+('cajero', 5.00, 20000.00),
+('vendedor', 10.00, 50000.00),
+('supervisor', 25.00, NULL),
+('admin', 100.00, NULL);
+
 -- Categorías de productos optimizadas con configuración para etiquetas
 INSERT INTO categorias_productos (codigo, nombre, descripcion, nivel, orden_visualizacion, configuracion_etiquetas) VALUES
 ('HERR', 'Herramientas', 'Herramientas manuales y eléctricas', 1, 1, '{"plantilla_default": "herramientas", "mostrar_marca": true, "mostrar_modelo": true}'),
@@ -2468,8 +3360,13 @@ GRANT CONNECT ON DATABASE postgres TO ferre_pos_api_pos;
 GRANT USAGE ON SCHEMA public TO ferre_pos_api_pos;
 GRANT SELECT, INSERT, UPDATE, DELETE ON usuarios, productos, stock_central, ventas, detalle_ventas, 
       medios_pago_venta, notas_venta, detalle_notas_venta, fidelizacion_clientes, 
-      movimientos_fidelizacion, sesiones_usuario, terminales, sucursales TO ferre_pos_api_pos;
-GRANT SELECT ON categorias_productos, codigos_barra_adicionales, reglas_fidelizacion, 
+      movimientos_fidelizacion, reglas_fidelizacion, despachos, detalle_despacho, incidencias_despacho,
+      ubicaciones_producto, listas_picking, reservas_stock, transferencias_stock,
+      detalle_transferencias_stock, conteos_inventario, detalle_conteo_inventario, lecturas_conteo_inventario,
+      proveedores, productos_proveedores, ordenes_compra, detalle_ordenes_compra, recepciones_compra, detalle_recepciones_compra,
+      pagos_proveedores, etiquetas_trabajos_impresion, alertas_stock, alertas_stock_categorias,
+      series_productos, lotes_stock, sesiones_usuario, terminales, sucursales TO ferre_pos_api_pos;
+GRANT SELECT ON categorias_productos, codigos_barra_adicionales, 
       configuracion_sistema TO ferre_pos_api_pos;
 GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA public TO ferre_pos_api_pos;
 GRANT SELECT ON ALL TABLES IN SCHEMA public TO ferre_pos_api_pos; -- Para vistas
@@ -156,6 +156,7 @@ func setupRoutes(
 	templatesHandler := handlers.NewTemplatesHandler(db, log, validator, metrics)
 	printHandler := handlers.NewPrintHandler(db, log, validator, metrics)
 	barcodeHandler := handlers.NewBarcodeHandler(db, log, validator, metrics)
+	unidadesMedidaHandler := handlers.NewUnidadesMedidaHandler(db, log, validator, metrics)
 
 	// Rutas de salud y métricas
 	router.GET("/health", handlers.HealthCheck(db, log))
@@ -268,6 +269,12 @@ func setupRoutes(
 				barcodes.POST("/bulk-validate", barcodeHandler.BulkValidateBarcodes)
 			}
 
+			// Presentaciones de productos (precio y código por unidad de medida)
+			productos := protected.Group("/productos")
+			{
+				productos.GET("/:id/unidades", unidadesMedidaHandler.List)
+			}
+
 			// Rutas de configuración de etiquetas
 			config := protected.Group("/config")
 			{
@@ -116,6 +116,15 @@ func main() {
 		metricsInstance.SetBuildInfo(apiName, "1.0.0", "dev", time.Now().Format("2006-01-02"))
 	}
 
+	// Iniciar vencimiento de notas de venta en background
+	handlers.NewNotasVentaHandler(db, log, validatorInstance, metricsInstance).StartExpiracionNotas(time.Minute)
+
+	// Iniciar liberación de reservas de stock vencidas en background
+	handlers.NewStockHandler(db, log, validatorInstance, metricsInstance).StartExpiracionReservas(time.Minute)
+
+	// Iniciar vencimiento de puntos y recálculo de niveles de fidelización en background
+	handlers.NewFidelizacionHandler(db, log, validatorInstance, metricsInstance).StartMantencionFidelizacion(time.Hour)
+
 	// Iniciar servidor en goroutine
 	go func() {
 		log.WithField("address", server.Addr).Info("Servidor API POS iniciado")
@@ -157,6 +166,26 @@ func setupRoutes(
 	ventasHandler := handlers.NewVentasHandler(db, log, validator, metrics)
 	stockHandler := handlers.NewStockHandler(db, log, validator, metrics)
 	usuariosHandler := handlers.NewUsuariosHandler(db, log, validator)
+	notasVentaHandler := handlers.NewNotasVentaHandler(db, log, validator, metrics)
+	cajaHandler := handlers.NewCajaHandler(db, log, validator, metrics)
+	descuentosHandler := handlers.NewDescuentosHandler(db, log, validator, metrics)
+	promocionesHandler := handlers.NewPromocionesHandler(db, log, validator, metrics)
+	cotizacionesHandler := handlers.NewCotizacionesHandler(db, log, validator, metrics)
+	cuentasCorrientesHandler := handlers.NewCuentasCorrientesHandler(db, log, validator, metrics)
+	unidadesMedidaHandler := handlers.NewUnidadesMedidaHandler(db, log, validator, metrics)
+	trazabilidadHandler := handlers.NewTrazabilidadHandler(db, log, validator, metrics)
+	notasCreditoHandler := handlers.NewNotasCreditoHandler(db, log, validator, metrics)
+	reimpresionesHandler := handlers.NewReimpresionesHandler(db, log, validator, metrics, cfg)
+	fidelizacionHandler := handlers.NewFidelizacionHandler(db, log, validator, metrics)
+	reglasFidelizacionHandler := handlers.NewReglasFidelizacionHandler(db, log, validator, metrics)
+	despachosHandler := handlers.NewDespachosHandler(db, log, validator, metrics)
+	pickingHandler := handlers.NewPickingHandler(db, log, validator, metrics)
+	transferenciasHandler := handlers.NewTransferenciasHandler(db, log, validator, metrics)
+	conteosInventarioHandler := handlers.NewConteosInventarioHandler(db, log, validator, metrics)
+	comprasHandler := handlers.NewComprasHandler(db, log, validator, metrics)
+
+	// Difundir a los clientes del stream de alertas las alertas de stock notificadas por la base de datos
+	stockHandler.StartDifusionAlertas()
 
 	// Rutas de salud y métricas
 	router.GET("/health", handlers.HealthCheck(db, log))
@@ -191,6 +220,8 @@ func setupRoutes(
 				productos.POST("", middleware.RequireRole("admin", "supervisor"), productosHandler.Create)
 				productos.PUT("/:id", middleware.RequireRole("admin", "supervisor"), productosHandler.Update)
 				productos.DELETE("/:id", middleware.RequireRole("admin"), productosHandler.Delete)
+				productos.GET("/:id/unidades", unidadesMedidaHandler.List)
+				productos.PUT("/:id/unidades", middleware.RequireRole("admin", "supervisor"), unidadesMedidaHandler.Update)
 			}
 
 			// Rutas de stock
@@ -200,13 +231,26 @@ func setupRoutes(
 				stock.GET("/sucursal/:sucursal_id", stockHandler.GetBySucursal)
 				stock.POST("/reservar", stockHandler.ReservarStock)
 				stock.POST("/liberar", stockHandler.LiberarStock)
+				stock.GET("/reservas/producto/:producto_id", stockHandler.ListReservasProducto)
 				stock.GET("/alertas", stockHandler.GetAlertas)
+				stock.GET("/alertas/stream", middleware.RequireRole("admin", "supervisor"), stockHandler.StreamAlertas)
+				stock.POST("/alertas/:id/reconocer", middleware.RequireRole("admin", "supervisor"), stockHandler.ReconocerAlerta)
+				stock.POST("/alertas/:id/resolver", middleware.RequireRole("admin", "supervisor"), stockHandler.ResolverAlerta)
+				stock.GET("/alertas/configuracion/producto/:producto_id", stockHandler.GetConfiguracionAlertas)
+				stock.PUT("/alertas/configuracion/producto/:producto_id", middleware.RequireRole("admin", "supervisor"), stockHandler.ConfigurarAlertasProducto)
+				stock.PUT("/alertas/configuracion/categoria/:categoria_id", middleware.RequireRole("admin", "supervisor"), stockHandler.ConfigurarAlertasCategoria)
+				stock.POST("/entradas", middleware.RequireRole("admin", "supervisor", "despacho"), trazabilidadHandler.RegistrarEntrada)
+				stock.GET("/series", trazabilidadHandler.ListSeries)
+				stock.GET("/series/:numero/garantia", trazabilidadHandler.GetGarantia)
+				stock.GET("/lotes", trazabilidadHandler.ListLotes)
+				stock.GET("/lotes/fefo", trazabilidadHandler.SugerirFEFO)
 			}
 
 			// Rutas de ventas
 			ventas := protected.Group("/ventas")
 			{
 				ventas.POST("", ventasHandler.Create)
+				ventas.POST("/preview", ventasHandler.Preview)
 				ventas.GET("/:id", ventasHandler.GetByID)
 				ventas.PUT("/:id/anular", middleware.RequireRole("supervisor", "admin"), ventasHandler.Anular)
 				ventas.GET("", ventasHandler.List)
@@ -214,6 +258,196 @@ func setupRoutes(
 				ventas.POST("/:id/dte", ventasHandler.GenerarDTE)
 			}
 
+			// Rutas de devoluciones con nota de crédito
+			notasCredito := protected.Group("/notas-credito")
+			{
+				notasCredito.POST("", notasCreditoHandler.Create)
+				notasCredito.GET("", notasCreditoHandler.List)
+				notasCredito.GET("/:id", notasCreditoHandler.GetByID)
+				notasCredito.GET("/ventas/:venta_id", notasCreditoHandler.GetVentaDevolvible)
+				notasCredito.PUT("/:id/autorizar", middleware.RequireRole("supervisor", "admin"), notasCreditoHandler.Autorizar)
+				notasCredito.PUT("/:id/rechazar", middleware.RequireRole("supervisor", "admin"), notasCreditoHandler.Rechazar)
+			}
+
+			// Rutas de reimpresión controlada de documentos
+			reimpresiones := protected.Group("/reimpresiones")
+			{
+				reimpresiones.POST("", reimpresionesHandler.Reimprimir)
+				reimpresiones.GET("", middleware.RequireRole("supervisor", "admin"), reimpresionesHandler.List)
+				reimpresiones.POST("/autorizaciones", middleware.RequireRole("supervisor", "admin"), reimpresionesHandler.CrearAutorizacion)
+			}
+
+			// Rutas de despachos (preparación y entrega en bodega)
+			despachos := protected.Group("/despachos")
+			despachos.Use(middleware.RequireRole("despacho", "supervisor", "admin"))
+			{
+				despachos.GET("", despachosHandler.List)
+				despachos.GET("/:id", despachosHandler.GetByID)
+				despachos.GET("/:id/comprobante", despachosHandler.GetComprobante)
+				despachos.POST("/:id/iniciar", despachosHandler.Iniciar)
+				despachos.POST("/:id/escanear", despachosHandler.Escanear)
+				despachos.PUT("/:id/lineas/:linea_id", despachosHandler.ActualizarLinea)
+				despachos.POST("/:id/incidencias", despachosHandler.RegistrarIncidencia)
+				despachos.POST("/:id/firma", despachosHandler.RegistrarFirma)
+				despachos.POST("/:id/completar", despachosHandler.Completar)
+				despachos.POST("/:id/rechazar", despachosHandler.Rechazar)
+			}
+
+			// Rutas de picking (ubicaciones de bodega y recolección agrupada de despachos)
+			picking := protected.Group("/picking")
+			picking.Use(middleware.RequireRole("despacho", "supervisor", "admin"))
+			{
+				picking.GET("/ubicaciones", pickingHandler.ListUbicaciones)
+				picking.PUT("/ubicaciones", middleware.RequireRole("supervisor", "admin"), pickingHandler.GuardarUbicaciones)
+				picking.DELETE("/ubicaciones/:id", middleware.RequireRole("supervisor", "admin"), pickingHandler.DeleteUbicacion)
+				picking.GET("/listas", pickingHandler.ListListas)
+				picking.POST("/listas", pickingHandler.CrearLista)
+				picking.GET("/listas/:id", pickingHandler.GetLista)
+				picking.POST("/listas/:id/iniciar", pickingHandler.IniciarLista)
+				picking.POST("/listas/:id/completar", pickingHandler.CompletarLista)
+				picking.POST("/listas/:id/cancelar", pickingHandler.CancelarLista)
+				picking.GET("/rendimiento", middleware.RequireRole("supervisor", "admin"), pickingHandler.GetRendimiento)
+			}
+
+			// Rutas de transferencias de stock entre sucursales
+			transferencias := protected.Group("/transferencias")
+			transferencias.Use(middleware.RequireRole("despacho", "supervisor", "admin"))
+			{
+				transferencias.GET("", transferenciasHandler.List)
+				transferencias.POST("", transferenciasHandler.Create)
+				transferencias.GET("/:id", transferenciasHandler.GetByID)
+				transferencias.POST("/:id/aprobar", middleware.RequireRole("supervisor", "admin"), transferenciasHandler.Aprobar)
+				transferencias.POST("/:id/rechazar", middleware.RequireRole("supervisor", "admin"), transferenciasHandler.Rechazar)
+				transferencias.POST("/:id/cancelar", transferenciasHandler.Cancelar)
+				transferencias.POST("/:id/despachar", transferenciasHandler.Despachar)
+				transferencias.POST("/:id/recibir", transferenciasHandler.Recibir)
+			}
+
+			// Rutas de conteos de inventario físico
+			conteos := protected.Group("/inventario/conteos")
+			conteos.Use(middleware.RequireRole("despacho", "supervisor", "admin"))
+			{
+				conteos.GET("", conteosInventarioHandler.List)
+				conteos.POST("", middleware.RequireRole("supervisor", "admin"), conteosInventarioHandler.Create)
+				conteos.GET("/:id", conteosInventarioHandler.GetByID)
+				conteos.GET("/:id/diferencias", conteosInventarioHandler.GetDiferencias)
+				conteos.POST("/:id/lecturas", conteosInventarioHandler.RegistrarLecturas)
+				conteos.POST("/:id/reconteos", middleware.RequireRole("supervisor", "admin"), conteosInventarioHandler.SolicitarReconteo)
+				conteos.POST("/:id/cerrar", conteosInventarioHandler.Cerrar)
+				conteos.POST("/:id/aprobar", middleware.RequireRole("supervisor", "admin"), conteosInventarioHandler.Aprobar)
+				conteos.POST("/:id/cancelar", middleware.RequireRole("supervisor", "admin"), conteosInventarioHandler.Cancelar)
+			}
+
+			// Rutas de compras: proveedores, órdenes de compra y recepción de mercadería
+			compras := protected.Group("/compras")
+			compras.Use(middleware.RequireRole("despacho", "supervisor", "admin"))
+			{
+				compras.GET("/proveedores", comprasHandler.ListProveedores)
+				compras.POST("/proveedores", middleware.RequireRole("supervisor", "admin"), comprasHandler.CreateProveedor)
+				compras.GET("/proveedores/:id", comprasHandler.GetProveedor)
+				compras.PUT("/proveedores/:id", middleware.RequireRole("supervisor", "admin"), comprasHandler.UpdateProveedor)
+				compras.GET("/proveedores/:id/productos", comprasHandler.ListProductosProveedor)
+				compras.PUT("/proveedores/:id/productos", middleware.RequireRole("supervisor", "admin"), comprasHandler.GuardarProductosProveedor)
+
+				compras.GET("/ordenes", comprasHandler.ListOrdenes)
+				compras.POST("/ordenes", middleware.RequireRole("supervisor", "admin"), comprasHandler.CreateOrden)
+				compras.GET("/ordenes/:id", comprasHandler.GetOrden)
+				compras.PUT("/ordenes/:id", middleware.RequireRole("supervisor", "admin"), comprasHandler.UpdateOrden)
+				compras.POST("/ordenes/:id/emitir", middleware.RequireRole("supervisor", "admin"), comprasHandler.EmitirOrden)
+				compras.POST("/ordenes/:id/cancelar", middleware.RequireRole("supervisor", "admin"), comprasHandler.CancelarOrden)
+				compras.POST("/ordenes/sugeridas", middleware.RequireRole("supervisor", "admin"), comprasHandler.GenerarOrdenesSugeridas)
+
+				compras.GET("/recepciones", comprasHandler.ListRecepciones)
+				compras.POST("/recepciones", comprasHandler.CreateRecepcion)
+				compras.GET("/recepciones/:id", comprasHandler.GetRecepcion)
+				compras.POST("/recepciones/:id/pagos", middleware.RequireRole("supervisor", "admin"), comprasHandler.RegistrarPago)
+			}
+
+			// Rutas de notas de venta (POS Tienda -> Caja)
+			notasVenta := protected.Group("/notas-venta")
+			{
+				notasVenta.POST("", notasVentaHandler.Create)
+				notasVenta.GET("", notasVentaHandler.List)
+				notasVenta.GET("/:id", notasVentaHandler.GetByID)
+				notasVenta.GET("/numero/:numero", notasVentaHandler.GetByNumero)
+				notasVenta.GET("/qr/:codigo", notasVentaHandler.GetByQR)
+				notasVenta.POST("/:id/convertir", notasVentaHandler.Convertir)
+			}
+
+			// Rutas de cotizaciones (presupuestos a contratistas)
+			cotizaciones := protected.Group("/cotizaciones")
+			{
+				cotizaciones.POST("", cotizacionesHandler.Create)
+				cotizaciones.GET("", cotizacionesHandler.List)
+				cotizaciones.GET("/:id", cotizacionesHandler.GetByID)
+				cotizaciones.GET("/:id/pdf", cotizacionesHandler.GetPDF)
+				cotizaciones.POST("/:id/convertir", cotizacionesHandler.Convertir)
+			}
+
+			// Rutas de cuentas corrientes (crédito a clientes empresa)
+			cuentasCorrientes := protected.Group("/cuentas-corrientes")
+			{
+				cuentasCorrientes.GET("", cuentasCorrientesHandler.List)
+				cuentasCorrientes.GET("/:id", cuentasCorrientesHandler.GetByID)
+				cuentasCorrientes.GET("/rut/:rut", cuentasCorrientesHandler.GetByRUT)
+				cuentasCorrientes.GET("/:id/estado-cuenta", cuentasCorrientesHandler.GetEstadoCuenta)
+				cuentasCorrientes.POST("/:id/pagos", cuentasCorrientesHandler.RegistrarPago)
+				cuentasCorrientes.POST("", middleware.RequireRole("admin", "supervisor"), cuentasCorrientesHandler.Create)
+				cuentasCorrientes.PUT("/:id", middleware.RequireRole("admin", "supervisor"), cuentasCorrientesHandler.Update)
+			}
+
+			// Rutas del programa de fidelización
+			fidelizacion := protected.Group("/fidelizacion/clientes")
+			{
+				fidelizacion.POST("", fidelizacionHandler.Create)
+				fidelizacion.GET("", fidelizacionHandler.List)
+				fidelizacion.GET("/:id", fidelizacionHandler.GetByID)
+				fidelizacion.GET("/rut/:rut", fidelizacionHandler.GetByRUT)
+				fidelizacion.GET("/qr/:qr", fidelizacionHandler.GetByQR)
+				fidelizacion.GET("/:id/movimientos", fidelizacionHandler.GetHistorial)
+				fidelizacion.PUT("/:id", fidelizacionHandler.Update)
+			}
+
+			// Rutas de reglas de acumulación, canje, promoción y nivel del programa de fidelización
+			reglasFidelizacion := protected.Group("/fidelizacion/reglas")
+			{
+				reglasFidelizacion.GET("", reglasFidelizacionHandler.List)
+				reglasFidelizacion.GET("/:id", reglasFidelizacionHandler.GetByID)
+				reglasFidelizacion.POST("", middleware.RequireRole("admin", "supervisor"), reglasFidelizacionHandler.Create)
+				reglasFidelizacion.POST("/recompilar", middleware.RequireRole("admin"), reglasFidelizacionHandler.Recompilar)
+				reglasFidelizacion.PUT("/:id", middleware.RequireRole("admin", "supervisor"), reglasFidelizacionHandler.Update)
+				reglasFidelizacion.DELETE("/:id", middleware.RequireRole("admin"), reglasFidelizacionHandler.Delete)
+			}
+			protected.POST("/fidelizacion/mantencion", middleware.RequireRole("admin"), fidelizacionHandler.EjecutarMantencion)
+
+			// Rutas de promociones
+			promociones := protected.Group("/promociones")
+			{
+				promociones.GET("", promocionesHandler.List)
+				promociones.GET("/:id", promocionesHandler.GetByID)
+				promociones.POST("", middleware.RequireRole("admin", "supervisor"), promocionesHandler.Create)
+				promociones.PUT("/:id", middleware.RequireRole("admin", "supervisor"), promocionesHandler.Update)
+				promociones.DELETE("/:id", middleware.RequireRole("admin"), promocionesHandler.Delete)
+			}
+
+			// Rutas de políticas y autorizaciones de descuento
+			descuentos := protected.Group("/descuentos")
+			{
+				descuentos.GET("/politicas", middleware.RequireRole("supervisor", "admin"), descuentosHandler.ListPoliticas)
+				descuentos.PUT("/politicas", middleware.RequireRole("admin"), descuentosHandler.GuardarPolitica)
+				descuentos.POST("/autorizaciones", middleware.RequireRole("supervisor", "admin"), descuentosHandler.CrearAutorizacion)
+			}
+
+			// Rutas de sesiones de caja
+			caja := protected.Group("/caja")
+			{
+				caja.POST("/sesiones", cajaHandler.Abrir)
+				caja.GET("/sesiones/actual", cajaHandler.GetActual)
+				caja.POST("/sesiones/:id/movimientos", cajaHandler.RegistrarMovimiento)
+				caja.POST("/sesiones/:id/cerrar", cajaHandler.Cerrar)
+				caja.GET("/sesiones/:id/reporte-z", middleware.RequireRole("supervisor", "admin"), cajaHandler.GetReporteZ)
+			}
+
 			// Rutas de usuarios
 			usuarios := protected.Group("/usuarios")
 			{
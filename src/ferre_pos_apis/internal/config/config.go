@@ -90,6 +90,7 @@ type APIConfig struct {
 	Retry            RetryConfig            `mapstructure:"retry"`
 	LabelGeneration  LabelGenerationConfig  `mapstructure:"label_generation"`
 	ReportGeneration ReportGenerationConfig `mapstructure:"report_generation"`
+	Reprints         ReprintConfig          `mapstructure:"reprints"`
 }
 
 // CacheConfig configuración de cache
@@ -136,6 +137,11 @@ type ReportGenerationConfig struct {
 	MaxRowsPerReport     int      `mapstructure:"max_rows_per_report"`
 }
 
+// ReprintConfig configuración del control de reimpresión de documentos
+type ReprintConfig struct {
+	FreeReprints *int `mapstructure:"free_reprints"` // Reimpresiones sin autorización de supervisor por documento; 0 exige autorización siempre
+}
+
 // SecurityConfig configuración de seguridad
 type SecurityConfig struct {
 	CORS       CORSConfig       `mapstructure:"cors"`
@@ -36,10 +36,7 @@ var globalDB *Database
 
 // Init inicializa la conexión a la base de datos
 func Init(cfg *config.DatabaseConfig, log logger.Logger) (*Database, error) {
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Name, cfg.SSLMode)
-
-	db, err := sql.Open("postgres", dsn)
+	db, err := sql.Open("postgres", dsnConfig(cfg))
 	if err != nil {
 		return nil, fmt.Errorf("error abriendo conexión a base de datos: %w", err)
 	}
@@ -71,6 +68,17 @@ func Init(cfg *config.DatabaseConfig, log logger.Logger) (*Database, error) {
 	return database, nil
 }
 
+// dsnConfig construye el DSN de conexión a partir de la configuración
+func dsnConfig(cfg *config.DatabaseConfig) string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Name, cfg.SSLMode)
+}
+
+// NewListener crea un listener de LISTEN/NOTIFY con conexión propia fuera del pool, que se reconecta solo
+func (d *Database) NewListener(eventos pq.EventCallbackType) *pq.Listener {
+	return pq.NewListener(dsnConfig(d.config), 10*time.Second, time.Minute, eventos)
+}
+
 // Get obtiene la instancia global de base de datos
 func Get() *Database {
 	if globalDB == nil {
@@ -171,7 +179,7 @@ func (d *Database) logQuery(operation, query string, args []interface{}, duratio
 }
 
 // Transaction helper para ejecutar operaciones en transacción
-func (d *Database) Transaction(ctx context.Context, fn func(*sql.Tx) error) error {
+func (d *Database) Transaction(ctx context.Context, fn func(*sql.Tx) error) (err error) {
 	tx, err := d.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("error iniciando transacción: %w", err)
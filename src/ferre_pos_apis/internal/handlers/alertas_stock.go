@@ -0,0 +1,635 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/models"
+)
+
+const (
+	// canalAlertasStock canal de NOTIFY por el que evaluar_alertas_stock informa alertas nuevas o resueltas
+	canalAlertasStock = "alertas_stock"
+
+	// intervaloKeepAliveAlertas cada cuánto se envía un ping a los clientes SSE sin alertas para mantener la conexión
+	intervaloKeepAliveAlertas = 30 * time.Second
+
+	// alertasPendientesPorCliente alertas en cola por cliente SSE; si se llena, el cliente lento pierde alertas
+	alertasPendientesPorCliente = 32
+)
+
+// difusorAlertas reparte las alertas de stock entre los clientes SSE conectados según su sucursal
+type difusorAlertas struct {
+	mu           sync.Mutex
+	suscriptores map[chan models.AlertaStock]uuid.UUID
+}
+
+// newDifusorAlertas crea un difusor sin clientes
+func newDifusorAlertas() *difusorAlertas {
+	return &difusorAlertas{suscriptores: make(map[chan models.AlertaStock]uuid.UUID)}
+}
+
+// suscribir registra un cliente de la sucursal
+func (d *difusorAlertas) suscribir(sucursalID uuid.UUID) chan models.AlertaStock {
+	ch := make(chan models.AlertaStock, alertasPendientesPorCliente)
+	d.mu.Lock()
+	d.suscriptores[ch] = sucursalID
+	d.mu.Unlock()
+	return ch
+}
+
+// cancelar elimina el cliente
+func (d *difusorAlertas) cancelar(ch chan models.AlertaStock) {
+	d.mu.Lock()
+	delete(d.suscriptores, ch)
+	d.mu.Unlock()
+}
+
+// publicar envía la alerta a los clientes de su sucursal sin bloquearse por clientes lentos
+func (d *difusorAlertas) publicar(alerta models.AlertaStock) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for ch, sucursalID := range d.suscriptores {
+		if sucursalID != alerta.SucursalID {
+			continue
+		}
+		select {
+		case ch <- alerta:
+		default:
+		}
+	}
+}
+
+// GetAlertas lista las alertas de stock de la sucursal. Por defecto solo las no resueltas
+func (h *StockHandler) GetAlertas(c *gin.Context) {
+	sucursalID, ok := sucursalIDQuery(c)
+	if !ok {
+		return
+	}
+
+	filtro := `WHERE a.sucursal_id = $1`
+	args := []interface{}{sucursalID}
+	if estado := c.Query("estado"); estado != "" {
+		args = append(args, estado)
+		filtro += ` AND a.estado = $` + strconv.Itoa(len(args))
+	} else {
+		filtro += ` AND a.estado <> 'resuelta'`
+	}
+	if tipo := c.Query("tipo"); tipo != "" {
+		args = append(args, tipo)
+		filtro += ` AND a.tipo = $` + strconv.Itoa(len(args))
+	}
+	if productoID := c.Query("producto_id"); productoID != "" {
+		if _, err := uuid.Parse(productoID); err == nil {
+			args = append(args, productoID)
+			filtro += ` AND a.producto_id = $` + strconv.Itoa(len(args))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	alertas, err := listarAlertasStock(ctx, h.db, filtro+` ORDER BY a.fecha_creacion DESC LIMIT 200`, args...)
+	if err != nil {
+		h.responderError(c, err, "Error consultando alertas de stock")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      alertas,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// StreamAlertas envía por Server-Sent Events las alertas de la sucursal a medida que se generan o cambian de
+// estado. Los clientes cargan las alertas abiertas con GetAlertas y luego se mantienen al día con este stream.
+// Sin sucursal_id se usa la sucursal del usuario; solo un admin puede seguir otra sucursal
+func (h *StockHandler) StreamAlertas(c *gin.Context) {
+	sucursalID, ok := sucursalIDQuery(c)
+	if !ok {
+		return
+	}
+	if getUserRole(c) != string(models.RolAdmin) && sucursalID.String() != getUserSucursalID(c) {
+		c.JSON(http.StatusForbidden, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "SUCURSAL_NO_AUTORIZADA",
+				Message: "Solo puede seguir las alertas de su sucursal",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	// La conexión dura más que el WriteTimeout del servidor
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		h.logger.WithError(err).Warn("No se pudo quitar el timeout de escritura del stream de alertas")
+	}
+
+	ch := h.alertas.suscribir(sucursalID)
+	defer h.alertas.cancelar(ch)
+
+	h.logger.WithField("sucursal_id", sucursalID).
+		WithField("usuario_id", getUserID(c)).
+		Info("Cliente conectado al stream de alertas de stock")
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	keepAlive := time.NewTicker(intervaloKeepAliveAlertas)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case alerta := <-ch:
+			c.SSEvent("alerta_stock", alerta)
+			return true
+		case t := <-keepAlive.C:
+			c.SSEvent("ping", t)
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// ReconocerAlerta marca que un supervisor tomó conocimiento de la alerta
+func (h *StockHandler) ReconocerAlerta(c *gin.Context) {
+	h.modificarAlerta(c, "Error reconociendo alerta de stock", func(ctx context.Context, tx *sql.Tx, alerta *models.AlertaStock, usuarioID uuid.UUID) error {
+		if alerta.Estado != "activa" {
+			return errAlertaNoModificable(alerta)
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			UPDATE alertas_stock
+			SET estado = 'reconocida', usuario_reconocimiento_id = $2, fecha_reconocimiento = NOW()
+			WHERE id = $1`,
+			alerta.ID, usuarioID,
+		)
+		if err != nil {
+			return fmt.Errorf("error reconociendo alerta: %w", err)
+		}
+		return nil
+	})
+}
+
+// ResolverAlerta cierra la alerta con las observaciones de lo realizado
+func (h *StockHandler) ResolverAlerta(c *gin.Context) {
+	var req models.ResolverAlertaStockRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	h.modificarAlerta(c, "Error resolviendo alerta de stock", func(ctx context.Context, tx *sql.Tx, alerta *models.AlertaStock, usuarioID uuid.UUID) error {
+		if alerta.Estado == "resuelta" {
+			return errAlertaNoModificable(alerta)
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			UPDATE alertas_stock
+			SET estado = 'resuelta', usuario_resolucion_id = $2, fecha_resolucion = NOW(),
+				observaciones_resolucion = $3,
+				usuario_reconocimiento_id = COALESCE(usuario_reconocimiento_id, $2),
+				fecha_reconocimiento = COALESCE(fecha_reconocimiento, NOW())
+			WHERE id = $1`,
+			alerta.ID, usuarioID, req.Observaciones,
+		)
+		if err != nil {
+			return fmt.Errorf("error resolviendo alerta: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetConfiguracionAlertas obtiene los umbrales de alerta del producto en la sucursal, los de su categoría y
+// los efectivos resultantes de superponerlos
+func (h *StockHandler) GetConfiguracionAlertas(c *gin.Context) {
+	productoID, ok := uuidParam(c, "producto_id", "INVALID_PRODUCT_ID", "ID de producto inválido")
+	if !ok {
+		return
+	}
+	sucursalID, ok := sucursalIDQuery(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var producto, categoria []byte
+	var stockMinimo float64
+	err := h.db.QueryRowContext(ctx, `
+		SELECT sc.alertas_configuradas, ac.configuracion, COALESCE(p.stock_minimo, 0)
+		FROM productos p
+		LEFT JOIN alertas_stock_categorias ac ON ac.categoria_id = p.categoria_id
+		LEFT JOIN stock_central sc ON sc.producto_id = p.id AND sc.sucursal_id = $2
+		WHERE p.id = $1`,
+		productoID, sucursalID,
+	).Scan(&producto, &categoria, &stockMinimo)
+	if err == sql.ErrNoRows {
+		err = &apiError{
+			status:  http.StatusNotFound,
+			code:    "PRODUCT_NOT_FOUND",
+			message: "Producto no encontrado",
+			details: models.JSONB{"producto_id": productoID},
+		}
+	}
+	if err != nil {
+		h.responderError(c, err, "Error consultando configuración de alertas")
+		return
+	}
+
+	var configProducto, configCategoria models.ConfiguracionAlertasStock
+	for _, cfg := range []struct {
+		datos   []byte
+		destino *models.ConfiguracionAlertasStock
+	}{{producto, &configProducto}, {categoria, &configCategoria}} {
+		if len(cfg.datos) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(cfg.datos, cfg.destino); err != nil {
+			h.responderError(c, fmt.Errorf("error leyendo configuración de alertas: %w", err), "Error consultando configuración de alertas")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"producto_id": productoID,
+			"sucursal_id": sucursalID,
+			"producto":    configProducto,
+			"categoria":   configCategoria,
+			"efectiva":    configuracionAlertasEfectiva(configCategoria, configProducto, stockMinimo),
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// ConfigurarAlertasProducto reemplaza los umbrales de alerta del producto en la sucursal
+func (h *StockHandler) ConfigurarAlertasProducto(c *gin.Context) {
+	productoID, ok := uuidParam(c, "producto_id", "INVALID_PRODUCT_ID", "ID de producto inválido")
+	if !ok {
+		return
+	}
+
+	var req models.AlertasProductoRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	configuracion, err := json.Marshal(req.Configuracion)
+	if err != nil {
+		h.responderError(c, err, "Error guardando configuración de alertas")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		var existe bool
+		if err := tx.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM productos WHERE id = $1)`, productoID,
+		).Scan(&existe); err != nil {
+			return fmt.Errorf("error consultando producto: %w", err)
+		}
+		if !existe {
+			return &apiError{
+				status:  http.StatusNotFound,
+				code:    "PRODUCT_NOT_FOUND",
+				message: "Producto no encontrado",
+				details: models.JSONB{"producto_id": productoID},
+			}
+		}
+
+		// Sin claves vuelve a usar la configuración de la categoría
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO stock_central (producto_id, sucursal_id, alertas_configuradas)
+			VALUES ($1, $2, NULLIF($3::jsonb, '{}'::jsonb))
+			ON CONFLICT (producto_id, sucursal_id) DO UPDATE
+			SET alertas_configuradas = EXCLUDED.alertas_configuradas`,
+			productoID, req.SucursalID, string(configuracion),
+		)
+		if err != nil {
+			return fmt.Errorf("error guardando configuración de alertas: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error guardando configuración de alertas")
+		return
+	}
+
+	h.logger.WithField("producto_id", productoID).
+		WithField("sucursal_id", req.SucursalID).
+		Info("Configuración de alertas de stock del producto actualizada")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"producto_id":   productoID,
+			"sucursal_id":   req.SucursalID,
+			"configuracion": req.Configuracion,
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// ConfigurarAlertasCategoria reemplaza los umbrales de alerta por defecto de los productos de la categoría
+func (h *StockHandler) ConfigurarAlertasCategoria(c *gin.Context) {
+	categoriaID, ok := uuidParam(c, "categoria_id", "INVALID_CATEGORIA_ID", "ID de categoría inválido")
+	if !ok {
+		return
+	}
+
+	var req models.ConfiguracionAlertasStock
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	configuracion, err := json.Marshal(req)
+	if err != nil {
+		h.responderError(c, err, "Error guardando configuración de alertas")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var existe bool
+	err = h.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM categorias_productos WHERE id = $1)`, categoriaID,
+	).Scan(&existe)
+	if err == nil && !existe {
+		err = &apiError{
+			status:  http.StatusNotFound,
+			code:    "CATEGORIA_NOT_FOUND",
+			message: "Categoría no encontrada",
+			details: models.JSONB{"categoria_id": categoriaID},
+		}
+	}
+	if err == nil {
+		_, err = h.db.ExecContext(ctx, `
+			INSERT INTO alertas_stock_categorias (categoria_id, configuracion, usuario_modificacion)
+			VALUES ($1, $2::jsonb, $3)
+			ON CONFLICT (categoria_id) DO UPDATE
+			SET configuracion = EXCLUDED.configuracion, usuario_modificacion = EXCLUDED.usuario_modificacion,
+				fecha_modificacion = NOW()`,
+			categoriaID, string(configuracion), usuarioID,
+		)
+	}
+	if err != nil {
+		h.responderError(c, err, "Error guardando configuración de alertas")
+		return
+	}
+
+	h.logger.WithField("categoria_id", categoriaID).
+		Info("Configuración de alertas de stock de la categoría actualizada")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"categoria_id":  categoriaID,
+			"configuracion": req,
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// StartDifusionAlertas escucha las alertas notificadas por la base de datos y las envía a los clientes SSE
+// conectados a este handler
+func (h *StockHandler) StartDifusionAlertas() {
+	listener := h.db.NewListener(func(evento pq.ListenerEventType, err error) {
+		if err != nil {
+			h.logger.WithError(err).Warn("Conexión de escucha de alertas de stock interrumpida")
+		}
+	})
+	if err := listener.Listen(canalAlertasStock); err != nil {
+		h.logger.WithError(err).Error("Error escuchando alertas de stock")
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case n := <-listener.Notify:
+				// nil indica que la conexión se restableció; las alertas intermedias quedan en GetAlertas
+				if n == nil {
+					continue
+				}
+				h.difundirAlerta(n.Extra)
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	}()
+}
+
+// difundirAlerta lee la alerta notificada y la publica a los clientes de su sucursal
+func (h *StockHandler) difundirAlerta(id string) {
+	alertaID, err := uuid.Parse(id)
+	if err != nil {
+		h.logger.WithField("payload", id).Warn("Notificación de alerta de stock inválida")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alerta, err := getAlertaStock(ctx, h.db, alertaID, false)
+	if err != nil {
+		h.logger.WithError(err).WithField("alerta_id", alertaID).Error("Error leyendo alerta de stock notificada")
+		return
+	}
+	h.alertas.publicar(*alerta)
+}
+
+// modificarAlerta bloquea la alerta de la ruta, aplica el cambio, notifica el nuevo estado y responde con la
+// alerta actualizada
+func (h *StockHandler) modificarAlerta(c *gin.Context, mensaje string, cambio func(ctx context.Context, tx *sql.Tx, alerta *models.AlertaStock, usuarioID uuid.UUID) error) {
+	alertaID, ok := uuidParam(c, "id", "INVALID_ALERTA_ID", "ID de alerta inválido")
+	if !ok {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var alerta *models.AlertaStock
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		actual, err := getAlertaStock(ctx, tx, alertaID, true)
+		if err != nil {
+			return err
+		}
+		if err := cambio(ctx, tx, actual, usuarioID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, canalAlertasStock, alertaID.String()); err != nil {
+			return fmt.Errorf("error notificando alerta: %w", err)
+		}
+		alerta, err = getAlertaStock(ctx, tx, alertaID, false)
+		return err
+	})
+	if err != nil {
+		h.responderError(c, err, mensaje)
+		return
+	}
+
+	h.logger.WithField("alerta_id", alerta.ID).
+		WithField("tipo", alerta.Tipo).
+		WithField("estado", alerta.Estado).
+		Info("Alerta de stock actualizada")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      alerta,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// configuracionAlertasEfectiva superpone la configuración del producto a la de su categoría y completa los
+// valores por defecto que aplica evaluar_alertas_stock
+func configuracionAlertasEfectiva(categoria, producto models.ConfiguracionAlertasStock, stockMinimo float64) models.ConfiguracionAlertasStock {
+	efectiva := categoria
+	if producto.Activas != nil {
+		efectiva.Activas = producto.Activas
+	}
+	if producto.StockMinimo != nil {
+		efectiva.StockMinimo = producto.StockMinimo
+	}
+	if producto.SinStock != nil {
+		efectiva.SinStock = producto.SinStock
+	}
+	if producto.AjusteNegativo != nil {
+		efectiva.AjusteNegativo = producto.AjusteNegativo
+	}
+	if producto.MermaMaxima != nil {
+		efectiva.MermaMaxima = producto.MermaMaxima
+	}
+	if producto.MermaDias != nil {
+		efectiva.MermaDias = producto.MermaDias
+	}
+
+	activas, sinStock, mermaDias := true, true, 30
+	if efectiva.Activas == nil {
+		efectiva.Activas = &activas
+	}
+	if efectiva.StockMinimo == nil {
+		efectiva.StockMinimo = &stockMinimo
+	}
+	if efectiva.SinStock == nil {
+		efectiva.SinStock = &sinStock
+	}
+	if efectiva.MermaMaxima != nil && efectiva.MermaDias == nil {
+		efectiva.MermaDias = &mermaDias
+	}
+	return efectiva
+}
+
+// errAlertaNoModificable error para operaciones sobre alertas en un estado que no las admite
+func errAlertaNoModificable(alerta *models.AlertaStock) error {
+	return &apiError{
+		status:  http.StatusConflict,
+		code:    "ALERTA_NO_MODIFICABLE",
+		message: fmt.Sprintf("La alerta ya está %s", alerta.Estado),
+		details: models.JSONB{"alerta_id": alerta.ID, "estado": alerta.Estado},
+	}
+}
+
+// columnasAlertaStock columnas de alertas_stock (alias a) en el orden esperado por scanAlertaStock
+const columnasAlertaStock = `a.id, a.producto_id, p.codigo_interno, p.descripcion, a.sucursal_id, a.tipo, a.estado,
+		a.cantidad_actual, a.umbral, a.mensaje, a.movimiento_id, a.tipo_movimiento, a.documento_referencia,
+		a.fecha_creacion, a.usuario_reconocimiento_id, a.fecha_reconocimiento, a.usuario_resolucion_id,
+		a.fecha_resolucion, a.observaciones_resolucion`
+
+// scanAlertaStock lee una alerta desde una fila
+func scanAlertaStock(row interface{ Scan(...interface{}) error }) (*models.AlertaStock, error) {
+	var a models.AlertaStock
+	err := row.Scan(&a.ID, &a.ProductoID, &a.CodigoInterno, &a.Descripcion, &a.SucursalID, &a.Tipo, &a.Estado,
+		&a.CantidadActual, &a.Umbral, &a.Mensaje, &a.MovimientoID, &a.TipoMovimiento, &a.DocumentoReferencia,
+		&a.FechaCreacion, &a.UsuarioReconocimientoID, &a.FechaReconocimiento, &a.UsuarioResolucionID,
+		&a.FechaResolucion, &a.ObservacionesResolucion)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// listarAlertasStock obtiene las alertas que cumplen el filtro indicado
+func listarAlertasStock(ctx context.Context, q sqlQueryer, filtro string, args ...interface{}) ([]models.AlertaStock, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT `+columnasAlertaStock+` FROM alertas_stock a JOIN productos p ON p.id = a.producto_id `+filtro,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando alertas de stock: %w", err)
+	}
+
+	alertas := []models.AlertaStock{}
+	err = database.ScanRows(rows, func() error {
+		a, err := scanAlertaStock(rows)
+		if err != nil {
+			return err
+		}
+		alertas = append(alertas, *a)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo alertas de stock: %w", err)
+	}
+	return alertas, nil
+}
+
+// getAlertaStock obtiene una alerta por ID
+func getAlertaStock(ctx context.Context, q sqlQueryer, id uuid.UUID, bloquear bool) (*models.AlertaStock, error) {
+	query := `SELECT ` + columnasAlertaStock + `
+		FROM alertas_stock a
+		JOIN productos p ON p.id = a.producto_id
+		WHERE a.id = $1`
+	if bloquear {
+		query += ` FOR UPDATE OF a`
+	}
+
+	a, err := scanAlertaStock(q.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, &apiError{
+			status:  http.StatusNotFound,
+			code:    "ALERTA_NOT_FOUND",
+			message: "Alerta de stock no encontrada",
+			details: models.JSONB{"alerta_id": id},
+		}
+	}
+	return a, err
+}
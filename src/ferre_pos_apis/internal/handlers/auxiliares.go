@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+// apiError error de negocio que se responde con su estado HTTP, código y detalle
+type apiError struct {
+	status  int
+	code    string
+	message string
+	details models.JSONB
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// bindAndValidate lee y valida el cuerpo JSON respondiendo el error correspondiente
+func bindAndValidate(c *gin.Context, val validator.Validator, req interface{}) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_JSON",
+				Message: "JSON inválido en el cuerpo de la petición",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return false
+	}
+
+	if err := val.ValidateStruct(req); err != nil {
+		validationErrors := val.GetValidationErrors(err)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "VALIDATION_ERROR",
+				Message: "Error de validación en los datos enviados",
+				Details: models.JSONB{"validation_errors": validationErrors},
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return false
+	}
+
+	return true
+}
+
+// uuidParam obtiene un ID de la ruta respondiendo con el código indicado si no es un UUID válido
+func uuidParam(c *gin.Context, nombre, codigo, mensaje string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param(nombre))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    codigo,
+				Message: mensaje,
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// sucursalIDQuery obtiene la sucursal de la consulta o, si no se informa, la del usuario
+func sucursalIDQuery(c *gin.Context) (uuid.UUID, bool) {
+	sucursalID, err := uuid.Parse(getSucursalID(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_SUCURSAL_ID",
+				Message: "ID de sucursal inválido",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return uuid.Nil, false
+	}
+	return sucursalID, true
+}
+
+// usuarioID obtiene el ID del usuario autenticado
+func usuarioID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(getUserID(c))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_USER",
+				Message: "Usuario no identificado",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// responderError responde los errores de negocio con su estado; el resto se registra y se responde
+// como error interno con el código indicado
+func responderError(c *gin.Context, log logger.Logger, err error, codigo, mensaje string) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		c.JSON(apiErr.status, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    apiErr.code,
+				Message: apiErr.message,
+				Details: apiErr.details,
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	log.WithError(err).Error(mensaje)
+	c.JSON(http.StatusInternalServerError, models.APIResponse{
+		Success: false,
+		Error: &models.APIError{
+			Code:    codigo,
+			Message: mensaje,
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
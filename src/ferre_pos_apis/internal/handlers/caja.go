@@ -0,0 +1,641 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+// mediosPagoSinArqueo medios de pago que no se cuentan físicamente al cierre
+var mediosPagoSinArqueo = map[string]bool{
+	"puntos_fidelizacion": true,
+	"cuenta_corriente":    true,
+}
+
+// CajaHandler handler para sesiones de caja (apertura, movimientos y cierre Z)
+type CajaHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+}
+
+// NewCajaHandler crea un nuevo handler de caja
+func NewCajaHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *CajaHandler {
+	return &CajaHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+	}
+}
+
+// Abrir abre una sesión de caja en un terminal con su fondo inicial
+func (h *CajaHandler) Abrir(c *gin.Context) {
+	var req models.AbrirCajaRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sesion := &models.SesionCaja{
+		ID:                uuid.New(),
+		TerminalID:        req.TerminalID,
+		UsuarioAperturaID: usuarioID,
+		MontoApertura:     redondearMonto(req.MontoApertura),
+		Estado:            "abierta",
+		Observaciones:     req.Observaciones,
+	}
+
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx,
+			`SELECT sucursal_id FROM terminales WHERE id = $1 AND activo = true`,
+			req.TerminalID,
+		).Scan(&sesion.SucursalID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return &apiError{
+					status:  http.StatusNotFound,
+					code:    "TERMINAL_NOT_FOUND",
+					message: "Terminal no encontrado o inactivo",
+				}
+			}
+			return fmt.Errorf("error consultando terminal: %w", err)
+		}
+
+		var abierta uuid.UUID
+		err = tx.QueryRowContext(ctx,
+			`SELECT id FROM sesiones_caja WHERE terminal_id = $1 AND estado = 'abierta'`,
+			req.TerminalID,
+		).Scan(&abierta)
+		if err == nil {
+			return &apiError{
+				status:  http.StatusConflict,
+				code:    "CAJA_YA_ABIERTA",
+				message: "El terminal ya tiene una sesión de caja abierta",
+				details: models.JSONB{"sesion_caja_id": abierta},
+			}
+		}
+		if err != sql.ErrNoRows {
+			return fmt.Errorf("error consultando sesión de caja: %w", err)
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO sesiones_caja (
+				id, terminal_id, sucursal_id, usuario_apertura_id, monto_apertura,
+				estado, fecha_apertura, observaciones
+			) VALUES ($1, $2, $3, $4, $5, $6, NOW(), $7)
+			RETURNING numero_sesion, fecha_apertura`,
+			sesion.ID, sesion.TerminalID, sesion.SucursalID, sesion.UsuarioAperturaID,
+			sesion.MontoApertura, sesion.Estado, sesion.Observaciones,
+		).Scan(&sesion.NumeroSesion, &sesion.FechaApertura)
+		if err != nil {
+			return fmt.Errorf("error abriendo sesión de caja: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error abriendo sesión de caja")
+		return
+	}
+
+	h.logger.WithField("sesion_caja_id", sesion.ID).
+		WithField("terminal_id", sesion.TerminalID).
+		Info("Sesión de caja abierta")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      sesion,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetActual obtiene la sesión abierta de un terminal (sin montos esperados, el cierre es ciego)
+func (h *CajaHandler) GetActual(c *gin.Context) {
+	terminalID := c.Query("terminal_id")
+	if _, err := uuid.Parse(terminalID); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_TERMINAL_ID",
+				Message: "ID de terminal inválido",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var sesion models.SesionCaja
+	err := h.db.QueryRowContext(ctx, `
+		SELECT id, numero_sesion, terminal_id, sucursal_id, usuario_apertura_id,
+			monto_apertura, estado, fecha_apertura, observaciones
+		FROM sesiones_caja
+		WHERE terminal_id = $1 AND estado = 'abierta'`,
+		terminalID,
+	).Scan(&sesion.ID, &sesion.NumeroSesion, &sesion.TerminalID, &sesion.SucursalID,
+		&sesion.UsuarioAperturaID, &sesion.MontoApertura, &sesion.Estado,
+		&sesion.FechaApertura, &sesion.Observaciones)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success: false,
+				Error: &models.APIError{
+					Code:    "CAJA_SIN_SESION",
+					Message: "El terminal no tiene una sesión de caja abierta",
+				},
+				RequestID: getRequestID(c),
+				Timestamp: time.Now(),
+			})
+			return
+		}
+
+		h.logger.WithError(err).Error("Error consultando sesión de caja")
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "DATABASE_ERROR",
+				Message: "Error consultando sesión de caja",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      sesion,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// RegistrarMovimiento registra un retiro o depósito de efectivo en la sesión
+func (h *CajaHandler) RegistrarMovimiento(c *gin.Context) {
+	sesionID, ok := uuidParam(c, "id", "INVALID_SESION_ID", "ID de sesión de caja inválido")
+	if !ok {
+		return
+	}
+
+	var req models.MovimientoCajaRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	movimiento := &models.MovimientoCaja{
+		ID:            uuid.New(),
+		SesionCajaID:  sesionID,
+		Tipo:          req.Tipo,
+		Monto:         redondearMonto(req.Monto),
+		Motivo:        req.Motivo,
+		UsuarioID:     usuarioID,
+		AutorizadoPor: req.AutorizadoPor,
+	}
+
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := bloquearSesionAbierta(ctx, tx, sesionID); err != nil {
+			return err
+		}
+
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO movimientos_caja (
+				id, sesion_caja_id, tipo, monto, motivo, usuario_id, autorizado_por, fecha
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+			RETURNING fecha`,
+			movimiento.ID, movimiento.SesionCajaID, movimiento.Tipo, movimiento.Monto,
+			movimiento.Motivo, movimiento.UsuarioID, movimiento.AutorizadoPor,
+		).Scan(&movimiento.Fecha)
+		if err != nil {
+			return fmt.Errorf("error registrando movimiento de caja: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error registrando movimiento de caja")
+		return
+	}
+
+	h.logger.WithField("sesion_caja_id", sesionID).
+		WithField("tipo", movimiento.Tipo).
+		WithField("monto", movimiento.Monto).
+		Info("Movimiento de caja registrado")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      movimiento,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Cerrar cierra la sesión con conteo ciego y genera el reporte Z
+func (h *CajaHandler) Cerrar(c *gin.Context) {
+	sesionID, ok := uuidParam(c, "id", "INVALID_SESION_ID", "ID de sesión de caja inválido")
+	if !ok {
+		return
+	}
+
+	var req models.CerrarCajaRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var reporte *models.ReporteZ
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		sesion, err := bloquearSesionAbierta(ctx, tx, sesionID)
+		if err != nil {
+			return err
+		}
+
+		reporte, err = h.generarReporteZ(ctx, tx, sesion, &req)
+		if err != nil {
+			return err
+		}
+
+		arqueo, err := json.Marshal(models.JSONB{
+			"conteo_efectivo":     req.ConteoEfectivo,
+			"conteo_otros_medios": req.ConteoOtrosMedios,
+		})
+		if err != nil {
+			return err
+		}
+		reporteJSON, err := json.Marshal(reporte)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE sesiones_caja
+			SET estado = 'cerrada', fecha_cierre = $2, usuario_cierre_id = $3,
+				total_esperado = $4, total_contado = $5, diferencia = $6,
+				arqueo = $7, reporte_z = $8,
+				observaciones = COALESCE($9, observaciones)
+			WHERE id = $1`,
+			sesion.ID, reporte.FechaCierre, usuarioID, reporte.TotalEsperado,
+			reporte.TotalContado, reporte.Diferencia, arqueo, reporteJSON, req.Observaciones,
+		)
+		if err != nil {
+			return fmt.Errorf("error cerrando sesión de caja: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error cerrando sesión de caja")
+		return
+	}
+
+	h.logger.WithField("sesion_caja_id", sesionID).
+		WithField("diferencia", reporte.Diferencia).
+		Info("Sesión de caja cerrada")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      reporte,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetReporteZ obtiene el reporte Z de una sesión cerrada
+func (h *CajaHandler) GetReporteZ(c *gin.Context) {
+	sesionID, ok := uuidParam(c, "id", "INVALID_SESION_ID", "ID de sesión de caja inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var reporte []byte
+	err := h.db.QueryRowContext(ctx,
+		`SELECT reporte_z FROM sesiones_caja WHERE id = $1 AND estado = 'cerrada'`,
+		sesionID,
+	).Scan(&reporte)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success: false,
+				Error: &models.APIError{
+					Code:    "REPORTE_Z_NOT_FOUND",
+					Message: "Sesión de caja cerrada no encontrada",
+				},
+				RequestID: getRequestID(c),
+				Timestamp: time.Now(),
+			})
+			return
+		}
+
+		h.logger.WithError(err).Error("Error consultando reporte Z")
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "DATABASE_ERROR",
+				Message: "Error consultando reporte Z",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      json.RawMessage(reporte),
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Métodos auxiliares
+
+// responderError responde errores de negocio o errores internos de caja
+func (h *CajaHandler) responderError(c *gin.Context, err error, mensaje string) {
+	responderError(c, h.logger, err, "CAJA_ERROR", mensaje)
+}
+
+// generarReporteZ calcula montos esperados vs contados por medio de pago
+func (h *CajaHandler) generarReporteZ(ctx context.Context, tx *sql.Tx, sesion *models.SesionCaja, req *models.CerrarCajaRequest) (*models.ReporteZ, error) {
+	reporte := &models.ReporteZ{
+		SesionCajaID:   sesion.ID,
+		NumeroSesion:   sesion.NumeroSesion,
+		TerminalID:     sesion.TerminalID,
+		SucursalID:     sesion.SucursalID,
+		FechaApertura:  sesion.FechaApertura,
+		FechaCierre:    time.Now(),
+		MontoApertura:  sesion.MontoApertura,
+		ConteoEfectivo: req.ConteoEfectivo,
+	}
+
+	err := tx.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE estado <> 'anulada'),
+			COALESCE(SUM(total) FILTER (WHERE estado <> 'anulada'), 0),
+			COUNT(*) FILTER (WHERE estado = 'anulada'),
+			COALESCE(SUM(total) FILTER (WHERE estado = 'anulada'), 0)
+		FROM ventas
+		WHERE datos_adicionales->>'sesion_caja_id' = $1`,
+		sesion.ID.String(),
+	).Scan(&reporte.CantidadVentas, &reporte.TotalVentas, &reporte.CantidadAnuladas, &reporte.TotalAnulado)
+	if err != nil {
+		return nil, fmt.Errorf("error totalizando ventas de la sesión: %w", err)
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(SUM(monto) FILTER (WHERE tipo = 'deposito'), 0),
+			COALESCE(SUM(monto) FILTER (WHERE tipo = 'retiro'), 0),
+			COALESCE(SUM(monto) FILTER (WHERE tipo = 'anulacion'), 0)
+		FROM movimientos_caja
+		WHERE sesion_caja_id = $1`,
+		sesion.ID,
+	).Scan(&reporte.TotalDepositos, &reporte.TotalRetiros, &reporte.TotalAnulaciones)
+	if err != nil {
+		return nil, fmt.Errorf("error totalizando movimientos de caja: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT mp.medio_pago, COALESCE(SUM(mp.monto), 0)
+		FROM medios_pago_venta mp
+		JOIN ventas v ON v.id = mp.venta_id
+		WHERE v.datos_adicionales->>'sesion_caja_id' = $1 AND v.estado <> 'anulada'
+		GROUP BY mp.medio_pago`,
+		sesion.ID.String(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error totalizando medios de pago: %w", err)
+	}
+
+	esperado := map[string]float64{"efectivo": 0}
+	err = database.ScanRows(rows, func() error {
+		var medio string
+		var monto float64
+		if err := rows.Scan(&medio, &monto); err != nil {
+			return err
+		}
+		esperado[medio] = monto
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo medios de pago: %w", err)
+	}
+
+	// Los reembolsos de notas de crédito entregados en el turno salen de la caja
+	rows, err = tx.QueryContext(ctx, `
+		SELECT medio_pago, SUM(monto)
+		FROM reembolsos_nota_credito
+		WHERE sesion_caja_id = $1
+		GROUP BY medio_pago`,
+		sesion.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error totalizando reembolsos: %w", err)
+	}
+	err = database.ScanRows(rows, func() error {
+		var medio string
+		var monto float64
+		if err := rows.Scan(&medio, &monto); err != nil {
+			return err
+		}
+		esperado[medio] -= monto
+		reporte.TotalReembolsos += monto
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo reembolsos: %w", err)
+	}
+	reporte.TotalReembolsos = redondearMonto(reporte.TotalReembolsos)
+
+	// El efectivo esperado considera el fondo inicial y los movimientos del turno, incluido el efectivo
+	// devuelto por ventas de turnos anteriores anuladas en este
+	esperado["efectivo"] = redondearMonto(esperado["efectivo"] + sesion.MontoApertura +
+		reporte.TotalDepositos - reporte.TotalRetiros - reporte.TotalAnulaciones)
+
+	contado := map[string]float64{"efectivo": 0}
+	for _, d := range req.ConteoEfectivo {
+		contado["efectivo"] += float64(d.Denominacion * d.Cantidad)
+	}
+	for medio, monto := range req.ConteoOtrosMedios {
+		if medio != "efectivo" {
+			contado[medio] = monto
+			if _, ok := esperado[medio]; !ok {
+				esperado[medio] = 0
+			}
+		}
+	}
+
+	medios := make([]string, 0, len(esperado))
+	for medio := range esperado {
+		medios = append(medios, medio)
+	}
+	sort.Strings(medios)
+
+	for _, medio := range medios {
+		resumen := models.ResumenMedioPagoCaja{
+			MedioPago: medio,
+			Esperado:  redondearMonto(esperado[medio]),
+			Contado:   redondearMonto(contado[medio]),
+		}
+		if mediosPagoSinArqueo[medio] {
+			resumen.Contado = resumen.Esperado
+		}
+		resumen.Diferencia = redondearMonto(resumen.Contado - resumen.Esperado)
+
+		reporte.MediosPago = append(reporte.MediosPago, resumen)
+		reporte.TotalEsperado += resumen.Esperado
+		reporte.TotalContado += resumen.Contado
+	}
+
+	reporte.TotalEsperado = redondearMonto(reporte.TotalEsperado)
+	reporte.TotalContado = redondearMonto(reporte.TotalContado)
+	reporte.Diferencia = redondearMonto(reporte.TotalContado - reporte.TotalEsperado)
+
+	return reporte, nil
+}
+
+// bloquearSesionAbierta obtiene y bloquea una sesión de caja abierta
+func bloquearSesionAbierta(ctx context.Context, tx *sql.Tx, sesionID uuid.UUID) (*models.SesionCaja, error) {
+	var sesion models.SesionCaja
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, numero_sesion, terminal_id, sucursal_id, usuario_apertura_id,
+			monto_apertura, estado, fecha_apertura
+		FROM sesiones_caja
+		WHERE id = $1
+		FOR UPDATE`,
+		sesionID,
+	).Scan(&sesion.ID, &sesion.NumeroSesion, &sesion.TerminalID, &sesion.SucursalID,
+		&sesion.UsuarioAperturaID, &sesion.MontoApertura, &sesion.Estado, &sesion.FechaApertura)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &apiError{
+				status:  http.StatusNotFound,
+				code:    "SESION_NOT_FOUND",
+				message: "Sesión de caja no encontrada",
+			}
+		}
+		return nil, fmt.Errorf("error consultando sesión de caja: %w", err)
+	}
+
+	if sesion.Estado != "abierta" {
+		return nil, &apiError{
+			status:  http.StatusConflict,
+			code:    "SESION_CERRADA",
+			message: "La sesión de caja ya se encuentra cerrada",
+		}
+	}
+
+	return &sesion, nil
+}
+
+// sesionCajaAbierta obtiene la sesión abierta del terminal bloqueándola contra un cierre concurrente
+func sesionCajaAbierta(ctx context.Context, tx *sql.Tx, terminalID uuid.UUID) (uuid.UUID, error) {
+	var sesionID uuid.UUID
+	err := tx.QueryRowContext(ctx,
+		`SELECT id FROM sesiones_caja WHERE terminal_id = $1 AND estado = 'abierta' FOR SHARE`,
+		terminalID,
+	).Scan(&sesionID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return uuid.Nil, &apiError{
+				status:  http.StatusConflict,
+				code:    "CAJA_SIN_SESION",
+				message: "El terminal no tiene una sesión de caja abierta",
+				details: models.JSONB{"terminal_id": terminalID},
+			}
+		}
+		return uuid.Nil, fmt.Errorf("error consultando sesión de caja: %w", err)
+	}
+
+	return sesionID, nil
+}
+
+// devolverEfectivoAnulacion registra la salida del efectivo de una venta anulada en la sesión abierta del
+// terminal cuando la venta pertenece a un turno anterior, ya cerrado y arqueado. Las ventas del turno en
+// curso no requieren movimiento porque al anularse dejan de sumar al efectivo esperado. Sin una sesión
+// abierta no hay caja desde donde devolver el efectivo y la anulación no procede
+func devolverEfectivoAnulacion(ctx context.Context, tx *sql.Tx, venta *models.Venta, sesionVenta *string, usuarioID *uuid.UUID, motivo string) (float64, error) {
+	var efectivo float64
+	err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(monto), 0)
+		FROM medios_pago_venta
+		WHERE venta_id = $1 AND medio_pago = 'efectivo'`,
+		venta.ID,
+	).Scan(&efectivo)
+	if err != nil {
+		return 0, fmt.Errorf("error consultando efectivo de la venta: %w", err)
+	}
+	efectivo = redondearMonto(efectivo)
+	if efectivo <= 0 {
+		return 0, nil
+	}
+
+	sesionID, err := sesionCajaAbierta(ctx, tx, venta.TerminalID)
+	if err != nil {
+		return 0, err
+	}
+	if sesionVenta != nil && *sesionVenta == sesionID.String() {
+		return 0, nil
+	}
+
+	if usuarioID == nil {
+		return 0, &apiError{
+			status:  http.StatusUnauthorized,
+			code:    "INVALID_USER",
+			message: "Usuario no identificado",
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO movimientos_caja (
+			id, sesion_caja_id, tipo, monto, motivo, usuario_id, fecha
+		) VALUES ($1, $2, 'anulacion', $3, $4, $5, NOW())`,
+		uuid.New(), sesionID, efectivo,
+		fmt.Sprintf("Anulación venta %d: %s", venta.NumeroVenta, motivo), *usuarioID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error registrando devolución de efectivo: %w", err)
+	}
+
+	return efectivo, nil
+}
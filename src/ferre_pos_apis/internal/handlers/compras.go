@@ -0,0 +1,1619 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+const (
+	// diasCreditoProveedor plazo de pago por defecto de los documentos de un proveedor
+	diasCreditoProveedor = 30
+	// diasEntregaProveedor tiempo de reposición por defecto de un proveedor
+	diasEntregaProveedor = 7
+)
+
+// ComprasHandler handler para proveedores, órdenes de compra y recepción de mercadería
+type ComprasHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+}
+
+// NewComprasHandler crea un nuevo handler de compras
+func NewComprasHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *ComprasHandler {
+	return &ComprasHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+	}
+}
+
+// ListProveedores lista proveedores, opcionalmente activos o por búsqueda por RUT o razón social
+func (h *ComprasHandler) ListProveedores(c *gin.Context) {
+	query := `SELECT ` + columnasProveedor + ` FROM proveedores WHERE 1 = 1`
+	var args []interface{}
+	if c.Query("activo") == "true" {
+		query += ` AND activo = true`
+	}
+	if q := c.Query("q"); q != "" {
+		args = append(args, "%"+q+"%")
+		n := strconv.Itoa(len(args))
+		query += ` AND (rut ILIKE $` + n + ` OR razon_social ILIKE $` + n + ` OR nombre_fantasia ILIKE $` + n + `)`
+	}
+	query += ` ORDER BY razon_social LIMIT 200`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		h.responderError(c, err, "Error listando proveedores")
+		return
+	}
+
+	proveedores := []models.Proveedor{}
+	err = database.ScanRows(rows, func() error {
+		p, err := scanProveedor(rows)
+		if err != nil {
+			return err
+		}
+		proveedores = append(proveedores, *p)
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error listando proveedores")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      proveedores,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// CreateProveedor registra un proveedor
+func (h *ComprasHandler) CreateProveedor(c *gin.Context) {
+	var req models.ProveedorRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	proveedor := &models.Proveedor{ID: uuid.New(), Activo: true}
+	aplicarProveedorRequest(proveedor, &req)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := h.db.QueryRowContext(ctx, `
+		INSERT INTO proveedores (
+			id, rut, razon_social, nombre_fantasia, giro, direccion, comuna, telefono,
+			email, contacto, dias_credito, dias_entrega, activo
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING fecha_creacion, fecha_modificacion`,
+		proveedor.ID, proveedor.RUT, proveedor.RazonSocial, proveedor.NombreFantasia, proveedor.Giro,
+		proveedor.Direccion, proveedor.Comuna, proveedor.Telefono, proveedor.Email, proveedor.Contacto,
+		proveedor.DiasCredito, proveedor.DiasEntrega, proveedor.Activo,
+	).Scan(&proveedor.FechaCreacion, &proveedor.FechaModificacion)
+	if err != nil {
+		h.responderError(c, errProveedorDuplicado(err), "Error creando proveedor")
+		return
+	}
+
+	h.logger.WithField("proveedor_id", proveedor.ID).
+		WithField("rut", proveedor.RUT).
+		Info("Proveedor creado")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      proveedor,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetProveedor obtiene un proveedor por ID
+func (h *ComprasHandler) GetProveedor(c *gin.Context) {
+	proveedorID, ok := uuidParam(c, "id", "INVALID_PROVEEDOR_ID", "ID de proveedor inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	proveedor, err := getProveedor(ctx, h.db, proveedorID, false)
+	if err != nil {
+		h.responderError(c, err, "Error consultando proveedor")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      proveedor,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// UpdateProveedor actualiza datos, plazo de pago o estado del proveedor
+func (h *ComprasHandler) UpdateProveedor(c *gin.Context) {
+	proveedorID, ok := uuidParam(c, "id", "INVALID_PROVEEDOR_ID", "ID de proveedor inválido")
+	if !ok {
+		return
+	}
+
+	var req models.ProveedorRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var proveedor *models.Proveedor
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		proveedor, err = getProveedor(ctx, tx, proveedorID, true)
+		if err != nil {
+			return err
+		}
+
+		aplicarProveedorRequest(proveedor, &req)
+		err = tx.QueryRowContext(ctx, `
+			UPDATE proveedores
+			SET rut = $2, razon_social = $3, nombre_fantasia = $4, giro = $5, direccion = $6, comuna = $7,
+				telefono = $8, email = $9, contacto = $10, dias_credito = $11, dias_entrega = $12, activo = $13,
+				fecha_modificacion = NOW()
+			WHERE id = $1
+			RETURNING fecha_modificacion`,
+			proveedor.ID, proveedor.RUT, proveedor.RazonSocial, proveedor.NombreFantasia, proveedor.Giro,
+			proveedor.Direccion, proveedor.Comuna, proveedor.Telefono, proveedor.Email, proveedor.Contacto,
+			proveedor.DiasCredito, proveedor.DiasEntrega, proveedor.Activo,
+		).Scan(&proveedor.FechaModificacion)
+		return errProveedorDuplicado(err)
+	})
+	if err != nil {
+		h.responderError(c, err, "Error actualizando proveedor")
+		return
+	}
+
+	h.logger.WithField("proveedor_id", proveedor.ID).
+		WithField("activo", proveedor.Activo).
+		Info("Proveedor actualizado")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      proveedor,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// ListProductosProveedor lista las condiciones de compra de los productos del proveedor
+func (h *ComprasHandler) ListProductosProveedor(c *gin.Context) {
+	proveedorID, ok := uuidParam(c, "id", "INVALID_PROVEEDOR_ID", "ID de proveedor inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := getProveedor(ctx, h.db, proveedorID, false); err != nil {
+		h.responderError(c, err, "Error consultando productos del proveedor")
+		return
+	}
+	productos, err := listarProductosProveedor(ctx, h.db, proveedorID)
+	if err != nil {
+		h.responderError(c, err, "Error consultando productos del proveedor")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      productos,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GuardarProductosProveedor registra o actualiza el empaque, costo y tiempo de entrega de productos del proveedor.
+// Marcar un producto como preferido lo desmarca en sus otros proveedores
+func (h *ComprasHandler) GuardarProductosProveedor(c *gin.Context) {
+	proveedorID, ok := uuidParam(c, "id", "INVALID_PROVEEDOR_ID", "ID de proveedor inválido")
+	if !ok {
+		return
+	}
+
+	var req models.ProductosProveedorRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var productos []models.ProductoProveedor
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := getProveedor(ctx, tx, proveedorID, true); err != nil {
+			return err
+		}
+
+		items := append([]models.ProductoProveedorRequest(nil), req.Items...)
+		sort.Slice(items, func(i, j int) bool { return items[i].ProductoID.String() < items[j].ProductoID.String() })
+		for i := range items {
+			if err := guardarProductoProveedor(ctx, tx, proveedorID, &items[i]); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		productos, err = listarProductosProveedor(ctx, tx, proveedorID)
+		return err
+	})
+	if err != nil {
+		h.responderError(c, err, "Error guardando productos del proveedor")
+		return
+	}
+
+	h.logger.WithField("proveedor_id", proveedorID).
+		WithField("productos", len(req.Items)).
+		Info("Productos de proveedor actualizados")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      productos,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GenerarOrdenesSugeridas recalcula las sugerencias de reposición de la sucursal y crea una orden de compra en
+// borrador por proveedor. Los productos sin proveedor asignado quedan fuera
+func (h *ComprasHandler) GenerarOrdenesSugeridas(c *gin.Context) {
+	var req models.OrdenesSugeridasRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	parametros := parametrosReposicion{
+		diasHistoria:  diasHistoriaReposicion,
+		diasCobertura: diasCoberturaReposicion,
+		nivelServicio: nivelServicioReposicion,
+	}
+	if req.DiasHistoria != nil {
+		parametros.diasHistoria = *req.DiasHistoria
+	}
+	if req.DiasCobertura != nil {
+		parametros.diasCobertura = *req.DiasCobertura
+	}
+	if req.NivelServicio != nil {
+		parametros.nivelServicio = *req.NivelServicio
+	}
+	incluir := make(map[uuid.UUID]bool, len(req.ProveedorIDs))
+	for _, id := range req.ProveedorIDs {
+		incluir[id] = true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	ordenes := []models.OrdenCompra{}
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		// Serializa la conversión en la sucursal para no duplicar pedidos: lo ya pedido en borrador cuenta como pendiente
+		var sucursalID uuid.UUID
+		err := tx.QueryRowContext(ctx,
+			`SELECT id FROM sucursales WHERE id = $1 FOR NO KEY UPDATE`, req.SucursalID,
+		).Scan(&sucursalID)
+		if err == sql.ErrNoRows {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "SUCURSAL_NOT_FOUND",
+				message: "Sucursal no encontrada",
+				details: models.JSONB{"sucursal_id": req.SucursalID},
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("error consultando sucursal: %w", err)
+		}
+
+		grupos, err := calcularSugerenciasReposicion(ctx, tx, sucursalID.String(), parametros)
+		if err != nil {
+			return err
+		}
+
+		ahora := time.Now()
+		hoy := time.Date(ahora.Year(), ahora.Month(), ahora.Day(), 0, 0, 0, 0, ahora.Location())
+		for _, grupo := range grupos {
+			if grupo.ProveedorID == nil || (len(incluir) > 0 && !incluir[*grupo.ProveedorID]) {
+				continue
+			}
+
+			// El costo desconocido se propone en cero para completarlo al revisar el borrador
+			items := make([]models.OrdenCompraItemRequest, len(grupo.Items))
+			diasEntrega := 0
+			for i, s := range grupo.Items {
+				items[i] = models.OrdenCompraItemRequest{ProductoID: s.ProductoID, Cantidad: s.CantidadSugerida}
+				if s.CostoUnitario != nil {
+					items[i].CostoUnitario = *s.CostoUnitario
+				}
+				if s.DiasEntrega > diasEntrega {
+					diasEntrega = s.DiasEntrega
+				}
+			}
+
+			id := uuid.New()
+			entrega := hoy.AddDate(0, 0, diasEntrega)
+			observaciones := fmt.Sprintf("Generada desde sugerencias de reposición (%d días de historia, %d%% de nivel de servicio)",
+				parametros.diasHistoria, parametros.nivelServicio)
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO ordenes_compra (
+					id, proveedor_id, sucursal_id, fecha_entrega_estimada, observaciones, usuario_creacion_id
+				) VALUES ($1, $2, $3, $4, $5, $6)`,
+				id, *grupo.ProveedorID, sucursalID, entrega, observaciones, usuarioID,
+			)
+			if err != nil {
+				return fmt.Errorf("error insertando orden de compra: %w", err)
+			}
+			if err := guardarItemsOrdenCompra(ctx, tx, id, items); err != nil {
+				return err
+			}
+
+			orden, err := getOrdenCompra(ctx, tx, id, false)
+			if err != nil {
+				return err
+			}
+			ordenes = append(ordenes, *orden)
+		}
+
+		if len(ordenes) == 0 {
+			return &apiError{
+				status:  http.StatusConflict,
+				code:    "SIN_SUGERENCIAS",
+				message: "No hay productos con proveedor asignado que reponer",
+				details: models.JSONB{"sucursal_id": req.SucursalID},
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error generando órdenes de compra sugeridas")
+		return
+	}
+
+	h.logger.WithField("sucursal_id", req.SucursalID).
+		WithField("ordenes", len(ordenes)).
+		Info("Órdenes de compra sugeridas generadas")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      ordenes,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// ListOrdenes lista las órdenes de compra de la sucursal
+func (h *ComprasHandler) ListOrdenes(c *gin.Context) {
+	sucursalID, ok := sucursalIDQuery(c)
+	if !ok {
+		return
+	}
+
+	filtro := `WHERE o.sucursal_id = $1`
+	args := []interface{}{sucursalID}
+	if estado := c.Query("estado"); estado != "" {
+		args = append(args, estado)
+		filtro += ` AND o.estado = $` + strconv.Itoa(len(args))
+	}
+	if proveedorID := c.Query("proveedor_id"); proveedorID != "" {
+		if _, err := uuid.Parse(proveedorID); err == nil {
+			args = append(args, proveedorID)
+			filtro += ` AND o.proveedor_id = $` + strconv.Itoa(len(args))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ordenes, err := listarOrdenesCompra(ctx, h.db, filtro+` ORDER BY o.fecha_creacion DESC LIMIT 200`, args...)
+	if err != nil {
+		h.responderError(c, err, "Error listando órdenes de compra")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      ordenes,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// CreateOrden crea una orden de compra en borrador
+func (h *ComprasHandler) CreateOrden(c *gin.Context) {
+	var req models.OrdenCompraRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var orden *models.OrdenCompra
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := proveedorActivo(ctx, tx, req.ProveedorID); err != nil {
+			return err
+		}
+
+		id := uuid.New()
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO ordenes_compra (
+				id, proveedor_id, sucursal_id, fecha_entrega_estimada, observaciones, usuario_creacion_id
+			) VALUES ($1, $2, $3, $4, $5, $6)`,
+			id, req.ProveedorID, req.SucursalID, req.FechaEntregaEstimada, req.Observaciones, usuarioID,
+		)
+		if err != nil {
+			return fmt.Errorf("error insertando orden de compra: %w", err)
+		}
+
+		if err := guardarItemsOrdenCompra(ctx, tx, id, req.Items); err != nil {
+			return err
+		}
+
+		orden, err = getOrdenCompra(ctx, tx, id, false)
+		return err
+	})
+	if err != nil {
+		h.responderError(c, err, "Error creando orden de compra")
+		return
+	}
+
+	h.logger.WithField("orden_compra_id", orden.ID).
+		WithField("numero_orden", orden.NumeroOrden).
+		WithField("proveedor_id", orden.ProveedorID).
+		Info("Orden de compra creada")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      orden,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetOrden obtiene una orden de compra con su detalle
+func (h *ComprasHandler) GetOrden(c *gin.Context) {
+	ordenID, ok := uuidParam(c, "id", "INVALID_ORDEN_COMPRA_ID", "ID de orden de compra inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	orden, err := getOrdenCompra(ctx, h.db, ordenID, false)
+	if err != nil {
+		h.responderError(c, err, "Error consultando orden de compra")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      orden,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// UpdateOrden reemplaza los datos y productos de una orden en borrador
+func (h *ComprasHandler) UpdateOrden(c *gin.Context) {
+	var req models.OrdenCompraRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	h.modificarOrden(c, "Error actualizando orden de compra", func(ctx context.Context, tx *sql.Tx, orden *models.OrdenCompra, usuarioID uuid.UUID) error {
+		if orden.Estado != "borrador" {
+			return errOrdenCompraNoModificable(orden)
+		}
+		if _, err := proveedorActivo(ctx, tx, req.ProveedorID); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			UPDATE ordenes_compra
+			SET proveedor_id = $2, sucursal_id = $3, fecha_entrega_estimada = $4, observaciones = $5
+			WHERE id = $1`,
+			orden.ID, req.ProveedorID, req.SucursalID, req.FechaEntregaEstimada, req.Observaciones,
+		)
+		if err != nil {
+			return fmt.Errorf("error actualizando orden de compra: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM detalle_ordenes_compra WHERE orden_compra_id = $1`, orden.ID); err != nil {
+			return fmt.Errorf("error reemplazando detalle de orden de compra: %w", err)
+		}
+		return guardarItemsOrdenCompra(ctx, tx, orden.ID, req.Items)
+	})
+}
+
+// EmitirOrden envía la orden al proveedor; desde entonces puede recibirse
+func (h *ComprasHandler) EmitirOrden(c *gin.Context) {
+	h.modificarOrden(c, "Error emitiendo orden de compra", func(ctx context.Context, tx *sql.Tx, orden *models.OrdenCompra, usuarioID uuid.UUID) error {
+		if orden.Estado != "borrador" {
+			return errOrdenCompraNoModificable(orden)
+		}
+		if _, err := proveedorActivo(ctx, tx, orden.ProveedorID); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			UPDATE ordenes_compra
+			SET estado = 'emitida', usuario_emision_id = $2, fecha_emision = NOW()
+			WHERE id = $1`,
+			orden.ID, usuarioID,
+		)
+		if err != nil {
+			return fmt.Errorf("error emitiendo orden de compra: %w", err)
+		}
+		return nil
+	})
+}
+
+// CancelarOrden anula una orden; si ya tuvo recepciones parciales se cancela el saldo pendiente
+func (h *ComprasHandler) CancelarOrden(c *gin.Context) {
+	var req models.CancelarOrdenCompraRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	h.modificarOrden(c, "Error cancelando orden de compra", func(ctx context.Context, tx *sql.Tx, orden *models.OrdenCompra, usuarioID uuid.UUID) error {
+		if orden.Estado == "recibida" || orden.Estado == "cancelada" {
+			return errOrdenCompraNoModificable(orden)
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			UPDATE ordenes_compra
+			SET estado = 'cancelada', fecha_cancelacion = NOW(), motivo_cancelacion = $2
+			WHERE id = $1`,
+			orden.ID, req.Motivo,
+		)
+		if err != nil {
+			return fmt.Errorf("error cancelando orden de compra: %w", err)
+		}
+		return nil
+	})
+}
+
+// ListRecepciones lista las recepciones de la sucursal, opcionalmente por proveedor o estado de pago
+func (h *ComprasHandler) ListRecepciones(c *gin.Context) {
+	sucursalID, ok := sucursalIDQuery(c)
+	if !ok {
+		return
+	}
+
+	filtro := `WHERE sucursal_id = $1`
+	args := []interface{}{sucursalID}
+	if proveedorID := c.Query("proveedor_id"); proveedorID != "" {
+		if _, err := uuid.Parse(proveedorID); err == nil {
+			args = append(args, proveedorID)
+			filtro += ` AND proveedor_id = $` + strconv.Itoa(len(args))
+		}
+	}
+	if estadoPago := c.Query("estado_pago"); estadoPago != "" {
+		args = append(args, estadoPago)
+		filtro += ` AND estado_pago = $` + strconv.Itoa(len(args))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.db.QueryContext(ctx,
+		`SELECT `+columnasRecepcionCompra+` FROM recepciones_compra `+filtro+` ORDER BY fecha_recepcion DESC LIMIT 200`,
+		args...,
+	)
+	if err != nil {
+		h.responderError(c, err, "Error listando recepciones")
+		return
+	}
+
+	recepciones := []models.RecepcionCompra{}
+	err = database.ScanRows(rows, func() error {
+		r, err := scanRecepcionCompra(rows)
+		if err != nil {
+			return err
+		}
+		recepciones = append(recepciones, *r)
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error listando recepciones")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      recepciones,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// CreateRecepcion ingresa mercadería de un proveedor con o sin orden de compra. Admite recepciones parciales
+// y productos no pedidos; cada producto genera su movimiento de entrada y pondera el costo promedio de la sucursal
+func (h *ComprasHandler) CreateRecepcion(c *gin.Context) {
+	var req models.RecepcionCompraRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	var recepcion *models.RecepcionCompra
+	var costosActualizados int
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		proveedor, err := proveedorActivo(ctx, tx, req.ProveedorID)
+		if err != nil {
+			return err
+		}
+
+		var orden *models.OrdenCompra
+		if req.OrdenCompraID != nil {
+			orden, err = getOrdenCompra(ctx, tx, *req.OrdenCompraID, true)
+			if err != nil {
+				return err
+			}
+			if orden.Estado != "emitida" && orden.Estado != "recibida_parcial" {
+				return errOrdenCompraNoModificable(orden)
+			}
+			if orden.ProveedorID != req.ProveedorID || orden.SucursalID != req.SucursalID {
+				return &apiError{
+					status:  http.StatusBadRequest,
+					code:    "ORDEN_COMPRA_NO_COINCIDE",
+					message: "La orden de compra es de otro proveedor o sucursal",
+					details: models.JSONB{"orden_compra_id": orden.ID},
+				}
+			}
+		}
+
+		items := append([]models.RecepcionCompraItemRequest(nil), req.Items...)
+		sort.Slice(items, func(i, j int) bool { return items[i].ProductoID.String() < items[j].ProductoID.String() })
+		for i := 1; i < len(items); i++ {
+			if items[i].ProductoID == items[i-1].ProductoID {
+				return &apiError{
+					status:  http.StatusBadRequest,
+					code:    "PRODUCTO_DUPLICADO",
+					message: "Cada producto debe informarse una sola vez",
+					details: models.JSONB{"producto_id": items[i].ProductoID},
+				}
+			}
+		}
+
+		ahora := time.Now()
+		fechaDocumento := time.Date(ahora.Year(), ahora.Month(), ahora.Day(), 0, 0, 0, 0, ahora.Location())
+		if req.FechaDocumento != nil {
+			fechaDocumento = *req.FechaDocumento
+		}
+
+		id := uuid.New()
+		batchID := uuid.New()
+		var numero int64
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO recepciones_compra (
+				id, proveedor_id, sucursal_id, orden_compra_id, tipo_documento, numero_documento,
+				fecha_documento, fecha_vencimiento, monto_neto, monto_iva, monto_total,
+				observaciones, usuario_id, batch_id
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 0, 0, 0, $9, $10, $11)
+			RETURNING numero_recepcion`,
+			id, req.ProveedorID, req.SucursalID, req.OrdenCompraID, req.TipoDocumento,
+			strings.TrimSpace(req.NumeroDocumento), fechaDocumento,
+			fechaDocumento.AddDate(0, 0, proveedor.DiasCredito), req.Observaciones, usuarioID, batchID,
+		).Scan(&numero)
+		if err != nil {
+			if esViolacionUnicidad(err) {
+				return &apiError{
+					status:  http.StatusConflict,
+					code:    "DOCUMENTO_DUPLICADO",
+					message: "El documento del proveedor ya fue recepcionado",
+					details: models.JSONB{"tipo_documento": req.TipoDocumento, "numero_documento": req.NumeroDocumento},
+				}
+			}
+			return fmt.Errorf("error insertando recepción: %w", err)
+		}
+
+		documento := fmt.Sprintf("RECEPCION-%d", numero)
+		var neto float64
+		var cambiados []uuid.UUID
+		for i := range items {
+			item := &items[i]
+			linea := lineaOrdenCompra(orden, item.ProductoID)
+			cambio, err := recibirItemCompra(ctx, tx, id, req.SucursalID, item, linea, documento, req.Observaciones, usuarioID, batchID)
+			if err != nil {
+				return err
+			}
+			neto += redondearCantidad(item.Cantidad) * *item.CostoUnitario
+			if cambio {
+				cambiados = append(cambiados, item.ProductoID)
+			}
+		}
+		costosActualizados = len(cambiados)
+
+		neto = redondearMonto(neto)
+		iva := redondearMonto(neto * tasaIVA)
+		_, err = tx.ExecContext(ctx, `
+			UPDATE recepciones_compra SET monto_neto = $2, monto_iva = $3, monto_total = $4 WHERE id = $1`,
+			id, neto, iva, redondearMonto(neto+iva),
+		)
+		if err != nil {
+			return fmt.Errorf("error totalizando recepción: %w", err)
+		}
+
+		if orden != nil {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE ordenes_compra
+				SET estado = CASE WHEN (
+					SELECT bool_and(cantidad_recibida >= cantidad) FROM detalle_ordenes_compra WHERE orden_compra_id = $1
+				) THEN 'recibida' ELSE 'recibida_parcial' END
+				WHERE id = $1`,
+				orden.ID,
+			)
+			if err != nil {
+				return fmt.Errorf("error actualizando orden de compra: %w", err)
+			}
+		}
+
+		if req.GenerarEtiquetas && len(cambiados) > 0 {
+			trabajoID, err := crearTrabajoEtiquetasCosto(ctx, tx, id, req.SucursalID, usuarioID, req.PlantillaID, cambiados)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE recepciones_compra SET trabajo_etiquetas_id = $2 WHERE id = $1`, id, trabajoID,
+			); err != nil {
+				return fmt.Errorf("error asociando trabajo de etiquetas: %w", err)
+			}
+		}
+
+		recepcion, err = getRecepcionCompra(ctx, tx, id, false)
+		return err
+	})
+	if err != nil {
+		h.responderError(c, err, "Error registrando recepción")
+		return
+	}
+
+	h.logger.WithField("recepcion_id", recepcion.ID).
+		WithField("numero_recepcion", recepcion.NumeroRecepcion).
+		WithField("proveedor_id", recepcion.ProveedorID).
+		WithField("monto_total", recepcion.MontoTotal).
+		WithField("costos_actualizados", costosActualizados).
+		Info("Recepción de compra registrada")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      recepcion,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetRecepcion obtiene una recepción con su detalle
+func (h *ComprasHandler) GetRecepcion(c *gin.Context) {
+	recepcionID, ok := uuidParam(c, "id", "INVALID_RECEPCION_ID", "ID de recepción inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	recepcion, err := getRecepcionCompra(ctx, h.db, recepcionID, false)
+	if err != nil {
+		h.responderError(c, err, "Error consultando recepción")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      recepcion,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// RegistrarPago abona al documento de una recepción y actualiza su estado de pago
+func (h *ComprasHandler) RegistrarPago(c *gin.Context) {
+	recepcionID, ok := uuidParam(c, "id", "INVALID_RECEPCION_ID", "ID de recepción inválido")
+	if !ok {
+		return
+	}
+
+	var req models.PagoProveedorRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var recepcion *models.RecepcionCompra
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		actual, err := getRecepcionCompra(ctx, tx, recepcionID, true)
+		if err != nil {
+			return err
+		}
+
+		monto := redondearMonto(req.Monto)
+		saldo := redondearMonto(actual.MontoTotal - actual.MontoPagado)
+		if monto > saldo {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "MONTO_EXCEDE_SALDO",
+				message: "El pago supera el saldo pendiente del documento",
+				details: models.JSONB{"saldo_pendiente": saldo},
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO pagos_proveedores (recepcion_id, monto, medio_pago, referencia, usuario_id)
+			VALUES ($1, $2, $3, $4, $5)`,
+			actual.ID, monto, req.MedioPago, req.Referencia, usuarioID,
+		)
+		if err != nil {
+			return fmt.Errorf("error registrando pago a proveedor: %w", err)
+		}
+
+		estado := "parcial"
+		if monto == saldo {
+			estado = "pagada"
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE recepciones_compra SET monto_pagado = monto_pagado + $2, estado_pago = $3 WHERE id = $1`,
+			actual.ID, monto, estado,
+		)
+		if err != nil {
+			return fmt.Errorf("error actualizando pago de recepción: %w", err)
+		}
+
+		recepcion, err = getRecepcionCompra(ctx, tx, recepcionID, false)
+		return err
+	})
+	if err != nil {
+		h.responderError(c, err, "Error registrando pago a proveedor")
+		return
+	}
+
+	h.logger.WithField("recepcion_id", recepcion.ID).
+		WithField("monto", req.Monto).
+		WithField("estado_pago", recepcion.EstadoPago).
+		Info("Pago a proveedor registrado")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      recepcion,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// modificarOrden bloquea la orden de la ruta, aplica el cambio y responde con la orden actualizada
+func (h *ComprasHandler) modificarOrden(c *gin.Context, mensaje string, cambio func(ctx context.Context, tx *sql.Tx, orden *models.OrdenCompra, usuarioID uuid.UUID) error) {
+	ordenID, ok := uuidParam(c, "id", "INVALID_ORDEN_COMPRA_ID", "ID de orden de compra inválido")
+	if !ok {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var orden *models.OrdenCompra
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		actual, err := getOrdenCompra(ctx, tx, ordenID, true)
+		if err != nil {
+			return err
+		}
+		if err := cambio(ctx, tx, actual, usuarioID); err != nil {
+			return err
+		}
+		orden, err = getOrdenCompra(ctx, tx, ordenID, false)
+		return err
+	})
+	if err != nil {
+		h.responderError(c, err, mensaje)
+		return
+	}
+
+	h.logger.WithField("orden_compra_id", orden.ID).
+		WithField("numero_orden", orden.NumeroOrden).
+		WithField("estado", orden.Estado).
+		Info("Orden de compra actualizada")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      orden,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// responderError responde errores de negocio o errores internos
+func (h *ComprasHandler) responderError(c *gin.Context, err error, mensaje string) {
+	responderError(c, h.logger, err, "COMPRAS_ERROR", mensaje)
+}
+
+// aplicarProveedorRequest copia los datos del request al proveedor
+func aplicarProveedorRequest(proveedor *models.Proveedor, req *models.ProveedorRequest) {
+	proveedor.RUT = strings.TrimSpace(req.RUT)
+	proveedor.RazonSocial = req.RazonSocial
+	proveedor.NombreFantasia = req.NombreFantasia
+	proveedor.Giro = req.Giro
+	proveedor.Direccion = req.Direccion
+	proveedor.Comuna = req.Comuna
+	proveedor.Telefono = req.Telefono
+	proveedor.Email = req.Email
+	proveedor.Contacto = req.Contacto
+
+	if req.DiasCredito != nil {
+		proveedor.DiasCredito = *req.DiasCredito
+	} else if proveedor.DiasCredito == 0 {
+		proveedor.DiasCredito = diasCreditoProveedor
+	}
+	if req.DiasEntrega != nil {
+		proveedor.DiasEntrega = *req.DiasEntrega
+	} else if proveedor.DiasEntrega == 0 {
+		proveedor.DiasEntrega = diasEntregaProveedor
+	}
+	if req.Activo != nil {
+		proveedor.Activo = *req.Activo
+	}
+}
+
+// errProveedorDuplicado traduce la violación de unicidad del RUT
+func errProveedorDuplicado(err error) error {
+	if err != nil && esViolacionUnicidad(err) {
+		return &apiError{
+			status:  http.StatusConflict,
+			code:    "PROVEEDOR_DUPLICADO",
+			message: "Ya existe un proveedor con el RUT indicado",
+		}
+	}
+	return err
+}
+
+// columnasProveedor columnas de proveedores en el orden esperado por scanProveedor
+const columnasProveedor = `id, rut, razon_social, nombre_fantasia, giro, direccion, comuna, telefono,
+		email, contacto, dias_credito, dias_entrega, activo, fecha_creacion, fecha_modificacion`
+
+// scanProveedor lee un proveedor desde una fila
+func scanProveedor(row interface{ Scan(...interface{}) error }) (*models.Proveedor, error) {
+	var p models.Proveedor
+	err := row.Scan(&p.ID, &p.RUT, &p.RazonSocial, &p.NombreFantasia, &p.Giro, &p.Direccion, &p.Comuna,
+		&p.Telefono, &p.Email, &p.Contacto, &p.DiasCredito, &p.DiasEntrega, &p.Activo, &p.FechaCreacion, &p.FechaModificacion)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// getProveedor obtiene un proveedor por ID
+func getProveedor(ctx context.Context, q sqlQueryer, id uuid.UUID, bloquear bool) (*models.Proveedor, error) {
+	query := `SELECT ` + columnasProveedor + ` FROM proveedores WHERE id = $1`
+	if bloquear {
+		query += ` FOR UPDATE`
+	}
+
+	p, err := scanProveedor(q.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, &apiError{
+			status:  http.StatusNotFound,
+			code:    "PROVEEDOR_NOT_FOUND",
+			message: "Proveedor no encontrado",
+			details: models.JSONB{"proveedor_id": id},
+		}
+	}
+	return p, err
+}
+
+// proveedorActivo obtiene el proveedor validando que esté habilitado para comprarle
+func proveedorActivo(ctx context.Context, q sqlQueryer, id uuid.UUID) (*models.Proveedor, error) {
+	p, err := getProveedor(ctx, q, id, false)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Activo {
+		return nil, &apiError{
+			status:  http.StatusConflict,
+			code:    "PROVEEDOR_INACTIVO",
+			message: "El proveedor está inactivo",
+			details: models.JSONB{"proveedor_id": id},
+		}
+	}
+	return p, nil
+}
+
+// guardarProductoProveedor registra o actualiza las condiciones de compra de un producto al proveedor
+func guardarProductoProveedor(ctx context.Context, tx *sql.Tx, proveedorID uuid.UUID, item *models.ProductoProveedorRequest) error {
+	var permiteFraccion bool
+	err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(permite_fraccionamiento, false) FROM productos WHERE id = $1`, item.ProductoID,
+	).Scan(&permiteFraccion)
+	if err == sql.ErrNoRows {
+		return &apiError{
+			status:  http.StatusBadRequest,
+			code:    "PRODUCT_NOT_FOUND",
+			message: "Producto no encontrado",
+			details: models.JSONB{"producto_id": item.ProductoID},
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error consultando producto: %w", err)
+	}
+
+	unidad := 1.0
+	if item.UnidadCompra != nil {
+		unidad = redondearCantidad(*item.UnidadCompra)
+	}
+	if !permiteFraccion && !esEntero(unidad) {
+		return &apiError{
+			status:  http.StatusBadRequest,
+			code:    "CANTIDAD_FRACCIONADA_NO_PERMITIDA",
+			message: "El producto no permite cantidades fraccionadas",
+			details: models.JSONB{"producto_id": item.ProductoID, "unidad_compra": unidad},
+		}
+	}
+
+	if item.Preferido {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE productos_proveedores SET preferido = false, fecha_modificacion = NOW()
+			WHERE producto_id = $1 AND proveedor_id <> $2 AND preferido = true`,
+			item.ProductoID, proveedorID,
+		); err != nil {
+			return fmt.Errorf("error desmarcando proveedor preferido: %w", err)
+		}
+	}
+
+	var costo *float64
+	if item.CostoReferencia != nil {
+		valor := redondearMonto(*item.CostoReferencia)
+		costo = &valor
+	}
+	activo := item.Activo == nil || *item.Activo
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO productos_proveedores (
+			producto_id, proveedor_id, codigo_proveedor, unidad_compra, costo_referencia, dias_entrega, preferido, activo
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (producto_id, proveedor_id) DO UPDATE
+		SET codigo_proveedor = EXCLUDED.codigo_proveedor, unidad_compra = EXCLUDED.unidad_compra,
+			costo_referencia = EXCLUDED.costo_referencia, dias_entrega = EXCLUDED.dias_entrega,
+			preferido = EXCLUDED.preferido, activo = EXCLUDED.activo, fecha_modificacion = NOW()`,
+		item.ProductoID, proveedorID, item.CodigoProveedor, unidad, costo, item.DiasEntrega, item.Preferido, activo,
+	)
+	if err != nil {
+		return fmt.Errorf("error guardando producto del proveedor: %w", err)
+	}
+	return nil
+}
+
+// listarProductosProveedor obtiene las condiciones de compra de los productos del proveedor
+func listarProductosProveedor(ctx context.Context, q sqlQueryer, proveedorID uuid.UUID) ([]models.ProductoProveedor, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT pp.id, pp.producto_id, p.codigo_interno, p.descripcion, pp.proveedor_id, pp.codigo_proveedor,
+			pp.unidad_compra, pp.costo_referencia, pp.dias_entrega, COALESCE(pp.preferido, false),
+			COALESCE(pp.activo, true), pp.fecha_creacion, pp.fecha_modificacion
+		FROM productos_proveedores pp
+		JOIN productos p ON p.id = pp.producto_id
+		WHERE pp.proveedor_id = $1
+		ORDER BY p.codigo_interno`,
+		proveedorID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando productos del proveedor: %w", err)
+	}
+
+	productos := []models.ProductoProveedor{}
+	err = database.ScanRows(rows, func() error {
+		var pp models.ProductoProveedor
+		if err := rows.Scan(&pp.ID, &pp.ProductoID, &pp.CodigoInterno, &pp.Descripcion, &pp.ProveedorID,
+			&pp.CodigoProveedor, &pp.UnidadCompra, &pp.CostoReferencia, &pp.DiasEntrega, &pp.Preferido,
+			&pp.Activo, &pp.FechaCreacion, &pp.FechaModificacion); err != nil {
+			return err
+		}
+		productos = append(productos, pp)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo productos del proveedor: %w", err)
+	}
+	return productos, nil
+}
+
+// columnasOrdenCompra columnas de ordenes_compra (alias o) en el orden esperado por scanOrdenCompra
+const columnasOrdenCompra = `o.id, o.numero_orden, o.proveedor_id, pr.razon_social, o.sucursal_id, o.estado,
+		o.fecha_entrega_estimada, o.observaciones, o.total_neto, o.usuario_creacion_id, o.fecha_creacion,
+		o.usuario_emision_id, o.fecha_emision, o.fecha_cancelacion, o.motivo_cancelacion`
+
+// scanOrdenCompra lee una orden de compra desde una fila
+func scanOrdenCompra(row interface{ Scan(...interface{}) error }) (*models.OrdenCompra, error) {
+	var o models.OrdenCompra
+	err := row.Scan(&o.ID, &o.NumeroOrden, &o.ProveedorID, &o.RazonSocialProveedor, &o.SucursalID, &o.Estado,
+		&o.FechaEntregaEstimada, &o.Observaciones, &o.TotalNeto, &o.UsuarioCreacionID, &o.FechaCreacion,
+		&o.UsuarioEmisionID, &o.FechaEmision, &o.FechaCancelacion, &o.MotivoCancelacion)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// listarOrdenesCompra obtiene las órdenes que cumplen el filtro indicado, sin su detalle
+func listarOrdenesCompra(ctx context.Context, q sqlQueryer, filtro string, args ...interface{}) ([]models.OrdenCompra, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT `+columnasOrdenCompra+` FROM ordenes_compra o JOIN proveedores pr ON pr.id = o.proveedor_id `+filtro,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando órdenes de compra: %w", err)
+	}
+
+	ordenes := []models.OrdenCompra{}
+	err = database.ScanRows(rows, func() error {
+		o, err := scanOrdenCompra(rows)
+		if err != nil {
+			return err
+		}
+		ordenes = append(ordenes, *o)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo órdenes de compra: %w", err)
+	}
+	return ordenes, nil
+}
+
+// getOrdenCompra obtiene una orden de compra con su detalle
+func getOrdenCompra(ctx context.Context, q sqlQueryer, id uuid.UUID, bloquear bool) (*models.OrdenCompra, error) {
+	query := `SELECT ` + columnasOrdenCompra + `
+		FROM ordenes_compra o
+		JOIN proveedores pr ON pr.id = o.proveedor_id
+		WHERE o.id = $1`
+	if bloquear {
+		query += ` FOR UPDATE OF o`
+	}
+
+	o, err := scanOrdenCompra(q.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, &apiError{
+			status:  http.StatusNotFound,
+			code:    "ORDEN_COMPRA_NOT_FOUND",
+			message: "Orden de compra no encontrada",
+			details: models.JSONB{"orden_compra_id": id},
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT d.id, d.orden_compra_id, d.producto_id, p.codigo_interno, p.descripcion,
+			d.cantidad, d.costo_unitario, COALESCE(d.cantidad_recibida, 0)
+		FROM detalle_ordenes_compra d
+		JOIN productos p ON p.id = d.producto_id
+		WHERE d.orden_compra_id = $1
+		ORDER BY p.codigo_interno`,
+		o.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando detalle de orden de compra: %w", err)
+	}
+	err = database.ScanRows(rows, func() error {
+		var d models.DetalleOrdenCompra
+		if err := rows.Scan(&d.ID, &d.OrdenCompraID, &d.ProductoID, &d.CodigoInterno, &d.Descripcion,
+			&d.Cantidad, &d.CostoUnitario, &d.CantidadRecibida); err != nil {
+			return err
+		}
+		o.Detalles = append(o.Detalles, d)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo detalle de orden de compra: %w", err)
+	}
+
+	return o, nil
+}
+
+// errOrdenCompraNoModificable error para operaciones sobre órdenes en un estado que no las admite
+func errOrdenCompraNoModificable(orden *models.OrdenCompra) error {
+	return &apiError{
+		status:  http.StatusConflict,
+		code:    "ORDEN_COMPRA_NO_MODIFICABLE",
+		message: fmt.Sprintf("La orden de compra está %s", strings.ReplaceAll(orden.Estado, "_", " ")),
+		details: models.JSONB{"orden_compra_id": orden.ID, "estado": orden.Estado},
+	}
+}
+
+// lineaOrdenCompra busca la línea de un producto en la orden; nil si no hay orden o no fue pedido
+func lineaOrdenCompra(orden *models.OrdenCompra, productoID uuid.UUID) *models.DetalleOrdenCompra {
+	if orden == nil {
+		return nil
+	}
+	for i := range orden.Detalles {
+		if orden.Detalles[i].ProductoID == productoID {
+			return &orden.Detalles[i]
+		}
+	}
+	return nil
+}
+
+// guardarItemsOrdenCompra inserta los productos de la orden y actualiza su total neto
+func guardarItemsOrdenCompra(ctx context.Context, tx *sql.Tx, ordenID uuid.UUID, items []models.OrdenCompraItemRequest) error {
+	ids := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		ids[i] = item.ProductoID
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, COALESCE(permite_fraccionamiento, false) FROM productos WHERE id = ANY($1::uuid[]) AND activo = true`,
+		pq.Array(uuidsTexto(ids)),
+	)
+	if err != nil {
+		return fmt.Errorf("error consultando productos: %w", err)
+	}
+	permiteFraccion := make(map[uuid.UUID]bool, len(items))
+	err = database.ScanRows(rows, func() error {
+		var id uuid.UUID
+		var fraccion bool
+		if err := rows.Scan(&id, &fraccion); err != nil {
+			return err
+		}
+		permiteFraccion[id] = fraccion
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error leyendo productos: %w", err)
+	}
+
+	vistos := make(map[uuid.UUID]bool, len(items))
+	var total float64
+	for _, item := range items {
+		fraccion, ok := permiteFraccion[item.ProductoID]
+		if !ok {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "PRODUCT_NOT_FOUND",
+				message: "Producto no encontrado o inactivo",
+				details: models.JSONB{"producto_id": item.ProductoID},
+			}
+		}
+		if vistos[item.ProductoID] {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "PRODUCTO_DUPLICADO",
+				message: "Cada producto debe informarse una sola vez",
+				details: models.JSONB{"producto_id": item.ProductoID},
+			}
+		}
+		vistos[item.ProductoID] = true
+
+		cantidad := redondearCantidad(item.Cantidad)
+		if !fraccion && !esEntero(cantidad) {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "CANTIDAD_FRACCIONADA_NO_PERMITIDA",
+				message: "El producto no permite cantidades fraccionadas",
+				details: models.JSONB{"producto_id": item.ProductoID, "cantidad": item.Cantidad},
+			}
+		}
+
+		costo := redondearMonto(item.CostoUnitario)
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO detalle_ordenes_compra (orden_compra_id, producto_id, cantidad, costo_unitario)
+			VALUES ($1, $2, $3, $4)`,
+			ordenID, item.ProductoID, cantidad, costo,
+		)
+		if err != nil {
+			return fmt.Errorf("error insertando detalle de orden de compra: %w", err)
+		}
+		total += cantidad * costo
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE ordenes_compra SET total_neto = $2 WHERE id = $1`, ordenID, redondearMonto(total),
+	); err != nil {
+		return fmt.Errorf("error totalizando orden de compra: %w", err)
+	}
+	return nil
+}
+
+// recibirItemCompra ingresa un producto de la recepción al stock de la sucursal y registra su línea con el
+// costo promedio previo y resultante. Indica si el costo promedio del producto cambió
+func recibirItemCompra(ctx context.Context, tx *sql.Tx, recepcionID, sucursalID uuid.UUID, item *models.RecepcionCompraItemRequest, linea *models.DetalleOrdenCompra, documento string, observaciones *string, usuarioID, batchID uuid.UUID) (bool, error) {
+	if item.CostoUnitario == nil {
+		if linea == nil {
+			return false, &apiError{
+				status:  http.StatusBadRequest,
+				code:    "COSTO_REQUERIDO",
+				message: "Los productos no pedidos en la orden requieren costo unitario",
+				details: models.JSONB{"producto_id": item.ProductoID},
+			}
+		}
+		item.CostoUnitario = &linea.CostoUnitario
+	}
+	costo := redondearMonto(*item.CostoUnitario)
+	item.CostoUnitario = &costo
+
+	anterior, err := costoPromedioSucursal(ctx, tx, item.ProductoID, sucursalID)
+	if err != nil {
+		return false, err
+	}
+
+	entrada := models.EntradaStockItemRequest{
+		ProductoID:       item.ProductoID,
+		Cantidad:         redondearCantidad(item.Cantidad),
+		CostoUnitario:    &costo,
+		NumerosSerie:     item.NumerosSerie,
+		Lote:             item.Lote,
+		FechaVencimiento: item.FechaVencimiento,
+	}
+	if _, err := registrarEntradaStock(ctx, tx, sucursalID, &entrada, &documento, observaciones, &usuarioID, batchID); err != nil {
+		return false, err
+	}
+
+	nuevo, err := costoPromedioSucursal(ctx, tx, item.ProductoID, sucursalID)
+	if err != nil {
+		return false, err
+	}
+
+	var detalleOrdenID *uuid.UUID
+	if linea != nil {
+		detalleOrdenID = &linea.ID
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE detalle_ordenes_compra SET cantidad_recibida = COALESCE(cantidad_recibida, 0) + $2 WHERE id = $1`,
+			linea.ID, entrada.Cantidad,
+		); err != nil {
+			return false, fmt.Errorf("error actualizando lo recibido de la orden: %w", err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO detalle_recepciones_compra (
+			recepcion_id, producto_id, detalle_orden_id, cantidad, costo_unitario,
+			costo_promedio_anterior, costo_promedio_nuevo, lote, fecha_vencimiento, numeros_serie
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		recepcionID, item.ProductoID, detalleOrdenID, entrada.Cantidad, costo,
+		anterior, nuevo, item.Lote, item.FechaVencimiento, pq.Array(item.NumerosSerie),
+	)
+	if err != nil {
+		return false, fmt.Errorf("error insertando detalle de recepción: %w", err)
+	}
+
+	cambio := nuevo != nil && (anterior == nil || *anterior != *nuevo)
+	return cambio, nil
+}
+
+// costoPromedioSucursal obtiene el costo promedio vigente del producto en la sucursal
+func costoPromedioSucursal(ctx context.Context, tx *sql.Tx, productoID, sucursalID uuid.UUID) (*float64, error) {
+	var costo *float64
+	err := tx.QueryRowContext(ctx,
+		`SELECT costo_promedio FROM stock_central WHERE producto_id = $1 AND sucursal_id = $2`,
+		productoID, sucursalID,
+	).Scan(&costo)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("error consultando costo promedio: %w", err)
+	}
+	return costo, nil
+}
+
+// crearTrabajoEtiquetasCosto encola un trabajo de etiquetas de precio para los productos cuyo costo cambió
+func crearTrabajoEtiquetasCosto(ctx context.Context, tx *sql.Tx, recepcionID, sucursalID, usuarioID uuid.UUID, plantillaID *uuid.UUID, productos []uuid.UUID) (uuid.UUID, error) {
+	var plantilla uuid.UUID
+	var err error
+	if plantillaID != nil {
+		err = tx.QueryRowContext(ctx,
+			`SELECT id FROM etiquetas_plantillas WHERE id = $1 AND activa = true`, *plantillaID,
+		).Scan(&plantilla)
+	} else {
+		err = tx.QueryRowContext(ctx, `
+			SELECT id FROM etiquetas_plantillas
+			WHERE activa = true AND predeterminada = true AND tipo_etiqueta = 'precio'
+			ORDER BY fecha_creacion
+			LIMIT 1`,
+		).Scan(&plantilla)
+	}
+	if err == sql.ErrNoRows {
+		return uuid.Nil, &apiError{
+			status:  http.StatusBadRequest,
+			code:    "PLANTILLA_ETIQUETA_NO_DISPONIBLE",
+			message: "No hay una plantilla de etiquetas activa para generar las etiquetas de precio",
+		}
+	}
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("error consultando plantilla de etiquetas: %w", err)
+	}
+
+	trabajoID := uuid.New()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO etiquetas_trabajos_impresion (
+			id, usuario_id, sucursal_id, plantilla_id, tipo_trabajo, total_etiquetas, parametros_trabajo
+		) VALUES ($1, $2, $3, $4, 'masivo', $5, $6)`,
+		trabajoID, usuarioID, sucursalID, plantilla, len(productos),
+		models.JSONB{
+			"productos_ids":  productos,
+			"cantidad":       1,
+			"formato_salida": "pdf",
+			"origen":         "recepcion_compra",
+			"recepcion_id":   recepcionID,
+		},
+	)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("error creando trabajo de etiquetas: %w", err)
+	}
+	return trabajoID, nil
+}
+
+// columnasRecepcionCompra columnas de recepciones_compra en el orden esperado por scanRecepcionCompra
+const columnasRecepcionCompra = `id, numero_recepcion, proveedor_id, sucursal_id, orden_compra_id, tipo_documento,
+		numero_documento, fecha_documento, fecha_vencimiento, monto_neto, monto_iva, monto_total,
+		COALESCE(monto_pagado, 0), estado_pago, observaciones, usuario_id, fecha_recepcion, batch_id,
+		trabajo_etiquetas_id`
+
+// scanRecepcionCompra lee una recepción desde una fila
+func scanRecepcionCompra(row interface{ Scan(...interface{}) error }) (*models.RecepcionCompra, error) {
+	var r models.RecepcionCompra
+	err := row.Scan(&r.ID, &r.NumeroRecepcion, &r.ProveedorID, &r.SucursalID, &r.OrdenCompraID, &r.TipoDocumento,
+		&r.NumeroDocumento, &r.FechaDocumento, &r.FechaVencimiento, &r.MontoNeto, &r.MontoIVA, &r.MontoTotal,
+		&r.MontoPagado, &r.EstadoPago, &r.Observaciones, &r.UsuarioID, &r.FechaRecepcion, &r.BatchID,
+		&r.TrabajoEtiquetasID)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// getRecepcionCompra obtiene una recepción con su detalle
+func getRecepcionCompra(ctx context.Context, q sqlQueryer, id uuid.UUID, bloquear bool) (*models.RecepcionCompra, error) {
+	query := `SELECT ` + columnasRecepcionCompra + ` FROM recepciones_compra WHERE id = $1`
+	if bloquear {
+		query += ` FOR UPDATE`
+	}
+
+	r, err := scanRecepcionCompra(q.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, &apiError{
+			status:  http.StatusNotFound,
+			code:    "RECEPCION_NOT_FOUND",
+			message: "Recepción no encontrada",
+			details: models.JSONB{"recepcion_id": id},
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT d.id, d.recepcion_id, d.producto_id, p.codigo_interno, p.descripcion, d.detalle_orden_id,
+			d.cantidad, d.costo_unitario, d.costo_promedio_anterior, d.costo_promedio_nuevo, d.lote,
+			d.fecha_vencimiento, d.numeros_serie
+		FROM detalle_recepciones_compra d
+		JOIN productos p ON p.id = d.producto_id
+		WHERE d.recepcion_id = $1
+		ORDER BY p.codigo_interno`,
+		r.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando detalle de recepción: %w", err)
+	}
+	err = database.ScanRows(rows, func() error {
+		var d models.DetalleRecepcionCompra
+		if err := rows.Scan(&d.ID, &d.RecepcionID, &d.ProductoID, &d.CodigoInterno, &d.Descripcion,
+			&d.DetalleOrdenID, &d.Cantidad, &d.CostoUnitario, &d.CostoPromedioAnterior, &d.CostoPromedioNuevo,
+			&d.Lote, &d.FechaVencimiento, pq.Array(&d.NumerosSerie)); err != nil {
+			return err
+		}
+		r.Detalles = append(r.Detalles, d)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo detalle de recepción: %w", err)
+	}
+
+	return r, nil
+}
+
+// consultarCuentasPorPagar agrupa por proveedor el saldo pendiente de sus documentos en tramos de atraso
+// respecto del vencimiento a la fecha de corte
+func consultarCuentasPorPagar(ctx context.Context, q sqlQueryer, corte time.Time, filtro string, args ...interface{}) ([]models.CuentaPorPagar, error) {
+	query := `
+		WITH pendientes AS (
+			SELECT r.proveedor_id, r.fecha_vencimiento, r.monto_total - COALESCE(r.monto_pagado, 0) AS saldo,
+				$1::date - r.fecha_vencimiento AS dias_atraso
+			FROM recepciones_compra r
+			WHERE r.estado_pago <> 'pagada'`
+	if filtro != "" {
+		query += ` AND ` + filtro
+	}
+	query += `
+		)
+		SELECT pr.id, pr.rut, pr.razon_social, pr.dias_credito, COUNT(*),
+			MIN(p.fecha_vencimiento) FILTER (WHERE p.dias_atraso <= 0),
+			COALESCE(SUM(p.saldo) FILTER (WHERE p.dias_atraso <= 0), 0),
+			COALESCE(SUM(p.saldo) FILTER (WHERE p.dias_atraso BETWEEN 1 AND 30), 0),
+			COALESCE(SUM(p.saldo) FILTER (WHERE p.dias_atraso BETWEEN 31 AND 60), 0),
+			COALESCE(SUM(p.saldo) FILTER (WHERE p.dias_atraso BETWEEN 61 AND 90), 0),
+			COALESCE(SUM(p.saldo) FILTER (WHERE p.dias_atraso > 90), 0),
+			SUM(p.saldo)
+		FROM pendientes p
+		JOIN proveedores pr ON pr.id = p.proveedor_id
+		WHERE p.saldo > 0
+		GROUP BY pr.id, pr.rut, pr.razon_social, pr.dias_credito
+		ORDER BY SUM(p.saldo) FILTER (WHERE p.dias_atraso > 0) DESC NULLS LAST, pr.razon_social`
+
+	rows, err := q.QueryContext(ctx, query, append([]interface{}{corte}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	cuentas := []models.CuentaPorPagar{}
+	err = database.ScanRows(rows, func() error {
+		var cp models.CuentaPorPagar
+		a := &cp.Antiguedad
+		if err := rows.Scan(
+			&cp.ProveedorID, &cp.RUT, &cp.RazonSocial, &cp.DiasCredito, &cp.Documentos, &cp.ProximoVencimiento,
+			&a.PorVencer, &a.Dias1a30, &a.Dias31a60, &a.Dias61a90, &a.Mas90, &a.Total,
+		); err != nil {
+			return err
+		}
+		cuentas = append(cuentas, cp)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cuentas, nil
+}
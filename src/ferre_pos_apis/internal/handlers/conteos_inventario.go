@@ -0,0 +1,941 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+// ConteosInventarioHandler handler para tomas de inventario físico y ajuste de diferencias
+type ConteosInventarioHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+}
+
+// NewConteosInventarioHandler crea un nuevo handler de conteos de inventario
+func NewConteosInventarioHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *ConteosInventarioHandler {
+	return &ConteosInventarioHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+	}
+}
+
+// Create abre un conteo congelando el stock de los productos de su alcance. Un producto no puede
+// estar en dos conteos vigentes de la misma sucursal
+func (h *ConteosInventarioHandler) Create(c *gin.Context) {
+	var req models.AbrirConteoRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	var conteo *models.ConteoInventario
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		// Serializa las aperturas de la sucursal para que el control de superposición sea consistente
+		var sucursalID uuid.UUID
+		err := tx.QueryRowContext(ctx,
+			`SELECT id FROM sucursales WHERE id = $1 FOR NO KEY UPDATE`, req.SucursalID,
+		).Scan(&sucursalID)
+		if err == sql.ErrNoRows {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "SUCURSAL_NOT_FOUND",
+				message: "Sucursal no encontrada",
+				details: models.JSONB{"sucursal_id": req.SucursalID},
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("error consultando sucursal: %w", err)
+		}
+
+		id := uuid.New()
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO conteos_inventario (
+				id, sucursal_id, tipo, categoria_id, pasillo, estante, observaciones, usuario_apertura_id
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			id, req.SucursalID, req.Tipo, req.CategoriaID, req.Pasillo, req.Estante, req.Observaciones, usuarioID,
+		)
+		if err != nil {
+			return fmt.Errorf("error insertando conteo: %w", err)
+		}
+
+		total, err := congelarStockConteo(ctx, tx, id, &req)
+		if err != nil {
+			return err
+		}
+		if total == 0 {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "CONTEO_SIN_PRODUCTOS",
+				message: "No hay productos con stock o ubicación en el alcance indicado",
+			}
+		}
+
+		superpuestos, err := productosEnOtrosConteos(ctx, tx, id, req.SucursalID)
+		if err != nil {
+			return err
+		}
+		if len(superpuestos) > 0 {
+			return &apiError{
+				status:  http.StatusConflict,
+				code:    "CONTEO_SUPERPUESTO",
+				message: "Hay productos del alcance en otro conteo vigente de la sucursal",
+				details: models.JSONB{"productos": superpuestos},
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE conteos_inventario SET total_productos = $2 WHERE id = $1`, id, total,
+		); err != nil {
+			return fmt.Errorf("error actualizando conteo: %w", err)
+		}
+
+		conteo, err = getConteo(ctx, tx, id, false)
+		return err
+	})
+	if err != nil {
+		h.responderError(c, err, "Error abriendo conteo de inventario")
+		return
+	}
+
+	h.logger.WithField("conteo_id", conteo.ID).
+		WithField("numero_conteo", conteo.NumeroConteo).
+		WithField("sucursal_id", conteo.SucursalID).
+		WithField("total_productos", conteo.TotalProductos).
+		Info("Conteo de inventario abierto")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      conteo,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// List lista los conteos de la sucursal
+func (h *ConteosInventarioHandler) List(c *gin.Context) {
+	sucursalID := getSucursalID(c)
+	if _, err := uuid.Parse(sucursalID); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_SUCURSAL_ID",
+				Message: "ID de sucursal inválido",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	filtro := `WHERE sucursal_id = $1`
+	args := []interface{}{sucursalID}
+	if estado := c.Query("estado"); estado != "" {
+		args = append(args, estado)
+		filtro += ` AND estado = $` + strconv.Itoa(len(args))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.db.QueryContext(ctx,
+		`SELECT `+columnasConteo+` FROM conteos_inventario c `+filtro+` ORDER BY fecha_apertura DESC LIMIT 100`,
+		args...,
+	)
+	if err != nil {
+		h.responderError(c, fmt.Errorf("error consultando conteos: %w", err), "Error listando conteos de inventario")
+		return
+	}
+
+	conteos := []models.ConteoInventario{}
+	err = database.ScanRows(rows, func() error {
+		conteo, err := scanConteo(rows)
+		if err != nil {
+			return err
+		}
+		conteos = append(conteos, *conteo)
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, fmt.Errorf("error leyendo conteos: %w", err), "Error listando conteos de inventario")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      conteos,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetByID obtiene un conteo con su avance
+func (h *ConteosInventarioHandler) GetByID(c *gin.Context) {
+	conteoID, ok := uuidParam(c, "id", "INVALID_CONTEO_ID", "ID de conteo inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conteo, err := getConteo(ctx, h.db, conteoID, false)
+	if err != nil {
+		h.responderError(c, err, "Error consultando conteo de inventario")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      conteo,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// RegistrarLecturas suma las lecturas de un terminal a la ronda vigente de cada producto. Varios terminales
+// pueden leer el mismo conteo a la vez; mientras haya líneas en reconteo solo se aceptan lecturas de ellas
+func (h *ConteosInventarioHandler) RegistrarLecturas(c *gin.Context) {
+	var req models.LecturasConteoRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	conteoID, ok := uuidParam(c, "id", "INVALID_CONTEO_ID", "ID de conteo inválido")
+	if !ok {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var lineas []models.DetalleConteoInventario
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		// FOR SHARE permite lecturas concurrentes y bloquea el cierre mientras se registran
+		var estado string
+		var enReconteo bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT estado, EXISTS (
+				SELECT 1 FROM detalle_conteo_inventario WHERE conteo_id = c.id AND en_reconteo = true
+			)
+			FROM conteos_inventario c
+			WHERE id = $1
+			FOR SHARE`,
+			conteoID,
+		).Scan(&estado, &enReconteo)
+		if err != nil {
+			return err
+		}
+		if estado != "abierto" {
+			return &apiError{
+				status:  http.StatusConflict,
+				code:    "CONTEO_NO_MODIFICABLE",
+				message: fmt.Sprintf("El conteo está %s", strings.ReplaceAll(estado, "_", " ")),
+				details: models.JSONB{"conteo_id": conteoID, "estado": estado},
+			}
+		}
+
+		var productos []uuid.UUID
+		for _, lectura := range req.Lecturas {
+			productoID, cantidad, codigo, err := resolverLecturaConteo(ctx, tx, &lectura)
+			if err != nil {
+				return err
+			}
+			if err := registrarLecturaConteo(ctx, tx, conteoID, productoID, cantidad, codigo, req.TerminalID, usuarioID, enReconteo); err != nil {
+				return err
+			}
+			productos = append(productos, productoID)
+		}
+
+		lineas, err = listarLineasConteo(ctx, tx, conteoID, `AND d.producto_id = ANY($2::uuid[])`, pq.Array(uuidsTexto(productos)))
+		return err
+	})
+	if err != nil {
+		h.responderError(c, err, "Error registrando lecturas de conteo")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      lineas,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// SolicitarReconteo descarta lo contado de las líneas en disputa y abre una nueva ronda para ellas.
+// Un conteo en revisión vuelve a quedar abierto
+func (h *ConteosInventarioHandler) SolicitarReconteo(c *gin.Context) {
+	var req models.ReconteoRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	h.modificarConteo(c, "Error solicitando reconteo", func(ctx context.Context, tx *sql.Tx, conteo *models.ConteoInventario, usuarioID uuid.UUID) error {
+		if conteo.Estado != "abierto" && conteo.Estado != "en_revision" {
+			return errConteoNoModificable(conteo)
+		}
+
+		productos := normalizarUUIDs(req.ProductoIDs)
+		res, err := tx.ExecContext(ctx, `
+			UPDATE detalle_conteo_inventario
+			SET ronda = ronda + 1, en_reconteo = true, cantidad_contada = NULL
+			WHERE conteo_id = $1 AND producto_id = ANY($2::uuid[])`,
+			conteo.ID, pq.Array(uuidsTexto(productos)),
+		)
+		if err != nil {
+			return fmt.Errorf("error marcando reconteo: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n != int64(len(productos)) {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "PRODUCTO_FUERA_DE_CONTEO",
+				message: "Hay productos que no forman parte del conteo",
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE conteos_inventario
+			SET estado = 'abierto', usuario_cierre_id = NULL, fecha_cierre = NULL
+			WHERE id = $1`,
+			conteo.ID,
+		)
+		if err != nil {
+			return fmt.Errorf("error reabriendo conteo: %w", err)
+		}
+		return nil
+	})
+}
+
+// Cerrar termina la toma de lecturas y deja el conteo en revisión; las líneas en reconteo deben estar contadas
+func (h *ConteosInventarioHandler) Cerrar(c *gin.Context) {
+	h.modificarConteo(c, "Error cerrando conteo", func(ctx context.Context, tx *sql.Tx, conteo *models.ConteoInventario, usuarioID uuid.UUID) error {
+		if conteo.Estado != "abierto" {
+			return errConteoNoModificable(conteo)
+		}
+
+		pendientes, err := listarLineasConteo(ctx, tx, conteo.ID, `AND d.en_reconteo = true AND d.cantidad_contada IS NULL`)
+		if err != nil {
+			return err
+		}
+		if len(pendientes) > 0 {
+			productos := make([]uuid.UUID, len(pendientes))
+			for i, l := range pendientes {
+				productos[i] = l.ProductoID
+			}
+			return &apiError{
+				status:  http.StatusConflict,
+				code:    "RECONTEO_PENDIENTE",
+				message: "Hay líneas en reconteo sin contar",
+				details: models.JSONB{"productos": productos},
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE detalle_conteo_inventario SET en_reconteo = false WHERE conteo_id = $1 AND en_reconteo = true`,
+			conteo.ID,
+		); err != nil {
+			return fmt.Errorf("error cerrando reconteo: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE conteos_inventario
+			SET estado = 'en_revision', usuario_cierre_id = $2, fecha_cierre = NOW()
+			WHERE id = $1`,
+			conteo.ID, usuarioID,
+		)
+		if err != nil {
+			return fmt.Errorf("error cerrando conteo: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetDiferencias reporta las diferencias entre lo contado y la foto de sistema valorizadas a costo promedio
+func (h *ConteosInventarioHandler) GetDiferencias(c *gin.Context) {
+	conteoID, ok := uuidParam(c, "id", "INVALID_CONTEO_ID", "ID de conteo inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	conteo, err := getConteo(ctx, h.db, conteoID, false)
+	if err != nil {
+		h.responderError(c, err, "Error consultando conteo de inventario")
+		return
+	}
+
+	lineas, err := listarLineasConteo(ctx, h.db, conteoID, "")
+	if err != nil {
+		h.responderError(c, err, "Error generando reporte de diferencias")
+		return
+	}
+
+	reporte := resumirDiferenciasConteo(conteo, lineas)
+	if c.Query("solo_diferencias") == "true" {
+		conDiferencia := []models.DetalleConteoInventario{}
+		for _, l := range reporte.Lineas {
+			if l.Diferencia == nil || *l.Diferencia != 0 {
+				conDiferencia = append(conDiferencia, l)
+			}
+		}
+		reporte.Lineas = conDiferencia
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      reporte,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Aprobar ajusta stock_central por la diferencia de cada línea respecto de la foto de apertura, de modo que
+// las ventas y entradas ocurridas durante el conteo se conservan. Todos los ajustes comparten un batch_id
+func (h *ConteosInventarioHandler) Aprobar(c *gin.Context) {
+	var req models.AprobarConteoRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	h.modificarConteo(c, "Error aprobando conteo", func(ctx context.Context, tx *sql.Tx, conteo *models.ConteoInventario, usuarioID uuid.UUID) error {
+		if conteo.Estado != "en_revision" {
+			return errConteoNoModificable(conteo)
+		}
+
+		lineas, err := listarLineasConteo(ctx, tx, conteo.ID, "")
+		if err != nil {
+			return err
+		}
+
+		// El orden por producto fija el orden de bloqueo de stock_central
+		sort.Slice(lineas, func(i, j int) bool {
+			return lineas[i].ProductoID.String() < lineas[j].ProductoID.String()
+		})
+
+		batchID := uuid.New()
+		documento := fmt.Sprintf("CONTEO-%d", conteo.NumeroConteo)
+		var valorNeto float64
+		ajustados := 0
+		for i := range lineas {
+			l := &lineas[i]
+			if l.CantidadContada == nil {
+				if !req.AjustarSinContar {
+					continue
+				}
+				cero := 0.0
+				l.CantidadContada = &cero
+			}
+
+			diferencia := redondearCantidad(*l.CantidadContada - l.CantidadSistema)
+			aplicado := 0.0
+			if diferencia != 0 {
+				anterior, nueva, err := ajustarStockConteo(ctx, tx, l.ProductoID, conteo.SucursalID, diferencia)
+				if err != nil {
+					return err
+				}
+				aplicado = redondearCantidad(nueva - anterior)
+				if aplicado != 0 {
+					err = registrarMovimientoStock(ctx, tx, "ajuste", l.ProductoID, conteo.SucursalID,
+						aplicado, anterior, l.CostoUnitario, documento, usuarioID, batchID,
+						models.JSONB{
+							"conteo_id":        conteo.ID,
+							"cantidad_sistema": l.CantidadSistema,
+							"cantidad_contada": *l.CantidadContada,
+							"diferencia":       diferencia,
+						},
+					)
+					if err != nil {
+						return err
+					}
+					ajustados++
+				}
+				if l.CostoUnitario != nil {
+					valorNeto += diferencia * *l.CostoUnitario
+				}
+			}
+
+			_, err := tx.ExecContext(ctx, `
+				UPDATE detalle_conteo_inventario
+				SET cantidad_contada = $2, cantidad_ajustada = $3
+				WHERE id = $1`,
+				l.ID, *l.CantidadContada, aplicado,
+			)
+			if err != nil {
+				return fmt.Errorf("error actualizando línea de conteo: %w", err)
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE conteos_inventario
+			SET estado = 'aprobado', usuario_aprobacion_id = $2, fecha_aprobacion = NOW(), batch_id = $3,
+				valor_diferencia = $4, observaciones = COALESCE($5, observaciones)
+			WHERE id = $1`,
+			conteo.ID, usuarioID, batchID, redondearMonto(valorNeto), req.Observaciones,
+		)
+		if err != nil {
+			return fmt.Errorf("error aprobando conteo: %w", err)
+		}
+
+		h.logger.WithField("conteo_id", conteo.ID).
+			WithField("batch_id", batchID).
+			WithField("ajustes", ajustados).
+			Info("Ajustes de conteo registrados")
+		return nil
+	})
+}
+
+// Cancelar anula un conteo no aprobado sin ajustar stock
+func (h *ConteosInventarioHandler) Cancelar(c *gin.Context) {
+	var req models.CancelarConteoRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	h.modificarConteo(c, "Error cancelando conteo", func(ctx context.Context, tx *sql.Tx, conteo *models.ConteoInventario, usuarioID uuid.UUID) error {
+		if conteo.Estado != "abierto" && conteo.Estado != "en_revision" {
+			return errConteoNoModificable(conteo)
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			UPDATE conteos_inventario
+			SET estado = 'cancelado', usuario_aprobacion_id = $2, fecha_aprobacion = NOW(), motivo_cancelacion = $3
+			WHERE id = $1`,
+			conteo.ID, usuarioID, req.Motivo,
+		)
+		if err != nil {
+			return fmt.Errorf("error cancelando conteo: %w", err)
+		}
+		return nil
+	})
+}
+
+// modificarConteo bloquea el conteo de la ruta, aplica el cambio y responde con el conteo actualizado
+func (h *ConteosInventarioHandler) modificarConteo(c *gin.Context, mensaje string, cambio func(ctx context.Context, tx *sql.Tx, conteo *models.ConteoInventario, usuarioID uuid.UUID) error) {
+	conteoID, ok := uuidParam(c, "id", "INVALID_CONTEO_ID", "ID de conteo inválido")
+	if !ok {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	var conteo *models.ConteoInventario
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		actual, err := getConteo(ctx, tx, conteoID, true)
+		if err != nil {
+			return err
+		}
+		if err := cambio(ctx, tx, actual, usuarioID); err != nil {
+			return err
+		}
+		conteo, err = getConteo(ctx, tx, conteoID, false)
+		return err
+	})
+	if err != nil {
+		h.responderError(c, err, mensaje)
+		return
+	}
+
+	h.logger.WithField("conteo_id", conteo.ID).
+		WithField("numero_conteo", conteo.NumeroConteo).
+		WithField("estado", conteo.Estado).
+		Info("Conteo de inventario actualizado")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      conteo,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// responderError responde errores de negocio, conteo inexistente o errores internos
+func (h *ConteosInventarioHandler) responderError(c *gin.Context, err error, mensaje string) {
+	if errors.Is(err, sql.ErrNoRows) {
+		err = &apiError{
+			status:  http.StatusNotFound,
+			code:    "CONTEO_NOT_FOUND",
+			message: "Conteo de inventario no encontrado",
+		}
+	}
+
+	responderError(c, h.logger, err, "CONTEO_ERROR", mensaje)
+}
+
+// columnasConteo columnas de conteos_inventario (alias c) en el orden esperado por scanConteo
+const columnasConteo = `c.id, c.numero_conteo, c.sucursal_id, c.tipo, c.estado, c.categoria_id, c.pasillo, c.estante,
+		c.observaciones, c.usuario_apertura_id, c.fecha_apertura, c.usuario_cierre_id, c.fecha_cierre,
+		c.usuario_aprobacion_id, c.fecha_aprobacion, c.motivo_cancelacion, c.batch_id, c.total_productos,
+		c.valor_diferencia,
+		(SELECT COUNT(*) FROM detalle_conteo_inventario d WHERE d.conteo_id = c.id AND d.cantidad_contada IS NOT NULL),
+		(SELECT COUNT(*) FROM detalle_conteo_inventario d WHERE d.conteo_id = c.id AND d.en_reconteo = true)`
+
+// scanConteo lee un conteo desde una fila
+func scanConteo(row interface{ Scan(...interface{}) error }) (*models.ConteoInventario, error) {
+	var conteo models.ConteoInventario
+	err := row.Scan(&conteo.ID, &conteo.NumeroConteo, &conteo.SucursalID, &conteo.Tipo, &conteo.Estado,
+		&conteo.CategoriaID, &conteo.Pasillo, &conteo.Estante, &conteo.Observaciones, &conteo.UsuarioAperturaID,
+		&conteo.FechaApertura, &conteo.UsuarioCierreID, &conteo.FechaCierre, &conteo.UsuarioAprobacionID,
+		&conteo.FechaAprobacion, &conteo.MotivoCancelacion, &conteo.BatchID, &conteo.TotalProductos,
+		&conteo.ValorDiferencia, &conteo.ProductosContados, &conteo.ProductosEnReconteo)
+	if err != nil {
+		return nil, err
+	}
+	return &conteo, nil
+}
+
+// getConteo obtiene un conteo, opcionalmente bloqueándolo para modificarlo
+func getConteo(ctx context.Context, q sqlQueryer, id uuid.UUID, bloquear bool) (*models.ConteoInventario, error) {
+	query := `SELECT ` + columnasConteo + ` FROM conteos_inventario c WHERE c.id = $1`
+	if bloquear {
+		query += ` FOR UPDATE`
+	}
+	return scanConteo(q.QueryRowContext(ctx, query, id))
+}
+
+// errConteoNoModificable error para operaciones sobre conteos en un estado que no las admite
+func errConteoNoModificable(conteo *models.ConteoInventario) error {
+	return &apiError{
+		status:  http.StatusConflict,
+		code:    "CONTEO_NO_MODIFICABLE",
+		message: fmt.Sprintf("El conteo está %s", strings.ReplaceAll(conteo.Estado, "_", " ")),
+		details: models.JSONB{"conteo_id": conteo.ID, "estado": conteo.Estado},
+	}
+}
+
+// congelarStockConteo registra la foto de stock de los productos activos del alcance que tienen stock o
+// ubicación en la sucursal. La categoría incluye sus subcategorías
+func congelarStockConteo(ctx context.Context, tx *sql.Tx, conteoID uuid.UUID, req *models.AbrirConteoRequest) (int, error) {
+	filtros := ""
+	args := []interface{}{conteoID, req.SucursalID}
+	if req.CategoriaID != nil {
+		args = append(args, *req.CategoriaID)
+		filtros += fmt.Sprintf(` AND p.categoria_id IN (
+			WITH RECURSIVE arbol AS (
+				SELECT id FROM categorias_productos WHERE id = $%d
+				UNION ALL
+				SELECT cp.id FROM categorias_productos cp JOIN arbol ON cp.categoria_padre_id = arbol.id
+			)
+			SELECT id FROM arbol)`, len(args))
+	}
+	if req.Pasillo != nil {
+		args = append(args, *req.Pasillo)
+		filtros += fmt.Sprintf(` AND u.pasillo = $%d`, len(args))
+	}
+	if req.Estante != nil {
+		args = append(args, *req.Estante)
+		filtros += fmt.Sprintf(` AND u.estante = $%d`, len(args))
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO detalle_conteo_inventario (conteo_id, producto_id, ubicacion, cantidad_sistema, costo_unitario)
+		SELECT $1, p.id, u.pasillo || '-' || u.estante || '-' || u.nivel,
+			COALESCE(sc.cantidad, 0), COALESCE(sc.costo_promedio, p.precio_costo)
+		FROM productos p
+		LEFT JOIN stock_central sc ON sc.producto_id = p.id AND sc.sucursal_id = $2
+		LEFT JOIN ubicaciones_producto u ON u.producto_id = p.id AND u.sucursal_id = $2
+		WHERE p.activo = true AND (sc.producto_id IS NOT NULL OR u.id IS NOT NULL)`+filtros,
+		args...,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error congelando stock del conteo: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error congelando stock del conteo: %w", err)
+	}
+	return int(n), nil
+}
+
+// productosEnOtrosConteos obtiene productos del conteo que también están en otro conteo vigente de la sucursal
+func productosEnOtrosConteos(ctx context.Context, tx *sql.Tx, conteoID, sucursalID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT DISTINCT d.producto_id
+		FROM detalle_conteo_inventario d
+		JOIN conteos_inventario c ON c.id = d.conteo_id
+		WHERE c.sucursal_id = $2 AND c.id <> $1 AND c.estado IN ('abierto', 'en_revision')
+			AND d.producto_id IN (SELECT producto_id FROM detalle_conteo_inventario WHERE conteo_id = $1)
+		LIMIT 50`,
+		conteoID, sucursalID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error verificando conteos vigentes: %w", err)
+	}
+
+	var productos []uuid.UUID
+	err = database.ScanRows(rows, func() error {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		productos = append(productos, id)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo conteos vigentes: %w", err)
+	}
+	return productos, nil
+}
+
+// resolverLecturaConteo obtiene el producto y la cantidad en unidad base de una lectura
+func resolverLecturaConteo(ctx context.Context, tx *sql.Tx, lectura *models.LecturaConteoItemRequest) (uuid.UUID, float64, *string, error) {
+	if lectura.ProductoID != nil {
+		return *lectura.ProductoID, redondearCantidad(lectura.Cantidad), nil, nil
+	}
+
+	codigo := strings.TrimSpace(*lectura.CodigoBarra)
+	productoID, factor, err := resolverCodigoDespacho(ctx, tx, codigo)
+	if err != nil {
+		return uuid.Nil, 0, nil, err
+	}
+	return productoID, redondearCantidad(lectura.Cantidad * factor), &codigo, nil
+}
+
+// registrarLecturaConteo guarda la lectura y la suma a lo contado en la ronda vigente del producto
+func registrarLecturaConteo(ctx context.Context, tx *sql.Tx, conteoID, productoID uuid.UUID, cantidad float64, codigo *string, terminalID *uuid.UUID, usuarioID uuid.UUID, soloReconteo bool) error {
+	var detalleID uuid.UUID
+	var ronda int
+	var enReconteo, permiteFraccion bool
+	err := tx.QueryRowContext(ctx, `
+		UPDATE detalle_conteo_inventario d
+		SET cantidad_contada = COALESCE(d.cantidad_contada, 0) + $3
+		FROM productos p
+		WHERE d.conteo_id = $1 AND d.producto_id = $2 AND p.id = d.producto_id
+		RETURNING d.id, d.ronda, d.en_reconteo, COALESCE(p.permite_fraccionamiento, false)`,
+		conteoID, productoID, cantidad,
+	).Scan(&detalleID, &ronda, &enReconteo, &permiteFraccion)
+	if err == sql.ErrNoRows {
+		return &apiError{
+			status:  http.StatusBadRequest,
+			code:    "PRODUCTO_FUERA_DE_CONTEO",
+			message: "El producto no forma parte del conteo",
+			details: models.JSONB{"producto_id": productoID},
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error sumando lectura de conteo: %w", err)
+	}
+
+	if soloReconteo && !enReconteo {
+		return &apiError{
+			status:  http.StatusConflict,
+			code:    "LINEA_NO_EN_RECONTEO",
+			message: "Durante el reconteo solo se aceptan lecturas de las líneas en disputa",
+			details: models.JSONB{"producto_id": productoID},
+		}
+	}
+	if !permiteFraccion && !esEntero(cantidad) {
+		return &apiError{
+			status:  http.StatusBadRequest,
+			code:    "CANTIDAD_FRACCIONADA_NO_PERMITIDA",
+			message: "El producto no permite cantidades fraccionadas",
+			details: models.JSONB{"producto_id": productoID, "cantidad": cantidad},
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO lecturas_conteo_inventario (conteo_id, detalle_id, ronda, cantidad, codigo_barra, terminal_id, usuario_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		conteoID, detalleID, ronda, cantidad, codigo, terminalID, usuarioID,
+	)
+	if err != nil {
+		return fmt.Errorf("error registrando lectura de conteo: %w", err)
+	}
+	return nil
+}
+
+// listarLineasConteo obtiene las líneas del conteo ordenadas por ubicación con las lecturas de su ronda vigente
+func listarLineasConteo(ctx context.Context, q sqlQueryer, conteoID uuid.UUID, filtro string, args ...interface{}) ([]models.DetalleConteoInventario, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT d.id, d.conteo_id, d.producto_id, p.codigo_interno, p.descripcion, d.ubicacion,
+			d.cantidad_sistema, d.costo_unitario, d.cantidad_contada, d.ronda, COALESCE(d.en_reconteo, false),
+			d.cantidad_ajustada, COALESCE(l.lecturas, 0), COALESCE(l.terminales, 0)
+		FROM detalle_conteo_inventario d
+		JOIN productos p ON p.id = d.producto_id
+		LEFT JOIN (
+			SELECT detalle_id, ronda, COUNT(*) AS lecturas, COUNT(DISTINCT terminal_id) AS terminales
+			FROM lecturas_conteo_inventario
+			WHERE conteo_id = $1
+			GROUP BY detalle_id, ronda
+		) l ON l.detalle_id = d.id AND l.ronda = d.ronda
+		WHERE d.conteo_id = $1 `+filtro+`
+		ORDER BY d.ubicacion NULLS LAST, p.codigo_interno`,
+		append([]interface{}{conteoID}, args...)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando líneas de conteo: %w", err)
+	}
+
+	lineas := []models.DetalleConteoInventario{}
+	err = database.ScanRows(rows, func() error {
+		var l models.DetalleConteoInventario
+		if err := rows.Scan(&l.ID, &l.ConteoID, &l.ProductoID, &l.CodigoInterno, &l.Descripcion, &l.Ubicacion,
+			&l.CantidadSistema, &l.CostoUnitario, &l.CantidadContada, &l.Ronda, &l.EnReconteo,
+			&l.CantidadAjustada, &l.Lecturas, &l.Terminales); err != nil {
+			return err
+		}
+		if l.CantidadContada != nil {
+			diferencia := redondearCantidad(*l.CantidadContada - l.CantidadSistema)
+			l.Diferencia = &diferencia
+			if l.CostoUnitario != nil {
+				valor := redondearMonto(diferencia * *l.CostoUnitario)
+				l.ValorDiferencia = &valor
+			}
+		}
+		lineas = append(lineas, l)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo líneas de conteo: %w", err)
+	}
+	return lineas, nil
+}
+
+// resumirDiferenciasConteo totaliza las diferencias valorizadas del conteo
+func resumirDiferenciasConteo(conteo *models.ConteoInventario, lineas []models.DetalleConteoInventario) *models.DiferenciasConteo {
+	reporte := &models.DiferenciasConteo{Conteo: *conteo, Lineas: lineas}
+	for _, l := range lineas {
+		if l.CostoUnitario != nil {
+			reporte.ValorSistema += l.CantidadSistema * *l.CostoUnitario
+		}
+		if l.CantidadContada == nil {
+			reporte.LineasSinContar++
+			continue
+		}
+		reporte.LineasContadas++
+		if *l.Diferencia != 0 {
+			reporte.LineasConDiferencia++
+		}
+		if l.ValorDiferencia == nil {
+			continue
+		}
+		if *l.ValorDiferencia > 0 {
+			reporte.ValorSobrante += *l.ValorDiferencia
+		} else {
+			reporte.ValorFaltante -= *l.ValorDiferencia
+		}
+	}
+
+	reporte.ValorSistema = redondearMonto(reporte.ValorSistema)
+	reporte.ValorSobrante = redondearMonto(reporte.ValorSobrante)
+	reporte.ValorFaltante = redondearMonto(reporte.ValorFaltante)
+	reporte.ValorNeto = redondearMonto(reporte.ValorSobrante - reporte.ValorFaltante)
+	if reporte.LineasContadas > 0 {
+		exactas := reporte.LineasContadas - reporte.LineasConDiferencia
+		reporte.Exactitud = math.Round(float64(exactas)*10000/float64(reporte.LineasContadas)) / 100
+	}
+	return reporte
+}
+
+// ajustarStockConteo aplica la diferencia del conteo sobre el stock vigente con control de concurrencia
+// optimista. El stock no baja de cero ni de lo reservado; devuelve la cantidad previa y la resultante
+func ajustarStockConteo(ctx context.Context, tx *sql.Tx, productoID, sucursalID uuid.UUID, diferencia float64) (float64, float64, error) {
+	for intento := 0; intento < intentosDescuentoStock; intento++ {
+		var actual, reservada float64
+		var version int
+		err := tx.QueryRowContext(ctx, `
+			SELECT COALESCE(cantidad, 0), COALESCE(cantidad_reservada, 0), version_optimistic_lock
+			FROM stock_central
+			WHERE producto_id = $1 AND sucursal_id = $2`,
+			productoID, sucursalID,
+		).Scan(&actual, &reservada, &version)
+		if err == sql.ErrNoRows {
+			if diferencia < 0 {
+				return 0, 0, nil
+			}
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO stock_central (producto_id, sucursal_id, cantidad)
+				VALUES ($1, $2, 0)
+				ON CONFLICT (producto_id, sucursal_id) DO NOTHING`,
+				productoID, sucursalID,
+			); err != nil {
+				return 0, 0, fmt.Errorf("error creando stock: %w", err)
+			}
+			continue
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("error consultando stock: %w", err)
+		}
+
+		nueva := redondearCantidad(math.Max(actual+diferencia, 0))
+		if nueva < reservada {
+			return 0, 0, &apiError{
+				status:  http.StatusConflict,
+				code:    "STOCK_RESERVADO",
+				message: "El ajuste deja el stock por debajo de lo reservado; libere las reservas del producto",
+				details: models.JSONB{"producto_id": productoID, "cantidad_reservada": reservada, "cantidad_ajustada": nueva},
+			}
+		}
+
+		result, err := tx.ExecContext(ctx, `
+			UPDATE stock_central
+			SET cantidad = $3,
+				version_optimistic_lock = version_optimistic_lock + 1,
+				fecha_sync = NOW()
+			WHERE producto_id = $1 AND sucursal_id = $2 AND version_optimistic_lock = $4`,
+			productoID, sucursalID, nueva, version,
+		)
+		if err != nil {
+			return 0, 0, fmt.Errorf("error ajustando stock: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 1 {
+			return actual, nueva, nil
+		}
+	}
+
+	return 0, 0, errConflictoStock(productoID)
+}
+
+// normalizarUUIDs descarta IDs repetidos conservando el orden
+func normalizarUUIDs(ids []uuid.UUID) []uuid.UUID {
+	vistos := make(map[uuid.UUID]bool, len(ids))
+	var unicos []uuid.UUID
+	for _, id := range ids {
+		if !vistos[id] {
+			vistos[id] = true
+			unicos = append(unicos, id)
+		}
+	}
+	return unicos
+}
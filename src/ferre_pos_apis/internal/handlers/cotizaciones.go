@@ -0,0 +1,785 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/pdf"
+	"ferre_pos_apis/pkg/validator"
+)
+
+// diasValidezCotizacion validez por defecto de una cotización
+const diasValidezCotizacion = 15
+
+// CotizacionesHandler handler para cotizaciones formales a contratistas
+type CotizacionesHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+	ventas    *VentasHandler
+}
+
+// NewCotizacionesHandler crea un nuevo handler de cotizaciones
+func NewCotizacionesHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *CotizacionesHandler {
+	return &CotizacionesHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+		ventas:    NewVentasHandler(db, log, val, met),
+	}
+}
+
+// Create crea una cotización congelando los precios vigentes de los productos
+func (h *CotizacionesHandler) Create(c *gin.Context) {
+	var req models.CotizacionRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	diasValidez := req.DiasValidez
+	if diasValidez == 0 {
+		diasValidez = diasValidezCotizacion
+	}
+
+	cotizacion := &models.Cotizacion{
+		ID:            uuid.New(),
+		SucursalID:    req.SucursalID,
+		ClienteRUT:    req.ClienteRUT,
+		ClienteNombre: req.ClienteNombre,
+		ClienteEmail:  req.ClienteEmail,
+		Estado:        "vigente",
+		Fecha:         time.Now().Truncate(time.Microsecond),
+		Observaciones: req.Observaciones,
+	}
+	cotizacion.FechaValidez = cotizacion.Fecha.AddDate(0, 0, diasValidez)
+
+	if uid, err := uuid.Parse(getUserID(c)); err == nil {
+		cotizacion.VendedorID = &uid
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		return createCotizacion(ctx, tx, cotizacion, req.Items)
+	})
+	if err != nil {
+		h.responderError(c, err, "Error creando cotización")
+		return
+	}
+
+	h.logger.WithField("cotizacion_id", cotizacion.ID).
+		WithField("numero_cotizacion", cotizacion.NumeroCotizacion).
+		Info("Cotización creada exitosamente")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      cotizacion,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// List lista cotizaciones de una sucursal, opcionalmente por estado o RUT de cliente
+func (h *CotizacionesHandler) List(c *gin.Context) {
+	sucursalID := getSucursalID(c)
+	if _, err := uuid.Parse(sucursalID); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_SUCURSAL_ID",
+				Message: "ID de sucursal inválido",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	query := `SELECT ` + columnasCotizacion + ` FROM cotizaciones WHERE sucursal_id = $1`
+	args := []interface{}{sucursalID}
+	if estado := c.Query("estado"); estado != "" {
+		args = append(args, estado)
+		query += ` AND estado = $` + strconv.Itoa(len(args))
+	}
+	if rut := c.Query("cliente_rut"); rut != "" {
+		args = append(args, rut)
+		query += ` AND cliente_rut = $` + strconv.Itoa(len(args))
+	}
+	query += ` ORDER BY fecha DESC LIMIT 200`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		h.responderError(c, err, "Error listando cotizaciones")
+		return
+	}
+
+	cotizaciones := []models.Cotizacion{}
+	err = database.ScanRows(rows, func() error {
+		cotizacion, err := scanCotizacion(rows)
+		if err != nil {
+			return err
+		}
+		cotizaciones = append(cotizaciones, *cotizacion)
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error listando cotizaciones")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      cotizaciones,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetByID obtiene una cotización con su detalle
+func (h *CotizacionesHandler) GetByID(c *gin.Context) {
+	cotizacionID, ok := uuidParam(c, "id", "INVALID_COTIZACION_ID", "ID de cotización inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cotizacion, err := getCotizacion(ctx, h.db, cotizacionID, false)
+	if err != nil {
+		h.responderError(c, err, "Error consultando cotización")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      cotizacion,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetPDF genera el documento PDF de la cotización para enviar al cliente
+func (h *CotizacionesHandler) GetPDF(c *gin.Context) {
+	cotizacionID, ok := uuidParam(c, "id", "INVALID_COTIZACION_ID", "ID de cotización inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cotizacion, err := getCotizacion(ctx, h.db, cotizacionID, false)
+	if err != nil {
+		h.responderError(c, err, "Error consultando cotización")
+		return
+	}
+
+	var sucursal models.Sucursal
+	err = h.db.QueryRowContext(ctx,
+		`SELECT nombre, direccion, comuna, telefono, email FROM sucursales WHERE id = $1`,
+		cotizacion.SucursalID,
+	).Scan(&sucursal.Nombre, &sucursal.Direccion, &sucursal.Comuna, &sucursal.Telefono, &sucursal.Email)
+	if err != nil {
+		h.responderError(c, err, "Error consultando sucursal de la cotización")
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="cotizacion-%d.pdf"`, cotizacion.NumeroCotizacion))
+	c.Data(http.StatusOK, "application/pdf", generarPDFCotizacion(cotizacion, &sucursal))
+}
+
+// Convertir convierte una cotización vigente en nota de venta o en venta, revalidando stock
+func (h *CotizacionesHandler) Convertir(c *gin.Context) {
+	start := time.Now()
+
+	cotizacionID, ok := uuidParam(c, "id", "INVALID_COTIZACION_ID", "ID de cotización inválido")
+	if !ok {
+		return
+	}
+
+	var req models.ConvertirCotizacionRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	if req.Destino == "venta" && (req.TerminalID == nil || req.TipoDocumento == "" || len(req.MediosPago) == 0) {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "DATOS_VENTA_REQUERIDOS",
+				Message: "La conversión a venta requiere terminal_id, tipo_documento y medios_pago",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+	if req.Destino == "nota_venta" && req.Despacho != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "DESPACHO_NO_PERMITIDO",
+				Message: "El despacho se informa al pagar la nota de venta",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var (
+		cotizacion *models.Cotizacion
+		nota       *models.NotaVenta
+		venta      *models.VentaResponse
+	)
+	txStart := time.Now()
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		cotizacion, err = getCotizacion(ctx, tx, cotizacionID, true)
+		if err != nil {
+			return err
+		}
+
+		if err := validarCotizacionConvertible(cotizacion); err != nil {
+			return err
+		}
+
+		// El stock reservado para la cotización queda disponible para su propia conversión
+		if err := consumirReservas(ctx, tx, "cotizacion", cotizacion.ID, &usuarioID); err != nil {
+			return err
+		}
+
+		if err := verificarStockCotizacion(ctx, tx, cotizacion); err != nil {
+			return err
+		}
+
+		if req.Destino == "nota_venta" {
+			nota = construirNotaDesdeCotizacion(cotizacion, &req)
+			if err := insertarNotaVenta(ctx, tx, nota); err != nil {
+				return err
+			}
+			cotizacion.NotaVentaID = &nota.ID
+		} else {
+			// Los precios y descuentos congelados en la cotización prevalecen sobre las promociones
+			ventaReq := construirVentaDesdeCotizacion(cotizacion, &req, usuarioID)
+			v, detalles, mediosPago, vErr := h.ventas.prepararVenta(ventaReq, nil)
+			if vErr != nil {
+				return vErr
+			}
+
+			if err := h.ventas.registrarVenta(ctx, tx, v, detalles, mediosPago, getUserRole(c), req.CodigoAutorizacionDescuento, req.Despacho, start); err != nil {
+				return err
+			}
+			cotizacion.VentaID = &v.ID
+
+			venta = &models.VentaResponse{
+				Venta:      *v,
+				Detalles:   detalles,
+				MediosPago: mediosPago,
+				Vuelto:     vueltoVenta(mediosPago),
+			}
+		}
+
+		ahora := time.Now()
+		cotizacion.Estado = "convertida"
+		cotizacion.FechaConversion = &ahora
+		cotizacion.UsuarioConversionID = &usuarioID
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE cotizaciones
+			SET estado = 'convertida', nota_venta_id = $2, venta_id = $3,
+				fecha_conversion = $4, usuario_conversion_id = $5
+			WHERE id = $1`,
+			cotizacion.ID, cotizacion.NotaVentaID, cotizacion.VentaID, ahora, usuarioID,
+		)
+		if err != nil {
+			return fmt.Errorf("error actualizando cotización: %w", err)
+		}
+
+		return nil
+	})
+	if h.metrics != nil {
+		h.metrics.RecordDatabaseTransaction("pos", time.Since(txStart), err)
+	}
+	if err != nil {
+		h.responderError(c, err, "Error convirtiendo cotización")
+		return
+	}
+
+	data := gin.H{"cotizacion": cotizacion}
+	if nota != nil {
+		data["nota_venta"] = nota
+	}
+	if venta != nil {
+		data["venta"] = venta
+		if h.metrics != nil {
+			h.metrics.RecordVenta("pos", venta.Venta.SucursalID.String(), venta.Venta.TipoDocumento, venta.Venta.Estado, venta.Venta.Total)
+		}
+	}
+
+	h.logger.WithField("cotizacion_id", cotizacion.ID).
+		WithField("destino", req.Destino).
+		Info("Cotización convertida")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      data,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Métodos auxiliares
+
+// responderError responde errores de negocio, cotización inexistente o errores internos
+func (h *CotizacionesHandler) responderError(c *gin.Context, err error, mensaje string) {
+	if errors.Is(err, sql.ErrNoRows) {
+		err = &apiError{
+			status:  http.StatusNotFound,
+			code:    "COTIZACION_NOT_FOUND",
+			message: "Cotización no encontrada",
+		}
+	}
+
+	responderError(c, h.logger, err, "COTIZACION_ERROR", mensaje)
+}
+
+// columnasCotizacion columnas de cotizaciones en el orden esperado por scanCotizacion
+const columnasCotizacion = `id, numero_cotizacion, sucursal_id, vendedor_id, cliente_rut, cliente_nombre,
+		cliente_email, subtotal, descuento_total, impuesto_total, total, estado, fecha,
+		fecha_validez, observaciones, nota_venta_id, venta_id, fecha_conversion,
+		usuario_conversion_id`
+
+// scanCotizacion lee una cotización desde una fila
+func scanCotizacion(row interface{ Scan(...interface{}) error }) (*models.Cotizacion, error) {
+	var c models.Cotizacion
+	err := row.Scan(
+		&c.ID, &c.NumeroCotizacion, &c.SucursalID, &c.VendedorID, &c.ClienteRUT,
+		&c.ClienteNombre, &c.ClienteEmail, &c.Subtotal, &c.DescuentoTotal, &c.ImpuestoTotal,
+		&c.Total, &c.Estado, &c.Fecha, &c.FechaValidez, &c.Observaciones, &c.NotaVentaID,
+		&c.VentaID, &c.FechaConversion, &c.UsuarioConversionID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// getCotizacion obtiene una cotización con su detalle
+func getCotizacion(ctx context.Context, q sqlQueryer, id uuid.UUID, bloquear bool) (*models.Cotizacion, error) {
+	query := `SELECT ` + columnasCotizacion + ` FROM cotizaciones WHERE id = $1`
+	if bloquear {
+		query += ` FOR UPDATE`
+	}
+
+	cotizacion, err := scanCotizacion(q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, cotizacion_id, producto_id, codigo_interno, descripcion, cantidad,
+			precio_unitario, descuento_unitario, total_item
+		FROM detalle_cotizaciones
+		WHERE cotizacion_id = $1
+		ORDER BY codigo_interno`,
+		cotizacion.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = database.ScanRows(rows, func() error {
+		var d models.DetalleCotizacion
+		if err := rows.Scan(
+			&d.ID, &d.CotizacionID, &d.ProductoID, &d.CodigoInterno, &d.Descripcion,
+			&d.Cantidad, &d.PrecioUnitario, &d.DescuentoUnitario, &d.TotalItem,
+		); err != nil {
+			return err
+		}
+		cotizacion.Detalles = append(cotizacion.Detalles, d)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cotizacion, nil
+}
+
+// createCotizacion inserta la cotización con su detalle congelando precio y descripción de cada producto
+func createCotizacion(ctx context.Context, tx *sql.Tx, cotizacion *models.Cotizacion, items []models.CotizacionItemRequest) error {
+	for i, item := range items {
+		d := models.DetalleCotizacion{
+			ID:                uuid.New(),
+			CotizacionID:      cotizacion.ID,
+			ProductoID:        item.ProductoID,
+			Cantidad:          item.Cantidad,
+			DescuentoUnitario: item.DescuentoUnitario,
+		}
+
+		err := tx.QueryRowContext(ctx, `
+			SELECT codigo_interno, descripcion, precio_unitario
+			FROM productos
+			WHERE id = $1 AND activo = true`,
+			item.ProductoID,
+		).Scan(&d.CodigoInterno, &d.Descripcion, &d.PrecioUnitario)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return &apiError{
+					status:  http.StatusBadRequest,
+					code:    "PRODUCT_NOT_FOUND",
+					message: "Producto no encontrado o inactivo",
+					details: models.JSONB{"item": i, "producto_id": item.ProductoID},
+				}
+			}
+			return fmt.Errorf("error consultando producto: %w", err)
+		}
+
+		if item.DescuentoUnitario > d.PrecioUnitario {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "INVALID_DISCOUNT",
+				message: "El descuento unitario no puede superar el precio unitario",
+				details: models.JSONB{"item": i, "producto_id": item.ProductoID},
+			}
+		}
+
+		d.TotalItem = redondearMonto((d.PrecioUnitario - d.DescuentoUnitario) * d.Cantidad)
+		cotizacion.Detalles = append(cotizacion.Detalles, d)
+
+		cotizacion.Subtotal += d.PrecioUnitario * d.Cantidad
+		cotizacion.DescuentoTotal += d.DescuentoUnitario * d.Cantidad
+	}
+
+	cotizacion.Subtotal = redondearMonto(cotizacion.Subtotal)
+	cotizacion.DescuentoTotal = redondearMonto(cotizacion.DescuentoTotal)
+	cotizacion.ImpuestoTotal = redondearMonto((cotizacion.Subtotal - cotizacion.DescuentoTotal) * tasaIVA)
+	cotizacion.Total = redondearMonto(cotizacion.Subtotal - cotizacion.DescuentoTotal + cotizacion.ImpuestoTotal)
+
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO cotizaciones (
+			id, sucursal_id, vendedor_id, cliente_rut, cliente_nombre, cliente_email,
+			subtotal, descuento_total, impuesto_total, total, estado, fecha, fecha_validez,
+			observaciones
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING numero_cotizacion`,
+		cotizacion.ID, cotizacion.SucursalID, cotizacion.VendedorID, cotizacion.ClienteRUT,
+		cotizacion.ClienteNombre, cotizacion.ClienteEmail, cotizacion.Subtotal,
+		cotizacion.DescuentoTotal, cotizacion.ImpuestoTotal, cotizacion.Total, cotizacion.Estado,
+		cotizacion.Fecha, cotizacion.FechaValidez, cotizacion.Observaciones,
+	).Scan(&cotizacion.NumeroCotizacion)
+	if err != nil {
+		return fmt.Errorf("error insertando cotización: %w", err)
+	}
+
+	for _, d := range cotizacion.Detalles {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO detalle_cotizaciones (
+				id, cotizacion_id, producto_id, codigo_interno, descripcion, cantidad,
+				precio_unitario, descuento_unitario, total_item
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			d.ID, d.CotizacionID, d.ProductoID, d.CodigoInterno, d.Descripcion, d.Cantidad,
+			d.PrecioUnitario, d.DescuentoUnitario, d.TotalItem,
+		)
+		if err != nil {
+			return fmt.Errorf("error insertando detalle de cotización: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// validarCotizacionConvertible verifica que la cotización siga vigente
+func validarCotizacionConvertible(cotizacion *models.Cotizacion) error {
+	if cotizacion.Estado != "vigente" {
+		return &apiError{
+			status:  http.StatusConflict,
+			code:    "COTIZACION_NO_VIGENTE",
+			message: fmt.Sprintf("La cotización se encuentra en estado %s", cotizacion.Estado),
+			details: models.JSONB{
+				"estado":        cotizacion.Estado,
+				"nota_venta_id": cotizacion.NotaVentaID,
+				"venta_id":      cotizacion.VentaID,
+			},
+		}
+	}
+
+	if cotizacion.FechaValidez.Before(time.Now()) {
+		return &apiError{
+			status:  http.StatusConflict,
+			code:    "COTIZACION_VENCIDA",
+			message: "La cotización se encuentra fuera de su fecha de validez",
+			details: models.JSONB{"fecha_validez": cotizacion.FechaValidez},
+		}
+	}
+
+	return nil
+}
+
+// verificarStockCotizacion revalida el stock de cada línea al momento de convertir
+func verificarStockCotizacion(ctx context.Context, tx *sql.Tx, cotizacion *models.Cotizacion) error {
+	var sinStock []models.JSONB
+	for _, d := range cotizacion.Detalles {
+		var disponible bool
+		err := tx.QueryRowContext(ctx,
+			`SELECT validar_stock_disponible_optimizado($1, $2, $3)`,
+			d.ProductoID, cotizacion.SucursalID, d.Cantidad,
+		).Scan(&disponible)
+		if err != nil {
+			return fmt.Errorf("error validando stock: %w", err)
+		}
+
+		if !disponible {
+			sinStock = append(sinStock, models.JSONB{
+				"producto_id":    d.ProductoID,
+				"codigo_interno": d.CodigoInterno,
+				"cantidad":       d.Cantidad,
+			})
+		}
+	}
+
+	if len(sinStock) > 0 {
+		return &apiError{
+			status:  http.StatusConflict,
+			code:    "STOCK_INSUFICIENTE",
+			message: "No hay stock suficiente para convertir la cotización",
+			details: models.JSONB{"productos": sinStock},
+		}
+	}
+
+	return nil
+}
+
+// construirNotaDesdeCotizacion arma la nota de venta con los precios congelados de la cotización
+func construirNotaDesdeCotizacion(cotizacion *models.Cotizacion, req *models.ConvertirCotizacionRequest) *models.NotaVenta {
+	ahora := time.Now()
+	vencimiento := ahora.Add(vigenciaNotaVentaHoras * time.Hour)
+	clienteRUT := cotizacion.ClienteRUT
+
+	nota := &models.NotaVenta{
+		ID:                  uuid.New(),
+		SucursalID:          cotizacion.SucursalID,
+		TerminalID:          req.TerminalID,
+		VendedorID:          cotizacion.VendedorID,
+		ClienteRUT:          &clienteRUT,
+		ClienteNombre:       cotizacion.ClienteNombre,
+		Subtotal:            cotizacion.Subtotal,
+		DescuentoTotal:      cotizacion.DescuentoTotal,
+		Total:               redondearMonto(cotizacion.Subtotal - cotizacion.DescuentoTotal),
+		Estado:              "pendiente",
+		Fecha:               ahora,
+		FechaVencimiento:    &vencimiento,
+		TiempoVigenciaHoras: vigenciaNotaVentaHoras,
+		PrioridadAtencion:   prioridadNotaVentaDefecto,
+	}
+
+	observaciones := fmt.Sprintf("Cotización N° %d", cotizacion.NumeroCotizacion)
+	if req.Observaciones != nil {
+		observaciones += " - " + *req.Observaciones
+	}
+	nota.Observaciones = &observaciones
+
+	for _, d := range cotizacion.Detalles {
+		nota.Detalles = append(nota.Detalles, models.DetalleNotaVenta{
+			ID:                       uuid.New(),
+			NotaVentaID:              nota.ID,
+			ProductoID:               d.ProductoID,
+			Cantidad:                 d.Cantidad,
+			PrecioUnitario:           d.PrecioUnitario,
+			DescuentoUnitario:        d.DescuentoUnitario,
+			TotalItem:                d.TotalItem,
+			DisponibilidadVerificada: true,
+			FechaVerificacionStock:   &ahora,
+		})
+	}
+
+	return nota
+}
+
+// construirVentaDesdeCotizacion arma la venta a registrar con los precios congelados de la cotización
+func construirVentaDesdeCotizacion(cotizacion *models.Cotizacion, req *models.ConvertirCotizacionRequest, cajeroID uuid.UUID) *models.VentaRequest {
+	clienteRUT := cotizacion.ClienteRUT
+	ventaReq := &models.VentaRequest{
+		SucursalID:    cotizacion.SucursalID,
+		TerminalID:    *req.TerminalID,
+		CajeroID:      cajeroID,
+		VendedorID:    cotizacion.VendedorID,
+		ClienteRUT:    &clienteRUT,
+		ClienteNombre: cotizacion.ClienteNombre,
+		TipoDocumento: req.TipoDocumento,
+		MediosPago:    req.MediosPago,
+		DatosAdicionales: models.JSONB{
+			"cotizacion_id":     cotizacion.ID,
+			"cotizacion_numero": cotizacion.NumeroCotizacion,
+		},
+		CodigoAutorizacionDescuento: req.CodigoAutorizacionDescuento,
+	}
+
+	for _, d := range cotizacion.Detalles {
+		ventaReq.Items = append(ventaReq.Items, models.VentaItemRequest{
+			ProductoID:        d.ProductoID,
+			Cantidad:          d.Cantidad,
+			PrecioUnitario:    d.PrecioUnitario,
+			DescuentoUnitario: d.DescuentoUnitario,
+		})
+	}
+
+	return ventaReq
+}
+
+// generarPDFCotizacion genera el documento PDF de la cotización
+func generarPDFCotizacion(cotizacion *models.Cotizacion, sucursal *models.Sucursal) []byte {
+	const (
+		margen     = 40.0
+		derecha    = pdf.AnchoA4 - margen
+		altoLinea  = 14.0
+		limiteInf  = 110.0
+		colCodigo  = margen
+		colDesc    = margen + 75
+		colCant    = 360.0
+		colPrecio  = 430.0
+		colDescto  = 490.0
+		tamanoBase = 9.0
+	)
+
+	doc := pdf.New()
+	y := pdf.AltoA4 - margen
+
+	encabezado := func() {
+		doc.Texto(colCodigo, y, tamanoBase, true, "Código")
+		doc.Texto(colDesc, y, tamanoBase, true, "Descripción")
+		doc.TextoDerecha(colCant, y, tamanoBase, true, "Cant.")
+		doc.TextoDerecha(colPrecio, y, tamanoBase, true, "Precio")
+		doc.TextoDerecha(colDescto, y, tamanoBase, true, "Dcto.")
+		doc.TextoDerecha(derecha, y, tamanoBase, true, "Total")
+		y -= 4
+		doc.Linea(margen, y, derecha, y)
+		y -= altoLinea
+	}
+
+	doc.Texto(margen, y, 16, true, sucursal.Nombre)
+	doc.TextoDerecha(derecha, y, 16, true, fmt.Sprintf("COTIZACIÓN N° %d", cotizacion.NumeroCotizacion))
+	y -= altoLinea + 4
+	for _, dato := range []*string{sucursal.Direccion, sucursal.Comuna, sucursal.Telefono, sucursal.Email} {
+		if dato != nil && *dato != "" {
+			doc.Texto(margen, y, tamanoBase, false, *dato)
+			y -= altoLinea
+		}
+	}
+
+	y -= altoLinea
+	doc.Texto(margen, y, 10, true, "Cliente")
+	doc.TextoDerecha(derecha, y, 10, false, "Fecha: "+cotizacion.Fecha.Format("02-01-2006"))
+	y -= altoLinea
+	doc.Texto(margen, y, tamanoBase, false, "RUT: "+cotizacion.ClienteRUT)
+	doc.TextoDerecha(derecha, y, 10, true, "Válida hasta: "+cotizacion.FechaValidez.Format("02-01-2006"))
+	y -= altoLinea
+	if cotizacion.ClienteNombre != nil {
+		doc.Texto(margen, y, tamanoBase, false, "Nombre: "+*cotizacion.ClienteNombre)
+		y -= altoLinea
+	}
+	if cotizacion.ClienteEmail != nil {
+		doc.Texto(margen, y, tamanoBase, false, "Email: "+*cotizacion.ClienteEmail)
+		y -= altoLinea
+	}
+
+	y -= altoLinea
+	encabezado()
+	for _, d := range cotizacion.Detalles {
+		if y < limiteInf {
+			doc.NuevaPagina()
+			y = pdf.AltoA4 - margen
+			encabezado()
+		}
+
+		descripcion := []rune(d.Descripcion)
+		if len(descripcion) > 48 {
+			descripcion = append(descripcion[:45], '.', '.', '.')
+		}
+		doc.Texto(colCodigo, y, tamanoBase, false, d.CodigoInterno)
+		doc.Texto(colDesc, y, tamanoBase, false, string(descripcion))
+		doc.TextoDerecha(colCant, y, tamanoBase, false, strconv.FormatFloat(d.Cantidad, 'f', -1, 64))
+		doc.TextoDerecha(colPrecio, y, tamanoBase, false, formatearPesos(d.PrecioUnitario))
+		doc.TextoDerecha(colDescto, y, tamanoBase, false, formatearPesos(d.DescuentoUnitario))
+		doc.TextoDerecha(derecha, y, tamanoBase, false, formatearPesos(d.TotalItem))
+		y -= altoLinea
+	}
+
+	if y < limiteInf {
+		doc.NuevaPagina()
+		y = pdf.AltoA4 - margen
+	}
+	doc.Linea(margen, y+4, derecha, y+4)
+	y -= altoLinea
+	for _, total := range []struct {
+		etiqueta string
+		monto    float64
+	}{
+		{"Subtotal", cotizacion.Subtotal},
+		{"Descuento", cotizacion.DescuentoTotal},
+		{"Neto", cotizacion.Subtotal - cotizacion.DescuentoTotal},
+		{"IVA 19%", cotizacion.ImpuestoTotal},
+	} {
+		doc.TextoDerecha(colDescto, y, tamanoBase, false, total.etiqueta)
+		doc.TextoDerecha(derecha, y, tamanoBase, false, formatearPesos(total.monto))
+		y -= altoLinea
+	}
+	doc.TextoDerecha(colDescto, y, 11, true, "TOTAL")
+	doc.TextoDerecha(derecha, y, 11, true, formatearPesos(cotizacion.Total))
+	y -= altoLinea * 2
+
+	if cotizacion.Observaciones != nil && *cotizacion.Observaciones != "" {
+		doc.Texto(margen, y, tamanoBase, false, "Observaciones: "+*cotizacion.Observaciones)
+		y -= altoLinea
+	}
+	doc.Texto(margen, y, 8, false, "Precios sujetos a disponibilidad de stock al momento de la compra.")
+
+	return doc.Bytes()
+}
+
+// formatearPesos formatea un monto en pesos chilenos con separador de miles
+func formatearPesos(monto float64) string {
+	entero := strconv.FormatInt(int64(math.Round(monto)), 10)
+	signo := ""
+	if entero[0] == '-' {
+		signo, entero = "-", entero[1:]
+	}
+
+	var out []byte
+	for i := range entero {
+		if i > 0 && (len(entero)-i)%3 == 0 {
+			out = append(out, '.')
+		}
+		out = append(out, entero[i])
+	}
+	return "$" + signo + string(out)
+}
@@ -0,0 +1,784 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+// diasPlazoCuentaCorriente condición de pago por defecto de una cuenta corriente
+const diasPlazoCuentaCorriente = 30
+
+// CuentasCorrientesHandler handler para cuentas corrientes de clientes empresa
+type CuentasCorrientesHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+}
+
+// NewCuentasCorrientesHandler crea un nuevo handler de cuentas corrientes
+func NewCuentasCorrientesHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *CuentasCorrientesHandler {
+	return &CuentasCorrientesHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+	}
+}
+
+// Create abre una cuenta corriente con su límite de crédito y condición de pago
+func (h *CuentasCorrientesHandler) Create(c *gin.Context) {
+	var req models.CuentaCorrienteRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	cuenta := &models.CuentaCorriente{ID: uuid.New(), Estado: "activa"}
+	aplicarCuentaCorrienteRequest(cuenta, &req)
+	if uid, err := uuid.Parse(getUserID(c)); err == nil {
+		cuenta.UsuarioCreacionID = &uid
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := h.db.QueryRowContext(ctx, `
+		INSERT INTO cuentas_corrientes (
+			id, cliente_rut, razon_social, giro, direccion, email, telefono,
+			limite_credito, dias_plazo, estado, usuario_creacion_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING fecha_creacion, fecha_modificacion`,
+		cuenta.ID, cuenta.ClienteRUT, cuenta.RazonSocial, cuenta.Giro, cuenta.Direccion,
+		cuenta.Email, cuenta.Telefono, cuenta.LimiteCredito, cuenta.DiasPlazo, cuenta.Estado,
+		cuenta.UsuarioCreacionID,
+	).Scan(&cuenta.FechaCreacion, &cuenta.FechaModificacion)
+	if err != nil {
+		h.responderError(c, err, "Error creando cuenta corriente")
+		return
+	}
+	cuenta.CreditoDisponible = cuenta.LimiteCredito
+
+	h.logger.WithField("cuenta_id", cuenta.ID).
+		WithField("cliente_rut", cuenta.ClienteRUT).
+		Info("Cuenta corriente creada")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      cuenta,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// List lista cuentas corrientes, opcionalmente por estado o búsqueda por RUT o razón social
+func (h *CuentasCorrientesHandler) List(c *gin.Context) {
+	query := `SELECT ` + columnasCuentaCorriente + ` FROM cuentas_corrientes WHERE 1 = 1`
+	var args []interface{}
+	if estado := c.Query("estado"); estado != "" {
+		args = append(args, estado)
+		query += ` AND estado = $` + strconv.Itoa(len(args))
+	}
+	if q := c.Query("q"); q != "" {
+		args = append(args, "%"+q+"%")
+		n := strconv.Itoa(len(args))
+		query += ` AND (cliente_rut ILIKE $` + n + ` OR razon_social ILIKE $` + n + `)`
+	}
+	query += ` ORDER BY razon_social LIMIT 200`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		h.responderError(c, err, "Error listando cuentas corrientes")
+		return
+	}
+
+	cuentas := []models.CuentaCorriente{}
+	err = database.ScanRows(rows, func() error {
+		cuenta, err := scanCuentaCorriente(rows)
+		if err != nil {
+			return err
+		}
+		cuentas = append(cuentas, *cuenta)
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error listando cuentas corrientes")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      cuentas,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetByID obtiene una cuenta corriente por ID
+func (h *CuentasCorrientesHandler) GetByID(c *gin.Context) {
+	cuentaID, ok := uuidParam(c, "id", "INVALID_CUENTA_ID", "ID de cuenta corriente inválido")
+	if !ok {
+		return
+	}
+
+	h.responderCuenta(c, "id", cuentaID)
+}
+
+// GetByRUT obtiene la cuenta corriente de un cliente, usado por caja antes de cargar una venta
+func (h *CuentasCorrientesHandler) GetByRUT(c *gin.Context) {
+	h.responderCuenta(c, "cliente_rut", c.Param("rut"))
+}
+
+// Update actualiza datos, límite de crédito, condición de pago o estado de la cuenta
+func (h *CuentasCorrientesHandler) Update(c *gin.Context) {
+	cuentaID, ok := uuidParam(c, "id", "INVALID_CUENTA_ID", "ID de cuenta corriente inválido")
+	if !ok {
+		return
+	}
+
+	var req models.CuentaCorrienteRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var cuenta *models.CuentaCorriente
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		cuenta, err = getCuentaCorriente(ctx, tx, "id", cuentaID, true)
+		if err != nil {
+			return err
+		}
+
+		aplicarCuentaCorrienteRequest(cuenta, &req)
+		return tx.QueryRowContext(ctx, `
+			UPDATE cuentas_corrientes
+			SET cliente_rut = $2, razon_social = $3, giro = $4, direccion = $5, email = $6,
+				telefono = $7, limite_credito = $8, dias_plazo = $9, estado = $10,
+				fecha_modificacion = NOW()
+			WHERE id = $1
+			RETURNING fecha_modificacion`,
+			cuenta.ID, cuenta.ClienteRUT, cuenta.RazonSocial, cuenta.Giro, cuenta.Direccion,
+			cuenta.Email, cuenta.Telefono, cuenta.LimiteCredito, cuenta.DiasPlazo, cuenta.Estado,
+		).Scan(&cuenta.FechaModificacion)
+	})
+	if err != nil {
+		h.responderError(c, err, "Error actualizando cuenta corriente")
+		return
+	}
+	cuenta.CreditoDisponible = creditoDisponible(cuenta)
+
+	h.logger.WithField("cuenta_id", cuenta.ID).
+		WithField("limite_credito", cuenta.LimiteCredito).
+		WithField("estado", cuenta.Estado).
+		Info("Cuenta corriente actualizada")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      cuenta,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetEstadoCuenta obtiene el estado de cuenta de un período (por defecto el mes en curso)
+func (h *CuentasCorrientesHandler) GetEstadoCuenta(c *gin.Context) {
+	cuentaID, ok := uuidParam(c, "id", "INVALID_CUENTA_ID", "ID de cuenta corriente inválido")
+	if !ok {
+		return
+	}
+
+	ahora := time.Now()
+	desde := time.Date(ahora.Year(), ahora.Month(), 1, 0, 0, 0, 0, ahora.Location())
+	hasta := time.Date(ahora.Year(), ahora.Month(), ahora.Day(), 0, 0, 0, 0, ahora.Location())
+	for param, fecha := range map[string]*time.Time{"desde": &desde, "hasta": &hasta} {
+		valor := c.Query(param)
+		if valor == "" {
+			continue
+		}
+
+		t, err := time.ParseInLocation("2006-01-02", valor, ahora.Location())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error: &models.APIError{
+					Code:    "INVALID_DATE",
+					Message: fmt.Sprintf("Fecha %s inválida, formato esperado AAAA-MM-DD", param),
+				},
+				RequestID: getRequestID(c),
+				Timestamp: time.Now(),
+			})
+			return
+		}
+		*fecha = t
+	}
+	// hasta incluye el día completo
+	hastaExclusivo := hasta.AddDate(0, 0, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	cuenta, err := getCuentaCorriente(ctx, h.db, "id", cuentaID, false)
+	if err != nil {
+		h.responderError(c, err, "Error consultando cuenta corriente")
+		return
+	}
+
+	estado := models.EstadoCuentaCorriente{
+		Cuenta:      *cuenta,
+		Desde:       desde,
+		Hasta:       hasta,
+		Movimientos: []models.MovimientoCuentaCorriente{},
+	}
+
+	err = h.db.QueryRowContext(ctx, `
+		SELECT saldo_nuevo FROM movimientos_cuenta_corriente
+		WHERE cuenta_id = $1 AND fecha < $2
+		ORDER BY fecha DESC
+		LIMIT 1`,
+		cuentaID, desde,
+	).Scan(&estado.SaldoInicial)
+	if err != nil && err != sql.ErrNoRows {
+		h.responderError(c, err, "Error consultando saldo inicial")
+		return
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT `+columnasMovimientoCuenta+`
+		FROM movimientos_cuenta_corriente
+		WHERE cuenta_id = $1 AND fecha >= $2 AND fecha < $3
+		ORDER BY fecha`,
+		cuentaID, desde, hastaExclusivo,
+	)
+	if err != nil {
+		h.responderError(c, err, "Error consultando movimientos de cuenta corriente")
+		return
+	}
+
+	err = database.ScanRows(rows, func() error {
+		m, err := scanMovimientoCuenta(rows)
+		if err != nil {
+			return err
+		}
+		if m.Monto > 0 {
+			estado.TotalCargos += m.Monto
+		} else {
+			estado.TotalAbonos -= m.Monto
+		}
+		estado.Movimientos = append(estado.Movimientos, *m)
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error consultando movimientos de cuenta corriente")
+		return
+	}
+
+	estado.TotalCargos = redondearMonto(estado.TotalCargos)
+	estado.TotalAbonos = redondearMonto(estado.TotalAbonos)
+	estado.SaldoFinal = redondearMonto(estado.SaldoInicial + estado.TotalCargos - estado.TotalAbonos)
+
+	deudas, err := consultarAntiguedadDeuda(ctx, h.db, ahora, `c.id = $2`, cuentaID)
+	if err != nil {
+		h.responderError(c, err, "Error calculando antigüedad de deuda")
+		return
+	}
+	if len(deudas) > 0 {
+		estado.Antiguedad = deudas[0].Antiguedad
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      estado,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// RegistrarPago registra un pago del cliente, imputándolo a los cargos más antiguos
+func (h *CuentasCorrientesHandler) RegistrarPago(c *gin.Context) {
+	cuentaID, ok := uuidParam(c, "id", "INVALID_CUENTA_ID", "ID de cuenta corriente inválido")
+	if !ok {
+		return
+	}
+
+	var req models.PagoCuentaCorrienteRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	movimiento := &models.MovimientoCuentaCorriente{
+		ID:            uuid.New(),
+		CuentaID:      cuentaID,
+		SucursalID:    req.SucursalID,
+		Tipo:          "pago",
+		Monto:         -redondearMonto(req.Monto),
+		MedioPago:     &req.MedioPago,
+		Referencia:    req.Referencia,
+		Observaciones: req.Observaciones,
+	}
+	if movimiento.SucursalID == nil {
+		if sid, err := uuid.Parse(getUserSucursalID(c)); err == nil {
+			movimiento.SucursalID = &sid
+		}
+	}
+	if uid, err := uuid.Parse(getUserID(c)); err == nil {
+		movimiento.UsuarioID = &uid
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var cuenta *models.CuentaCorriente
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		cuenta, err = getCuentaCorriente(ctx, tx, "id", cuentaID, true)
+		if err != nil {
+			return err
+		}
+
+		if -movimiento.Monto > cuenta.Saldo {
+			return &apiError{
+				status:  http.StatusConflict,
+				code:    "PAGO_EXCEDE_SALDO",
+				message: "El pago excede la deuda de la cuenta corriente",
+				details: models.JSONB{"saldo": cuenta.Saldo, "monto": -movimiento.Monto},
+			}
+		}
+
+		if err := registrarMovimientoCuenta(ctx, tx, cuenta, movimiento); err != nil {
+			return err
+		}
+
+		return imputarAbonoCuenta(ctx, tx, cuenta.ID, -movimiento.Monto)
+	})
+	if err != nil {
+		h.responderError(c, err, "Error registrando pago de cuenta corriente")
+		return
+	}
+
+	h.logger.WithField("cuenta_id", cuentaID).
+		WithField("monto", -movimiento.Monto).
+		WithField("medio_pago", req.MedioPago).
+		Info("Pago de cuenta corriente registrado")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      gin.H{"movimiento": movimiento, "cuenta": cuenta},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Métodos auxiliares
+
+// responderCuenta responde con la cuenta corriente encontrada por el campo indicado
+func (h *CuentasCorrientesHandler) responderCuenta(c *gin.Context, campo string, valor interface{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cuenta, err := getCuentaCorriente(ctx, h.db, campo, valor, false)
+	if err != nil {
+		h.responderError(c, err, "Error consultando cuenta corriente")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      cuenta,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// responderError responde errores de negocio, cuenta inexistente, RUT duplicado o errores internos
+func (h *CuentasCorrientesHandler) responderError(c *gin.Context, err error, mensaje string) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		err = &apiError{
+			status:  http.StatusNotFound,
+			code:    "CUENTA_CORRIENTE_NOT_FOUND",
+			message: "Cuenta corriente no encontrada",
+		}
+	case esViolacionUnicidad(err):
+		err = &apiError{
+			status:  http.StatusConflict,
+			code:    "CUENTA_CORRIENTE_DUPLICADA",
+			message: "El cliente ya tiene una cuenta corriente",
+		}
+	}
+
+	responderError(c, h.logger, err, "CUENTA_CORRIENTE_ERROR", mensaje)
+}
+
+// aplicarCuentaCorrienteRequest copia los datos editables del request a la cuenta
+func aplicarCuentaCorrienteRequest(cuenta *models.CuentaCorriente, req *models.CuentaCorrienteRequest) {
+	cuenta.ClienteRUT = req.ClienteRUT
+	cuenta.RazonSocial = req.RazonSocial
+	cuenta.Giro = req.Giro
+	cuenta.Direccion = req.Direccion
+	cuenta.Email = req.Email
+	cuenta.Telefono = req.Telefono
+	cuenta.LimiteCredito = redondearMonto(req.LimiteCredito)
+
+	if req.DiasPlazo != nil {
+		cuenta.DiasPlazo = *req.DiasPlazo
+	} else if cuenta.DiasPlazo == 0 {
+		cuenta.DiasPlazo = diasPlazoCuentaCorriente
+	}
+	if req.Estado != nil {
+		cuenta.Estado = *req.Estado
+	}
+}
+
+// creditoDisponible calcula el crédito que aún puede cargarse a la cuenta
+func creditoDisponible(cuenta *models.CuentaCorriente) float64 {
+	return math.Max(redondearMonto(cuenta.LimiteCredito-cuenta.Saldo), 0)
+}
+
+// columnasCuentaCorriente columnas de cuentas_corrientes en el orden esperado por scanCuentaCorriente
+const columnasCuentaCorriente = `id, cliente_rut, razon_social, giro, direccion, email, telefono,
+		limite_credito, dias_plazo, saldo, estado, usuario_creacion_id, fecha_creacion,
+		fecha_modificacion`
+
+// scanCuentaCorriente lee una cuenta corriente desde una fila
+func scanCuentaCorriente(row interface{ Scan(...interface{}) error }) (*models.CuentaCorriente, error) {
+	var cuenta models.CuentaCorriente
+	err := row.Scan(
+		&cuenta.ID, &cuenta.ClienteRUT, &cuenta.RazonSocial, &cuenta.Giro, &cuenta.Direccion,
+		&cuenta.Email, &cuenta.Telefono, &cuenta.LimiteCredito, &cuenta.DiasPlazo, &cuenta.Saldo,
+		&cuenta.Estado, &cuenta.UsuarioCreacionID, &cuenta.FechaCreacion, &cuenta.FechaModificacion,
+	)
+	if err != nil {
+		return nil, err
+	}
+	cuenta.CreditoDisponible = creditoDisponible(&cuenta)
+	return &cuenta, nil
+}
+
+// getCuentaCorriente obtiene una cuenta corriente filtrando por id o cliente_rut
+func getCuentaCorriente(ctx context.Context, q sqlQueryer, campo string, valor interface{}, bloquear bool) (*models.CuentaCorriente, error) {
+	query := `SELECT ` + columnasCuentaCorriente + ` FROM cuentas_corrientes WHERE ` + campo + ` = $1`
+	if bloquear {
+		query += ` FOR UPDATE`
+	}
+
+	return scanCuentaCorriente(q.QueryRowContext(ctx, query, valor))
+}
+
+// columnasMovimientoCuenta columnas de movimientos_cuenta_corriente en el orden esperado por scanMovimientoCuenta
+const columnasMovimientoCuenta = `id, cuenta_id, sucursal_id, tipo, monto, saldo_anterior, saldo_nuevo,
+		saldo_pendiente, fecha, fecha_vencimiento, venta_id, medio_pago, referencia,
+		observaciones, usuario_id`
+
+// scanMovimientoCuenta lee un movimiento de cuenta corriente desde una fila
+func scanMovimientoCuenta(row interface{ Scan(...interface{}) error }) (*models.MovimientoCuentaCorriente, error) {
+	var m models.MovimientoCuentaCorriente
+	err := row.Scan(
+		&m.ID, &m.CuentaID, &m.SucursalID, &m.Tipo, &m.Monto, &m.SaldoAnterior, &m.SaldoNuevo,
+		&m.SaldoPendiente, &m.Fecha, &m.FechaVencimiento, &m.VentaID, &m.MedioPago,
+		&m.Referencia, &m.Observaciones, &m.UsuarioID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// registrarMovimientoCuenta inserta el movimiento y actualiza el saldo de la cuenta bloqueada
+func registrarMovimientoCuenta(ctx context.Context, tx *sql.Tx, cuenta *models.CuentaCorriente, m *models.MovimientoCuentaCorriente) error {
+	m.SaldoAnterior = cuenta.Saldo
+	m.SaldoNuevo = redondearMonto(cuenta.Saldo + m.Monto)
+
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO movimientos_cuenta_corriente (
+			id, cuenta_id, sucursal_id, tipo, monto, saldo_anterior, saldo_nuevo,
+			saldo_pendiente, fecha_vencimiento, venta_id, medio_pago, referencia,
+			observaciones, usuario_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING fecha`,
+		m.ID, m.CuentaID, m.SucursalID, m.Tipo, m.Monto, m.SaldoAnterior, m.SaldoNuevo,
+		m.SaldoPendiente, m.FechaVencimiento, m.VentaID, m.MedioPago, m.Referencia,
+		m.Observaciones, m.UsuarioID,
+	).Scan(&m.Fecha)
+	if err != nil {
+		return fmt.Errorf("error insertando movimiento de cuenta corriente: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE cuentas_corrientes SET saldo = $2, fecha_modificacion = NOW() WHERE id = $1`,
+		cuenta.ID, m.SaldoNuevo,
+	)
+	if err != nil {
+		return fmt.Errorf("error actualizando saldo de cuenta corriente: %w", err)
+	}
+
+	cuenta.Saldo = m.SaldoNuevo
+	cuenta.CreditoDisponible = creditoDisponible(cuenta)
+	return nil
+}
+
+// imputarAbonoCuenta rebaja el saldo pendiente de los cargos partiendo por el de vencimiento más antiguo
+func imputarAbonoCuenta(ctx context.Context, tx *sql.Tx, cuentaID uuid.UUID, monto float64) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, saldo_pendiente
+		FROM movimientos_cuenta_corriente
+		WHERE cuenta_id = $1 AND saldo_pendiente > 0
+		ORDER BY fecha_vencimiento, fecha
+		FOR UPDATE`,
+		cuentaID,
+	)
+	if err != nil {
+		return fmt.Errorf("error consultando cargos pendientes: %w", err)
+	}
+
+	type cargoPendiente struct {
+		id        uuid.UUID
+		pendiente float64
+	}
+	var cargos []cargoPendiente
+	err = database.ScanRows(rows, func() error {
+		var cp cargoPendiente
+		if err := rows.Scan(&cp.id, &cp.pendiente); err != nil {
+			return err
+		}
+		cargos = append(cargos, cp)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error leyendo cargos pendientes: %w", err)
+	}
+
+	for _, cp := range cargos {
+		if monto <= 0 {
+			break
+		}
+
+		imputado := math.Min(monto, cp.pendiente)
+		_, err := tx.ExecContext(ctx,
+			`UPDATE movimientos_cuenta_corriente SET saldo_pendiente = $2 WHERE id = $1`,
+			cp.id, redondearMonto(cp.pendiente-imputado),
+		)
+		if err != nil {
+			return fmt.Errorf("error imputando abono: %w", err)
+		}
+		monto = redondearMonto(monto - imputado)
+	}
+
+	return nil
+}
+
+// cargarCuentaCorriente carga a la cuenta del cliente el monto pagado con cuenta_corriente
+func cargarCuentaCorriente(ctx context.Context, tx *sql.Tx, venta *models.Venta, mp *models.MedioPagoVenta) error {
+	if venta.ClienteRUT == nil || *venta.ClienteRUT == "" {
+		return &apiError{
+			status:  http.StatusBadRequest,
+			code:    "CLIENTE_REQUERIDO",
+			message: "El pago con cuenta corriente requiere informar el RUT del cliente",
+		}
+	}
+
+	cuenta, err := getCuentaCorriente(ctx, tx, "cliente_rut", *venta.ClienteRUT, true)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "CUENTA_CORRIENTE_NOT_FOUND",
+				message: "El cliente no tiene cuenta corriente",
+				details: models.JSONB{"cliente_rut": *venta.ClienteRUT},
+			}
+		}
+		return fmt.Errorf("error consultando cuenta corriente: %w", err)
+	}
+
+	if cuenta.Estado != "activa" {
+		return &apiError{
+			status:  http.StatusConflict,
+			code:    "CUENTA_CORRIENTE_BLOQUEADA",
+			message: fmt.Sprintf("La cuenta corriente se encuentra %s", cuenta.Estado),
+			details: models.JSONB{"cuenta_id": cuenta.ID, "estado": cuenta.Estado},
+		}
+	}
+
+	if mp.Monto > cuenta.CreditoDisponible {
+		return &apiError{
+			status:  http.StatusConflict,
+			code:    "CREDITO_INSUFICIENTE",
+			message: "El monto supera el crédito disponible de la cuenta corriente",
+			details: models.JSONB{
+				"limite_credito":     cuenta.LimiteCredito,
+				"saldo":              cuenta.Saldo,
+				"credito_disponible": cuenta.CreditoDisponible,
+				"monto":              mp.Monto,
+			},
+		}
+	}
+
+	vencimiento := venta.Fecha.AddDate(0, 0, cuenta.DiasPlazo)
+	referencia := fmt.Sprintf("Venta N° %d", venta.NumeroVenta)
+	movimiento := &models.MovimientoCuentaCorriente{
+		ID:               uuid.New(),
+		CuentaID:         cuenta.ID,
+		SucursalID:       &venta.SucursalID,
+		Tipo:             "cargo",
+		Monto:            mp.Monto,
+		SaldoPendiente:   mp.Monto,
+		FechaVencimiento: &vencimiento,
+		VentaID:          &venta.ID,
+		Referencia:       &referencia,
+		UsuarioID:        &venta.CajeroID,
+	}
+	if err := registrarMovimientoCuenta(ctx, tx, cuenta, movimiento); err != nil {
+		return err
+	}
+
+	if mp.DatosTransaccion == nil {
+		mp.DatosTransaccion = models.JSONB{}
+	}
+	mp.DatosTransaccion["cuenta_corriente_id"] = cuenta.ID
+	mp.DatosTransaccion["movimiento_id"] = movimiento.ID
+	mp.DatosTransaccion["fecha_vencimiento"] = vencimiento
+
+	return nil
+}
+
+// revertirCargosCuentaCorriente anula los cargos de la venta; lo ya pagado se imputa a otros cargos
+func revertirCargosCuentaCorriente(ctx context.Context, tx *sql.Tx, venta *models.Venta, usuarioID *uuid.UUID, motivo string) (float64, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, cuenta_id, monto, saldo_pendiente
+		FROM movimientos_cuenta_corriente
+		WHERE venta_id = $1 AND tipo = 'cargo'`,
+		venta.ID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error consultando cargos de cuenta corriente: %w", err)
+	}
+
+	type cargoVenta struct {
+		id, cuentaID     uuid.UUID
+		monto, pendiente float64
+	}
+	var cargos []cargoVenta
+	err = database.ScanRows(rows, func() error {
+		var cv cargoVenta
+		if err := rows.Scan(&cv.id, &cv.cuentaID, &cv.monto, &cv.pendiente); err != nil {
+			return err
+		}
+		cargos = append(cargos, cv)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error leyendo cargos de cuenta corriente: %w", err)
+	}
+
+	total := 0.0
+	for _, cv := range cargos {
+		cuenta, err := getCuentaCorriente(ctx, tx, "id", cv.cuentaID, true)
+		if err != nil {
+			return 0, fmt.Errorf("error consultando cuenta corriente: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE movimientos_cuenta_corriente SET saldo_pendiente = 0 WHERE id = $1`, cv.id)
+		if err != nil {
+			return 0, fmt.Errorf("error anulando cargo de cuenta corriente: %w", err)
+		}
+
+		referencia := fmt.Sprintf("Anulación venta N° %d", venta.NumeroVenta)
+		movimiento := &models.MovimientoCuentaCorriente{
+			ID:            uuid.New(),
+			CuentaID:      cuenta.ID,
+			SucursalID:    &venta.SucursalID,
+			Tipo:          "anulacion",
+			Monto:         -cv.monto,
+			VentaID:       &venta.ID,
+			Referencia:    &referencia,
+			Observaciones: &motivo,
+			UsuarioID:     usuarioID,
+		}
+		if err := registrarMovimientoCuenta(ctx, tx, cuenta, movimiento); err != nil {
+			return 0, err
+		}
+
+		// Lo que el cliente ya había pagado de este cargo queda a favor de sus otros cargos
+		if pagado := redondearMonto(cv.monto - cv.pendiente); pagado > 0 {
+			if err := imputarAbonoCuenta(ctx, tx, cuenta.ID, pagado); err != nil {
+				return 0, err
+			}
+		}
+
+		total += cv.monto
+	}
+
+	return redondearMonto(total), nil
+}
+
+// consultarAntiguedadDeuda agrupa la deuda pendiente de cada cuenta por días de atraso a la fecha de corte.
+// El filtro adicional usa parámetros desde $2.
+func consultarAntiguedadDeuda(ctx context.Context, q sqlQueryer, corte time.Time, filtro string, args ...interface{}) ([]models.CuentaPorCobrar, error) {
+	query := `
+		WITH pendientes AS (
+			SELECT m.cuenta_id, m.saldo_pendiente,
+				$1::date - COALESCE(m.fecha_vencimiento, m.fecha)::date AS dias_atraso
+			FROM movimientos_cuenta_corriente m
+			JOIN cuentas_corrientes c ON c.id = m.cuenta_id
+			WHERE m.saldo_pendiente > 0`
+	if filtro != "" {
+		query += ` AND ` + filtro
+	}
+	query += `
+		)
+		SELECT c.id, c.cliente_rut, c.razon_social, c.limite_credito, c.saldo, c.estado,
+			COALESCE(SUM(p.saldo_pendiente) FILTER (WHERE p.dias_atraso <= 0), 0),
+			COALESCE(SUM(p.saldo_pendiente) FILTER (WHERE p.dias_atraso BETWEEN 1 AND 30), 0),
+			COALESCE(SUM(p.saldo_pendiente) FILTER (WHERE p.dias_atraso BETWEEN 31 AND 60), 0),
+			COALESCE(SUM(p.saldo_pendiente) FILTER (WHERE p.dias_atraso BETWEEN 61 AND 90), 0),
+			COALESCE(SUM(p.saldo_pendiente) FILTER (WHERE p.dias_atraso > 90), 0),
+			SUM(p.saldo_pendiente)
+		FROM pendientes p
+		JOIN cuentas_corrientes c ON c.id = p.cuenta_id
+		GROUP BY c.id, c.cliente_rut, c.razon_social, c.limite_credito, c.saldo, c.estado
+		ORDER BY SUM(p.saldo_pendiente) FILTER (WHERE p.dias_atraso > 0) DESC NULLS LAST, c.razon_social`
+
+	rows, err := q.QueryContext(ctx, query, append([]interface{}{corte}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	cuentas := []models.CuentaPorCobrar{}
+	err = database.ScanRows(rows, func() error {
+		var cp models.CuentaPorCobrar
+		a := &cp.Antiguedad
+		if err := rows.Scan(
+			&cp.CuentaID, &cp.ClienteRUT, &cp.RazonSocial, &cp.LimiteCredito, &cp.Saldo, &cp.Estado,
+			&a.PorVencer, &a.Dias1a30, &a.Dias31a60, &a.Dias61a90, &a.Mas90, &a.Total,
+		); err != nil {
+			return err
+		}
+		cuentas = append(cuentas, cp)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cuentas, nil
+}
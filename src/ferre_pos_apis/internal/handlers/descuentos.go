@@ -0,0 +1,418 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+const (
+	// vigenciaAutorizacionDescuentoMinutos vigencia por defecto de un código de autorización
+	vigenciaAutorizacionDescuentoMinutos = 10
+	// intentosCodigoAutorizacion reintentos ante colisión de códigos vigentes
+	intentosCodigoAutorizacion = 3
+)
+
+// DescuentosHandler handler para políticas y autorizaciones de descuento
+type DescuentosHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+}
+
+// NewDescuentosHandler crea un nuevo handler de descuentos
+func NewDescuentosHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *DescuentosHandler {
+	return &DescuentosHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+	}
+}
+
+// ListPoliticas lista las políticas de descuento, opcionalmente filtradas por rol
+func (h *DescuentosHandler) ListPoliticas(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT id, rol, categoria_id, porcentaje_maximo, monto_maximo, activo,
+			fecha_creacion, fecha_modificacion
+		FROM politicas_descuento`
+	var args []interface{}
+	if rol := c.Query("rol"); rol != "" {
+		query += " WHERE rol = $1"
+		args = append(args, rol)
+	}
+	query += " ORDER BY rol, categoria_id NULLS FIRST"
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		responderError(c, h.logger, err, "DATABASE_ERROR", "Error consultando políticas de descuento")
+		return
+	}
+
+	politicas := []models.PoliticaDescuento{}
+	err = database.ScanRows(rows, func() error {
+		var p models.PoliticaDescuento
+		if err := rows.Scan(&p.ID, &p.Rol, &p.CategoriaID, &p.PorcentajeMaximo, &p.MontoMaximo,
+			&p.Activo, &p.FechaCreacion, &p.FechaModificacion); err != nil {
+			return err
+		}
+		politicas = append(politicas, p)
+		return nil
+	})
+	if err != nil {
+		responderError(c, h.logger, err, "DATABASE_ERROR", "Error leyendo políticas de descuento")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      politicas,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GuardarPolitica crea o actualiza la política de un rol y categoría
+func (h *DescuentosHandler) GuardarPolitica(c *gin.Context) {
+	var req models.PoliticaDescuentoRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	activo := true
+	if req.Activo != nil {
+		activo = *req.Activo
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var p models.PoliticaDescuento
+	err := h.db.QueryRowContext(ctx, `
+		INSERT INTO politicas_descuento (rol, categoria_id, porcentaje_maximo, monto_maximo, activo)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (rol, COALESCE(categoria_id, '00000000-0000-0000-0000-000000000000'::uuid))
+		DO UPDATE SET porcentaje_maximo = EXCLUDED.porcentaje_maximo,
+			monto_maximo = EXCLUDED.monto_maximo,
+			activo = EXCLUDED.activo,
+			fecha_modificacion = NOW()
+		RETURNING id, rol, categoria_id, porcentaje_maximo, monto_maximo, activo,
+			fecha_creacion, fecha_modificacion`,
+		req.Rol, req.CategoriaID, req.PorcentajeMaximo, req.MontoMaximo, activo,
+	).Scan(&p.ID, &p.Rol, &p.CategoriaID, &p.PorcentajeMaximo, &p.MontoMaximo,
+		&p.Activo, &p.FechaCreacion, &p.FechaModificacion)
+	if err != nil {
+		responderError(c, h.logger, err, "DATABASE_ERROR", "Error guardando política de descuento")
+		return
+	}
+
+	h.logger.WithField("rol", p.Rol).
+		WithField("porcentaje_maximo", p.PorcentajeMaximo).
+		Info("Política de descuento actualizada")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      p,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// CrearAutorizacion emite un código de un solo uso para autorizar un descuento sobre el límite
+func (h *DescuentosHandler) CrearAutorizacion(c *gin.Context) {
+	var req models.AutorizacionDescuentoRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	supervisorID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	// Los supervisores solo autorizan descuentos en su propia sucursal
+	sucursalUsuario := getUserSucursalID(c)
+	sucursalID := sucursalUsuario
+	if req.SucursalID != nil {
+		sucursalID = req.SucursalID.String()
+	}
+	if getUserRole(c) != string(models.RolAdmin) && sucursalID != sucursalUsuario {
+		c.JSON(http.StatusForbidden, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "SUCURSAL_NO_AUTORIZADA",
+				Message: "Solo puede autorizar descuentos en su sucursal",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	autorizacion := models.AutorizacionDescuento{
+		ID:           uuid.New(),
+		SupervisorID: supervisorID,
+		Motivo:       req.Motivo,
+	}
+	var err error
+	if autorizacion.SucursalID, err = uuid.Parse(sucursalID); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_SUCURSAL_ID",
+				Message: "ID de sucursal inválido",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	vigencia := req.VigenciaMinutos
+	if vigencia == 0 {
+		vigencia = vigenciaAutorizacionDescuentoMinutos
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var codigo string
+	for intento := 0; intento < intentosCodigoAutorizacion; intento++ {
+		codigo, err = generarCodigoAutorizacion()
+		if err != nil {
+			break
+		}
+
+		err = h.db.QueryRowContext(ctx, `
+			INSERT INTO autorizaciones_descuento (
+				id, codigo_hash, supervisor_id, sucursal_id, motivo, fecha_expiracion
+			) VALUES ($1, $2, $3, $4, $5, NOW() + make_interval(mins => $6))
+			ON CONFLICT (codigo_hash) WHERE usada = false DO NOTHING
+			RETURNING fecha_creacion, fecha_expiracion`,
+			autorizacion.ID, hashCodigoAutorizacion(codigo), autorizacion.SupervisorID,
+			autorizacion.SucursalID, autorizacion.Motivo, vigencia,
+		).Scan(&autorizacion.FechaCreacion, &autorizacion.FechaExpiracion)
+		if err != sql.ErrNoRows {
+			break
+		}
+	}
+	if err != nil {
+		responderError(c, h.logger, err, "DATABASE_ERROR", "Error emitiendo autorización de descuento")
+		return
+	}
+
+	h.logger.WithField("autorizacion_id", autorizacion.ID).
+		WithField("supervisor_id", supervisorID).
+		Info("Autorización de descuento emitida")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"autorizacion": autorizacion,
+			"codigo":       codigo,
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Métodos auxiliares
+
+// aplicarPoliticaDescuento valida los descuentos de la venta contra la política del rol del cajero,
+// tomado del token y nunca del cuerpo de la petición.
+// Los excesos requieren un código de supervisor, que queda registrado en datos_adicionales.
+func aplicarPoliticaDescuento(ctx context.Context, tx *sql.Tx, venta *models.Venta, detalles []models.DetalleVenta, rol string, codigo *string) error {
+	productoIDs := make([]string, len(detalles))
+	for i := range detalles {
+		productoIDs[i] = detalles[i].ProductoID.String()
+	}
+
+	type datosProducto struct {
+		categoriaID *uuid.UUID
+		precioCosto sql.NullFloat64
+	}
+	productos := make(map[uuid.UUID]datosProducto, len(productoIDs))
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, categoria_id, precio_costo FROM productos WHERE id = ANY($1::uuid[])`,
+		pq.Array(productoIDs),
+	)
+	if err != nil {
+		return fmt.Errorf("error consultando costos de productos: %w", err)
+	}
+	err = database.ScanRows(rows, func() error {
+		var id uuid.UUID
+		var p datosProducto
+		if err := rows.Scan(&id, &p.categoriaID, &p.precioCosto); err != nil {
+			return err
+		}
+		productos[id] = p
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error leyendo costos de productos: %w", err)
+	}
+
+	// Nunca se vende bajo el costo, sea por descuento manual, promoción o precio congelado, aunque exista
+	// autorización; el costo es por unidad base. Las promociones no consumen el límite del rol, solo el
+	// descuento manual
+	var conDescuento []*models.DetalleVenta
+	for i := range detalles {
+		d := &detalles[i]
+		if producto := productos[d.ProductoID]; producto.precioCosto.Valid && d.PrecioFinal < producto.precioCosto.Float64*d.FactorConversion {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "PRECIO_BAJO_COSTO",
+				message: "El precio final queda bajo el costo del producto",
+				details: models.JSONB{"item": i, "producto_id": d.ProductoID, "precio_final": d.PrecioFinal},
+			}
+		}
+		if descuentoManual(d) > 0 {
+			conDescuento = append(conDescuento, d)
+		}
+	}
+	if len(conDescuento) == 0 {
+		return nil
+	}
+
+	type limite struct {
+		porcentaje float64
+		monto      sql.NullFloat64
+	}
+	var general *limite
+	porCategoria := make(map[uuid.UUID]limite)
+	rows, err = tx.QueryContext(ctx, `
+		SELECT categoria_id, porcentaje_maximo, monto_maximo
+		FROM politicas_descuento
+		WHERE rol = $1 AND activo = true`,
+		rol,
+	)
+	if err != nil {
+		return fmt.Errorf("error consultando políticas de descuento: %w", err)
+	}
+	err = database.ScanRows(rows, func() error {
+		var categoriaID *uuid.UUID
+		var l limite
+		if err := rows.Scan(&categoriaID, &l.porcentaje, &l.monto); err != nil {
+			return err
+		}
+		if categoriaID == nil {
+			general = &l
+		} else {
+			porCategoria[*categoriaID] = l
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error leyendo políticas de descuento: %w", err)
+	}
+
+	var excesos []models.JSONB
+	for _, d := range conDescuento {
+		producto := productos[d.ProductoID]
+
+		// Sin política para el rol no se permiten descuentos sin autorización
+		l := limite{}
+		if general != nil {
+			l = *general
+		}
+		if producto.categoriaID != nil {
+			if lc, ok := porCategoria[*producto.categoriaID]; ok {
+				l = lc
+			}
+		}
+
+		manual := descuentoManual(d)
+		porcentaje := redondearMonto(manual / d.PrecioUnitario * 100)
+		monto := redondearMonto(manual * d.Cantidad)
+		if porcentaje > l.porcentaje || (l.monto.Valid && monto > l.monto.Float64) {
+			exceso := models.JSONB{
+				"producto_id":       d.ProductoID,
+				"porcentaje":        porcentaje,
+				"porcentaje_maximo": l.porcentaje,
+				"monto":             monto,
+			}
+			if l.monto.Valid {
+				exceso["monto_maximo"] = l.monto.Float64
+			}
+			excesos = append(excesos, exceso)
+		}
+	}
+	if len(excesos) == 0 {
+		return nil
+	}
+
+	if codigo == nil || strings.TrimSpace(*codigo) == "" {
+		return &apiError{
+			status:  http.StatusForbidden,
+			code:    "AUTORIZACION_DESCUENTO_REQUERIDA",
+			message: "El descuento supera el límite del rol y requiere autorización de supervisor",
+			details: models.JSONB{"rol": rol, "excesos": excesos},
+		}
+	}
+
+	var autorizacionID, supervisorID uuid.UUID
+	var motivo string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE autorizaciones_descuento
+		SET usada = true, venta_id = $3, fecha_uso = NOW()
+		WHERE codigo_hash = $1 AND sucursal_id = $2 AND usada = false AND fecha_expiracion > NOW()
+		RETURNING id, supervisor_id, motivo`,
+		hashCodigoAutorizacion(strings.TrimSpace(*codigo)), venta.SucursalID, venta.ID,
+	).Scan(&autorizacionID, &supervisorID, &motivo)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &apiError{
+				status:  http.StatusForbidden,
+				code:    "AUTORIZACION_DESCUENTO_INVALIDA",
+				message: "Código de autorización de descuento inválido, usado o vencido",
+			}
+		}
+		return fmt.Errorf("error validando autorización de descuento: %w", err)
+	}
+
+	venta.DatosAdicionales["autorizacion_descuento"] = models.JSONB{
+		"autorizacion_id": autorizacionID,
+		"supervisor_id":   supervisorID,
+		"motivo":          motivo,
+		"rol_cajero":      rol,
+		"excesos":         excesos,
+	}
+
+	return nil
+}
+
+// generarCodigoAutorizacion genera un código numérico de 8 dígitos
+func generarCodigoAutorizacion() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(100000000))
+	if err != nil {
+		return "", fmt.Errorf("error generando código de autorización: %w", err)
+	}
+	return fmt.Sprintf("%08d", n.Int64()), nil
+}
+
+// hashCodigoAutorizacion calcula el hash con que se almacena el código de autorización
+func hashCodigoAutorizacion(codigo string) string {
+	sum := sha256.Sum256([]byte(codigo))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,1026 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Decodificador de firmas JPEG
+	_ "image/png"  // Decodificador de firmas PNG
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+const (
+	// prioridadDespachoPorDefecto prioridad de un despacho si la venta no la informa (1 es la más urgente)
+	prioridadDespachoPorDefecto = 5
+	// tamanoMaximoFirma tamaño máximo de la imagen de firma decodificada
+	tamanoMaximoFirma = 512 * 1024
+)
+
+// DespachosHandler handler para la preparación y entrega de despachos por bodega
+type DespachosHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+}
+
+// NewDespachosHandler crea un nuevo handler de despachos
+func NewDespachosHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *DespachosHandler {
+	return &DespachosHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+	}
+}
+
+// List lista los despachos de una sucursal, por defecto los pendientes y en proceso,
+// ordenados por prioridad, cliente o fecha programada
+func (h *DespachosHandler) List(c *gin.Context) {
+	sucursalID := getSucursalID(c)
+	if _, err := uuid.Parse(sucursalID); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_SUCURSAL_ID",
+				Message: "ID de sucursal inválido",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	var orden string
+	switch c.DefaultQuery("orden", "prioridad") {
+	case "prioridad":
+		orden = `prioridad_despacho, fecha_programada NULLS LAST, numero_despacho`
+	case "cliente":
+		orden = `cliente_nombre NULLS LAST, cliente_rut, prioridad_despacho, numero_despacho`
+	case "fecha":
+		orden = `fecha_programada NULLS LAST, prioridad_despacho, numero_despacho`
+	default:
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_ORDEN",
+				Message: "Orden inválido; use prioridad, cliente o fecha",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	query := `SELECT ` + columnasDespacho + `, ` + conteoLineasDespacho + ` FROM despachos WHERE sucursal_id = $1`
+	args := []interface{}{sucursalID}
+	if estado := c.Query("estado"); estado != "" {
+		args = append(args, estado)
+		query += ` AND estado = $` + strconv.Itoa(len(args))
+	} else {
+		query += ` AND estado IN ('pendiente', 'en_proceso')`
+	}
+	if rut := c.Query("cliente_rut"); rut != "" {
+		args = append(args, rut)
+		query += ` AND cliente_rut = $` + strconv.Itoa(len(args))
+	}
+	query += ` ORDER BY ` + orden + ` LIMIT 200`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		h.responderError(c, err, "Error listando despachos")
+		return
+	}
+
+	despachos := []models.Despacho{}
+	err = database.ScanRows(rows, func() error {
+		var d models.Despacho
+		if err := scanDespacho(rows, &d, &d.TotalLineas, &d.LineasPendientes); err != nil {
+			return err
+		}
+		despachos = append(despachos, d)
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error listando despachos")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      despachos,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetByID obtiene un despacho con su detalle e incidencias
+func (h *DespachosHandler) GetByID(c *gin.Context) {
+	despachoID, ok := uuidParam(c, "id", "INVALID_DESPACHO_ID", "ID de despacho inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	despacho, err := getDespacho(ctx, h.db, despachoID, false)
+	if err != nil {
+		h.responderError(c, err, "Error consultando despacho")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      despacho,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Iniciar toma un despacho pendiente para su preparación
+func (h *DespachosHandler) Iniciar(c *gin.Context) {
+	despachoID, ok := uuidParam(c, "id", "INVALID_DESPACHO_ID", "ID de despacho inválido")
+	if !ok {
+		return
+	}
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	h.modificarDespacho(c, despachoID, "Error iniciando despacho", func(ctx context.Context, tx *sql.Tx, d *models.Despacho) error {
+		return iniciarDespacho(ctx, tx, d, usuarioID)
+	})
+}
+
+// Escanear confirma una línea del despacho con el código de barras del producto o de su presentación.
+// Escanear un despacho pendiente lo deja en proceso
+func (h *DespachosHandler) Escanear(c *gin.Context) {
+	despachoID, ok := uuidParam(c, "id", "INVALID_DESPACHO_ID", "ID de despacho inválido")
+	if !ok {
+		return
+	}
+
+	var req models.EscanearDespachoRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	h.modificarDespacho(c, despachoID, "Error registrando escaneo de despacho", func(ctx context.Context, tx *sql.Tx, d *models.Despacho) error {
+		if err := iniciarDespacho(ctx, tx, d, usuarioID); err != nil {
+			return err
+		}
+
+		productoID, factor, err := resolverCodigoDespacho(ctx, tx, strings.TrimSpace(req.CodigoBarra))
+		if err != nil {
+			return err
+		}
+
+		cantidad := req.Cantidad
+		if cantidad == 0 {
+			cantidad = 1
+		}
+		cantidad = redondearCantidad(cantidad * factor)
+
+		linea, err := lineaEscaneada(d, productoID, req.NumeroSerie, req.Lote, cantidad)
+		if err != nil {
+			return err
+		}
+
+		return actualizarLineaDespacho(ctx, tx, d, linea, redondearCantidad(linea.CantidadDespachada+cantidad),
+			req.NumeroSerie, req.Lote, nil, usuarioID)
+	})
+}
+
+// ActualizarLinea registra la cantidad despachada de una línea; menos que lo solicitado es una entrega parcial
+func (h *DespachosHandler) ActualizarLinea(c *gin.Context) {
+	despachoID, ok := uuidParam(c, "id", "INVALID_DESPACHO_ID", "ID de despacho inválido")
+	if !ok {
+		return
+	}
+
+	lineaID, ok := uuidParam(c, "linea_id", "INVALID_LINEA_ID", "ID de línea de despacho inválido")
+	if !ok {
+		return
+	}
+
+	var req models.LineaDespachoRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	h.modificarDespacho(c, despachoID, "Error actualizando línea de despacho", func(ctx context.Context, tx *sql.Tx, d *models.Despacho) error {
+		if err := iniciarDespacho(ctx, tx, d, usuarioID); err != nil {
+			return err
+		}
+
+		linea := lineaDespacho(d, lineaID)
+		if linea == nil {
+			return &apiError{
+				status:  http.StatusNotFound,
+				code:    "LINEA_DESPACHO_NOT_FOUND",
+				message: "La línea no pertenece al despacho",
+			}
+		}
+
+		cantidad := redondearCantidad(req.CantidadDespachada)
+		if cantidad > linea.CantidadSolicitada {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "CANTIDAD_EXCEDE_SOLICITADA",
+				message: "La cantidad despachada supera la solicitada",
+				details: models.JSONB{
+					"detalle_despacho_id": linea.ID,
+					"cantidad_solicitada": linea.CantidadSolicitada,
+				},
+			}
+		}
+
+		return actualizarLineaDespacho(ctx, tx, d, linea, cantidad, req.NumeroSerie, req.Lote, req.Observaciones, usuarioID)
+	})
+}
+
+// RegistrarIncidencia registra un problema del despacho completo o de una de sus líneas
+func (h *DespachosHandler) RegistrarIncidencia(c *gin.Context) {
+	despachoID, ok := uuidParam(c, "id", "INVALID_DESPACHO_ID", "ID de despacho inválido")
+	if !ok {
+		return
+	}
+
+	var req models.IncidenciaDespachoRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	h.modificarDespacho(c, despachoID, "Error registrando incidencia de despacho", func(ctx context.Context, tx *sql.Tx, d *models.Despacho) error {
+		if d.Estado == "rechazado" {
+			return errDespachoNoModificable(d)
+		}
+		if req.DetalleDespachoID != nil && lineaDespacho(d, *req.DetalleDespachoID) == nil {
+			return &apiError{
+				status:  http.StatusNotFound,
+				code:    "LINEA_DESPACHO_NOT_FOUND",
+				message: "La línea no pertenece al despacho",
+			}
+		}
+
+		incidencia := models.IncidenciaDespacho{
+			ID:                uuid.New(),
+			DespachoID:        d.ID,
+			DetalleDespachoID: req.DetalleDespachoID,
+			Tipo:              req.Tipo,
+			Descripcion:       req.Descripcion,
+			CantidadAfectada:  req.CantidadAfectada,
+			UsuarioID:         &usuarioID,
+		}
+		if err := insertarIncidenciaDespacho(ctx, tx, &incidencia); err != nil {
+			return err
+		}
+		d.Incidencias = append(d.Incidencias, incidencia)
+		return nil
+	})
+}
+
+// RegistrarFirma guarda la firma de quien recibe capturada en la pantalla táctil
+func (h *DespachosHandler) RegistrarFirma(c *gin.Context) {
+	despachoID, ok := uuidParam(c, "id", "INVALID_DESPACHO_ID", "ID de despacho inválido")
+	if !ok {
+		return
+	}
+
+	var req models.FirmaDespachoRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	firma, tipoContenido, vErr := decodificarFirma(req.Imagen)
+	if vErr != nil {
+		h.responderError(c, vErr, "Firma inválida")
+		return
+	}
+
+	h.modificarDespacho(c, despachoID, "Error registrando firma de despacho", func(ctx context.Context, tx *sql.Tx, d *models.Despacho) error {
+		if d.Estado == "rechazado" {
+			return errDespachoNoModificable(d)
+		}
+
+		nombre := strings.TrimSpace(req.Nombre)
+		err := tx.QueryRowContext(ctx, `
+			UPDATE despachos
+			SET firma_imagen = $2, firma_tipo_contenido = $3, firma_nombre = $4, firma_rut = $5, fecha_firma = NOW()
+			WHERE id = $1
+			RETURNING fecha_firma`,
+			d.ID, firma, tipoContenido, nombre, req.RUT,
+		).Scan(&d.FechaFirma)
+		if err != nil {
+			return fmt.Errorf("error guardando firma: %w", err)
+		}
+		d.FirmaNombre = &nombre
+		d.FirmaRUT = req.RUT
+		return nil
+	})
+}
+
+// Completar cierra el despacho como completo si se entregó todo lo solicitado o como parcial si faltaron productos
+func (h *DespachosHandler) Completar(c *gin.Context) {
+	despachoID, ok := uuidParam(c, "id", "INVALID_DESPACHO_ID", "ID de despacho inválido")
+	if !ok {
+		return
+	}
+
+	var req models.CompletarDespachoRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	h.modificarDespacho(c, despachoID, "Error completando despacho", func(ctx context.Context, tx *sql.Tx, d *models.Despacho) error {
+		if d.Estado != "en_proceso" {
+			return errDespachoNoModificable(d)
+		}
+
+		despachado := false
+		for _, l := range d.Detalles {
+			if l.CantidadDespachada > 0 {
+				despachado = true
+				break
+			}
+		}
+		if !despachado {
+			return &apiError{
+				status:  http.StatusConflict,
+				code:    "DESPACHO_SIN_ENTREGAS",
+				message: "No hay productos despachados; registre las cantidades o rechace el despacho",
+			}
+		}
+
+		estado := "completo"
+		if d.LineasPendientes > 0 {
+			estado = "parcial"
+		}
+
+		err := tx.QueryRowContext(ctx, `
+			UPDATE despachos
+			SET estado = $2, fecha_completado = NOW(), observaciones = COALESCE($3, observaciones)
+			WHERE id = $1
+			RETURNING fecha_completado, observaciones`,
+			d.ID, estado, req.Observaciones,
+		).Scan(&d.FechaCompletado, &d.Observaciones)
+		if err != nil {
+			return fmt.Errorf("error completando despacho: %w", err)
+		}
+		d.Estado = estado
+		return nil
+	})
+}
+
+// Rechazar cierra el despacho sin entrega, registrando el motivo como incidencia
+func (h *DespachosHandler) Rechazar(c *gin.Context) {
+	despachoID, ok := uuidParam(c, "id", "INVALID_DESPACHO_ID", "ID de despacho inválido")
+	if !ok {
+		return
+	}
+
+	var req models.RechazarDespachoRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	h.modificarDespacho(c, despachoID, "Error rechazando despacho", func(ctx context.Context, tx *sql.Tx, d *models.Despacho) error {
+		if d.Estado != "pendiente" && d.Estado != "en_proceso" {
+			return errDespachoNoModificable(d)
+		}
+
+		incidencia := models.IncidenciaDespacho{
+			ID:          uuid.New(),
+			DespachoID:  d.ID,
+			Tipo:        "rechazo_cliente",
+			Descripcion: req.Motivo,
+			UsuarioID:   &usuarioID,
+		}
+		if err := insertarIncidenciaDespacho(ctx, tx, &incidencia); err != nil {
+			return err
+		}
+		d.Incidencias = append(d.Incidencias, incidencia)
+
+		return rechazarDespacho(ctx, tx, d, req.Motivo)
+	})
+}
+
+// GetComprobante emite el comprobante de entrega firmado. Se emite una sola vez; las copias
+// posteriores se obtienen con la reimpresión controlada de documentos
+func (h *DespachosHandler) GetComprobante(c *gin.Context) {
+	despachoID, ok := uuidParam(c, "id", "INVALID_DESPACHO_ID", "ID de despacho inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var contenido []byte
+	var numero int64
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		doc, err := cargarDocumentoImprimible(ctx, tx, "comprobante_despacho", despachoID, true)
+		if err != nil {
+			return err
+		}
+		if err := validarSucursalDocumento(doc, getUserSucursalID(c), getUserRole(c)); err != nil {
+			return err
+		}
+		if doc.despacho.estado != "completo" && doc.despacho.estado != "parcial" {
+			return &apiError{
+				status:  http.StatusConflict,
+				code:    "DESPACHO_NO_ENTREGADO",
+				message: "El comprobante se emite al completar la entrega",
+				details: models.JSONB{"estado": doc.despacho.estado},
+			}
+		}
+
+		var emitido sql.NullString
+		err = tx.QueryRowContext(ctx,
+			`SELECT datos_adicionales->>'comprobante_emitido' FROM despachos WHERE id = $1`, despachoID,
+		).Scan(&emitido)
+		if err != nil {
+			return fmt.Errorf("error consultando emisión del comprobante: %w", err)
+		}
+		if emitido.Valid {
+			return &apiError{
+				status:  http.StatusConflict,
+				code:    "COMPROBANTE_YA_EMITIDO",
+				message: "El comprobante ya fue emitido; solicite una reimpresión",
+				details: models.JSONB{"fecha_emision": emitido.String, "flujo_sugerido": "reimpresiones"},
+			}
+		}
+
+		doc.despacho.original = true
+		numero = doc.numero
+		leyenda := "Comprobante de entrega emitido el " + time.Now().Format("02-01-2006 15:04")
+		contenido, err = renderizarDocumentoImprimible(ctx, tx, despachoID, doc, leyenda)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE despachos
+			SET datos_adicionales = COALESCE(datos_adicionales, '{}'::jsonb) ||
+				jsonb_build_object('comprobante_emitido', NOW())
+			WHERE id = $1`,
+			despachoID,
+		)
+		if err != nil {
+			return fmt.Errorf("error registrando emisión del comprobante: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error emitiendo comprobante de despacho")
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="despacho-%d.pdf"`, numero))
+	c.Data(http.StatusOK, "application/pdf", contenido)
+}
+
+// modificarDespacho bloquea el despacho, aplica el cambio y responde con el despacho actualizado
+func (h *DespachosHandler) modificarDespacho(c *gin.Context, despachoID uuid.UUID, mensaje string, cambio func(ctx context.Context, tx *sql.Tx, d *models.Despacho) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var despacho *models.Despacho
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		despacho, err = getDespacho(ctx, tx, despachoID, true)
+		if err != nil {
+			return err
+		}
+		if err := cambio(ctx, tx, despacho); err != nil {
+			return err
+		}
+		contarLineasDespacho(despacho)
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, mensaje)
+		return
+	}
+
+	h.logger.WithField("despacho_id", despacho.ID).
+		WithField("numero_despacho", despacho.NumeroDespacho).
+		WithField("estado", despacho.Estado).
+		Debug("Despacho actualizado")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      despacho,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// responderError responde errores de negocio, despacho inexistente o errores internos
+func (h *DespachosHandler) responderError(c *gin.Context, err error, mensaje string) {
+	if errors.Is(err, sql.ErrNoRows) {
+		err = &apiError{
+			status:  http.StatusNotFound,
+			code:    "DESPACHO_NOT_FOUND",
+			message: "Despacho no encontrado",
+		}
+	}
+
+	responderError(c, h.logger, err, "DESPACHO_ERROR", mensaje)
+}
+
+// columnasDespacho columnas de despachos en el orden esperado por scanDespacho
+const columnasDespacho = `id, numero_despacho, sucursal_id, documento_id, venta_id, usuario_despacho_id,
+		cliente_rut, cliente_nombre, estado, fecha_programada, fecha_inicio, fecha_completado,
+		observaciones, datos_adicionales, COALESCE(prioridad_despacho, 5), tiempo_estimado_minutos,
+		codigo_seguimiento, ubicacion_picking, firma_nombre, firma_rut, fecha_firma, lista_picking_id`
+
+// conteoLineasDespacho total de líneas y líneas con cantidad pendiente de un despacho
+const conteoLineasDespacho = `
+		(SELECT COUNT(*) FROM detalle_despacho dd WHERE dd.despacho_id = despachos.id),
+		(SELECT COUNT(*) FROM detalle_despacho dd
+			WHERE dd.despacho_id = despachos.id AND COALESCE(dd.cantidad_despachada, 0) < dd.cantidad_solicitada)`
+
+// scanDespacho lee un despacho desde una fila; extra recibe las columnas adicionales de la consulta
+func scanDespacho(row interface{ Scan(...interface{}) error }, d *models.Despacho, extra ...interface{}) error {
+	dest := []interface{}{
+		&d.ID, &d.NumeroDespacho, &d.SucursalID, &d.DocumentoID, &d.VentaID, &d.UsuarioDespachoID,
+		&d.ClienteRUT, &d.ClienteNombre, &d.Estado, &d.FechaProgramada, &d.FechaInicio,
+		&d.FechaCompletado, &d.Observaciones, &d.DatosAdicionales, &d.PrioridadDespacho,
+		&d.TiempoEstimadoMinutos, &d.CodigoSeguimiento, &d.UbicacionPicking, &d.FirmaNombre,
+		&d.FirmaRUT, &d.FechaFirma, &d.ListaPickingID,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// getDespacho obtiene un despacho con su detalle en orden de picking y sus incidencias
+func getDespacho(ctx context.Context, q sqlQueryer, id uuid.UUID, bloquear bool) (*models.Despacho, error) {
+	query := `SELECT ` + columnasDespacho + ` FROM despachos WHERE id = $1`
+	if bloquear {
+		query += ` FOR UPDATE`
+	}
+
+	var despacho models.Despacho
+	if err := scanDespacho(q.QueryRowContext(ctx, query, id), &despacho); err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT dd.id, dd.despacho_id, dd.producto_id, p.codigo_interno, p.descripcion,
+			dd.cantidad_solicitada, COALESCE(dd.cantidad_despachada, 0), dd.numero_serie, dd.lote,
+			dd.observaciones, dd.fecha_despacho, dd.usuario_despacho_id, dd.ubicacion_producto,
+			dd.orden_picking, dd.tiempo_picking_segundos
+		FROM detalle_despacho dd
+		JOIN productos p ON p.id = dd.producto_id
+		WHERE dd.despacho_id = $1
+		ORDER BY dd.orden_picking NULLS LAST, p.descripcion, dd.id`,
+		despacho.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando detalle de despacho: %w", err)
+	}
+	err = database.ScanRows(rows, func() error {
+		var l models.DetalleDespacho
+		if err := rows.Scan(
+			&l.ID, &l.DespachoID, &l.ProductoID, &l.CodigoInterno, &l.Descripcion,
+			&l.CantidadSolicitada, &l.CantidadDespachada, &l.NumeroSerie, &l.Lote,
+			&l.Observaciones, &l.FechaDespacho, &l.UsuarioDespachoID, &l.UbicacionProducto,
+			&l.OrdenPicking, &l.TiempoPickingSegundos,
+		); err != nil {
+			return err
+		}
+		despacho.Detalles = append(despacho.Detalles, l)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo detalle de despacho: %w", err)
+	}
+
+	rows, err = q.QueryContext(ctx, `
+		SELECT id, despacho_id, detalle_despacho_id, tipo, descripcion, cantidad_afectada, usuario_id, fecha
+		FROM incidencias_despacho
+		WHERE despacho_id = $1
+		ORDER BY fecha`,
+		despacho.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando incidencias de despacho: %w", err)
+	}
+	err = database.ScanRows(rows, func() error {
+		var i models.IncidenciaDespacho
+		if err := rows.Scan(&i.ID, &i.DespachoID, &i.DetalleDespachoID, &i.Tipo, &i.Descripcion,
+			&i.CantidadAfectada, &i.UsuarioID, &i.Fecha); err != nil {
+			return err
+		}
+		despacho.Incidencias = append(despacho.Incidencias, i)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo incidencias de despacho: %w", err)
+	}
+
+	contarLineasDespacho(&despacho)
+	return &despacho, nil
+}
+
+// contarLineasDespacho recalcula el total de líneas y las que aún tienen cantidad pendiente
+func contarLineasDespacho(d *models.Despacho) {
+	d.TotalLineas = len(d.Detalles)
+	d.LineasPendientes = 0
+	for _, l := range d.Detalles {
+		if l.CantidadDespachada < l.CantidadSolicitada {
+			d.LineasPendientes++
+		}
+	}
+}
+
+// crearDespachoVenta crea el despacho pendiente de una venta con una línea por línea vendida en unidad base
+func crearDespachoVenta(ctx context.Context, tx *sql.Tx, venta *models.Venta, detalles []models.DetalleVenta, req *models.DespachoVentaRequest) (*models.Despacho, error) {
+	prioridad := req.Prioridad
+	if prioridad == 0 {
+		prioridad = prioridadDespachoPorDefecto
+	}
+
+	despacho := &models.Despacho{
+		ID:                    uuid.New(),
+		SucursalID:            venta.SucursalID,
+		DocumentoID:           venta.DTEID,
+		VentaID:               &venta.ID,
+		ClienteRUT:            venta.ClienteRUT,
+		ClienteNombre:         venta.ClienteNombre,
+		Estado:                "pendiente",
+		FechaProgramada:       req.FechaProgramada,
+		Observaciones:         req.Observaciones,
+		PrioridadDespacho:     prioridad,
+		TiempoEstimadoMinutos: req.TiempoEstimadoMinutos,
+		DatosAdicionales:      models.JSONB{"numero_venta": venta.NumeroVenta},
+	}
+	if req.DireccionEntrega != nil {
+		despacho.DatosAdicionales["direccion_entrega"] = *req.DireccionEntrega
+	}
+
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO despachos (
+			id, sucursal_id, documento_id, venta_id, cliente_rut, cliente_nombre, estado,
+			fecha_programada, observaciones, datos_adicionales, prioridad_despacho, tiempo_estimado_minutos
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING numero_despacho`,
+		despacho.ID, despacho.SucursalID, despacho.DocumentoID, despacho.VentaID, despacho.ClienteRUT,
+		despacho.ClienteNombre, despacho.Estado, despacho.FechaProgramada, despacho.Observaciones,
+		despacho.DatosAdicionales, despacho.PrioridadDespacho, despacho.TiempoEstimadoMinutos,
+	).Scan(&despacho.NumeroDespacho)
+	if err != nil {
+		if esViolacionUnicidad(err) {
+			return nil, &apiError{
+				status:  http.StatusConflict,
+				code:    "DESPACHO_DUPLICADO",
+				message: "La venta ya tiene un despacho",
+				details: models.JSONB{"venta_id": venta.ID},
+			}
+		}
+		return nil, fmt.Errorf("error insertando despacho: %w", err)
+	}
+
+	for _, d := range detalles {
+		linea := models.DetalleDespacho{
+			ID:                 uuid.New(),
+			DespachoID:         despacho.ID,
+			ProductoID:         d.ProductoID,
+			CantidadSolicitada: redondearCantidad(d.Cantidad * d.FactorConversion),
+			NumeroSerie:        d.NumeroSerie,
+			Lote:               d.Lote,
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO detalle_despacho (
+				id, despacho_id, producto_id, cantidad_solicitada, cantidad_despachada, numero_serie, lote
+			) VALUES ($1, $2, $3, $4, 0, $5, $6)`,
+			linea.ID, linea.DespachoID, linea.ProductoID, linea.CantidadSolicitada, linea.NumeroSerie, linea.Lote,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error insertando detalle de despacho: %w", err)
+		}
+		despacho.Detalles = append(despacho.Detalles, linea)
+	}
+	contarLineasDespacho(despacho)
+
+	return despacho, nil
+}
+
+// cancelarDespachoVenta rechaza el despacho aún no entregado de una venta anulada; si ya salió mercadería la anulación no procede
+func cancelarDespachoVenta(ctx context.Context, tx *sql.Tx, ventaID uuid.UUID, motivo string) error {
+	var d models.Despacho
+	err := scanDespacho(tx.QueryRowContext(ctx,
+		`SELECT `+columnasDespacho+` FROM despachos WHERE venta_id = $1 FOR UPDATE`, ventaID,
+	), &d)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error consultando despacho de la venta: %w", err)
+	}
+
+	switch d.Estado {
+	case "rechazado":
+		return nil
+	case "completo", "parcial":
+		return &apiError{
+			status:  http.StatusConflict,
+			code:    "VENTA_DESPACHADA",
+			message: "La venta ya fue despachada y no puede anularse; debe emitirse una nota de crédito",
+			details: models.JSONB{"despacho_id": d.ID, "numero_despacho": d.NumeroDespacho, "flujo_sugerido": "notas_credito"},
+		}
+	}
+
+	return rechazarDespacho(ctx, tx, &d, "Venta anulada: "+motivo)
+}
+
+// rechazarDespacho marca el despacho como rechazado agregando el motivo a sus observaciones
+func rechazarDespacho(ctx context.Context, tx *sql.Tx, d *models.Despacho, motivo string) error {
+	err := tx.QueryRowContext(ctx, `
+		UPDATE despachos
+		SET estado = 'rechazado', fecha_completado = NOW(),
+			observaciones = CONCAT_WS(E'\n', NULLIF(observaciones, ''), $2::text)
+		WHERE id = $1
+		RETURNING fecha_completado, observaciones`,
+		d.ID, motivo,
+	).Scan(&d.FechaCompletado, &d.Observaciones)
+	if err != nil {
+		return fmt.Errorf("error rechazando despacho: %w", err)
+	}
+	d.Estado = "rechazado"
+	return nil
+}
+
+// iniciarDespacho deja en proceso un despacho pendiente a cargo del usuario; uno ya en proceso se mantiene
+func iniciarDespacho(ctx context.Context, tx *sql.Tx, d *models.Despacho, usuarioID uuid.UUID) error {
+	switch d.Estado {
+	case "en_proceso":
+		return nil
+	case "pendiente":
+	default:
+		return errDespachoNoModificable(d)
+	}
+
+	err := tx.QueryRowContext(ctx, `
+		UPDATE despachos
+		SET estado = 'en_proceso', fecha_inicio = NOW(), usuario_despacho_id = $2
+		WHERE id = $1
+		RETURNING fecha_inicio`,
+		d.ID, usuarioID,
+	).Scan(&d.FechaInicio)
+	if err != nil {
+		return fmt.Errorf("error iniciando despacho: %w", err)
+	}
+	d.Estado = "en_proceso"
+	d.UsuarioDespachoID = &usuarioID
+	return nil
+}
+
+// errDespachoNoModificable error para operaciones sobre despachos cerrados o en un estado que no las admite
+func errDespachoNoModificable(d *models.Despacho) error {
+	return &apiError{
+		status:  http.StatusConflict,
+		code:    "DESPACHO_NO_MODIFICABLE",
+		message: fmt.Sprintf("El despacho está %s", strings.ReplaceAll(d.Estado, "_", " ")),
+		details: models.JSONB{"despacho_id": d.ID, "estado": d.Estado},
+	}
+}
+
+// resolverCodigoDespacho obtiene el producto de un código de barras y el factor a unidad base de la presentación escaneada
+func resolverCodigoDespacho(ctx context.Context, tx *sql.Tx, codigo string) (uuid.UUID, float64, error) {
+	var productoID uuid.UUID
+	var factor float64
+	err := tx.QueryRowContext(ctx, `
+		SELECT p.id, 1::numeric
+		FROM productos p
+		WHERE p.codigo_barra = $1 AND p.activo = true
+		UNION
+		SELECT p.id, 1::numeric
+		FROM productos p
+		JOIN codigos_barra_adicionales cba ON p.id = cba.producto_id
+		WHERE cba.codigo_barra = $1 AND cba.activo = true AND p.activo = true
+		UNION
+		SELECT p.id, ump.factor_conversion
+		FROM productos p
+		JOIN unidades_medida_producto ump ON p.id = ump.producto_id
+		WHERE ump.codigo_barra = $1 AND ump.activo = true AND p.activo = true
+		LIMIT 1`,
+		codigo,
+	).Scan(&productoID, &factor)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, 0, &apiError{
+			status:  http.StatusNotFound,
+			code:    "CODIGO_NO_ENCONTRADO",
+			message: "Código de barras no encontrado",
+			details: models.JSONB{"codigo_barra": codigo},
+		}
+	}
+	if err != nil {
+		return uuid.Nil, 0, fmt.Errorf("error resolviendo código de barras: %w", err)
+	}
+	return productoID, factor, nil
+}
+
+// lineaEscaneada elige la primera línea del producto con cantidad pendiente compatible con la serie y el lote escaneados
+func lineaEscaneada(d *models.Despacho, productoID uuid.UUID, serie, lote *string, cantidad float64) (*models.DetalleDespacho, error) {
+	incluido := false
+	for i := range d.Detalles {
+		l := &d.Detalles[i]
+		if l.ProductoID != productoID {
+			continue
+		}
+		incluido = true
+		if !coincideOpcional(l.NumeroSerie, serie) || !coincideOpcional(l.Lote, lote) {
+			continue
+		}
+
+		pendiente := redondearCantidad(l.CantidadSolicitada - l.CantidadDespachada)
+		if pendiente <= 0 {
+			continue
+		}
+		if cantidad > pendiente {
+			return nil, &apiError{
+				status:  http.StatusConflict,
+				code:    "CANTIDAD_EXCEDE_PENDIENTE",
+				message: "La cantidad escaneada supera lo pendiente de la línea",
+				details: models.JSONB{
+					"detalle_despacho_id": l.ID,
+					"cantidad_pendiente":  pendiente,
+					"cantidad_escaneada":  cantidad,
+				},
+			}
+		}
+		return l, nil
+	}
+
+	if !incluido {
+		return nil, &apiError{
+			status:  http.StatusConflict,
+			code:    "PRODUCTO_NO_INCLUIDO",
+			message: "El producto escaneado no pertenece al despacho",
+			details: models.JSONB{"producto_id": productoID},
+		}
+	}
+	return nil, &apiError{
+		status:  http.StatusConflict,
+		code:    "LINEA_COMPLETA",
+		message: "El producto ya fue despachado completo",
+		details: models.JSONB{"producto_id": productoID},
+	}
+}
+
+// coincideOpcional indica si el valor escaneado es compatible con el registrado en la línea; la ausencia de cualquiera de ellos coincide
+func coincideOpcional(registrado, escaneado *string) bool {
+	return registrado == nil || escaneado == nil || strings.EqualFold(*registrado, strings.TrimSpace(*escaneado))
+}
+
+// lineaDespacho busca una línea del despacho por su ID
+func lineaDespacho(d *models.Despacho, lineaID uuid.UUID) *models.DetalleDespacho {
+	for i := range d.Detalles {
+		if d.Detalles[i].ID == lineaID {
+			return &d.Detalles[i]
+		}
+	}
+	return nil
+}
+
+// actualizarLineaDespacho registra la cantidad despachada de la línea, quién la confirmó y el tiempo de picking.
+// El tiempo se mide desde la confirmación anterior de la lista de picking, o del despacho si no pertenece a una,
+// y se acumula cuando la línea se confirma en varios escaneos
+func actualizarLineaDespacho(ctx context.Context, tx *sql.Tx, d *models.Despacho, l *models.DetalleDespacho, cantidad float64, serie, lote, observaciones *string, usuarioID uuid.UUID) error {
+	var segundos sql.NullInt64
+	var err error
+	if d.ListaPickingID != nil {
+		err = tx.QueryRowContext(ctx, `
+			SELECT EXTRACT(EPOCH FROM NOW() - GREATEST(lp.fecha_inicio, MAX(dd.fecha_despacho)))::int
+			FROM listas_picking lp
+			LEFT JOIN despachos d ON d.lista_picking_id = lp.id
+			LEFT JOIN detalle_despacho dd ON dd.despacho_id = d.id
+			WHERE lp.id = $1
+			GROUP BY lp.fecha_inicio`,
+			*d.ListaPickingID,
+		).Scan(&segundos)
+	} else {
+		err = tx.QueryRowContext(ctx, `
+			SELECT EXTRACT(EPOCH FROM NOW() - GREATEST(d.fecha_inicio, MAX(dd.fecha_despacho)))::int
+			FROM despachos d
+			LEFT JOIN detalle_despacho dd ON dd.despacho_id = d.id
+			WHERE d.id = $1
+			GROUP BY d.fecha_inicio`,
+			d.ID,
+		).Scan(&segundos)
+	}
+	if err != nil {
+		return fmt.Errorf("error calculando tiempo de picking: %w", err)
+	}
+	if segundos.Int64 < 0 {
+		segundos.Int64 = 0
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE detalle_despacho
+		SET cantidad_despachada = $2, numero_serie = COALESCE($3, numero_serie), lote = COALESCE($4, lote),
+			observaciones = COALESCE($5, observaciones), fecha_despacho = NOW(), usuario_despacho_id = $6,
+			tiempo_picking_segundos = COALESCE(tiempo_picking_segundos, 0) + $7
+		WHERE id = $1
+		RETURNING numero_serie, lote, observaciones, fecha_despacho, tiempo_picking_segundos`,
+		l.ID, cantidad, serie, lote, observaciones, usuarioID, segundos.Int64,
+	).Scan(&l.NumeroSerie, &l.Lote, &l.Observaciones, &l.FechaDespacho, &l.TiempoPickingSegundos)
+	if err != nil {
+		return fmt.Errorf("error actualizando línea de despacho: %w", err)
+	}
+	l.CantidadDespachada = cantidad
+	l.UsuarioDespachoID = &usuarioID
+	return nil
+}
+
+// insertarIncidenciaDespacho registra una incidencia del despacho
+func insertarIncidenciaDespacho(ctx context.Context, tx *sql.Tx, i *models.IncidenciaDespacho) error {
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO incidencias_despacho (
+			id, despacho_id, detalle_despacho_id, tipo, descripcion, cantidad_afectada, usuario_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING fecha`,
+		i.ID, i.DespachoID, i.DetalleDespachoID, i.Tipo, i.Descripcion, i.CantidadAfectada, i.UsuarioID,
+	).Scan(&i.Fecha)
+	if err != nil {
+		return fmt.Errorf("error registrando incidencia de despacho: %w", err)
+	}
+	return nil
+}
+
+// decodificarFirma valida la firma en base64 (con o sin prefijo data:) y devuelve la imagen y su tipo de contenido
+func decodificarFirma(contenido string) ([]byte, string, *apiError) {
+	if i := strings.Index(contenido, ","); strings.HasPrefix(contenido, "data:") && i >= 0 {
+		contenido = contenido[i+1:]
+	}
+
+	invalida := &apiError{
+		status:  http.StatusBadRequest,
+		code:    "FIRMA_INVALIDA",
+		message: "La firma debe ser una imagen PNG o JPEG en base64",
+	}
+
+	datos, err := base64.StdEncoding.DecodeString(strings.TrimSpace(contenido))
+	if err != nil || len(datos) == 0 {
+		return nil, "", invalida
+	}
+	if len(datos) > tamanoMaximoFirma {
+		return nil, "", &apiError{
+			status:  http.StatusBadRequest,
+			code:    "FIRMA_DEMASIADO_GRANDE",
+			message: fmt.Sprintf("La firma no puede superar %d KB", tamanoMaximoFirma/1024),
+		}
+	}
+
+	tipo := http.DetectContentType(datos)
+	if tipo != "image/png" && tipo != "image/jpeg" {
+		return nil, "", invalida
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(datos)); err != nil || cfg.Width == 0 || cfg.Height == 0 {
+		return nil, "", invalida
+	}
+
+	return datos, tipo, nil
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"ferre_pos_apis/internal/models"
+)
+
+// reservarFolioDTE toma el siguiente folio del rango activo de la sucursal para el tipo de documento.
+// folio_actual apunta al próximo folio libre; al usar el último el rango queda inactivo.
+func reservarFolioDTE(ctx context.Context, tx *sql.Tx, sucursalID uuid.UUID, tipoDocumento string) (int64, error) {
+	var folio int64
+	err := tx.QueryRowContext(ctx, `
+		WITH rango AS (
+			SELECT id, folio_actual, folio_hasta
+			FROM folios_dte
+			WHERE sucursal_id = $1 AND tipo_documento = $2 AND activo = true
+				AND (fecha_vencimiento IS NULL OR fecha_vencimiento > NOW())
+			ORDER BY folio_desde
+			LIMIT 1
+			FOR UPDATE
+		)
+		UPDATE folios_dte f
+		SET folio_actual = LEAST(r.folio_actual + 1, r.folio_hasta),
+			activo = r.folio_actual < r.folio_hasta,
+			version_lock = f.version_lock + 1
+		FROM rango r
+		WHERE f.id = r.id
+		RETURNING r.folio_actual`,
+		sucursalID, tipoDocumento,
+	).Scan(&folio)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, &apiError{
+				status:  http.StatusConflict,
+				code:    "FOLIOS_DTE_AGOTADOS",
+				message: "La sucursal no tiene folios disponibles para el documento",
+				details: models.JSONB{"sucursal_id": sucursalID, "tipo_documento": tipoDocumento},
+			}
+		}
+		return 0, fmt.Errorf("error reservando folio DTE: %w", err)
+	}
+
+	return folio, nil
+}
+
+// emitirDTE asigna folio al documento y lo deja pendiente de envío al SII
+func emitirDTE(ctx context.Context, tx *sql.Tx, doc *models.DocumentoDTE) error {
+	folio, err := reservarFolioDTE(ctx, tx, doc.SucursalID, doc.TipoDocumento)
+	if err != nil {
+		return err
+	}
+
+	doc.ID = uuid.New()
+	doc.Folio = folio
+	doc.Estado = models.EstadoPendiente
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO documentos_dte (
+			id, sucursal_id, venta_id, tipo_documento, folio, rut_receptor,
+			razon_social_receptor, monto_neto, monto_iva, monto_total, estado, datos_adicionales
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING fecha_emision`,
+		doc.ID, doc.SucursalID, doc.VentaID, doc.TipoDocumento, doc.Folio, doc.RUTReceptor,
+		doc.RazonSocialReceptor, doc.MontoNeto, doc.MontoIVA, doc.MontoTotal, doc.Estado,
+		doc.DatosAdicionales,
+	).Scan(&doc.FechaEmision)
+	if err != nil {
+		return fmt.Errorf("error registrando DTE: %w", err)
+	}
+
+	return nil
+}
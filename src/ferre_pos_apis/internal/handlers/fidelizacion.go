@@ -0,0 +1,935 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+const (
+	// puntosPorPesoFidelizacion puntos por peso pagado si configuracion_sistema no lo define
+	puntosPorPesoFidelizacion = 1.0
+	// minimoCanjeFidelizacion mínimo de puntos por canje si configuracion_sistema no lo define
+	minimoCanjeFidelizacion = 100
+	// diasExpiracionPuntos vigencia de los puntos acumulados si configuracion_sistema no la define
+	diasExpiracionPuntos = 365
+	// diasAvisoVencimientoPuntos anticipación con que se informan los puntos por vencer
+	diasAvisoVencimientoPuntos = 30
+	// diasPeriodoEstadisticas ventana de gasto de cache_estadisticas si no hay reglas de nivel por gasto
+	diasPeriodoEstadisticas = 365
+	// vigenciaEstadisticasFidelizacion antigüedad a partir de la cual se recalculan nivel y estadísticas
+	vigenciaEstadisticasFidelizacion = 24 * time.Hour
+	// clientesPorMantencion máximo de clientes procesados por etapa en cada ejecución de la mantención
+	clientesPorMantencion = 500
+)
+
+// FidelizacionHandler handler para el programa de fidelización de clientes
+type FidelizacionHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+}
+
+// NewFidelizacionHandler crea un nuevo handler de fidelización
+func NewFidelizacionHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *FidelizacionHandler {
+	return &FidelizacionHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+	}
+}
+
+// Create inscribe un cliente en el programa de fidelización y le asigna su código QR
+func (h *FidelizacionHandler) Create(c *gin.Context) {
+	var req models.ClienteFidelizacionRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	qr, err := generarQRFidelizacion()
+	if err != nil {
+		h.responderError(c, err, "Error inscribiendo cliente de fidelización")
+		return
+	}
+
+	cliente := &models.ClienteFidelizacion{
+		ID:                uuid.New(),
+		NivelFidelizacion: "bronce",
+		Activo:            true,
+		QRFidelizacion:    &qr,
+	}
+	aplicarClienteFidelizacionRequest(cliente, &req)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = h.db.QueryRowContext(ctx, `
+		INSERT INTO fidelizacion_clientes (
+			id, rut, nombre, apellido, email, telefono, fecha_nacimiento, direccion,
+			comuna, region, puntos_actuales, puntos_acumulados_total, nivel_fidelizacion,
+			activo, acepta_marketing, qr_fidelizacion, fecha_ultima_actividad
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 0, 0, $11, $12, $13, $14, NOW())
+		RETURNING fecha_ultima_actividad, fecha_creacion, fecha_modificacion`,
+		cliente.ID, cliente.RUT, cliente.Nombre, cliente.Apellido, cliente.Email, cliente.Telefono,
+		cliente.FechaNacimiento, cliente.Direccion, cliente.Comuna, cliente.Region,
+		cliente.NivelFidelizacion, cliente.Activo, cliente.AceptaMarketing, cliente.QRFidelizacion,
+	).Scan(&cliente.FechaUltimaActividad, &cliente.FechaCreacion, &cliente.FechaModificacion)
+	if err != nil {
+		h.responderError(c, err, "Error inscribiendo cliente de fidelización")
+		return
+	}
+
+	h.logger.WithField("cliente_id", cliente.ID).
+		WithField("rut", cliente.RUT).
+		Info("Cliente inscrito en fidelización")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      cliente,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// List busca clientes de fidelización por RUT o nombre
+func (h *FidelizacionHandler) List(c *gin.Context) {
+	query := `SELECT ` + columnasClienteFidelizacion + ` FROM fidelizacion_clientes WHERE 1 = 1`
+	var args []interface{}
+	if c.DefaultQuery("activos", "true") == "true" {
+		query += ` AND activo = true`
+	}
+	if q := c.Query("q"); q != "" {
+		args = append(args, "%"+q+"%")
+		n := strconv.Itoa(len(args))
+		query += ` AND (rut ILIKE $` + n + ` OR (nombre || ' ' || COALESCE(apellido, '')) ILIKE $` + n + `)`
+	}
+	query += ` ORDER BY nombre, apellido LIMIT 200`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		h.responderError(c, err, "Error listando clientes de fidelización")
+		return
+	}
+
+	clientes := []models.ClienteFidelizacion{}
+	err = database.ScanRows(rows, func() error {
+		cliente, err := scanClienteFidelizacion(rows)
+		if err != nil {
+			return err
+		}
+		clientes = append(clientes, *cliente)
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error listando clientes de fidelización")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      clientes,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetByID obtiene un cliente de fidelización con su saldo de puntos
+func (h *FidelizacionHandler) GetByID(c *gin.Context) {
+	clienteID, ok := uuidParam(c, "id", "INVALID_CLIENTE_ID", "ID de cliente de fidelización inválido")
+	if !ok {
+		return
+	}
+
+	h.responderCliente(c, "id", clienteID)
+}
+
+// GetByRUT obtiene el cliente de fidelización por RUT, usado por caja al identificar al cliente
+func (h *FidelizacionHandler) GetByRUT(c *gin.Context) {
+	h.responderCliente(c, "rut", c.Param("rut"))
+}
+
+// GetByQR obtiene el cliente de fidelización escaneando su código QR
+func (h *FidelizacionHandler) GetByQR(c *gin.Context) {
+	h.responderCliente(c, "qr_fidelizacion", c.Param("qr"))
+}
+
+// Update actualiza los datos de contacto, preferencias o estado del cliente
+func (h *FidelizacionHandler) Update(c *gin.Context) {
+	clienteID, ok := uuidParam(c, "id", "INVALID_CLIENTE_ID", "ID de cliente de fidelización inválido")
+	if !ok {
+		return
+	}
+
+	var req models.ClienteFidelizacionRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var cliente *models.ClienteFidelizacion
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		cliente, err = getClienteFidelizacion(ctx, tx, "id", clienteID, true)
+		if err != nil {
+			return err
+		}
+
+		aplicarClienteFidelizacionRequest(cliente, &req)
+		return tx.QueryRowContext(ctx, `
+			UPDATE fidelizacion_clientes
+			SET rut = $2, nombre = $3, apellido = $4, email = $5, telefono = $6,
+				fecha_nacimiento = $7, direccion = $8, comuna = $9, region = $10,
+				acepta_marketing = $11, activo = $12, fecha_modificacion = NOW()
+			WHERE id = $1
+			RETURNING fecha_modificacion`,
+			cliente.ID, cliente.RUT, cliente.Nombre, cliente.Apellido, cliente.Email,
+			cliente.Telefono, cliente.FechaNacimiento, cliente.Direccion, cliente.Comuna,
+			cliente.Region, cliente.AceptaMarketing, cliente.Activo,
+		).Scan(&cliente.FechaModificacion)
+	})
+	if err != nil {
+		h.responderError(c, err, "Error actualizando cliente de fidelización")
+		return
+	}
+
+	h.logger.WithField("cliente_id", cliente.ID).
+		WithField("activo", cliente.Activo).
+		Info("Cliente de fidelización actualizado")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      cliente,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetHistorial obtiene el saldo del cliente y sus últimos movimientos de puntos, opcionalmente por tipo
+func (h *FidelizacionHandler) GetHistorial(c *gin.Context) {
+	clienteID, ok := uuidParam(c, "id", "INVALID_CLIENTE_ID", "ID de cliente de fidelización inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cliente, err := getClienteFidelizacion(ctx, h.db, "id", clienteID, false)
+	if err != nil {
+		h.responderError(c, err, "Error consultando cliente de fidelización")
+		return
+	}
+
+	query := `SELECT ` + columnasMovimientoFidelizacion + ` FROM movimientos_fidelizacion WHERE cliente_id = $1`
+	args := []interface{}{clienteID}
+	if tipo := c.Query("tipo"); tipo != "" {
+		args = append(args, tipo)
+		query += ` AND tipo::text = $` + strconv.Itoa(len(args))
+	}
+	query += ` ORDER BY fecha DESC LIMIT 200`
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		h.responderError(c, err, "Error consultando movimientos de fidelización")
+		return
+	}
+
+	historial := models.HistorialFidelizacion{
+		Cliente:     *cliente,
+		Movimientos: []models.MovimientoFidelizacion{},
+	}
+	err = database.ScanRows(rows, func() error {
+		m, err := scanMovimientoFidelizacion(rows)
+		if err != nil {
+			return err
+		}
+		historial.Movimientos = append(historial.Movimientos, *m)
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error consultando movimientos de fidelización")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      historial,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// EjecutarMantencion vence puntos y recalcula niveles a pedido, sin esperar la ejecución programada
+func (h *FidelizacionHandler) EjecutarMantencion(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	resultado, err := h.mantenerFidelizacion(ctx)
+	if err != nil {
+		h.responderError(c, err, "Error ejecutando mantención de fidelización")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      resultado,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// StartMantencionFidelizacion inicia en background el vencimiento de puntos y el recálculo de niveles.
+// Cada cliente se procesa en su propia transacción recalculando desde sus movimientos, por lo que
+// ejecutar la mantención dos veces, o en varias instancias a la vez, no vence los mismos puntos dos veces.
+func (h *FidelizacionHandler) StartMantencionFidelizacion(intervalo time.Duration) {
+	go func() {
+		ticker := time.NewTicker(intervalo)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			resultado, err := h.mantenerFidelizacion(ctx)
+			cancel()
+
+			if err != nil {
+				h.logger.WithError(err).Error("Error en mantención de fidelización")
+				continue
+			}
+			if resultado.PuntosVencidos > 0 || resultado.CambiosNivel > 0 {
+				h.logger.WithField("lote_procesamiento", resultado.LoteProcesamiento).
+					WithField("puntos_vencidos", resultado.PuntosVencidos).
+					WithField("cambios_nivel", resultado.CambiosNivel).
+					Info("Mantención de fidelización ejecutada")
+			}
+		}
+	}()
+}
+
+// Métodos auxiliares
+
+// responderCliente responde con el cliente de fidelización encontrado por el campo indicado
+func (h *FidelizacionHandler) responderCliente(c *gin.Context, campo string, valor interface{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cliente, err := getClienteFidelizacion(ctx, h.db, campo, valor, false)
+	if err != nil {
+		h.responderError(c, err, "Error consultando cliente de fidelización")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      cliente,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// responderError responde errores de negocio, cliente inexistente, RUT duplicado o errores internos
+func (h *FidelizacionHandler) responderError(c *gin.Context, err error, mensaje string) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		err = &apiError{
+			status:  http.StatusNotFound,
+			code:    "CLIENTE_FIDELIZACION_NOT_FOUND",
+			message: "Cliente de fidelización no encontrado",
+		}
+	case esViolacionUnicidad(err):
+		err = &apiError{
+			status:  http.StatusConflict,
+			code:    "CLIENTE_FIDELIZACION_DUPLICADO",
+			message: "El RUT ya está inscrito en el programa de fidelización",
+		}
+	}
+
+	responderError(c, h.logger, err, "FIDELIZACION_ERROR", mensaje)
+}
+
+// aplicarClienteFidelizacionRequest copia los datos editables del request al cliente
+func aplicarClienteFidelizacionRequest(cliente *models.ClienteFidelizacion, req *models.ClienteFidelizacionRequest) {
+	cliente.RUT = req.RUT
+	cliente.Nombre = req.Nombre
+	cliente.Apellido = req.Apellido
+	cliente.Email = req.Email
+	cliente.Telefono = req.Telefono
+	cliente.FechaNacimiento = req.FechaNacimiento
+	cliente.Direccion = req.Direccion
+	cliente.Comuna = req.Comuna
+	cliente.Region = req.Region
+	cliente.AceptaMarketing = req.AceptaMarketing
+
+	if req.Activo != nil {
+		cliente.Activo = *req.Activo
+	}
+}
+
+// generarQRFidelizacion genera el código que se imprime en el QR de la tarjeta del cliente
+func generarQRFidelizacion() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generando código QR de fidelización: %w", err)
+	}
+	return "FID-" + hex.EncodeToString(b), nil
+}
+
+// columnasClienteFidelizacion columnas de fidelizacion_clientes en el orden esperado por scanClienteFidelizacion
+const columnasClienteFidelizacion = `id, rut, nombre, apellido, email, telefono, fecha_nacimiento, direccion,
+		comuna, region, COALESCE(puntos_actuales, 0), COALESCE(puntos_acumulados_total, 0),
+		COALESCE(nivel_fidelizacion, 'bronce'), fecha_ultima_compra, fecha_ultima_actividad,
+		COALESCE(activo, false), COALESCE(acepta_marketing, false), qr_fidelizacion,
+		fecha_proximo_vencimiento_puntos, COALESCE(puntos_por_vencer, 0), fecha_creacion,
+		fecha_modificacion`
+
+// scanClienteFidelizacion lee un cliente de fidelización desde una fila
+func scanClienteFidelizacion(row interface{ Scan(...interface{}) error }) (*models.ClienteFidelizacion, error) {
+	var cliente models.ClienteFidelizacion
+	err := row.Scan(
+		&cliente.ID, &cliente.RUT, &cliente.Nombre, &cliente.Apellido, &cliente.Email,
+		&cliente.Telefono, &cliente.FechaNacimiento, &cliente.Direccion, &cliente.Comuna,
+		&cliente.Region, &cliente.PuntosActuales, &cliente.PuntosAcumuladosTotal,
+		&cliente.NivelFidelizacion, &cliente.FechaUltimaCompra, &cliente.FechaUltimaActividad,
+		&cliente.Activo, &cliente.AceptaMarketing, &cliente.QRFidelizacion,
+		&cliente.FechaProximoVencimientoPuntos, &cliente.PuntosPorVencer, &cliente.FechaCreacion,
+		&cliente.FechaModificacion,
+	)
+	if err != nil {
+		return nil, err
+	}
+	cliente.ValorPuntos = redondearMonto(float64(cliente.PuntosActuales) * valorPuntoFidelizacion)
+	return &cliente, nil
+}
+
+// getClienteFidelizacion obtiene un cliente de fidelización filtrando por id, rut o qr_fidelizacion
+func getClienteFidelizacion(ctx context.Context, q sqlQueryer, campo string, valor interface{}, bloquear bool) (*models.ClienteFidelizacion, error) {
+	query := `SELECT ` + columnasClienteFidelizacion + ` FROM fidelizacion_clientes WHERE ` + campo + ` = $1`
+	if bloquear {
+		query += ` FOR UPDATE`
+	}
+
+	return scanClienteFidelizacion(q.QueryRowContext(ctx, query, valor))
+}
+
+// columnasMovimientoFidelizacion columnas de movimientos_fidelizacion en el orden esperado por scanMovimientoFidelizacion
+const columnasMovimientoFidelizacion = `id, cliente_id, sucursal_id, venta_id, tipo, puntos,
+		COALESCE(puntos_anteriores, 0), COALESCE(puntos_nuevos, 0), COALESCE(multiplicador, 1),
+		detalle, fecha, fecha_vencimiento, usuario_id, datos_adicionales, regla_aplicada_id`
+
+// scanMovimientoFidelizacion lee un movimiento de puntos desde una fila
+func scanMovimientoFidelizacion(row interface{ Scan(...interface{}) error }) (*models.MovimientoFidelizacion, error) {
+	var m models.MovimientoFidelizacion
+	err := row.Scan(
+		&m.ID, &m.ClienteID, &m.SucursalID, &m.VentaID, &m.Tipo, &m.Puntos, &m.PuntosAnteriores,
+		&m.PuntosNuevos, &m.Multiplicador, &m.Detalle, &m.Fecha, &m.FechaVencimiento, &m.UsuarioID,
+		&m.DatosAdicionales, &m.ReglaAplicadaID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// parametroFidelizacion lee un parámetro numérico de configuracion_sistema, usando el valor por defecto si no existe
+func parametroFidelizacion(ctx context.Context, q sqlQueryer, clave string, defecto float64) (float64, error) {
+	var valor sql.NullString
+	err := q.QueryRowContext(ctx,
+		`SELECT valor FROM configuracion_sistema WHERE clave = $1`,
+		clave,
+	).Scan(&valor)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return defecto, nil
+		}
+		return 0, fmt.Errorf("error consultando parámetro %s: %w", clave, err)
+	}
+	if !valor.Valid {
+		return defecto, nil
+	}
+
+	n, err := strconv.ParseFloat(valor.String, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parámetro %s inválido: %w", clave, err)
+	}
+	return n, nil
+}
+
+// acumulacionVenta puntos abonados por la venta y nivel alcanzado por el cliente
+type acumulacionVenta struct {
+	puntos     int
+	nivelNuevo string // Vacío si el cliente mantiene su nivel
+}
+
+// acumularPuntosVenta abona al cliente inscrito los puntos de la venta sobre lo pagado sin puntos.
+// Cada regla aplicada genera su propio movimiento con regla_aplicada_id y multiplicador.
+func acumularPuntosVenta(ctx context.Context, tx *sql.Tx, motor *motorFidelizacion, venta *models.Venta, detalles []models.DetalleVenta, mediosPago []models.MedioPagoVenta) (*acumulacionVenta, error) {
+	resultado := &acumulacionVenta{}
+	if venta.ClienteRUT == nil || *venta.ClienteRUT == "" {
+		return resultado, nil
+	}
+
+	var clienteID uuid.UUID
+	var puntosActuales int
+	var nivel string
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, COALESCE(puntos_actuales, 0), COALESCE(nivel_fidelizacion, 'bronce')
+		FROM fidelizacion_clientes
+		WHERE rut = $1 AND activo = true
+		FOR UPDATE`,
+		*venta.ClienteRUT,
+	).Scan(&clienteID, &puntosActuales, &nivel)
+	if err != nil {
+		// El RUT de la venta no siempre corresponde a un cliente inscrito
+		if err == sql.ErrNoRows {
+			return resultado, nil
+		}
+		return nil, fmt.Errorf("error consultando cliente de fidelización: %w", err)
+	}
+
+	// Lo pagado con puntos no genera nuevos puntos
+	base := 0.0
+	for _, mp := range mediosPago {
+		if mp.MedioPago != "puntos_fidelizacion" {
+			base += mp.Monto
+		}
+	}
+
+	puntosPorPeso, err := parametroFidelizacion(ctx, tx, "fidelizacion.puntos_por_peso", puntosPorPesoFidelizacion)
+	if err != nil {
+		return nil, err
+	}
+	diasVigencia, err := parametroFidelizacion(ctx, tx, "fidelizacion.expiracion_puntos_dias", diasExpiracionPuntos)
+	if err != nil {
+		return nil, err
+	}
+
+	ctxEval := contextoFidelizacionVenta(ctx, tx, venta, detalles, nivel, base)
+	aplicaciones, err := motor.evaluarAcumulacion(ctxEval, puntosPorPeso)
+	if err != nil {
+		return nil, err
+	}
+
+	vencimiento := time.Now().AddDate(0, 0, int(diasVigencia))
+	puntosReglas := make(map[uuid.UUID]int)
+	saldo := puntosActuales
+	for _, a := range aplicaciones {
+		if a.puntos <= 0 {
+			continue
+		}
+
+		detalle := fmt.Sprintf("Acumulación por venta %d", venta.NumeroVenta)
+		if a.reglaID != nil {
+			detalle += " - " + a.nombre
+			puntosReglas[*a.reglaID] += a.puntos
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO movimientos_fidelizacion (
+				cliente_id, sucursal_id, venta_id, tipo, puntos, puntos_anteriores,
+				puntos_nuevos, multiplicador, detalle, fecha_vencimiento, usuario_id,
+				datos_adicionales, regla_aplicada_id
+			) VALUES ($1, $2, $3, 'acumulacion', $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+			clienteID, venta.SucursalID, venta.ID, a.puntos, saldo, saldo+a.puntos,
+			a.multiplicador, detalle, vencimiento, venta.CajeroID,
+			models.JSONB{"monto_base": ctxEval.montoBase, "puntos_por_peso": puntosPorPeso}, a.reglaID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error registrando acumulación de puntos: %w", err)
+		}
+
+		saldo += a.puntos
+		resultado.puntos += a.puntos
+	}
+
+	// Las reglas de nivel se evalúan con el gasto que incluye la venta actual
+	reglaNivel, err := motor.evaluarNivel(ctxEval)
+	if err != nil {
+		return nil, err
+	}
+	nivelFinal := nivel
+	if reglaNivel != nil {
+		// La regla de nivel cuenta como aplicada aunque no otorgue puntos
+		nivelFinal = reglaNivel.Nivel
+		resultado.nivelNuevo = reglaNivel.Nivel
+		puntosReglas[reglaNivel.id] = 0
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE fidelizacion_clientes
+		SET puntos_actuales = $2,
+			puntos_acumulados_total = COALESCE(puntos_acumulados_total, 0) + $3,
+			fecha_proximo_vencimiento_puntos = CASE WHEN $3 > 0
+				THEN LEAST(fecha_proximo_vencimiento_puntos, $4) ELSE fecha_proximo_vencimiento_puntos END,
+			nivel_fidelizacion = $5,
+			fecha_ultima_compra = NOW(),
+			fecha_ultima_actividad = NOW(),
+			fecha_modificacion = NOW()
+		WHERE id = $1`,
+		clienteID, saldo, resultado.puntos, vencimiento, nivelFinal,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error actualizando puntos del cliente: %w", err)
+	}
+
+	if err := registrarAplicacionesReglas(ctx, tx, puntosReglas); err != nil {
+		return nil, err
+	}
+
+	return resultado, nil
+}
+
+// mantenerFidelizacion vence los puntos de los clientes con vencimientos próximos y recalcula
+// nivel y cache_estadisticas de los clientes con estadísticas desactualizadas
+func (h *FidelizacionHandler) mantenerFidelizacion(ctx context.Context) (*models.ResultadoMantencionFidelizacion, error) {
+	resultado := &models.ResultadoMantencionFidelizacion{
+		LoteProcesamiento: uuid.New(),
+		FechaEjecucion:    time.Now(),
+	}
+
+	ids, err := h.clientesMantencion(ctx, `
+		SELECT id FROM fidelizacion_clientes
+		WHERE fecha_proximo_vencimiento_puntos <= NOW() + make_interval(days => $1)
+		ORDER BY fecha_proximo_vencimiento_puntos
+		LIMIT $2`,
+		diasAvisoVencimientoPuntos, clientesPorMantencion,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando clientes con puntos por vencer: %w", err)
+	}
+
+	for _, clienteID := range ids {
+		var vencidos int
+		err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+			var err error
+			vencidos, err = vencerPuntosCliente(ctx, tx, clienteID, resultado.LoteProcesamiento)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error venciendo puntos del cliente %s: %w", clienteID, err)
+		}
+		if vencidos > 0 {
+			resultado.ClientesConVencimiento++
+			resultado.PuntosVencidos += vencidos
+		}
+	}
+
+	motor, err := cargarMotorFidelizacion(ctx, h.db, h.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err = h.clientesMantencion(ctx, `
+		SELECT id FROM fidelizacion_clientes
+		WHERE activo = true
+			AND (cache_estadisticas->>'fecha_calculo' IS NULL
+				OR (cache_estadisticas->>'fecha_calculo')::timestamptz < NOW() - make_interval(secs => $1))
+		ORDER BY cache_estadisticas->>'fecha_calculo' NULLS FIRST
+		LIMIT $2`,
+		vigenciaEstadisticasFidelizacion.Seconds(), clientesPorMantencion,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando clientes a recalcular: %w", err)
+	}
+
+	for _, clienteID := range ids {
+		var cambio bool
+		err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+			var err error
+			cambio, err = recalcularNivelCliente(ctx, tx, motor, clienteID)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error recalculando nivel del cliente %s: %w", clienteID, err)
+		}
+		resultado.ClientesRecalculados++
+		if cambio {
+			resultado.CambiosNivel++
+		}
+	}
+
+	return resultado, nil
+}
+
+// clientesMantencion obtiene los IDs de clientes a procesar en una etapa de la mantención
+func (h *FidelizacionHandler) clientesMantencion(ctx context.Context, query string, args ...interface{}) ([]uuid.UUID, error) {
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uuid.UUID
+	err = database.ScanRows(rows, func() error {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		return nil
+	})
+	return ids, err
+}
+
+// loteAcumulacion puntos de una acumulación pendientes de canjear o vencer
+type loteAcumulacion struct {
+	saldo            int
+	fechaVencimiento *time.Time
+}
+
+// movimientoPuntos movimiento de fidelización considerado al calcular vencimientos
+type movimientoPuntos struct {
+	tipo             string
+	puntos           int
+	fechaVencimiento *time.Time
+}
+
+// vencimientoPuntos puntos vencidos y por vencer de un cliente a una fecha
+type vencimientoPuntos struct {
+	vencidos           int
+	porVencer          int
+	lotesVencidos      int
+	proximoVencimiento *time.Time
+}
+
+// calcularVencimientoPuntos imputa FIFO los canjes, ajustes y vencimientos anteriores a las acumulaciones
+// que vencen primero y calcula, a la fecha indicada, los puntos vencidos y los que vencen dentro del aviso.
+// Los movimientos deben venir ordenados por fecha de vencimiento y fecha; los vencidos nunca superan el saldo actual.
+func calcularVencimientoPuntos(movimientos []movimientoPuntos, puntosActuales int, ahora time.Time) vencimientoPuntos {
+	var lotes []loteAcumulacion
+	consumido := 0
+	for _, m := range movimientos {
+		if m.tipo == "acumulacion" && m.puntos > 0 {
+			lotes = append(lotes, loteAcumulacion{saldo: m.puntos, fechaVencimiento: m.fechaVencimiento})
+		} else {
+			consumido -= m.puntos
+		}
+	}
+
+	// Los consumos se imputan a las acumulaciones que vencen primero
+	for i := range lotes {
+		if consumido <= 0 {
+			break
+		}
+		imputado := lotes[i].saldo
+		if imputado > consumido {
+			imputado = consumido
+		}
+		lotes[i].saldo -= imputado
+		consumido -= imputado
+	}
+
+	limiteAviso := ahora.AddDate(0, 0, diasAvisoVencimientoPuntos)
+	var v vencimientoPuntos
+	for _, l := range lotes {
+		if l.saldo <= 0 || l.fechaVencimiento == nil {
+			continue
+		}
+		switch {
+		case !l.fechaVencimiento.After(ahora):
+			v.vencidos += l.saldo
+			v.lotesVencidos++
+		default:
+			if v.proximoVencimiento == nil {
+				v.proximoVencimiento = l.fechaVencimiento
+			}
+			if !l.fechaVencimiento.After(limiteAviso) {
+				v.porVencer += l.saldo
+			}
+		}
+	}
+
+	// El saldo nunca queda negativo aunque los movimientos no cuadren con puntos_actuales
+	if v.vencidos > puntosActuales {
+		v.vencidos = puntosActuales
+	}
+	return v
+}
+
+// vencerPuntosCliente vence los puntos acumulados cuya fecha de vencimiento pasó, imputando FIFO a las
+// acumulaciones más antiguas todo lo ya consumido por canjes, ajustes y vencimientos anteriores.
+// Actualiza también puntos_por_vencer y fecha_proximo_vencimiento_puntos.
+func vencerPuntosCliente(ctx context.Context, tx *sql.Tx, clienteID, loteID uuid.UUID) (int, error) {
+	var puntosActuales int
+	var sucursalID *uuid.UUID
+	err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(c.puntos_actuales, 0),
+			(SELECT m.sucursal_id FROM movimientos_fidelizacion m
+			 WHERE m.cliente_id = c.id ORDER BY m.fecha DESC LIMIT 1)
+		FROM fidelizacion_clientes c
+		WHERE c.id = $1
+		FOR UPDATE OF c`,
+		clienteID,
+	).Scan(&puntosActuales, &sucursalID)
+	if err != nil {
+		return 0, fmt.Errorf("error consultando cliente de fidelización: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT tipo::text, puntos, fecha_vencimiento
+		FROM movimientos_fidelizacion
+		WHERE cliente_id = $1
+		ORDER BY fecha_vencimiento NULLS LAST, fecha`,
+		clienteID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error consultando movimientos de fidelización: %w", err)
+	}
+
+	var movimientos []movimientoPuntos
+	err = database.ScanRows(rows, func() error {
+		var m movimientoPuntos
+		if err := rows.Scan(&m.tipo, &m.puntos, &m.fechaVencimiento); err != nil {
+			return err
+		}
+		movimientos = append(movimientos, m)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error leyendo movimientos de fidelización: %w", err)
+	}
+
+	v := calcularVencimientoPuntos(movimientos, puntosActuales, time.Now())
+
+	puntosNuevos := puntosActuales - v.vencidos
+	if v.vencidos > 0 {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO movimientos_fidelizacion (
+				cliente_id, sucursal_id, tipo, puntos, puntos_anteriores, puntos_nuevos,
+				detalle, datos_adicionales, lote_procesamiento
+			) VALUES ($1, $2, 'expiracion', $3, $4, $5, $6, $7, $8)`,
+			clienteID, sucursalID, -v.vencidos, puntosActuales, puntosNuevos,
+			"Vencimiento de puntos", models.JSONB{"acumulaciones_vencidas": v.lotesVencidos}, loteID,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("error registrando vencimiento de puntos: %w", err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE fidelizacion_clientes
+		SET puntos_actuales = $2,
+			puntos_por_vencer = $3,
+			fecha_proximo_vencimiento_puntos = $4,
+			fecha_modificacion = NOW()
+		WHERE id = $1`,
+		clienteID, puntosNuevos, v.porVencer, v.proximoVencimiento,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error actualizando vencimiento de puntos: %w", err)
+	}
+
+	return v.vencidos, nil
+}
+
+// recalcularNivelCliente asigna el nivel que corresponde al gasto móvil del cliente, subiéndolo o bajándolo,
+// y actualiza cache_estadisticas. Si no hay reglas de nivel por gasto el nivel no se modifica.
+func recalcularNivelCliente(ctx context.Context, tx *sql.Tx, motor *motorFidelizacion, clienteID uuid.UUID) (bool, error) {
+	var rut, nivelActual string
+	var puntosPorVencer int
+	err := tx.QueryRowContext(ctx, `
+		SELECT rut, COALESCE(nivel_fidelizacion, 'bronce'), COALESCE(puntos_por_vencer, 0)
+		FROM fidelizacion_clientes
+		WHERE id = $1
+		FOR UPDATE`,
+		clienteID,
+	).Scan(&rut, &nivelActual, &puntosPorVencer)
+	if err != nil {
+		return false, fmt.Errorf("error consultando cliente de fidelización: %w", err)
+	}
+
+	gastos := make(map[int]float64)
+	gasto := func(dias int) (float64, error) {
+		if g, ok := gastos[dias]; ok {
+			return g, nil
+		}
+		var g float64
+		err := tx.QueryRowContext(ctx, `
+			SELECT COALESCE(SUM(total), 0)
+			FROM ventas
+			WHERE cliente_rut = $1 AND estado <> 'anulada'
+				AND fecha >= NOW() - make_interval(days => $2)`,
+			rut, dias,
+		).Scan(&g)
+		if err != nil {
+			return 0, fmt.Errorf("error consultando gasto del cliente: %w", err)
+		}
+		gastos[dias] = g
+		return g, nil
+	}
+
+	nivel, reglaID, hayReglas, err := motor.nivelPorGasto(gasto)
+	if err != nil {
+		return false, err
+	}
+	if !hayReglas {
+		nivel = nivelActual
+	}
+
+	dias := motor.diasPeriodoNivel(diasPeriodoEstadisticas)
+	var compras int
+	var gastoPeriodo float64
+	var ultimaCompra *time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(total), 0), MAX(fecha)
+		FROM ventas
+		WHERE cliente_rut = $1 AND estado <> 'anulada'
+			AND fecha >= NOW() - make_interval(days => $2)`,
+		rut, dias,
+	).Scan(&compras, &gastoPeriodo, &ultimaCompra)
+	if err != nil {
+		return false, fmt.Errorf("error calculando estadísticas del cliente: %w", err)
+	}
+
+	ticketPromedio := 0.0
+	if compras > 0 {
+		ticketPromedio = redondearMonto(gastoPeriodo / float64(compras))
+	}
+	estadisticas := models.JSONB{
+		"fecha_calculo":       time.Now(),
+		"periodo_dias":        dias,
+		"compras_periodo":     compras,
+		"gasto_periodo":       redondearMonto(gastoPeriodo),
+		"ticket_promedio":     ticketPromedio,
+		"fecha_ultima_compra": ultimaCompra,
+		"puntos_por_vencer":   puntosPorVencer,
+		"nivel_calculado":     nivel,
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE fidelizacion_clientes
+		SET nivel_fidelizacion = $2, cache_estadisticas = $3, fecha_modificacion = NOW()
+		WHERE id = $1`,
+		clienteID, nivel, estadisticas,
+	)
+	if err != nil {
+		return false, fmt.Errorf("error actualizando nivel del cliente: %w", err)
+	}
+
+	cambio := nivel != nivelActual
+	if cambio && reglaID != nil && nivelesFidelizacion[nivel] > nivelesFidelizacion[nivelActual] {
+		if err := registrarAplicacionesReglas(ctx, tx, map[uuid.UUID]int{*reglaID: 0}); err != nil {
+			return false, err
+		}
+	}
+
+	return cambio, nil
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalcularVencimientoPuntos(t *testing.T) {
+	ahora := time.Date(2026, 6, 15, 12, 0, 0, 0, time.UTC)
+	fecha := func(mes time.Month, dia int) *time.Time {
+		f := time.Date(2026, mes, dia, 0, 0, 0, 0, time.UTC)
+		return &f
+	}
+	acumulacion := func(puntos int, vencimiento *time.Time) movimientoPuntos {
+		return movimientoPuntos{tipo: "acumulacion", puntos: puntos, fechaVencimiento: vencimiento}
+	}
+	movimiento := func(tipo string, puntos int) movimientoPuntos {
+		return movimientoPuntos{tipo: tipo, puntos: puntos}
+	}
+
+	tests := []struct {
+		name           string
+		movimientos    []movimientoPuntos
+		puntosActuales int
+		esperado       vencimientoPuntos
+	}{
+		{
+			name: "canjes imputados FIFO a acumulaciones antiguas parcialmente canjeadas",
+			movimientos: []movimientoPuntos{
+				acumulacion(100, fecha(time.May, 1)),
+				acumulacion(200, fecha(time.June, 1)),
+				acumulacion(150, fecha(time.July, 1)),
+				acumulacion(300, fecha(time.December, 1)),
+				movimiento("canje", -120),
+				movimiento("ajuste", -30),
+			},
+			puntosActuales: 600,
+			esperado:       vencimientoPuntos{vencidos: 150, lotesVencidos: 1, porVencer: 150, proximoVencimiento: fecha(time.July, 1)},
+		},
+		{
+			name: "canjes que superan las acumulaciones vencidas descuentan de las vigentes",
+			movimientos: []movimientoPuntos{
+				acumulacion(100, fecha(time.May, 1)),
+				acumulacion(200, fecha(time.July, 1)),
+				movimiento("canje", -250),
+			},
+			puntosActuales: 50,
+			esperado:       vencimientoPuntos{porVencer: 50, proximoVencimiento: fecha(time.July, 1)},
+		},
+		{
+			name: "vencimientos anteriores no se vuelven a vencer",
+			movimientos: []movimientoPuntos{
+				acumulacion(100, fecha(time.March, 1)),
+				acumulacion(80, fecha(time.May, 1)),
+				movimiento("expiracion", -100),
+				movimiento("canje", -30),
+			},
+			puntosActuales: 50,
+			esperado:       vencimientoPuntos{vencidos: 50, lotesVencidos: 1},
+		},
+		{
+			name: "vence exactamente a la fecha de proceso",
+			movimientos: []movimientoPuntos{
+				acumulacion(40, &ahora),
+				acumulacion(60, fecha(time.September, 1)),
+			},
+			puntosActuales: 100,
+			esperado:       vencimientoPuntos{vencidos: 40, lotesVencidos: 1, proximoVencimiento: fecha(time.September, 1)},
+		},
+		{
+			name: "acumulaciones sin vencimiento no vencen",
+			movimientos: []movimientoPuntos{
+				acumulacion(500, nil),
+				movimiento("canje", -100),
+			},
+			puntosActuales: 400,
+		},
+		{
+			name: "los vencidos no superan el saldo actual",
+			movimientos: []movimientoPuntos{
+				acumulacion(300, fecha(time.May, 1)),
+				movimiento("canje", -100),
+			},
+			puntosActuales: 120,
+			esperado:       vencimientoPuntos{vencidos: 120, lotesVencidos: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.esperado, calcularVencimientoPuntos(tt.movimientos, tt.puntosActuales, ahora))
+		})
+	}
+}
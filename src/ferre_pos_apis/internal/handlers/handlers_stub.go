@@ -8,159 +8,12 @@ import (
 	
 	"ferre_pos_apis/internal/database"
 	"ferre_pos_apis/internal/logger"
-	"ferre_pos_apis/internal/metrics"
 	"ferre_pos_apis/internal/models"
 	"ferre_pos_apis/pkg/validator"
 )
 
 // Handlers stub - implementaciones básicas para completar el API POS
 
-// VentasHandler handler para operaciones de ventas
-type VentasHandler struct {
-	db        *database.Database
-	logger    logger.Logger
-	validator validator.Validator
-	metrics   *metrics.Metrics
-}
-
-// NewVentasHandler crea un nuevo handler de ventas
-func NewVentasHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *VentasHandler {
-	return &VentasHandler{
-		db:        db,
-		logger:    log,
-		validator: val,
-		metrics:   met,
-	}
-}
-
-// Create crea una nueva venta
-func (h *VentasHandler) Create(c *gin.Context) {
-	c.JSON(http.StatusOK, models.APIResponse{
-		Success:   true,
-		Data:      gin.H{"message": "Venta creada exitosamente"},
-		RequestID: getRequestID(c),
-		Timestamp: time.Now(),
-	})
-}
-
-// GetByID obtiene una venta por ID
-func (h *VentasHandler) GetByID(c *gin.Context) {
-	c.JSON(http.StatusOK, models.APIResponse{
-		Success:   true,
-		Data:      gin.H{"message": "Venta encontrada"},
-		RequestID: getRequestID(c),
-		Timestamp: time.Now(),
-	})
-}
-
-// List lista ventas
-func (h *VentasHandler) List(c *gin.Context) {
-	c.JSON(http.StatusOK, models.APIResponse{
-		Success:   true,
-		Data:      []interface{}{},
-		RequestID: getRequestID(c),
-		Timestamp: time.Now(),
-	})
-}
-
-// GetByNumero obtiene venta por número
-func (h *VentasHandler) GetByNumero(c *gin.Context) {
-	c.JSON(http.StatusOK, models.APIResponse{
-		Success:   true,
-		Data:      gin.H{"message": "Venta encontrada por número"},
-		RequestID: getRequestID(c),
-		Timestamp: time.Now(),
-	})
-}
-
-// Anular anula una venta
-func (h *VentasHandler) Anular(c *gin.Context) {
-	c.JSON(http.StatusOK, models.APIResponse{
-		Success:   true,
-		Data:      gin.H{"message": "Venta anulada exitosamente"},
-		RequestID: getRequestID(c),
-		Timestamp: time.Now(),
-	})
-}
-
-// GenerarDTE genera DTE para una venta
-func (h *VentasHandler) GenerarDTE(c *gin.Context) {
-	c.JSON(http.StatusOK, models.APIResponse{
-		Success:   true,
-		Data:      gin.H{"message": "DTE generado exitosamente"},
-		RequestID: getRequestID(c),
-		Timestamp: time.Now(),
-	})
-}
-
-// StockHandler handler para operaciones de stock
-type StockHandler struct {
-	db        *database.Database
-	logger    logger.Logger
-	validator validator.Validator
-	metrics   *metrics.Metrics
-}
-
-// NewStockHandler crea un nuevo handler de stock
-func NewStockHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *StockHandler {
-	return &StockHandler{
-		db:        db,
-		logger:    log,
-		validator: val,
-		metrics:   met,
-	}
-}
-
-// GetByProducto obtiene stock por producto
-func (h *StockHandler) GetByProducto(c *gin.Context) {
-	c.JSON(http.StatusOK, models.APIResponse{
-		Success:   true,
-		Data:      gin.H{"stock_disponible": 100},
-		RequestID: getRequestID(c),
-		Timestamp: time.Now(),
-	})
-}
-
-// GetBySucursal obtiene stock por sucursal
-func (h *StockHandler) GetBySucursal(c *gin.Context) {
-	c.JSON(http.StatusOK, models.APIResponse{
-		Success:   true,
-		Data:      []interface{}{},
-		RequestID: getRequestID(c),
-		Timestamp: time.Now(),
-	})
-}
-
-// ReservarStock reserva stock
-func (h *StockHandler) ReservarStock(c *gin.Context) {
-	c.JSON(http.StatusOK, models.APIResponse{
-		Success:   true,
-		Data:      gin.H{"message": "Stock reservado exitosamente"},
-		RequestID: getRequestID(c),
-		Timestamp: time.Now(),
-	})
-}
-
-// LiberarStock libera stock reservado
-func (h *StockHandler) LiberarStock(c *gin.Context) {
-	c.JSON(http.StatusOK, models.APIResponse{
-		Success:   true,
-		Data:      gin.H{"message": "Stock liberado exitosamente"},
-		RequestID: getRequestID(c),
-		Timestamp: time.Now(),
-	})
-}
-
-// GetAlertas obtiene alertas de stock
-func (h *StockHandler) GetAlertas(c *gin.Context) {
-	c.JSON(http.StatusOK, models.APIResponse{
-		Success:   true,
-		Data:      []interface{}{},
-		RequestID: getRequestID(c),
-		Timestamp: time.Now(),
-	})
-}
-
 // UsuariosHandler handler para operaciones de usuarios
 type UsuariosHandler struct {
 	db        *database.Database
@@ -393,14 +246,3 @@ func (h *VentasHandler) GetProductosVendidos(c *gin.Context) {
 		Timestamp: time.Now(),
 	})
 }
-
-// Métodos adicionales para ProductosHandler
-func (h *ProductosHandler) GetStockBajo(c *gin.Context) {
-	c.JSON(http.StatusOK, models.APIResponse{
-		Success:   true,
-		Data:      []interface{}{},
-		RequestID: getRequestID(c),
-		Timestamp: time.Now(),
-	})
-}
-
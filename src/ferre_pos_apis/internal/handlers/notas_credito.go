@@ -0,0 +1,890 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+// estadosNotaCreditoVigente estados de nota de crédito que comprometen cantidades y montos de la venta
+const estadosNotaCreditoVigente = `('pendiente', 'procesado', 'enviado')`
+
+// NotasCreditoHandler handler para devoluciones con nota de crédito
+type NotasCreditoHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+}
+
+// NewNotasCreditoHandler crea un nuevo handler de notas de crédito
+func NewNotasCreditoHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *NotasCreditoHandler {
+	return &NotasCreditoHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+	}
+}
+
+// GetVentaDevolvible obtiene la venta de origen con las cantidades y montos que aún pueden devolverse
+func (h *NotasCreditoHandler) GetVentaDevolvible(c *gin.Context) {
+	ventaID, ok := uuidParam(c, "venta_id", "INVALID_VENTA_ID", "ID de venta inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	venta, err := getVentaOrigen(ctx, h.db, ventaID, false)
+	if err != nil {
+		h.responderError(c, err, "Error consultando venta")
+		return
+	}
+
+	lineas, err := lineasDevolvibles(ctx, h.db, venta.ID)
+	if err != nil {
+		h.responderError(c, err, "Error consultando venta")
+		return
+	}
+
+	reembolsable, err := reembolsablePorMedio(ctx, h.db, venta.ID)
+	if err != nil {
+		h.responderError(c, err, "Error consultando venta")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: models.VentaDevolvible{
+			Venta:                *venta,
+			Lineas:               lineas,
+			ReembolsablePorMedio: reembolsable,
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Create registra la solicitud de devolución, que queda pendiente de autorización del supervisor
+func (h *NotasCreditoHandler) Create(c *gin.Context) {
+	var req models.NotaCreditoRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	cajeroID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var nota *models.NotaCredito
+	txStart := time.Now()
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		nota, err = solicitarNotaCredito(ctx, tx, &req, cajeroID)
+		return err
+	})
+	if h.metrics != nil {
+		h.metrics.RecordDatabaseTransaction("pos", time.Since(txStart), err)
+	}
+	if err != nil {
+		h.responderError(c, err, "Error registrando devolución")
+		return
+	}
+
+	h.logger.WithField("nota_credito_id", nota.ID).
+		WithField("venta_id", nota.VentaOrigenID).
+		WithField("total", nota.Total).
+		Info("Devolución registrada, pendiente de autorización")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      nota,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// List lista notas de crédito de la sucursal, opcionalmente por estado o venta de origen
+func (h *NotasCreditoHandler) List(c *gin.Context) {
+	query := `SELECT ` + columnasNotaCredito + ` FROM notas_credito WHERE 1 = 1`
+	var args []interface{}
+	if sucursalID := getSucursalID(c); sucursalID != "" {
+		args = append(args, sucursalID)
+		query += ` AND sucursal_id = $` + strconv.Itoa(len(args))
+	}
+	if estado := c.Query("estado"); estado != "" {
+		args = append(args, estado)
+		query += ` AND estado = $` + strconv.Itoa(len(args))
+	}
+	if ventaID := c.Query("venta_id"); ventaID != "" {
+		args = append(args, ventaID)
+		query += ` AND venta_origen_id = $` + strconv.Itoa(len(args))
+	}
+	query += ` ORDER BY fecha_solicitud DESC LIMIT 200`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		h.responderError(c, err, "Error listando notas de crédito")
+		return
+	}
+
+	notas := []models.NotaCredito{}
+	err = database.ScanRows(rows, func() error {
+		nota, err := scanNotaCredito(rows)
+		if err != nil {
+			return err
+		}
+		notas = append(notas, *nota)
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error listando notas de crédito")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      notas,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetByID obtiene una nota de crédito con sus líneas y reembolsos
+func (h *NotasCreditoHandler) GetByID(c *gin.Context) {
+	notaID, ok := uuidParam(c, "id", "INVALID_NOTA_CREDITO_ID", "ID de nota de crédito inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	nota, err := getNotaCredito(ctx, h.db, notaID, false)
+	if err == nil {
+		err = cargarDetalleNotaCredito(ctx, h.db, nota)
+	}
+	if err != nil {
+		h.responderError(c, err, "Error consultando nota de crédito")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      nota,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Autorizar aprueba la devolución: restaura stock, entrega el reembolso en la caja y emite la nota de crédito electrónica
+func (h *NotasCreditoHandler) Autorizar(c *gin.Context) {
+	notaID, ok := uuidParam(c, "id", "INVALID_NOTA_CREDITO_ID", "ID de nota de crédito inválido")
+	if !ok {
+		return
+	}
+
+	supervisorID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var nota *models.NotaCredito
+	txStart := time.Now()
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		nota, err = bloquearNotaPendiente(ctx, tx, notaID, getUserSucursalID(c), getUserRole(c))
+		if err != nil {
+			return err
+		}
+		return autorizarNotaCredito(ctx, tx, nota, supervisorID)
+	})
+	if h.metrics != nil {
+		h.metrics.RecordDatabaseTransaction("pos", time.Since(txStart), err)
+	}
+	if err != nil {
+		h.responderError(c, err, "Error autorizando devolución")
+		return
+	}
+
+	h.logger.WithField("nota_credito_id", nota.ID).
+		WithField("supervisor_id", supervisorID).
+		WithField("dte_id", nota.DTEID).
+		Info("Nota de crédito autorizada y emitida")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      nota,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Rechazar rechaza una devolución pendiente liberando las cantidades comprometidas
+func (h *NotasCreditoHandler) Rechazar(c *gin.Context) {
+	notaID, ok := uuidParam(c, "id", "INVALID_NOTA_CREDITO_ID", "ID de nota de crédito inválido")
+	if !ok {
+		return
+	}
+
+	var req models.RechazarNotaCreditoRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	supervisorID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var nota *models.NotaCredito
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		nota, err = bloquearNotaPendiente(ctx, tx, notaID, getUserSucursalID(c), getUserRole(c))
+		if err != nil {
+			return err
+		}
+
+		return tx.QueryRowContext(ctx, `
+			UPDATE notas_credito
+			SET estado = 'rechazado', supervisor_id = $2, fecha_autorizacion = NOW(), observaciones = $3
+			WHERE id = $1
+			RETURNING estado, supervisor_id, fecha_autorizacion, observaciones`,
+			nota.ID, supervisorID, req.Motivo,
+		).Scan(&nota.Estado, &nota.SupervisorID, &nota.FechaAutorizacion, &nota.Observaciones)
+	})
+	if err != nil {
+		h.responderError(c, err, "Error rechazando devolución")
+		return
+	}
+
+	h.logger.WithField("nota_credito_id", nota.ID).
+		WithField("supervisor_id", supervisorID).
+		Info("Devolución rechazada")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      nota,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Métodos auxiliares
+
+// responderError responde errores de negocio, nota inexistente o errores internos
+func (h *NotasCreditoHandler) responderError(c *gin.Context, err error, mensaje string) {
+	if errors.Is(err, sql.ErrNoRows) {
+		err = &apiError{
+			status:  http.StatusNotFound,
+			code:    "NOTA_CREDITO_NOT_FOUND",
+			message: "Nota de crédito no encontrada",
+		}
+	}
+
+	responderError(c, h.logger, err, "NOTA_CREDITO_ERROR", mensaje)
+}
+
+// getVentaOrigen obtiene la venta a la que se asocia una devolución
+func getVentaOrigen(ctx context.Context, q sqlQueryer, ventaID uuid.UUID, bloquear bool) (*models.Venta, error) {
+	query := `
+		SELECT id, numero_venta, sucursal_id, terminal_id, cajero_id, cliente_rut, cliente_nombre,
+			tipo_documento, subtotal, descuento_total, impuesto_total, total, estado, fecha,
+			dte_id, dte_emitido
+		FROM ventas
+		WHERE id = $1`
+	if bloquear {
+		query += ` FOR UPDATE`
+	}
+
+	var venta models.Venta
+	err := q.QueryRowContext(ctx, query, ventaID).Scan(
+		&venta.ID, &venta.NumeroVenta, &venta.SucursalID, &venta.TerminalID, &venta.CajeroID,
+		&venta.ClienteRUT, &venta.ClienteNombre, &venta.TipoDocumento, &venta.Subtotal,
+		&venta.DescuentoTotal, &venta.ImpuestoTotal, &venta.Total, &venta.Estado, &venta.Fecha,
+		&venta.DTEID, &venta.DTEEmitido,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &apiError{
+				status:  http.StatusNotFound,
+				code:    "VENTA_NOT_FOUND",
+				message: "Venta no encontrada",
+			}
+		}
+		return nil, fmt.Errorf("error consultando venta: %w", err)
+	}
+
+	return &venta, nil
+}
+
+// lineasDevolvibles calcula por línea de la venta lo vendido, lo ya devuelto y lo que queda por devolver
+func lineasDevolvibles(ctx context.Context, q sqlQueryer, ventaID uuid.UUID) ([]models.LineaDevolvible, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT dv.id, dv.producto_id, p.descripcion, dv.unidad_medida, dv.numero_serie, dv.lote,
+			dv.precio_final, dv.cantidad,
+			COALESCE((
+				SELECT SUM(dnc.cantidad)
+				FROM detalle_notas_credito dnc
+				JOIN notas_credito nc ON nc.id = dnc.nota_credito_id
+				WHERE dnc.detalle_venta_id = dv.id AND nc.estado IN `+estadosNotaCreditoVigente+`
+			), 0)
+		FROM detalle_ventas dv
+		JOIN productos p ON p.id = dv.producto_id
+		WHERE dv.venta_id = $1
+		ORDER BY p.descripcion`,
+		ventaID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando detalle de venta: %w", err)
+	}
+
+	lineas := []models.LineaDevolvible{}
+	err = database.ScanRows(rows, func() error {
+		var l models.LineaDevolvible
+		if err := rows.Scan(&l.DetalleVentaID, &l.ProductoID, &l.Descripcion, &l.UnidadMedida,
+			&l.NumeroSerie, &l.Lote, &l.PrecioFinal, &l.CantidadVendida, &l.CantidadDevuelta); err != nil {
+			return err
+		}
+		l.CantidadDevolvible = l.CantidadVendida - l.CantidadDevuelta
+		if l.CantidadDevolvible < 0 {
+			l.CantidadDevolvible = 0
+		}
+		lineas = append(lineas, l)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo detalle de venta: %w", err)
+	}
+
+	return lineas, nil
+}
+
+// reembolsablePorMedio calcula por medio de pago lo cobrado en la venta menos lo ya comprometido en reembolsos
+func reembolsablePorMedio(ctx context.Context, q sqlQueryer, ventaID uuid.UUID) (map[string]float64, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT medio_pago, SUM(monto)
+		FROM (
+			SELECT medio_pago, monto
+			FROM medios_pago_venta
+			WHERE venta_id = $1
+			UNION ALL
+			SELECT r.medio_pago, -r.monto
+			FROM reembolsos_nota_credito r
+			JOIN notas_credito nc ON nc.id = r.nota_credito_id
+			WHERE nc.venta_origen_id = $1 AND nc.estado IN `+estadosNotaCreditoVigente+`
+		) m
+		GROUP BY medio_pago`,
+		ventaID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando medios de pago de la venta: %w", err)
+	}
+
+	reembolsable := map[string]float64{}
+	err = database.ScanRows(rows, func() error {
+		var medio string
+		var monto float64
+		if err := rows.Scan(&medio, &monto); err != nil {
+			return err
+		}
+		if monto > 0 {
+			reembolsable[medio] = redondearMonto(monto)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo medios de pago de la venta: %w", err)
+	}
+
+	return reembolsable, nil
+}
+
+// solicitarNotaCredito valida las cantidades y reembolsos contra la venta y registra la nota pendiente
+func solicitarNotaCredito(ctx context.Context, tx *sql.Tx, req *models.NotaCreditoRequest, cajeroID uuid.UUID) (*models.NotaCredito, error) {
+	// El bloqueo de la venta serializa devoluciones concurrentes sobre las mismas líneas
+	venta, err := getVentaOrigen(ctx, tx, req.VentaID, true)
+	if err != nil {
+		return nil, err
+	}
+	if venta.Estado == "anulada" {
+		return nil, &apiError{
+			status:  http.StatusConflict,
+			code:    "VENTA_ANULADA",
+			message: "La venta se encuentra anulada",
+			details: models.JSONB{"venta_id": venta.ID},
+		}
+	}
+
+	lineas, err := lineasDevolvibles(ctx, tx, venta.ID)
+	if err != nil {
+		return nil, err
+	}
+	porDetalle := make(map[uuid.UUID]models.LineaDevolvible, len(lineas))
+	for _, l := range lineas {
+		porDetalle[l.DetalleVentaID] = l
+	}
+
+	nota := &models.NotaCredito{
+		ID:            uuid.New(),
+		SucursalID:    venta.SucursalID,
+		VentaOrigenID: venta.ID,
+		TerminalID:    req.TerminalID,
+		CajeroID:      cajeroID,
+		Motivo:        req.Motivo,
+		Estado:        models.EstadoPendiente,
+	}
+
+	vistos := make(map[uuid.UUID]bool, len(req.Items))
+	for i, item := range req.Items {
+		linea, ok := porDetalle[item.DetalleVentaID]
+		if !ok {
+			return nil, &apiError{
+				status:  http.StatusBadRequest,
+				code:    "LINEA_NO_PERTENECE_VENTA",
+				message: "La línea no pertenece a la venta",
+				details: models.JSONB{"item": i, "detalle_venta_id": item.DetalleVentaID},
+			}
+		}
+		if vistos[item.DetalleVentaID] {
+			return nil, &apiError{
+				status:  http.StatusBadRequest,
+				code:    "LINEA_DUPLICADA",
+				message: "La línea de la venta se informó más de una vez",
+				details: models.JSONB{"item": i, "detalle_venta_id": item.DetalleVentaID},
+			}
+		}
+		vistos[item.DetalleVentaID] = true
+
+		if item.Cantidad > linea.CantidadDevolvible+toleranciaFraccion {
+			return nil, &apiError{
+				status:  http.StatusConflict,
+				code:    "CANTIDAD_DEVOLUCION_EXCEDIDA",
+				message: "La cantidad supera lo vendido menos lo ya devuelto",
+				details: models.JSONB{
+					"item":                i,
+					"detalle_venta_id":    item.DetalleVentaID,
+					"cantidad_vendida":    linea.CantidadVendida,
+					"cantidad_devuelta":   linea.CantidadDevuelta,
+					"cantidad_devolvible": linea.CantidadDevolvible,
+				},
+			}
+		}
+
+		afectaStock := true
+		if item.AfectaStock != nil {
+			afectaStock = *item.AfectaStock
+		}
+		detalle := models.DetalleNotaCredito{
+			ID:             uuid.New(),
+			NotaCreditoID:  nota.ID,
+			DetalleVentaID: linea.DetalleVentaID,
+			ProductoID:     linea.ProductoID,
+			Cantidad:       item.Cantidad,
+			PrecioUnitario: linea.PrecioFinal,
+			TotalItem:      redondearMonto(linea.PrecioFinal * item.Cantidad),
+			MotivoItem:     item.Motivo,
+			AfectaStock:    afectaStock,
+		}
+		nota.Detalles = append(nota.Detalles, detalle)
+		nota.Subtotal += detalle.TotalItem
+	}
+
+	nota.Subtotal = redondearMonto(nota.Subtotal)
+	nota.ImpuestoTotal = redondearMonto(nota.Subtotal * tasaIVA)
+	nota.Total = redondearMonto(nota.Subtotal + nota.ImpuestoTotal)
+
+	if err := validarReembolsos(ctx, tx, venta, nota, req.Reembolsos); err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO notas_credito (
+			id, sucursal_id, venta_origen_id, terminal_id, cajero_id, motivo, subtotal,
+			descuento_total, impuesto_total, total, estado
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING numero_nota, fecha_solicitud`,
+		nota.ID, nota.SucursalID, nota.VentaOrigenID, nota.TerminalID, nota.CajeroID, nota.Motivo,
+		nota.Subtotal, nota.DescuentoTotal, nota.ImpuestoTotal, nota.Total, nota.Estado,
+	).Scan(&nota.NumeroNota, &nota.FechaSolicitud)
+	if err != nil {
+		return nil, fmt.Errorf("error insertando nota de crédito: %w", err)
+	}
+
+	for _, d := range nota.Detalles {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO detalle_notas_credito (
+				id, nota_credito_id, detalle_venta_id, producto_id, cantidad, precio_unitario,
+				total_item, motivo_item, afecta_stock
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			d.ID, d.NotaCreditoID, d.DetalleVentaID, d.ProductoID, d.Cantidad, d.PrecioUnitario,
+			d.TotalItem, d.MotivoItem, d.AfectaStock,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error insertando detalle de nota de crédito: %w", err)
+		}
+	}
+
+	for i := range nota.Reembolsos {
+		r := &nota.Reembolsos[i]
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO reembolsos_nota_credito (id, nota_credito_id, medio_pago, monto, referencia_transaccion)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING fecha`,
+			r.ID, r.NotaCreditoID, r.MedioPago, r.Monto, r.ReferenciaTransaccion,
+		).Scan(&r.Fecha)
+		if err != nil {
+			return nil, fmt.Errorf("error insertando reembolso: %w", err)
+		}
+	}
+
+	return nota, nil
+}
+
+// validarReembolsos exige que los reembolsos cuadren con el total de la nota. Salvo el efectivo, cada
+// medio debe haberse usado en la venta y no puede devolver más de lo cobrado con él.
+func validarReembolsos(ctx context.Context, tx *sql.Tx, venta *models.Venta, nota *models.NotaCredito, reembolsos []models.ReembolsoRequest) error {
+	reembolsable, err := reembolsablePorMedio(ctx, tx, venta.ID)
+	if err != nil {
+		return err
+	}
+
+	total := 0.0
+	porMedio := map[string]float64{}
+	for _, r := range reembolsos {
+		monto := redondearMonto(r.Monto)
+		total += monto
+		porMedio[r.MedioPago] += monto
+		nota.Reembolsos = append(nota.Reembolsos, models.ReembolsoNotaCredito{
+			ID:                    uuid.New(),
+			NotaCreditoID:         nota.ID,
+			MedioPago:             r.MedioPago,
+			Monto:                 monto,
+			ReferenciaTransaccion: r.ReferenciaTransaccion,
+		})
+	}
+
+	if redondearMonto(total) != nota.Total {
+		return &apiError{
+			status:  http.StatusBadRequest,
+			code:    "REEMBOLSO_NO_CUADRA",
+			message: "Los reembolsos deben sumar el total de la nota de crédito",
+			details: models.JSONB{"total_nota_credito": nota.Total, "total_reembolsos": redondearMonto(total)},
+		}
+	}
+
+	for medio, monto := range porMedio {
+		if medio == "efectivo" {
+			continue
+		}
+		if monto > reembolsable[medio] {
+			return &apiError{
+				status:  http.StatusConflict,
+				code:    "REEMBOLSO_MEDIO_INVALIDO",
+				message: "El reembolso supera lo cobrado en la venta con ese medio de pago",
+				details: models.JSONB{"medio_pago": medio, "monto": monto, "reembolsable": reembolsable[medio]},
+			}
+		}
+	}
+
+	return nil
+}
+
+// bloquearNotaPendiente bloquea la nota, que debe estar pendiente y pertenecer a la sucursal del supervisor
+func bloquearNotaPendiente(ctx context.Context, tx *sql.Tx, notaID uuid.UUID, sucursalUsuario, rol string) (*models.NotaCredito, error) {
+	nota, err := getNotaCredito(ctx, tx, notaID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if rol != string(models.RolAdmin) && nota.SucursalID.String() != sucursalUsuario {
+		return nil, &apiError{
+			status:  http.StatusForbidden,
+			code:    "SUCURSAL_NO_AUTORIZADA",
+			message: "Solo puede resolver devoluciones de su sucursal",
+		}
+	}
+	if nota.Estado != models.EstadoPendiente {
+		return nil, &apiError{
+			status:  http.StatusConflict,
+			code:    "NOTA_CREDITO_NO_PENDIENTE",
+			message: fmt.Sprintf("La nota de crédito se encuentra %s", nota.Estado),
+			details: models.JSONB{"nota_credito_id": nota.ID, "estado": nota.Estado},
+		}
+	}
+
+	return nota, nil
+}
+
+// autorizarNotaCredito restaura el stock de las líneas, entrega los reembolsos en la sesión de caja
+// abierta del terminal y emite el DTE de la nota de crédito
+func autorizarNotaCredito(ctx context.Context, tx *sql.Tx, nota *models.NotaCredito, supervisorID uuid.UUID) error {
+	if err := cargarDetalleNotaCredito(ctx, tx, nota); err != nil {
+		return err
+	}
+
+	venta, err := getVentaOrigen(ctx, tx, nota.VentaOrigenID, false)
+	if err != nil {
+		return err
+	}
+
+	sesionID, err := sesionCajaAbierta(ctx, tx, nota.TerminalID)
+	if err != nil {
+		return err
+	}
+
+	batchID := uuid.New()
+	referencia := fmt.Sprintf("NOTA-CREDITO-%d", nota.NumeroNota)
+	for i := range nota.Detalles {
+		d := &nota.Detalles[i]
+		if !d.AfectaStock {
+			continue
+		}
+
+		var factor float64
+		err := tx.QueryRowContext(ctx,
+			`SELECT factor_conversion FROM detalle_ventas WHERE id = $1`, d.DetalleVentaID,
+		).Scan(&factor)
+		if err != nil {
+			return fmt.Errorf("error consultando línea de venta: %w", err)
+		}
+
+		cantidadBase := d.Cantidad * factor
+		if err := registrarDevolucionStock(ctx, tx, d.ProductoID, nota.SucursalID, cantidadBase, referencia, &supervisorID, batchID, nota.Motivo); err != nil {
+			return err
+		}
+		if err := devolverTrazabilidadLinea(ctx, tx, d.DetalleVentaID, cantidadBase); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE detalle_notas_credito SET stock_restaurado = true WHERE id = $1`, d.ID)
+		if err != nil {
+			return fmt.Errorf("error actualizando detalle de nota de crédito: %w", err)
+		}
+		d.StockRestaurado = true
+	}
+
+	for i := range nota.Reembolsos {
+		r := &nota.Reembolsos[i]
+		if r.MedioPago == "cuenta_corriente" {
+			if err := abonarReembolsoCuenta(ctx, tx, venta, nota, r.Monto, supervisorID); err != nil {
+				return err
+			}
+		}
+
+		err := tx.QueryRowContext(ctx, `
+			UPDATE reembolsos_nota_credito
+			SET sesion_caja_id = $2, fecha = NOW()
+			WHERE id = $1
+			RETURNING sesion_caja_id, fecha`,
+			r.ID, sesionID,
+		).Scan(&r.SesionCajaID, &r.Fecha)
+		if err != nil {
+			return fmt.Errorf("error registrando reembolso: %w", err)
+		}
+	}
+
+	dte := &models.DocumentoDTE{
+		SucursalID:          nota.SucursalID,
+		VentaID:             &venta.ID,
+		TipoDocumento:       "nota_credito_electronica",
+		RUTReceptor:         venta.ClienteRUT,
+		RazonSocialReceptor: venta.ClienteNombre,
+		MontoNeto:           nota.Subtotal,
+		MontoIVA:            nota.ImpuestoTotal,
+		MontoTotal:          nota.Total,
+		DatosAdicionales: models.JSONB{
+			"nota_credito_id":  nota.ID,
+			"numero_nota":      nota.NumeroNota,
+			"venta_origen_id":  venta.ID,
+			"numero_venta":     venta.NumeroVenta,
+			"documento_origen": venta.DTEID,
+			"motivo":           nota.Motivo,
+		},
+	}
+	if err := emitirDTE(ctx, tx, dte); err != nil {
+		return err
+	}
+
+	codigo, err := generarCodigoAutorizacion()
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE notas_credito
+		SET estado = 'procesado', supervisor_id = $2, fecha_autorizacion = NOW(), fecha_emision = $3,
+			codigo_autorizacion = $4, dte_id = $5, documento_origen_id = $6,
+			tiempo_autorizacion_ms = (EXTRACT(EPOCH FROM NOW() - fecha_solicitud) * 1000)::INTEGER
+		WHERE id = $1
+		RETURNING `+columnasNotaCredito,
+		nota.ID, supervisorID, dte.FechaEmision, codigo, dte.ID, venta.DTEID,
+	).Scan(notaCreditoDestinos(nota)...)
+	if err != nil {
+		return fmt.Errorf("error actualizando nota de crédito: %w", err)
+	}
+
+	return nil
+}
+
+// abonarReembolsoCuenta devuelve a la cuenta corriente del cliente el monto reembolsado por ese medio
+func abonarReembolsoCuenta(ctx context.Context, tx *sql.Tx, venta *models.Venta, nota *models.NotaCredito, monto float64, usuarioID uuid.UUID) error {
+	if venta.ClienteRUT == nil {
+		return &apiError{
+			status:  http.StatusConflict,
+			code:    "CUENTA_CORRIENTE_NOT_FOUND",
+			message: "La venta no tiene cliente con cuenta corriente",
+		}
+	}
+
+	cuenta, err := getCuentaCorriente(ctx, tx, "cliente_rut", *venta.ClienteRUT, true)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &apiError{
+				status:  http.StatusConflict,
+				code:    "CUENTA_CORRIENTE_NOT_FOUND",
+				message: "El cliente no tiene cuenta corriente",
+				details: models.JSONB{"cliente_rut": *venta.ClienteRUT},
+			}
+		}
+		return fmt.Errorf("error consultando cuenta corriente: %w", err)
+	}
+
+	referencia := fmt.Sprintf("Nota de crédito N° %d", nota.NumeroNota)
+	movimiento := &models.MovimientoCuentaCorriente{
+		ID:            uuid.New(),
+		CuentaID:      cuenta.ID,
+		SucursalID:    &nota.SucursalID,
+		Tipo:          "ajuste",
+		Monto:         -monto,
+		VentaID:       &venta.ID,
+		Referencia:    &referencia,
+		Observaciones: &nota.Motivo,
+		UsuarioID:     &usuarioID,
+	}
+	if err := registrarMovimientoCuenta(ctx, tx, cuenta, movimiento); err != nil {
+		return err
+	}
+
+	return imputarAbonoCuenta(ctx, tx, cuenta.ID, monto)
+}
+
+// columnasNotaCredito columnas de notas_credito en el orden esperado por scanNotaCredito
+const columnasNotaCredito = `id, numero_nota, sucursal_id, documento_origen_id, venta_origen_id, terminal_id,
+		supervisor_id, cajero_id, motivo, subtotal, descuento_total, impuesto_total, total, estado,
+		fecha_solicitud, fecha_autorizacion, fecha_emision, dte_id, observaciones,
+		codigo_autorizacion, tiempo_autorizacion_ms`
+
+// notaCreditoDestinos destinos de Scan de una nota de crédito en el orden de columnasNotaCredito
+func notaCreditoDestinos(nota *models.NotaCredito) []interface{} {
+	return []interface{}{
+		&nota.ID, &nota.NumeroNota, &nota.SucursalID, &nota.DocumentoOrigenID, &nota.VentaOrigenID,
+		&nota.TerminalID, &nota.SupervisorID, &nota.CajeroID, &nota.Motivo, &nota.Subtotal,
+		&nota.DescuentoTotal, &nota.ImpuestoTotal, &nota.Total, &nota.Estado, &nota.FechaSolicitud,
+		&nota.FechaAutorizacion, &nota.FechaEmision, &nota.DTEID, &nota.Observaciones,
+		&nota.CodigoAutorizacion, &nota.TiempoAutorizacionMs,
+	}
+}
+
+// scanNotaCredito lee una nota de crédito desde una fila
+func scanNotaCredito(row interface{ Scan(...interface{}) error }) (*models.NotaCredito, error) {
+	var nota models.NotaCredito
+	if err := row.Scan(notaCreditoDestinos(&nota)...); err != nil {
+		return nil, err
+	}
+	return &nota, nil
+}
+
+// getNotaCredito obtiene una nota de crédito por ID
+func getNotaCredito(ctx context.Context, q sqlQueryer, notaID uuid.UUID, bloquear bool) (*models.NotaCredito, error) {
+	query := `SELECT ` + columnasNotaCredito + ` FROM notas_credito WHERE id = $1`
+	if bloquear {
+		query += ` FOR UPDATE`
+	}
+
+	return scanNotaCredito(q.QueryRowContext(ctx, query, notaID))
+}
+
+// cargarDetalleNotaCredito carga las líneas y reembolsos de la nota
+func cargarDetalleNotaCredito(ctx context.Context, q sqlQueryer, nota *models.NotaCredito) error {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, nota_credito_id, detalle_venta_id, producto_id, cantidad, precio_unitario,
+			total_item, motivo_item, afecta_stock, stock_restaurado
+		FROM detalle_notas_credito
+		WHERE nota_credito_id = $1`,
+		nota.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error consultando detalle de nota de crédito: %w", err)
+	}
+
+	nota.Detalles = []models.DetalleNotaCredito{}
+	err = database.ScanRows(rows, func() error {
+		var d models.DetalleNotaCredito
+		if err := rows.Scan(&d.ID, &d.NotaCreditoID, &d.DetalleVentaID, &d.ProductoID, &d.Cantidad,
+			&d.PrecioUnitario, &d.TotalItem, &d.MotivoItem, &d.AfectaStock, &d.StockRestaurado); err != nil {
+			return err
+		}
+		nota.Detalles = append(nota.Detalles, d)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error leyendo detalle de nota de crédito: %w", err)
+	}
+
+	rows, err = q.QueryContext(ctx, `
+		SELECT id, nota_credito_id, medio_pago, monto, referencia_transaccion, sesion_caja_id, fecha
+		FROM reembolsos_nota_credito
+		WHERE nota_credito_id = $1
+		ORDER BY fecha`,
+		nota.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error consultando reembolsos: %w", err)
+	}
+
+	nota.Reembolsos = []models.ReembolsoNotaCredito{}
+	err = database.ScanRows(rows, func() error {
+		var r models.ReembolsoNotaCredito
+		if err := rows.Scan(&r.ID, &r.NotaCreditoID, &r.MedioPago, &r.Monto,
+			&r.ReferenciaTransaccion, &r.SesionCajaID, &r.Fecha); err != nil {
+			return err
+		}
+		nota.Reembolsos = append(nota.Reembolsos, r)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error leyendo reembolsos: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,693 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+const (
+	// vigenciaNotaVentaHoras vigencia por defecto de una nota de venta
+	vigenciaNotaVentaHoras = 24
+	// prioridadNotaVentaDefecto prioridad de atención por defecto en caja
+	prioridadNotaVentaDefecto = 5
+	// prefijoQRNotaVenta prefijo del contenido del código QR de una nota de venta
+	prefijoQRNotaVenta = "FPNV"
+)
+
+// sqlQueryer operaciones de consulta comunes a *database.Database y *sql.Tx
+type sqlQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// NotasVentaHandler handler para el flujo de notas de venta entre POS Tienda y Caja
+type NotasVentaHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+	ventas    *VentasHandler
+}
+
+// NewNotasVentaHandler crea un nuevo handler de notas de venta
+func NewNotasVentaHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *NotasVentaHandler {
+	return &NotasVentaHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+		ventas:    NewVentasHandler(db, log, val, met),
+	}
+}
+
+// Create crea una nota de venta desde el piso de ventas
+func (h *NotasVentaHandler) Create(c *gin.Context) {
+	var req models.NotaVentaRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	nota := &models.NotaVenta{
+		ID:                  uuid.New(),
+		SucursalID:          req.SucursalID,
+		TerminalID:          req.TerminalID,
+		ClienteRUT:          req.ClienteRUT,
+		ClienteNombre:       req.ClienteNombre,
+		Estado:              "pendiente",
+		Fecha:               time.Now(),
+		Observaciones:       req.Observaciones,
+		TiempoVigenciaHoras: req.TiempoVigenciaHoras,
+		PrioridadAtencion:   req.PrioridadAtencion,
+	}
+	if nota.TiempoVigenciaHoras == 0 {
+		nota.TiempoVigenciaHoras = vigenciaNotaVentaHoras
+	}
+	if nota.PrioridadAtencion == 0 {
+		nota.PrioridadAtencion = prioridadNotaVentaDefecto
+	}
+	vencimiento := nota.Fecha.Add(time.Duration(nota.TiempoVigenciaHoras) * time.Hour)
+	nota.FechaVencimiento = &vencimiento
+
+	if uid, err := uuid.Parse(getUserID(c)); err == nil {
+		nota.VendedorID = &uid
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		return h.createNotaVenta(ctx, tx, nota, req.Items)
+	})
+	if err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			h.ventas.responderVentaError(c, apiErr)
+			return
+		}
+
+		h.logger.WithError(err).Error("Error creando nota de venta")
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "CREATE_ERROR",
+				Message: "Error creando nota de venta",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	h.logger.WithField("nota_venta_id", nota.ID).
+		WithField("numero_nota", nota.NumeroNota).
+		Info("Nota de venta creada exitosamente")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      nota,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// List lista notas de venta de una sucursal en orden de atención
+func (h *NotasVentaHandler) List(c *gin.Context) {
+	sucursalID := getSucursalID(c)
+	if _, err := uuid.Parse(sucursalID); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_SUCURSAL_ID",
+				Message: "ID de sucursal inválido",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+	estado := c.DefaultQuery("estado", "pendiente")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT `+columnasNotaVenta+`
+		FROM notas_venta
+		WHERE sucursal_id = $1 AND estado = $2
+		ORDER BY prioridad_atencion ASC, fecha ASC
+		LIMIT 200`,
+		sucursalID, estado,
+	)
+	if err != nil {
+		h.logger.WithError(err).Error("Error listando notas de venta")
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "DATABASE_ERROR",
+				Message: "Error listando notas de venta",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	notas := []models.NotaVenta{}
+	err = database.ScanRows(rows, func() error {
+		nota, err := scanNotaVenta(rows)
+		if err != nil {
+			return err
+		}
+		notas = append(notas, *nota)
+		return nil
+	})
+	if err != nil {
+		h.logger.WithError(err).Error("Error leyendo notas de venta")
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "DATABASE_ERROR",
+				Message: "Error listando notas de venta",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      notas,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetByID obtiene una nota de venta por ID
+func (h *NotasVentaHandler) GetByID(c *gin.Context) {
+	notaID, ok := uuidParam(c, "id", "INVALID_NOTA_ID", "ID de nota de venta inválido")
+	if !ok {
+		return
+	}
+
+	h.responderNotaVenta(c, "id", notaID)
+}
+
+// GetByNumero obtiene una nota de venta por número
+func (h *NotasVentaHandler) GetByNumero(c *gin.Context) {
+	numero, err := strconv.ParseInt(c.Param("numero"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_NOTA_NUMERO",
+				Message: "Número de nota de venta inválido",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	h.responderNotaVenta(c, "numero_nota", numero)
+}
+
+// GetByQR obtiene una nota de venta escaneando su código QR
+func (h *NotasVentaHandler) GetByQR(c *gin.Context) {
+	codigo := strings.TrimSpace(c.Param("codigo"))
+	if !strings.HasPrefix(codigo, prefijoQRNotaVenta+":") {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_QR_CODE",
+				Message: "Código QR de nota de venta inválido",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	h.responderNotaVenta(c, "qr_code", codigo)
+}
+
+// Convertir convierte una nota de venta pendiente en una venta pagada en caja
+func (h *NotasVentaHandler) Convertir(c *gin.Context) {
+	start := time.Now()
+
+	notaID, ok := uuidParam(c, "id", "INVALID_NOTA_ID", "ID de nota de venta inválido")
+	if !ok {
+		return
+	}
+
+	var req models.ConvertirNotaVentaRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	cajeroID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var response models.VentaResponse
+	txStart := time.Now()
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		nota, err := getNotaVenta(ctx, tx, "id", notaID, true)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return &apiError{
+					status:  http.StatusNotFound,
+					code:    "NOTA_NOT_FOUND",
+					message: "Nota de venta no encontrada",
+				}
+			}
+			return err
+		}
+
+		if err := validarNotaConvertible(nota); err != nil {
+			return err
+		}
+
+		ventaReq := construirVentaDesdeNota(nota, &req, cajeroID)
+		motor, err := cargarMotorPromociones(ctx, tx, nota.SucursalID, ventaReq.Items)
+		if err != nil {
+			return fmt.Errorf("error cargando promociones vigentes: %w", err)
+		}
+
+		venta, detalles, mediosPago, vErr := h.ventas.prepararVenta(ventaReq, motor)
+		if vErr != nil {
+			return vErr
+		}
+		venta.NotaVentaID = &nota.ID
+
+		if err := consumirReservas(ctx, tx, "nota_venta", nota.ID, &cajeroID); err != nil {
+			return err
+		}
+
+		if err := h.ventas.registrarVenta(ctx, tx, venta, detalles, mediosPago, getUserRole(c), req.CodigoAutorizacionDescuento, req.Despacho, start); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE notas_venta
+			SET estado = 'pagada', venta_id = $2, fecha_pago = NOW()
+			WHERE id = $1`,
+			nota.ID, venta.ID,
+		)
+		if err != nil {
+			return fmt.Errorf("error actualizando nota de venta: %w", err)
+		}
+
+		response = models.VentaResponse{
+			Venta:                *venta,
+			Detalles:             detalles,
+			MediosPago:           mediosPago,
+			Vuelto:               vueltoVenta(mediosPago),
+			PromocionesAplicadas: promocionesAplicadas(detalles),
+		}
+		return nil
+	})
+	if h.metrics != nil {
+		h.metrics.RecordDatabaseTransaction("pos", time.Since(txStart), err)
+	}
+
+	if err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			h.ventas.responderVentaError(c, apiErr)
+			return
+		}
+
+		h.logger.WithError(err).Error("Error convirtiendo nota de venta")
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "CONVERSION_ERROR",
+				Message: "Error convirtiendo nota de venta en venta",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	if h.metrics != nil {
+		h.metrics.RecordVenta("pos", response.Venta.SucursalID.String(), response.Venta.TipoDocumento, response.Venta.Estado, response.Venta.Total)
+	}
+
+	h.logger.WithField("nota_venta_id", notaID).
+		WithField("venta_id", response.Venta.ID).
+		Info("Nota de venta convertida en venta")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      response,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// StartExpiracionNotas inicia en background el vencimiento de notas de venta pendientes
+func (h *NotasVentaHandler) StartExpiracionNotas(intervalo time.Duration) {
+	go func() {
+		ticker := time.NewTicker(intervalo)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			vencidas, err := h.expirarNotas(ctx)
+			cancel()
+
+			if err != nil {
+				h.logger.WithError(err).Error("Error venciendo notas de venta")
+				continue
+			}
+			if vencidas > 0 {
+				h.logger.WithField("notas_vencidas", vencidas).Info("Notas de venta vencidas")
+			}
+		}
+	}()
+}
+
+// Métodos auxiliares
+
+// columnasNotaVenta columnas de notas_venta en el orden esperado por scanNotaVenta
+const columnasNotaVenta = `id, numero_nota, sucursal_id, terminal_id, vendedor_id, cliente_rut,
+		cliente_nombre, subtotal, descuento_total, total, estado, fecha, fecha_vencimiento,
+		venta_id, fecha_pago, sincronizada, observaciones, qr_code, hash_validacion,
+		tiempo_vigencia_horas, prioridad_atencion`
+
+// scanNotaVenta lee una nota de venta desde una fila
+func scanNotaVenta(row interface{ Scan(...interface{}) error }) (*models.NotaVenta, error) {
+	var nota models.NotaVenta
+	err := row.Scan(
+		&nota.ID, &nota.NumeroNota, &nota.SucursalID, &nota.TerminalID, &nota.VendedorID,
+		&nota.ClienteRUT, &nota.ClienteNombre, &nota.Subtotal, &nota.DescuentoTotal,
+		&nota.Total, &nota.Estado, &nota.Fecha, &nota.FechaVencimiento, &nota.VentaID,
+		&nota.FechaPago, &nota.Sincronizada, &nota.Observaciones, &nota.QRCode,
+		&nota.HashValidacion, &nota.TiempoVigenciaHoras, &nota.PrioridadAtencion,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &nota, nil
+}
+
+// getNotaVenta obtiene una nota de venta con su detalle filtrando por id, numero_nota o qr_code
+func getNotaVenta(ctx context.Context, q sqlQueryer, campo string, valor interface{}, bloquear bool) (*models.NotaVenta, error) {
+	query := `SELECT ` + columnasNotaVenta + ` FROM notas_venta WHERE ` + campo + ` = $1`
+	if bloquear {
+		query += ` FOR UPDATE`
+	}
+
+	nota, err := scanNotaVenta(q.QueryRowContext(ctx, query, valor))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, nota_venta_id, producto_id, cantidad, precio_unitario, descuento_unitario,
+			total_item, observaciones, disponibilidad_verificada, fecha_verificacion_stock
+		FROM detalle_notas_venta
+		WHERE nota_venta_id = $1`,
+		nota.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = database.ScanRows(rows, func() error {
+		var d models.DetalleNotaVenta
+		if err := rows.Scan(
+			&d.ID, &d.NotaVentaID, &d.ProductoID, &d.Cantidad, &d.PrecioUnitario,
+			&d.DescuentoUnitario, &d.TotalItem, &d.Observaciones, &d.DisponibilidadVerificada,
+			&d.FechaVerificacionStock,
+		); err != nil {
+			return err
+		}
+		nota.Detalles = append(nota.Detalles, d)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nota, nil
+}
+
+// responderNotaVenta responde con la nota de venta encontrada por el campo indicado
+func (h *NotasVentaHandler) responderNotaVenta(c *gin.Context, campo string, valor interface{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	nota, err := getNotaVenta(ctx, h.db, campo, valor, false)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success: false,
+				Error: &models.APIError{
+					Code:    "NOTA_NOT_FOUND",
+					Message: "Nota de venta no encontrada",
+				},
+				RequestID: getRequestID(c),
+				Timestamp: time.Now(),
+			})
+			return
+		}
+
+		h.logger.WithError(err).Error("Error consultando nota de venta")
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "DATABASE_ERROR",
+				Message: "Error consultando nota de venta",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      nota,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// createNotaVenta inserta la nota con su detalle usando los precios vigentes de los productos
+func (h *NotasVentaHandler) createNotaVenta(ctx context.Context, tx *sql.Tx, nota *models.NotaVenta, items []models.NotaVentaItemRequest) error {
+	ahora := time.Now()
+	for i, item := range items {
+		var precio float64
+		var disponible float64
+		err := tx.QueryRowContext(ctx, `
+			SELECT p.precio_unitario, COALESCE(s.cantidad_disponible, 0)
+			FROM productos p
+			LEFT JOIN stock_central s ON s.producto_id = p.id AND s.sucursal_id = $2
+			WHERE p.id = $1 AND p.activo = true`,
+			item.ProductoID, nota.SucursalID,
+		).Scan(&precio, &disponible)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return &apiError{
+					status:  http.StatusBadRequest,
+					code:    "PRODUCT_NOT_FOUND",
+					message: "Producto no encontrado o inactivo",
+					details: models.JSONB{"item": i, "producto_id": item.ProductoID},
+				}
+			}
+			return fmt.Errorf("error consultando producto: %w", err)
+		}
+
+		if item.DescuentoUnitario > precio {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "INVALID_DISCOUNT",
+				message: "El descuento unitario no puede superar el precio unitario",
+				details: models.JSONB{"item": i, "producto_id": item.ProductoID},
+			}
+		}
+
+		nota.Detalles = append(nota.Detalles, models.DetalleNotaVenta{
+			ID:                       uuid.New(),
+			NotaVentaID:              nota.ID,
+			ProductoID:               item.ProductoID,
+			Cantidad:                 item.Cantidad,
+			PrecioUnitario:           precio,
+			DescuentoUnitario:        item.DescuentoUnitario,
+			TotalItem:                redondearMonto((precio - item.DescuentoUnitario) * item.Cantidad),
+			Observaciones:            item.Observaciones,
+			DisponibilidadVerificada: disponible >= item.Cantidad,
+			FechaVerificacionStock:   &ahora,
+		})
+
+		nota.Subtotal += precio * item.Cantidad
+		nota.DescuentoTotal += item.DescuentoUnitario * item.Cantidad
+	}
+
+	nota.Subtotal = redondearMonto(nota.Subtotal)
+	nota.DescuentoTotal = redondearMonto(nota.DescuentoTotal)
+	nota.Total = redondearMonto(nota.Subtotal - nota.DescuentoTotal)
+
+	return insertarNotaVenta(ctx, tx, nota)
+}
+
+// insertarNotaVenta genera hash y QR de la nota y la inserta junto con su detalle
+func insertarNotaVenta(ctx context.Context, tx *sql.Tx, nota *models.NotaVenta) error {
+	hash := calcularHashNotaVenta(nota)
+	qr := fmt.Sprintf("%s:%s:%s", prefijoQRNotaVenta, nota.ID, hash[:12])
+	nota.HashValidacion = &hash
+	nota.QRCode = &qr
+
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO notas_venta (
+			id, sucursal_id, terminal_id, vendedor_id, cliente_rut, cliente_nombre,
+			subtotal, descuento_total, total, estado, fecha, fecha_vencimiento,
+			observaciones, qr_code, hash_validacion, tiempo_vigencia_horas, prioridad_atencion
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		RETURNING numero_nota`,
+		nota.ID, nota.SucursalID, nota.TerminalID, nota.VendedorID, nota.ClienteRUT,
+		nota.ClienteNombre, nota.Subtotal, nota.DescuentoTotal, nota.Total, nota.Estado,
+		nota.Fecha, nota.FechaVencimiento, nota.Observaciones, nota.QRCode,
+		nota.HashValidacion, nota.TiempoVigenciaHoras, nota.PrioridadAtencion,
+	).Scan(&nota.NumeroNota)
+	if err != nil {
+		return fmt.Errorf("error insertando nota de venta: %w", err)
+	}
+
+	for _, d := range nota.Detalles {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO detalle_notas_venta (
+				id, nota_venta_id, producto_id, cantidad, precio_unitario, descuento_unitario,
+				total_item, observaciones, disponibilidad_verificada, fecha_verificacion_stock
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			d.ID, d.NotaVentaID, d.ProductoID, d.Cantidad, d.PrecioUnitario, d.DescuentoUnitario,
+			d.TotalItem, d.Observaciones, d.DisponibilidadVerificada, d.FechaVerificacionStock,
+		)
+		if err != nil {
+			return fmt.Errorf("error insertando detalle de nota de venta: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// expirarNotas marca como vencidas las notas pendientes fuera de vigencia
+func (h *NotasVentaHandler) expirarNotas(ctx context.Context) (int64, error) {
+	result, err := h.db.ExecContext(ctx, `
+		UPDATE notas_venta
+		SET estado = 'vencida'
+		WHERE estado = 'pendiente'
+		  AND COALESCE(fecha_vencimiento, fecha + tiempo_vigencia_horas * INTERVAL '1 hour') < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// validarNotaConvertible verifica que la nota pueda cobrarse en caja
+func validarNotaConvertible(nota *models.NotaVenta) error {
+	if nota.Estado != "pendiente" {
+		return &apiError{
+			status:  http.StatusConflict,
+			code:    "NOTA_NO_PENDIENTE",
+			message: fmt.Sprintf("La nota de venta se encuentra en estado %s", nota.Estado),
+			details: models.JSONB{"estado": nota.Estado, "venta_id": nota.VentaID},
+		}
+	}
+
+	if nota.FechaVencimiento != nil && nota.FechaVencimiento.Before(time.Now()) {
+		return &apiError{
+			status:  http.StatusConflict,
+			code:    "NOTA_VENCIDA",
+			message: "La nota de venta se encuentra vencida",
+			details: models.JSONB{"fecha_vencimiento": nota.FechaVencimiento},
+		}
+	}
+
+	if nota.HashValidacion != nil && *nota.HashValidacion != calcularHashNotaVenta(nota) {
+		return &apiError{
+			status:  http.StatusConflict,
+			code:    "NOTA_ALTERADA",
+			message: "La nota de venta no supera la validación de integridad",
+		}
+	}
+
+	return nil
+}
+
+// construirVentaDesdeNota arma la venta a registrar a partir de la nota y los pagos de caja
+func construirVentaDesdeNota(nota *models.NotaVenta, req *models.ConvertirNotaVentaRequest, cajeroID uuid.UUID) *models.VentaRequest {
+	ventaReq := &models.VentaRequest{
+		SucursalID:    nota.SucursalID,
+		TerminalID:    req.TerminalID,
+		CajeroID:      cajeroID,
+		VendedorID:    nota.VendedorID,
+		ClienteRUT:    nota.ClienteRUT,
+		ClienteNombre: nota.ClienteNombre,
+		TipoDocumento: req.TipoDocumento,
+		MediosPago:    req.MediosPago,
+		DatosAdicionales: models.JSONB{
+			"nota_venta_id":     nota.ID,
+			"nota_venta_numero": nota.NumeroNota,
+		},
+	}
+	ventaReq.CodigoAutorizacionDescuento = req.CodigoAutorizacionDescuento
+	if req.ClienteRUT != nil {
+		ventaReq.ClienteRUT = req.ClienteRUT
+	}
+	if req.ClienteNombre != nil {
+		ventaReq.ClienteNombre = req.ClienteNombre
+	}
+
+	for _, d := range nota.Detalles {
+		ventaReq.Items = append(ventaReq.Items, models.VentaItemRequest{
+			ProductoID:        d.ProductoID,
+			Cantidad:          d.Cantidad,
+			PrecioUnitario:    d.PrecioUnitario,
+			DescuentoUnitario: d.DescuentoUnitario,
+		})
+	}
+
+	return ventaReq
+}
+
+// calcularHashNotaVenta calcula el hash de validación de la nota de venta
+func calcularHashNotaVenta(nota *models.NotaVenta) string {
+	// El detalle se ordena porque la base de datos no garantiza el orden de inserción
+	lineas := make([]string, 0, len(nota.Detalles))
+	for _, d := range nota.Detalles {
+		lineas = append(lineas, fmt.Sprintf("%s:%.3f:%.2f:%.2f", d.ProductoID, d.Cantidad, d.PrecioUnitario, d.DescuentoUnitario))
+	}
+	sort.Strings(lineas)
+
+	contenido := fmt.Sprintf("%s|%s|%.2f|%.2f|%.2f|%s", nota.ID, nota.SucursalID,
+		nota.Subtotal, nota.DescuentoTotal, nota.Total, strings.Join(lineas, "|"))
+	sum := sha256.Sum256([]byte(contenido))
+	return hex.EncodeToString(sum[:])
+}
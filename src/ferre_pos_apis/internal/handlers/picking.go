@@ -0,0 +1,922 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+// despachosPorListaPicking despachos que agrupa una lista de picking si no se indica otra cantidad
+const despachosPorListaPicking = 10
+
+// PickingHandler handler para ubicaciones de bodega, listas de picking y rendimiento de recolección
+type PickingHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+}
+
+// NewPickingHandler crea un nuevo handler de picking
+func NewPickingHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *PickingHandler {
+	return &PickingHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+	}
+}
+
+// ListUbicaciones lista las ubicaciones de bodega de una sucursal, opcionalmente de un pasillo o producto
+func (h *PickingHandler) ListUbicaciones(c *gin.Context) {
+	sucursalID, ok := sucursalIDQuery(c)
+	if !ok {
+		return
+	}
+
+	query := `SELECT ` + columnasUbicacionProducto + ` FROM ubicaciones_producto WHERE sucursal_id = $1`
+	args := []interface{}{sucursalID}
+	if pasillo := c.Query("pasillo"); pasillo != "" {
+		args = append(args, pasillo)
+		query += ` AND pasillo = $` + strconv.Itoa(len(args))
+	}
+	if productoID := c.Query("producto_id"); productoID != "" {
+		args = append(args, productoID)
+		query += ` AND producto_id = $` + strconv.Itoa(len(args))
+	}
+	query += ` ORDER BY pasillo, estante, nivel LIMIT 1000`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		h.responderError(c, err, "Error listando ubicaciones")
+		return
+	}
+
+	ubicaciones := []models.UbicacionProducto{}
+	err = database.ScanRows(rows, func() error {
+		u, err := scanUbicacionProducto(rows)
+		if err != nil {
+			return err
+		}
+		ubicaciones = append(ubicaciones, *u)
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error listando ubicaciones")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      ubicaciones,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GuardarUbicaciones registra o reemplaza la ubicación de bodega de los productos indicados
+func (h *PickingHandler) GuardarUbicaciones(c *gin.Context) {
+	var req models.UbicacionesProductoRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ubicaciones := make([]models.UbicacionProducto, 0, len(req.Ubicaciones))
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		for _, item := range req.Ubicaciones {
+			u, err := scanUbicacionProducto(tx.QueryRowContext(ctx, `
+				INSERT INTO ubicaciones_producto (
+					producto_id, sucursal_id, pasillo, estante, nivel, observaciones, usuario_modificacion_id
+				) VALUES ($1, $2, $3, $4, $5, $6, $7)
+				ON CONFLICT (producto_id, sucursal_id) DO UPDATE
+				SET pasillo = EXCLUDED.pasillo, estante = EXCLUDED.estante, nivel = EXCLUDED.nivel,
+					observaciones = EXCLUDED.observaciones, fecha_modificacion = NOW(),
+					usuario_modificacion_id = EXCLUDED.usuario_modificacion_id
+				RETURNING `+columnasUbicacionProducto,
+				item.ProductoID, req.SucursalID, strings.ToUpper(strings.TrimSpace(item.Pasillo)),
+				strings.ToUpper(strings.TrimSpace(item.Estante)), strings.ToUpper(strings.TrimSpace(item.Nivel)),
+				item.Observaciones, usuarioID,
+			))
+			if err != nil {
+				return fmt.Errorf("error guardando ubicación del producto %s: %w", item.ProductoID, err)
+			}
+			ubicaciones = append(ubicaciones, *u)
+		}
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error guardando ubicaciones")
+		return
+	}
+
+	h.logger.WithField("sucursal_id", req.SucursalID).
+		WithField("ubicaciones", len(ubicaciones)).
+		Info("Ubicaciones de bodega actualizadas")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      ubicaciones,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// DeleteUbicacion elimina la ubicación de bodega de un producto
+func (h *PickingHandler) DeleteUbicacion(c *gin.Context) {
+	ubicacionID, ok := uuidParam(c, "id", "INVALID_UBICACION_ID", "ID de ubicación inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := h.db.ExecContext(ctx, `DELETE FROM ubicaciones_producto WHERE id = $1`, ubicacionID)
+	if err == nil {
+		if n, _ := result.RowsAffected(); n == 0 {
+			err = &apiError{
+				status:  http.StatusNotFound,
+				code:    "UBICACION_NOT_FOUND",
+				message: "Ubicación no encontrada",
+			}
+		}
+	}
+	if err != nil {
+		h.responderError(c, err, "Error eliminando ubicación")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      gin.H{"id": ubicacionID},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// CrearLista agrupa despachos pendientes en una lista de picking y ordena sus líneas según el recorrido por la bodega.
+// Sin despachos indicados toma los pendientes de mayor prioridad que no estén en otra lista
+func (h *PickingHandler) CrearLista(c *gin.Context) {
+	var req models.ListaPickingRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	maxDespachos := req.MaxDespachos
+	if maxDespachos == 0 {
+		maxDespachos = despachosPorListaPicking
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	lista := &models.ListaPicking{
+		ID:                     uuid.New(),
+		SucursalID:             req.SucursalID,
+		Estado:                 "pendiente",
+		UsuarioCreacionID:      &usuarioID,
+		UbicacionConsolidacion: req.UbicacionConsolidacion,
+	}
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		despachoIDs, err := seleccionarDespachosPicking(ctx, tx, req.SucursalID, req.DespachoIDs, maxDespachos)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO listas_picking (
+				id, sucursal_id, estado, usuario_creacion_id, ubicacion_consolidacion, total_despachos
+			) VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING numero_lista, fecha_creacion`,
+			lista.ID, lista.SucursalID, lista.Estado, lista.UsuarioCreacionID, lista.UbicacionConsolidacion,
+			len(despachoIDs),
+		).Scan(&lista.NumeroLista, &lista.FechaCreacion)
+		if err != nil {
+			return fmt.Errorf("error insertando lista de picking: %w", err)
+		}
+		lista.TotalDespachos = len(despachoIDs)
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE despachos
+			SET lista_picking_id = $2, ubicacion_picking = COALESCE($3, ubicacion_picking)
+			WHERE id = ANY($1::uuid[])`,
+			pq.Array(uuidsTexto(despachoIDs)), lista.ID, lista.UbicacionConsolidacion,
+		)
+		if err != nil {
+			return fmt.Errorf("error asignando despachos a la lista de picking: %w", err)
+		}
+
+		lineas, err := lineasPicking(ctx, tx, lista)
+		if err != nil {
+			return err
+		}
+		ordenarRecorrido(lineas)
+
+		for i := range lineas {
+			lineas[i].linea.OrdenPicking = i + 1
+			_, err := tx.ExecContext(ctx,
+				`UPDATE detalle_despacho SET orden_picking = $2, ubicacion_producto = $3 WHERE id = $1`,
+				lineas[i].linea.DetalleDespachoID, lineas[i].linea.OrdenPicking, lineas[i].linea.Ubicacion,
+			)
+			if err != nil {
+				return fmt.Errorf("error asignando orden de picking: %w", err)
+			}
+			lista.Lineas = append(lista.Lineas, lineas[i].linea)
+		}
+		lista.TotalLineas = len(lineas)
+
+		if lista.UbicacionConsolidacion == nil {
+			// Sin zona de consolidación el despacho queda donde se recoge su primera línea
+			_, err = tx.ExecContext(ctx, `
+				UPDATE despachos d
+				SET ubicacion_picking = (
+					SELECT dd.ubicacion_producto FROM detalle_despacho dd
+					WHERE dd.despacho_id = d.id AND dd.ubicacion_producto IS NOT NULL
+					ORDER BY dd.orden_picking LIMIT 1
+				)
+				WHERE d.lista_picking_id = $1`,
+				lista.ID,
+			)
+			if err != nil {
+				return fmt.Errorf("error asignando ubicación de picking: %w", err)
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE listas_picking SET total_lineas = $2 WHERE id = $1`, lista.ID, lista.TotalLineas)
+		if err != nil {
+			return fmt.Errorf("error actualizando lista de picking: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error generando lista de picking")
+		return
+	}
+
+	h.logger.WithField("lista_picking_id", lista.ID).
+		WithField("numero_lista", lista.NumeroLista).
+		WithField("despachos", lista.TotalDespachos).
+		WithField("lineas", lista.TotalLineas).
+		Info("Lista de picking generada")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      lista,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// ListListas lista las listas de picking de una sucursal, por defecto las pendientes y en proceso
+func (h *PickingHandler) ListListas(c *gin.Context) {
+	sucursalID, ok := sucursalIDQuery(c)
+	if !ok {
+		return
+	}
+
+	query := `SELECT ` + columnasListaPicking + ` FROM listas_picking WHERE sucursal_id = $1`
+	args := []interface{}{sucursalID}
+	if estado := c.Query("estado"); estado != "" {
+		args = append(args, estado)
+		query += ` AND estado = $` + strconv.Itoa(len(args))
+	} else {
+		query += ` AND estado IN ('pendiente', 'en_proceso')`
+	}
+	query += ` ORDER BY fecha_creacion DESC LIMIT 200`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		h.responderError(c, err, "Error listando listas de picking")
+		return
+	}
+
+	listas := []models.ListaPicking{}
+	err = database.ScanRows(rows, func() error {
+		lista, err := scanListaPicking(rows)
+		if err != nil {
+			return err
+		}
+		listas = append(listas, *lista)
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error listando listas de picking")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      listas,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetLista obtiene una lista de picking con sus líneas en orden de recorrido
+func (h *PickingHandler) GetLista(c *gin.Context) {
+	listaID, ok := uuidParam(c, "id", "INVALID_LISTA_PICKING_ID", "ID de lista de picking inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lista, err := getListaPicking(ctx, h.db, listaID, false)
+	if err != nil {
+		h.responderError(c, err, "Error consultando lista de picking")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      lista,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// IniciarLista asigna la lista al usuario que recorre la bodega y deja sus despachos en proceso.
+// Desde este momento se mide el tiempo de picking de cada línea
+func (h *PickingHandler) IniciarLista(c *gin.Context) {
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	h.modificarLista(c, "Error iniciando lista de picking", func(ctx context.Context, tx *sql.Tx, lista *models.ListaPicking) error {
+		if lista.Estado != "pendiente" {
+			return errListaPickingNoModificable(lista)
+		}
+
+		err := tx.QueryRowContext(ctx, `
+			UPDATE listas_picking
+			SET estado = 'en_proceso', usuario_picking_id = $2, fecha_inicio = NOW()
+			WHERE id = $1
+			RETURNING fecha_inicio`,
+			lista.ID, usuarioID,
+		).Scan(&lista.FechaInicio)
+		if err != nil {
+			return fmt.Errorf("error iniciando lista de picking: %w", err)
+		}
+		lista.Estado = "en_proceso"
+		lista.UsuarioPickingID = &usuarioID
+
+		rows, err := tx.QueryContext(ctx,
+			`SELECT `+columnasDespacho+` FROM despachos WHERE lista_picking_id = $1 FOR UPDATE`, lista.ID)
+		if err != nil {
+			return fmt.Errorf("error consultando despachos de la lista: %w", err)
+		}
+		var despachos []models.Despacho
+		err = database.ScanRows(rows, func() error {
+			var d models.Despacho
+			if err := scanDespacho(rows, &d); err != nil {
+				return err
+			}
+			despachos = append(despachos, d)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error leyendo despachos de la lista: %w", err)
+		}
+
+		for i := range despachos {
+			if err := iniciarDespacho(ctx, tx, &despachos[i], usuarioID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CompletarLista cierra la recolección registrando su duración; la entrega de cada despacho se cierra por separado
+func (h *PickingHandler) CompletarLista(c *gin.Context) {
+	h.modificarLista(c, "Error completando lista de picking", func(ctx context.Context, tx *sql.Tx, lista *models.ListaPicking) error {
+		if lista.Estado != "en_proceso" {
+			return errListaPickingNoModificable(lista)
+		}
+
+		err := tx.QueryRowContext(ctx, `
+			UPDATE listas_picking
+			SET estado = 'completada', fecha_completado = NOW(),
+				tiempo_total_segundos = EXTRACT(EPOCH FROM NOW() - fecha_inicio)::int
+			WHERE id = $1
+			RETURNING fecha_completado, tiempo_total_segundos`,
+			lista.ID,
+		).Scan(&lista.FechaCompletado, &lista.TiempoTotalSegundos)
+		if err != nil {
+			return fmt.Errorf("error completando lista de picking: %w", err)
+		}
+		lista.Estado = "completada"
+		return nil
+	})
+}
+
+// CancelarLista anula la lista y libera sus despachos no entregados para incluirlos en otra
+func (h *PickingHandler) CancelarLista(c *gin.Context) {
+	h.modificarLista(c, "Error cancelando lista de picking", func(ctx context.Context, tx *sql.Tx, lista *models.ListaPicking) error {
+		if lista.Estado != "pendiente" && lista.Estado != "en_proceso" {
+			return errListaPickingNoModificable(lista)
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			UPDATE despachos
+			SET lista_picking_id = NULL
+			WHERE lista_picking_id = $1 AND estado IN ('pendiente', 'en_proceso')`,
+			lista.ID,
+		)
+		if err != nil {
+			return fmt.Errorf("error liberando despachos de la lista: %w", err)
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			UPDATE listas_picking SET estado = 'cancelada', fecha_completado = NOW()
+			WHERE id = $1
+			RETURNING fecha_completado`,
+			lista.ID,
+		).Scan(&lista.FechaCompletado)
+		if err != nil {
+			return fmt.Errorf("error cancelando lista de picking: %w", err)
+		}
+		lista.Estado = "cancelada"
+		return nil
+	})
+}
+
+// GetRendimiento resume por usuario las líneas recogidas y sus tiempos de picking en un período (por defecto el día en curso)
+func (h *PickingHandler) GetRendimiento(c *gin.Context) {
+	sucursalID, ok := sucursalIDQuery(c)
+	if !ok {
+		return
+	}
+
+	ahora := time.Now()
+	desde := time.Date(ahora.Year(), ahora.Month(), ahora.Day(), 0, 0, 0, 0, ahora.Location())
+	hasta := desde
+	for param, fecha := range map[string]*time.Time{"desde": &desde, "hasta": &hasta} {
+		valor := c.Query(param)
+		if valor == "" {
+			continue
+		}
+
+		t, err := time.ParseInLocation("2006-01-02", valor, ahora.Location())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error: &models.APIError{
+					Code:    "INVALID_DATE",
+					Message: fmt.Sprintf("Fecha %s inválida, formato esperado AAAA-MM-DD", param),
+				},
+				RequestID: getRequestID(c),
+				Timestamp: time.Now(),
+			})
+			return
+		}
+		*fecha = t
+	}
+	// hasta incluye el día completo
+	hastaExclusivo := hasta.AddDate(0, 0, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT dd.usuario_despacho_id, u.nombre || COALESCE(' ' || u.apellido, ''),
+			COUNT(DISTINCT d.lista_picking_id), COUNT(DISTINCT d.id), COUNT(*),
+			COALESCE(SUM(dd.cantidad_despachada), 0), COALESCE(SUM(dd.tiempo_picking_segundos), 0)
+		FROM detalle_despacho dd
+		JOIN despachos d ON d.id = dd.despacho_id
+		JOIN usuarios u ON u.id = dd.usuario_despacho_id
+		WHERE d.sucursal_id = $1 AND dd.fecha_despacho >= $2 AND dd.fecha_despacho < $3
+			AND dd.tiempo_picking_segundos IS NOT NULL
+		GROUP BY dd.usuario_despacho_id, u.nombre, u.apellido
+		ORDER BY COUNT(*) DESC`,
+		sucursalID, desde, hastaExclusivo,
+	)
+	if err != nil {
+		h.responderError(c, err, "Error consultando rendimiento de picking")
+		return
+	}
+
+	rendimiento := []models.RendimientoPicking{}
+	err = database.ScanRows(rows, func() error {
+		var r models.RendimientoPicking
+		if err := rows.Scan(&r.UsuarioID, &r.Nombre, &r.Listas, &r.Despachos, &r.Lineas,
+			&r.Unidades, &r.TiempoTotalSegundos); err != nil {
+			return err
+		}
+		if r.Lineas > 0 {
+			r.SegundosPromedioLinea = math.Round(float64(r.TiempoTotalSegundos)/float64(r.Lineas)*10) / 10
+		}
+		if r.TiempoTotalSegundos > 0 {
+			r.LineasPorHora = math.Round(float64(r.Lineas)*3600/float64(r.TiempoTotalSegundos)*10) / 10
+		}
+		rendimiento = append(rendimiento, r)
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error consultando rendimiento de picking")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"sucursal_id": sucursalID,
+			"desde":       desde.Format("2006-01-02"),
+			"hasta":       hasta.Format("2006-01-02"),
+			"usuarios":    rendimiento,
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// modificarLista bloquea la lista de la ruta, aplica el cambio y responde con la lista actualizada
+func (h *PickingHandler) modificarLista(c *gin.Context, mensaje string, cambio func(ctx context.Context, tx *sql.Tx, lista *models.ListaPicking) error) {
+	listaID, ok := uuidParam(c, "id", "INVALID_LISTA_PICKING_ID", "ID de lista de picking inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var lista *models.ListaPicking
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		lista, err = getListaPicking(ctx, tx, listaID, true)
+		if err != nil {
+			return err
+		}
+		return cambio(ctx, tx, lista)
+	})
+	if err != nil {
+		h.responderError(c, err, mensaje)
+		return
+	}
+
+	h.logger.WithField("lista_picking_id", lista.ID).
+		WithField("estado", lista.Estado).
+		Info("Lista de picking actualizada")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      lista,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// responderError responde errores de negocio, lista inexistente o errores internos
+func (h *PickingHandler) responderError(c *gin.Context, err error, mensaje string) {
+	if errors.Is(err, sql.ErrNoRows) {
+		err = &apiError{
+			status:  http.StatusNotFound,
+			code:    "LISTA_PICKING_NOT_FOUND",
+			message: "Lista de picking no encontrada",
+		}
+	}
+
+	responderError(c, h.logger, err, "PICKING_ERROR", mensaje)
+}
+
+// columnasUbicacionProducto columnas de ubicaciones_producto en el orden esperado por scanUbicacionProducto
+const columnasUbicacionProducto = `id, producto_id, sucursal_id, pasillo, estante, nivel, observaciones,
+		fecha_modificacion, usuario_modificacion_id`
+
+// scanUbicacionProducto lee una ubicación de bodega desde una fila
+func scanUbicacionProducto(row interface{ Scan(...interface{}) error }) (*models.UbicacionProducto, error) {
+	var u models.UbicacionProducto
+	err := row.Scan(&u.ID, &u.ProductoID, &u.SucursalID, &u.Pasillo, &u.Estante, &u.Nivel,
+		&u.Observaciones, &u.FechaModificacion, &u.UsuarioModificacionID)
+	if err != nil {
+		return nil, err
+	}
+	u.Codigo = codigoUbicacion(u.Pasillo, u.Estante, u.Nivel)
+	return &u, nil
+}
+
+// codigoUbicacion código legible de una ubicación de bodega
+func codigoUbicacion(pasillo, estante, nivel string) string {
+	return pasillo + "-" + estante + "-" + nivel
+}
+
+// columnasListaPicking columnas de listas_picking en el orden esperado por scanListaPicking
+const columnasListaPicking = `id, numero_lista, sucursal_id, estado, usuario_creacion_id, usuario_picking_id,
+		ubicacion_consolidacion, total_despachos, total_lineas, fecha_creacion, fecha_inicio,
+		fecha_completado, tiempo_total_segundos`
+
+// scanListaPicking lee una lista de picking desde una fila
+func scanListaPicking(row interface{ Scan(...interface{}) error }) (*models.ListaPicking, error) {
+	var l models.ListaPicking
+	err := row.Scan(&l.ID, &l.NumeroLista, &l.SucursalID, &l.Estado, &l.UsuarioCreacionID,
+		&l.UsuarioPickingID, &l.UbicacionConsolidacion, &l.TotalDespachos, &l.TotalLineas,
+		&l.FechaCreacion, &l.FechaInicio, &l.FechaCompletado, &l.TiempoTotalSegundos)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// getListaPicking obtiene una lista de picking con sus líneas en el orden asignado
+func getListaPicking(ctx context.Context, q sqlQueryer, id uuid.UUID, bloquear bool) (*models.ListaPicking, error) {
+	query := `SELECT ` + columnasListaPicking + ` FROM listas_picking WHERE id = $1`
+	if bloquear {
+		query += ` FOR UPDATE`
+	}
+
+	lista, err := scanListaPicking(q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT COALESCE(dd.orden_picking, 0), dd.id, d.id, d.numero_despacho, dd.producto_id,
+			p.codigo_interno, p.descripcion, dd.ubicacion_producto, dd.cantidad_solicitada,
+			COALESCE(dd.cantidad_despachada, 0), dd.tiempo_picking_segundos
+		FROM despachos d
+		JOIN detalle_despacho dd ON dd.despacho_id = d.id
+		JOIN productos p ON p.id = dd.producto_id
+		WHERE d.lista_picking_id = $1
+		ORDER BY dd.orden_picking NULLS LAST, d.numero_despacho`,
+		lista.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando líneas de la lista de picking: %w", err)
+	}
+	err = database.ScanRows(rows, func() error {
+		var l models.LineaPicking
+		if err := rows.Scan(&l.OrdenPicking, &l.DetalleDespachoID, &l.DespachoID, &l.NumeroDespacho,
+			&l.ProductoID, &l.CodigoInterno, &l.Descripcion, &l.Ubicacion, &l.CantidadSolicitada,
+			&l.CantidadDespachada, &l.TiempoPickingSegundos); err != nil {
+			return err
+		}
+		lista.Lineas = append(lista.Lineas, l)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo líneas de la lista de picking: %w", err)
+	}
+
+	return lista, nil
+}
+
+// errListaPickingNoModificable error para operaciones sobre listas en un estado que no las admite
+func errListaPickingNoModificable(lista *models.ListaPicking) error {
+	return &apiError{
+		status:  http.StatusConflict,
+		code:    "LISTA_PICKING_NO_MODIFICABLE",
+		message: fmt.Sprintf("La lista de picking está %s", strings.ReplaceAll(lista.Estado, "_", " ")),
+		details: models.JSONB{"lista_picking_id": lista.ID, "estado": lista.Estado},
+	}
+}
+
+// seleccionarDespachosPicking bloquea los despachos a incluir en la lista. Los indicados deben estar pendientes,
+// ser de la sucursal y no pertenecer a otra lista; sin indicarlos se toman los de mayor prioridad disponibles
+func seleccionarDespachosPicking(ctx context.Context, tx *sql.Tx, sucursalID uuid.UUID, solicitados []uuid.UUID, maximo int) ([]uuid.UUID, error) {
+	var rows *sql.Rows
+	var err error
+	if len(solicitados) > 0 {
+		rows, err = tx.QueryContext(ctx, `
+			SELECT id, numero_despacho, sucursal_id, estado, lista_picking_id
+			FROM despachos
+			WHERE id = ANY($1::uuid[])
+			ORDER BY prioridad_despacho, numero_despacho
+			FOR UPDATE`,
+			pq.Array(uuidsTexto(solicitados)),
+		)
+	} else {
+		// SKIP LOCKED evita que dos bodegueros generando listas a la vez tomen los mismos despachos
+		rows, err = tx.QueryContext(ctx, `
+			SELECT id, numero_despacho, sucursal_id, estado, lista_picking_id
+			FROM despachos
+			WHERE sucursal_id = $1 AND estado = 'pendiente' AND lista_picking_id IS NULL
+			ORDER BY prioridad_despacho, fecha_programada NULLS LAST, numero_despacho
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED`,
+			sucursalID, maximo,
+		)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error seleccionando despachos: %w", err)
+	}
+
+	var ids []uuid.UUID
+	var noDisponibles []int64
+	err = database.ScanRows(rows, func() error {
+		var id uuid.UUID
+		var numero int64
+		var sucursal uuid.UUID
+		var estado string
+		var listaID *uuid.UUID
+		if err := rows.Scan(&id, &numero, &sucursal, &estado, &listaID); err != nil {
+			return err
+		}
+		if sucursal != sucursalID || estado != "pendiente" || listaID != nil {
+			noDisponibles = append(noDisponibles, numero)
+			return nil
+		}
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo despachos: %w", err)
+	}
+
+	if len(noDisponibles) > 0 || len(ids) < len(solicitados) {
+		return nil, &apiError{
+			status:  http.StatusConflict,
+			code:    "DESPACHOS_NO_DISPONIBLES",
+			message: "Hay despachos inexistentes, de otra sucursal, ya iniciados o asignados a otra lista",
+			details: models.JSONB{"numeros_despacho": noDisponibles},
+		}
+	}
+	if len(ids) == 0 {
+		return nil, &apiError{
+			status:  http.StatusConflict,
+			code:    "SIN_DESPACHOS_PENDIENTES",
+			message: "No hay despachos pendientes para generar una lista de picking",
+		}
+	}
+	return ids, nil
+}
+
+// lineaRecorrido línea de picking con su ubicación descompuesta para ordenar el recorrido
+type lineaRecorrido struct {
+	linea   models.LineaPicking
+	pasillo string
+	estante string
+	nivel   string
+}
+
+// lineasPicking obtiene las líneas de los despachos de la lista con la ubicación de cada producto en la sucursal
+func lineasPicking(ctx context.Context, tx *sql.Tx, lista *models.ListaPicking) ([]lineaRecorrido, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT dd.id, d.id, d.numero_despacho, dd.producto_id, p.codigo_interno, p.descripcion,
+			dd.cantidad_solicitada, COALESCE(dd.cantidad_despachada, 0),
+			COALESCE(u.pasillo, ''), COALESCE(u.estante, ''), COALESCE(u.nivel, '')
+		FROM despachos d
+		JOIN detalle_despacho dd ON dd.despacho_id = d.id
+		JOIN productos p ON p.id = dd.producto_id
+		LEFT JOIN ubicaciones_producto u ON u.producto_id = dd.producto_id AND u.sucursal_id = d.sucursal_id
+		WHERE d.lista_picking_id = $1`,
+		lista.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando líneas de despacho: %w", err)
+	}
+
+	var lineas []lineaRecorrido
+	err = database.ScanRows(rows, func() error {
+		var r lineaRecorrido
+		l := &r.linea
+		if err := rows.Scan(&l.DetalleDespachoID, &l.DespachoID, &l.NumeroDespacho, &l.ProductoID,
+			&l.CodigoInterno, &l.Descripcion, &l.CantidadSolicitada, &l.CantidadDespachada,
+			&r.pasillo, &r.estante, &r.nivel); err != nil {
+			return err
+		}
+		if r.pasillo != "" {
+			codigo := codigoUbicacion(r.pasillo, r.estante, r.nivel)
+			l.Ubicacion = &codigo
+		}
+		lineas = append(lineas, r)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo líneas de despacho: %w", err)
+	}
+	return lineas, nil
+}
+
+// ordenarRecorrido ordena las líneas en recorrido serpentina: los pasillos en orden, los estantes de ida
+// en los pasillos impares del recorrido y de vuelta en los pares, y los niveles de abajo hacia arriba.
+// Las líneas de un mismo producto quedan juntas y las sin ubicación al final
+func ordenarRecorrido(lineas []lineaRecorrido) {
+	pasillos := map[string]bool{}
+	for _, l := range lineas {
+		if l.pasillo != "" {
+			pasillos[l.pasillo] = true
+		}
+	}
+	orden := make([]string, 0, len(pasillos))
+	for p := range pasillos {
+		orden = append(orden, p)
+	}
+	sort.Slice(orden, func(i, j int) bool { return compararNatural(orden[i], orden[j]) < 0 })
+	posicion := make(map[string]int, len(orden))
+	for i, p := range orden {
+		posicion[p] = i
+	}
+
+	sort.SliceStable(lineas, func(i, j int) bool {
+		a, b := &lineas[i], &lineas[j]
+		if (a.pasillo == "") != (b.pasillo == "") {
+			return b.pasillo == ""
+		}
+		if a.pasillo != b.pasillo {
+			return posicion[a.pasillo] < posicion[b.pasillo]
+		}
+		if c := compararNatural(a.estante, b.estante); c != 0 {
+			if posicion[a.pasillo]%2 == 1 {
+				return c > 0
+			}
+			return c < 0
+		}
+		if c := compararNatural(a.nivel, b.nivel); c != 0 {
+			return c < 0
+		}
+		if a.linea.ProductoID != b.linea.ProductoID {
+			return a.linea.CodigoInterno < b.linea.CodigoInterno
+		}
+		return a.linea.NumeroDespacho < b.linea.NumeroDespacho
+	})
+}
+
+// compararNatural compara textos tratando los tramos numéricos como números, de modo que "P2" va antes que "P10"
+func compararNatural(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	i, j := 0, 0
+	for i < len(ra) && j < len(rb) {
+		if unicode.IsDigit(ra[i]) && unicode.IsDigit(rb[j]) {
+			ini, inj := i, j
+			for i < len(ra) && unicode.IsDigit(ra[i]) {
+				i++
+			}
+			for j < len(rb) && unicode.IsDigit(rb[j]) {
+				j++
+			}
+			na, _ := strconv.Atoi(string(ra[ini:i]))
+			nb, _ := strconv.Atoi(string(rb[inj:j]))
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+		ca, cb := unicode.ToUpper(ra[i]), unicode.ToUpper(rb[j])
+		if ca != cb {
+			if ca < cb {
+				return -1
+			}
+			return 1
+		}
+		i++
+		j++
+	}
+	switch {
+	case len(ra)-i < len(rb)-j:
+		return -1
+	case len(ra)-i > len(rb)-j:
+		return 1
+	}
+	return 0
+}
+
+// uuidsTexto convierte UUIDs a texto para pasarlos como arreglo a PostgreSQL
+func uuidsTexto(ids []uuid.UUID) []string {
+	textos := make([]string, len(ids))
+	for i, id := range ids {
+		textos[i] = id.String()
+	}
+	return textos
+}
@@ -162,7 +162,7 @@ func (h *ProductosHandler) List(c *gin.Context) {
 	for rows.Next() {
 		var p models.Producto
 		var categoriaNombre sql.NullString
-		var stockDisponible int
+		var stockDisponible float64
 
 		err := rows.Scan(
 			&p.ID, &p.CodigoInterno, &p.CodigoBarra, &p.Descripcion, &p.DescripcionCorta,
@@ -487,32 +487,7 @@ func (h *ProductosHandler) Search(c *gin.Context) {
 // Create crea un nuevo producto (solo admin/supervisor)
 func (h *ProductosHandler) Create(c *gin.Context) {
 	var req models.Producto
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error: &models.APIError{
-				Code:    "INVALID_JSON",
-				Message: "JSON inválido en el cuerpo de la petición",
-			},
-			RequestID: getRequestID(c),
-			Timestamp: time.Now(),
-		})
-		return
-	}
-
-	// Validar datos
-	if err := h.validator.ValidateStruct(&req); err != nil {
-		validationErrors := h.validator.GetValidationErrors(err)
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error: &models.APIError{
-				Code:    "VALIDATION_ERROR",
-				Message: "Error de validación en los datos enviados",
-				Details: models.JSONB{"validation_errors": validationErrors},
-			},
-			RequestID: getRequestID(c),
-			Timestamp: time.Now(),
-		})
+	if !bindAndValidate(c, h.validator, &req) {
 		return
 	}
 
@@ -574,32 +549,7 @@ func (h *ProductosHandler) Update(c *gin.Context) {
 	}
 
 	var req models.Producto
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error: &models.APIError{
-				Code:    "INVALID_JSON",
-				Message: "JSON inválido en el cuerpo de la petición",
-			},
-			RequestID: getRequestID(c),
-			Timestamp: time.Now(),
-		})
-		return
-	}
-
-	// Validar datos
-	if err := h.validator.ValidateStruct(&req); err != nil {
-		validationErrors := h.validator.GetValidationErrors(err)
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error: &models.APIError{
-				Code:    "VALIDATION_ERROR",
-				Message: "Error de validación en los datos enviados",
-				Details: models.JSONB{"validation_errors": validationErrors},
-			},
-			RequestID: getRequestID(c),
-			Timestamp: time.Now(),
-		})
+	if !bindAndValidate(c, h.validator, &req) {
 		return
 	}
 
@@ -713,6 +663,56 @@ func (h *ProductosHandler) Delete(c *gin.Context) {
 	})
 }
 
+// GetStockBajo sugiere qué productos reponer en la sucursal y cuánto pedir según su velocidad de venta,
+// agrupados por proveedor
+func (h *ProductosHandler) GetStockBajo(c *gin.Context) {
+	sucursalID := getSucursalID(c)
+	if _, err := uuid.Parse(sucursalID); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_SUCURSAL_ID",
+				Message: "ID de sucursal inválido",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+	parametros := parametrosReposicionQuery(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	proveedores, err := calcularSugerenciasReposicion(ctx, h.db, sucursalID, parametros)
+	if err != nil {
+		h.logger.WithError(err).Error("Error calculando sugerencias de reposición")
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "DATABASE_ERROR",
+				Message: "Error calculando sugerencias de reposición",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"sucursal_id":    sucursalID,
+			"dias_historia":  parametros.diasHistoria,
+			"dias_cobertura": parametros.diasCobertura,
+			"nivel_servicio": parametros.nivelServicio,
+			"proveedores":    proveedores,
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
 // Métodos auxiliares
 
 // getProductoByID obtiene un producto por ID con información de stock
@@ -728,7 +728,8 @@ func (h *ProductosHandler) getProductoByID(ctx context.Context, productID, sucur
 		       c.nombre as categoria_nombre,
 		       COALESCE(s.cantidad_disponible, 0) as stock_disponible,
 		       COALESCE(s.cantidad, 0) as stock_total,
-		       COALESCE(s.cantidad_reservada, 0) as stock_reservado
+		       COALESCE(s.cantidad_reservada, 0) as stock_reservado,
+		       COALESCE(s.cantidad_en_transito, 0) as stock_en_transito
 		FROM productos p
 		LEFT JOIN categorias_productos c ON p.categoria_id = c.id
 		LEFT JOIN stock_central s ON p.id = s.producto_id AND s.sucursal_id = $2
@@ -736,7 +737,7 @@ func (h *ProductosHandler) getProductoByID(ctx context.Context, productID, sucur
 
 	var p models.Producto
 	var categoriaNombre sql.NullString
-	var stockDisponible, stockTotal, stockReservado int
+	var stockDisponible, stockTotal, stockReservado, stockEnTransito float64
 
 	err := h.db.QueryRowContext(ctx, query, productID, sucursalID).Scan(
 		&p.ID, &p.CodigoInterno, &p.CodigoBarra, &p.Descripcion, &p.DescripcionCorta,
@@ -746,7 +747,7 @@ func (h *ProductosHandler) getProductoByID(ctx context.Context, productID, sucur
 		&p.StockMaximo, &p.ImagenPrincipalURL, &p.ImagenesAdicionales,
 		&p.FechaCreacion, &p.FechaModificacion, &p.PopularidadScore,
 		&p.ConfiguracionEtiqueta, &p.FechaUltimaEtiqueta, &p.TotalEtiquetasGeneradas,
-		&categoriaNombre, &stockDisponible, &stockTotal, &stockReservado,
+		&categoriaNombre, &stockDisponible, &stockTotal, &stockReservado, &stockEnTransito,
 	)
 
 	if err != nil {
@@ -777,6 +778,7 @@ func (h *ProductosHandler) getProductoByID(ctx context.Context, productID, sucur
 		"stock_disponible":           stockDisponible,
 		"stock_total":                stockTotal,
 		"stock_reservado":            stockReservado,
+		"stock_en_transito":          stockEnTransito,
 		"imagen_principal_url":       p.ImagenPrincipalURL,
 		"imagenes_adicionales":       p.ImagenesAdicionales,
 		"popularidad_score":          p.PopularidadScore,
@@ -792,25 +794,51 @@ func (h *ProductosHandler) getProductoByID(ctx context.Context, productID, sucur
 
 // getProductoByBarcode obtiene un producto por código de barras
 func (h *ProductosHandler) getProductoByBarcode(ctx context.Context, codigo, sucursalID string) (map[string]interface{}, error) {
-	// Buscar en tabla principal y códigos adicionales
+	// Buscar en tabla principal, códigos adicionales y códigos de presentaciones (caja, rollo)
 	query := `
-		SELECT p.id
+		SELECT p.id, NULL::uuid
 		FROM productos p
 		WHERE p.codigo_barra = $1 AND p.activo = true
 		UNION
-		SELECT p.id
+		SELECT p.id, NULL::uuid
 		FROM productos p
 		JOIN codigos_barra_adicionales cba ON p.id = cba.producto_id
 		WHERE cba.codigo_barra = $1 AND cba.activo = true AND p.activo = true
+		UNION
+		SELECT p.id, ump.id
+		FROM productos p
+		JOIN unidades_medida_producto ump ON p.id = ump.producto_id
+		WHERE ump.codigo_barra = $1 AND ump.activo = true AND p.activo = true
 		LIMIT 1`
 
 	var productID string
-	err := h.db.QueryRowContext(ctx, query, codigo).Scan(&productID)
+	var unidadID sql.NullString
+	err := h.db.QueryRowContext(ctx, query, codigo).Scan(&productID, &unidadID)
+	if err != nil {
+		return nil, err
+	}
+
+	producto, err := h.getProductoByID(ctx, productID, sucursalID)
+	if err != nil || !unidadID.Valid {
+		return producto, err
+	}
+
+	// El código corresponde a una presentación: el POS vende en esa unidad
+	var unidad models.UnidadMedidaProducto
+	err = h.db.QueryRowContext(ctx, `
+		SELECT id, producto_id, unidad, factor_conversion, codigo_barra, precio_unitario,
+			activo, fecha_creacion, fecha_modificacion
+		FROM unidades_medida_producto
+		WHERE id = $1`,
+		unidadID.String,
+	).Scan(&unidad.ID, &unidad.ProductoID, &unidad.Unidad, &unidad.FactorConversion, &unidad.CodigoBarra,
+		&unidad.PrecioUnitario, &unidad.Activo, &unidad.FechaCreacion, &unidad.FechaModificacion)
 	if err != nil {
 		return nil, err
 	}
+	producto["unidad_venta"] = unidad
 
-	return h.getProductoByID(ctx, productID, sucursalID)
+	return producto, nil
 }
 
 // searchProductos busca productos por texto
@@ -905,7 +933,7 @@ func (h *ProductosHandler) searchProductos(ctx context.Context, query, sucursalI
 			PopularidadScore  float64
 		}
 		var categoriaNombre sql.NullString
-		var stockDisponible int
+		var stockDisponible float64
 
 		err := rows.Scan(
 			&p.ID, &p.CodigoInterno, &p.CodigoBarra, &p.Descripcion, &p.DescripcionCorta,
@@ -1046,6 +1074,13 @@ func getUserSucursalID(c *gin.Context) string {
 	return ""
 }
 
+func getUserRole(c *gin.Context) string {
+	if userRole, exists := c.Get("user_role"); exists {
+		return userRole.(string)
+	}
+	return ""
+}
+
 func getSucursalID(c *gin.Context) string {
 	sucursalID := c.Query("sucursal_id")
 	if sucursalID == "" {
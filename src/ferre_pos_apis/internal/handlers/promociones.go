@@ -0,0 +1,720 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+// PromocionesHandler handler para el mantenedor de promociones
+type PromocionesHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+}
+
+// NewPromocionesHandler crea un nuevo handler de promociones
+func NewPromocionesHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *PromocionesHandler {
+	return &PromocionesHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+	}
+}
+
+// Create crea una promoción con sus ventanas de vigencia por sucursal
+func (h *PromocionesHandler) Create(c *gin.Context) {
+	var req models.PromocionRequest
+	if !h.bindPromocion(c, &req) {
+		return
+	}
+
+	promocion := nuevaPromocion(uuid.New(), &req)
+	if uid, err := uuid.Parse(getUserID(c)); err == nil {
+		promocion.UsuarioCreacionID = &uid
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		tramos, err := tramosJSON(promocion.Tramos)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO promociones (
+				id, codigo, nombre, descripcion, tipo, producto_id, categoria_id,
+				lleva_cantidad, paga_cantidad, porcentaje, tramos, prioridad, activa,
+				usuario_creacion_id
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+			RETURNING fecha_creacion, fecha_modificacion`,
+			promocion.ID, promocion.Codigo, promocion.Nombre, promocion.Descripcion, promocion.Tipo,
+			promocion.ProductoID, promocion.CategoriaID, promocion.LlevaCantidad, promocion.PagaCantidad,
+			promocion.Porcentaje, tramos, promocion.Prioridad, promocion.Activa, promocion.UsuarioCreacionID,
+		).Scan(&promocion.FechaCreacion, &promocion.FechaModificacion)
+		if err != nil {
+			return err
+		}
+
+		return guardarSucursalesPromocion(ctx, tx, promocion)
+	})
+	if err != nil {
+		h.responderErrorGuardado(c, err, "Error creando promoción")
+		return
+	}
+
+	h.logger.WithField("promocion_id", promocion.ID).
+		WithField("codigo", promocion.Codigo).
+		Info("Promoción creada")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      promocion,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// List lista promociones, opcionalmente solo las vigentes de una sucursal
+func (h *PromocionesHandler) List(c *gin.Context) {
+	sucursalID := c.Query("sucursal_id")
+	vigentes := c.Query("vigentes") == "true"
+
+	query := `SELECT ` + columnasPromocion + ` FROM promociones p`
+	var args []interface{}
+	if sucursalID != "" {
+		if _, err := uuid.Parse(sucursalID); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error: &models.APIError{
+					Code:    "INVALID_SUCURSAL_ID",
+					Message: "ID de sucursal inválido",
+				},
+				RequestID: getRequestID(c),
+				Timestamp: time.Now(),
+			})
+			return
+		}
+
+		query += ` JOIN promociones_sucursales ps ON ps.promocion_id = p.id AND ps.sucursal_id = $1`
+		args = append(args, sucursalID)
+		if vigentes {
+			query += ` AND NOW() BETWEEN ps.fecha_inicio AND ps.fecha_fin WHERE p.activa = true`
+		}
+	}
+	query += ` ORDER BY p.prioridad DESC, p.fecha_creacion DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	promociones, err := listarPromociones(ctx, h.db, query, args...)
+	if err == nil {
+		err = cargarSucursalesPromociones(ctx, h.db, promociones)
+	}
+	if err != nil {
+		responderError(c, h.logger, err, "DATABASE_ERROR", "Error consultando promociones")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      promociones,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetByID obtiene una promoción con sus ventanas de vigencia
+func (h *PromocionesHandler) GetByID(c *gin.Context) {
+	promocionID, ok := uuidParam(c, "id", "INVALID_PROMOCION_ID", "ID de promoción inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	promociones, err := listarPromociones(ctx, h.db,
+		`SELECT `+columnasPromocion+` FROM promociones p WHERE p.id = $1`, promocionID)
+	if err == nil {
+		err = cargarSucursalesPromociones(ctx, h.db, promociones)
+	}
+	if err != nil {
+		responderError(c, h.logger, err, "DATABASE_ERROR", "Error consultando promoción")
+		return
+	}
+	if len(promociones) == 0 {
+		h.responderNoEncontrada(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      promociones[0],
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Update actualiza una promoción reemplazando sus ventanas de vigencia
+func (h *PromocionesHandler) Update(c *gin.Context) {
+	promocionID, ok := uuidParam(c, "id", "INVALID_PROMOCION_ID", "ID de promoción inválido")
+	if !ok {
+		return
+	}
+
+	var req models.PromocionRequest
+	if !h.bindPromocion(c, &req) {
+		return
+	}
+
+	promocion := nuevaPromocion(promocionID, &req)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		tramos, err := tramosJSON(promocion.Tramos)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			UPDATE promociones
+			SET codigo = $2, nombre = $3, descripcion = $4, tipo = $5, producto_id = $6,
+				categoria_id = $7, lleva_cantidad = $8, paga_cantidad = $9, porcentaje = $10,
+				tramos = $11, prioridad = $12, activa = $13, fecha_modificacion = NOW()
+			WHERE id = $1
+			RETURNING usuario_creacion_id, fecha_creacion, fecha_modificacion`,
+			promocion.ID, promocion.Codigo, promocion.Nombre, promocion.Descripcion, promocion.Tipo,
+			promocion.ProductoID, promocion.CategoriaID, promocion.LlevaCantidad, promocion.PagaCantidad,
+			promocion.Porcentaje, tramos, promocion.Prioridad, promocion.Activa,
+		).Scan(&promocion.UsuarioCreacionID, &promocion.FechaCreacion, &promocion.FechaModificacion)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM promociones_sucursales WHERE promocion_id = $1`, promocion.ID); err != nil {
+			return err
+		}
+		return guardarSucursalesPromocion(ctx, tx, promocion)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		h.responderNoEncontrada(c)
+		return
+	}
+	if err != nil {
+		h.responderErrorGuardado(c, err, "Error actualizando promoción")
+		return
+	}
+
+	h.logger.WithField("promocion_id", promocion.ID).Info("Promoción actualizada")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      promocion,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Delete desactiva una promoción conservando su historial
+func (h *PromocionesHandler) Delete(c *gin.Context) {
+	promocionID, ok := uuidParam(c, "id", "INVALID_PROMOCION_ID", "ID de promoción inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := h.db.ExecContext(ctx,
+		`UPDATE promociones SET activa = false, fecha_modificacion = NOW() WHERE id = $1`,
+		promocionID,
+	)
+	if err != nil {
+		responderError(c, h.logger, err, "DATABASE_ERROR", "Error desactivando promoción")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		h.responderNoEncontrada(c)
+		return
+	}
+
+	h.logger.WithField("promocion_id", promocionID).Info("Promoción desactivada")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      gin.H{"message": "Promoción desactivada exitosamente"},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Métodos auxiliares
+
+// bindPromocion lee y valida el cuerpo de una promoción, incluidas las reglas de cada tipo
+func (h *PromocionesHandler) bindPromocion(c *gin.Context, req *models.PromocionRequest) bool {
+	if !bindAndValidate(c, h.validator, req) {
+		return false
+	}
+
+	if mensaje := validarReglasPromocion(req); mensaje != "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_PROMOCION",
+				Message: mensaje,
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return false
+	}
+
+	return true
+}
+
+// responderNoEncontrada responde que la promoción no existe
+func (h *PromocionesHandler) responderNoEncontrada(c *gin.Context) {
+	c.JSON(http.StatusNotFound, models.APIResponse{
+		Success: false,
+		Error: &models.APIError{
+			Code:    "PROMOCION_NOT_FOUND",
+			Message: "Promoción no encontrada",
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// responderErrorGuardado responde errores al guardar distinguiendo códigos duplicados
+func (h *PromocionesHandler) responderErrorGuardado(c *gin.Context, err error, mensaje string) {
+	if esViolacionUnicidad(err) {
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "PROMOCION_DUPLICADA",
+				Message: "Ya existe una promoción con el mismo código",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+	responderError(c, h.logger, err, "DATABASE_ERROR", mensaje)
+}
+
+// validarReglasPromocion valida los parámetros requeridos por cada tipo de promoción
+func validarReglasPromocion(req *models.PromocionRequest) string {
+	if req.ProductoID == nil && req.CategoriaID == nil {
+		return "La promoción debe aplicar a un producto o a una categoría"
+	}
+
+	switch req.Tipo {
+	case "lleva_paga":
+		if req.LlevaCantidad == nil || req.PagaCantidad == nil || *req.PagaCantidad >= *req.LlevaCantidad {
+			return "La promoción lleva/paga requiere lleva_cantidad mayor que paga_cantidad"
+		}
+	case "volumen":
+		if len(req.Tramos) == 0 {
+			return "La promoción por volumen requiere al menos un tramo"
+		}
+	case "porcentaje":
+		if req.Porcentaje == nil {
+			return "La promoción por porcentaje requiere el porcentaje de descuento"
+		}
+	}
+
+	for _, s := range req.Sucursales {
+		if !s.FechaFin.After(s.FechaInicio) {
+			return "La fecha de fin de vigencia debe ser posterior a la de inicio"
+		}
+	}
+
+	return ""
+}
+
+// nuevaPromocion construye la promoción a guardar a partir del request
+func nuevaPromocion(id uuid.UUID, req *models.PromocionRequest) *models.Promocion {
+	activa := true
+	if req.Activa != nil {
+		activa = *req.Activa
+	}
+
+	promocion := &models.Promocion{
+		ID:          id,
+		Codigo:      req.Codigo,
+		Nombre:      req.Nombre,
+		Descripcion: req.Descripcion,
+		Tipo:        req.Tipo,
+		ProductoID:  req.ProductoID,
+		CategoriaID: req.CategoriaID,
+		Prioridad:   req.Prioridad,
+		Activa:      activa,
+		Sucursales:  req.Sucursales,
+	}
+
+	// Solo se guardan los parámetros del tipo de promoción
+	switch req.Tipo {
+	case "lleva_paga":
+		promocion.LlevaCantidad = req.LlevaCantidad
+		promocion.PagaCantidad = req.PagaCantidad
+	case "volumen":
+		promocion.Tramos = req.Tramos
+	case "porcentaje":
+		promocion.Porcentaje = req.Porcentaje
+	}
+
+	for i := range promocion.Sucursales {
+		promocion.Sucursales[i].PromocionID = id
+	}
+
+	return promocion
+}
+
+// guardarSucursalesPromocion inserta las ventanas de vigencia de la promoción
+func guardarSucursalesPromocion(ctx context.Context, tx *sql.Tx, promocion *models.Promocion) error {
+	for _, s := range promocion.Sucursales {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO promociones_sucursales (promocion_id, sucursal_id, fecha_inicio, fecha_fin)
+			VALUES ($1, $2, $3, $4)`,
+			promocion.ID, s.SucursalID, s.FechaInicio, s.FechaFin,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tramosJSON serializa los tramos de volumen para la columna JSONB
+func tramosJSON(tramos []models.TramoPromocion) ([]byte, error) {
+	if len(tramos) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(tramos)
+}
+
+// columnasPromocion columnas leídas por scanPromocion
+const columnasPromocion = `p.id, p.codigo, p.nombre, p.descripcion, p.tipo, p.producto_id, p.categoria_id,
+	p.lleva_cantidad, p.paga_cantidad, p.porcentaje, p.tramos, p.prioridad, p.activa,
+	p.usuario_creacion_id, p.fecha_creacion, p.fecha_modificacion`
+
+// listarPromociones ejecuta una consulta sobre columnasPromocion
+func listarPromociones(ctx context.Context, q sqlQueryer, query string, args ...interface{}) ([]models.Promocion, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	promociones := []models.Promocion{}
+	err = database.ScanRows(rows, func() error {
+		var p models.Promocion
+		var tramos []byte
+		if err := rows.Scan(&p.ID, &p.Codigo, &p.Nombre, &p.Descripcion, &p.Tipo, &p.ProductoID,
+			&p.CategoriaID, &p.LlevaCantidad, &p.PagaCantidad, &p.Porcentaje, &tramos, &p.Prioridad,
+			&p.Activa, &p.UsuarioCreacionID, &p.FechaCreacion, &p.FechaModificacion); err != nil {
+			return err
+		}
+		if len(tramos) > 0 {
+			if err := json.Unmarshal(tramos, &p.Tramos); err != nil {
+				return fmt.Errorf("tramos inválidos en promoción %s: %w", p.ID, err)
+			}
+		}
+		promociones = append(promociones, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return promociones, nil
+}
+
+// cargarSucursalesPromociones completa las ventanas de vigencia de las promociones
+func cargarSucursalesPromociones(ctx context.Context, q sqlQueryer, promociones []models.Promocion) error {
+	if len(promociones) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(promociones))
+	indice := make(map[uuid.UUID]int, len(promociones))
+	for i, p := range promociones {
+		ids[i] = p.ID.String()
+		indice[p.ID] = i
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT promocion_id, sucursal_id, fecha_inicio, fecha_fin
+		FROM promociones_sucursales
+		WHERE promocion_id = ANY($1::uuid[])
+		ORDER BY fecha_inicio`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return err
+	}
+
+	return database.ScanRows(rows, func() error {
+		var s models.PromocionSucursal
+		if err := rows.Scan(&s.PromocionID, &s.SucursalID, &s.FechaInicio, &s.FechaFin); err != nil {
+			return err
+		}
+		i := indice[s.PromocionID]
+		promociones[i].Sucursales = append(promociones[i].Sucursales, s)
+		return nil
+	})
+}
+
+// esViolacionUnicidad indica si el error corresponde a una restricción UNIQUE
+func esViolacionUnicidad(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// motorPromociones promociones vigentes en una sucursal con categoría y unidad base de los productos vendidos
+type motorPromociones struct {
+	promociones  []models.Promocion
+	categorias   map[uuid.UUID]uuid.UUID
+	unidadesBase map[uuid.UUID]string
+}
+
+// cargarMotorPromociones obtiene las promociones vigentes que pueden aplicar a los items
+func cargarMotorPromociones(ctx context.Context, q sqlQueryer, sucursalID uuid.UUID, items []models.VentaItemRequest) (*motorPromociones, error) {
+	motor := &motorPromociones{
+		categorias:   make(map[uuid.UUID]uuid.UUID),
+		unidadesBase: make(map[uuid.UUID]string),
+	}
+
+	promociones, err := listarPromociones(ctx, q, `
+		SELECT `+columnasPromocion+`
+		FROM promociones p
+		JOIN promociones_sucursales ps ON ps.promocion_id = p.id
+		WHERE ps.sucursal_id = $1 AND p.activa = true
+			AND NOW() BETWEEN ps.fecha_inicio AND ps.fecha_fin`,
+		sucursalID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando promociones vigentes: %w", err)
+	}
+	motor.promociones = promociones
+	if len(promociones) == 0 {
+		return motor, nil
+	}
+
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ProductoID.String()
+	}
+	rows, err := q.QueryContext(ctx,
+		`SELECT id, categoria_id, unidad_medida FROM productos WHERE id = ANY($1::uuid[])`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando categorías de productos: %w", err)
+	}
+	err = database.ScanRows(rows, func() error {
+		var productoID uuid.UUID
+		var categoriaID *uuid.UUID
+		var unidad string
+		if err := rows.Scan(&productoID, &categoriaID, &unidad); err != nil {
+			return err
+		}
+		if categoriaID != nil {
+			motor.categorias[productoID] = *categoriaID
+		}
+		motor.unidadesBase[productoID] = unidad
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo categorías de productos: %w", err)
+	}
+
+	return motor, nil
+}
+
+// aplicarPromociones asigna las promociones vigentes a las líneas vendidas en unidad base. Las cantidades se
+// suman en todo el carro por producto o, en las promociones de categoría, por categoría, de modo que las
+// unidades registradas en líneas separadas cuentan juntas y el descuento se reparte entre esas líneas.
+// Las promociones no se acumulan: se asigna primero la que entrega el mayor descuento total (ante igual
+// descuento, la de mayor prioridad), sus líneas quedan tomadas y se repite con las líneas restantes.
+func (m *motorPromociones) aplicarPromociones(detalles []models.DetalleVenta) {
+	if m == nil {
+		return
+	}
+
+	libre := make([]bool, len(detalles))
+	for i := range detalles {
+		libre[i] = m.enUnidadBase(detalles[i].ProductoID, detalles[i].UnidadMedida)
+	}
+
+	for {
+		var mejor *models.Promocion
+		var mejorLineas []int
+		var mejorDescuentos []float64
+		mejorTotal := 0.0
+		for k := range m.promociones {
+			p := &m.promociones[k]
+			var lineas []int
+			for i := range detalles {
+				if libre[i] && m.aplicaA(p, detalles[i].ProductoID) {
+					lineas = append(lineas, i)
+				}
+			}
+			if len(lineas) == 0 {
+				continue
+			}
+
+			descuentos := descuentosPromocion(p, detalles, lineas)
+			total := 0.0
+			for j, i := range lineas {
+				total += descuentos[j] * detalles[i].Cantidad
+			}
+			total = redondearMonto(total)
+			if total > mejorTotal || (total == mejorTotal && total > 0 && p.Prioridad > mejor.Prioridad) {
+				mejor, mejorLineas, mejorDescuentos, mejorTotal = p, lineas, descuentos, total
+			}
+		}
+		if mejor == nil {
+			return
+		}
+
+		for j, i := range mejorLineas {
+			libre[i] = false
+			if mejorDescuentos[j] <= 0 {
+				continue
+			}
+
+			d := &detalles[i]
+			d.DescuentoUnitario = redondearMonto(d.DescuentoUnitario + mejorDescuentos[j])
+			d.DatosAdicionales = models.JSONB{"promocion": models.PromocionAplicada{
+				PromocionID:       mejor.ID,
+				Codigo:            mejor.Codigo,
+				Nombre:            mejor.Nombre,
+				Tipo:              mejor.Tipo,
+				ProductoID:        d.ProductoID,
+				Cantidad:          d.Cantidad,
+				DescuentoUnitario: mejorDescuentos[j],
+				DescuentoTotal:    redondearMonto(mejorDescuentos[j] * d.Cantidad),
+			}}
+		}
+	}
+}
+
+// aplicaA indica si la promoción corresponde al producto, directamente o por su categoría
+func (m *motorPromociones) aplicaA(p *models.Promocion, productoID uuid.UUID) bool {
+	if p.ProductoID != nil {
+		return *p.ProductoID == productoID
+	}
+	categoriaID, ok := m.categorias[productoID]
+	return ok && p.CategoriaID != nil && *p.CategoriaID == categoriaID
+}
+
+// enUnidadBase indica si la línea se vende en la unidad base del producto, la única en que aplican promociones
+func (m *motorPromociones) enUnidadBase(productoID uuid.UUID, unidad *string) bool {
+	if m == nil || unidad == nil {
+		return true
+	}
+	return normalizarUnidad(*unidad) == normalizarUnidad(m.unidadesBase[productoID])
+}
+
+// descuentosPromocion calcula el descuento unitario que la promoción otorga a cada una de las líneas indicadas,
+// evaluadas en conjunto. En lleva/paga las unidades de regalo se imputan primero a las líneas de menor precio.
+// Cada descuento se limita a lo que el descuento manual de la línea deja del precio.
+func descuentosPromocion(p *models.Promocion, detalles []models.DetalleVenta, lineas []int) []float64 {
+	cantidad := 0.0
+	for _, i := range lineas {
+		cantidad += detalles[i].Cantidad
+	}
+	cantidad = redondearCantidad(cantidad)
+
+	descuentos := make([]float64, len(lineas))
+	switch p.Tipo {
+	case "lleva_paga":
+		if p.LlevaCantidad == nil || p.PagaCantidad == nil || *p.LlevaCantidad <= 0 {
+			break
+		}
+		// Lleva 3 paga 2: por cada grupo completo se regalan las unidades de diferencia
+		gratis := math.Floor(cantidad/float64(*p.LlevaCantidad)) * float64(*p.LlevaCantidad-*p.PagaCantidad)
+		orden := make([]int, len(lineas))
+		for j := range orden {
+			orden[j] = j
+		}
+		sort.SliceStable(orden, func(a, b int) bool {
+			return detalles[lineas[orden[a]]].PrecioUnitario < detalles[lineas[orden[b]]].PrecioUnitario
+		})
+		for _, j := range orden {
+			if gratis <= 0 {
+				break
+			}
+			d := &detalles[lineas[j]]
+			regalo := math.Min(gratis, d.Cantidad)
+			descuentos[j] = redondearMonto(d.PrecioUnitario * regalo / d.Cantidad)
+			gratis -= regalo
+		}
+	case "volumen":
+		porcentaje := 0.0
+		for _, t := range p.Tramos {
+			if cantidad >= t.CantidadMinima && t.Porcentaje > porcentaje {
+				porcentaje = t.Porcentaje
+			}
+		}
+		for j, i := range lineas {
+			descuentos[j] = redondearMonto(detalles[i].PrecioUnitario * porcentaje / 100)
+		}
+	case "porcentaje":
+		if p.Porcentaje == nil {
+			break
+		}
+		for j, i := range lineas {
+			descuentos[j] = redondearMonto(detalles[i].PrecioUnitario * *p.Porcentaje / 100)
+		}
+	}
+
+	for j, i := range lineas {
+		restante := redondearMonto(detalles[i].PrecioUnitario - detalles[i].DescuentoUnitario)
+		descuentos[j] = math.Max(math.Min(descuentos[j], restante), 0)
+	}
+	return descuentos
+}
+
+// promocionesAplicadas obtiene el desglose de promociones registrado en el detalle
+func promocionesAplicadas(detalles []models.DetalleVenta) []models.PromocionAplicada {
+	var aplicadas []models.PromocionAplicada
+	for i := range detalles {
+		if p, ok := detalles[i].DatosAdicionales["promocion"].(models.PromocionAplicada); ok {
+			aplicadas = append(aplicadas, p)
+		}
+	}
+	return aplicadas
+}
+
+// descuentoManual obtiene el descuento de la línea que no proviene de una promoción
+func descuentoManual(detalle *models.DetalleVenta) float64 {
+	descuento := detalle.DescuentoUnitario
+	if p, ok := detalle.DatosAdicionales["promocion"].(models.PromocionAplicada); ok {
+		descuento -= p.DescuentoUnitario
+	}
+	return redondearMonto(descuento)
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ferre_pos_apis/internal/models"
+)
+
+func TestAplicarPromociones(t *testing.T) {
+	martillo := uuid.New()
+	alicate := uuid.New()
+	herramientas := uuid.New()
+	caja := "caja"
+
+	llevaPaga := func(codigo string, prioridad int, productoID, categoriaID *uuid.UUID) models.Promocion {
+		lleva, paga := 3, 2
+		return models.Promocion{ID: uuid.New(), Codigo: codigo, Tipo: "lleva_paga", ProductoID: productoID,
+			CategoriaID: categoriaID, LlevaCantidad: &lleva, PagaCantidad: &paga, Prioridad: prioridad}
+	}
+	porcentaje := func(codigo string, prioridad int, valor float64, productoID, categoriaID *uuid.UUID) models.Promocion {
+		return models.Promocion{ID: uuid.New(), Codigo: codigo, Tipo: "porcentaje", ProductoID: productoID,
+			CategoriaID: categoriaID, Porcentaje: &valor, Prioridad: prioridad}
+	}
+	volumen := func(codigo string, productoID, categoriaID *uuid.UUID, tramos ...models.TramoPromocion) models.Promocion {
+		return models.Promocion{ID: uuid.New(), Codigo: codigo, Tipo: "volumen", ProductoID: productoID,
+			CategoriaID: categoriaID, Tramos: tramos}
+	}
+	linea := func(productoID uuid.UUID, cantidad, precio, descuento float64) models.DetalleVenta {
+		return models.DetalleVenta{ProductoID: productoID, Cantidad: cantidad, PrecioUnitario: precio, DescuentoUnitario: descuento}
+	}
+
+	tests := []struct {
+		name        string
+		promociones []models.Promocion
+		detalles    []models.DetalleVenta
+		descuentos  []float64
+		codigos     []string
+	}{
+		{
+			name:        "lleva 3 paga 2 en líneas separadas",
+			promociones: []models.Promocion{llevaPaga("LP3X2", 0, &martillo, nil)},
+			detalles:    []models.DetalleVenta{linea(martillo, 1, 1000, 0), linea(martillo, 1, 1000, 0), linea(martillo, 1, 1000, 0)},
+			descuentos:  []float64{1000, 0, 0},
+			codigos:     []string{"LP3X2", "", ""},
+		},
+		{
+			name:        "lleva 3 paga 2 regala solo grupos completos",
+			promociones: []models.Promocion{llevaPaga("LP3X2", 0, &martillo, nil)},
+			detalles:    []models.DetalleVenta{linea(martillo, 5, 1000, 0)},
+			descuentos:  []float64{200},
+			codigos:     []string{"LP3X2"},
+		},
+		{
+			name:        "lleva 3 paga 2 sin completar el grupo",
+			promociones: []models.Promocion{llevaPaga("LP3X2", 0, &martillo, nil)},
+			detalles:    []models.DetalleVenta{linea(martillo, 1, 1000, 0), linea(martillo, 1, 1000, 0)},
+			descuentos:  []float64{0, 0},
+			codigos:     []string{"", ""},
+		},
+		{
+			name:        "lleva 3 paga 2 por categoría regala la unidad más barata",
+			promociones: []models.Promocion{llevaPaga("CAT3X2", 0, nil, &herramientas)},
+			detalles:    []models.DetalleVenta{linea(martillo, 2, 1000, 0), linea(alicate, 1, 600, 0)},
+			descuentos:  []float64{0, 600},
+			codigos:     []string{"", "CAT3X2"},
+		},
+		{
+			name: "gana la promoción de mayor descuento total",
+			promociones: []models.Promocion{
+				porcentaje("MART10", 9, 10, &martillo, nil),
+				volumen("VOL20", nil, &herramientas, models.TramoPromocion{CantidadMinima: 2, Porcentaje: 10}, models.TramoPromocion{CantidadMinima: 3, Porcentaje: 20}),
+			},
+			detalles:   []models.DetalleVenta{linea(martillo, 2, 1000, 0), linea(alicate, 1, 600, 0)},
+			descuentos: []float64{200, 120},
+			codigos:    []string{"VOL20", "VOL20"},
+		},
+		{
+			name: "ante igual descuento gana la mayor prioridad",
+			promociones: []models.Promocion{
+				porcentaje("BAJA", 1, 10, &martillo, nil),
+				porcentaje("ALTA", 5, 10, nil, &herramientas),
+			},
+			detalles:   []models.DetalleVenta{linea(martillo, 1, 1000, 0)},
+			descuentos: []float64{100},
+			codigos:    []string{"ALTA"},
+		},
+		{
+			name: "las líneas libres reciben la siguiente promoción",
+			promociones: []models.Promocion{
+				llevaPaga("LP3X2", 0, &martillo, nil),
+				porcentaje("CAT10", 0, 10, nil, &herramientas),
+			},
+			detalles:   []models.DetalleVenta{linea(martillo, 3, 1000, 0), linea(alicate, 1, 600, 0)},
+			descuentos: []float64{333.33, 60},
+			codigos:    []string{"LP3X2", "CAT10"},
+		},
+		{
+			name:        "se suma al descuento manual",
+			promociones: []models.Promocion{llevaPaga("LP3X2", 0, &martillo, nil)},
+			detalles:    []models.DetalleVenta{linea(martillo, 1, 1000, 100), linea(martillo, 2, 1000, 0)},
+			descuentos:  []float64{1000, 0},
+			codigos:     []string{"LP3X2", ""},
+		},
+		{
+			name:        "el descuento manual limita la promoción",
+			promociones: []models.Promocion{porcentaje("MART30", 0, 30, &martillo, nil)},
+			detalles:    []models.DetalleVenta{linea(martillo, 2, 1000, 800)},
+			descuentos:  []float64{1000},
+			codigos:     []string{"MART30"},
+		},
+		{
+			name:        "no aplica fuera de la unidad base",
+			promociones: []models.Promocion{porcentaje("MART10", 0, 10, &martillo, nil)},
+			detalles:    []models.DetalleVenta{{ProductoID: martillo, Cantidad: 1, PrecioUnitario: 12000, UnidadMedida: &caja}},
+			descuentos:  []float64{0},
+			codigos:     []string{""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			motor := &motorPromociones{
+				promociones:  tt.promociones,
+				categorias:   map[uuid.UUID]uuid.UUID{martillo: herramientas, alicate: herramientas},
+				unidadesBase: map[uuid.UUID]string{martillo: "unidad", alicate: "unidad"},
+			}
+			manuales := make([]float64, len(tt.detalles))
+			for i := range tt.detalles {
+				manuales[i] = tt.detalles[i].DescuentoUnitario
+			}
+
+			motor.aplicarPromociones(tt.detalles)
+
+			for i := range tt.detalles {
+				detalle := &tt.detalles[i]
+				assert.Equal(t, tt.descuentos[i], detalle.DescuentoUnitario, "línea %d", i)
+				assert.Equal(t, manuales[i], descuentoManual(detalle), "línea %d", i)
+
+				promocion, ok := detalle.DatosAdicionales["promocion"].(models.PromocionAplicada)
+				if tt.codigos[i] == "" {
+					assert.False(t, ok, "línea %d", i)
+					continue
+				}
+				require.True(t, ok, "línea %d", i)
+				assert.Equal(t, tt.codigos[i], promocion.Codigo)
+				assert.Equal(t, redondearMonto(promocion.DescuentoUnitario*detalle.Cantidad), promocion.DescuentoTotal)
+			}
+		})
+	}
+}
+
+func TestCalcularDetalleVentaConPromocion(t *testing.T) {
+	martillo := uuid.New()
+	lleva, paga := 3, 2
+	motor := &motorPromociones{
+		promociones: []models.Promocion{{ID: uuid.New(), Codigo: "LP3X2", Tipo: "lleva_paga", ProductoID: &martillo,
+			LlevaCantidad: &lleva, PagaCantidad: &paga}},
+		categorias:   map[uuid.UUID]uuid.UUID{},
+		unidadesBase: map[uuid.UUID]string{martillo: "unidad"},
+	}
+	items := []models.VentaItemRequest{
+		{ProductoID: martillo, Cantidad: 2, PrecioUnitario: 1000, DescuentoUnitario: 50},
+		{ProductoID: martillo, Cantidad: 1, PrecioUnitario: 1000},
+	}
+
+	venta := &models.Venta{ID: uuid.New(), Fecha: time.Now()}
+	detalles, vErr := calcularDetalleVenta(venta, items, motor)
+	require.Nil(t, vErr)
+	require.Len(t, detalles, 2)
+
+	assert.Equal(t, 550.0, detalles[0].DescuentoUnitario)
+	assert.Equal(t, 450.0, detalles[0].PrecioFinal)
+	assert.Equal(t, 900.0, detalles[0].TotalItem)
+	assert.Equal(t, 0.0, detalles[1].DescuentoUnitario)
+	assert.Equal(t, 1000.0, detalles[1].TotalItem)
+
+	assert.Equal(t, 3000.0, venta.Subtotal)
+	assert.Equal(t, 1100.0, venta.DescuentoTotal)
+	assert.Equal(t, 361.0, venta.ImpuestoTotal)
+	assert.Equal(t, 2261.0, venta.Total)
+}
@@ -0,0 +1,1068 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+// versionReglaCompilada versión del formato guardado en reglas_fidelizacion.condiciones_compiladas.
+// Las reglas con otra versión (por ejemplo las cargadas por SQL) se compilan al evaluarlas.
+const versionReglaCompilada = 1
+
+// nivelesFidelizacion orden de los niveles del programa, de menor a mayor
+var nivelesFidelizacion = map[string]int{
+	"bronce":  0,
+	"plata":   1,
+	"oro":     2,
+	"platino": 3,
+}
+
+// diasSemanaRegla días aceptados en condiciones.dias_semana
+var diasSemanaRegla = map[string][]time.Weekday{
+	"domingo":       {time.Sunday},
+	"lunes":         {time.Monday},
+	"martes":        {time.Tuesday},
+	"miercoles":     {time.Wednesday},
+	"miércoles":     {time.Wednesday},
+	"jueves":        {time.Thursday},
+	"viernes":       {time.Friday},
+	"sabado":        {time.Saturday},
+	"sábado":        {time.Saturday},
+	"fin_de_semana": {time.Saturday, time.Sunday},
+}
+
+// condicionesRegla formato de reglas_fidelizacion.condiciones. Todas las condiciones informadas deben cumplirse:
+//
+//	monto_minimo          monto mínimo de la venta pagado sin puntos
+//	nivel_cliente         nivel o lista de niveles del cliente: bronce, plata, oro, platino
+//	categorias            categorías cuyas líneas generan los puntos de la regla
+//	productos             productos cuyas líneas generan los puntos de la regla
+//	productos_excluidos   productos que nunca generan puntos con la regla
+//	sucursales_incluidas  "todas" o lista de sucursales
+//	dias_semana           lunes a domingo, o "fin_de_semana"
+//	hora_desde/hora_hasta ventana horaria HH:MM de la venta
+//	gasto_periodo         {"monto_minimo": 500000, "dias": 90}, gasto del cliente en los últimos días
+//
+// Ejemplo, categoría con puntos dobles los fines de semana:
+//
+//	{"categorias": ["<categoria_id>"], "dias_semana": ["fin_de_semana"]}
+type condicionesRegla struct {
+	MontoMinimo         *float64        `json:"monto_minimo,omitempty"`
+	NivelCliente        json.RawMessage `json:"nivel_cliente,omitempty"`
+	Categorias          []uuid.UUID     `json:"categorias,omitempty"`
+	Productos           []uuid.UUID     `json:"productos,omitempty"`
+	ProductosExcluidos  []uuid.UUID     `json:"productos_excluidos,omitempty"`
+	SucursalesIncluidas json.RawMessage `json:"sucursales_incluidas,omitempty"`
+	DiasSemana          []string        `json:"dias_semana,omitempty"`
+	HoraDesde           *string         `json:"hora_desde,omitempty"`
+	HoraHasta           *string         `json:"hora_hasta,omitempty"`
+	GastoPeriodo        *gastoPeriodo   `json:"gasto_periodo,omitempty"`
+}
+
+// gastoPeriodo condición de gasto acumulado del cliente en una ventana móvil de días
+type gastoPeriodo struct {
+	MontoMinimo float64 `json:"monto_minimo"`
+	Dias        int     `json:"dias"`
+}
+
+// accionesRegla formato de reglas_fidelizacion.acciones según tipo_regla:
+//
+//	acumulacion, promocion:
+//	  puntos_por_peso     regla base; la de mayor prioridad que se cumple reemplaza a fidelizacion.puntos_por_peso
+//	  multiplicador_base  factor sobre puntos_por_peso de la regla base
+//	  multiplicador       puntos extra: (multiplicador - 1) veces los puntos base de las líneas de la regla
+//	  bonus_adicional     puntos fijos extra por venta
+//	  redondeo            inferior (por defecto), superior o cercano
+//	  exclusiva           no se evalúan reglas de menor prioridad después de aplicarla
+//	canje:
+//	  minimo_canje        mínimo de puntos por canje
+//	  porcentaje_maximo   porcentaje máximo del total de la venta pagable con puntos
+//	nivel:
+//	  nivel               nivel asignado al cliente; nunca lo baja
+//
+// Ejemplo, gasto sobre 500 mil en 90 días sube a oro:
+//
+//	condiciones: {"gasto_periodo": {"monto_minimo": 500000, "dias": 90}}
+//	acciones:    {"nivel": "oro"}
+type accionesRegla struct {
+	PuntosPorPeso     *float64 `json:"puntos_por_peso,omitempty"`
+	MultiplicadorBase *float64 `json:"multiplicador_base,omitempty"`
+	Multiplicador     *float64 `json:"multiplicador,omitempty"`
+	BonusAdicional    int      `json:"bonus_adicional,omitempty"`
+	Redondeo          string   `json:"redondeo,omitempty"`
+	Exclusiva         bool     `json:"exclusiva,omitempty"`
+	MinimoCanje       *int     `json:"minimo_canje,omitempty"`
+	PorcentajeMaximo  *float64 `json:"porcentaje_maximo,omitempty"`
+	Nivel             string   `json:"nivel,omitempty"`
+}
+
+// reglaCompilada condiciones y acciones normalizadas para evaluar sin volver a interpretar el JSON
+type reglaCompilada struct {
+	Version            int           `json:"version"`
+	MontoMinimo        float64       `json:"monto_minimo"`
+	Niveles            []string      `json:"niveles,omitempty"`
+	Categorias         []uuid.UUID   `json:"categorias,omitempty"`
+	Productos          []uuid.UUID   `json:"productos,omitempty"`
+	ProductosExcluidos []uuid.UUID   `json:"productos_excluidos,omitempty"`
+	Sucursales         []uuid.UUID   `json:"sucursales,omitempty"`
+	DiasSemana         uint8         `json:"dias_semana"` // Bit por time.Weekday; 0 todos los días
+	MinutoDesde        int           `json:"minuto_desde"`
+	MinutoHasta        int           `json:"minuto_hasta"` // 0 sin ventana horaria
+	GastoPeriodo       *gastoPeriodo `json:"gasto_periodo,omitempty"`
+
+	PuntosPorPeso  float64 `json:"puntos_por_peso"`
+	Multiplicador  float64 `json:"multiplicador"`
+	BonusAdicional int     `json:"bonus_adicional"`
+	Redondeo       string  `json:"redondeo"`
+	Exclusiva      bool    `json:"exclusiva"`
+
+	MinimoCanje      *int     `json:"minimo_canje,omitempty"`
+	PorcentajeMaximo *float64 `json:"porcentaje_maximo,omitempty"`
+	Nivel            string   `json:"nivel,omitempty"`
+}
+
+// Value implementa driver.Valuer para guardar la regla en condiciones_compiladas
+func (r *reglaCompilada) Value() (driver.Value, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return json.Marshal(r)
+}
+
+// ReglasFidelizacionHandler handler para el mantenedor de reglas del programa de fidelización
+type ReglasFidelizacionHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+}
+
+// NewReglasFidelizacionHandler crea un nuevo handler de reglas de fidelización
+func NewReglasFidelizacionHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *ReglasFidelizacionHandler {
+	return &ReglasFidelizacionHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+	}
+}
+
+// Create valida, compila y guarda una regla de fidelización
+func (h *ReglasFidelizacionHandler) Create(c *gin.Context) {
+	var req models.ReglaFidelizacionRequest
+	compilada, ok := h.bindAndCompile(c, &req)
+	if !ok {
+		return
+	}
+
+	regla := nuevaReglaFidelizacion(uuid.New(), &req)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := h.db.QueryRowContext(ctx, `
+		INSERT INTO reglas_fidelizacion (
+			id, nombre, descripcion, tipo_regla, condiciones, acciones, activa,
+			fecha_inicio, fecha_fin, prioridad, condiciones_compiladas
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING fecha_creacion, fecha_modificacion`,
+		regla.ID, regla.Nombre, regla.Descripcion, regla.TipoRegla, regla.Condiciones,
+		regla.Acciones, regla.Activa, regla.FechaInicio, regla.FechaFin, regla.Prioridad, compilada,
+	).Scan(&regla.FechaCreacion, &regla.FechaModificacion)
+	if err != nil {
+		responderError(c, h.logger, err, "DATABASE_ERROR", "Error creando regla de fidelización")
+		return
+	}
+
+	h.logger.WithField("regla_id", regla.ID).
+		WithField("tipo_regla", regla.TipoRegla).
+		Info("Regla de fidelización creada")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      regla,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// List lista las reglas de fidelización por prioridad, opcionalmente por tipo o solo activas
+func (h *ReglasFidelizacionHandler) List(c *gin.Context) {
+	query := `SELECT ` + columnasReglaFidelizacion + ` FROM reglas_fidelizacion WHERE 1 = 1`
+	var args []interface{}
+	if tipo := c.Query("tipo_regla"); tipo != "" {
+		args = append(args, tipo)
+		query += fmt.Sprintf(` AND tipo_regla = $%d`, len(args))
+	}
+	if c.Query("activas") == "true" {
+		query += ` AND activa = true`
+	}
+	query += ` ORDER BY prioridad DESC, fecha_creacion`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	reglas, err := listarReglasFidelizacion(ctx, h.db, query, args...)
+	if err != nil {
+		responderError(c, h.logger, err, "DATABASE_ERROR", "Error consultando reglas de fidelización")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      reglas,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetByID obtiene una regla de fidelización con sus estadísticas de aplicación
+func (h *ReglasFidelizacionHandler) GetByID(c *gin.Context) {
+	reglaID, ok := uuidParam(c, "id", "INVALID_REGLA_ID", "ID de regla de fidelización inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	reglas, err := listarReglasFidelizacion(ctx, h.db,
+		`SELECT `+columnasReglaFidelizacion+` FROM reglas_fidelizacion WHERE id = $1`, reglaID)
+	if err != nil {
+		responderError(c, h.logger, err, "DATABASE_ERROR", "Error consultando regla de fidelización")
+		return
+	}
+	if len(reglas) == 0 {
+		h.responderNoEncontrada(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      reglas[0],
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Update reemplaza las condiciones y acciones de una regla volviendo a compilarla
+func (h *ReglasFidelizacionHandler) Update(c *gin.Context) {
+	reglaID, ok := uuidParam(c, "id", "INVALID_REGLA_ID", "ID de regla de fidelización inválido")
+	if !ok {
+		return
+	}
+
+	var req models.ReglaFidelizacionRequest
+	compilada, ok := h.bindAndCompile(c, &req)
+	if !ok {
+		return
+	}
+
+	regla := nuevaReglaFidelizacion(reglaID, &req)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := h.db.QueryRowContext(ctx, `
+		UPDATE reglas_fidelizacion
+		SET nombre = $2, descripcion = $3, tipo_regla = $4, condiciones = $5, acciones = $6,
+			activa = $7, fecha_inicio = $8, fecha_fin = $9, prioridad = $10,
+			condiciones_compiladas = $11, cache_evaluacion = NULL, fecha_modificacion = NOW()
+		WHERE id = $1
+		RETURNING COALESCE(total_aplicaciones, 0), COALESCE(total_puntos_otorgados, 0),
+			fecha_creacion, fecha_modificacion`,
+		regla.ID, regla.Nombre, regla.Descripcion, regla.TipoRegla, regla.Condiciones,
+		regla.Acciones, regla.Activa, regla.FechaInicio, regla.FechaFin, regla.Prioridad, compilada,
+	).Scan(&regla.TotalAplicaciones, &regla.TotalPuntosOtorgados, &regla.FechaCreacion, &regla.FechaModificacion)
+	if errors.Is(err, sql.ErrNoRows) {
+		h.responderNoEncontrada(c)
+		return
+	}
+	if err != nil {
+		responderError(c, h.logger, err, "DATABASE_ERROR", "Error actualizando regla de fidelización")
+		return
+	}
+
+	h.logger.WithField("regla_id", regla.ID).Info("Regla de fidelización actualizada")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      regla,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Delete desactiva una regla conservando sus estadísticas y los movimientos que la referencian
+func (h *ReglasFidelizacionHandler) Delete(c *gin.Context) {
+	reglaID, ok := uuidParam(c, "id", "INVALID_REGLA_ID", "ID de regla de fidelización inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := h.db.ExecContext(ctx,
+		`UPDATE reglas_fidelizacion SET activa = false, fecha_modificacion = NOW() WHERE id = $1`,
+		reglaID,
+	)
+	if err != nil {
+		responderError(c, h.logger, err, "DATABASE_ERROR", "Error desactivando regla de fidelización")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		h.responderNoEncontrada(c)
+		return
+	}
+
+	h.logger.WithField("regla_id", reglaID).Info("Regla de fidelización desactivada")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      gin.H{"message": "Regla de fidelización desactivada exitosamente"},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Recompilar compila las reglas guardadas con un formato anterior o cargadas directamente por SQL
+func (h *ReglasFidelizacionHandler) Recompilar(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	reglas, err := listarReglasFidelizacion(ctx, h.db,
+		`SELECT `+columnasReglaFidelizacion+` FROM reglas_fidelizacion ORDER BY prioridad DESC`)
+	if err != nil {
+		responderError(c, h.logger, err, "DATABASE_ERROR", "Error consultando reglas de fidelización")
+		return
+	}
+
+	compiladas := 0
+	invalidas := []gin.H{}
+	for _, regla := range reglas {
+		compilada, err := compilarReglaFidelizacion(regla.TipoRegla, regla.Condiciones, regla.Acciones)
+		if err != nil {
+			invalidas = append(invalidas, gin.H{"regla_id": regla.ID, "nombre": regla.Nombre, "error": err.Error()})
+			continue
+		}
+
+		_, err = h.db.ExecContext(ctx,
+			`UPDATE reglas_fidelizacion SET condiciones_compiladas = $2, cache_evaluacion = NULL WHERE id = $1`,
+			regla.ID, compilada,
+		)
+		if err != nil {
+			responderError(c, h.logger, err, "DATABASE_ERROR", "Error guardando regla compilada")
+			return
+		}
+		compiladas++
+	}
+
+	h.logger.WithField("compiladas", compiladas).
+		WithField("invalidas", len(invalidas)).
+		Info("Reglas de fidelización recompiladas")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      gin.H{"compiladas": compiladas, "invalidas": invalidas},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Métodos auxiliares
+
+// bindAndCompile lee y valida el cuerpo de la regla y compila sus condiciones y acciones
+func (h *ReglasFidelizacionHandler) bindAndCompile(c *gin.Context, req *models.ReglaFidelizacionRequest) (*reglaCompilada, bool) {
+	if !bindAndValidate(c, h.validator, req) {
+		return nil, false
+	}
+
+	mensaje := ""
+	if req.FechaInicio != nil && req.FechaFin != nil && !req.FechaFin.After(*req.FechaInicio) {
+		mensaje = "La fecha de fin de vigencia debe ser posterior a la de inicio"
+	}
+	compilada, err := compilarReglaFidelizacion(req.TipoRegla, req.Condiciones, req.Acciones)
+	if mensaje == "" && err != nil {
+		mensaje = err.Error()
+	}
+	if mensaje != "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_REGLA_FIDELIZACION",
+				Message: mensaje,
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return nil, false
+	}
+
+	return compilada, true
+}
+
+// responderNoEncontrada responde que la regla no existe
+func (h *ReglasFidelizacionHandler) responderNoEncontrada(c *gin.Context) {
+	c.JSON(http.StatusNotFound, models.APIResponse{
+		Success: false,
+		Error: &models.APIError{
+			Code:    "REGLA_FIDELIZACION_NOT_FOUND",
+			Message: "Regla de fidelización no encontrada",
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// nuevaReglaFidelizacion construye la regla a guardar a partir del request
+func nuevaReglaFidelizacion(id uuid.UUID, req *models.ReglaFidelizacionRequest) *models.ReglaFidelizacion {
+	activa := true
+	if req.Activa != nil {
+		activa = *req.Activa
+	}
+
+	return &models.ReglaFidelizacion{
+		ID:          id,
+		Nombre:      req.Nombre,
+		Descripcion: req.Descripcion,
+		TipoRegla:   req.TipoRegla,
+		Condiciones: req.Condiciones,
+		Acciones:    req.Acciones,
+		Activa:      activa,
+		FechaInicio: req.FechaInicio,
+		FechaFin:    req.FechaFin,
+		Prioridad:   req.Prioridad,
+	}
+}
+
+// columnasReglaFidelizacion columnas leídas por listarReglasFidelizacion
+const columnasReglaFidelizacion = `id, nombre, descripcion, tipo_regla, condiciones, acciones,
+	COALESCE(activa, false), fecha_inicio, fecha_fin, COALESCE(prioridad, 0),
+	COALESCE(total_aplicaciones, 0), COALESCE(total_puntos_otorgados, 0),
+	fecha_creacion, fecha_modificacion, condiciones_compiladas`
+
+// listarReglasFidelizacion ejecuta una consulta sobre columnasReglaFidelizacion
+func listarReglasFidelizacion(ctx context.Context, q sqlQueryer, query string, args ...interface{}) ([]models.ReglaFidelizacion, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	reglas := []models.ReglaFidelizacion{}
+	err = database.ScanRows(rows, func() error {
+		var r models.ReglaFidelizacion
+		var compilada []byte
+		if err := rows.Scan(&r.ID, &r.Nombre, &r.Descripcion, &r.TipoRegla, &r.Condiciones,
+			&r.Acciones, &r.Activa, &r.FechaInicio, &r.FechaFin, &r.Prioridad, &r.TotalAplicaciones,
+			&r.TotalPuntosOtorgados, &r.FechaCreacion, &r.FechaModificacion, &compilada); err != nil {
+			return err
+		}
+		r.Compilada = compilada
+		reglas = append(reglas, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reglas, nil
+}
+
+// decodificarEstricto convierte el JSONB al formato documentado rechazando campos desconocidos
+func decodificarEstricto(datos models.JSONB, destino interface{}) error {
+	b, err := json.Marshal(datos)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	return dec.Decode(destino)
+}
+
+// compilarReglaFidelizacion valida las condiciones y acciones de una regla y las normaliza para su evaluación
+func compilarReglaFidelizacion(tipoRegla string, condiciones, acciones models.JSONB) (*reglaCompilada, error) {
+	var cond condicionesRegla
+	if err := decodificarEstricto(condiciones, &cond); err != nil {
+		return nil, fmt.Errorf("condiciones inválidas: %v", err)
+	}
+	var acc accionesRegla
+	if err := decodificarEstricto(acciones, &acc); err != nil {
+		return nil, fmt.Errorf("acciones inválidas: %v", err)
+	}
+
+	r := &reglaCompilada{
+		Version:            versionReglaCompilada,
+		Categorias:         cond.Categorias,
+		Productos:          cond.Productos,
+		ProductosExcluidos: cond.ProductosExcluidos,
+		GastoPeriodo:       cond.GastoPeriodo,
+		Multiplicador:      1,
+		Redondeo:           "inferior",
+		Exclusiva:          acc.Exclusiva,
+	}
+
+	// Condiciones
+	if cond.MontoMinimo != nil {
+		if *cond.MontoMinimo < 0 {
+			return nil, errors.New("monto_minimo no puede ser negativo")
+		}
+		r.MontoMinimo = *cond.MontoMinimo
+	}
+
+	if len(cond.NivelCliente) > 0 {
+		var niveles []string
+		if err := json.Unmarshal(cond.NivelCliente, &niveles); err != nil {
+			var nivel string
+			if err := json.Unmarshal(cond.NivelCliente, &nivel); err != nil {
+				return nil, errors.New("nivel_cliente debe ser un nivel o una lista de niveles")
+			}
+			niveles = []string{nivel}
+		}
+		for _, nivel := range niveles {
+			if _, ok := nivelesFidelizacion[nivel]; !ok {
+				return nil, fmt.Errorf("nivel_cliente desconocido: %s", nivel)
+			}
+		}
+		r.Niveles = niveles
+	}
+
+	if len(cond.SucursalesIncluidas) > 0 {
+		var todas string
+		if err := json.Unmarshal(cond.SucursalesIncluidas, &todas); err == nil {
+			if todas != "todas" {
+				return nil, errors.New(`sucursales_incluidas debe ser "todas" o una lista de sucursales`)
+			}
+		} else if err := json.Unmarshal(cond.SucursalesIncluidas, &r.Sucursales); err != nil {
+			return nil, errors.New(`sucursales_incluidas debe ser "todas" o una lista de sucursales`)
+		}
+	}
+
+	for _, dia := range cond.DiasSemana {
+		dias, ok := diasSemanaRegla[strings.ToLower(dia)]
+		if !ok {
+			return nil, fmt.Errorf("dia_semana desconocido: %s", dia)
+		}
+		for _, d := range dias {
+			r.DiasSemana |= 1 << uint(d)
+		}
+	}
+
+	if (cond.HoraDesde == nil) != (cond.HoraHasta == nil) {
+		return nil, errors.New("hora_desde y hora_hasta deben informarse juntas")
+	}
+	if cond.HoraDesde != nil {
+		desde, err := time.Parse("15:04", *cond.HoraDesde)
+		if err != nil {
+			return nil, errors.New("hora_desde debe tener formato HH:MM")
+		}
+		hasta, err := time.Parse("15:04", *cond.HoraHasta)
+		if err != nil {
+			return nil, errors.New("hora_hasta debe tener formato HH:MM")
+		}
+		r.MinutoDesde = desde.Hour()*60 + desde.Minute()
+		r.MinutoHasta = hasta.Hour()*60 + hasta.Minute()
+		if r.MinutoHasta <= r.MinutoDesde {
+			return nil, errors.New("hora_hasta debe ser posterior a hora_desde")
+		}
+	}
+
+	if cond.GastoPeriodo != nil && (cond.GastoPeriodo.Dias <= 0 || cond.GastoPeriodo.MontoMinimo <= 0) {
+		return nil, errors.New("gasto_periodo requiere dias y monto_minimo mayores que cero")
+	}
+
+	// Acciones según el tipo de regla
+	switch tipoRegla {
+	case "acumulacion", "promocion":
+		if acc.PuntosPorPeso != nil {
+			if *acc.PuntosPorPeso <= 0 {
+				return nil, errors.New("puntos_por_peso debe ser mayor que cero")
+			}
+			r.PuntosPorPeso = *acc.PuntosPorPeso
+			if acc.MultiplicadorBase != nil {
+				r.Multiplicador = *acc.MultiplicadorBase
+			}
+		} else if acc.Multiplicador != nil {
+			r.Multiplicador = *acc.Multiplicador
+		}
+		// multiplicador se registra en movimientos_fidelizacion como NUMERIC(4,2)
+		if r.Multiplicador <= 0 || r.Multiplicador >= 100 {
+			return nil, errors.New("el multiplicador debe ser mayor que 0 y menor que 100")
+		}
+		if acc.BonusAdicional < 0 {
+			return nil, errors.New("bonus_adicional no puede ser negativo")
+		}
+		r.BonusAdicional = acc.BonusAdicional
+		if r.PuntosPorPeso == 0 && r.Multiplicador <= 1 && r.BonusAdicional == 0 {
+			return nil, errors.New("la regla debe definir puntos_por_peso, un multiplicador mayor que 1 o bonus_adicional")
+		}
+
+		switch acc.Redondeo {
+		case "", "inferior":
+		case "superior", "cercano":
+			r.Redondeo = acc.Redondeo
+		default:
+			return nil, fmt.Errorf("redondeo desconocido: %s", acc.Redondeo)
+		}
+	case "canje":
+		if acc.MinimoCanje == nil && acc.PorcentajeMaximo == nil {
+			return nil, errors.New("la regla de canje debe definir minimo_canje o porcentaje_maximo")
+		}
+		if acc.MinimoCanje != nil && *acc.MinimoCanje < 0 {
+			return nil, errors.New("minimo_canje no puede ser negativo")
+		}
+		if acc.PorcentajeMaximo != nil && (*acc.PorcentajeMaximo <= 0 || *acc.PorcentajeMaximo > 100) {
+			return nil, errors.New("porcentaje_maximo debe estar entre 0 y 100")
+		}
+		r.MinimoCanje = acc.MinimoCanje
+		r.PorcentajeMaximo = acc.PorcentajeMaximo
+	case "nivel":
+		if _, ok := nivelesFidelizacion[acc.Nivel]; !ok {
+			return nil, fmt.Errorf("nivel desconocido: %s", acc.Nivel)
+		}
+		r.Nivel = acc.Nivel
+	default:
+		return nil, fmt.Errorf("tipo_regla desconocido: %s", tipoRegla)
+	}
+
+	return r, nil
+}
+
+// reglaFidelizacionVigente regla activa en evaluación
+type reglaFidelizacionVigente struct {
+	id        uuid.UUID
+	nombre    string
+	tipo      string
+	prioridad int
+	reglaCompilada
+}
+
+// motorFidelizacion reglas vigentes ordenadas por prioridad, de mayor a menor
+type motorFidelizacion struct {
+	reglas []reglaFidelizacionVigente
+}
+
+// cargarMotorFidelizacion obtiene las reglas activas y vigentes usando su forma compilada.
+// Las reglas sin compilar o con una versión anterior se compilan en memoria; si no son válidas se omiten.
+func cargarMotorFidelizacion(ctx context.Context, q sqlQueryer, log logger.Logger) (*motorFidelizacion, error) {
+	reglas, err := listarReglasFidelizacion(ctx, q, `
+		SELECT `+columnasReglaFidelizacion+`
+		FROM reglas_fidelizacion
+		WHERE activa = true
+			AND (fecha_inicio IS NULL OR fecha_inicio <= NOW())
+			AND (fecha_fin IS NULL OR fecha_fin > NOW())
+		ORDER BY prioridad DESC, fecha_creacion`)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando reglas de fidelización: %w", err)
+	}
+
+	motor := &motorFidelizacion{}
+	for _, regla := range reglas {
+		var compilada reglaCompilada
+		if len(regla.Compilada) > 0 {
+			if err := json.Unmarshal(regla.Compilada, &compilada); err != nil {
+				compilada.Version = 0
+			}
+		}
+		if compilada.Version != versionReglaCompilada {
+			c, err := compilarReglaFidelizacion(regla.TipoRegla, regla.Condiciones, regla.Acciones)
+			if err != nil {
+				if log != nil {
+					log.WithError(err).WithField("regla_id", regla.ID).Warn("Regla de fidelización inválida omitida")
+				}
+				continue
+			}
+			compilada = *c
+		}
+
+		motor.reglas = append(motor.reglas, reglaFidelizacionVigente{
+			id:             regla.ID,
+			nombre:         regla.Nombre,
+			tipo:           regla.TipoRegla,
+			prioridad:      regla.Prioridad,
+			reglaCompilada: compilada,
+		})
+	}
+
+	return motor, nil
+}
+
+// lineaFidelizacion línea de la venta con el monto que genera puntos
+type lineaFidelizacion struct {
+	productoID  uuid.UUID
+	categoriaID *uuid.UUID
+	monto       float64
+}
+
+// contextoFidelizacion datos de la venta y del cliente contra los que se evalúan las reglas
+type contextoFidelizacion struct {
+	sucursalID uuid.UUID
+	fecha      time.Time
+	nivel      string
+	montoBase  float64
+	lineas     []lineaFidelizacion
+	// gasto obtiene el gasto del cliente en los últimos días; se consulta solo si alguna regla lo requiere
+	gasto func(dias int) (float64, error)
+}
+
+// aplicacionRegla resultado de aplicar una regla a una venta
+type aplicacionRegla struct {
+	reglaID       *uuid.UUID
+	nombre        string
+	puntos        int
+	multiplicador float64
+}
+
+// cumple evalúa las condiciones de la regla y devuelve el monto de las líneas que generan puntos con ella
+func (r *reglaFidelizacionVigente) cumple(ctxEval *contextoFidelizacion) (bool, float64, error) {
+	if ctxEval.montoBase < r.MontoMinimo {
+		return false, 0, nil
+	}
+	if len(r.Niveles) > 0 && !contieneTexto(r.Niveles, ctxEval.nivel) {
+		return false, 0, nil
+	}
+	if len(r.Sucursales) > 0 && !contieneUUID(r.Sucursales, ctxEval.sucursalID) {
+		return false, 0, nil
+	}
+	if r.DiasSemana != 0 && r.DiasSemana&(1<<uint(ctxEval.fecha.Weekday())) == 0 {
+		return false, 0, nil
+	}
+	if r.MinutoHasta > 0 {
+		minuto := ctxEval.fecha.Hour()*60 + ctxEval.fecha.Minute()
+		if minuto < r.MinutoDesde || minuto >= r.MinutoHasta {
+			return false, 0, nil
+		}
+	}
+
+	monto := 0.0
+	for _, l := range ctxEval.lineas {
+		if contieneUUID(r.ProductosExcluidos, l.productoID) {
+			continue
+		}
+		if len(r.Productos) > 0 || len(r.Categorias) > 0 {
+			enCategoria := l.categoriaID != nil && contieneUUID(r.Categorias, *l.categoriaID)
+			if !contieneUUID(r.Productos, l.productoID) && !enCategoria {
+				continue
+			}
+		}
+		monto += l.monto
+	}
+	if (len(r.Productos) > 0 || len(r.Categorias) > 0) && monto <= 0 {
+		return false, 0, nil
+	}
+
+	if r.GastoPeriodo != nil {
+		gasto, err := ctxEval.gasto(r.GastoPeriodo.Dias)
+		if err != nil {
+			return false, 0, err
+		}
+		if gasto < r.GastoPeriodo.MontoMinimo {
+			return false, 0, nil
+		}
+	}
+
+	return true, monto, nil
+}
+
+// evaluarAcumulacion aplica por prioridad las reglas de acumulación y promoción.
+// La regla base de mayor prioridad que se cumple define los puntos por peso; sin reglas base se usa puntosPorPesoDefecto.
+// Las reglas de multiplicador y bonus suman puntos extra sobre los puntos base de sus líneas.
+func (m *motorFidelizacion) evaluarAcumulacion(ctxEval *contextoFidelizacion, puntosPorPesoDefecto float64) ([]aplicacionRegla, error) {
+	if m == nil {
+		m = &motorFidelizacion{}
+	}
+	var aplicaciones []aplicacionRegla
+
+	// Regla base
+	puntosPorPeso := puntosPorPesoDefecto
+	hayReglasBase := false
+	var base *aplicacionRegla
+	for i := range m.reglas {
+		r := &m.reglas[i]
+		if (r.tipo != "acumulacion" && r.tipo != "promocion") || r.PuntosPorPeso == 0 {
+			continue
+		}
+		hayReglasBase = true
+		ok, monto, err := r.cumple(ctxEval)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		id := r.id
+		puntosPorPeso = r.PuntosPorPeso * r.Multiplicador
+		base = &aplicacionRegla{
+			reglaID:       &id,
+			nombre:        r.nombre,
+			puntos:        redondearPuntos(monto*puntosPorPeso, r.Redondeo) + r.BonusAdicional,
+			multiplicador: r.Multiplicador,
+		}
+		break
+	}
+	if base == nil {
+		if hayReglasBase {
+			// Ninguna regla base se cumple: la venta no genera puntos
+			return nil, nil
+		}
+		monto := 0.0
+		for _, l := range ctxEval.lineas {
+			monto += l.monto
+		}
+		base = &aplicacionRegla{
+			puntos:        int(math.Floor(monto * puntosPorPeso)),
+			multiplicador: 1,
+		}
+	}
+	aplicaciones = append(aplicaciones, *base)
+
+	// Multiplicadores y bonus
+	for i := range m.reglas {
+		r := &m.reglas[i]
+		if (r.tipo != "acumulacion" && r.tipo != "promocion") || r.PuntosPorPeso != 0 {
+			continue
+		}
+		ok, monto, err := r.cumple(ctxEval)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		extra := redondearPuntos(monto*puntosPorPeso*(r.Multiplicador-1), r.Redondeo) + r.BonusAdicional
+		if extra > 0 {
+			id := r.id
+			aplicaciones = append(aplicaciones, aplicacionRegla{
+				reglaID:       &id,
+				nombre:        r.nombre,
+				puntos:        extra,
+				multiplicador: r.Multiplicador,
+			})
+		}
+		if r.Exclusiva {
+			break
+		}
+	}
+
+	return aplicaciones, nil
+}
+
+// evaluarCanje obtiene la regla de canje de mayor prioridad que se cumple
+func (m *motorFidelizacion) evaluarCanje(ctxEval *contextoFidelizacion) (*reglaFidelizacionVigente, error) {
+	if m == nil {
+		m = &motorFidelizacion{}
+	}
+	for i := range m.reglas {
+		r := &m.reglas[i]
+		if r.tipo != "canje" {
+			continue
+		}
+		ok, _, err := r.cumple(ctxEval)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return r, nil
+		}
+	}
+	return nil, nil
+}
+
+// evaluarNivel obtiene la regla de nivel que se cumple con el nivel más alto, si supera al nivel actual
+func (m *motorFidelizacion) evaluarNivel(ctxEval *contextoFidelizacion) (*reglaFidelizacionVigente, error) {
+	if m == nil {
+		m = &motorFidelizacion{}
+	}
+	var mejor *reglaFidelizacionVigente
+	for i := range m.reglas {
+		r := &m.reglas[i]
+		if r.tipo != "nivel" || nivelesFidelizacion[r.Nivel] <= nivelesFidelizacion[ctxEval.nivel] {
+			continue
+		}
+		if mejor != nil && nivelesFidelizacion[r.Nivel] <= nivelesFidelizacion[mejor.Nivel] {
+			continue
+		}
+		ok, _, err := r.cumple(ctxEval)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			mejor = r
+		}
+	}
+	return mejor, nil
+}
+
+// registrarAplicacionesReglas actualiza total_aplicaciones y total_puntos_otorgados de las reglas aplicadas.
+// Se actualizan en orden de ID para que ventas concurrentes no se bloqueen mutuamente.
+func registrarAplicacionesReglas(ctx context.Context, tx *sql.Tx, puntosPorRegla map[uuid.UUID]int) error {
+	ids := make([]uuid.UUID, 0, len(puntosPorRegla))
+	for id := range puntosPorRegla {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+	for _, id := range ids {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE reglas_fidelizacion
+			SET total_aplicaciones = COALESCE(total_aplicaciones, 0) + 1,
+				total_puntos_otorgados = COALESCE(total_puntos_otorgados, 0) + $2
+			WHERE id = $1`,
+			id, puntosPorRegla[id],
+		)
+		if err != nil {
+			return fmt.Errorf("error actualizando estadísticas de regla de fidelización: %w", err)
+		}
+	}
+	return nil
+}
+
+// contextoFidelizacionVenta arma el contexto de evaluación de una venta prorrateando en las líneas lo pagado sin puntos
+func contextoFidelizacionVenta(ctx context.Context, tx *sql.Tx, venta *models.Venta, detalles []models.DetalleVenta, nivel string, montoBase float64) *contextoFidelizacion {
+	ctxEval := &contextoFidelizacion{
+		sucursalID: venta.SucursalID,
+		fecha:      venta.Fecha,
+		nivel:      nivel,
+		montoBase:  redondearMonto(montoBase),
+	}
+
+	proporcion := 0.0
+	if venta.Total > 0 {
+		proporcion = math.Min(montoBase/venta.Total, 1)
+	}
+	for i := range detalles {
+		ctxEval.lineas = append(ctxEval.lineas, lineaFidelizacion{
+			productoID:  detalles[i].ProductoID,
+			categoriaID: detalles[i].CategoriaProductoID,
+			monto:       redondearMonto(detalles[i].TotalItem * proporcion),
+		})
+	}
+
+	gastos := make(map[int]float64)
+	ctxEval.gasto = func(dias int) (float64, error) {
+		if g, ok := gastos[dias]; ok {
+			return g, nil
+		}
+		if venta.ClienteRUT == nil {
+			return 0, nil
+		}
+		var gasto float64
+		err := tx.QueryRowContext(ctx, `
+			SELECT COALESCE(SUM(total), 0)
+			FROM ventas
+			WHERE cliente_rut = $1 AND estado <> 'anulada'
+				AND fecha >= NOW() - make_interval(days => $2)`,
+			*venta.ClienteRUT, dias,
+		).Scan(&gasto)
+		if err != nil {
+			return 0, fmt.Errorf("error consultando gasto del cliente: %w", err)
+		}
+		gastos[dias] = gasto
+		return gasto, nil
+	}
+
+	return ctxEval
+}
+
+// redondearPuntos convierte puntos fraccionarios según el redondeo de la regla
+func redondearPuntos(puntos float64, redondeo string) int {
+	switch redondeo {
+	case "superior":
+		return int(math.Ceil(puntos - 1e-9))
+	case "cercano":
+		return int(math.Round(puntos))
+	default:
+		return int(math.Floor(puntos + 1e-9))
+	}
+}
+
+// contieneUUID indica si el ID está en la lista
+func contieneUUID(lista []uuid.UUID, id uuid.UUID) bool {
+	for _, v := range lista {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// contieneTexto indica si el texto está en la lista
+func contieneTexto(lista []string, s string) bool {
+	for _, v := range lista {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// nivelPorGasto obtiene el nivel que corresponde al gasto del cliente según las reglas de nivel con gasto_periodo.
+// Las demás condiciones dependen de una venta y no se consideran. Devuelve false si no hay reglas de nivel por gasto.
+func (m *motorFidelizacion) nivelPorGasto(gasto func(dias int) (float64, error)) (string, *uuid.UUID, bool, error) {
+	if m == nil {
+		return "", nil, false, nil
+	}
+
+	nivel := "bronce"
+	var reglaID *uuid.UUID
+	hayReglas := false
+	for i := range m.reglas {
+		r := &m.reglas[i]
+		if r.tipo != "nivel" || r.GastoPeriodo == nil {
+			continue
+		}
+		hayReglas = true
+		if nivelesFidelizacion[r.Nivel] <= nivelesFidelizacion[nivel] {
+			continue
+		}
+
+		g, err := gasto(r.GastoPeriodo.Dias)
+		if err != nil {
+			return "", nil, false, err
+		}
+		if g >= r.GastoPeriodo.MontoMinimo {
+			id := r.id
+			nivel = r.Nivel
+			reglaID = &id
+		}
+	}
+
+	return nivel, reglaID, hayReglas, nil
+}
+
+// diasPeriodoNivel ventana más larga de las reglas de nivel por gasto, o el valor por defecto si no hay
+func (m *motorFidelizacion) diasPeriodoNivel(defecto int) int {
+	dias := 0
+	if m != nil {
+		for _, r := range m.reglas {
+			if r.tipo == "nivel" && r.GastoPeriodo != nil && r.GastoPeriodo.Dias > dias {
+				dias = r.GastoPeriodo.Dias
+			}
+		}
+	}
+	if dias == 0 {
+		return defecto
+	}
+	return dias
+}
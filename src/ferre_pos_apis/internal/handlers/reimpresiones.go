@@ -0,0 +1,855 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"ferre_pos_apis/internal/config"
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/pdf"
+	"ferre_pos_apis/pkg/validator"
+)
+
+const (
+	// reimpresionesLibresPorDefecto reimpresiones por documento sin autorización si no se configura el límite
+	reimpresionesLibresPorDefecto = 1
+	// vigenciaAutorizacionReimpresionMinutos vigencia por defecto de un código de autorización de reimpresión
+	vigenciaAutorizacionReimpresionMinutos = 10
+)
+
+// ReimpresionesHandler handler para la reimpresión controlada de documentos
+type ReimpresionesHandler struct {
+	db                  *database.Database
+	logger              logger.Logger
+	validator           validator.Validator
+	metrics             *metrics.Metrics
+	reimpresionesLibres int
+}
+
+// NewReimpresionesHandler crea un nuevo handler de reimpresiones
+func NewReimpresionesHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics, cfg *config.APIConfig) *ReimpresionesHandler {
+	libres := reimpresionesLibresPorDefecto
+	if cfg != nil && cfg.Reprints.FreeReprints != nil && *cfg.Reprints.FreeReprints >= 0 {
+		libres = *cfg.Reprints.FreeReprints
+	}
+
+	return &ReimpresionesHandler{
+		db:                  db,
+		logger:              log,
+		validator:           val,
+		metrics:             met,
+		reimpresionesLibres: libres,
+	}
+}
+
+// documentoImprimible documento a reimprimir con los datos para auditarlo y regenerar su PDF
+type documentoImprimible struct {
+	sucursalID uuid.UUID
+	numero     int64
+	hash       *string
+	almacenado []byte // PDF del DTE almacenado; nil si debe regenerarse
+	venta      *models.Venta
+	despacho   *despachoImprimible
+}
+
+// despachoImprimible datos del comprobante de despacho
+type despachoImprimible struct {
+	numero          int64
+	clienteRUT      *string
+	clienteNombre   *string
+	estado          string
+	fechaProgramada *time.Time
+	fechaCompletado *time.Time
+	observaciones   *string
+	firma           []byte // Imagen PNG o JPEG de la firma de quien recibe
+	firmaNombre     *string
+	firmaRUT        *string
+	original        bool // Comprobante emitido al entregar, sin marca de copia
+}
+
+// lineaImprimible línea de un documento reimpreso
+type lineaImprimible struct {
+	codigo      string
+	descripcion string
+	cantidad    float64
+	unidad      *string
+	precio      float64
+	total       float64
+	despachada  float64
+}
+
+// Reimprimir entrega una copia del documento registrando la auditoría. Superado el límite de
+// reimpresiones libres se exige un supervisor o un código de autorización de un solo uso.
+func (h *ReimpresionesHandler) Reimprimir(c *gin.Context) {
+	inicio := time.Now()
+
+	var req models.ReimpresionRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	reimpresion := &models.ReimpresionDocumento{
+		ID:            uuid.New(),
+		DocumentoID:   req.DocumentoID,
+		UsuarioID:     usuarioID,
+		TipoDocumento: req.TipoDocumento,
+		Motivo:        req.Motivo,
+		Dispositivo:   req.Dispositivo,
+	}
+	if ip := c.ClientIP(); ip != "" {
+		reimpresion.IPOrigen = &ip
+	}
+	if reimpresion.Dispositivo == nil {
+		if agente := c.Request.UserAgent(); agente != "" {
+			reimpresion.Dispositivo = &agente
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var contenido []byte
+	var numero int64
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		// El documento queda bloqueado para contar las reimpresiones previas sin carreras
+		doc, err := cargarDocumentoImprimible(ctx, tx, req.TipoDocumento, req.DocumentoID, true)
+		if err != nil {
+			return err
+		}
+		if err := validarSucursalDocumento(doc, getUserSucursalID(c), getUserRole(c)); err != nil {
+			return err
+		}
+		reimpresion.SucursalID = doc.sucursalID
+		reimpresion.HashDocumentoOriginal = doc.hash
+		numero = doc.numero
+
+		err = tx.QueryRowContext(ctx, `
+			SELECT COUNT(*)
+			FROM reimpresiones_documentos
+			WHERE documento_id = $1 AND tipo_documento = $2`,
+			req.DocumentoID, req.TipoDocumento,
+		).Scan(&reimpresion.ReimpresionesPrevias)
+		if err != nil {
+			return fmt.Errorf("error contando reimpresiones: %w", err)
+		}
+
+		requiereAutorizacion := reimpresion.ReimpresionesPrevias >= h.reimpresionesLibres
+		rol := getUserRole(c)
+		if requiereAutorizacion && (rol == string(models.RolSupervisor) || rol == string(models.RolAdmin)) {
+			reimpresion.AutorizadoPor = &usuarioID
+			requiereAutorizacion = false
+		}
+		if requiereAutorizacion && (req.CodigoAutorizacion == nil || strings.TrimSpace(*req.CodigoAutorizacion) == "") {
+			return &apiError{
+				status:  http.StatusForbidden,
+				code:    "AUTORIZACION_REIMPRESION_REQUERIDA",
+				message: "El documento superó las reimpresiones libres y requiere autorización de supervisor",
+				details: models.JSONB{
+					"reimpresiones_previas": reimpresion.ReimpresionesPrevias,
+					"reimpresiones_libres":  h.reimpresionesLibres,
+				},
+			}
+		}
+
+		origen := "almacenado"
+		contenido = doc.almacenado
+		if contenido == nil {
+			origen = "regenerado"
+			leyenda := fmt.Sprintf("COPIA - Reimpresión N° %d del %s", reimpresion.ReimpresionesPrevias+1,
+				time.Now().Format("02-01-2006 15:04"))
+			contenido, err = renderizarDocumentoImprimible(ctx, tx, req.DocumentoID, doc, leyenda)
+			if err != nil {
+				return err
+			}
+		}
+		tamano := len(contenido)
+		tiempo := int(time.Since(inicio).Milliseconds())
+		reimpresion.TamanoArchivoBytes = &tamano
+		reimpresion.TiempoProcesamiento = &tiempo
+		reimpresion.DatosAdicionales = models.JSONB{"origen_pdf": origen}
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO reimpresiones_documentos (
+				id, documento_id, usuario_id, sucursal_id, tipo_documento, motivo, ip_origen,
+				dispositivo, reimpresiones_previas, autorizado_por, datos_adicionales,
+				hash_documento_original, tiempo_procesamiento_ms, tamaño_archivo_bytes
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+			RETURNING fecha`,
+			reimpresion.ID, reimpresion.DocumentoID, reimpresion.UsuarioID, reimpresion.SucursalID,
+			reimpresion.TipoDocumento, reimpresion.Motivo, reimpresion.IPOrigen, reimpresion.Dispositivo,
+			reimpresion.ReimpresionesPrevias, reimpresion.AutorizadoPor, reimpresion.DatosAdicionales,
+			reimpresion.HashDocumentoOriginal, reimpresion.TiempoProcesamiento, reimpresion.TamanoArchivoBytes,
+		).Scan(&reimpresion.Fecha)
+		if err != nil {
+			return fmt.Errorf("error registrando reimpresión: %w", err)
+		}
+
+		if !requiereAutorizacion {
+			return nil
+		}
+		return consumirAutorizacionReimpresion(ctx, tx, reimpresion, strings.TrimSpace(*req.CodigoAutorizacion))
+	})
+	if err != nil {
+		h.responderError(c, err, "Error reimprimiendo documento")
+		return
+	}
+
+	h.logger.WithField("reimpresion_id", reimpresion.ID).
+		WithField("documento_id", reimpresion.DocumentoID).
+		WithField("tipo_documento", reimpresion.TipoDocumento).
+		WithField("reimpresiones_previas", reimpresion.ReimpresionesPrevias).
+		WithField("autorizado_por", reimpresion.AutorizadoPor).
+		WithField("ip_origen", reimpresion.IPOrigen).
+		Info("Documento reimpreso")
+
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="copia-%s-%d.pdf"`,
+		strings.ReplaceAll(reimpresion.TipoDocumento, "_", "-"), numero))
+	c.Header("X-Reimpresion-ID", reimpresion.ID.String())
+	c.Data(http.StatusOK, "application/pdf", contenido)
+}
+
+// List lista la auditoría de reimpresiones por documento, sucursal o usuario
+func (h *ReimpresionesHandler) List(c *gin.Context) {
+	query := `
+		SELECT id, documento_id, usuario_id, sucursal_id, tipo_documento, motivo, fecha,
+			host(ip_origen), dispositivo, reimpresiones_previas, autorizado_por, datos_adicionales,
+			hash_documento_original, tiempo_procesamiento_ms, tamaño_archivo_bytes
+		FROM reimpresiones_documentos
+		WHERE 1 = 1`
+	var args []interface{}
+	if documentoID := c.Query("documento_id"); documentoID != "" {
+		args = append(args, documentoID)
+		query += ` AND documento_id = $` + strconv.Itoa(len(args))
+	} else if sucursalID := getSucursalID(c); sucursalID != "" {
+		args = append(args, sucursalID)
+		query += ` AND sucursal_id = $` + strconv.Itoa(len(args))
+	}
+	if tipo := c.Query("tipo_documento"); tipo != "" {
+		args = append(args, tipo)
+		query += ` AND tipo_documento = $` + strconv.Itoa(len(args))
+	}
+	if usuarioID := c.Query("usuario_id"); usuarioID != "" {
+		args = append(args, usuarioID)
+		query += ` AND usuario_id = $` + strconv.Itoa(len(args))
+	}
+	query += ` ORDER BY fecha DESC LIMIT 200`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		h.responderError(c, err, "Error listando reimpresiones")
+		return
+	}
+
+	reimpresiones := []models.ReimpresionDocumento{}
+	err = database.ScanRows(rows, func() error {
+		var r models.ReimpresionDocumento
+		if err := rows.Scan(&r.ID, &r.DocumentoID, &r.UsuarioID, &r.SucursalID, &r.TipoDocumento,
+			&r.Motivo, &r.Fecha, &r.IPOrigen, &r.Dispositivo, &r.ReimpresionesPrevias, &r.AutorizadoPor,
+			&r.DatosAdicionales, &r.HashDocumentoOriginal, &r.TiempoProcesamiento, &r.TamanoArchivoBytes); err != nil {
+			return err
+		}
+		reimpresiones = append(reimpresiones, r)
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error listando reimpresiones")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      reimpresiones,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// CrearAutorizacion emite un código de un solo uso para reimprimir un documento específico
+func (h *ReimpresionesHandler) CrearAutorizacion(c *gin.Context) {
+	var req models.AutorizacionReimpresionRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	supervisorID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	autorizacion := models.AutorizacionReimpresion{
+		ID:            uuid.New(),
+		SupervisorID:  supervisorID,
+		TipoDocumento: req.TipoDocumento,
+		DocumentoID:   req.DocumentoID,
+		Motivo:        req.Motivo,
+	}
+	vigencia := req.VigenciaMinutos
+	if vigencia == 0 {
+		vigencia = vigenciaAutorizacionReimpresionMinutos
+	}
+
+	var codigo string
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		doc, err := cargarDocumentoImprimible(ctx, tx, req.TipoDocumento, req.DocumentoID, false)
+		if err != nil {
+			return err
+		}
+		if err := validarSucursalDocumento(doc, getUserSucursalID(c), getUserRole(c)); err != nil {
+			return err
+		}
+		autorizacion.SucursalID = doc.sucursalID
+
+		for intento := 0; intento < intentosCodigoAutorizacion; intento++ {
+			codigo, err = generarCodigoAutorizacion()
+			if err != nil {
+				return err
+			}
+
+			err = tx.QueryRowContext(ctx, `
+				INSERT INTO autorizaciones_reimpresion (
+					id, codigo_hash, supervisor_id, sucursal_id, tipo_documento, documento_id,
+					motivo, fecha_expiracion
+				) VALUES ($1, $2, $3, $4, $5, $6, $7, NOW() + make_interval(mins => $8))
+				ON CONFLICT (codigo_hash) WHERE usada = false DO NOTHING
+				RETURNING fecha_creacion, fecha_expiracion`,
+				autorizacion.ID, hashCodigoAutorizacion(codigo), autorizacion.SupervisorID,
+				autorizacion.SucursalID, autorizacion.TipoDocumento, autorizacion.DocumentoID,
+				autorizacion.Motivo, vigencia,
+			).Scan(&autorizacion.FechaCreacion, &autorizacion.FechaExpiracion)
+			if err != sql.ErrNoRows {
+				break
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("error emitiendo autorización de reimpresión: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error emitiendo autorización de reimpresión")
+		return
+	}
+
+	h.logger.WithField("autorizacion_id", autorizacion.ID).
+		WithField("documento_id", autorizacion.DocumentoID).
+		WithField("supervisor_id", supervisorID).
+		Info("Autorización de reimpresión emitida")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"autorizacion": autorizacion,
+			"codigo":       codigo,
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Métodos auxiliares
+
+// responderError responde errores de negocio o errores internos
+func (h *ReimpresionesHandler) responderError(c *gin.Context, err error, mensaje string) {
+	responderError(c, h.logger, err, "REIMPRESION_ERROR", mensaje)
+}
+
+// validarSucursalDocumento restringe la reimpresión a documentos de la sucursal del usuario, salvo admin
+func validarSucursalDocumento(doc *documentoImprimible, sucursalUsuario, rol string) error {
+	if rol != string(models.RolAdmin) && doc.sucursalID.String() != sucursalUsuario {
+		return &apiError{
+			status:  http.StatusForbidden,
+			code:    "SUCURSAL_NO_AUTORIZADA",
+			message: "Solo puede reimprimir documentos de su sucursal",
+		}
+	}
+	return nil
+}
+
+// consumirAutorizacionReimpresion marca como usado el código emitido para el documento
+func consumirAutorizacionReimpresion(ctx context.Context, tx *sql.Tx, reimpresion *models.ReimpresionDocumento, codigo string) error {
+	var autorizacionID, supervisorID uuid.UUID
+	err := tx.QueryRowContext(ctx, `
+		UPDATE autorizaciones_reimpresion
+		SET usada = true, reimpresion_id = $4, fecha_uso = NOW()
+		WHERE codigo_hash = $1 AND documento_id = $2 AND tipo_documento = $3
+			AND usada = false AND fecha_expiracion > NOW()
+		RETURNING id, supervisor_id`,
+		hashCodigoAutorizacion(codigo), reimpresion.DocumentoID, reimpresion.TipoDocumento, reimpresion.ID,
+	).Scan(&autorizacionID, &supervisorID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &apiError{
+				status:  http.StatusForbidden,
+				code:    "AUTORIZACION_REIMPRESION_INVALIDA",
+				message: "Código de autorización de reimpresión inválido, usado o vencido",
+			}
+		}
+		return fmt.Errorf("error validando autorización de reimpresión: %w", err)
+	}
+
+	reimpresion.AutorizadoPor = &supervisorID
+	reimpresion.DatosAdicionales["autorizacion_id"] = autorizacionID
+	_, err = tx.ExecContext(ctx, `
+		UPDATE reimpresiones_documentos
+		SET autorizado_por = $2, datos_adicionales = $3
+		WHERE id = $1`,
+		reimpresion.ID, supervisorID, reimpresion.DatosAdicionales,
+	)
+	if err != nil {
+		return fmt.Errorf("error registrando autorización de reimpresión: %w", err)
+	}
+
+	return nil
+}
+
+// cargarDocumentoImprimible obtiene la venta o despacho a reimprimir y, si existe, el PDF de su DTE
+func cargarDocumentoImprimible(ctx context.Context, tx *sql.Tx, tipoDocumento string, documentoID uuid.UUID, bloquear bool) (*documentoImprimible, error) {
+	bloqueo := ""
+	if bloquear {
+		bloqueo = ` FOR UPDATE`
+	}
+
+	if tipoDocumento == "comprobante_despacho" {
+		doc := &documentoImprimible{despacho: &despachoImprimible{}}
+		d := doc.despacho
+		err := tx.QueryRowContext(ctx, `
+			SELECT numero_despacho, sucursal_id, cliente_rut, cliente_nombre, estado,
+				fecha_programada, fecha_completado, observaciones, firma_imagen, firma_nombre, firma_rut
+			FROM despachos
+			WHERE id = $1`+bloqueo,
+			documentoID,
+		).Scan(&d.numero, &doc.sucursalID, &d.clienteRUT, &d.clienteNombre, &d.estado,
+			&d.fechaProgramada, &d.fechaCompletado, &d.observaciones, &d.firma, &d.firmaNombre, &d.firmaRUT)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, &apiError{
+					status:  http.StatusNotFound,
+					code:    "DESPACHO_NOT_FOUND",
+					message: "Despacho no encontrado",
+				}
+			}
+			return nil, fmt.Errorf("error consultando despacho: %w", err)
+		}
+		doc.numero = d.numero
+		return doc, nil
+	}
+
+	doc := &documentoImprimible{venta: &models.Venta{ID: documentoID}}
+	v := doc.venta
+	err := tx.QueryRowContext(ctx, `
+		SELECT numero_venta, sucursal_id, tipo_documento, cliente_rut, cliente_nombre, subtotal,
+			descuento_total, impuesto_total, total, estado, fecha, dte_id, hash_integridad
+		FROM ventas
+		WHERE id = $1`+bloqueo,
+		documentoID,
+	).Scan(&v.NumeroVenta, &doc.sucursalID, &v.TipoDocumento, &v.ClienteRUT, &v.ClienteNombre,
+		&v.Subtotal, &v.DescuentoTotal, &v.ImpuestoTotal, &v.Total, &v.Estado, &v.Fecha, &v.DTEID,
+		&v.HashIntegridad)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &apiError{
+				status:  http.StatusNotFound,
+				code:    "VENTA_NOT_FOUND",
+				message: "Venta no encontrada",
+			}
+		}
+		return nil, fmt.Errorf("error consultando venta: %w", err)
+	}
+	doc.numero = v.NumeroVenta
+	doc.hash = v.HashIntegridad
+
+	if v.TipoDocumento != tipoDocumento {
+		return nil, &apiError{
+			status:  http.StatusBadRequest,
+			code:    "TIPO_DOCUMENTO_NO_COINCIDE",
+			message: fmt.Sprintf("La venta se emitió como %s", v.TipoDocumento),
+			details: models.JSONB{"venta_id": v.ID, "tipo_documento": v.TipoDocumento},
+		}
+	}
+	if v.Estado == "anulada" {
+		return nil, &apiError{
+			status:  http.StatusConflict,
+			code:    "VENTA_ANULADA",
+			message: "No se pueden reimprimir documentos de ventas anuladas",
+			details: models.JSONB{"venta_id": v.ID},
+		}
+	}
+
+	if v.DTEID != nil {
+		var almacenado []byte
+		err := tx.QueryRowContext(ctx,
+			`SELECT pdf_documento FROM documentos_dte WHERE id = $1`, *v.DTEID,
+		).Scan(&almacenado)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("error consultando DTE de la venta: %w", err)
+		}
+		if len(almacenado) > 0 {
+			sum := sha256.Sum256(almacenado)
+			hash := hex.EncodeToString(sum[:])
+			doc.almacenado = almacenado
+			doc.hash = &hash
+		}
+	}
+
+	return doc, nil
+}
+
+// renderizarDocumentoImprimible regenera el PDF del documento con la leyenda de copia
+func renderizarDocumentoImprimible(ctx context.Context, tx *sql.Tx, documentoID uuid.UUID, doc *documentoImprimible, leyenda string) ([]byte, error) {
+	var sucursal models.Sucursal
+	err := tx.QueryRowContext(ctx,
+		`SELECT nombre, direccion, comuna, telefono, email FROM sucursales WHERE id = $1`,
+		doc.sucursalID,
+	).Scan(&sucursal.Nombre, &sucursal.Direccion, &sucursal.Comuna, &sucursal.Telefono, &sucursal.Email)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando sucursal del documento: %w", err)
+	}
+
+	var lineas []lineaImprimible
+	if doc.despacho != nil {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT p.codigo_interno, p.descripcion, dd.cantidad_solicitada, COALESCE(dd.cantidad_despachada, 0)
+			FROM detalle_despacho dd
+			JOIN productos p ON p.id = dd.producto_id
+			WHERE dd.despacho_id = $1
+			ORDER BY dd.orden_picking NULLS LAST, p.descripcion`,
+			documentoID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error consultando detalle de despacho: %w", err)
+		}
+		err = database.ScanRows(rows, func() error {
+			var l lineaImprimible
+			if err := rows.Scan(&l.codigo, &l.descripcion, &l.cantidad, &l.despachada); err != nil {
+				return err
+			}
+			lineas = append(lineas, l)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error leyendo detalle de despacho: %w", err)
+		}
+		return generarPDFDespacho(doc.despacho, &sucursal, lineas, leyenda), nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT p.codigo_interno, p.descripcion, dv.cantidad, dv.unidad_medida, dv.precio_final, dv.total_item
+		FROM detalle_ventas dv
+		JOIN productos p ON p.id = dv.producto_id
+		WHERE dv.venta_id = $1
+		ORDER BY p.descripcion`,
+		documentoID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando detalle de venta: %w", err)
+	}
+	err = database.ScanRows(rows, func() error {
+		var l lineaImprimible
+		if err := rows.Scan(&l.codigo, &l.descripcion, &l.cantidad, &l.unidad, &l.precio, &l.total); err != nil {
+			return err
+		}
+		lineas = append(lineas, l)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo detalle de venta: %w", err)
+	}
+
+	rows, err = tx.QueryContext(ctx,
+		`SELECT medio_pago, monto FROM medios_pago_venta WHERE venta_id = $1 ORDER BY fecha_procesamiento`,
+		documentoID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando medios de pago: %w", err)
+	}
+	var mediosPago []models.MedioPagoVenta
+	err = database.ScanRows(rows, func() error {
+		var mp models.MedioPagoVenta
+		if err := rows.Scan(&mp.MedioPago, &mp.Monto); err != nil {
+			return err
+		}
+		mediosPago = append(mediosPago, mp)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo medios de pago: %w", err)
+	}
+
+	titulo := fmt.Sprintf("%s N° %d", strings.ToUpper(doc.venta.TipoDocumento), doc.venta.NumeroVenta)
+	if doc.venta.DTEID != nil {
+		var folio int64
+		err := tx.QueryRowContext(ctx,
+			`SELECT folio FROM documentos_dte WHERE id = $1`, *doc.venta.DTEID,
+		).Scan(&folio)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("error consultando folio del DTE: %w", err)
+		}
+		if folio > 0 {
+			titulo = fmt.Sprintf("%s ELECTRÓNICA N° %d", strings.ToUpper(doc.venta.TipoDocumento), folio)
+		}
+	}
+
+	return generarPDFVenta(doc.venta, &sucursal, titulo, lineas, mediosPago, leyenda), nil
+}
+
+// encabezadoPDFCopia dibuja la sucursal, el título del documento y la marca de copia; devuelve la altura siguiente
+func encabezadoPDFCopia(doc *pdf.Documento, sucursal *models.Sucursal, titulo string, margen float64) float64 {
+	return encabezadoPDF(doc, sucursal, titulo, "COPIA", margen)
+}
+
+// encabezadoPDF dibuja la sucursal, el título del documento y la marca indicada, si hay; devuelve la altura siguiente
+func encabezadoPDF(doc *pdf.Documento, sucursal *models.Sucursal, titulo, marca string, margen float64) float64 {
+	derecha := pdf.AnchoA4 - margen
+	y := pdf.AltoA4 - margen
+
+	doc.Texto(margen, y, 16, true, sucursal.Nombre)
+	doc.TextoDerecha(derecha, y, 14, true, titulo)
+	y -= 30
+	if marca != "" {
+		doc.TextoDerecha(derecha, y, 28, true, marca)
+	}
+	for _, dato := range []*string{sucursal.Direccion, sucursal.Comuna, sucursal.Telefono, sucursal.Email} {
+		if dato != nil && *dato != "" {
+			doc.Texto(margen, y, 9, false, *dato)
+			y -= 14
+		}
+	}
+	return y - 14
+}
+
+// generarPDFVenta regenera la boleta o factura marcada como copia
+func generarPDFVenta(venta *models.Venta, sucursal *models.Sucursal, titulo string, lineas []lineaImprimible, mediosPago []models.MedioPagoVenta, leyenda string) []byte {
+	const (
+		margen     = 40.0
+		derecha    = pdf.AnchoA4 - margen
+		altoLinea  = 14.0
+		limiteInf  = 110.0
+		colCodigo  = margen
+		colDesc    = margen + 75
+		colCant    = 400.0
+		colPrecio  = 470.0
+		tamanoBase = 9.0
+	)
+
+	doc := pdf.New()
+	y := encabezadoPDFCopia(doc, sucursal, titulo, margen)
+
+	encabezado := func() {
+		doc.Texto(colCodigo, y, tamanoBase, true, "Código")
+		doc.Texto(colDesc, y, tamanoBase, true, "Descripción")
+		doc.TextoDerecha(colCant, y, tamanoBase, true, "Cant.")
+		doc.TextoDerecha(colPrecio, y, tamanoBase, true, "Precio")
+		doc.TextoDerecha(derecha, y, tamanoBase, true, "Total")
+		y -= 4
+		doc.Linea(margen, y, derecha, y)
+		y -= altoLinea
+	}
+
+	doc.Texto(margen, y, tamanoBase, false, fmt.Sprintf("Venta N° %d", venta.NumeroVenta))
+	doc.TextoDerecha(derecha, y, tamanoBase, false, "Fecha: "+venta.Fecha.Format("02-01-2006 15:04"))
+	y -= altoLinea
+	if venta.ClienteRUT != nil {
+		doc.Texto(margen, y, tamanoBase, false, "RUT: "+*venta.ClienteRUT)
+		y -= altoLinea
+	}
+	if venta.ClienteNombre != nil {
+		doc.Texto(margen, y, tamanoBase, false, "Cliente: "+*venta.ClienteNombre)
+		y -= altoLinea
+	}
+
+	y -= altoLinea
+	encabezado()
+	for _, l := range lineas {
+		if y < limiteInf {
+			doc.NuevaPagina()
+			y = pdf.AltoA4 - margen
+			encabezado()
+		}
+
+		descripcion := []rune(l.descripcion)
+		if len(descripcion) > 55 {
+			descripcion = append(descripcion[:52], '.', '.', '.')
+		}
+		cantidad := strconv.FormatFloat(l.cantidad, 'f', -1, 64)
+		if l.unidad != nil {
+			cantidad += " " + *l.unidad
+		}
+		doc.Texto(colCodigo, y, tamanoBase, false, l.codigo)
+		doc.Texto(colDesc, y, tamanoBase, false, string(descripcion))
+		doc.TextoDerecha(colCant, y, tamanoBase, false, cantidad)
+		doc.TextoDerecha(colPrecio, y, tamanoBase, false, formatearPesos(l.precio))
+		doc.TextoDerecha(derecha, y, tamanoBase, false, formatearPesos(l.total))
+		y -= altoLinea
+	}
+
+	if y < limiteInf {
+		doc.NuevaPagina()
+		y = pdf.AltoA4 - margen
+	}
+	doc.Linea(margen, y+4, derecha, y+4)
+	y -= altoLinea
+	for _, total := range []struct {
+		etiqueta string
+		monto    float64
+	}{
+		{"Neto", venta.Subtotal - venta.DescuentoTotal},
+		{"IVA 19%", venta.ImpuestoTotal},
+	} {
+		doc.TextoDerecha(colPrecio, y, tamanoBase, false, total.etiqueta)
+		doc.TextoDerecha(derecha, y, tamanoBase, false, formatearPesos(total.monto))
+		y -= altoLinea
+	}
+	doc.TextoDerecha(colPrecio, y, 11, true, "TOTAL")
+	doc.TextoDerecha(derecha, y, 11, true, formatearPesos(venta.Total))
+	y -= altoLinea * 2
+
+	for _, mp := range mediosPago {
+		doc.Texto(margen, y, tamanoBase, false, strings.ReplaceAll(mp.MedioPago, "_", " ")+": "+formatearPesos(mp.Monto))
+		y -= altoLinea
+	}
+
+	doc.Texto(margen, margen, 8, true, leyenda)
+
+	return doc.Bytes()
+}
+
+// generarPDFDespacho genera el comprobante de despacho, marcado como copia salvo el emitido al entregar
+func generarPDFDespacho(despacho *despachoImprimible, sucursal *models.Sucursal, lineas []lineaImprimible, leyenda string) []byte {
+	const (
+		margen       = 40.0
+		derecha      = pdf.AnchoA4 - margen
+		altoLinea    = 14.0
+		limiteInf    = 110.0
+		colCodigo    = margen
+		colDesc      = margen + 75
+		colSolicitud = 450.0
+		tamanoBase   = 9.0
+		anchoFirma   = 200.0
+		altoFirma    = 50.0
+	)
+
+	// Una firma ilegible no impide emitir el comprobante; queda la línea para firmar a mano
+	var firma image.Image
+	if len(despacho.firma) > 0 {
+		if img, _, err := image.Decode(bytes.NewReader(despacho.firma)); err == nil {
+			firma = img
+		}
+	}
+
+	marca := "COPIA"
+	if despacho.original {
+		marca = ""
+	}
+
+	doc := pdf.New()
+	y := encabezadoPDF(doc, sucursal, fmt.Sprintf("DESPACHO N° %d", despacho.numero), marca, margen)
+
+	encabezado := func() {
+		doc.Texto(colCodigo, y, tamanoBase, true, "Código")
+		doc.Texto(colDesc, y, tamanoBase, true, "Descripción")
+		doc.TextoDerecha(colSolicitud, y, tamanoBase, true, "Solicitado")
+		doc.TextoDerecha(derecha, y, tamanoBase, true, "Entregado")
+		y -= 4
+		doc.Linea(margen, y, derecha, y)
+		y -= altoLinea
+	}
+
+	doc.Texto(margen, y, tamanoBase, false, "Estado: "+despacho.estado)
+	if despacho.fechaCompletado != nil {
+		doc.TextoDerecha(derecha, y, tamanoBase, false, "Entregado: "+despacho.fechaCompletado.Format("02-01-2006 15:04"))
+	} else if despacho.fechaProgramada != nil {
+		doc.TextoDerecha(derecha, y, tamanoBase, false, "Programado: "+despacho.fechaProgramada.Format("02-01-2006 15:04"))
+	}
+	y -= altoLinea
+	if despacho.clienteRUT != nil {
+		doc.Texto(margen, y, tamanoBase, false, "RUT: "+*despacho.clienteRUT)
+		y -= altoLinea
+	}
+	if despacho.clienteNombre != nil {
+		doc.Texto(margen, y, tamanoBase, false, "Cliente: "+*despacho.clienteNombre)
+		y -= altoLinea
+	}
+
+	y -= altoLinea
+	encabezado()
+	for _, l := range lineas {
+		if y < limiteInf {
+			doc.NuevaPagina()
+			y = pdf.AltoA4 - margen
+			encabezado()
+		}
+
+		descripcion := []rune(l.descripcion)
+		if len(descripcion) > 60 {
+			descripcion = append(descripcion[:57], '.', '.', '.')
+		}
+		doc.Texto(colCodigo, y, tamanoBase, false, l.codigo)
+		doc.Texto(colDesc, y, tamanoBase, false, string(descripcion))
+		doc.TextoDerecha(colSolicitud, y, tamanoBase, false, strconv.FormatFloat(l.cantidad, 'f', -1, 64))
+		doc.TextoDerecha(derecha, y, tamanoBase, false, strconv.FormatFloat(l.despachada, 'f', -1, 64))
+		y -= altoLinea
+	}
+
+	reserva := altoLinea * 2
+	if firma != nil {
+		reserva += altoFirma
+	}
+	if y < limiteInf+reserva {
+		doc.NuevaPagina()
+		y = pdf.AltoA4 - margen
+	}
+	if despacho.observaciones != nil && *despacho.observaciones != "" {
+		y -= altoLinea
+		doc.Texto(margen, y, tamanoBase, false, "Observaciones: "+*despacho.observaciones)
+	}
+
+	y = limiteInf - altoLinea
+	if firma != nil {
+		// La firma se ajusta al recuadro conservando su proporción
+		limites := firma.Bounds()
+		ancho, alto := anchoFirma, anchoFirma*float64(limites.Dy())/float64(limites.Dx())
+		if alto > altoFirma {
+			ancho, alto = altoFirma*float64(limites.Dx())/float64(limites.Dy()), altoFirma
+		}
+		doc.Imagen(derecha-anchoFirma+(anchoFirma-ancho)/2, y+2, ancho, alto, firma)
+	}
+	doc.Linea(derecha-anchoFirma, y, derecha, y)
+	doc.TextoDerecha(derecha, y-altoLinea, tamanoBase, false, "Firma y RUT de quien recibe")
+	if despacho.firmaNombre != nil {
+		recibe := "Recibido por: " + *despacho.firmaNombre
+		if despacho.firmaRUT != nil && *despacho.firmaRUT != "" {
+			recibe += " - RUT " + *despacho.firmaRUT
+		}
+		doc.Texto(margen, y, tamanoBase, false, recibe)
+	}
+
+	doc.Texto(margen, margen, 8, true, leyenda)
+
+	return doc.Bytes()
+}
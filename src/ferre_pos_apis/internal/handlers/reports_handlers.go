@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	
 	"ferre_pos_apis/internal/database"
 	"ferre_pos_apis/internal/logger"
@@ -511,8 +515,158 @@ func (h *InventoryReportsHandler) GetStockAdjustments(c *gin.Context) {
 	c.JSON(http.StatusOK, models.APIResponse{Success: true, Data: []interface{}{}, RequestID: getRequestID(c), Timestamp: time.Now()})
 }
 
+// GetStockTransfers reporta las transferencias entre sucursales con sus totales y discrepancias de recepción
 func (h *InventoryReportsHandler) GetStockTransfers(c *gin.Context) {
-	c.JSON(http.StatusOK, models.APIResponse{Success: true, Data: []interface{}{}, RequestID: getRequestID(c), Timestamp: time.Now()})
+	ahora := time.Now()
+	hasta := time.Date(ahora.Year(), ahora.Month(), ahora.Day(), 0, 0, 0, 0, ahora.Location())
+	desde := hasta.AddDate(0, 0, -30)
+	for param, fecha := range map[string]*time.Time{"desde": &desde, "hasta": &hasta} {
+		valor := c.Query(param)
+		if valor == "" {
+			continue
+		}
+
+		t, err := time.ParseInLocation("2006-01-02", valor, ahora.Location())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error: &models.APIError{
+					Code:    "INVALID_DATE",
+					Message: fmt.Sprintf("Fecha %s inválida, formato esperado AAAA-MM-DD", param),
+				},
+				RequestID: getRequestID(c),
+				Timestamp: time.Now(),
+			})
+			return
+		}
+		*fecha = t
+	}
+
+	// hasta incluye el día completo
+	filtros := []string{"t.fecha_solicitud >= $1", "t.fecha_solicitud < $2"}
+	args := []interface{}{desde, hasta.AddDate(0, 0, 1)}
+	if sucursalID := c.Query("sucursal_id"); sucursalID != "" {
+		if _, err := uuid.Parse(sucursalID); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error: &models.APIError{
+					Code:    "INVALID_SUCURSAL_ID",
+					Message: "ID de sucursal inválido",
+				},
+				RequestID: getRequestID(c),
+				Timestamp: time.Now(),
+			})
+			return
+		}
+		args = append(args, sucursalID)
+		filtros = append(filtros, fmt.Sprintf("(t.sucursal_origen_id = $%d OR t.sucursal_destino_id = $%d)", len(args), len(args)))
+	}
+	if estado := c.Query("estado"); estado != "" {
+		args = append(args, estado)
+		filtros = append(filtros, fmt.Sprintf("t.estado = $%d", len(args)))
+	}
+	if c.Query("con_diferencias") == "true" {
+		filtros = append(filtros, "EXISTS (SELECT 1 FROM detalle_transferencias_stock dx WHERE dx.transferencia_id = t.id AND dx.diferencia <> 0)")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT t.id, t.numero_transferencia, t.sucursal_origen_id, so.nombre, t.sucursal_destino_id, sd.nombre,
+			t.estado, t.fecha_solicitud, t.fecha_despacho, t.fecha_recepcion, COUNT(d.id),
+			COALESCE(SUM(d.cantidad_solicitada), 0), COALESCE(SUM(d.cantidad_despachada), 0),
+			COALESCE(SUM(d.cantidad_recibida), 0),
+			COALESCE(SUM(-d.diferencia) FILTER (WHERE d.diferencia < 0), 0),
+			COALESCE(SUM(d.diferencia) FILTER (WHERE d.diferencia > 0), 0),
+			COALESCE(SUM(d.cantidad_despachada * d.costo_unitario), 0),
+			COALESCE(SUM(d.diferencia * d.costo_unitario), 0),
+			COUNT(*) FILTER (WHERE d.diferencia <> 0)
+		FROM transferencias_stock t
+		JOIN sucursales so ON so.id = t.sucursal_origen_id
+		JOIN sucursales sd ON sd.id = t.sucursal_destino_id
+		LEFT JOIN detalle_transferencias_stock d ON d.transferencia_id = t.id
+		WHERE `+strings.Join(filtros, " AND ")+`
+		GROUP BY t.id, so.nombre, sd.nombre
+		ORDER BY t.fecha_solicitud DESC`,
+		args...,
+	)
+	if err == nil {
+		var transferencias []models.ResumenTransferencia
+		err = database.ScanRows(rows, func() error {
+			var r models.ResumenTransferencia
+			if err := rows.Scan(&r.TransferenciaID, &r.NumeroTransferencia, &r.SucursalOrigenID, &r.SucursalOrigen,
+				&r.SucursalDestinoID, &r.SucursalDestino, &r.Estado, &r.FechaSolicitud, &r.FechaDespacho,
+				&r.FechaRecepcion, &r.Productos, &r.CantidadSolicitada, &r.CantidadDespachada,
+				&r.CantidadRecibida, &r.Faltante, &r.Sobrante, &r.ValorDespachado, &r.ValorDiferencia,
+				&r.LineasConDiferencia); err != nil {
+				return err
+			}
+			r.ValorDespachado = redondearMonto(r.ValorDespachado)
+			r.ValorDiferencia = redondearMonto(r.ValorDiferencia)
+			transferencias = append(transferencias, r)
+			return nil
+		})
+		if err == nil {
+			h.responderReporteTransferencias(c, desde, hasta, transferencias)
+			return
+		}
+	}
+
+	h.logger.WithError(err).Error("Error generando reporte de transferencias")
+	c.JSON(http.StatusInternalServerError, models.APIResponse{
+		Success: false,
+		Error: &models.APIError{
+			Code:    "REPORT_ERROR",
+			Message: "Error generando reporte de transferencias",
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// responderReporteTransferencias totaliza las transferencias por estado y responde el reporte
+func (h *InventoryReportsHandler) responderReporteTransferencias(c *gin.Context, desde, hasta time.Time, transferencias []models.ResumenTransferencia) {
+	porEstado := map[string]int{}
+	var despachado, recibido, faltante, sobrante, valorDespachado, valorDiferencia float64
+	conDiferencias := 0
+	for _, t := range transferencias {
+		porEstado[t.Estado]++
+		despachado += t.CantidadDespachada
+		recibido += t.CantidadRecibida
+		faltante += t.Faltante
+		sobrante += t.Sobrante
+		valorDespachado += t.ValorDespachado
+		valorDiferencia += t.ValorDiferencia
+		if t.LineasConDiferencia > 0 {
+			conDiferencias++
+		}
+	}
+	if transferencias == nil {
+		transferencias = []models.ResumenTransferencia{}
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"desde":          desde,
+			"hasta":          hasta,
+			"transferencias": transferencias,
+			"totales": gin.H{
+				"transferencias":      len(transferencias),
+				"por_estado":          porEstado,
+				"con_diferencias":     conDiferencias,
+				"cantidad_despachada": redondearCantidad(despachado),
+				"cantidad_recibida":   redondearCantidad(recibido),
+				"faltante":            redondearCantidad(faltante),
+				"sobrante":            redondearCantidad(sobrante),
+				"valor_despachado":    redondearMonto(valorDespachado),
+				"valor_diferencia":    redondearMonto(valorDiferencia),
+			},
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
 }
 
 func (h *InventoryReportsHandler) GetInventoryTurnover(c *gin.Context) {
@@ -587,12 +741,151 @@ func (h *FinancialReportsHandler) GetPaymentReports(c *gin.Context) {
 	c.JSON(http.StatusOK, models.APIResponse{Success: true, Data: []interface{}{}, RequestID: getRequestID(c), Timestamp: time.Now()})
 }
 
+// GetReceivablesReport reporta la deuda de cuentas corrientes por tramos de antigüedad
 func (h *FinancialReportsHandler) GetReceivablesReport(c *gin.Context) {
-	c.JSON(http.StatusOK, models.APIResponse{Success: true, Data: []interface{}{}, RequestID: getRequestID(c), Timestamp: time.Now()})
+	var filtros []string
+	var args []interface{}
+	if sucursalID := c.Query("sucursal_id"); sucursalID != "" {
+		if _, err := uuid.Parse(sucursalID); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error: &models.APIError{
+					Code:    "INVALID_SUCURSAL_ID",
+					Message: "ID de sucursal inválido",
+				},
+				RequestID: getRequestID(c),
+				Timestamp: time.Now(),
+			})
+			return
+		}
+		args = append(args, sucursalID)
+		filtros = append(filtros, fmt.Sprintf("m.sucursal_id = $%d", len(args)+1))
+	}
+	if estado := c.Query("estado"); estado != "" {
+		args = append(args, estado)
+		filtros = append(filtros, fmt.Sprintf("c.estado = $%d", len(args)+1))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	corte := time.Now()
+	cuentas, err := consultarAntiguedadDeuda(ctx, h.db, corte, strings.Join(filtros, " AND "), args...)
+	if err != nil {
+		h.logger.WithError(err).Error("Error generando reporte de cuentas por cobrar")
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "REPORT_ERROR",
+				Message: "Error generando reporte de cuentas por cobrar",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	var totales models.AntiguedadDeuda
+	for _, cp := range cuentas {
+		totales.PorVencer += cp.Antiguedad.PorVencer
+		totales.Dias1a30 += cp.Antiguedad.Dias1a30
+		totales.Dias31a60 += cp.Antiguedad.Dias31a60
+		totales.Dias61a90 += cp.Antiguedad.Dias61a90
+		totales.Mas90 += cp.Antiguedad.Mas90
+		totales.Total += cp.Antiguedad.Total
+	}
+	totales.PorVencer = redondearMonto(totales.PorVencer)
+	totales.Dias1a30 = redondearMonto(totales.Dias1a30)
+	totales.Dias31a60 = redondearMonto(totales.Dias31a60)
+	totales.Dias61a90 = redondearMonto(totales.Dias61a90)
+	totales.Mas90 = redondearMonto(totales.Mas90)
+	totales.Total = redondearMonto(totales.Total)
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"fecha_corte": corte,
+			"cuentas":     cuentas,
+			"totales":     totales,
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
 }
 
+// GetPayablesReport reporta el saldo adeudado a proveedores por tramos de atraso respecto del vencimiento
 func (h *FinancialReportsHandler) GetPayablesReport(c *gin.Context) {
-	c.JSON(http.StatusOK, models.APIResponse{Success: true, Data: []interface{}{}, RequestID: getRequestID(c), Timestamp: time.Now()})
+	var filtros []string
+	var args []interface{}
+	for _, filtro := range []struct{ param, columna, codigo, mensaje string }{
+		{"sucursal_id", "r.sucursal_id", "INVALID_SUCURSAL_ID", "ID de sucursal inválido"},
+		{"proveedor_id", "r.proveedor_id", "INVALID_PROVEEDOR_ID", "ID de proveedor inválido"},
+	} {
+		valor := c.Query(filtro.param)
+		if valor == "" {
+			continue
+		}
+		if _, err := uuid.Parse(valor); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error: &models.APIError{
+					Code:    filtro.codigo,
+					Message: filtro.mensaje,
+				},
+				RequestID: getRequestID(c),
+				Timestamp: time.Now(),
+			})
+			return
+		}
+		args = append(args, valor)
+		filtros = append(filtros, fmt.Sprintf("%s = $%d", filtro.columna, len(args)+1))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	corte := time.Now()
+	cuentas, err := consultarCuentasPorPagar(ctx, h.db, corte, strings.Join(filtros, " AND "), args...)
+	if err != nil {
+		h.logger.WithError(err).Error("Error generando reporte de cuentas por pagar")
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "REPORT_ERROR",
+				Message: "Error generando reporte de cuentas por pagar",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	var totales models.AntiguedadDeuda
+	for _, cp := range cuentas {
+		totales.PorVencer += cp.Antiguedad.PorVencer
+		totales.Dias1a30 += cp.Antiguedad.Dias1a30
+		totales.Dias31a60 += cp.Antiguedad.Dias31a60
+		totales.Dias61a90 += cp.Antiguedad.Dias61a90
+		totales.Mas90 += cp.Antiguedad.Mas90
+		totales.Total += cp.Antiguedad.Total
+	}
+	totales.PorVencer = redondearMonto(totales.PorVencer)
+	totales.Dias1a30 = redondearMonto(totales.Dias1a30)
+	totales.Dias31a60 = redondearMonto(totales.Dias31a60)
+	totales.Dias61a90 = redondearMonto(totales.Dias61a90)
+	totales.Mas90 = redondearMonto(totales.Mas90)
+	totales.Total = redondearMonto(totales.Total)
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"fecha_corte": corte,
+			"proveedores": cuentas,
+			"totales":     totales,
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
 }
 
 func (h *FinancialReportsHandler) GetTaxReports(c *gin.Context) {
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/models"
+)
+
+const (
+	// diasHistoriaReposicion días de ventas usados por defecto para estimar la demanda
+	diasHistoriaReposicion = 90
+	// diasCoberturaReposicion días de demanda que debe cubrir por defecto cada pedido sobre el tiempo de entrega
+	diasCoberturaReposicion = 30
+	// nivelServicioReposicion porcentaje por defecto de ciclos de reposición sin quiebre de stock
+	nivelServicioReposicion = 95
+)
+
+// factoresNivelServicio desviaciones estándar de demanda que cubre el stock de seguridad según el nivel de servicio
+var factoresNivelServicio = map[int]float64{90: 1.28, 95: 1.65, 98: 2.05, 99: 2.33}
+
+// parametrosReposicion parámetros del cálculo de sugerencias de reposición
+type parametrosReposicion struct {
+	diasHistoria  int
+	diasCobertura int
+	nivelServicio int
+}
+
+// parametrosReposicionQuery lee los parámetros de la consulta; los valores ausentes o fuera de rango usan el defecto
+func parametrosReposicionQuery(c *gin.Context) parametrosReposicion {
+	p := parametrosReposicion{
+		diasHistoria:  diasHistoriaReposicion,
+		diasCobertura: diasCoberturaReposicion,
+		nivelServicio: nivelServicioReposicion,
+	}
+	if v, err := strconv.Atoi(c.Query("dias_historia")); err == nil && v >= 7 && v <= 365 {
+		p.diasHistoria = v
+	}
+	if v, err := strconv.Atoi(c.Query("dias_cobertura")); err == nil && v >= 1 && v <= 180 {
+		p.diasCobertura = v
+	}
+	if v, err := strconv.Atoi(c.Query("nivel_servicio")); err == nil {
+		if _, ok := factoresNivelServicio[v]; ok {
+			p.nivelServicio = v
+		}
+	}
+	return p
+}
+
+// calcularSugerenciasReposicion calcula qué productos de la sucursal reponer y cuánto pedir, agrupados por
+// proveedor preferido. La demanda diaria y su desviación salen de las ventas finalizadas del período de historia
+// (los días sin ventas cuentan como demanda cero); el stock de seguridad cubre la desviación durante el tiempo de
+// entrega. Se sugiere pedir cuando la posición de inventario (disponible, en tránsito y pendiente en órdenes de
+// compra abiertas) no supera el punto de reorden, hasta el stock objetivo y en múltiplos de la unidad de compra
+func calcularSugerenciasReposicion(ctx context.Context, q sqlQueryer, sucursalID string, p parametrosReposicion) ([]models.SugerenciasProveedor, error) {
+	rows, err := q.QueryContext(ctx, `
+		WITH ventas_dia AS (
+			SELECT dv.producto_id, v.fecha::date AS dia, SUM(dv.cantidad * dv.factor_conversion) AS cantidad
+			FROM ventas v
+			JOIN detalle_ventas dv ON dv.venta_id = v.id
+			WHERE v.sucursal_id = $1 AND v.estado = 'finalizada'
+				AND v.fecha >= CURRENT_DATE - make_interval(days => $2)
+			GROUP BY dv.producto_id, v.fecha::date
+		), demanda AS (
+			SELECT producto_id, SUM(cantidad) / $2 AS media, SUM(cantidad * cantidad) / $2 AS media_cuadrados
+			FROM ventas_dia
+			GROUP BY producto_id
+		), pendiente AS (
+			SELECT d.producto_id, SUM(GREATEST(d.cantidad - COALESCE(d.cantidad_recibida, 0), 0)) AS cantidad
+			FROM detalle_ordenes_compra d
+			JOIN ordenes_compra o ON o.id = d.orden_compra_id
+			WHERE o.sucursal_id = $1 AND o.estado IN ('borrador', 'emitida', 'recibida_parcial')
+			GROUP BY d.producto_id
+		)
+		SELECT p.id, p.codigo_interno, p.descripcion, COALESCE(p.stock_minimo, 0), p.stock_maximo,
+			COALESCE(de.media, 0), COALESCE(de.media_cuadrados, 0),
+			COALESCE(sc.cantidad_disponible, 0), COALESCE(sc.cantidad_en_transito, 0), COALESCE(pe.cantidad, 0),
+			pp.proveedor_id, pp.razon_social, pp.codigo_proveedor, COALESCE(pp.unidad_compra, 1),
+			COALESCE(pp.dias_entrega, $3), COALESCE(pp.costo_referencia, sc.costo_promedio, p.precio_costo)
+		FROM productos p
+		LEFT JOIN stock_central sc ON sc.producto_id = p.id AND sc.sucursal_id = $1
+		LEFT JOIN demanda de ON de.producto_id = p.id
+		LEFT JOIN pendiente pe ON pe.producto_id = p.id
+		LEFT JOIN LATERAL (
+			SELECT pp.proveedor_id, pr.razon_social, pp.codigo_proveedor, pp.unidad_compra,
+				COALESCE(pp.dias_entrega, pr.dias_entrega) AS dias_entrega, pp.costo_referencia
+			FROM productos_proveedores pp
+			JOIN proveedores pr ON pr.id = pp.proveedor_id
+			WHERE pp.producto_id = p.id AND pp.activo = true AND pr.activo = true
+			ORDER BY pp.preferido DESC, pp.costo_referencia NULLS LAST, pr.razon_social
+			LIMIT 1
+		) pp ON true
+		WHERE p.activo = true AND (de.producto_id IS NOT NULL OR p.stock_minimo > 0)
+		ORDER BY p.codigo_interno`,
+		sucursalID, p.diasHistoria, diasEntregaProveedor,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando demanda de productos: %w", err)
+	}
+
+	z := factoresNivelServicio[p.nivelServicio]
+	grupos := map[uuid.UUID]*models.SugerenciasProveedor{}
+	sinProveedor := &models.SugerenciasProveedor{Items: []models.SugerenciaReposicion{}}
+	err = database.ScanRows(rows, func() error {
+		var s models.SugerenciaReposicion
+		var mediaCuadrados float64
+		var razonSocial *string
+		if err := rows.Scan(&s.ProductoID, &s.CodigoInterno, &s.Descripcion, &s.StockMinimo, &s.StockMaximo,
+			&s.VentaDiaria, &mediaCuadrados, &s.StockDisponible, &s.EnTransito, &s.PendienteCompra,
+			&s.ProveedorID, &razonSocial, &s.CodigoProveedor, &s.UnidadCompra, &s.DiasEntrega, &s.CostoUnitario); err != nil {
+			return err
+		}
+
+		s.DesviacionDiaria = math.Sqrt(math.Max(mediaCuadrados-s.VentaDiaria*s.VentaDiaria, 0))
+		if !calcularCantidadReposicion(&s, z, p.diasCobertura) {
+			return nil
+		}
+
+		grupo := sinProveedor
+		if s.ProveedorID != nil {
+			grupo = grupos[*s.ProveedorID]
+			if grupo == nil {
+				grupo = &models.SugerenciasProveedor{ProveedorID: s.ProveedorID, RazonSocial: razonSocial}
+				grupos[*s.ProveedorID] = grupo
+			}
+		}
+		grupo.Items = append(grupo.Items, s)
+		if s.CostoUnitario != nil {
+			grupo.TotalNeto = redondearMonto(grupo.TotalNeto + s.CantidadSugerida**s.CostoUnitario)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error calculando sugerencias de reposición: %w", err)
+	}
+
+	resultado := make([]models.SugerenciasProveedor, 0, len(grupos)+1)
+	for _, grupo := range grupos {
+		resultado = append(resultado, *grupo)
+	}
+	sort.Slice(resultado, func(i, j int) bool { return *resultado[i].RazonSocial < *resultado[j].RazonSocial })
+	if len(sinProveedor.Items) > 0 {
+		resultado = append(resultado, *sinProveedor)
+	}
+	return resultado, nil
+}
+
+// calcularCantidadReposicion completa el stock de seguridad, punto de reorden, stock objetivo y cantidad a pedir
+// de la sugerencia. Indica si corresponde pedir
+func calcularCantidadReposicion(s *models.SugerenciaReposicion, z float64, diasCobertura int) bool {
+	entrega := float64(s.DiasEntrega)
+	seguridad := z * s.DesviacionDiaria * math.Sqrt(entrega)
+
+	s.PuntoReorden = math.Max(s.VentaDiaria*entrega+seguridad, s.StockMinimo)
+	s.StockObjetivo = math.Max(s.VentaDiaria*(entrega+float64(diasCobertura))+seguridad, s.StockMinimo)
+	if s.StockMaximo != nil {
+		s.PuntoReorden = math.Min(s.PuntoReorden, *s.StockMaximo)
+		s.StockObjetivo = math.Min(s.StockObjetivo, *s.StockMaximo)
+	}
+	s.PosicionInventario = redondearCantidad(s.StockDisponible + s.EnTransito + s.PendienteCompra)
+
+	s.VentaDiaria = redondearCantidad(s.VentaDiaria)
+	s.DesviacionDiaria = redondearCantidad(s.DesviacionDiaria)
+	s.StockSeguridad = redondearCantidad(seguridad)
+	s.PuntoReorden = redondearCantidad(s.PuntoReorden)
+	s.StockObjetivo = redondearCantidad(s.StockObjetivo)
+
+	faltante := s.StockObjetivo - s.PosicionInventario
+	if s.PosicionInventario > s.PuntoReorden || faltante <= 0 {
+		return false
+	}
+
+	// Se pide en empaques completos; si redondear hacia arriba supera el máximo se baja un empaque, salvo
+	// que sea el único
+	empaques := math.Ceil(redondearCantidad(faltante / s.UnidadCompra))
+	if s.StockMaximo != nil && empaques > 1 && s.PosicionInventario+empaques*s.UnidadCompra > *s.StockMaximo {
+		empaques--
+	}
+	s.CantidadSugerida = redondearCantidad(empaques * s.UnidadCompra)
+	return true
+}
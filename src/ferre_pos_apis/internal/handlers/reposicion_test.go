@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"ferre_pos_apis/internal/models"
+)
+
+func TestCalcularCantidadReposicion(t *testing.T) {
+	maximo := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name          string
+		sugerencia    models.SugerenciaReposicion
+		z             float64
+		diasCobertura int
+		pedir         bool
+		seguridad     float64
+		puntoReorden  float64
+		objetivo      float64
+		cantidad      float64
+	}{
+		{
+			name:          "redondea hacia arriba al empaque de compra",
+			sugerencia:    models.SugerenciaReposicion{VentaDiaria: 10, DiasEntrega: 5, StockDisponible: 20, UnidadCompra: 12},
+			diasCobertura: 10,
+			pedir:         true,
+			puntoReorden:  50,
+			objetivo:      150,
+			cantidad:      132,
+		},
+		{
+			name:          "faltante exacto en empaques",
+			sugerencia:    models.SugerenciaReposicion{VentaDiaria: 10, DiasEntrega: 5, StockDisponible: 20, UnidadCompra: 10},
+			diasCobertura: 10,
+			pedir:         true,
+			puntoReorden:  50,
+			objetivo:      150,
+			cantidad:      130,
+		},
+		{
+			name:          "baja un empaque si el redondeo supera el stock máximo",
+			sugerencia:    models.SugerenciaReposicion{VentaDiaria: 10, DiasEntrega: 5, StockDisponible: 20, UnidadCompra: 25, StockMaximo: maximo(140)},
+			diasCobertura: 10,
+			pedir:         true,
+			puntoReorden:  50,
+			objetivo:      140,
+			cantidad:      100,
+		},
+		{
+			name:          "mantiene el redondeo si cabe en el stock máximo",
+			sugerencia:    models.SugerenciaReposicion{VentaDiaria: 10, DiasEntrega: 5, StockDisponible: 20, UnidadCompra: 12, StockMaximo: maximo(140)},
+			diasCobertura: 10,
+			pedir:         true,
+			puntoReorden:  50,
+			objetivo:      140,
+			cantidad:      120,
+		},
+		{
+			name:          "pide un empaque aunque supere el stock máximo",
+			sugerencia:    models.SugerenciaReposicion{VentaDiaria: 10, DiasEntrega: 5, StockDisponible: 5, UnidadCompra: 50, StockMaximo: maximo(30)},
+			diasCobertura: 10,
+			pedir:         true,
+			puntoReorden:  30,
+			objetivo:      30,
+			cantidad:      50,
+		},
+		{
+			name:          "stock mínimo como piso del punto de reorden y del objetivo",
+			sugerencia:    models.SugerenciaReposicion{VentaDiaria: 0.5, DiasEntrega: 2, StockMinimo: 40, StockDisponible: 10, UnidadCompra: 1},
+			diasCobertura: 10,
+			pedir:         true,
+			puntoReorden:  40,
+			objetivo:      40,
+			cantidad:      30,
+		},
+		{
+			name:          "stock mínimo sin venta",
+			sugerencia:    models.SugerenciaReposicion{DiasEntrega: 3, StockMinimo: 6, StockDisponible: 2, UnidadCompra: 4},
+			diasCobertura: 10,
+			pedir:         true,
+			puntoReorden:  6,
+			objetivo:      6,
+			cantidad:      4,
+		},
+		{
+			name:          "incluye el stock de seguridad",
+			sugerencia:    models.SugerenciaReposicion{VentaDiaria: 10, DesviacionDiaria: 2, DiasEntrega: 4, StockDisponible: 30, UnidadCompra: 1},
+			z:             1.65,
+			diasCobertura: 10,
+			pedir:         true,
+			seguridad:     6.6,
+			puntoReorden:  46.6,
+			objetivo:      146.6,
+			cantidad:      117,
+		},
+		{
+			name:          "no pide sobre el punto de reorden",
+			sugerencia:    models.SugerenciaReposicion{VentaDiaria: 10, DiasEntrega: 5, StockDisponible: 60, UnidadCompra: 12},
+			diasCobertura: 10,
+			puntoReorden:  50,
+			objetivo:      150,
+		},
+		{
+			name:          "considera lo en tránsito y lo pendiente de compra",
+			sugerencia:    models.SugerenciaReposicion{VentaDiaria: 10, DiasEntrega: 5, StockDisponible: 20, EnTransito: 20, PendienteCompra: 15, UnidadCompra: 12},
+			diasCobertura: 10,
+			puntoReorden:  50,
+			objetivo:      150,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.sugerencia
+			pedir := calcularCantidadReposicion(&s, tt.z, tt.diasCobertura)
+
+			assert.Equal(t, tt.pedir, pedir)
+			assert.Equal(t, tt.seguridad, s.StockSeguridad)
+			assert.Equal(t, tt.puntoReorden, s.PuntoReorden)
+			assert.Equal(t, tt.objetivo, s.StockObjetivo)
+			assert.Equal(t, tt.cantidad, s.CantidadSugerida)
+		})
+	}
+}
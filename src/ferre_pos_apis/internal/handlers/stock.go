@@ -0,0 +1,711 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+const (
+	// ttlReservaCarritoMinutos vigencia por defecto de las reservas de un carrito del terminal
+	ttlReservaCarritoMinutos = 30
+
+	// reservasPorCicloExpiracion reservas vencidas liberadas por transacción en cada ciclo del job
+	reservasPorCicloExpiracion = 200
+)
+
+// StockHandler handler para operaciones de stock
+type StockHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+	alertas   *difusorAlertas
+}
+
+// NewStockHandler crea un nuevo handler de stock
+func NewStockHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *StockHandler {
+	return &StockHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+		alertas:   newDifusorAlertas(),
+	}
+}
+
+// GetByProducto obtiene el stock del producto en cada sucursal, incluido el que viene en tránsito
+func (h *StockHandler) GetByProducto(c *gin.Context) {
+	productoID, ok := uuidParam(c, "producto_id", "INVALID_PRODUCT_ID", "ID de producto inválido")
+	if !ok {
+		return
+	}
+
+	filtro := `WHERE producto_id = $1`
+	args := []interface{}{productoID}
+	if sucursalID := c.Query("sucursal_id"); sucursalID != "" {
+		if _, err := uuid.Parse(sucursalID); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error: &models.APIError{
+					Code:    "INVALID_SUCURSAL_ID",
+					Message: "ID de sucursal inválido",
+				},
+				RequestID: getRequestID(c),
+				Timestamp: time.Now(),
+			})
+			return
+		}
+		args = append(args, sucursalID)
+		filtro += ` AND sucursal_id = $2`
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stock, err := listarStockCentral(ctx, h.db, filtro+` ORDER BY sucursal_id`, args...)
+	if err != nil {
+		h.responderError(c, err, "Error consultando stock")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      stock,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetBySucursal obtiene el stock de la sucursal, incluido el que viene en tránsito desde otras sucursales
+func (h *StockHandler) GetBySucursal(c *gin.Context) {
+	sucursalID, ok := uuidParam(c, "sucursal_id", "INVALID_SUCURSAL_ID", "ID de sucursal inválido")
+	if !ok {
+		return
+	}
+
+	filtro := `WHERE sucursal_id = $1`
+	if c.Query("en_transito") == "true" {
+		filtro += ` AND cantidad_en_transito > 0`
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stock, err := listarStockCentral(ctx, h.db, filtro+` ORDER BY producto_id LIMIT 1000`, sucursalID)
+	if err != nil {
+		h.responderError(c, err, "Error consultando stock")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      stock,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// ReservarStock fija la cantidad reservada de cada producto para una nota de venta, cotización o carrito.
+// Reservar de nuevo un producto del mismo origen ajusta la cantidad y renueva el vencimiento
+func (h *StockHandler) ReservarStock(c *gin.Context) {
+	var req models.ReservarStockRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var reservas []models.ReservaStock
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		expiracion, err := expiracionReserva(ctx, tx, &req)
+		if err != nil {
+			return err
+		}
+
+		// Orden fijo de productos para no cruzar bloqueos con otras terminales
+		items := append([]models.ReservaStockItemRequest(nil), req.Items...)
+		sort.Slice(items, func(i, j int) bool { return items[i].ProductoID.String() < items[j].ProductoID.String() })
+
+		for _, item := range items {
+			if err := reservarProducto(ctx, tx, &req, &item, usuarioID, expiracion); err != nil {
+				return err
+			}
+		}
+
+		reservas, err = listarReservas(ctx, tx, `
+			WHERE tipo_origen = $1 AND origen_id = $2 AND estado = 'activa'
+			ORDER BY fecha_creacion`,
+			req.TipoOrigen, req.OrigenID,
+		)
+		return err
+	})
+	if err != nil {
+		h.responderError(c, err, "Error reservando stock")
+		return
+	}
+
+	h.logger.WithField("tipo_origen", req.TipoOrigen).
+		WithField("origen_id", req.OrigenID).
+		WithField("reservas_activas", len(reservas)).
+		Info("Stock reservado")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      reservas,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// LiberarStock libera reservas activas indicadas por ID o todas las de un origen.
+// Solo el dueño de la reserva o un supervisor pueden liberarla
+func (h *StockHandler) LiberarStock(c *gin.Context) {
+	var req models.LiberarStockRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	porOrigen := req.TipoOrigen != nil && req.OrigenID != nil
+	if len(req.ReservaIDs) == 0 && !porOrigen {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "RESERVA_SIN_CRITERIO",
+				Message: "Debe indicar reserva_ids o tipo_origen y origen_id",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+	supervisor := getUserRole(c) == "supervisor" || getUserRole(c) == "admin"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var liberadas []models.ReservaStock
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		var reservas []models.ReservaStock
+		var err error
+		if porOrigen {
+			reservas, err = listarReservas(ctx, tx, `
+				WHERE tipo_origen = $1 AND origen_id = $2 AND estado = 'activa'
+				ORDER BY producto_id
+				FOR UPDATE`,
+				*req.TipoOrigen, *req.OrigenID,
+			)
+		} else {
+			reservas, err = listarReservas(ctx, tx, `
+				WHERE id = ANY($1::uuid[])
+				ORDER BY producto_id
+				FOR UPDATE`,
+				pq.Array(uuidsTexto(req.ReservaIDs)),
+			)
+			if err == nil && len(reservas) < len(req.ReservaIDs) {
+				err = &apiError{
+					status:  http.StatusNotFound,
+					code:    "RESERVA_NOT_FOUND",
+					message: "Hay reservas inexistentes entre las indicadas",
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		for i := range reservas {
+			r := &reservas[i]
+			if r.Estado != "activa" {
+				return &apiError{
+					status:  http.StatusConflict,
+					code:    "RESERVA_NO_ACTIVA",
+					message: fmt.Sprintf("La reserva ya se encuentra %s", r.Estado),
+					details: models.JSONB{"reserva_id": r.ID, "estado": r.Estado},
+				}
+			}
+			if r.UsuarioID != usuarioID && !supervisor {
+				return &apiError{
+					status:  http.StatusForbidden,
+					code:    "RESERVA_DE_OTRO_USUARIO",
+					message: "Solo el dueño de la reserva o un supervisor pueden liberarla",
+					details: models.JSONB{"reserva_id": r.ID, "usuario_id": r.UsuarioID},
+				}
+			}
+			if err := cerrarReserva(ctx, tx, r, "liberada", &usuarioID, req.Motivo); err != nil {
+				return err
+			}
+		}
+		liberadas = reservas
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error liberando stock")
+		return
+	}
+
+	h.logger.WithField("reservas_liberadas", len(liberadas)).Info("Stock liberado")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      liberadas,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// ListReservasProducto lista las reservas activas de un producto en la sucursal junto al stock comprometido
+func (h *StockHandler) ListReservasProducto(c *gin.Context) {
+	productoID, ok := uuidParam(c, "producto_id", "INVALID_PRODUCT_ID", "ID de producto inválido")
+	if !ok {
+		return
+	}
+
+	sucursalID, err := uuid.Parse(getSucursalID(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_SUCURSAL_ID",
+				Message: "ID de sucursal inválido",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	reservas, err := listarReservas(ctx, h.db, `
+		WHERE producto_id = $1 AND sucursal_id = $2 AND estado = 'activa'
+		ORDER BY fecha_expiracion`,
+		productoID, sucursalID,
+	)
+	if err != nil {
+		h.responderError(c, err, "Error listando reservas")
+		return
+	}
+
+	var cantidad, reservada, disponible float64
+	err = h.db.QueryRowContext(ctx, `
+		SELECT cantidad, cantidad_reservada, cantidad_disponible
+		FROM stock_central
+		WHERE producto_id = $1 AND sucursal_id = $2`,
+		productoID, sucursalID,
+	).Scan(&cantidad, &reservada, &disponible)
+	if err != nil && err != sql.ErrNoRows {
+		h.responderError(c, err, "Error listando reservas")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"producto_id":         productoID,
+			"sucursal_id":         sucursalID,
+			"cantidad":            cantidad,
+			"cantidad_reservada":  reservada,
+			"cantidad_disponible": disponible,
+			"reservas":            reservas,
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// StartExpiracionReservas inicia en background la liberación de reservas de stock vencidas
+func (h *StockHandler) StartExpiracionReservas(intervalo time.Duration) {
+	go func() {
+		ticker := time.NewTicker(intervalo)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			vencidas, err := h.expirarReservas(ctx)
+			cancel()
+
+			if err != nil {
+				h.logger.WithError(err).Error("Error liberando reservas de stock vencidas")
+				continue
+			}
+			if vencidas > 0 {
+				h.logger.WithField("reservas_vencidas", vencidas).Info("Reservas de stock vencidas liberadas")
+			}
+		}
+	}()
+}
+
+// expirarReservas libera un lote de reservas vencidas devolviendo su cantidad al stock disponible.
+// SKIP LOCKED deja fuera las reservas que una terminal está modificando en ese momento
+func (h *StockHandler) expirarReservas(ctx context.Context) (int, error) {
+	var vencidas int
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		reservas, err := listarReservas(ctx, tx, `
+			WHERE estado = 'activa' AND fecha_expiracion < NOW()
+			ORDER BY producto_id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED`,
+			reservasPorCicloExpiracion,
+		)
+		if err != nil {
+			return err
+		}
+
+		motivo := "Reserva vencida"
+		for i := range reservas {
+			if err := cerrarReserva(ctx, tx, &reservas[i], "expirada", nil, &motivo); err != nil {
+				return err
+			}
+		}
+		vencidas = len(reservas)
+		return nil
+	})
+	return vencidas, err
+}
+
+// responderError responde errores de negocio, reserva inexistente o errores internos
+func (h *StockHandler) responderError(c *gin.Context, err error, mensaje string) {
+	if errors.Is(err, sql.ErrNoRows) {
+		err = &apiError{
+			status:  http.StatusNotFound,
+			code:    "RESERVA_NOT_FOUND",
+			message: "Reserva no encontrada",
+		}
+	}
+
+	responderError(c, h.logger, err, "STOCK_ERROR", mensaje)
+}
+
+// columnasReservaStock columnas de reservas_stock en el orden esperado por scanReservaStock
+const columnasReservaStock = `id, producto_id, sucursal_id, cantidad, tipo_origen, origen_id, usuario_id,
+		terminal_id, estado, fecha_creacion, fecha_modificacion, fecha_expiracion, fecha_liberacion,
+		usuario_liberacion_id, motivo_liberacion, observaciones`
+
+// scanReservaStock lee una reserva de stock desde una fila
+func scanReservaStock(row interface{ Scan(...interface{}) error }) (*models.ReservaStock, error) {
+	var r models.ReservaStock
+	err := row.Scan(&r.ID, &r.ProductoID, &r.SucursalID, &r.Cantidad, &r.TipoOrigen, &r.OrigenID,
+		&r.UsuarioID, &r.TerminalID, &r.Estado, &r.FechaCreacion, &r.FechaModificacion,
+		&r.FechaExpiracion, &r.FechaLiberacion, &r.UsuarioLiberacionID, &r.MotivoLiberacion,
+		&r.Observaciones)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// listarReservas obtiene las reservas que cumplen el filtro indicado
+func listarReservas(ctx context.Context, q sqlQueryer, filtro string, args ...interface{}) ([]models.ReservaStock, error) {
+	rows, err := q.QueryContext(ctx, `SELECT `+columnasReservaStock+` FROM reservas_stock `+filtro, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando reservas de stock: %w", err)
+	}
+
+	reservas := []models.ReservaStock{}
+	err = database.ScanRows(rows, func() error {
+		r, err := scanReservaStock(rows)
+		if err != nil {
+			return err
+		}
+		reservas = append(reservas, *r)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo reservas de stock: %w", err)
+	}
+	return reservas, nil
+}
+
+// expiracionReserva calcula el vencimiento de las reservas. Las de notas de venta y cotizaciones exigen
+// que el documento siga vigente en la sucursal y por defecto vencen junto con él
+func expiracionReserva(ctx context.Context, tx *sql.Tx, req *models.ReservarStockRequest) (time.Time, error) {
+	ahora := time.Now()
+	var vigencia time.Time
+	switch req.TipoOrigen {
+	case "nota_venta":
+		nota, err := getNotaVenta(ctx, tx, "id", req.OrigenID, false)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return time.Time{}, &apiError{
+					status:  http.StatusNotFound,
+					code:    "NOTA_NOT_FOUND",
+					message: "Nota de venta no encontrada",
+				}
+			}
+			return time.Time{}, err
+		}
+		if nota.Estado != "pendiente" || nota.SucursalID != req.SucursalID {
+			return time.Time{}, &apiError{
+				status:  http.StatusConflict,
+				code:    "ORIGEN_NO_RESERVABLE",
+				message: "La nota de venta no está pendiente en la sucursal",
+				details: models.JSONB{"nota_venta_id": nota.ID, "estado": nota.Estado},
+			}
+		}
+		vigencia = nota.Fecha.Add(time.Duration(nota.TiempoVigenciaHoras) * time.Hour)
+		if nota.FechaVencimiento != nil {
+			vigencia = *nota.FechaVencimiento
+		}
+	case "cotizacion":
+		cotizacion, err := getCotizacion(ctx, tx, req.OrigenID, false)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return time.Time{}, &apiError{
+					status:  http.StatusNotFound,
+					code:    "COTIZACION_NOT_FOUND",
+					message: "Cotización no encontrada",
+				}
+			}
+			return time.Time{}, err
+		}
+		if cotizacion.SucursalID != req.SucursalID {
+			return time.Time{}, &apiError{
+				status:  http.StatusConflict,
+				code:    "ORIGEN_NO_RESERVABLE",
+				message: "La cotización pertenece a otra sucursal",
+				details: models.JSONB{"cotizacion_id": cotizacion.ID},
+			}
+		}
+		if err := validarCotizacionConvertible(cotizacion); err != nil {
+			return time.Time{}, err
+		}
+		vigencia = cotizacion.FechaValidez
+	default:
+		vigencia = ahora.Add(ttlReservaCarritoMinutos * time.Minute)
+	}
+
+	if req.TTLMinutos > 0 {
+		expiracion := ahora.Add(time.Duration(req.TTLMinutos) * time.Minute)
+		// Una reserva no sobrevive al documento que la origina
+		if req.TipoOrigen == "carrito" || expiracion.Before(vigencia) {
+			return expiracion, nil
+		}
+	}
+	return vigencia, nil
+}
+
+// reservarProducto crea, ajusta o libera la reserva activa del origen para un producto
+func reservarProducto(ctx context.Context, tx *sql.Tx, req *models.ReservarStockRequest, item *models.ReservaStockItemRequest, usuarioID uuid.UUID, expiracion time.Time) error {
+	cantidad := redondearCantidad(item.Cantidad)
+
+	existente, err := scanReservaStock(tx.QueryRowContext(ctx, `
+		SELECT `+columnasReservaStock+`
+		FROM reservas_stock
+		WHERE tipo_origen = $1 AND origen_id = $2 AND producto_id = $3 AND estado = 'activa'
+		FOR UPDATE`,
+		req.TipoOrigen, req.OrigenID, item.ProductoID,
+	))
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error consultando reserva existente: %w", err)
+	}
+
+	if existente == nil {
+		if cantidad == 0 {
+			return nil
+		}
+		if err := ajustarStockReservado(ctx, tx, item.ProductoID, req.SucursalID, cantidad); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO reservas_stock (
+				producto_id, sucursal_id, cantidad, tipo_origen, origen_id, usuario_id,
+				terminal_id, fecha_expiracion, observaciones
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			item.ProductoID, req.SucursalID, cantidad, req.TipoOrigen, req.OrigenID, usuarioID,
+			req.TerminalID, expiracion, req.Observaciones,
+		)
+		if err != nil {
+			return fmt.Errorf("error insertando reserva de stock: %w", err)
+		}
+		return nil
+	}
+
+	if cantidad == 0 {
+		motivo := "Cantidad reservada en cero"
+		return cerrarReserva(ctx, tx, existente, "liberada", &usuarioID, &motivo)
+	}
+
+	if delta := redondearCantidad(cantidad - existente.Cantidad); delta != 0 {
+		if err := ajustarStockReservado(ctx, tx, item.ProductoID, existente.SucursalID, delta); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE reservas_stock
+		SET cantidad = $2, fecha_expiracion = $3, terminal_id = COALESCE($4, terminal_id),
+			observaciones = COALESCE($5, observaciones), fecha_modificacion = NOW()
+		WHERE id = $1`,
+		existente.ID, cantidad, expiracion, req.TerminalID, req.Observaciones,
+	)
+	if err != nil {
+		return fmt.Errorf("error actualizando reserva de stock: %w", err)
+	}
+	return nil
+}
+
+// cerrarReserva devuelve la cantidad de una reserva activa al stock disponible y la deja en el estado final indicado
+func cerrarReserva(ctx context.Context, tx *sql.Tx, r *models.ReservaStock, estado string, usuarioID *uuid.UUID, motivo *string) error {
+	if err := ajustarStockReservado(ctx, tx, r.ProductoID, r.SucursalID, -r.Cantidad); err != nil {
+		return err
+	}
+
+	err := tx.QueryRowContext(ctx, `
+		UPDATE reservas_stock
+		SET estado = $2, fecha_liberacion = NOW(), usuario_liberacion_id = $3,
+			motivo_liberacion = $4, fecha_modificacion = NOW()
+		WHERE id = $1
+		RETURNING fecha_liberacion`,
+		r.ID, estado, usuarioID, motivo,
+	).Scan(&r.FechaLiberacion)
+	if err != nil {
+		return fmt.Errorf("error cerrando reserva de stock: %w", err)
+	}
+	r.Estado = estado
+	r.UsuarioLiberacionID = usuarioID
+	r.MotivoLiberacion = motivo
+	return nil
+}
+
+// consumirReservas cierra las reservas activas de un origen que se convierte en venta. Se llama antes de
+// descontar el stock para que la cantidad reservada vuelva a estar disponible para la propia venta
+func consumirReservas(ctx context.Context, tx *sql.Tx, tipoOrigen string, origenID uuid.UUID, usuarioID *uuid.UUID) error {
+	reservas, err := listarReservas(ctx, tx, `
+		WHERE tipo_origen = $1 AND origen_id = $2 AND estado = 'activa'
+		ORDER BY producto_id
+		FOR UPDATE`,
+		tipoOrigen, origenID,
+	)
+	if err != nil {
+		return err
+	}
+
+	for i := range reservas {
+		if err := cerrarReserva(ctx, tx, &reservas[i], "consumida", usuarioID, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ajustarStockReservado suma delta a la cantidad reservada del producto con control de concurrencia optimista:
+// la actualización solo aplica si nadie modificó la fila desde la lectura, reintentando en caso contrario
+func ajustarStockReservado(ctx context.Context, tx *sql.Tx, productoID, sucursalID uuid.UUID, delta float64) error {
+	for intento := 0; intento < intentosDescuentoStock; intento++ {
+		var reservada, disponible float64
+		var version int
+		err := tx.QueryRowContext(ctx, `
+			SELECT cantidad_reservada, cantidad_disponible, version_optimistic_lock
+			FROM stock_central
+			WHERE producto_id = $1 AND sucursal_id = $2`,
+			productoID, sucursalID,
+		).Scan(&reservada, &disponible, &version)
+		if err == sql.ErrNoRows {
+			if delta <= 0 {
+				return nil
+			}
+		} else if err != nil {
+			return fmt.Errorf("error consultando stock: %w", err)
+		}
+
+		if delta > 0 && disponible < delta {
+			return &apiError{
+				status:  http.StatusConflict,
+				code:    "STOCK_INSUFICIENTE",
+				message: "Stock disponible insuficiente para reservar",
+				details: models.JSONB{
+					"producto_id":         productoID,
+					"cantidad_solicitada": delta,
+					"cantidad_disponible": disponible,
+				},
+			}
+		}
+
+		nueva := redondearCantidad(reservada + delta)
+		if nueva < 0 {
+			nueva = 0
+		}
+		result, err := tx.ExecContext(ctx, `
+			UPDATE stock_central
+			SET cantidad_reservada = $3,
+				version_optimistic_lock = version_optimistic_lock + 1,
+				fecha_sync = NOW()
+			WHERE producto_id = $1 AND sucursal_id = $2 AND version_optimistic_lock = $4`,
+			productoID, sucursalID, nueva, version,
+		)
+		if err != nil {
+			return fmt.Errorf("error actualizando stock reservado: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 1 {
+			return nil
+		}
+	}
+
+	return errConflictoStock(productoID)
+}
+
+// errConflictoStock error cuando la fila de stock siguió cambiando durante todos los reintentos optimistas
+func errConflictoStock(productoID uuid.UUID) error {
+	return &apiError{
+		status:  http.StatusConflict,
+		code:    "CONFLICTO_CONCURRENCIA",
+		message: "El stock fue modificado por otra terminal, reintente la operación",
+		details: models.JSONB{"producto_id": productoID},
+	}
+}
+
+// listarStockCentral obtiene las filas de stock_central que cumplen el filtro indicado
+func listarStockCentral(ctx context.Context, q sqlQueryer, filtro string, args ...interface{}) ([]models.StockCentral, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT producto_id, sucursal_id, COALESCE(cantidad, 0), COALESCE(cantidad_reservada, 0),
+			COALESCE(cantidad_disponible, 0), COALESCE(cantidad_en_transito, 0), costo_promedio,
+			fecha_ultima_entrada, fecha_ultima_salida, COALESCE(fecha_sync, NOW()),
+			COALESCE(version_optimistic_lock, 1), COALESCE(cache_validez, NOW())
+		FROM stock_central `+filtro, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando stock: %w", err)
+	}
+
+	stock := []models.StockCentral{}
+	err = database.ScanRows(rows, func() error {
+		var s models.StockCentral
+		if err := rows.Scan(&s.ProductoID, &s.SucursalID, &s.Cantidad, &s.CantidadReservada,
+			&s.CantidadDisponible, &s.CantidadEnTransito, &s.CostoPromedio,
+			&s.FechaUltimaEntrada, &s.FechaUltimaSalida, &s.FechaSync,
+			&s.VersionOptimisticLock, &s.CacheValidez); err != nil {
+			return err
+		}
+		stock = append(stock, s)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo stock: %w", err)
+	}
+	return stock, nil
+}
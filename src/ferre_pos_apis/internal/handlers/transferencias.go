@@ -0,0 +1,1023 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+// TransferenciasHandler handler para traslados de mercadería entre sucursales
+type TransferenciasHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+}
+
+// NewTransferenciasHandler crea un nuevo handler de transferencias
+func NewTransferenciasHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *TransferenciasHandler {
+	return &TransferenciasHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+	}
+}
+
+// Create registra una solicitud de transferencia entre dos sucursales
+func (h *TransferenciasHandler) Create(c *gin.Context) {
+	var req models.TransferenciaStockRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	if req.SucursalOrigenID == req.SucursalDestinoID {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "SUCURSALES_IGUALES",
+				Message: "La sucursal de origen y la de destino deben ser distintas",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var transferencia *models.TransferenciaStock
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		id := uuid.New()
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO transferencias_stock (
+				id, sucursal_origen_id, sucursal_destino_id, motivo, observaciones,
+				usuario_solicitud_id, total_items
+			) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			id, req.SucursalOrigenID, req.SucursalDestinoID, req.Motivo, req.Observaciones,
+			usuarioID, len(req.Items),
+		)
+		if err != nil {
+			return fmt.Errorf("error insertando transferencia: %w", err)
+		}
+
+		vistos := make(map[uuid.UUID]bool, len(req.Items))
+		for _, item := range req.Items {
+			if vistos[item.ProductoID] {
+				return &apiError{
+					status:  http.StatusBadRequest,
+					code:    "PRODUCTO_DUPLICADO",
+					message: "Cada producto debe informarse una sola vez",
+					details: models.JSONB{"producto_id": item.ProductoID},
+				}
+			}
+			vistos[item.ProductoID] = true
+
+			if _, err := productoTransferible(ctx, tx, item.ProductoID, item.Cantidad); err != nil {
+				return err
+			}
+
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO detalle_transferencias_stock (transferencia_id, producto_id, cantidad_solicitada)
+				VALUES ($1, $2, $3)`,
+				id, item.ProductoID, redondearCantidad(item.Cantidad),
+			)
+			if err != nil {
+				return fmt.Errorf("error insertando detalle de transferencia: %w", err)
+			}
+		}
+
+		transferencia, err = getTransferencia(ctx, tx, id, false)
+		return err
+	})
+	if err != nil {
+		h.responderError(c, err, "Error registrando transferencia")
+		return
+	}
+
+	h.logger.WithField("transferencia_id", transferencia.ID).
+		WithField("numero_transferencia", transferencia.NumeroTransferencia).
+		WithField("sucursal_origen_id", transferencia.SucursalOrigenID).
+		WithField("sucursal_destino_id", transferencia.SucursalDestinoID).
+		Info("Transferencia solicitada")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success:   true,
+		Data:      transferencia,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// List lista las transferencias en que participa la sucursal, como origen o destino
+func (h *TransferenciasHandler) List(c *gin.Context) {
+	sucursalID := getSucursalID(c)
+	if _, err := uuid.Parse(sucursalID); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_SUCURSAL_ID",
+				Message: "ID de sucursal inválido",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	args := []interface{}{sucursalID}
+	var query string
+	switch c.Query("direccion") {
+	case "salida":
+		query = `WHERE sucursal_origen_id = $1`
+	case "entrada":
+		query = `WHERE sucursal_destino_id = $1`
+	default:
+		query = `WHERE (sucursal_origen_id = $1 OR sucursal_destino_id = $1)`
+	}
+	if estado := c.Query("estado"); estado != "" {
+		args = append(args, estado)
+		query += ` AND estado = $` + strconv.Itoa(len(args))
+	}
+	query += ` ORDER BY fecha_solicitud DESC LIMIT 200`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	transferencias, err := listarTransferencias(ctx, h.db, query, args...)
+	if err != nil {
+		h.responderError(c, err, "Error listando transferencias")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      transferencias,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetByID obtiene una transferencia con su detalle
+func (h *TransferenciasHandler) GetByID(c *gin.Context) {
+	transferenciaID, ok := uuidParam(c, "id", "INVALID_TRANSFERENCIA_ID", "ID de transferencia inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	transferencia, err := getTransferencia(ctx, h.db, transferenciaID, false)
+	if err != nil {
+		h.responderError(c, err, "Error consultando transferencia")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      transferencia,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Aprobar autoriza el despacho de una transferencia solicitada
+func (h *TransferenciasHandler) Aprobar(c *gin.Context) {
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	h.modificarTransferencia(c, "Error aprobando transferencia", func(ctx context.Context, tx *sql.Tx, t *models.TransferenciaStock) error {
+		if t.Estado != "solicitada" {
+			return errTransferenciaNoModificable(t)
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			UPDATE transferencias_stock
+			SET estado = 'aprobada', usuario_aprobacion_id = $2, fecha_aprobacion = NOW()
+			WHERE id = $1`,
+			t.ID, usuarioID,
+		)
+		if err != nil {
+			return fmt.Errorf("error aprobando transferencia: %w", err)
+		}
+		return nil
+	})
+}
+
+// Rechazar rechaza una transferencia que aún no se despacha
+func (h *TransferenciasHandler) Rechazar(c *gin.Context) {
+	h.cerrarSinDespacho(c, "rechazada", "Error rechazando transferencia")
+}
+
+// Cancelar anula una transferencia que aún no se despacha
+func (h *TransferenciasHandler) Cancelar(c *gin.Context) {
+	h.cerrarSinDespacho(c, "cancelada", "Error cancelando transferencia")
+}
+
+// Despachar descuenta el stock de origen y lo deja en tránsito hacia el destino. Sin ítems se despacha
+// lo solicitado; los productos con número de serie deben informar las series que salen
+func (h *TransferenciasHandler) Despachar(c *gin.Context) {
+	var req models.DespacharTransferenciaRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	h.modificarTransferencia(c, "Error despachando transferencia", func(ctx context.Context, tx *sql.Tx, t *models.TransferenciaStock) error {
+		if t.Estado != "aprobada" {
+			return errTransferenciaNoModificable(t)
+		}
+
+		items := make(map[uuid.UUID]models.DespachoTransferenciaItemRequest, len(req.Items))
+		for _, item := range req.Items {
+			items[item.ProductoID] = item
+		}
+		for productoID := range items {
+			if lineaTransferencia(t, productoID) == nil {
+				return errProductoNoEnTransferencia(productoID)
+			}
+		}
+
+		batchID := uuid.New()
+		documento := documentoTransferencia(t)
+		despachados := 0
+		for i := range t.Detalles {
+			d := &t.Detalles[i]
+			cantidad := d.CantidadSolicitada
+			var series []string
+			if len(items) > 0 {
+				item := items[d.ProductoID]
+				cantidad = redondearCantidad(item.Cantidad)
+				series = item.NumerosSerie
+			}
+			if cantidad > d.CantidadSolicitada {
+				return &apiError{
+					status:  http.StatusBadRequest,
+					code:    "CANTIDAD_EXCEDE_SOLICITADA",
+					message: "La cantidad despachada supera la solicitada",
+					details: models.JSONB{"producto_id": d.ProductoID, "cantidad_solicitada": d.CantidadSolicitada},
+				}
+			}
+
+			if err := despacharLineaTransferencia(ctx, tx, t, d, cantidad, series, documento, usuarioID, batchID); err != nil {
+				return err
+			}
+			if cantidad > 0 {
+				despachados++
+			}
+		}
+		if despachados == 0 {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "TRANSFERENCIA_SIN_DESPACHO",
+				message: "Debe despacharse al menos un producto",
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			UPDATE transferencias_stock
+			SET estado = 'en_transito', usuario_despacho_id = $2, fecha_despacho = NOW(), batch_id = $3,
+				observaciones = COALESCE($4, observaciones)
+			WHERE id = $1`,
+			t.ID, usuarioID, batchID, req.Observaciones,
+		)
+		if err != nil {
+			return fmt.Errorf("error actualizando transferencia: %w", err)
+		}
+		return nil
+	})
+}
+
+// Recibir ingresa en destino lo contado de cada producto despachado. Las diferencias con lo despachado
+// quedan registradas con su motivo y los movimientos de entrada comparten el batch_id de la salida
+func (h *TransferenciasHandler) Recibir(c *gin.Context) {
+	var req models.RecibirTransferenciaRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	h.modificarTransferencia(c, "Error recibiendo transferencia", func(ctx context.Context, tx *sql.Tx, t *models.TransferenciaStock) error {
+		if t.Estado != "en_transito" {
+			return errTransferenciaNoModificable(t)
+		}
+
+		conteo := make(map[uuid.UUID]models.RecepcionTransferenciaItemRequest, len(req.Items))
+		for _, item := range req.Items {
+			d := lineaTransferencia(t, item.ProductoID)
+			if d == nil || d.CantidadDespachada == nil || *d.CantidadDespachada == 0 {
+				return errProductoNoEnTransferencia(item.ProductoID)
+			}
+			conteo[item.ProductoID] = item
+		}
+
+		var sinContar []uuid.UUID
+		for _, d := range t.Detalles {
+			if d.CantidadDespachada == nil || *d.CantidadDespachada == 0 {
+				continue
+			}
+			if _, ok := conteo[d.ProductoID]; !ok {
+				sinContar = append(sinContar, d.ProductoID)
+			}
+		}
+		if len(sinContar) > 0 {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "CONTEO_INCOMPLETO",
+				message: "Debe informarse la cantidad recibida de cada producto despachado",
+				details: models.JSONB{"productos": sinContar},
+			}
+		}
+
+		documento := documentoTransferencia(t)
+		conDiferencias := false
+		for i := range t.Detalles {
+			d := &t.Detalles[i]
+			item, ok := conteo[d.ProductoID]
+			if !ok {
+				continue
+			}
+			if err := recibirLineaTransferencia(ctx, tx, t, d, &item, documento, usuarioID); err != nil {
+				return err
+			}
+			if *d.CantidadRecibida != *d.CantidadDespachada {
+				conDiferencias = true
+			}
+		}
+
+		estado := "recibida"
+		if conDiferencias {
+			estado = "recibida_con_diferencias"
+		}
+		_, err := tx.ExecContext(ctx, `
+			UPDATE transferencias_stock
+			SET estado = $2, usuario_recepcion_id = $3, fecha_recepcion = NOW(), observaciones_recepcion = $4
+			WHERE id = $1`,
+			t.ID, estado, usuarioID, req.Observaciones,
+		)
+		if err != nil {
+			return fmt.Errorf("error actualizando transferencia: %w", err)
+		}
+		return nil
+	})
+}
+
+// cerrarSinDespacho rechaza o cancela una transferencia solicitada o aprobada
+func (h *TransferenciasHandler) cerrarSinDespacho(c *gin.Context, estado, mensaje string) {
+	var req models.RechazarTransferenciaRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	usuarioID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+
+	h.modificarTransferencia(c, mensaje, func(ctx context.Context, tx *sql.Tx, t *models.TransferenciaStock) error {
+		if t.Estado != "solicitada" && t.Estado != "aprobada" {
+			return errTransferenciaNoModificable(t)
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			UPDATE transferencias_stock
+			SET estado = $2, usuario_aprobacion_id = $3, fecha_aprobacion = NOW(), motivo_rechazo = $4
+			WHERE id = $1`,
+			t.ID, estado, usuarioID, req.Motivo,
+		)
+		if err != nil {
+			return fmt.Errorf("error cerrando transferencia: %w", err)
+		}
+		return nil
+	})
+}
+
+// modificarTransferencia bloquea la transferencia de la ruta, aplica el cambio y responde con la transferencia actualizada
+func (h *TransferenciasHandler) modificarTransferencia(c *gin.Context, mensaje string, cambio func(ctx context.Context, tx *sql.Tx, t *models.TransferenciaStock) error) {
+	transferenciaID, ok := uuidParam(c, "id", "INVALID_TRANSFERENCIA_ID", "ID de transferencia inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var transferencia *models.TransferenciaStock
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		t, err := getTransferencia(ctx, tx, transferenciaID, true)
+		if err != nil {
+			return err
+		}
+		if err := cambio(ctx, tx, t); err != nil {
+			return err
+		}
+		transferencia, err = getTransferencia(ctx, tx, transferenciaID, false)
+		return err
+	})
+	if err != nil {
+		h.responderError(c, err, mensaje)
+		return
+	}
+
+	h.logger.WithField("transferencia_id", transferencia.ID).
+		WithField("numero_transferencia", transferencia.NumeroTransferencia).
+		WithField("estado", transferencia.Estado).
+		Info("Transferencia actualizada")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      transferencia,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// responderError responde errores de negocio, transferencia inexistente o errores internos
+func (h *TransferenciasHandler) responderError(c *gin.Context, err error, mensaje string) {
+	if errors.Is(err, sql.ErrNoRows) {
+		err = &apiError{
+			status:  http.StatusNotFound,
+			code:    "TRANSFERENCIA_NOT_FOUND",
+			message: "Transferencia no encontrada",
+		}
+	}
+
+	responderError(c, h.logger, err, "TRANSFERENCIA_ERROR", mensaje)
+}
+
+// columnasTransferencia columnas de transferencias_stock en el orden esperado por scanTransferencia
+const columnasTransferencia = `id, numero_transferencia, sucursal_origen_id, sucursal_destino_id, estado,
+		motivo, observaciones, usuario_solicitud_id, fecha_solicitud, usuario_aprobacion_id,
+		fecha_aprobacion, motivo_rechazo, usuario_despacho_id, fecha_despacho, usuario_recepcion_id,
+		fecha_recepcion, observaciones_recepcion, batch_id, total_items`
+
+// scanTransferencia lee una transferencia desde una fila
+func scanTransferencia(row interface{ Scan(...interface{}) error }) (*models.TransferenciaStock, error) {
+	var t models.TransferenciaStock
+	err := row.Scan(&t.ID, &t.NumeroTransferencia, &t.SucursalOrigenID, &t.SucursalDestinoID, &t.Estado,
+		&t.Motivo, &t.Observaciones, &t.UsuarioSolicitudID, &t.FechaSolicitud, &t.UsuarioAprobacionID,
+		&t.FechaAprobacion, &t.MotivoRechazo, &t.UsuarioDespachoID, &t.FechaDespacho, &t.UsuarioRecepcionID,
+		&t.FechaRecepcion, &t.ObservacionesRecepcion, &t.BatchID, &t.TotalItems)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// listarTransferencias obtiene las transferencias que cumplen el filtro indicado, sin su detalle
+func listarTransferencias(ctx context.Context, q sqlQueryer, filtro string, args ...interface{}) ([]models.TransferenciaStock, error) {
+	rows, err := q.QueryContext(ctx, `SELECT `+columnasTransferencia+` FROM transferencias_stock `+filtro, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando transferencias: %w", err)
+	}
+
+	transferencias := []models.TransferenciaStock{}
+	err = database.ScanRows(rows, func() error {
+		t, err := scanTransferencia(rows)
+		if err != nil {
+			return err
+		}
+		transferencias = append(transferencias, *t)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo transferencias: %w", err)
+	}
+	return transferencias, nil
+}
+
+// getTransferencia obtiene una transferencia con su detalle ordenado por producto
+func getTransferencia(ctx context.Context, q sqlQueryer, id uuid.UUID, bloquear bool) (*models.TransferenciaStock, error) {
+	query := `SELECT ` + columnasTransferencia + ` FROM transferencias_stock WHERE id = $1`
+	if bloquear {
+		query += ` FOR UPDATE`
+	}
+
+	t, err := scanTransferencia(q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return nil, err
+	}
+
+	// El orden por producto fija el orden de bloqueo de stock_central al despachar y recibir
+	rows, err := q.QueryContext(ctx, `
+		SELECT d.id, d.transferencia_id, d.producto_id, p.codigo_interno, p.descripcion,
+			d.cantidad_solicitada, d.cantidad_despachada, d.cantidad_recibida, d.diferencia,
+			d.costo_unitario, d.numeros_serie, d.lotes, d.motivo_diferencia
+		FROM detalle_transferencias_stock d
+		JOIN productos p ON p.id = d.producto_id
+		WHERE d.transferencia_id = $1
+		ORDER BY d.producto_id`,
+		t.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando detalle de transferencia: %w", err)
+	}
+	err = database.ScanRows(rows, func() error {
+		var d models.DetalleTransferenciaStock
+		var lotes []byte
+		if err := rows.Scan(&d.ID, &d.TransferenciaID, &d.ProductoID, &d.CodigoInterno, &d.Descripcion,
+			&d.CantidadSolicitada, &d.CantidadDespachada, &d.CantidadRecibida, &d.Diferencia,
+			&d.CostoUnitario, pq.Array(&d.NumerosSerie), &lotes, &d.MotivoDiferencia); err != nil {
+			return err
+		}
+		if len(lotes) > 0 {
+			if err := json.Unmarshal(lotes, &d.Lotes); err != nil {
+				return fmt.Errorf("error leyendo lotes de transferencia: %w", err)
+			}
+		}
+		t.Detalles = append(t.Detalles, d)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo detalle de transferencia: %w", err)
+	}
+
+	return t, nil
+}
+
+// lineaTransferencia busca la línea de un producto en la transferencia
+func lineaTransferencia(t *models.TransferenciaStock, productoID uuid.UUID) *models.DetalleTransferenciaStock {
+	for i := range t.Detalles {
+		if t.Detalles[i].ProductoID == productoID {
+			return &t.Detalles[i]
+		}
+	}
+	return nil
+}
+
+// documentoTransferencia referencia de la transferencia en los movimientos de stock
+func documentoTransferencia(t *models.TransferenciaStock) string {
+	return fmt.Sprintf("TRANSFERENCIA-%d", t.NumeroTransferencia)
+}
+
+// errTransferenciaNoModificable error para operaciones sobre transferencias en un estado que no las admite
+func errTransferenciaNoModificable(t *models.TransferenciaStock) error {
+	return &apiError{
+		status:  http.StatusConflict,
+		code:    "TRANSFERENCIA_NO_MODIFICABLE",
+		message: fmt.Sprintf("La transferencia está %s", strings.ReplaceAll(t.Estado, "_", " ")),
+		details: models.JSONB{"transferencia_id": t.ID, "estado": t.Estado},
+	}
+}
+
+// errProductoNoEnTransferencia error para ítems de productos que no se trasladan en la transferencia
+func errProductoNoEnTransferencia(productoID uuid.UUID) error {
+	return &apiError{
+		status:  http.StatusBadRequest,
+		code:    "PRODUCTO_NO_EN_TRANSFERENCIA",
+		message: "El producto no forma parte de lo despachado en la transferencia",
+		details: models.JSONB{"producto_id": productoID},
+	}
+}
+
+// productoTransferible valida que el producto esté activo y admita la cantidad; indica si requiere serie
+func productoTransferible(ctx context.Context, tx *sql.Tx, productoID uuid.UUID, cantidad float64) (bool, error) {
+	var requiereSerie, permiteFraccion bool
+	err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(requiere_serie, false), COALESCE(permite_fraccionamiento, false) FROM productos WHERE id = $1 AND activo = true`,
+		productoID,
+	).Scan(&requiereSerie, &permiteFraccion)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, &apiError{
+				status:  http.StatusBadRequest,
+				code:    "PRODUCT_NOT_FOUND",
+				message: "Producto no encontrado o inactivo",
+				details: models.JSONB{"producto_id": productoID},
+			}
+		}
+		return false, fmt.Errorf("error consultando producto: %w", err)
+	}
+
+	if !permiteFraccion && !esEntero(cantidad) {
+		return false, &apiError{
+			status:  http.StatusBadRequest,
+			code:    "CANTIDAD_FRACCIONADA_NO_PERMITIDA",
+			message: "El producto no permite cantidades fraccionadas",
+			details: models.JSONB{"producto_id": productoID, "cantidad": cantidad},
+		}
+	}
+	return requiereSerie, nil
+}
+
+// despacharLineaTransferencia descuenta la línea del stock de origen con su trazabilidad, registra la salida
+// y suma la cantidad al stock en tránsito del destino
+func despacharLineaTransferencia(ctx context.Context, tx *sql.Tx, t *models.TransferenciaStock, d *models.DetalleTransferenciaStock, cantidad float64, series []string, documento string, usuarioID, batchID uuid.UUID) error {
+	d.CantidadDespachada = &cantidad
+	if cantidad == 0 {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE detalle_transferencias_stock SET cantidad_despachada = 0 WHERE id = $1`, d.ID)
+		if err != nil {
+			return fmt.Errorf("error actualizando detalle de transferencia: %w", err)
+		}
+		return nil
+	}
+
+	requiereSerie, err := productoTransferible(ctx, tx, d.ProductoID, cantidad)
+	if err != nil {
+		return err
+	}
+	if requiereSerie {
+		if err := despacharSeriesTransferencia(ctx, tx, t, d, cantidad, series); err != nil {
+			return err
+		}
+	} else if len(series) > 0 {
+		return &apiError{
+			status:  http.StatusBadRequest,
+			code:    "SERIE_NO_APLICA",
+			message: "El producto no se controla por número de serie",
+			details: models.JSONB{"producto_id": d.ProductoID},
+		}
+	}
+
+	// Los lotes salen por FEFO; lo que no cubren los lotes registrados no se controla por lote
+	lotes, err := lotesDisponibles(ctx, tx, d.ProductoID, t.SucursalOrigenID, true)
+	if err != nil {
+		return err
+	}
+	asignados, _ := repartirFEFO(lotes, cantidad)
+	d.Lotes = nil
+	for _, a := range asignados {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE lotes_stock SET cantidad = cantidad - $2 WHERE id = $1`,
+			a.ID, a.CantidadSugerida,
+		)
+		if err != nil {
+			return fmt.Errorf("error descontando lote: %w", err)
+		}
+		d.Lotes = append(d.Lotes, models.LoteTransferencia{
+			Lote:             a.Lote,
+			FechaVencimiento: a.FechaVencimiento,
+			Cantidad:         a.CantidadSugerida,
+		})
+	}
+
+	anterior, costo, err := descontarStockTransferencia(ctx, tx, d.ProductoID, t.SucursalOrigenID, cantidad)
+	if err != nil {
+		return err
+	}
+	d.CostoUnitario = costo
+
+	err = registrarMovimientoStock(ctx, tx, "transferencia_salida", d.ProductoID, t.SucursalOrigenID,
+		-cantidad, anterior, costo, documento, usuarioID, batchID,
+		models.JSONB{"transferencia_id": t.ID, "sucursal_destino_id": t.SucursalDestinoID},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO stock_central (producto_id, sucursal_id, cantidad, cantidad_en_transito)
+		VALUES ($1, $2, 0, $3)
+		ON CONFLICT (producto_id, sucursal_id) DO UPDATE SET
+			cantidad_en_transito = stock_central.cantidad_en_transito + EXCLUDED.cantidad_en_transito,
+			version_optimistic_lock = stock_central.version_optimistic_lock + 1,
+			fecha_sync = NOW()`,
+		d.ProductoID, t.SucursalDestinoID, cantidad,
+	)
+	if err != nil {
+		return fmt.Errorf("error registrando stock en tránsito: %w", err)
+	}
+
+	var lotesJSON interface{}
+	if len(d.Lotes) > 0 {
+		b, err := json.Marshal(d.Lotes)
+		if err != nil {
+			return fmt.Errorf("error serializando lotes de transferencia: %w", err)
+		}
+		lotesJSON = b
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE detalle_transferencias_stock
+		SET cantidad_despachada = $2, costo_unitario = $3, numeros_serie = $4, lotes = $5
+		WHERE id = $1`,
+		d.ID, cantidad, costo, pq.Array(d.NumerosSerie), lotesJSON,
+	)
+	if err != nil {
+		return fmt.Errorf("error actualizando detalle de transferencia: %w", err)
+	}
+	return nil
+}
+
+// despacharSeriesTransferencia deja en tránsito las series despachadas, que deben estar disponibles en origen
+func despacharSeriesTransferencia(ctx context.Context, tx *sql.Tx, t *models.TransferenciaStock, d *models.DetalleTransferenciaStock, cantidad float64, series []string) error {
+	numeros := normalizarSeries(series)
+	if float64(len(numeros)) != cantidad {
+		return &apiError{
+			status:  http.StatusBadRequest,
+			code:    "SERIES_NO_COINCIDEN",
+			message: "Se debe informar un número de serie distinto por cada unidad despachada",
+			details: models.JSONB{"producto_id": d.ProductoID, "cantidad": cantidad, "series": len(numeros)},
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE series_productos
+		SET estado = 'en_transito'
+		WHERE producto_id = $1 AND sucursal_id = $2 AND numero_serie = ANY($3) AND estado = 'disponible'`,
+		d.ProductoID, t.SucursalOrigenID, pq.Array(numeros),
+	)
+	if err != nil {
+		return fmt.Errorf("error despachando números de serie: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n != int64(len(numeros)) {
+		return &apiError{
+			status:  http.StatusConflict,
+			code:    "SERIE_NO_DISPONIBLE",
+			message: "Hay números de serie que no están disponibles en la sucursal de origen",
+			details: models.JSONB{"producto_id": d.ProductoID, "numeros_serie": numeros},
+		}
+	}
+	d.NumerosSerie = numeros
+	return nil
+}
+
+// recibirLineaTransferencia ingresa lo contado de la línea en destino, libera su stock en tránsito
+// y registra la entrada con la diferencia respecto de lo despachado
+func recibirLineaTransferencia(ctx context.Context, tx *sql.Tx, t *models.TransferenciaStock, d *models.DetalleTransferenciaStock, item *models.RecepcionTransferenciaItemRequest, documento string, usuarioID uuid.UUID) error {
+	despachada := *d.CantidadDespachada
+	recibida := redondearCantidad(item.CantidadRecibida)
+	diferencia := redondearCantidad(recibida - despachada)
+	if diferencia != 0 && (item.MotivoDiferencia == nil || strings.TrimSpace(*item.MotivoDiferencia) == "") {
+		return &apiError{
+			status:  http.StatusBadRequest,
+			code:    "MOTIVO_DIFERENCIA_REQUERIDO",
+			message: "Las diferencias entre lo despachado y lo recibido requieren motivo",
+			details: models.JSONB{"producto_id": d.ProductoID, "cantidad_despachada": despachada, "cantidad_recibida": recibida},
+		}
+	}
+
+	// Las series se exigieron al despachar; la recepción solo concilia las despachadas
+	if len(d.NumerosSerie) > 0 {
+		if err := recibirSeriesTransferencia(ctx, tx, t, d, recibida, item.NumerosSerie, documento); err != nil {
+			return err
+		}
+	}
+	if err := recibirLotesTransferencia(ctx, tx, t, d, recibida, documento); err != nil {
+		return err
+	}
+
+	anterior, err := ingresarStockTransferencia(ctx, tx, d.ProductoID, t.SucursalDestinoID, despachada, recibida, d.CostoUnitario)
+	if err != nil {
+		return err
+	}
+
+	if recibida > 0 || diferencia != 0 {
+		err = registrarMovimientoStock(ctx, tx, "transferencia_entrada", d.ProductoID, t.SucursalDestinoID,
+			recibida, anterior, d.CostoUnitario, documento, usuarioID, *t.BatchID,
+			models.JSONB{
+				"transferencia_id":    t.ID,
+				"sucursal_origen_id":  t.SucursalOrigenID,
+				"cantidad_despachada": despachada,
+				"diferencia":          diferencia,
+				"motivo_diferencia":   item.MotivoDiferencia,
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE detalle_transferencias_stock
+		SET cantidad_recibida = $2, motivo_diferencia = $3
+		WHERE id = $1`,
+		d.ID, recibida, item.MotivoDiferencia,
+	)
+	if err != nil {
+		return fmt.Errorf("error actualizando detalle de transferencia: %w", err)
+	}
+	d.CantidadRecibida = &recibida
+	d.Diferencia = &diferencia
+	d.MotivoDiferencia = item.MotivoDiferencia
+	return nil
+}
+
+// recibirSeriesTransferencia deja disponibles en destino las series recibidas y da de baja las faltantes.
+// Si llegó todo lo despachado no es necesario informar las series
+func recibirSeriesTransferencia(ctx context.Context, tx *sql.Tx, t *models.TransferenciaStock, d *models.DetalleTransferenciaStock, recibida float64, series []string, documento string) error {
+	recibidas := normalizarSeries(series)
+	if len(recibidas) == 0 && recibida == float64(len(d.NumerosSerie)) {
+		recibidas = d.NumerosSerie
+	}
+
+	despachadas := make(map[string]bool, len(d.NumerosSerie))
+	for _, n := range d.NumerosSerie {
+		despachadas[n] = true
+	}
+	var ajenas []string
+	for _, n := range recibidas {
+		if !despachadas[n] {
+			ajenas = append(ajenas, n)
+		}
+	}
+	if float64(len(recibidas)) != recibida || len(ajenas) > 0 {
+		return &apiError{
+			status:  http.StatusBadRequest,
+			code:    "SERIES_RECEPCION_INVALIDAS",
+			message: "Se debe informar cada número de serie recibido, entre los despachados",
+			details: models.JSONB{"producto_id": d.ProductoID, "cantidad_recibida": recibida, "series_no_despachadas": ajenas},
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		UPDATE series_productos
+		SET estado = CASE WHEN numero_serie = ANY($4) THEN 'disponible' ELSE 'baja' END,
+			sucursal_id = CASE WHEN numero_serie = ANY($4) THEN $2 ELSE sucursal_id END,
+			documento_ingreso = CASE WHEN numero_serie = ANY($4) THEN $5 ELSE documento_ingreso END,
+			fecha_ingreso = CASE WHEN numero_serie = ANY($4) THEN NOW() ELSE fecha_ingreso END
+		WHERE producto_id = $1 AND numero_serie = ANY($3) AND estado = 'en_transito'`,
+		d.ProductoID, t.SucursalDestinoID, pq.Array(d.NumerosSerie), pq.Array(recibidas), documento,
+	)
+	if err != nil {
+		return fmt.Errorf("error recibiendo números de serie: %w", err)
+	}
+	return nil
+}
+
+// recibirLotesTransferencia ingresa en destino los lotes despachados hasta cubrir lo recibido; los faltantes
+// se descuentan de los últimos lotes del desglose y un sobrante queda fuera del control por lote
+func recibirLotesTransferencia(ctx context.Context, tx *sql.Tx, t *models.TransferenciaStock, d *models.DetalleTransferenciaStock, recibida float64, documento string) error {
+	pendiente := recibida
+	for _, l := range d.Lotes {
+		cantidad := math.Min(l.Cantidad, pendiente)
+		if cantidad <= 0 {
+			break
+		}
+		pendiente = redondearCantidad(pendiente - cantidad)
+
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO lotes_stock (
+				producto_id, sucursal_id, lote, fecha_vencimiento, cantidad_inicial, cantidad, documento_ingreso
+			) VALUES ($1, $2, $3, $4, $5, $5, $6)
+			ON CONFLICT (producto_id, sucursal_id, lote) DO UPDATE SET
+				cantidad_inicial = lotes_stock.cantidad_inicial + EXCLUDED.cantidad_inicial,
+				cantidad = lotes_stock.cantidad + EXCLUDED.cantidad`,
+			d.ProductoID, t.SucursalDestinoID, l.Lote, l.FechaVencimiento, cantidad, documento,
+		)
+		if err != nil {
+			return fmt.Errorf("error ingresando lote %s: %w", l.Lote, err)
+		}
+	}
+	return nil
+}
+
+// descontarStockTransferencia descuenta la cantidad del stock disponible de origen con control de concurrencia
+// optimista; devuelve la cantidad previa y el costo promedio con que sale la mercadería
+func descontarStockTransferencia(ctx context.Context, tx *sql.Tx, productoID, sucursalID uuid.UUID, cantidad float64) (float64, *float64, error) {
+	for intento := 0; intento < intentosDescuentoStock; intento++ {
+		var actual, disponible float64
+		var costo *float64
+		var version int
+		err := tx.QueryRowContext(ctx, `
+			SELECT cantidad, cantidad_disponible, costo_promedio, version_optimistic_lock
+			FROM stock_central
+			WHERE producto_id = $1 AND sucursal_id = $2`,
+			productoID, sucursalID,
+		).Scan(&actual, &disponible, &costo, &version)
+		if err != nil && err != sql.ErrNoRows {
+			return 0, nil, fmt.Errorf("error consultando stock: %w", err)
+		}
+
+		if err == sql.ErrNoRows || disponible < cantidad {
+			return 0, nil, &apiError{
+				status:  http.StatusConflict,
+				code:    "STOCK_INSUFICIENTE",
+				message: "Stock disponible insuficiente en la sucursal de origen",
+				details: models.JSONB{
+					"producto_id":         productoID,
+					"cantidad_solicitada": cantidad,
+					"cantidad_disponible": disponible,
+				},
+			}
+		}
+
+		result, err := tx.ExecContext(ctx, `
+			UPDATE stock_central
+			SET cantidad = cantidad - $3,
+				version_optimistic_lock = version_optimistic_lock + 1,
+				fecha_ultima_salida = NOW(),
+				fecha_sync = NOW()
+			WHERE producto_id = $1 AND sucursal_id = $2 AND version_optimistic_lock = $4`,
+			productoID, sucursalID, cantidad, version,
+		)
+		if err != nil {
+			return 0, nil, fmt.Errorf("error descontando stock: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 1 {
+			return actual, costo, nil
+		}
+	}
+
+	return 0, nil, errConflictoStock(productoID)
+}
+
+// ingresarStockTransferencia suma lo recibido al stock de destino ponderando el costo promedio y
+// libera lo despachado del stock en tránsito; devuelve la cantidad previa
+func ingresarStockTransferencia(ctx context.Context, tx *sql.Tx, productoID, sucursalID uuid.UUID, despachada, recibida float64, costo *float64) (float64, error) {
+	for intento := 0; intento < intentosDescuentoStock; intento++ {
+		var actual float64
+		var version int
+		err := tx.QueryRowContext(ctx, `
+			SELECT cantidad, version_optimistic_lock
+			FROM stock_central
+			WHERE producto_id = $1 AND sucursal_id = $2`,
+			productoID, sucursalID,
+		).Scan(&actual, &version)
+		if err != nil {
+			return 0, fmt.Errorf("error consultando stock en tránsito: %w", err)
+		}
+
+		result, err := tx.ExecContext(ctx, `
+			UPDATE stock_central
+			SET cantidad = cantidad + $3,
+				cantidad_en_transito = GREATEST(cantidad_en_transito - $4, 0),
+				costo_promedio = CASE
+					WHEN $5::numeric IS NULL OR $3 <= 0 THEN costo_promedio
+					WHEN costo_promedio IS NULL OR cantidad <= 0 THEN $5
+					ELSE ROUND((costo_promedio * cantidad + $5 * $3) / (cantidad + $3), 2)
+				END,
+				fecha_ultima_entrada = CASE WHEN $3 > 0 THEN NOW() ELSE fecha_ultima_entrada END,
+				version_optimistic_lock = version_optimistic_lock + 1,
+				fecha_sync = NOW()
+			WHERE producto_id = $1 AND sucursal_id = $2 AND version_optimistic_lock = $6`,
+			productoID, sucursalID, recibida, despachada, costo, version,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("error ingresando stock: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 1 {
+			return actual, nil
+		}
+	}
+
+	return 0, errConflictoStock(productoID)
+}
+
+// registrarMovimientoStock registra en movimientos_stock un movimiento agrupado por batch_id
+func registrarMovimientoStock(ctx context.Context, tx *sql.Tx, tipo string, productoID, sucursalID uuid.UUID, cantidad, anterior float64, costo *float64, documento string, usuarioID, batchID uuid.UUID, datos models.JSONB) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO movimientos_stock (
+			producto_id, sucursal_id, tipo_movimiento, cantidad, cantidad_anterior,
+			cantidad_nueva, costo_unitario, documento_referencia, usuario_id,
+			datos_adicionales, proceso_origen, batch_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 'maxima', $11)`,
+		productoID, sucursalID, tipo, cantidad, anterior, redondearCantidad(anterior+cantidad),
+		costo, documento, usuarioID, datos, batchID,
+	)
+	if err != nil {
+		return fmt.Errorf("error registrando movimiento de transferencia: %w", err)
+	}
+	return nil
+}
+
+// normalizarSeries limpia y ordena los números de serie descartando repetidos
+func normalizarSeries(series []string) []string {
+	vistos := make(map[string]bool, len(series))
+	var numeros []string
+	for _, s := range series {
+		n := strings.TrimSpace(s)
+		if n == "" || vistos[n] {
+			continue
+		}
+		vistos[n] = true
+		numeros = append(numeros, n)
+	}
+	sort.Strings(numeros)
+	return numeros
+}
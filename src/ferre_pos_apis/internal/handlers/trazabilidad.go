@@ -0,0 +1,809 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+const columnasSerie = `s.id, s.producto_id, s.sucursal_id, s.numero_serie, s.estado, s.documento_ingreso,
+	s.fecha_ingreso, s.usuario_ingreso_id, s.venta_id, s.detalle_venta_id, s.fecha_venta`
+
+const columnasLote = `l.id, l.producto_id, l.sucursal_id, l.lote, l.fecha_vencimiento, l.cantidad_inicial,
+	l.cantidad, l.documento_ingreso, l.fecha_ingreso`
+
+// TrazabilidadHandler handler para inventario por número de serie y lote
+type TrazabilidadHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+}
+
+// NewTrazabilidadHandler crea un nuevo handler de trazabilidad
+func NewTrazabilidadHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *TrazabilidadHandler {
+	return &TrazabilidadHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+	}
+}
+
+// RegistrarEntrada ingresa mercadería al stock de la sucursal registrando series y lotes
+func (h *TrazabilidadHandler) RegistrarEntrada(c *gin.Context) {
+	var req models.EntradaStockRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	var usuarioID *uuid.UUID
+	if uid, err := uuid.Parse(getUserID(c)); err == nil {
+		usuarioID = &uid
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	batchID := uuid.New()
+	resultado := make([]gin.H, 0, len(req.Items))
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		for i := range req.Items {
+			item := &req.Items[i]
+			cantidadNueva, err := registrarEntradaStock(ctx, tx, req.SucursalID, item, req.DocumentoReferencia, req.Observaciones, usuarioID, batchID)
+			if err != nil {
+				var apiErr *apiError
+				if errors.As(err, &apiErr) {
+					if apiErr.details == nil {
+						apiErr.details = models.JSONB{}
+					}
+					apiErr.details["item"] = i
+				}
+				return err
+			}
+			resultado = append(resultado, gin.H{
+				"producto_id":    item.ProductoID,
+				"cantidad":       item.Cantidad,
+				"cantidad_nueva": cantidadNueva,
+				"lote":           item.Lote,
+				"numeros_serie":  item.NumerosSerie,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error registrando entrada de stock")
+		return
+	}
+
+	h.logger.WithField("batch_id", batchID).
+		WithField("sucursal_id", req.SucursalID).
+		WithField("items", len(req.Items)).
+		Info("Entrada de stock registrada")
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"batch_id":             batchID,
+			"sucursal_id":          req.SucursalID,
+			"documento_referencia": req.DocumentoReferencia,
+			"items":                resultado,
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// ListSeries lista los números de serie de un producto, opcionalmente por sucursal y estado
+func (h *TrazabilidadHandler) ListSeries(c *gin.Context) {
+	productoID, ok := h.productoIDQuery(c)
+	if !ok {
+		return
+	}
+
+	query := `SELECT ` + columnasSerie + ` FROM series_productos s WHERE s.producto_id = $1`
+	args := []interface{}{productoID}
+	if sucursalID := c.Query("sucursal_id"); sucursalID != "" {
+		args = append(args, sucursalID)
+		query += ` AND s.sucursal_id = $` + strconv.Itoa(len(args))
+	}
+	if estado := c.Query("estado"); estado != "" {
+		args = append(args, estado)
+		query += ` AND s.estado = $` + strconv.Itoa(len(args))
+	}
+	query += ` ORDER BY s.fecha_ingreso DESC LIMIT 500`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		h.responderError(c, err, "Error listando números de serie")
+		return
+	}
+
+	series := []models.SerieProducto{}
+	err = database.ScanRows(rows, func() error {
+		s, err := scanSerie(rows)
+		if err != nil {
+			return err
+		}
+		series = append(series, *s)
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error listando números de serie")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      series,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// ListLotes lista los lotes con saldo de un producto en orden FEFO
+func (h *TrazabilidadHandler) ListLotes(c *gin.Context) {
+	productoID, ok := h.productoIDQuery(c)
+	if !ok {
+		return
+	}
+
+	query := `SELECT ` + columnasLote + ` FROM lotes_stock l WHERE l.producto_id = $1`
+	args := []interface{}{productoID}
+	if sucursalID := c.Query("sucursal_id"); sucursalID != "" {
+		args = append(args, sucursalID)
+		query += ` AND l.sucursal_id = $` + strconv.Itoa(len(args))
+	}
+	if c.Query("incluir_agotados") != "true" {
+		query += ` AND l.cantidad > 0`
+	}
+	query += ` ORDER BY l.fecha_vencimiento NULLS LAST, l.fecha_ingreso LIMIT 500`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lotes, err := listarLotes(ctx, h.db, query, args...)
+	if err != nil {
+		h.responderError(c, err, "Error listando lotes")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      lotes,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// SugerirFEFO sugiere de qué lotes tomar una cantidad, primero los de vencimiento más próximo
+func (h *TrazabilidadHandler) SugerirFEFO(c *gin.Context) {
+	productoID, ok := h.productoIDQuery(c)
+	if !ok {
+		return
+	}
+
+	sucursalID, err := uuid.Parse(getSucursalID(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_SUCURSAL_ID",
+				Message: "ID de sucursal inválido",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	cantidad, err := strconv.ParseFloat(c.Query("cantidad"), 64)
+	if err != nil || cantidad <= 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_CANTIDAD",
+				Message: "La cantidad debe ser un número mayor a cero",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lotes, err := lotesDisponibles(ctx, h.db, productoID, sucursalID, false)
+	if err != nil {
+		h.responderError(c, err, "Error consultando lotes")
+		return
+	}
+
+	sugeridos, cubierta := repartirFEFO(lotes, cantidad)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: models.SugerenciaFEFO{
+			ProductoID:         productoID,
+			SucursalID:         sucursalID,
+			CantidadSolicitada: cantidad,
+			CantidadCubierta:   cubierta,
+			Lotes:              sugeridos,
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetGarantia obtiene la venta en que salió un número de serie para atender reclamos de garantía
+func (h *TrazabilidadHandler) GetGarantia(c *gin.Context) {
+	numeroSerie := strings.TrimSpace(c.Param("numero"))
+
+	query := `
+		SELECT ` + columnasSerie + `, p.codigo_interno, p.descripcion,
+			v.numero_venta, v.fecha, v.tipo_documento, v.estado, v.cliente_rut, v.cliente_nombre,
+			dv.precio_final
+		FROM series_productos s
+		JOIN productos p ON p.id = s.producto_id
+		LEFT JOIN ventas v ON v.id = s.venta_id
+		LEFT JOIN detalle_ventas dv ON dv.id = s.detalle_venta_id
+		WHERE s.numero_serie = $1`
+	args := []interface{}{numeroSerie}
+	if productoID := c.Query("producto_id"); productoID != "" {
+		args = append(args, productoID)
+		query += ` AND s.producto_id = $2`
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		h.responderError(c, err, "Error consultando número de serie")
+		return
+	}
+
+	// El mismo número de serie puede existir en productos de distintos fabricantes
+	garantias := []models.GarantiaSerie{}
+	err = database.ScanRows(rows, func() error {
+		var g models.GarantiaSerie
+		s := &g.Serie
+		if err := rows.Scan(&s.ID, &s.ProductoID, &s.SucursalID, &s.NumeroSerie, &s.Estado,
+			&s.DocumentoIngreso, &s.FechaIngreso, &s.UsuarioIngresoID, &s.VentaID, &s.DetalleVentaID,
+			&s.FechaVenta, &g.ProductoCodigo, &g.ProductoDescripcion, &g.NumeroVenta, &g.FechaVenta,
+			&g.TipoDocumento, &g.EstadoVenta, &g.ClienteRUT, &g.ClienteNombre, &g.PrecioFinal); err != nil {
+			return err
+		}
+		garantias = append(garantias, g)
+		return nil
+	})
+	if err != nil {
+		h.responderError(c, err, "Error consultando número de serie")
+		return
+	}
+
+	if len(garantias) == 0 {
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "SERIE_NOT_FOUND",
+				Message: "Número de serie no registrado",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      garantias,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Métodos auxiliares
+
+// productoIDQuery obtiene el ID de producto obligatorio de la query
+func (h *TrazabilidadHandler) productoIDQuery(c *gin.Context) (uuid.UUID, bool) {
+	productoID, err := uuid.Parse(c.Query("producto_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "INVALID_PRODUCT_ID",
+				Message: "ID de producto inválido",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return uuid.Nil, false
+	}
+	return productoID, true
+}
+
+// responderError responde errores de negocio, series duplicadas o errores internos
+func (h *TrazabilidadHandler) responderError(c *gin.Context, err error, mensaje string) {
+	if esViolacionUnicidad(err) {
+		err = &apiError{
+			status:  http.StatusConflict,
+			code:    "SERIE_DUPLICADA",
+			message: "El número de serie ya está registrado para el producto",
+		}
+	}
+
+	responderError(c, h.logger, err, "TRAZABILIDAD_ERROR", mensaje)
+}
+
+// scanSerie lee un número de serie con las columnas de columnasSerie
+func scanSerie(row interface{ Scan(...interface{}) error }) (*models.SerieProducto, error) {
+	var s models.SerieProducto
+	err := row.Scan(&s.ID, &s.ProductoID, &s.SucursalID, &s.NumeroSerie, &s.Estado, &s.DocumentoIngreso,
+		&s.FechaIngreso, &s.UsuarioIngresoID, &s.VentaID, &s.DetalleVentaID, &s.FechaVenta)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// listarLotes ejecuta una consulta que selecciona columnasLote
+func listarLotes(ctx context.Context, q sqlQueryer, query string, args ...interface{}) ([]models.LoteStock, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando lotes: %w", err)
+	}
+
+	lotes := []models.LoteStock{}
+	err = database.ScanRows(rows, func() error {
+		var l models.LoteStock
+		if err := rows.Scan(&l.ID, &l.ProductoID, &l.SucursalID, &l.Lote, &l.FechaVencimiento,
+			&l.CantidadInicial, &l.Cantidad, &l.DocumentoIngreso, &l.FechaIngreso); err != nil {
+			return err
+		}
+		lotes = append(lotes, l)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo lotes: %w", err)
+	}
+	return lotes, nil
+}
+
+// lotesDisponibles obtiene los lotes no vencidos con saldo en orden FEFO; bloquear los reserva para la transacción
+func lotesDisponibles(ctx context.Context, q sqlQueryer, productoID, sucursalID uuid.UUID, bloquear bool) ([]models.LoteStock, error) {
+	query := `
+		SELECT ` + columnasLote + `
+		FROM lotes_stock l
+		WHERE l.producto_id = $1 AND l.sucursal_id = $2 AND l.cantidad > 0
+			AND (l.fecha_vencimiento IS NULL OR l.fecha_vencimiento >= CURRENT_DATE)
+		ORDER BY l.fecha_vencimiento NULLS LAST, l.fecha_ingreso`
+	if bloquear {
+		query += ` FOR UPDATE`
+	}
+	return listarLotes(ctx, q, query, productoID, sucursalID)
+}
+
+// repartirFEFO toma la cantidad de los lotes en el orden recibido y devuelve lo que logró cubrir
+func repartirFEFO(lotes []models.LoteStock, cantidad float64) ([]models.SugerenciaLote, float64) {
+	sugeridos := []models.SugerenciaLote{}
+	pendiente := cantidad
+	for _, l := range lotes {
+		if pendiente <= 0 {
+			break
+		}
+		tomar := math.Min(l.Cantidad, pendiente)
+		sugeridos = append(sugeridos, models.SugerenciaLote{LoteStock: l, CantidadSugerida: tomar})
+		pendiente -= tomar
+	}
+	return sugeridos, cantidad - math.Max(pendiente, 0)
+}
+
+// registrarEntradaStock suma el ingreso al stock de la sucursal con su movimiento, series y lote.
+// Devuelve la cantidad resultante en stock.
+func registrarEntradaStock(ctx context.Context, tx *sql.Tx, sucursalID uuid.UUID, item *models.EntradaStockItemRequest, documento, observaciones *string, usuarioID *uuid.UUID, batchID uuid.UUID) (float64, error) {
+	var requiereSerie, permiteFraccion bool
+	err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(requiere_serie, false), COALESCE(permite_fraccionamiento, false) FROM productos WHERE id = $1 AND activo = true`,
+		item.ProductoID,
+	).Scan(&requiereSerie, &permiteFraccion)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, &apiError{
+				status:  http.StatusBadRequest,
+				code:    "PRODUCT_NOT_FOUND",
+				message: "Producto no encontrado o inactivo",
+				details: models.JSONB{"producto_id": item.ProductoID},
+			}
+		}
+		return 0, fmt.Errorf("error consultando producto: %w", err)
+	}
+
+	if !permiteFraccion && !esEntero(item.Cantidad) {
+		return 0, &apiError{
+			status:  http.StatusBadRequest,
+			code:    "CANTIDAD_FRACCIONADA_NO_PERMITIDA",
+			message: "El producto no permite cantidades fraccionadas",
+			details: models.JSONB{"producto_id": item.ProductoID, "cantidad": item.Cantidad},
+		}
+	}
+	if requiereSerie && float64(len(item.NumerosSerie)) != item.Cantidad {
+		return 0, &apiError{
+			status:  http.StatusBadRequest,
+			code:    "SERIES_NO_COINCIDEN",
+			message: "Se debe informar un número de serie por cada unidad ingresada",
+			details: models.JSONB{"producto_id": item.ProductoID, "cantidad": item.Cantidad, "series": len(item.NumerosSerie)},
+		}
+	}
+	if !requiereSerie && len(item.NumerosSerie) > 0 {
+		return 0, &apiError{
+			status:  http.StatusBadRequest,
+			code:    "SERIE_NO_APLICA",
+			message: "El producto no se controla por número de serie",
+			details: models.JSONB{"producto_id": item.ProductoID},
+		}
+	}
+	if item.FechaVencimiento != nil && item.Lote == nil {
+		return 0, &apiError{
+			status:  http.StatusBadRequest,
+			code:    "LOTE_REQUERIDO",
+			message: "La fecha de vencimiento se registra por lote",
+			details: models.JSONB{"producto_id": item.ProductoID},
+		}
+	}
+
+	// El costo promedio se pondera con el stock previo; sin costo informado se mantiene
+	var cantidadNueva float64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO stock_central (producto_id, sucursal_id, cantidad, costo_promedio, fecha_ultima_entrada)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (producto_id, sucursal_id) DO UPDATE SET
+			cantidad = stock_central.cantidad + EXCLUDED.cantidad,
+			costo_promedio = CASE
+				WHEN EXCLUDED.costo_promedio IS NULL THEN stock_central.costo_promedio
+				WHEN stock_central.costo_promedio IS NULL OR stock_central.cantidad <= 0 THEN EXCLUDED.costo_promedio
+				ELSE ROUND((stock_central.costo_promedio * stock_central.cantidad + EXCLUDED.costo_promedio * EXCLUDED.cantidad)
+					/ (stock_central.cantidad + EXCLUDED.cantidad), 2)
+			END,
+			fecha_ultima_entrada = NOW(),
+			version_optimistic_lock = stock_central.version_optimistic_lock + 1,
+			fecha_sync = NOW()
+		RETURNING cantidad`,
+		item.ProductoID, sucursalID, item.Cantidad, item.CostoUnitario,
+	).Scan(&cantidadNueva)
+	if err != nil {
+		return 0, fmt.Errorf("error actualizando stock: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO movimientos_stock (
+			producto_id, sucursal_id, tipo_movimiento, cantidad, cantidad_anterior,
+			cantidad_nueva, costo_unitario, documento_referencia, usuario_id, observaciones,
+			datos_adicionales, proceso_origen, batch_id
+		) VALUES ($1, $2, 'entrada', $3, $4, $5, $6, $7, $8, $9, $10, 'maxima', $11)`,
+		item.ProductoID, sucursalID, item.Cantidad, cantidadNueva-item.Cantidad, cantidadNueva,
+		item.CostoUnitario, documento, usuarioID, observaciones,
+		models.JSONB{"lote": item.Lote, "numeros_serie": item.NumerosSerie}, batchID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error registrando movimiento de entrada: %w", err)
+	}
+
+	for _, numero := range item.NumerosSerie {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO series_productos (
+				producto_id, sucursal_id, numero_serie, documento_ingreso, usuario_ingreso_id
+			) VALUES ($1, $2, $3, $4, $5)`,
+			item.ProductoID, sucursalID, strings.TrimSpace(numero), documento, usuarioID,
+		)
+		if err != nil {
+			if esViolacionUnicidad(err) {
+				return 0, &apiError{
+					status:  http.StatusConflict,
+					code:    "SERIE_DUPLICADA",
+					message: "El número de serie ya está registrado para el producto",
+					details: models.JSONB{"producto_id": item.ProductoID, "numero_serie": numero},
+				}
+			}
+			return 0, fmt.Errorf("error registrando número de serie: %w", err)
+		}
+	}
+
+	if item.Lote != nil {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO lotes_stock (
+				producto_id, sucursal_id, lote, fecha_vencimiento, cantidad_inicial, cantidad, documento_ingreso
+			) VALUES ($1, $2, $3, $4, $5, $5, $6)
+			ON CONFLICT (producto_id, sucursal_id, lote) DO UPDATE SET
+				cantidad_inicial = lotes_stock.cantidad_inicial + EXCLUDED.cantidad_inicial,
+				cantidad = lotes_stock.cantidad + EXCLUDED.cantidad,
+				fecha_vencimiento = COALESCE(EXCLUDED.fecha_vencimiento, lotes_stock.fecha_vencimiento)`,
+			item.ProductoID, sucursalID, strings.TrimSpace(*item.Lote), item.FechaVencimiento, item.Cantidad, documento,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("error registrando lote: %w", err)
+		}
+	}
+
+	return cantidadNueva, nil
+}
+
+// asignarTrazabilidadVenta valida la serie vendida y descuenta la línea de sus lotes, por FEFO si no se indicó lote
+func asignarTrazabilidadVenta(ctx context.Context, tx *sql.Tx, venta *models.Venta, detalle *models.DetalleVenta) error {
+	var requiereSerie bool
+	err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(requiere_serie, false) FROM productos WHERE id = $1`,
+		detalle.ProductoID,
+	).Scan(&requiereSerie)
+	if err == sql.ErrNoRows {
+		// El producto inexistente se informa al insertar el detalle
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error consultando producto: %w", err)
+	}
+
+	if requiereSerie {
+		if err := venderSerie(ctx, tx, venta, detalle); err != nil {
+			return err
+		}
+	}
+
+	return descontarLotesVenta(ctx, tx, venta, detalle)
+}
+
+// venderSerie marca como vendida la serie de la línea, que debe estar disponible en la sucursal
+func venderSerie(ctx context.Context, tx *sql.Tx, venta *models.Venta, detalle *models.DetalleVenta) error {
+	if detalle.NumeroSerie == nil || strings.TrimSpace(*detalle.NumeroSerie) == "" {
+		return &apiError{
+			status:  http.StatusBadRequest,
+			code:    "SERIE_REQUERIDA",
+			message: "El producto requiere número de serie",
+			details: models.JSONB{"producto_id": detalle.ProductoID},
+		}
+	}
+	if math.Abs(detalle.Cantidad*detalle.FactorConversion-1) > toleranciaFraccion {
+		return &apiError{
+			status:  http.StatusBadRequest,
+			code:    "SERIE_CANTIDAD_INVALIDA",
+			message: "Los productos con número de serie se venden de a una unidad por línea",
+			details: models.JSONB{"producto_id": detalle.ProductoID, "cantidad": detalle.Cantidad},
+		}
+	}
+
+	numero := strings.TrimSpace(*detalle.NumeroSerie)
+	res, err := tx.ExecContext(ctx, `
+		UPDATE series_productos
+		SET estado = 'vendida', venta_id = $4, detalle_venta_id = $5, fecha_venta = $6
+		WHERE producto_id = $1 AND sucursal_id = $2 AND numero_serie = $3 AND estado = 'disponible'`,
+		detalle.ProductoID, venta.SucursalID, numero, venta.ID, detalle.ID, venta.Fecha,
+	)
+	if err != nil {
+		return fmt.Errorf("error registrando venta de número de serie: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error registrando venta de número de serie: %w", err)
+	} else if n == 0 {
+		return &apiError{
+			status:  http.StatusConflict,
+			code:    "SERIE_NO_DISPONIBLE",
+			message: "El número de serie no está disponible en la sucursal",
+			details: models.JSONB{"producto_id": detalle.ProductoID, "numero_serie": numero},
+		}
+	}
+	detalle.NumeroSerie = &numero
+
+	return nil
+}
+
+// descontarLotesVenta consume la cantidad de la línea desde el lote indicado o, sin lote, desde los de
+// vencimiento más próximo. Los productos sin lotes registrados en la sucursal no se controlan por lote.
+func descontarLotesVenta(ctx context.Context, tx *sql.Tx, venta *models.Venta, detalle *models.DetalleVenta) error {
+	cantidad := detalle.Cantidad * detalle.FactorConversion
+
+	var asignados []models.SugerenciaLote
+	if detalle.Lote != nil {
+		lote := strings.TrimSpace(*detalle.Lote)
+		lotes, err := listarLotes(ctx, tx, `
+			SELECT `+columnasLote+`
+			FROM lotes_stock l
+			WHERE l.producto_id = $1 AND l.sucursal_id = $2 AND l.lote = $3
+			FOR UPDATE`,
+			detalle.ProductoID, venta.SucursalID, lote,
+		)
+		if err != nil {
+			return err
+		}
+		if len(lotes) == 0 {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "LOTE_NO_ENCONTRADO",
+				message: "El lote no está registrado en la sucursal",
+				details: models.JSONB{"producto_id": detalle.ProductoID, "lote": lote},
+			}
+		}
+		l := lotes[0]
+		if l.FechaVencimiento != nil && l.FechaVencimiento.Before(venta.Fecha.Truncate(24*time.Hour)) {
+			return &apiError{
+				status:  http.StatusConflict,
+				code:    "LOTE_VENCIDO",
+				message: "El lote está vencido",
+				details: models.JSONB{"producto_id": detalle.ProductoID, "lote": lote, "fecha_vencimiento": l.FechaVencimiento},
+			}
+		}
+		if l.Cantidad < cantidad {
+			return &apiError{
+				status:  http.StatusConflict,
+				code:    "LOTE_INSUFICIENTE",
+				message: "El lote no tiene saldo suficiente",
+				details: models.JSONB{"producto_id": detalle.ProductoID, "lote": lote, "cantidad_disponible": l.Cantidad},
+			}
+		}
+		asignados = []models.SugerenciaLote{{LoteStock: l, CantidadSugerida: cantidad}}
+	} else {
+		lotes, err := lotesDisponibles(ctx, tx, detalle.ProductoID, venta.SucursalID, true)
+		if err != nil {
+			return err
+		}
+		asignados, _ = repartirFEFO(lotes, cantidad)
+	}
+	if len(asignados) == 0 {
+		return nil
+	}
+
+	for _, a := range asignados {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE lotes_stock SET cantidad = cantidad - $2 WHERE id = $1`,
+			a.ID, a.CantidadSugerida,
+		)
+		if err != nil {
+			return fmt.Errorf("error descontando lote: %w", err)
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO lotes_venta (lote_id, venta_id, detalle_venta_id, cantidad)
+			VALUES ($1, $2, $3, $4)`,
+			a.ID, venta.ID, detalle.ID, a.CantidadSugerida,
+		)
+		if err != nil {
+			return fmt.Errorf("error registrando lote vendido: %w", err)
+		}
+	}
+
+	// La línea guarda el primer lote; si abarca varios, el desglose queda en datos_adicionales
+	detalle.Lote = &asignados[0].Lote
+	detalle.FechaVencimiento = asignados[0].FechaVencimiento
+	if len(asignados) > 1 {
+		desglose := make([]models.JSONB, len(asignados))
+		for i, a := range asignados {
+			desglose[i] = models.JSONB{"lote": a.Lote, "fecha_vencimiento": a.FechaVencimiento, "cantidad": a.CantidadSugerida}
+		}
+		if detalle.DatosAdicionales == nil {
+			detalle.DatosAdicionales = models.JSONB{}
+		}
+		detalle.DatosAdicionales["lotes"] = desglose
+	}
+
+	return nil
+}
+
+// revertirTrazabilidadVenta deja disponibles las series vendidas y devuelve a sus lotes lo consumido por la venta
+func revertirTrazabilidadVenta(ctx context.Context, tx *sql.Tx, ventaID uuid.UUID) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE series_productos
+		SET estado = 'disponible', venta_id = NULL, detalle_venta_id = NULL, fecha_venta = NULL
+		WHERE venta_id = $1 AND estado = 'vendida'`,
+		ventaID,
+	)
+	if err != nil {
+		return fmt.Errorf("error liberando números de serie: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE lotes_stock l
+		SET cantidad = l.cantidad + lv.cantidad
+		FROM (
+			SELECT lote_id, SUM(cantidad) AS cantidad
+			FROM lotes_venta
+			WHERE venta_id = $1
+			GROUP BY lote_id
+		) lv
+		WHERE l.id = lv.lote_id`,
+		ventaID,
+	)
+	if err != nil {
+		return fmt.Errorf("error restaurando lotes: %w", err)
+	}
+
+	return nil
+}
+
+// devolverTrazabilidadLinea deja disponible la serie de la línea devuelta y reintegra la cantidad,
+// en unidad base, a los lotes desde los que se vendió
+func devolverTrazabilidadLinea(ctx context.Context, tx *sql.Tx, detalleVentaID uuid.UUID, cantidad float64) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE series_productos
+		SET estado = 'disponible', venta_id = NULL, detalle_venta_id = NULL, fecha_venta = NULL
+		WHERE detalle_venta_id = $1 AND estado = 'vendida'`,
+		detalleVentaID,
+	)
+	if err != nil {
+		return fmt.Errorf("error liberando número de serie: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, lote_id, cantidad
+		FROM lotes_venta
+		WHERE detalle_venta_id = $1
+		ORDER BY fecha DESC
+		FOR UPDATE`,
+		detalleVentaID,
+	)
+	if err != nil {
+		return fmt.Errorf("error consultando lotes vendidos: %w", err)
+	}
+
+	type loteVendido struct {
+		id, loteID uuid.UUID
+		cantidad   float64
+	}
+	var vendidos []loteVendido
+	err = database.ScanRows(rows, func() error {
+		var lv loteVendido
+		if err := rows.Scan(&lv.id, &lv.loteID, &lv.cantidad); err != nil {
+			return err
+		}
+		vendidos = append(vendidos, lv)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error leyendo lotes vendidos: %w", err)
+	}
+
+	for _, lv := range vendidos {
+		if cantidad <= toleranciaFraccion {
+			break
+		}
+
+		devuelta := math.Min(cantidad, lv.cantidad)
+		if lv.cantidad-devuelta <= toleranciaFraccion {
+			_, err = tx.ExecContext(ctx, `DELETE FROM lotes_venta WHERE id = $1`, lv.id)
+		} else {
+			_, err = tx.ExecContext(ctx,
+				`UPDATE lotes_venta SET cantidad = cantidad - $2 WHERE id = $1`,
+				lv.id, devuelta,
+			)
+		}
+		if err != nil {
+			return fmt.Errorf("error actualizando lote vendido: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE lotes_stock SET cantidad = cantidad + $2 WHERE id = $1`,
+			lv.loteID, devuelta,
+		)
+		if err != nil {
+			return fmt.Errorf("error restaurando lote: %w", err)
+		}
+		cantidad -= devuelta
+	}
+
+	return nil
+}
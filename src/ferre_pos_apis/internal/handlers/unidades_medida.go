@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+// toleranciaFraccion margen para considerar entera una cantidad convertida (NUMERIC(10,3) por factor)
+const toleranciaFraccion = 1e-6
+
+// UnidadesMedidaHandler handler para las unidades de venta de los productos
+type UnidadesMedidaHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+}
+
+// NewUnidadesMedidaHandler crea un nuevo handler de unidades de medida
+func NewUnidadesMedidaHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *UnidadesMedidaHandler {
+	return &UnidadesMedidaHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+	}
+}
+
+// List obtiene la unidad base y las unidades de venta activas de un producto
+func (h *UnidadesMedidaHandler) List(c *gin.Context) {
+	productoID, ok := uuidParam(c, "id", "INVALID_PRODUCT_ID", "ID de producto inválido")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	unidades, err := getUnidadesProducto(ctx, h.db, productoID)
+	if err != nil {
+		h.responderError(c, err, "Error consultando unidades de medida")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      unidades,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Update reemplaza las unidades de venta de un producto; las omitidas quedan inactivas
+func (h *UnidadesMedidaHandler) Update(c *gin.Context) {
+	productoID, ok := uuidParam(c, "id", "INVALID_PRODUCT_ID", "ID de producto inválido")
+	if !ok {
+		return
+	}
+
+	var req models.UnidadesMedidaRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var unidades *models.UnidadesProducto
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		var unidadBase string
+		var permiteFraccion bool
+		err := tx.QueryRowContext(ctx,
+			`SELECT unidad_medida, COALESCE(permite_fraccionamiento, false) FROM productos WHERE id = $1 FOR UPDATE`,
+			productoID,
+		).Scan(&unidadBase, &permiteFraccion)
+		if err != nil {
+			return err
+		}
+
+		vigentes := make([]string, 0, len(req.Unidades))
+		for i, u := range req.Unidades {
+			unidad := normalizarUnidad(u.Unidad)
+			if unidad == normalizarUnidad(unidadBase) {
+				return &apiError{
+					status:  http.StatusBadRequest,
+					code:    "UNIDAD_MEDIDA_INVALIDA",
+					message: "La unidad base del producto no se configura como unidad alternativa",
+					details: models.JSONB{"item": i, "unidad": unidad},
+				}
+			}
+			for _, v := range vigentes {
+				if v == unidad {
+					return &apiError{
+						status:  http.StatusBadRequest,
+						code:    "UNIDAD_MEDIDA_DUPLICADA",
+						message: "La unidad de medida está repetida",
+						details: models.JSONB{"item": i, "unidad": unidad},
+					}
+				}
+			}
+			// Sin fraccionamiento una presentación debe equivaler a unidades base enteras
+			if !permiteFraccion && !esEntero(u.FactorConversion) {
+				return &apiError{
+					status:  http.StatusBadRequest,
+					code:    "CANTIDAD_FRACCIONADA_NO_PERMITIDA",
+					message: "El producto no permite fraccionamiento; el factor de conversión debe ser entero",
+					details: models.JSONB{"item": i, "unidad": unidad, "factor_conversion": u.FactorConversion},
+				}
+			}
+			vigentes = append(vigentes, unidad)
+
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO unidades_medida_producto (
+					producto_id, unidad, factor_conversion, codigo_barra, precio_unitario, activo
+				) VALUES ($1, $2, $3, $4, $5, true)
+				ON CONFLICT (producto_id, unidad) DO UPDATE SET
+					factor_conversion = EXCLUDED.factor_conversion,
+					codigo_barra = EXCLUDED.codigo_barra,
+					precio_unitario = EXCLUDED.precio_unitario,
+					activo = true,
+					fecha_modificacion = NOW()`,
+				productoID, unidad, u.FactorConversion, u.CodigoBarra, u.PrecioUnitario,
+			)
+			if err != nil {
+				return fmt.Errorf("error guardando unidad de medida: %w", err)
+			}
+		}
+
+		// Las unidades ya usadas en ventas se conservan inactivas para el historial
+		_, err = tx.ExecContext(ctx, `
+			UPDATE unidades_medida_producto
+			SET activo = false, fecha_modificacion = NOW()
+			WHERE producto_id = $1 AND activo = true AND NOT (unidad = ANY($2::text[]))`,
+			productoID, pq.Array(vigentes),
+		)
+		if err != nil {
+			return fmt.Errorf("error desactivando unidades de medida: %w", err)
+		}
+
+		unidades, err = getUnidadesProducto(ctx, tx, productoID)
+		return err
+	})
+	if err != nil {
+		h.responderError(c, err, "Error guardando unidades de medida")
+		return
+	}
+
+	h.logger.WithField("producto_id", productoID).
+		WithField("unidades", len(unidades.Unidades)).
+		Info("Unidades de medida actualizadas")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      unidades,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Métodos auxiliares
+
+// responderError responde errores de negocio, producto inexistente, código de barras duplicado o errores internos
+func (h *UnidadesMedidaHandler) responderError(c *gin.Context, err error, mensaje string) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		err = &apiError{
+			status:  http.StatusNotFound,
+			code:    "PRODUCT_NOT_FOUND",
+			message: "Producto no encontrado",
+		}
+	case esViolacionUnicidad(err):
+		err = &apiError{
+			status:  http.StatusConflict,
+			code:    "CODIGO_BARRA_DUPLICADO",
+			message: "El código de barras ya está asignado a otra unidad de medida",
+		}
+	}
+
+	responderError(c, h.logger, err, "UNIDADES_MEDIDA_ERROR", mensaje)
+}
+
+// getUnidadesProducto obtiene la unidad base del producto y sus unidades de venta activas
+func getUnidadesProducto(ctx context.Context, q sqlQueryer, productoID uuid.UUID) (*models.UnidadesProducto, error) {
+	unidades := &models.UnidadesProducto{ProductoID: productoID, Unidades: []models.UnidadMedidaProducto{}}
+	err := q.QueryRowContext(ctx,
+		`SELECT unidad_medida, COALESCE(permite_fraccionamiento, false) FROM productos WHERE id = $1`,
+		productoID,
+	).Scan(&unidades.UnidadBase, &unidades.PermiteFraccionamiento)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, producto_id, unidad, factor_conversion, codigo_barra, precio_unitario,
+			activo, fecha_creacion, fecha_modificacion
+		FROM unidades_medida_producto
+		WHERE producto_id = $1 AND activo = true
+		ORDER BY factor_conversion`,
+		productoID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando unidades de medida: %w", err)
+	}
+	err = database.ScanRows(rows, func() error {
+		var u models.UnidadMedidaProducto
+		if err := rows.Scan(&u.ID, &u.ProductoID, &u.Unidad, &u.FactorConversion, &u.CodigoBarra,
+			&u.PrecioUnitario, &u.Activo, &u.FechaCreacion, &u.FechaModificacion); err != nil {
+			return err
+		}
+		unidades.Unidades = append(unidades.Unidades, u)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo unidades de medida: %w", err)
+	}
+
+	return unidades, nil
+}
+
+// resolverUnidadesVenta asigna el factor de conversión a cada línea según su unidad de venta
+// y rechaza unidades no configuradas o cantidades fraccionadas en productos que no lo permiten
+func resolverUnidadesVenta(ctx context.Context, q sqlQueryer, detalles []models.DetalleVenta) error {
+	if len(detalles) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(detalles))
+	for i := range detalles {
+		ids[i] = detalles[i].ProductoID.String()
+	}
+
+	productos := make(map[uuid.UUID]*unidadesProducto, len(ids))
+	rows, err := q.QueryContext(ctx,
+		`SELECT id, unidad_medida, COALESCE(permite_fraccionamiento, false) FROM productos WHERE id = ANY($1::uuid[])`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return fmt.Errorf("error consultando unidades de productos: %w", err)
+	}
+	err = database.ScanRows(rows, func() error {
+		var id uuid.UUID
+		p := &unidadesProducto{factores: make(map[string]float64)}
+		if err := rows.Scan(&id, &p.base, &p.permiteFraccion); err != nil {
+			return err
+		}
+		productos[id] = p
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error leyendo unidades de productos: %w", err)
+	}
+
+	rows, err = q.QueryContext(ctx, `
+		SELECT producto_id, unidad, factor_conversion
+		FROM unidades_medida_producto
+		WHERE producto_id = ANY($1::uuid[]) AND activo = true`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return fmt.Errorf("error consultando unidades de medida: %w", err)
+	}
+	err = database.ScanRows(rows, func() error {
+		var productoID uuid.UUID
+		var unidad string
+		var factor float64
+		if err := rows.Scan(&productoID, &unidad, &factor); err != nil {
+			return err
+		}
+		if p, ok := productos[productoID]; ok {
+			p.factores[unidad] = factor
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error leyendo unidades de medida: %w", err)
+	}
+
+	return asignarUnidadesVenta(detalles, productos)
+}
+
+// unidadesProducto unidad base, fraccionamiento y factores de las unidades de venta activas de un producto
+type unidadesProducto struct {
+	base            string
+	permiteFraccion bool
+	factores        map[string]float64
+}
+
+// asignarUnidadesVenta valida la unidad y la cantidad de cada línea contra las unidades del producto
+// y fija su factor de conversión. Las líneas en unidad base quedan sin unidad de venta
+func asignarUnidadesVenta(detalles []models.DetalleVenta, productos map[uuid.UUID]*unidadesProducto) error {
+	for i := range detalles {
+		d := &detalles[i]
+		d.FactorConversion = 1
+
+		// Los productos inexistentes se informan al insertar el detalle, pero sin unidades configuradas
+		// ninguna unidad de venta tiene conversión
+		p, ok := productos[d.ProductoID]
+		if !ok {
+			if d.UnidadMedida != nil {
+				return &apiError{
+					status:  http.StatusBadRequest,
+					code:    "UNIDAD_NO_VALIDA",
+					message: "La unidad de medida no está configurada para el producto",
+					details: models.JSONB{"item": i, "producto_id": d.ProductoID, "unidad_medida": normalizarUnidad(*d.UnidadMedida)},
+				}
+			}
+			continue
+		}
+
+		if d.UnidadMedida != nil {
+			unidad := normalizarUnidad(*d.UnidadMedida)
+			if unidad == normalizarUnidad(p.base) {
+				d.UnidadMedida = nil
+			} else if factor, ok := p.factores[unidad]; ok {
+				d.UnidadMedida = &unidad
+				d.FactorConversion = factor
+			} else {
+				disponibles := []string{p.base}
+				for u := range p.factores {
+					disponibles = append(disponibles, u)
+				}
+				sort.Strings(disponibles[1:])
+				return &apiError{
+					status:  http.StatusBadRequest,
+					code:    "UNIDAD_NO_VALIDA",
+					message: "La unidad de medida no está configurada para el producto",
+					details: models.JSONB{
+						"item":                 i,
+						"producto_id":          d.ProductoID,
+						"unidad_medida":        unidad,
+						"unidades_disponibles": disponibles,
+					},
+				}
+			}
+		}
+
+		if !p.permiteFraccion && (!esEntero(d.Cantidad) || !esEntero(d.Cantidad*d.FactorConversion)) {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "CANTIDAD_FRACCIONADA_NO_PERMITIDA",
+				message: "El producto no permite vender cantidades fraccionadas",
+				details: models.JSONB{"item": i, "producto_id": d.ProductoID, "cantidad": d.Cantidad},
+			}
+		}
+	}
+
+	return nil
+}
+
+// normalizarUnidad normaliza el código de unidad para compararlo sin distinguir mayúsculas
+func normalizarUnidad(unidad string) string {
+	return strings.ToUpper(strings.TrimSpace(unidad))
+}
+
+// esEntero indica si la cantidad no tiene parte fraccionaria
+func esEntero(cantidad float64) bool {
+	return math.Abs(cantidad-math.Round(cantidad)) < toleranciaFraccion
+}
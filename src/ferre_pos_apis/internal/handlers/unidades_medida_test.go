@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ferre_pos_apis/internal/models"
+)
+
+func TestAsignarUnidadesVenta(t *testing.T) {
+	tornillo := uuid.New()
+	cable := uuid.New()
+	productos := map[uuid.UUID]*unidadesProducto{
+		tornillo: {base: "UN", factores: map[string]float64{"CAJA": 100, "BOLSA": 2.5}},
+		cable:    {base: "M", permiteFraccion: true, factores: map[string]float64{"ROLLO": 50}},
+	}
+	unidad := func(u string) *string { return &u }
+
+	tests := []struct {
+		name     string
+		detalle  models.DetalleVenta
+		code     string
+		unidad   *string
+		factor   float64
+		unidades float64
+	}{
+		{
+			name:     "sin unidad se vende en la base",
+			detalle:  models.DetalleVenta{ProductoID: tornillo, Cantidad: 7},
+			factor:   1,
+			unidades: 7,
+		},
+		{
+			name:     "unidad base informada queda sin unidad de venta",
+			detalle:  models.DetalleVenta{ProductoID: tornillo, Cantidad: 7, UnidadMedida: unidad(" un ")},
+			factor:   1,
+			unidades: 7,
+		},
+		{
+			name:     "caja convierte a unidades base",
+			detalle:  models.DetalleVenta{ProductoID: tornillo, Cantidad: 3, UnidadMedida: unidad("caja")},
+			unidad:   unidad("CAJA"),
+			factor:   100,
+			unidades: 300,
+		},
+		{
+			name:     "factor fraccionario con resultado entero",
+			detalle:  models.DetalleVenta{ProductoID: tornillo, Cantidad: 2, UnidadMedida: unidad("BOLSA")},
+			unidad:   unidad("BOLSA"),
+			factor:   2.5,
+			unidades: 5,
+		},
+		{
+			name:    "factor fraccionario con resultado fraccionado",
+			detalle: models.DetalleVenta{ProductoID: tornillo, Cantidad: 3, UnidadMedida: unidad("BOLSA")},
+			code:    "CANTIDAD_FRACCIONADA_NO_PERMITIDA",
+		},
+		{
+			name:    "cantidad fraccionada en producto no fraccionable",
+			detalle: models.DetalleVenta{ProductoID: tornillo, Cantidad: 1.5},
+			code:    "CANTIDAD_FRACCIONADA_NO_PERMITIDA",
+		},
+		{
+			name:    "media caja de producto no fraccionable",
+			detalle: models.DetalleVenta{ProductoID: tornillo, Cantidad: 0.5, UnidadMedida: unidad("CAJA")},
+			code:    "CANTIDAD_FRACCIONADA_NO_PERMITIDA",
+		},
+		{
+			name:     "producto fraccionable acepta fracciones",
+			detalle:  models.DetalleVenta{ProductoID: cable, Cantidad: 2.75},
+			factor:   1,
+			unidades: 2.75,
+		},
+		{
+			name:     "rollo fraccionado de producto fraccionable",
+			detalle:  models.DetalleVenta{ProductoID: cable, Cantidad: 0.5, UnidadMedida: unidad("rollo")},
+			unidad:   unidad("ROLLO"),
+			factor:   50,
+			unidades: 25,
+		},
+		{
+			name:    "unidad no configurada",
+			detalle: models.DetalleVenta{ProductoID: tornillo, Cantidad: 1, UnidadMedida: unidad("PALLET")},
+			code:    "UNIDAD_NO_VALIDA",
+		},
+		{
+			name:     "producto desconocido se informa al insertar",
+			detalle:  models.DetalleVenta{ProductoID: uuid.New(), Cantidad: 1.5},
+			factor:   1,
+			unidades: 1.5,
+		},
+		{
+			name:    "producto desconocido con unidad sin conversión",
+			detalle: models.DetalleVenta{ProductoID: uuid.New(), Cantidad: 1, UnidadMedida: unidad("CAJA")},
+			code:    "UNIDAD_NO_VALIDA",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detalles := []models.DetalleVenta{tt.detalle}
+			err := asignarUnidadesVenta(detalles, productos)
+
+			if tt.code != "" {
+				var vErr *apiError
+				require.ErrorAs(t, err, &vErr)
+				assert.Equal(t, tt.code, vErr.code)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.unidad, detalles[0].UnidadMedida)
+			assert.Equal(t, tt.factor, detalles[0].FactorConversion)
+			assert.InDelta(t, tt.unidades, detalles[0].Cantidad*detalles[0].FactorConversion, toleranciaFraccion)
+		})
+	}
+}
+
+func TestAsignarUnidadesVentaInformaUnidadesDisponibles(t *testing.T) {
+	tornillo := uuid.New()
+	productos := map[uuid.UUID]*unidadesProducto{
+		tornillo: {base: "UN", factores: map[string]float64{"CAJA": 100, "BOLSA": 2.5}},
+	}
+	pallet := "pallet"
+	detalles := []models.DetalleVenta{
+		{ProductoID: tornillo, Cantidad: 1},
+		{ProductoID: tornillo, Cantidad: 1, UnidadMedida: &pallet},
+	}
+
+	err := asignarUnidadesVenta(detalles, productos)
+
+	var vErr *apiError
+	require.ErrorAs(t, err, &vErr)
+	assert.Equal(t, 1, vErr.details["item"])
+	assert.Equal(t, "PALLET", vErr.details["unidad_medida"])
+	assert.Equal(t, []string{"UN", "BOLSA", "CAJA"}, vErr.details["unidades_disponibles"])
+}
@@ -0,0 +1,1466 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"ferre_pos_apis/internal/database"
+	"ferre_pos_apis/internal/logger"
+	"ferre_pos_apis/internal/metrics"
+	"ferre_pos_apis/internal/models"
+	"ferre_pos_apis/pkg/validator"
+)
+
+const (
+	// tasaIVA tasa de IVA aplicada sobre el neto de la venta
+	tasaIVA = 0.19
+	// intentosDescuentoStock reintentos ante conflictos de concurrencia optimista
+	intentosDescuentoStock = 3
+	// headerIdempotencia header con la clave de idempotencia enviada por el terminal
+	headerIdempotencia = "Idempotency-Key"
+	// vigenciaIdempotencia tiempo durante el cual se conserva la respuesta de una venta
+	vigenciaIdempotencia = 72 * time.Hour
+	// valorPuntoFidelizacion valor en pesos de cada punto canjeado como medio de pago
+	valorPuntoFidelizacion = 1.0
+)
+
+// mediosPagoTarjeta medios de pago que requieren código de autorización del adquirente
+var mediosPagoTarjeta = map[string]bool{
+	"tarjeta_debito":  true,
+	"tarjeta_credito": true,
+}
+
+// errClaveIdempotenciaUsada indica que otra petición ya registró la venta con la misma clave
+var errClaveIdempotenciaUsada = errors.New("clave de idempotencia ya utilizada")
+
+// VentasHandler handler para operaciones de ventas
+type VentasHandler struct {
+	db        *database.Database
+	logger    logger.Logger
+	validator validator.Validator
+	metrics   *metrics.Metrics
+}
+
+// NewVentasHandler crea un nuevo handler de ventas
+func NewVentasHandler(db *database.Database, log logger.Logger, val validator.Validator, met *metrics.Metrics) *VentasHandler {
+	return &VentasHandler{
+		db:        db,
+		logger:    log,
+		validator: val,
+		metrics:   met,
+	}
+}
+
+// Create crea una nueva venta registrando detalle, medios de pago y descuento de stock
+// en una única transacción
+func (h *VentasHandler) Create(c *gin.Context) {
+	start := time.Now()
+
+	var req models.VentaRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	// El cajero es siempre el usuario autenticado, cuyo rol determina los límites de descuento
+	cajeroID, ok := usuarioID(c)
+	if !ok {
+		return
+	}
+	if req.CajeroID != uuid.Nil && req.CajeroID != cajeroID {
+		h.responderVentaError(c, &apiError{
+			status:  http.StatusForbidden,
+			code:    "CAJERO_NO_COINCIDE",
+			message: "El cajero informado no corresponde al usuario autenticado",
+		})
+		return
+	}
+	req.CajeroID = cajeroID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Reintentos de terminales: devolver la respuesta original si la clave ya fue usada
+	clave, vErr := obtenerClaveIdempotencia(c, &req)
+	if vErr != nil {
+		h.responderVentaError(c, vErr)
+		return
+	}
+	hashPeticion := ""
+	if clave != "" {
+		hashPeticion = calcularHashPeticion(&req)
+		if h.responderRepeticion(ctx, c, clave, hashPeticion) {
+			return
+		}
+	}
+
+	motor, err := cargarMotorPromociones(ctx, h.db, req.SucursalID, req.Items)
+	if err != nil {
+		h.logger.WithError(err).Error("Error cargando promociones vigentes")
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "PROMOCIONES_ERROR",
+				Message: "Error cargando promociones vigentes",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	if err := aplicarPreciosLista(ctx, h.db, req.Items); err != nil {
+		responderError(c, h.logger, err, "PRECIOS_ERROR", "Error consultando precios de lista")
+		return
+	}
+
+	venta, detalles, mediosPago, vErr := h.prepararVenta(&req, motor)
+	if vErr != nil {
+		h.responderVentaError(c, vErr)
+		return
+	}
+
+	var response models.APIResponse
+	txStart := time.Now()
+	err = h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		if clave != "" {
+			if err := h.reservarClaveIdempotencia(ctx, tx, clave, hashPeticion, venta); err != nil {
+				return err
+			}
+		}
+
+		// Un UUID de venta del terminal ya registrado con otra clave de idempotencia no crea otra venta
+		if req.VentaID != nil {
+			if err := ventaDuplicada(ctx, tx, venta.ID); err != nil {
+				return err
+			}
+		}
+
+		if req.CarritoID != nil {
+			if err := consumirReservas(ctx, tx, "carrito", *req.CarritoID, &venta.CajeroID); err != nil {
+				return err
+			}
+		}
+
+		if err := h.registrarVenta(ctx, tx, venta, detalles, mediosPago, getUserRole(c), req.CodigoAutorizacionDescuento, req.Despacho, start); err != nil {
+			return err
+		}
+
+		response = models.APIResponse{
+			Success: true,
+			Data: models.VentaResponse{
+				Venta:                *venta,
+				Detalles:             detalles,
+				MediosPago:           mediosPago,
+				Vuelto:               vueltoVenta(mediosPago),
+				PromocionesAplicadas: promocionesAplicadas(detalles),
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		}
+
+		if clave != "" {
+			return h.guardarRespuestaIdempotencia(ctx, tx, clave, http.StatusCreated, &response)
+		}
+		return nil
+	})
+	if h.metrics != nil {
+		h.metrics.RecordDatabaseTransaction("pos", time.Since(txStart), err)
+	}
+
+	if err != nil {
+		// Una petición concurrente con la misma clave registró la venta primero
+		if errors.Is(err, errClaveIdempotenciaUsada) && h.responderRepeticion(ctx, c, clave, hashPeticion) {
+			return
+		}
+
+		// Otra petición concurrente insertó primero una venta con el mismo UUID del terminal
+		if req.VentaID != nil && esViolacionUnicidad(err) {
+			if dupErr := ventaDuplicada(ctx, h.db, *req.VentaID); dupErr != nil {
+				err = dupErr
+			}
+		}
+
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			h.responderVentaError(c, apiErr)
+			return
+		}
+
+		h.logger.WithError(err).Error("Error registrando venta")
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "CREATE_ERROR",
+				Message: "Error registrando venta",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	if h.metrics != nil {
+		h.metrics.RecordVenta("pos", venta.SucursalID.String(), venta.TipoDocumento, venta.Estado, venta.Total)
+	}
+
+	h.logger.WithField("venta_id", venta.ID).
+		WithField("numero_venta", venta.NumeroVenta).
+		WithField("duration_ms", time.Since(start).Milliseconds()).
+		Info("Venta registrada exitosamente")
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// GetByID obtiene una venta por ID
+func (h *VentasHandler) GetByID(c *gin.Context) {
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      gin.H{"message": "Venta encontrada"},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// List lista ventas
+func (h *VentasHandler) List(c *gin.Context) {
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      []interface{}{},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GetByNumero obtiene venta por número
+func (h *VentasHandler) GetByNumero(c *gin.Context) {
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      gin.H{"message": "Venta encontrada por número"},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Preview calcula totales y promociones aplicables antes de cobrar la venta
+func (h *VentasHandler) Preview(c *gin.Context) {
+	var req models.VentaPreviewRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	motor, err := cargarMotorPromociones(ctx, h.db, req.SucursalID, req.Items)
+	if err != nil {
+		h.logger.WithError(err).Error("Error cargando promociones vigentes")
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "PROMOCIONES_ERROR",
+				Message: "Error cargando promociones vigentes",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	if err := aplicarPreciosLista(ctx, h.db, req.Items); err != nil {
+		responderError(c, h.logger, err, "PRECIOS_ERROR", "Error consultando precios de lista")
+		return
+	}
+
+	venta := &models.Venta{SucursalID: req.SucursalID}
+	detalles, vErr := calcularDetalleVenta(venta, req.Items, motor)
+	if vErr != nil {
+		h.responderVentaError(c, vErr)
+		return
+	}
+
+	if err := resolverUnidadesVenta(ctx, h.db, detalles); err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			h.responderVentaError(c, apiErr)
+			return
+		}
+
+		h.logger.WithError(err).Error("Error resolviendo unidades de medida")
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "UNIDADES_MEDIDA_ERROR",
+				Message: "Error resolviendo unidades de medida",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	aplicadas := promocionesAplicadas(detalles)
+	if aplicadas == nil {
+		aplicadas = []models.PromocionAplicada{}
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: models.VentaPreview{
+			Subtotal:             venta.Subtotal,
+			DescuentoTotal:       venta.DescuentoTotal,
+			ImpuestoTotal:        venta.ImpuestoTotal,
+			Total:                venta.Total,
+			Detalles:             detalles,
+			PromocionesAplicadas: aplicadas,
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Anular anula una venta restaurando stock y revirtiendo puntos de fidelización
+func (h *VentasHandler) Anular(c *gin.Context) {
+	ventaID, ok := uuidParam(c, "id", "INVALID_VENTA_ID", "ID de venta inválido")
+	if !ok {
+		return
+	}
+
+	var req models.AnularVentaRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	var usuarioID *uuid.UUID
+	if uid, err := uuid.Parse(getUserID(c)); err == nil {
+		usuarioID = &uid
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var resultado gin.H
+	var venta models.Venta
+	txStart := time.Now()
+	err := h.db.Transaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		resultado, err = h.anularVenta(ctx, tx, ventaID, req.Motivo, usuarioID, &venta)
+		return err
+	})
+	if h.metrics != nil {
+		h.metrics.RecordDatabaseTransaction("pos", time.Since(txStart), err)
+	}
+
+	if err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			h.responderVentaError(c, apiErr)
+			return
+		}
+
+		h.logger.WithError(err).Error("Error anulando venta")
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error: &models.APIError{
+				Code:    "ANULACION_ERROR",
+				Message: "Error anulando venta",
+			},
+			RequestID: getRequestID(c),
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	if h.metrics != nil {
+		h.metrics.RecordVenta("pos", venta.SucursalID.String(), venta.TipoDocumento, "anulada", venta.Total)
+	}
+
+	h.logger.WithField("venta_id", ventaID).
+		WithField("usuario_id", getUserID(c)).
+		Info("Venta anulada exitosamente")
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      resultado,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// GenerarDTE genera DTE para una venta
+func (h *VentasHandler) GenerarDTE(c *gin.Context) {
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:   true,
+		Data:      gin.H{"message": "DTE generado exitosamente"},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// Métodos auxiliares
+
+// responderVentaError responde con el error de negocio de una venta
+func (h *VentasHandler) responderVentaError(c *gin.Context, e *apiError) {
+	c.JSON(e.status, models.APIResponse{
+		Success: false,
+		Error: &models.APIError{
+			Code:    e.code,
+			Message: e.message,
+			Details: e.details,
+		},
+		RequestID: getRequestID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// prepararVenta construye la venta, su detalle y medios de pago calculando los totales
+func (h *VentasHandler) prepararVenta(req *models.VentaRequest, motor *motorPromociones) (*models.Venta, []models.DetalleVenta, []models.MedioPagoVenta, *apiError) {
+	ventaID := uuid.New()
+	if req.VentaID != nil {
+		ventaID = *req.VentaID
+	}
+
+	venta := &models.Venta{
+		ID:               ventaID,
+		SucursalID:       req.SucursalID,
+		TerminalID:       req.TerminalID,
+		CajeroID:         req.CajeroID,
+		VendedorID:       req.VendedorID,
+		ClienteRUT:       req.ClienteRUT,
+		ClienteNombre:    req.ClienteNombre,
+		TipoDocumento:    req.TipoDocumento,
+		Estado:           "finalizada",
+		Fecha:            time.Now().Truncate(time.Microsecond),
+		DatosAdicionales: req.DatosAdicionales,
+		ProcesoOrigen:    models.PrioridadMaxima,
+	}
+
+	detalles, vErr := calcularDetalleVenta(venta, req.Items, motor)
+	if vErr != nil {
+		return nil, nil, nil, vErr
+	}
+
+	mediosPago, vErr := prepararMediosPago(venta, req.MediosPago)
+	if vErr != nil {
+		return nil, nil, nil, vErr
+	}
+
+	hash := calcularHashVenta(venta, detalles, mediosPago)
+	venta.HashIntegridad = &hash
+
+	return venta, detalles, mediosPago, nil
+}
+
+// calcularDetalleVenta arma las líneas de la venta aplicando promociones y calcula los totales
+func calcularDetalleVenta(venta *models.Venta, items []models.VentaItemRequest, motor *motorPromociones) ([]models.DetalleVenta, *apiError) {
+	detalles := make([]models.DetalleVenta, 0, len(items))
+	for i, item := range items {
+		if item.DescuentoUnitario < 0 || item.DescuentoUnitario > item.PrecioUnitario {
+			return nil, &apiError{
+				status:  http.StatusBadRequest,
+				code:    "INVALID_DISCOUNT",
+				message: "El descuento unitario no puede ser negativo ni superar el precio unitario",
+				details: models.JSONB{"item": i, "producto_id": item.ProductoID},
+			}
+		}
+
+		detalles = append(detalles, models.DetalleVenta{
+			ID:                uuid.New(),
+			VentaID:           venta.ID,
+			ProductoID:        item.ProductoID,
+			Cantidad:          item.Cantidad,
+			PrecioUnitario:    item.PrecioUnitario,
+			DescuentoUnitario: item.DescuentoUnitario,
+			NumeroSerie:       item.NumeroSerie,
+			Lote:              item.Lote,
+			UnidadMedida:      item.UnidadMedida,
+			FactorConversion:  1,
+		})
+	}
+
+	// Las promociones se evalúan sobre todo el carro y se suman al descuento manual de cada línea
+	motor.aplicarPromociones(detalles)
+
+	for i := range detalles {
+		detalle := &detalles[i]
+		detalle.PrecioFinal = redondearMonto(detalle.PrecioUnitario - detalle.DescuentoUnitario)
+		detalle.TotalItem = redondearMonto(detalle.PrecioFinal * detalle.Cantidad)
+
+		venta.Subtotal += detalle.PrecioUnitario * detalle.Cantidad
+		venta.DescuentoTotal += detalle.DescuentoUnitario * detalle.Cantidad
+	}
+
+	venta.Subtotal = redondearMonto(venta.Subtotal)
+	venta.DescuentoTotal = redondearMonto(venta.DescuentoTotal)
+	venta.ImpuestoTotal = redondearMonto((venta.Subtotal - venta.DescuentoTotal) * tasaIVA)
+	venta.Total = redondearMonto(venta.Subtotal - venta.DescuentoTotal + venta.ImpuestoTotal)
+
+	return detalles, nil
+}
+
+// aplicarPreciosLista reemplaza el precio informado por el terminal con el precio de lista vigente de la
+// unidad vendida. Un precio informado menor se suma al descuento manual de la línea, por lo que queda sujeto
+// a la política de descuentos del rol del cajero
+func aplicarPreciosLista(ctx context.Context, q sqlQueryer, items []models.VentaItemRequest) error {
+	ids := make([]string, len(items))
+	for i := range items {
+		ids[i] = items[i].ProductoID.String()
+	}
+
+	type preciosProducto struct {
+		unidadBase string
+		precio     float64
+		unidades   map[string]float64
+	}
+	productos := make(map[uuid.UUID]*preciosProducto, len(ids))
+	rows, err := q.QueryContext(ctx, `
+		SELECT p.id, p.unidad_medida, p.precio_unitario, u.unidad,
+			COALESCE(u.precio_unitario, p.precio_unitario * u.factor_conversion)
+		FROM productos p
+		LEFT JOIN unidades_medida_producto u ON u.producto_id = p.id AND u.activo = true
+		WHERE p.id = ANY($1::uuid[]) AND p.activo = true`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return fmt.Errorf("error consultando precios de lista: %w", err)
+	}
+	err = database.ScanRows(rows, func() error {
+		var id uuid.UUID
+		var unidadBase string
+		var precio float64
+		var unidad *string
+		var precioUnidad *float64
+		if err := rows.Scan(&id, &unidadBase, &precio, &unidad, &precioUnidad); err != nil {
+			return err
+		}
+		p := productos[id]
+		if p == nil {
+			p = &preciosProducto{unidadBase: unidadBase, precio: precio, unidades: make(map[string]float64)}
+			productos[id] = p
+		}
+		if unidad != nil && precioUnidad != nil {
+			p.unidades[*unidad] = *precioUnidad
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error leyendo precios de lista: %w", err)
+	}
+
+	for i := range items {
+		item := &items[i]
+		p, ok := productos[item.ProductoID]
+		if !ok {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "PRODUCT_NOT_FOUND",
+				message: "Producto no encontrado o inactivo",
+				details: models.JSONB{"item": i, "producto_id": item.ProductoID},
+			}
+		}
+
+		lista := p.precio
+		if item.UnidadMedida != nil && normalizarUnidad(*item.UnidadMedida) != normalizarUnidad(p.unidadBase) {
+			precioUnidad, ok := p.unidades[normalizarUnidad(*item.UnidadMedida)]
+			if !ok {
+				// resolverUnidadesVenta rechaza la unidad no configurada antes de registrar la línea
+				continue
+			}
+			lista = precioUnidad
+		}
+		lista = redondearMonto(lista)
+
+		precio := redondearMonto(item.PrecioUnitario)
+		if precio > lista {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "PRECIO_SOBRE_LISTA",
+				message: "El precio unitario no puede superar el precio de lista del producto",
+				details: models.JSONB{"item": i, "producto_id": item.ProductoID, "precio_unitario": precio, "precio_lista": lista},
+			}
+		}
+
+		item.DescuentoUnitario = redondearMonto(item.DescuentoUnitario + lista - precio)
+		item.PrecioUnitario = lista
+	}
+
+	return nil
+}
+
+// prepararMediosPago valida el pago mixto y calcula el vuelto, que solo puede entregarse en efectivo
+func prepararMediosPago(venta *models.Venta, pagos []models.MedioPagoRequest) ([]models.MedioPagoVenta, *apiError) {
+	mediosPago := make([]models.MedioPagoVenta, 0, len(pagos))
+	totalPagado := 0.0
+	totalNoEfectivo := 0.0
+	for i, mp := range pagos {
+		if mediosPagoTarjeta[mp.MedioPago] && (mp.CodigoAutorizacion == nil || strings.TrimSpace(*mp.CodigoAutorizacion) == "") {
+			return nil, &apiError{
+				status:  http.StatusBadRequest,
+				code:    "CODIGO_AUTORIZACION_REQUERIDO",
+				message: "Los pagos con tarjeta requieren código de autorización",
+				details: models.JSONB{"medio_pago": i},
+			}
+		}
+
+		monto := redondearMonto(mp.Monto)
+		mediosPago = append(mediosPago, models.MedioPagoVenta{
+			ID:                    uuid.New(),
+			VentaID:               venta.ID,
+			MedioPago:             mp.MedioPago,
+			Monto:                 monto,
+			ReferenciaTransaccion: mp.ReferenciaTransaccion,
+			CodigoAutorizacion:    mp.CodigoAutorizacion,
+			FechaProcesamiento:    venta.Fecha,
+			EstadoConciliacion:    "pendiente",
+		})
+		totalPagado += monto
+		if mp.MedioPago != "efectivo" {
+			totalNoEfectivo += monto
+		}
+	}
+	totalPagado = redondearMonto(totalPagado)
+	totalNoEfectivo = redondearMonto(totalNoEfectivo)
+
+	if totalNoEfectivo > venta.Total {
+		return nil, &apiError{
+			status:  http.StatusBadRequest,
+			code:    "PAGO_EXCEDE_TOTAL",
+			message: "Los medios de pago distintos de efectivo no pueden superar el total de la venta",
+			details: models.JSONB{"total": venta.Total, "total_no_efectivo": totalNoEfectivo},
+		}
+	}
+
+	if totalPagado < venta.Total {
+		return nil, &apiError{
+			status:  http.StatusBadRequest,
+			code:    "PAGO_INSUFICIENTE",
+			message: "El total de los medios de pago no cubre el total de la venta",
+			details: models.JSONB{"total": venta.Total, "total_pagado": totalPagado},
+		}
+	}
+
+	// El vuelto se descuenta del efectivo para que cada medio registre solo lo que queda en caja
+	pendiente := redondearMonto(totalPagado - venta.Total)
+	for i := len(mediosPago) - 1; i >= 0 && pendiente > 0; i-- {
+		mp := &mediosPago[i]
+		if mp.MedioPago != "efectivo" {
+			continue
+		}
+		if pendiente >= mp.Monto {
+			return nil, &apiError{
+				status:  http.StatusBadRequest,
+				code:    "PAGO_EXCEDE_TOTAL",
+				message: "Se informó un pago en efectivo que no es necesario para cubrir la venta",
+				details: models.JSONB{"total": venta.Total, "total_pagado": totalPagado},
+			}
+		}
+		mp.DatosTransaccion = models.JSONB{"monto_recibido": mp.Monto, "vuelto": pendiente}
+		mp.Monto = redondearMonto(mp.Monto - pendiente)
+		pendiente = 0
+	}
+
+	return mediosPago, nil
+}
+
+// vueltoVenta suma el vuelto entregado en los pagos en efectivo
+func vueltoVenta(mediosPago []models.MedioPagoVenta) float64 {
+	vuelto := 0.0
+	for _, mp := range mediosPago {
+		if v, ok := mp.DatosTransaccion["vuelto"].(float64); ok {
+			vuelto += v
+		}
+	}
+	return redondearMonto(vuelto)
+}
+
+// registrarVenta inserta la venta con su detalle y medios de pago dentro de la transacción;
+// si se informa despacho, crea además el despacho pendiente para bodega. rolCajero es el rol del
+// usuario autenticado que registra la venta
+func (h *VentasHandler) registrarVenta(ctx context.Context, tx *sql.Tx, venta *models.Venta, detalles []models.DetalleVenta, mediosPago []models.MedioPagoVenta, rolCajero string, codigoAutorizacion *string, despacho *models.DespachoVentaRequest, inicio time.Time) error {
+	// Toda venta queda asociada a la sesión de caja abierta del terminal
+	sesionID, err := sesionCajaAbierta(ctx, tx, venta.TerminalID)
+	if err != nil {
+		return err
+	}
+	if venta.DatosAdicionales == nil {
+		venta.DatosAdicionales = models.JSONB{}
+	}
+	venta.DatosAdicionales["sesion_caja_id"] = sesionID.String()
+
+	if err := resolverUnidadesVenta(ctx, tx, detalles); err != nil {
+		return err
+	}
+
+	if err := aplicarPoliticaDescuento(ctx, tx, venta, detalles, rolCajero, codigoAutorizacion); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO ventas (
+			id, sucursal_id, terminal_id, cajero_id, vendedor_id, cliente_rut,
+			cliente_nombre, nota_venta_id, tipo_documento, subtotal, descuento_total,
+			impuesto_total, total, estado, fecha, datos_adicionales, hash_integridad,
+			proceso_origen
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18
+		) RETURNING numero_venta`
+
+	err = tx.QueryRowContext(ctx, query,
+		venta.ID, venta.SucursalID, venta.TerminalID, venta.CajeroID, venta.VendedorID,
+		venta.ClienteRUT, venta.ClienteNombre, venta.NotaVentaID, venta.TipoDocumento,
+		venta.Subtotal, venta.DescuentoTotal, venta.ImpuestoTotal, venta.Total, venta.Estado,
+		venta.Fecha, venta.DatosAdicionales, venta.HashIntegridad, venta.ProcesoOrigen,
+	).Scan(&venta.NumeroVenta)
+	if err != nil {
+		return fmt.Errorf("error insertando venta: %w", err)
+	}
+
+	for i := range detalles {
+		// Completa serie y lote de la línea antes de insertarla
+		if err := asignarTrazabilidadVenta(ctx, tx, venta, &detalles[i]); err != nil {
+			return err
+		}
+
+		if err := h.insertarDetalleVenta(ctx, tx, &detalles[i]); err != nil {
+			return err
+		}
+
+		// descontar_stock_optimizado registra también el movimiento de tipo 'venta'
+		if err := h.descontarStock(ctx, tx, venta, &detalles[i]); err != nil {
+			return err
+		}
+	}
+
+	if despacho != nil {
+		d, err := crearDespachoVenta(ctx, tx, venta, detalles, despacho)
+		if err != nil {
+			return err
+		}
+		venta.DatosAdicionales["despacho_id"] = d.ID.String()
+		venta.DatosAdicionales["numero_despacho"] = d.NumeroDespacho
+	}
+
+	// Las reglas de fidelización solo se evalúan si la venta identifica al cliente
+	var motor *motorFidelizacion
+	if venta.ClienteRUT != nil && *venta.ClienteRUT != "" {
+		motor, err = cargarMotorFidelizacion(ctx, tx, h.logger)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range mediosPago {
+		mp := &mediosPago[i]
+		switch mp.MedioPago {
+		case "puntos_fidelizacion":
+			if err := h.canjearPuntos(ctx, tx, motor, venta, detalles, mp); err != nil {
+				return err
+			}
+		case "cuenta_corriente":
+			if err := cargarCuentaCorriente(ctx, tx, venta, mp); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO medios_pago_venta (
+				id, venta_id, medio_pago, monto, referencia_transaccion,
+				codigo_autorizacion, datos_transaccion, fecha_procesamiento, estado_conciliacion
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			mp.ID, mp.VentaID, mp.MedioPago, mp.Monto, mp.ReferenciaTransaccion,
+			mp.CodigoAutorizacion, mp.DatosTransaccion, mp.FechaProcesamiento, mp.EstadoConciliacion,
+		)
+		if err != nil {
+			return fmt.Errorf("error insertando medio de pago: %w", err)
+		}
+	}
+
+	acumulacion, err := acumularPuntosVenta(ctx, tx, motor, venta, detalles, mediosPago)
+	if err != nil {
+		return err
+	}
+	if acumulacion.puntos > 0 {
+		venta.DatosAdicionales["puntos_acumulados"] = acumulacion.puntos
+	}
+	if acumulacion.nivelNuevo != "" {
+		venta.DatosAdicionales["nivel_fidelizacion"] = acumulacion.nivelNuevo
+	}
+
+	tiempo := int(time.Since(inicio).Milliseconds())
+	venta.TiempoProcesamiento = &tiempo
+	_, err = tx.ExecContext(ctx,
+		`UPDATE ventas SET tiempo_procesamiento_ms = $2, datos_adicionales = $4 WHERE id = $1 AND fecha = $3`,
+		venta.ID, tiempo, venta.Fecha, venta.DatosAdicionales,
+	)
+	if err != nil {
+		return fmt.Errorf("error actualizando tiempo de procesamiento: %w", err)
+	}
+
+	return nil
+}
+
+// canjearPuntos descuenta del saldo del cliente los puntos usados como medio de pago.
+// La regla de canje de mayor prioridad que se cumple reemplaza el mínimo de canje y puede limitar el monto pagable con puntos.
+func (h *VentasHandler) canjearPuntos(ctx context.Context, tx *sql.Tx, motor *motorFidelizacion, venta *models.Venta, detalles []models.DetalleVenta, mp *models.MedioPagoVenta) error {
+	if venta.ClienteRUT == nil || *venta.ClienteRUT == "" {
+		return &apiError{
+			status:  http.StatusBadRequest,
+			code:    "CLIENTE_REQUERIDO",
+			message: "El pago con puntos requiere informar el RUT del cliente",
+		}
+	}
+
+	var clienteID uuid.UUID
+	var puntosActuales int
+	var nivel string
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, puntos_actuales, COALESCE(nivel_fidelizacion, 'bronce')
+		FROM fidelizacion_clientes
+		WHERE rut = $1 AND activo = true
+		FOR UPDATE`,
+		*venta.ClienteRUT,
+	).Scan(&clienteID, &puntosActuales, &nivel)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "CLIENTE_FIDELIZACION_NOT_FOUND",
+				message: "Cliente de fidelización no encontrado o inactivo",
+				details: models.JSONB{"cliente_rut": *venta.ClienteRUT},
+			}
+		}
+		return fmt.Errorf("error consultando cliente de fidelización: %w", err)
+	}
+
+	puntos := int(math.Ceil(mp.Monto / valorPuntoFidelizacion))
+	minimoCanje, err := parametroFidelizacion(ctx, tx, "fidelizacion.minimo_canje", minimoCanjeFidelizacion)
+	if err != nil {
+		return err
+	}
+
+	regla, err := motor.evaluarCanje(contextoFidelizacionVenta(ctx, tx, venta, detalles, nivel, venta.Total))
+	if err != nil {
+		return err
+	}
+	var reglaID *uuid.UUID
+	if regla != nil {
+		reglaID = &regla.id
+		if regla.MinimoCanje != nil {
+			minimoCanje = float64(*regla.MinimoCanje)
+		}
+		if regla.PorcentajeMaximo != nil {
+			maximo := redondearMonto(venta.Total * *regla.PorcentajeMaximo / 100)
+			if mp.Monto > maximo {
+				return &apiError{
+					status:  http.StatusBadRequest,
+					code:    "CANJE_SOBRE_MAXIMO",
+					message: "El pago con puntos supera el máximo permitido para la venta",
+					details: models.JSONB{"monto": mp.Monto, "monto_maximo": maximo, "regla": regla.nombre},
+				}
+			}
+		}
+	}
+	if float64(puntos) < minimoCanje {
+		return &apiError{
+			status:  http.StatusBadRequest,
+			code:    "CANJE_BAJO_MINIMO",
+			message: "El canje no alcanza el mínimo de puntos del programa de fidelización",
+			details: models.JSONB{"puntos": puntos, "minimo_canje": minimoCanje},
+		}
+	}
+	if puntos > puntosActuales {
+		return &apiError{
+			status:  http.StatusConflict,
+			code:    "PUNTOS_INSUFICIENTES",
+			message: "El cliente no tiene puntos suficientes para el pago",
+			details: models.JSONB{"puntos_requeridos": puntos, "puntos_disponibles": puntosActuales},
+		}
+	}
+
+	puntosNuevos := puntosActuales - puntos
+	_, err = tx.ExecContext(ctx, `
+		UPDATE fidelizacion_clientes
+		SET puntos_actuales = $2, fecha_ultima_actividad = NOW(), fecha_modificacion = NOW()
+		WHERE id = $1`,
+		clienteID, puntosNuevos,
+	)
+	if err != nil {
+		return fmt.Errorf("error actualizando puntos del cliente: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO movimientos_fidelizacion (
+			cliente_id, sucursal_id, venta_id, tipo, puntos, puntos_anteriores,
+			puntos_nuevos, detalle, usuario_id, regla_aplicada_id
+		) VALUES ($1, $2, $3, 'canje', $4, $5, $6, $7, $8, $9)`,
+		clienteID, venta.SucursalID, venta.ID, -puntos, puntosActuales, puntosNuevos,
+		"Canje de puntos como medio de pago", venta.CajeroID, reglaID,
+	)
+	if err != nil {
+		return fmt.Errorf("error registrando canje de puntos: %w", err)
+	}
+
+	if reglaID != nil {
+		if err := registrarAplicacionesReglas(ctx, tx, map[uuid.UUID]int{*reglaID: 0}); err != nil {
+			return err
+		}
+	}
+
+	if mp.DatosTransaccion == nil {
+		mp.DatosTransaccion = models.JSONB{}
+	}
+	mp.DatosTransaccion["puntos_canjeados"] = puntos
+	mp.DatosTransaccion["cliente_id"] = clienteID
+
+	return nil
+}
+
+// insertarDetalleVenta inserta una línea de venta completando datos del producto
+func (h *VentasHandler) insertarDetalleVenta(ctx context.Context, tx *sql.Tx, detalle *models.DetalleVenta) error {
+	var precioCosto sql.NullFloat64
+	err := tx.QueryRowContext(ctx,
+		`SELECT categoria_id, precio_costo FROM productos WHERE id = $1 AND activo = true`,
+		detalle.ProductoID,
+	).Scan(&detalle.CategoriaProductoID, &precioCosto)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &apiError{
+				status:  http.StatusBadRequest,
+				code:    "PRODUCT_NOT_FOUND",
+				message: "Producto no encontrado o inactivo",
+				details: models.JSONB{"producto_id": detalle.ProductoID},
+			}
+		}
+		return fmt.Errorf("error consultando producto: %w", err)
+	}
+
+	// El costo es por unidad base; el precio corresponde a la unidad vendida
+	if precioCosto.Valid {
+		margen := redondearMonto(detalle.PrecioFinal - precioCosto.Float64*detalle.FactorConversion)
+		detalle.MargenUnitario = &margen
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO detalle_ventas (
+			id, venta_id, producto_id, cantidad, precio_unitario, descuento_unitario,
+			precio_final, total_item, numero_serie, lote, fecha_vencimiento,
+			datos_adicionales, margen_unitario, categoria_producto_id, unidad_medida,
+			factor_conversion
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`,
+		detalle.ID, detalle.VentaID, detalle.ProductoID, detalle.Cantidad, detalle.PrecioUnitario,
+		detalle.DescuentoUnitario, detalle.PrecioFinal, detalle.TotalItem, detalle.NumeroSerie,
+		detalle.Lote, detalle.FechaVencimiento, detalle.DatosAdicionales, detalle.MargenUnitario,
+		detalle.CategoriaProductoID, detalle.UnidadMedida, detalle.FactorConversion,
+	)
+	if err != nil {
+		return fmt.Errorf("error insertando detalle de venta: %w", err)
+	}
+
+	return nil
+}
+
+// descontarStock descuenta el stock de una línea reintentando ante conflictos de concurrencia.
+// El stock se lleva en unidad base, por lo que la cantidad se convierte con el factor de la línea.
+func (h *VentasHandler) descontarStock(ctx context.Context, tx *sql.Tx, venta *models.Venta, detalle *models.DetalleVenta) error {
+	cantidadBase := detalle.Cantidad * detalle.FactorConversion
+	for intento := 0; intento < intentosDescuentoStock; intento++ {
+		var exito bool
+		err := tx.QueryRowContext(ctx,
+			`SELECT descontar_stock_optimizado($1, $2, $3, $4, $5)`,
+			detalle.ProductoID, venta.SucursalID, cantidadBase, venta.ID, venta.CajeroID,
+		).Scan(&exito)
+		if err != nil {
+			return fmt.Errorf("error descontando stock: %w", err)
+		}
+		if exito {
+			return nil
+		}
+	}
+
+	var disponible float64
+	err := tx.QueryRowContext(ctx,
+		`SELECT cantidad_disponible FROM stock_central WHERE producto_id = $1 AND sucursal_id = $2`,
+		detalle.ProductoID, venta.SucursalID,
+	).Scan(&disponible)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error consultando stock disponible: %w", err)
+	}
+
+	return &apiError{
+		status:  http.StatusConflict,
+		code:    "STOCK_INSUFICIENTE",
+		message: "Stock insuficiente para completar la venta",
+		details: models.JSONB{
+			"producto_id":         detalle.ProductoID,
+			"unidad_medida":       detalle.UnidadMedida,
+			"cantidad_solicitada": detalle.Cantidad,
+			"cantidad_base":       cantidadBase,
+			"cantidad_disponible": disponible,
+		},
+	}
+}
+
+// anularVenta marca la venta como anulada, restaura el stock y revierte los puntos dentro de la transacción
+func (h *VentasHandler) anularVenta(ctx context.Context, tx *sql.Tx, ventaID uuid.UUID, motivo string, usuarioID *uuid.UUID, venta *models.Venta) (gin.H, error) {
+	var sesionVenta *string
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, numero_venta, sucursal_id, terminal_id, tipo_documento, total, estado, dte_emitido,
+			datos_adicionales->>'sesion_caja_id'
+		FROM ventas
+		WHERE id = $1
+		FOR UPDATE`,
+		ventaID,
+	).Scan(&venta.ID, &venta.NumeroVenta, &venta.SucursalID, &venta.TerminalID, &venta.TipoDocumento,
+		&venta.Total, &venta.Estado, &venta.DTEEmitido, &sesionVenta)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &apiError{
+				status:  http.StatusNotFound,
+				code:    "VENTA_NOT_FOUND",
+				message: "Venta no encontrada",
+			}
+		}
+		return nil, fmt.Errorf("error consultando venta: %w", err)
+	}
+
+	if venta.Estado == "anulada" {
+		return nil, &apiError{
+			status:  http.StatusConflict,
+			code:    "VENTA_YA_ANULADA",
+			message: "La venta ya se encuentra anulada",
+		}
+	}
+
+	if venta.DTEEmitido {
+		return nil, &apiError{
+			status:  http.StatusConflict,
+			code:    "DTE_EMITIDO",
+			message: "La venta tiene un DTE emitido y no puede anularse; debe emitirse una nota de crédito",
+			details: models.JSONB{"venta_id": venta.ID, "flujo_sugerido": "notas_credito"},
+		}
+	}
+
+	// Con devoluciones en curso o emitidas la venta ya no puede anularse completa
+	var notasCredito int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM notas_credito
+		WHERE venta_origen_id = $1 AND estado IN ('pendiente', 'procesado', 'enviado')`,
+		venta.ID,
+	).Scan(&notasCredito)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando notas de crédito de la venta: %w", err)
+	}
+	if notasCredito > 0 {
+		return nil, &apiError{
+			status:  http.StatusConflict,
+			code:    "VENTA_CON_NOTAS_CREDITO",
+			message: "La venta tiene devoluciones registradas y no puede anularse",
+			details: models.JSONB{"venta_id": venta.ID, "notas_credito": notasCredito},
+		}
+	}
+
+	// La mercadería aún en bodega deja de despacharse; si ya salió, corresponde nota de crédito
+	if err := cancelarDespachoVenta(ctx, tx, venta.ID, motivo); err != nil {
+		return nil, err
+	}
+
+	// Restaurar stock de cada producto vendido, convertido a unidad base
+	rows, err := tx.QueryContext(ctx, `
+		SELECT producto_id, SUM(cantidad * factor_conversion)
+		FROM detalle_ventas
+		WHERE venta_id = $1
+		GROUP BY producto_id`,
+		venta.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando detalle de venta: %w", err)
+	}
+
+	type lineaAnulada struct {
+		ProductoID uuid.UUID `json:"producto_id"`
+		Cantidad   float64   `json:"cantidad"`
+	}
+	var lineas []lineaAnulada
+	err = database.ScanRows(rows, func() error {
+		var l lineaAnulada
+		if err := rows.Scan(&l.ProductoID, &l.Cantidad); err != nil {
+			return err
+		}
+		lineas = append(lineas, l)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo detalle de venta: %w", err)
+	}
+
+	batchID := uuid.New()
+	referencia := fmt.Sprintf("ANULACION-VENTA-%d", venta.NumeroVenta)
+	for _, l := range lineas {
+		if err := registrarDevolucionStock(ctx, tx, l.ProductoID, venta.SucursalID, l.Cantidad, referencia, usuarioID, batchID, motivo); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := revertirTrazabilidadVenta(ctx, tx, venta.ID); err != nil {
+		return nil, err
+	}
+
+	puntosRevertidos, err := h.revertirPuntosVenta(ctx, tx, venta, usuarioID, motivo)
+	if err != nil {
+		return nil, err
+	}
+
+	creditoRevertido, err := revertirCargosCuentaCorriente(ctx, tx, venta, usuarioID, motivo)
+	if err != nil {
+		return nil, err
+	}
+
+	// Marcar los medios de pago para su devolución en la conciliación
+	_, err = tx.ExecContext(ctx, `
+		UPDATE medios_pago_venta
+		SET datos_transaccion = COALESCE(datos_transaccion, '{}'::jsonb) ||
+			jsonb_build_object('anulado', true, 'fecha_anulacion', NOW())
+		WHERE venta_id = $1`,
+		venta.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error actualizando medios de pago: %w", err)
+	}
+
+	efectivoDevuelto, err := devolverEfectivoAnulacion(ctx, tx, venta, sesionVenta, usuarioID, motivo)
+	if err != nil {
+		return nil, err
+	}
+
+	var fechaAnulacion time.Time
+	err = tx.QueryRowContext(ctx, `
+		UPDATE ventas
+		SET estado = 'anulada', fecha_anulacion = NOW(), motivo_anulacion = $2, usuario_anulacion = $3
+		WHERE id = $1
+		RETURNING fecha_anulacion`,
+		venta.ID, motivo, usuarioID,
+	).Scan(&fechaAnulacion)
+	if err != nil {
+		return nil, fmt.Errorf("error anulando venta: %w", err)
+	}
+
+	return gin.H{
+		"venta_id":          venta.ID,
+		"numero_venta":      venta.NumeroVenta,
+		"estado":            "anulada",
+		"fecha_anulacion":   fechaAnulacion,
+		"motivo_anulacion":  motivo,
+		"stock_restaurado":  lineas,
+		"puntos_revertidos": puntosRevertidos,
+		"credito_revertido": creditoRevertido,
+		"efectivo_devuelto": efectivoDevuelto,
+		"batch_id":          batchID,
+	}, nil
+}
+
+// revertirPuntosVenta revierte los puntos acumulados y canjeados en la venta
+func (h *VentasHandler) revertirPuntosVenta(ctx context.Context, tx *sql.Tx, venta *models.Venta, usuarioID *uuid.UUID, motivo string) (int, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT cliente_id,
+			SUM(puntos),
+			COALESCE(SUM(puntos) FILTER (WHERE tipo = 'acumulacion'), 0)
+		FROM movimientos_fidelizacion
+		WHERE venta_id = $1
+		GROUP BY cliente_id`,
+		venta.ID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error consultando movimientos de fidelización: %w", err)
+	}
+
+	type puntosCliente struct {
+		clienteID  uuid.UUID
+		neto       int
+		acumulados int
+	}
+	var clientes []puntosCliente
+	err = database.ScanRows(rows, func() error {
+		var p puntosCliente
+		if err := rows.Scan(&p.clienteID, &p.neto, &p.acumulados); err != nil {
+			return err
+		}
+		clientes = append(clientes, p)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error leyendo movimientos de fidelización: %w", err)
+	}
+
+	total := 0
+	for _, p := range clientes {
+		if p.neto == 0 {
+			continue
+		}
+
+		var puntosActuales int
+		err := tx.QueryRowContext(ctx,
+			`SELECT puntos_actuales FROM fidelizacion_clientes WHERE id = $1 FOR UPDATE`,
+			p.clienteID,
+		).Scan(&puntosActuales)
+		if err != nil {
+			return 0, fmt.Errorf("error consultando cliente de fidelización: %w", err)
+		}
+
+		// Si el cliente ya utilizó los puntos no se puede dejar el saldo negativo
+		puntosNuevos := puntosActuales - p.neto
+		if puntosNuevos < 0 {
+			puntosNuevos = 0
+		}
+		ajuste := puntosNuevos - puntosActuales
+		if ajuste == 0 {
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE fidelizacion_clientes
+			SET puntos_actuales = $2,
+				puntos_acumulados_total = GREATEST(puntos_acumulados_total - $3, 0),
+				fecha_ultima_actividad = NOW(),
+				fecha_modificacion = NOW()
+			WHERE id = $1`,
+			p.clienteID, puntosNuevos, p.acumulados,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("error actualizando puntos del cliente: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO movimientos_fidelizacion (
+				cliente_id, sucursal_id, venta_id, tipo, puntos, puntos_anteriores,
+				puntos_nuevos, detalle, usuario_id, datos_adicionales
+			) VALUES ($1, $2, $3, 'ajuste', $4, $5, $6, $7, $8, $9)`,
+			p.clienteID, venta.SucursalID, venta.ID, ajuste, puntosActuales, puntosNuevos,
+			fmt.Sprintf("Reversa por anulación de venta %d", venta.NumeroVenta), usuarioID,
+			models.JSONB{"motivo_anulacion": motivo, "puntos_venta": p.neto},
+		)
+		if err != nil {
+			return 0, fmt.Errorf("error registrando reversa de puntos: %w", err)
+		}
+
+		total += -ajuste
+	}
+
+	return total, nil
+}
+
+// registrarDevolucionStock reingresa stock a la sucursal y registra el movimiento de devolución
+func registrarDevolucionStock(ctx context.Context, tx *sql.Tx, productoID, sucursalID uuid.UUID, cantidad float64, referencia string, usuarioID *uuid.UUID, batchID uuid.UUID, observaciones string) error {
+	var cantidadNueva float64
+	err := tx.QueryRowContext(ctx, `
+		UPDATE stock_central
+		SET cantidad = cantidad + $3,
+			version_optimistic_lock = version_optimistic_lock + 1,
+			fecha_sync = NOW()
+		WHERE producto_id = $1 AND sucursal_id = $2
+		RETURNING cantidad`,
+		productoID, sucursalID, cantidad,
+	).Scan(&cantidadNueva)
+	if err == sql.ErrNoRows {
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO stock_central (producto_id, sucursal_id, cantidad)
+			VALUES ($1, $2, $3)
+			RETURNING cantidad`,
+			productoID, sucursalID, cantidad,
+		).Scan(&cantidadNueva)
+	}
+	if err != nil {
+		return fmt.Errorf("error restaurando stock: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO movimientos_stock (
+			producto_id, sucursal_id, tipo_movimiento, cantidad, cantidad_anterior,
+			cantidad_nueva, documento_referencia, usuario_id, observaciones,
+			proceso_origen, batch_id
+		) VALUES ($1, $2, 'devolucion', $3, $4, $5, $6, $7, $8, 'maxima', $9)`,
+		productoID, sucursalID, cantidad, cantidadNueva-cantidad, cantidadNueva,
+		referencia, usuarioID, observaciones, batchID,
+	)
+	if err != nil {
+		return fmt.Errorf("error registrando movimiento de devolución: %w", err)
+	}
+
+	return nil
+}
+
+// obtenerClaveIdempotencia obtiene la clave desde el header o el UUID de venta del cliente
+func obtenerClaveIdempotencia(c *gin.Context, req *models.VentaRequest) (string, *apiError) {
+	clave := strings.TrimSpace(c.GetHeader(headerIdempotencia))
+	if clave == "" && req.VentaID != nil {
+		clave = req.VentaID.String()
+	}
+
+	if len(clave) > 255 {
+		return "", &apiError{
+			status:  http.StatusBadRequest,
+			code:    "INVALID_IDEMPOTENCY_KEY",
+			message: "La clave de idempotencia no puede superar 255 caracteres",
+		}
+	}
+
+	return clave, nil
+}
+
+// ventaDuplicada devuelve un conflicto con la venta ya registrada si el UUID informado por el terminal existe
+func ventaDuplicada(ctx context.Context, q sqlQueryer, ventaID uuid.UUID) error {
+	venta, err := getVentaOrigen(ctx, q, ventaID, false)
+	if err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.status == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+
+	return &apiError{
+		status:  http.StatusConflict,
+		code:    "VENTA_DUPLICADA",
+		message: "Ya existe una venta registrada con el ID informado",
+		details: models.JSONB{"venta": *venta},
+	}
+}
+
+// responderRepeticion responde con la venta ya registrada para la clave, si existe
+func (h *VentasHandler) responderRepeticion(ctx context.Context, c *gin.Context, clave, hashPeticion string) bool {
+	var hashOriginal string
+	var codigoHTTP sql.NullInt64
+	var respuesta []byte
+
+	err := h.db.QueryRowContext(ctx, `
+		SELECT hash_peticion, codigo_http, respuesta
+		FROM idempotencia_ventas
+		WHERE clave = $1 AND fecha_expiracion > NOW()`,
+		clave,
+	).Scan(&hashOriginal, &codigoHTTP, &respuesta)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			h.logger.WithError(err).Error("Error consultando clave de idempotencia")
+		}
+		return false
+	}
+
+	if hashOriginal != hashPeticion {
+		h.responderVentaError(c, &apiError{
+			status:  http.StatusConflict,
+			code:    "IDEMPOTENCY_KEY_CONFLICT",
+			message: "La clave de idempotencia ya fue utilizada con una venta distinta",
+			details: models.JSONB{"idempotency_key": clave},
+		})
+		return true
+	}
+
+	var response models.APIResponse
+	if !codigoHTTP.Valid || json.Unmarshal(respuesta, &response) != nil {
+		return false
+	}
+
+	h.logger.WithField("idempotency_key", clave).Info("Reintento de venta respondido desde cache de idempotencia")
+
+	c.Header("Idempotent-Replayed", "true")
+	c.JSON(int(codigoHTTP.Int64), response)
+	return true
+}
+
+// reservarClaveIdempotencia registra la clave dentro de la transacción de la venta.
+// Una petición concurrente con la misma clave queda bloqueada hasta que la primera termine.
+func (h *VentasHandler) reservarClaveIdempotencia(ctx context.Context, tx *sql.Tx, clave, hashPeticion string, venta *models.Venta) error {
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO idempotencia_ventas (
+			clave, hash_peticion, terminal_id, venta_id, fecha_creacion, fecha_expiracion
+		) VALUES ($1, $2, $3, $4, NOW(), $5)
+		ON CONFLICT (clave) DO UPDATE SET
+			hash_peticion = EXCLUDED.hash_peticion,
+			terminal_id = EXCLUDED.terminal_id,
+			venta_id = EXCLUDED.venta_id,
+			codigo_http = NULL,
+			respuesta = NULL,
+			fecha_creacion = NOW(),
+			fecha_expiracion = EXCLUDED.fecha_expiracion
+		WHERE idempotencia_ventas.fecha_expiracion <= NOW()`,
+		clave, hashPeticion, venta.TerminalID, venta.ID, time.Now().Add(vigenciaIdempotencia),
+	)
+	if err != nil {
+		return fmt.Errorf("error registrando clave de idempotencia: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errClaveIdempotenciaUsada
+	}
+
+	return nil
+}
+
+// guardarRespuestaIdempotencia almacena la respuesta enviada para la clave
+func (h *VentasHandler) guardarRespuestaIdempotencia(ctx context.Context, tx *sql.Tx, clave string, codigoHTTP int, response *models.APIResponse) error {
+	respuesta, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("error serializando respuesta: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE idempotencia_ventas SET codigo_http = $2, respuesta = $3 WHERE clave = $1`,
+		clave, codigoHTTP, respuesta,
+	)
+	if err != nil {
+		return fmt.Errorf("error guardando respuesta de idempotencia: %w", err)
+	}
+
+	return nil
+}
+
+// calcularHashPeticion calcula el hash del cuerpo de la venta para detectar reutilización de claves
+func calcularHashPeticion(req *models.VentaRequest) string {
+	cuerpo, _ := json.Marshal(req)
+	sum := sha256.Sum256(cuerpo)
+	return hex.EncodeToString(sum[:])
+}
+
+// calcularHashVenta calcula el hash de integridad de la venta
+func calcularHashVenta(venta *models.Venta, detalles []models.DetalleVenta, mediosPago []models.MedioPagoVenta) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s|%s|%s|%s|%s|%d|%.2f|%.2f|%.2f|%.2f",
+		venta.ID, venta.SucursalID, venta.TerminalID, venta.CajeroID, venta.TipoDocumento,
+		venta.Fecha.UnixNano(), venta.Subtotal, venta.DescuentoTotal, venta.ImpuestoTotal, venta.Total)
+	for _, d := range detalles {
+		fmt.Fprintf(&b, "|%s:%.3f:%.2f:%.2f", d.ProductoID, d.Cantidad, d.PrecioFinal, d.TotalItem)
+	}
+	for _, mp := range mediosPago {
+		fmt.Fprintf(&b, "|%s:%.2f", mp.MedioPago, mp.Monto)
+	}
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// redondearMonto redondea un monto a dos decimales
+func redondearMonto(monto float64) float64 {
+	return math.Round(monto*100) / 100
+}
+
+// redondearCantidad redondea una cantidad a la precisión de NUMERIC(10,3)
+func redondearCantidad(cantidad float64) float64 {
+	return math.Round(cantidad*1000) / 1000
+}
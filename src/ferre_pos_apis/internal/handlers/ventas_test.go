@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ferre_pos_apis/internal/models"
+)
+
+func TestPrepararMediosPago(t *testing.T) {
+	autorizacion := "AUT123"
+	efectivo := func(monto float64) models.MedioPagoRequest {
+		return models.MedioPagoRequest{MedioPago: "efectivo", Monto: monto}
+	}
+	tarjeta := func(monto float64) models.MedioPagoRequest {
+		return models.MedioPagoRequest{MedioPago: "tarjeta_debito", Monto: monto, CodigoAutorizacion: &autorizacion}
+	}
+
+	tests := []struct {
+		name   string
+		total  float64
+		pagos  []models.MedioPagoRequest
+		code   string
+		montos []float64
+		vuelto float64
+	}{
+		{
+			name:   "efectivo exacto",
+			total:  11900,
+			pagos:  []models.MedioPagoRequest{efectivo(11900)},
+			montos: []float64{11900},
+		},
+		{
+			name:   "vuelto desde efectivo",
+			total:  11900,
+			pagos:  []models.MedioPagoRequest{efectivo(20000)},
+			montos: []float64{11900},
+			vuelto: 8100,
+		},
+		{
+			name:   "pago mixto con vuelto solo desde efectivo",
+			total:  11900,
+			pagos:  []models.MedioPagoRequest{tarjeta(10000), efectivo(5000)},
+			montos: []float64{10000, 1900},
+			vuelto: 3100,
+		},
+		{
+			name:   "vuelto desde el último pago en efectivo",
+			total:  11900,
+			pagos:  []models.MedioPagoRequest{efectivo(10000), tarjeta(1000), efectivo(2000)},
+			montos: []float64{10000, 1000, 900},
+			vuelto: 1100,
+		},
+		{
+			name:  "tarjeta sobre el total",
+			total: 11900,
+			pagos: []models.MedioPagoRequest{tarjeta(12000)},
+			code:  "PAGO_EXCEDE_TOTAL",
+		},
+		{
+			name:  "tarjeta y transferencia sobre el total",
+			total: 11900,
+			pagos: []models.MedioPagoRequest{tarjeta(6000), {MedioPago: "transferencia", Monto: 6000}},
+			code:  "PAGO_EXCEDE_TOTAL",
+		},
+		{
+			name:  "efectivo innecesario",
+			total: 11900,
+			pagos: []models.MedioPagoRequest{tarjeta(11900), efectivo(1000)},
+			code:  "PAGO_EXCEDE_TOTAL",
+		},
+		{
+			name:  "pago insuficiente",
+			total: 11900,
+			pagos: []models.MedioPagoRequest{tarjeta(5000), efectivo(6899.99)},
+			code:  "PAGO_INSUFICIENTE",
+		},
+		{
+			name:  "tarjeta sin código de autorización",
+			total: 11900,
+			pagos: []models.MedioPagoRequest{{MedioPago: "tarjeta_credito", Monto: 11900}},
+			code:  "CODIGO_AUTORIZACION_REQUERIDO",
+		},
+		{
+			name:   "montos redondeados a centavos",
+			total:  0.3,
+			pagos:  []models.MedioPagoRequest{efectivo(0.1), {MedioPago: "transferencia", Monto: 0.2}},
+			montos: []float64{0.1, 0.2},
+		},
+		{
+			name:   "fracciones bajo el centavo no generan vuelto",
+			total:  1190.1,
+			pagos:  []models.MedioPagoRequest{efectivo(1190.104)},
+			montos: []float64{1190.1},
+		},
+		{
+			name:   "vuelto redondeado",
+			total:  1190.33,
+			pagos:  []models.MedioPagoRequest{efectivo(1200.005)},
+			montos: []float64{1190.33},
+			vuelto: 9.68,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			venta := &models.Venta{Total: tt.total, Fecha: time.Now()}
+			mediosPago, vErr := prepararMediosPago(venta, tt.pagos)
+
+			if tt.code != "" {
+				require.NotNil(t, vErr)
+				assert.Equal(t, tt.code, vErr.code)
+				return
+			}
+
+			require.Nil(t, vErr)
+			require.Len(t, mediosPago, len(tt.montos))
+			for i, monto := range tt.montos {
+				assert.Equal(t, monto, mediosPago[i].Monto, "medio de pago %d", i)
+				assert.Equal(t, tt.pagos[i].MedioPago, mediosPago[i].MedioPago)
+			}
+			assert.Equal(t, tt.vuelto, vueltoVenta(mediosPago))
+		})
+	}
+}
@@ -195,13 +195,35 @@ type Producto struct {
 	TotalEtiquetasGeneradas      int        `json:"total_etiquetas_generadas" db:"total_etiquetas_generadas"`
 }
 
+// UnidadMedidaProducto unidad de venta alternativa de un producto con su factor a la unidad base
+type UnidadMedidaProducto struct {
+	ID                uuid.UUID `json:"id" db:"id"`
+	ProductoID        uuid.UUID `json:"producto_id" db:"producto_id"`
+	Unidad            string    `json:"unidad" db:"unidad"`
+	FactorConversion  float64   `json:"factor_conversion" db:"factor_conversion"`
+	CodigoBarra       *string   `json:"codigo_barra,omitempty" db:"codigo_barra"`
+	PrecioUnitario    *float64  `json:"precio_unitario,omitempty" db:"precio_unitario"`
+	Activo            bool      `json:"activo" db:"activo"`
+	FechaCreacion     time.Time `json:"fecha_creacion" db:"fecha_creacion"`
+	FechaModificacion time.Time `json:"fecha_modificacion" db:"fecha_modificacion"`
+}
+
+// UnidadesProducto unidades de venta configuradas para un producto
+type UnidadesProducto struct {
+	ProductoID             uuid.UUID              `json:"producto_id"`
+	UnidadBase             string                 `json:"unidad_base"`
+	PermiteFraccionamiento bool                   `json:"permite_fraccionamiento"`
+	Unidades               []UnidadMedidaProducto `json:"unidades"`
+}
+
 // StockCentral modelo de stock central
 type StockCentral struct {
 	ProductoID           uuid.UUID  `json:"producto_id" db:"producto_id"`
 	SucursalID           uuid.UUID  `json:"sucursal_id" db:"sucursal_id"`
-	Cantidad             int        `json:"cantidad" db:"cantidad"`
-	CantidadReservada    int        `json:"cantidad_reservada" db:"cantidad_reservada"`
-	CantidadDisponible   int        `json:"cantidad_disponible" db:"cantidad_disponible"`
+	Cantidad             float64    `json:"cantidad" db:"cantidad"`
+	CantidadReservada    float64    `json:"cantidad_reservada" db:"cantidad_reservada"`
+	CantidadDisponible   float64    `json:"cantidad_disponible" db:"cantidad_disponible"`
+	CantidadEnTransito   float64    `json:"cantidad_en_transito" db:"cantidad_en_transito"`
 	CostoPromedio        *float64   `json:"costo_promedio,omitempty" db:"costo_promedio"`
 	FechaUltimaEntrada   *time.Time `json:"fecha_ultima_entrada,omitempty" db:"fecha_ultima_entrada"`
 	FechaUltimaSalida    *time.Time `json:"fecha_ultima_salida,omitempty" db:"fecha_ultima_salida"`
@@ -217,9 +239,9 @@ type MovimientoStock struct {
 	ProductoID           uuid.UUID         `json:"producto_id" db:"producto_id"`
 	SucursalID           uuid.UUID         `json:"sucursal_id" db:"sucursal_id"`
 	TipoMovimiento       string            `json:"tipo_movimiento" db:"tipo_movimiento"`
-	Cantidad             int               `json:"cantidad" db:"cantidad"`
-	CantidadAnterior     *int              `json:"cantidad_anterior,omitempty" db:"cantidad_anterior"`
-	CantidadNueva        *int              `json:"cantidad_nueva,omitempty" db:"cantidad_nueva"`
+	Cantidad             float64           `json:"cantidad" db:"cantidad"`
+	CantidadAnterior     *float64          `json:"cantidad_anterior,omitempty" db:"cantidad_anterior"`
+	CantidadNueva        *float64          `json:"cantidad_nueva,omitempty" db:"cantidad_nueva"`
 	CostoUnitario        *float64          `json:"costo_unitario,omitempty" db:"costo_unitario"`
 	DocumentoReferencia  *string           `json:"documento_referencia,omitempty" db:"documento_referencia"`
 	UsuarioID            *uuid.UUID        `json:"usuario_id,omitempty" db:"usuario_id"`
@@ -230,6 +252,610 @@ type MovimientoStock struct {
 	BatchID              *uuid.UUID        `json:"batch_id,omitempty" db:"batch_id"`
 }
 
+// SerieProducto unidad de un producto con requiere_serie identificada por su número de serie
+type SerieProducto struct {
+	ID               uuid.UUID  `json:"id" db:"id"`
+	ProductoID       uuid.UUID  `json:"producto_id" db:"producto_id"`
+	SucursalID       uuid.UUID  `json:"sucursal_id" db:"sucursal_id"`
+	NumeroSerie      string     `json:"numero_serie" db:"numero_serie"`
+	Estado           string     `json:"estado" db:"estado"`
+	DocumentoIngreso *string    `json:"documento_ingreso,omitempty" db:"documento_ingreso"`
+	FechaIngreso     time.Time  `json:"fecha_ingreso" db:"fecha_ingreso"`
+	UsuarioIngresoID *uuid.UUID `json:"usuario_ingreso_id,omitempty" db:"usuario_ingreso_id"`
+	VentaID          *uuid.UUID `json:"venta_id,omitempty" db:"venta_id"`
+	DetalleVentaID   *uuid.UUID `json:"detalle_venta_id,omitempty" db:"detalle_venta_id"`
+	FechaVenta       *time.Time `json:"fecha_venta,omitempty" db:"fecha_venta"`
+}
+
+// LoteStock existencia de un lote de producto en una sucursal
+type LoteStock struct {
+	ID               uuid.UUID  `json:"id" db:"id"`
+	ProductoID       uuid.UUID  `json:"producto_id" db:"producto_id"`
+	SucursalID       uuid.UUID  `json:"sucursal_id" db:"sucursal_id"`
+	Lote             string     `json:"lote" db:"lote"`
+	FechaVencimiento *time.Time `json:"fecha_vencimiento,omitempty" db:"fecha_vencimiento"`
+	CantidadInicial  float64    `json:"cantidad_inicial" db:"cantidad_inicial"`
+	Cantidad         float64    `json:"cantidad" db:"cantidad"`
+	DocumentoIngreso *string    `json:"documento_ingreso,omitempty" db:"documento_ingreso"`
+	FechaIngreso     time.Time  `json:"fecha_ingreso" db:"fecha_ingreso"`
+}
+
+// SugerenciaLote lote sugerido por FEFO con la cantidad a tomar de él
+type SugerenciaLote struct {
+	LoteStock
+	CantidadSugerida float64 `json:"cantidad_sugerida"`
+}
+
+// TransferenciaStock traslado de mercadería entre sucursales: solicitud, aprobación, despacho y recepción
+type TransferenciaStock struct {
+	ID                     uuid.UUID                   `json:"id" db:"id"`
+	NumeroTransferencia    int64                       `json:"numero_transferencia" db:"numero_transferencia"`
+	SucursalOrigenID       uuid.UUID                   `json:"sucursal_origen_id" db:"sucursal_origen_id"`
+	SucursalDestinoID      uuid.UUID                   `json:"sucursal_destino_id" db:"sucursal_destino_id"`
+	Estado                 string                      `json:"estado" db:"estado"`
+	Motivo                 *string                     `json:"motivo,omitempty" db:"motivo"`
+	Observaciones          *string                     `json:"observaciones,omitempty" db:"observaciones"`
+	UsuarioSolicitudID     uuid.UUID                   `json:"usuario_solicitud_id" db:"usuario_solicitud_id"`
+	FechaSolicitud         time.Time                   `json:"fecha_solicitud" db:"fecha_solicitud"`
+	UsuarioAprobacionID    *uuid.UUID                  `json:"usuario_aprobacion_id,omitempty" db:"usuario_aprobacion_id"`
+	FechaAprobacion        *time.Time                  `json:"fecha_aprobacion,omitempty" db:"fecha_aprobacion"`
+	MotivoRechazo          *string                     `json:"motivo_rechazo,omitempty" db:"motivo_rechazo"`
+	UsuarioDespachoID      *uuid.UUID                  `json:"usuario_despacho_id,omitempty" db:"usuario_despacho_id"`
+	FechaDespacho          *time.Time                  `json:"fecha_despacho,omitempty" db:"fecha_despacho"`
+	UsuarioRecepcionID     *uuid.UUID                  `json:"usuario_recepcion_id,omitempty" db:"usuario_recepcion_id"`
+	FechaRecepcion         *time.Time                  `json:"fecha_recepcion,omitempty" db:"fecha_recepcion"`
+	ObservacionesRecepcion *string                     `json:"observaciones_recepcion,omitempty" db:"observaciones_recepcion"`
+	BatchID                *uuid.UUID                  `json:"batch_id,omitempty" db:"batch_id"`
+	TotalItems             int                         `json:"total_items" db:"total_items"`
+	Detalles               []DetalleTransferenciaStock `json:"detalles,omitempty"`
+}
+
+// DetalleTransferenciaStock producto trasladado; diferencia es lo recibido menos lo despachado
+type DetalleTransferenciaStock struct {
+	ID                 uuid.UUID           `json:"id" db:"id"`
+	TransferenciaID    uuid.UUID           `json:"transferencia_id" db:"transferencia_id"`
+	ProductoID         uuid.UUID           `json:"producto_id" db:"producto_id"`
+	CodigoInterno      string              `json:"codigo_interno"`
+	Descripcion        string              `json:"descripcion"`
+	CantidadSolicitada float64             `json:"cantidad_solicitada" db:"cantidad_solicitada"`
+	CantidadDespachada *float64            `json:"cantidad_despachada,omitempty" db:"cantidad_despachada"`
+	CantidadRecibida   *float64            `json:"cantidad_recibida,omitempty" db:"cantidad_recibida"`
+	Diferencia         *float64            `json:"diferencia,omitempty" db:"diferencia"`
+	CostoUnitario      *float64            `json:"costo_unitario,omitempty" db:"costo_unitario"`
+	NumerosSerie       []string            `json:"numeros_serie,omitempty" db:"numeros_serie"`
+	Lotes              []LoteTransferencia `json:"lotes,omitempty" db:"lotes"`
+	MotivoDiferencia   *string             `json:"motivo_diferencia,omitempty" db:"motivo_diferencia"`
+}
+
+// LoteTransferencia cantidad despachada desde un lote de la sucursal de origen
+type LoteTransferencia struct {
+	Lote             string     `json:"lote"`
+	FechaVencimiento *time.Time `json:"fecha_vencimiento,omitempty"`
+	Cantidad         float64    `json:"cantidad"`
+}
+
+// ResumenTransferencia fila del reporte de transferencias con sus totales y discrepancias de recepción
+type ResumenTransferencia struct {
+	TransferenciaID     uuid.UUID  `json:"transferencia_id"`
+	NumeroTransferencia int64      `json:"numero_transferencia"`
+	SucursalOrigenID    uuid.UUID  `json:"sucursal_origen_id"`
+	SucursalOrigen      string     `json:"sucursal_origen"`
+	SucursalDestinoID   uuid.UUID  `json:"sucursal_destino_id"`
+	SucursalDestino     string     `json:"sucursal_destino"`
+	Estado              string     `json:"estado"`
+	FechaSolicitud      time.Time  `json:"fecha_solicitud"`
+	FechaDespacho       *time.Time `json:"fecha_despacho,omitempty"`
+	FechaRecepcion      *time.Time `json:"fecha_recepcion,omitempty"`
+	Productos           int        `json:"productos"`
+	CantidadSolicitada  float64    `json:"cantidad_solicitada"`
+	CantidadDespachada  float64    `json:"cantidad_despachada"`
+	CantidadRecibida    float64    `json:"cantidad_recibida"`
+	Faltante            float64    `json:"faltante"`
+	Sobrante            float64    `json:"sobrante"`
+	ValorDespachado     float64    `json:"valor_despachado"`
+	ValorDiferencia     float64    `json:"valor_diferencia"` // Diferencias valorizadas a costo de origen
+	LineasConDiferencia int        `json:"lineas_con_diferencia"`
+}
+
+// ConteoInventario toma de inventario físico de una sucursal sobre una foto de stock_central
+type ConteoInventario struct {
+	ID                  uuid.UUID  `json:"id" db:"id"`
+	NumeroConteo        int64      `json:"numero_conteo" db:"numero_conteo"`
+	SucursalID          uuid.UUID  `json:"sucursal_id" db:"sucursal_id"`
+	Tipo                string     `json:"tipo" db:"tipo"`
+	Estado              string     `json:"estado" db:"estado"`
+	CategoriaID         *uuid.UUID `json:"categoria_id,omitempty" db:"categoria_id"`
+	Pasillo             *string    `json:"pasillo,omitempty" db:"pasillo"`
+	Estante             *string    `json:"estante,omitempty" db:"estante"`
+	Observaciones       *string    `json:"observaciones,omitempty" db:"observaciones"`
+	UsuarioAperturaID   uuid.UUID  `json:"usuario_apertura_id" db:"usuario_apertura_id"`
+	FechaApertura       time.Time  `json:"fecha_apertura" db:"fecha_apertura"`
+	UsuarioCierreID     *uuid.UUID `json:"usuario_cierre_id,omitempty" db:"usuario_cierre_id"`
+	FechaCierre         *time.Time `json:"fecha_cierre,omitempty" db:"fecha_cierre"`
+	UsuarioAprobacionID *uuid.UUID `json:"usuario_aprobacion_id,omitempty" db:"usuario_aprobacion_id"`
+	FechaAprobacion     *time.Time `json:"fecha_aprobacion,omitempty" db:"fecha_aprobacion"`
+	MotivoCancelacion   *string    `json:"motivo_cancelacion,omitempty" db:"motivo_cancelacion"`
+	BatchID             *uuid.UUID `json:"batch_id,omitempty" db:"batch_id"`
+	TotalProductos      int        `json:"total_productos" db:"total_productos"`
+	ValorDiferencia     *float64   `json:"valor_diferencia,omitempty" db:"valor_diferencia"`
+	ProductosContados   int        `json:"productos_contados"`
+	ProductosEnReconteo int        `json:"productos_en_reconteo"`
+}
+
+// DetalleConteoInventario producto del conteo; diferencia es lo contado menos la foto de sistema
+type DetalleConteoInventario struct {
+	ID               uuid.UUID `json:"id" db:"id"`
+	ConteoID         uuid.UUID `json:"conteo_id" db:"conteo_id"`
+	ProductoID       uuid.UUID `json:"producto_id" db:"producto_id"`
+	CodigoInterno    string    `json:"codigo_interno"`
+	Descripcion      string    `json:"descripcion"`
+	Ubicacion        *string   `json:"ubicacion,omitempty" db:"ubicacion"`
+	CantidadSistema  float64   `json:"cantidad_sistema" db:"cantidad_sistema"`
+	CostoUnitario    *float64  `json:"costo_unitario,omitempty" db:"costo_unitario"`
+	CantidadContada  *float64  `json:"cantidad_contada,omitempty" db:"cantidad_contada"`
+	Ronda            int       `json:"ronda" db:"ronda"`
+	EnReconteo       bool      `json:"en_reconteo" db:"en_reconteo"`
+	CantidadAjustada *float64  `json:"cantidad_ajustada,omitempty" db:"cantidad_ajustada"`
+	Diferencia       *float64  `json:"diferencia,omitempty"`
+	ValorDiferencia  *float64  `json:"valor_diferencia,omitempty"`
+	Lecturas         int       `json:"lecturas"`   // Lecturas de la ronda vigente
+	Terminales       int       `json:"terminales"` // Terminales distintos que leyeron en la ronda vigente
+}
+
+// DiferenciasConteo reporte de diferencias de un conteo valorizadas a costo promedio
+type DiferenciasConteo struct {
+	Conteo              ConteoInventario          `json:"conteo"`
+	Lineas              []DetalleConteoInventario `json:"lineas"`
+	LineasContadas      int                       `json:"lineas_contadas"`
+	LineasSinContar     int                       `json:"lineas_sin_contar"`
+	LineasConDiferencia int                       `json:"lineas_con_diferencia"`
+	ValorSistema        float64                   `json:"valor_sistema"`
+	ValorSobrante       float64                   `json:"valor_sobrante"`
+	ValorFaltante       float64                   `json:"valor_faltante"`
+	ValorNeto           float64                   `json:"valor_neto"`
+	Exactitud           float64                   `json:"exactitud"` // Porcentaje de líneas contadas sin diferencia
+}
+
+// Proveedor modelo de proveedor de mercadería
+type Proveedor struct {
+	ID                uuid.UUID `json:"id" db:"id"`
+	RUT               string    `json:"rut" db:"rut"`
+	RazonSocial       string    `json:"razon_social" db:"razon_social"`
+	NombreFantasia    *string   `json:"nombre_fantasia,omitempty" db:"nombre_fantasia"`
+	Giro              *string   `json:"giro,omitempty" db:"giro"`
+	Direccion         *string   `json:"direccion,omitempty" db:"direccion"`
+	Comuna            *string   `json:"comuna,omitempty" db:"comuna"`
+	Telefono          *string   `json:"telefono,omitempty" db:"telefono"`
+	Email             *string   `json:"email,omitempty" db:"email"`
+	Contacto          *string   `json:"contacto,omitempty" db:"contacto"`
+	DiasCredito       int       `json:"dias_credito" db:"dias_credito"`
+	DiasEntrega       int       `json:"dias_entrega" db:"dias_entrega"`
+	Activo            bool      `json:"activo" db:"activo"`
+	FechaCreacion     time.Time `json:"fecha_creacion" db:"fecha_creacion"`
+	FechaModificacion time.Time `json:"fecha_modificacion" db:"fecha_modificacion"`
+}
+
+// ProductoProveedor condiciones de compra de un producto a un proveedor
+type ProductoProveedor struct {
+	ID                uuid.UUID `json:"id" db:"id"`
+	ProductoID        uuid.UUID `json:"producto_id" db:"producto_id"`
+	CodigoInterno     string    `json:"codigo_interno"`
+	Descripcion       string    `json:"descripcion"`
+	ProveedorID       uuid.UUID `json:"proveedor_id" db:"proveedor_id"`
+	CodigoProveedor   *string   `json:"codigo_proveedor,omitempty" db:"codigo_proveedor"`
+	UnidadCompra      float64   `json:"unidad_compra" db:"unidad_compra"` // Unidades base por empaque
+	CostoReferencia   *float64  `json:"costo_referencia,omitempty" db:"costo_referencia"`
+	DiasEntrega       *int      `json:"dias_entrega,omitempty" db:"dias_entrega"`
+	Preferido         bool      `json:"preferido" db:"preferido"`
+	Activo            bool      `json:"activo" db:"activo"`
+	FechaCreacion     time.Time `json:"fecha_creacion" db:"fecha_creacion"`
+	FechaModificacion time.Time `json:"fecha_modificacion" db:"fecha_modificacion"`
+}
+
+// OrdenCompra pedido de mercadería a un proveedor para una sucursal
+type OrdenCompra struct {
+	ID                   uuid.UUID            `json:"id" db:"id"`
+	NumeroOrden          int64                `json:"numero_orden" db:"numero_orden"`
+	ProveedorID          uuid.UUID            `json:"proveedor_id" db:"proveedor_id"`
+	RazonSocialProveedor string               `json:"razon_social_proveedor"`
+	SucursalID           uuid.UUID            `json:"sucursal_id" db:"sucursal_id"`
+	Estado               string               `json:"estado" db:"estado"`
+	FechaEntregaEstimada *time.Time           `json:"fecha_entrega_estimada,omitempty" db:"fecha_entrega_estimada"`
+	Observaciones        *string              `json:"observaciones,omitempty" db:"observaciones"`
+	TotalNeto            float64              `json:"total_neto" db:"total_neto"`
+	UsuarioCreacionID    uuid.UUID            `json:"usuario_creacion_id" db:"usuario_creacion_id"`
+	FechaCreacion        time.Time            `json:"fecha_creacion" db:"fecha_creacion"`
+	UsuarioEmisionID     *uuid.UUID           `json:"usuario_emision_id,omitempty" db:"usuario_emision_id"`
+	FechaEmision         *time.Time           `json:"fecha_emision,omitempty" db:"fecha_emision"`
+	FechaCancelacion     *time.Time           `json:"fecha_cancelacion,omitempty" db:"fecha_cancelacion"`
+	MotivoCancelacion    *string              `json:"motivo_cancelacion,omitempty" db:"motivo_cancelacion"`
+	Detalles             []DetalleOrdenCompra `json:"detalles,omitempty"`
+}
+
+// DetalleOrdenCompra producto pedido con lo recibido acumulado
+type DetalleOrdenCompra struct {
+	ID               uuid.UUID `json:"id" db:"id"`
+	OrdenCompraID    uuid.UUID `json:"orden_compra_id" db:"orden_compra_id"`
+	ProductoID       uuid.UUID `json:"producto_id" db:"producto_id"`
+	CodigoInterno    string    `json:"codigo_interno"`
+	Descripcion      string    `json:"descripcion"`
+	Cantidad         float64   `json:"cantidad" db:"cantidad"`
+	CostoUnitario    float64   `json:"costo_unitario" db:"costo_unitario"`
+	CantidadRecibida float64   `json:"cantidad_recibida" db:"cantidad_recibida"`
+}
+
+// RecepcionCompra ingreso de mercadería respaldado por el documento del proveedor
+type RecepcionCompra struct {
+	ID                 uuid.UUID                `json:"id" db:"id"`
+	NumeroRecepcion    int64                    `json:"numero_recepcion" db:"numero_recepcion"`
+	ProveedorID        uuid.UUID                `json:"proveedor_id" db:"proveedor_id"`
+	SucursalID         uuid.UUID                `json:"sucursal_id" db:"sucursal_id"`
+	OrdenCompraID      *uuid.UUID               `json:"orden_compra_id,omitempty" db:"orden_compra_id"`
+	TipoDocumento      string                   `json:"tipo_documento" db:"tipo_documento"`
+	NumeroDocumento    string                   `json:"numero_documento" db:"numero_documento"`
+	FechaDocumento     time.Time                `json:"fecha_documento" db:"fecha_documento"`
+	FechaVencimiento   time.Time                `json:"fecha_vencimiento" db:"fecha_vencimiento"`
+	MontoNeto          float64                  `json:"monto_neto" db:"monto_neto"`
+	MontoIVA           float64                  `json:"monto_iva" db:"monto_iva"`
+	MontoTotal         float64                  `json:"monto_total" db:"monto_total"`
+	MontoPagado        float64                  `json:"monto_pagado" db:"monto_pagado"`
+	EstadoPago         string                   `json:"estado_pago" db:"estado_pago"`
+	Observaciones      *string                  `json:"observaciones,omitempty" db:"observaciones"`
+	UsuarioID          uuid.UUID                `json:"usuario_id" db:"usuario_id"`
+	FechaRecepcion     time.Time                `json:"fecha_recepcion" db:"fecha_recepcion"`
+	BatchID            *uuid.UUID               `json:"batch_id,omitempty" db:"batch_id"`
+	TrabajoEtiquetasID *uuid.UUID               `json:"trabajo_etiquetas_id,omitempty" db:"trabajo_etiquetas_id"`
+	Detalles           []DetalleRecepcionCompra `json:"detalles,omitempty"`
+}
+
+// DetalleRecepcionCompra producto recibido con el costo promedio de la sucursal antes y después del ingreso
+type DetalleRecepcionCompra struct {
+	ID                    uuid.UUID  `json:"id" db:"id"`
+	RecepcionID           uuid.UUID  `json:"recepcion_id" db:"recepcion_id"`
+	ProductoID            uuid.UUID  `json:"producto_id" db:"producto_id"`
+	CodigoInterno         string     `json:"codigo_interno"`
+	Descripcion           string     `json:"descripcion"`
+	DetalleOrdenID        *uuid.UUID `json:"detalle_orden_id,omitempty" db:"detalle_orden_id"` // Sin valor si el producto no estaba pedido
+	Cantidad              float64    `json:"cantidad" db:"cantidad"`
+	CostoUnitario         float64    `json:"costo_unitario" db:"costo_unitario"`
+	CostoPromedioAnterior *float64   `json:"costo_promedio_anterior,omitempty" db:"costo_promedio_anterior"`
+	CostoPromedioNuevo    *float64   `json:"costo_promedio_nuevo,omitempty" db:"costo_promedio_nuevo"`
+	Lote                  *string    `json:"lote,omitempty" db:"lote"`
+	FechaVencimiento      *time.Time `json:"fecha_vencimiento,omitempty" db:"fecha_vencimiento"`
+	NumerosSerie          []string   `json:"numeros_serie,omitempty" db:"numeros_serie"`
+}
+
+// CuentaPorPagar deuda vigente con un proveedor por tramos de antigüedad
+type CuentaPorPagar struct {
+	ProveedorID        uuid.UUID       `json:"proveedor_id"`
+	RUT                string          `json:"rut"`
+	RazonSocial        string          `json:"razon_social"`
+	DiasCredito        int             `json:"dias_credito"`
+	Documentos         int             `json:"documentos"`
+	ProximoVencimiento *time.Time      `json:"proximo_vencimiento,omitempty"`
+	Antiguedad         AntiguedadDeuda `json:"antiguedad"`
+}
+
+// SugerenciaReposicion cálculo de reposición de un producto en una sucursal. Las cantidades van en unidad base
+type SugerenciaReposicion struct {
+	ProductoID         uuid.UUID  `json:"producto_id"`
+	CodigoInterno      string     `json:"codigo_interno"`
+	Descripcion        string     `json:"descripcion"`
+	ProveedorID        *uuid.UUID `json:"proveedor_id,omitempty"`
+	CodigoProveedor    *string    `json:"codigo_proveedor,omitempty"`
+	VentaDiaria        float64    `json:"venta_diaria"`
+	DesviacionDiaria   float64    `json:"desviacion_diaria"`
+	DiasEntrega        int        `json:"dias_entrega"`
+	StockSeguridad     float64    `json:"stock_seguridad"`
+	PuntoReorden       float64    `json:"punto_reorden"`
+	StockMinimo        float64    `json:"stock_minimo"`
+	StockMaximo        *float64   `json:"stock_maximo,omitempty"`
+	StockObjetivo      float64    `json:"stock_objetivo"`
+	StockDisponible    float64    `json:"stock_disponible"`
+	EnTransito         float64    `json:"en_transito"`
+	PendienteCompra    float64    `json:"pendiente_compra"` // Pedido en órdenes abiertas y aún no recibido
+	PosicionInventario float64    `json:"posicion_inventario"`
+	UnidadCompra       float64    `json:"unidad_compra"`
+	CantidadSugerida   float64    `json:"cantidad_sugerida"`
+	CostoUnitario      *float64   `json:"costo_unitario,omitempty"`
+}
+
+// SugerenciasProveedor sugerencias de reposición agrupadas por el proveedor al que se compran
+type SugerenciasProveedor struct {
+	ProveedorID *uuid.UUID             `json:"proveedor_id,omitempty"`
+	RazonSocial *string                `json:"razon_social,omitempty"`
+	Items       []SugerenciaReposicion `json:"items"`
+	TotalNeto   float64                `json:"total_neto"`
+}
+
+// AlertaStock alerta generada al cruzar un umbral de stock, con su reconocimiento y resolución
+type AlertaStock struct {
+	ID                      uuid.UUID  `json:"id" db:"id"`
+	ProductoID              uuid.UUID  `json:"producto_id" db:"producto_id"`
+	CodigoInterno           string     `json:"codigo_interno"`
+	Descripcion             string     `json:"descripcion"`
+	SucursalID              uuid.UUID  `json:"sucursal_id" db:"sucursal_id"`
+	Tipo                    string     `json:"tipo" db:"tipo"`
+	Estado                  string     `json:"estado" db:"estado"`
+	CantidadActual          *float64   `json:"cantidad_actual,omitempty" db:"cantidad_actual"`
+	Umbral                  *float64   `json:"umbral,omitempty" db:"umbral"`
+	Mensaje                 string     `json:"mensaje" db:"mensaje"`
+	MovimientoID            *uuid.UUID `json:"movimiento_id,omitempty" db:"movimiento_id"`
+	TipoMovimiento          *string    `json:"tipo_movimiento,omitempty" db:"tipo_movimiento"`
+	DocumentoReferencia     *string    `json:"documento_referencia,omitempty" db:"documento_referencia"`
+	FechaCreacion           time.Time  `json:"fecha_creacion" db:"fecha_creacion"`
+	UsuarioReconocimientoID *uuid.UUID `json:"usuario_reconocimiento_id,omitempty" db:"usuario_reconocimiento_id"`
+	FechaReconocimiento     *time.Time `json:"fecha_reconocimiento,omitempty" db:"fecha_reconocimiento"`
+	UsuarioResolucionID     *uuid.UUID `json:"usuario_resolucion_id,omitempty" db:"usuario_resolucion_id"`
+	FechaResolucion         *time.Time `json:"fecha_resolucion,omitempty" db:"fecha_resolucion"`
+	ObservacionesResolucion *string    `json:"observaciones_resolucion,omitempty" db:"observaciones_resolucion"`
+}
+
+// ConfiguracionAlertasStock umbrales de alerta de un producto en una sucursal o de una categoría.
+// Las claves omitidas usan la configuración de la categoría o el valor por defecto
+type ConfiguracionAlertasStock struct {
+	Activas        *bool    `json:"activas,omitempty"`
+	StockMinimo    *float64 `json:"stock_minimo,omitempty" validate:"omitempty,gte=0"` // Por defecto productos.stock_minimo
+	SinStock       *bool    `json:"sin_stock,omitempty"`
+	AjusteNegativo *float64 `json:"ajuste_negativo,omitempty" validate:"omitempty,gt=0"`
+	MermaMaxima    *float64 `json:"merma_maxima,omitempty" validate:"omitempty,gt=0"`
+	MermaDias      *int     `json:"merma_dias,omitempty" validate:"omitempty,min=1,max=365"`
+}
+
+// ReservaStock stock comprometido por una nota de venta, cotización o carrito hasta su vencimiento
+type ReservaStock struct {
+	ID                  uuid.UUID  `json:"id" db:"id"`
+	ProductoID          uuid.UUID  `json:"producto_id" db:"producto_id"`
+	SucursalID          uuid.UUID  `json:"sucursal_id" db:"sucursal_id"`
+	Cantidad            float64    `json:"cantidad" db:"cantidad"` // En unidad base del producto
+	TipoOrigen          string     `json:"tipo_origen" db:"tipo_origen"`
+	OrigenID            uuid.UUID  `json:"origen_id" db:"origen_id"`
+	UsuarioID           uuid.UUID  `json:"usuario_id" db:"usuario_id"`
+	TerminalID          *uuid.UUID `json:"terminal_id,omitempty" db:"terminal_id"`
+	Estado              string     `json:"estado" db:"estado"`
+	FechaCreacion       time.Time  `json:"fecha_creacion" db:"fecha_creacion"`
+	FechaModificacion   time.Time  `json:"fecha_modificacion" db:"fecha_modificacion"`
+	FechaExpiracion     time.Time  `json:"fecha_expiracion" db:"fecha_expiracion"`
+	FechaLiberacion     *time.Time `json:"fecha_liberacion,omitempty" db:"fecha_liberacion"`
+	UsuarioLiberacionID *uuid.UUID `json:"usuario_liberacion_id,omitempty" db:"usuario_liberacion_id"`
+	MotivoLiberacion    *string    `json:"motivo_liberacion,omitempty" db:"motivo_liberacion"`
+	Observaciones       *string    `json:"observaciones,omitempty" db:"observaciones"`
+}
+
+// SugerenciaFEFO lotes a despachar primero según su vencimiento
+type SugerenciaFEFO struct {
+	ProductoID         uuid.UUID        `json:"producto_id"`
+	SucursalID         uuid.UUID        `json:"sucursal_id"`
+	CantidadSolicitada float64          `json:"cantidad_solicitada"`
+	CantidadCubierta   float64          `json:"cantidad_cubierta"`
+	Lotes              []SugerenciaLote `json:"lotes"`
+}
+
+// GarantiaSerie venta en que salió un número de serie, para reclamos de garantía
+type GarantiaSerie struct {
+	Serie               SerieProducto `json:"serie"`
+	ProductoCodigo      string        `json:"producto_codigo"`
+	ProductoDescripcion string        `json:"producto_descripcion"`
+	NumeroVenta         *int64        `json:"numero_venta,omitempty"`
+	FechaVenta          *time.Time    `json:"fecha_venta,omitempty"`
+	TipoDocumento       *string       `json:"tipo_documento,omitempty"`
+	EstadoVenta         *string       `json:"estado_venta,omitempty"`
+	ClienteRUT          *string       `json:"cliente_rut,omitempty"`
+	ClienteNombre       *string       `json:"cliente_nombre,omitempty"`
+	PrecioFinal         *float64      `json:"precio_final,omitempty"`
+}
+
+// NotaCredito modelo de nota de crédito por devolución de una venta
+type NotaCredito struct {
+	ID                   uuid.UUID              `json:"id" db:"id"`
+	NumeroNota           int64                  `json:"numero_nota" db:"numero_nota"`
+	SucursalID           uuid.UUID              `json:"sucursal_id" db:"sucursal_id"`
+	DocumentoOrigenID    *uuid.UUID             `json:"documento_origen_id,omitempty" db:"documento_origen_id"`
+	VentaOrigenID        uuid.UUID              `json:"venta_origen_id" db:"venta_origen_id"`
+	TerminalID           uuid.UUID              `json:"terminal_id" db:"terminal_id"`
+	SupervisorID         *uuid.UUID             `json:"supervisor_id,omitempty" db:"supervisor_id"`
+	CajeroID             uuid.UUID              `json:"cajero_id" db:"cajero_id"`
+	Motivo               string                 `json:"motivo" db:"motivo"`
+	Subtotal             float64                `json:"subtotal" db:"subtotal"`
+	DescuentoTotal       float64                `json:"descuento_total" db:"descuento_total"`
+	ImpuestoTotal        float64                `json:"impuesto_total" db:"impuesto_total"`
+	Total                float64                `json:"total" db:"total"`
+	Estado               EstadoDocumento        `json:"estado" db:"estado"`
+	FechaSolicitud       time.Time              `json:"fecha_solicitud" db:"fecha_solicitud"`
+	FechaAutorizacion    *time.Time             `json:"fecha_autorizacion,omitempty" db:"fecha_autorizacion"`
+	FechaEmision         *time.Time             `json:"fecha_emision,omitempty" db:"fecha_emision"`
+	DTEID                *uuid.UUID             `json:"dte_id,omitempty" db:"dte_id"`
+	Observaciones        *string                `json:"observaciones,omitempty" db:"observaciones"`
+	CodigoAutorizacion   *string                `json:"codigo_autorizacion,omitempty" db:"codigo_autorizacion"`
+	TiempoAutorizacionMs *int                   `json:"tiempo_autorizacion_ms,omitempty" db:"tiempo_autorizacion_ms"`
+	Detalles             []DetalleNotaCredito   `json:"detalles,omitempty"`
+	Reembolsos           []ReembolsoNotaCredito `json:"reembolsos,omitempty"`
+}
+
+// DetalleNotaCredito línea devuelta de una venta
+type DetalleNotaCredito struct {
+	ID              uuid.UUID `json:"id" db:"id"`
+	NotaCreditoID   uuid.UUID `json:"nota_credito_id" db:"nota_credito_id"`
+	DetalleVentaID  uuid.UUID `json:"detalle_venta_id" db:"detalle_venta_id"`
+	ProductoID      uuid.UUID `json:"producto_id" db:"producto_id"`
+	Cantidad        float64   `json:"cantidad" db:"cantidad"`
+	PrecioUnitario  float64   `json:"precio_unitario" db:"precio_unitario"`
+	TotalItem       float64   `json:"total_item" db:"total_item"`
+	MotivoItem      *string   `json:"motivo_item,omitempty" db:"motivo_item"`
+	AfectaStock     bool      `json:"afecta_stock" db:"afecta_stock"`
+	StockRestaurado bool      `json:"stock_restaurado" db:"stock_restaurado"`
+}
+
+// ReembolsoNotaCredito medio con que se devuelve el dinero de una nota de crédito
+type ReembolsoNotaCredito struct {
+	ID                    uuid.UUID  `json:"id" db:"id"`
+	NotaCreditoID         uuid.UUID  `json:"nota_credito_id" db:"nota_credito_id"`
+	MedioPago             string     `json:"medio_pago" db:"medio_pago"`
+	Monto                 float64    `json:"monto" db:"monto"`
+	ReferenciaTransaccion *string    `json:"referencia_transaccion,omitempty" db:"referencia_transaccion"`
+	SesionCajaID          *uuid.UUID `json:"sesion_caja_id,omitempty" db:"sesion_caja_id"`
+	Fecha                 time.Time  `json:"fecha" db:"fecha"`
+}
+
+// LineaDevolvible línea de una venta con la cantidad que aún puede devolverse
+type LineaDevolvible struct {
+	DetalleVentaID     uuid.UUID `json:"detalle_venta_id"`
+	ProductoID         uuid.UUID `json:"producto_id"`
+	Descripcion        string    `json:"descripcion"`
+	UnidadMedida       *string   `json:"unidad_medida,omitempty"`
+	NumeroSerie        *string   `json:"numero_serie,omitempty"`
+	Lote               *string   `json:"lote,omitempty"`
+	PrecioFinal        float64   `json:"precio_final"`
+	CantidadVendida    float64   `json:"cantidad_vendida"`
+	CantidadDevuelta   float64   `json:"cantidad_devuelta"`
+	CantidadDevolvible float64   `json:"cantidad_devolvible"`
+}
+
+// Despacho modelo de entrega de mercadería preparada por bodega
+type Despacho struct {
+	ID                    uuid.UUID            `json:"id" db:"id"`
+	NumeroDespacho        int64                `json:"numero_despacho" db:"numero_despacho"`
+	SucursalID            uuid.UUID            `json:"sucursal_id" db:"sucursal_id"`
+	DocumentoID           *uuid.UUID           `json:"documento_id,omitempty" db:"documento_id"`
+	VentaID               *uuid.UUID           `json:"venta_id,omitempty" db:"venta_id"`
+	UsuarioDespachoID     *uuid.UUID           `json:"usuario_despacho_id,omitempty" db:"usuario_despacho_id"`
+	ClienteRUT            *string              `json:"cliente_rut,omitempty" db:"cliente_rut"`
+	ClienteNombre         *string              `json:"cliente_nombre,omitempty" db:"cliente_nombre"`
+	Estado                string               `json:"estado" db:"estado"`
+	FechaProgramada       *time.Time           `json:"fecha_programada,omitempty" db:"fecha_programada"`
+	FechaInicio           *time.Time           `json:"fecha_inicio,omitempty" db:"fecha_inicio"`
+	FechaCompletado       *time.Time           `json:"fecha_completado,omitempty" db:"fecha_completado"`
+	Observaciones         *string              `json:"observaciones,omitempty" db:"observaciones"`
+	DatosAdicionales      JSONB                `json:"datos_adicionales,omitempty" db:"datos_adicionales"`
+	PrioridadDespacho     int                  `json:"prioridad_despacho" db:"prioridad_despacho"`
+	TiempoEstimadoMinutos *int                 `json:"tiempo_estimado_minutos,omitempty" db:"tiempo_estimado_minutos"`
+	CodigoSeguimiento     *string              `json:"codigo_seguimiento,omitempty" db:"codigo_seguimiento"`
+	UbicacionPicking      *string              `json:"ubicacion_picking,omitempty" db:"ubicacion_picking"`
+	FirmaNombre           *string              `json:"firma_nombre,omitempty" db:"firma_nombre"`
+	FirmaRUT              *string              `json:"firma_rut,omitempty" db:"firma_rut"`
+	FechaFirma            *time.Time           `json:"fecha_firma,omitempty" db:"fecha_firma"`
+	ListaPickingID        *uuid.UUID           `json:"lista_picking_id,omitempty" db:"lista_picking_id"`
+	TotalLineas           int                  `json:"total_lineas"`
+	LineasPendientes      int                  `json:"lineas_pendientes"`
+	Detalles              []DetalleDespacho    `json:"detalles,omitempty"`
+	Incidencias           []IncidenciaDespacho `json:"incidencias,omitempty"`
+}
+
+// DetalleDespacho línea de un despacho; las cantidades se expresan en la unidad base del producto
+type DetalleDespacho struct {
+	ID                    uuid.UUID  `json:"id" db:"id"`
+	DespachoID            uuid.UUID  `json:"despacho_id" db:"despacho_id"`
+	ProductoID            uuid.UUID  `json:"producto_id" db:"producto_id"`
+	CodigoInterno         string     `json:"codigo_interno"`
+	Descripcion           string     `json:"descripcion"`
+	CantidadSolicitada    float64    `json:"cantidad_solicitada" db:"cantidad_solicitada"`
+	CantidadDespachada    float64    `json:"cantidad_despachada" db:"cantidad_despachada"`
+	NumeroSerie           *string    `json:"numero_serie,omitempty" db:"numero_serie"`
+	Lote                  *string    `json:"lote,omitempty" db:"lote"`
+	Observaciones         *string    `json:"observaciones,omitempty" db:"observaciones"`
+	FechaDespacho         *time.Time `json:"fecha_despacho,omitempty" db:"fecha_despacho"`
+	UsuarioDespachoID     *uuid.UUID `json:"usuario_despacho_id,omitempty" db:"usuario_despacho_id"`
+	UbicacionProducto     *string    `json:"ubicacion_producto,omitempty" db:"ubicacion_producto"`
+	OrdenPicking          *int       `json:"orden_picking,omitempty" db:"orden_picking"`
+	TiempoPickingSegundos *int       `json:"tiempo_picking_segundos,omitempty" db:"tiempo_picking_segundos"`
+}
+
+// IncidenciaDespacho problema registrado durante la preparación o entrega de un despacho
+type IncidenciaDespacho struct {
+	ID                uuid.UUID  `json:"id" db:"id"`
+	DespachoID        uuid.UUID  `json:"despacho_id" db:"despacho_id"`
+	DetalleDespachoID *uuid.UUID `json:"detalle_despacho_id,omitempty" db:"detalle_despacho_id"`
+	Tipo              string     `json:"tipo" db:"tipo"`
+	Descripcion       string     `json:"descripcion" db:"descripcion"`
+	CantidadAfectada  *float64   `json:"cantidad_afectada,omitempty" db:"cantidad_afectada"`
+	UsuarioID         *uuid.UUID `json:"usuario_id,omitempty" db:"usuario_id"`
+	Fecha             time.Time  `json:"fecha" db:"fecha"`
+}
+
+// UbicacionProducto ubicación en bodega de un producto en una sucursal
+type UbicacionProducto struct {
+	ID                    uuid.UUID  `json:"id" db:"id"`
+	ProductoID            uuid.UUID  `json:"producto_id" db:"producto_id"`
+	SucursalID            uuid.UUID  `json:"sucursal_id" db:"sucursal_id"`
+	Pasillo               string     `json:"pasillo" db:"pasillo"`
+	Estante               string     `json:"estante" db:"estante"`
+	Nivel                 string     `json:"nivel" db:"nivel"`
+	Codigo                string     `json:"codigo"` // Pasillo-estante-nivel
+	Observaciones         *string    `json:"observaciones,omitempty" db:"observaciones"`
+	FechaModificacion     time.Time  `json:"fecha_modificacion" db:"fecha_modificacion"`
+	UsuarioModificacionID *uuid.UUID `json:"usuario_modificacion_id,omitempty" db:"usuario_modificacion_id"`
+}
+
+// ListaPicking recolección conjunta de varios despachos en orden de recorrido por la bodega
+type ListaPicking struct {
+	ID                     uuid.UUID      `json:"id" db:"id"`
+	NumeroLista            int64          `json:"numero_lista" db:"numero_lista"`
+	SucursalID             uuid.UUID      `json:"sucursal_id" db:"sucursal_id"`
+	Estado                 string         `json:"estado" db:"estado"`
+	UsuarioCreacionID      *uuid.UUID     `json:"usuario_creacion_id,omitempty" db:"usuario_creacion_id"`
+	UsuarioPickingID       *uuid.UUID     `json:"usuario_picking_id,omitempty" db:"usuario_picking_id"`
+	UbicacionConsolidacion *string        `json:"ubicacion_consolidacion,omitempty" db:"ubicacion_consolidacion"`
+	TotalDespachos         int            `json:"total_despachos" db:"total_despachos"`
+	TotalLineas            int            `json:"total_lineas" db:"total_lineas"`
+	FechaCreacion          time.Time      `json:"fecha_creacion" db:"fecha_creacion"`
+	FechaInicio            *time.Time     `json:"fecha_inicio,omitempty" db:"fecha_inicio"`
+	FechaCompletado        *time.Time     `json:"fecha_completado,omitempty" db:"fecha_completado"`
+	TiempoTotalSegundos    *int           `json:"tiempo_total_segundos,omitempty" db:"tiempo_total_segundos"`
+	Lineas                 []LineaPicking `json:"lineas,omitempty"`
+}
+
+// LineaPicking línea de despacho a recoger, en el orden de recorrido de la lista
+type LineaPicking struct {
+	OrdenPicking          int       `json:"orden_picking"`
+	DetalleDespachoID     uuid.UUID `json:"detalle_despacho_id"`
+	DespachoID            uuid.UUID `json:"despacho_id"`
+	NumeroDespacho        int64     `json:"numero_despacho"`
+	ProductoID            uuid.UUID `json:"producto_id"`
+	CodigoInterno         string    `json:"codigo_interno"`
+	Descripcion           string    `json:"descripcion"`
+	Ubicacion             *string   `json:"ubicacion,omitempty"` // Sin ubicación registrada la línea va al final del recorrido
+	CantidadSolicitada    float64   `json:"cantidad_solicitada"`
+	CantidadDespachada    float64   `json:"cantidad_despachada"`
+	TiempoPickingSegundos *int      `json:"tiempo_picking_segundos,omitempty"`
+}
+
+// RendimientoPicking productividad de recolección de un usuario en un período
+type RendimientoPicking struct {
+	UsuarioID             uuid.UUID `json:"usuario_id"`
+	Nombre                string    `json:"nombre"`
+	Listas                int       `json:"listas"`
+	Despachos             int       `json:"despachos"`
+	Lineas                int       `json:"lineas"`
+	Unidades              float64   `json:"unidades"`
+	TiempoTotalSegundos   int       `json:"tiempo_total_segundos"`
+	SegundosPromedioLinea float64   `json:"segundos_promedio_linea"`
+	LineasPorHora         float64   `json:"lineas_por_hora"`
+}
+
+// VentaDevolvible venta de origen de una devolución con sus líneas devolvibles
+type VentaDevolvible struct {
+	Venta                Venta              `json:"venta"`
+	Lineas               []LineaDevolvible  `json:"lineas"`
+	ReembolsablePorMedio map[string]float64 `json:"reembolsable_por_medio"`
+}
+
+// DocumentoDTE documento tributario electrónico emitido para envío al SII
+type DocumentoDTE struct {
+	ID                  uuid.UUID       `json:"id" db:"id"`
+	SucursalID          uuid.UUID       `json:"sucursal_id" db:"sucursal_id"`
+	VentaID             *uuid.UUID      `json:"venta_id,omitempty" db:"venta_id"`
+	TipoDocumento       string          `json:"tipo_documento" db:"tipo_documento"`
+	Folio               int64           `json:"folio" db:"folio"`
+	RUTReceptor         *string         `json:"rut_receptor,omitempty" db:"rut_receptor"`
+	RazonSocialReceptor *string         `json:"razon_social_receptor,omitempty" db:"razon_social_receptor"`
+	FechaEmision        time.Time       `json:"fecha_emision" db:"fecha_emision"`
+	MontoNeto           float64         `json:"monto_neto" db:"monto_neto"`
+	MontoIVA            float64         `json:"monto_iva" db:"monto_iva"`
+	MontoTotal          float64         `json:"monto_total" db:"monto_total"`
+	Estado              EstadoDocumento `json:"estado" db:"estado"`
+	DatosAdicionales    JSONB           `json:"datos_adicionales,omitempty" db:"datos_adicionales"`
+}
+
 // Terminal modelo de terminal
 type Terminal struct {
 	ID                     uuid.UUID `json:"id" db:"id"`
@@ -299,6 +925,426 @@ type DetalleVenta struct {
 	DatosAdicionales     JSONB      `json:"datos_adicionales,omitempty" db:"datos_adicionales"`
 	MargenUnitario       *float64   `json:"margen_unitario,omitempty" db:"margen_unitario"`
 	CategoriaProductoID  *uuid.UUID `json:"categoria_producto_id,omitempty" db:"categoria_producto_id"`
+	UnidadMedida         *string    `json:"unidad_medida,omitempty" db:"unidad_medida"`
+	FactorConversion     float64    `json:"factor_conversion" db:"factor_conversion"`
+}
+
+// MedioPagoVenta modelo de medio de pago de una venta
+type MedioPagoVenta struct {
+	ID                    uuid.UUID  `json:"id" db:"id"`
+	VentaID               uuid.UUID  `json:"venta_id" db:"venta_id"`
+	MedioPago             string     `json:"medio_pago" db:"medio_pago"`
+	Monto                 float64    `json:"monto" db:"monto"`
+	ReferenciaTransaccion *string    `json:"referencia_transaccion,omitempty" db:"referencia_transaccion"`
+	CodigoAutorizacion    *string    `json:"codigo_autorizacion,omitempty" db:"codigo_autorizacion"`
+	DatosTransaccion      JSONB      `json:"datos_transaccion,omitempty" db:"datos_transaccion"`
+	FechaProcesamiento    time.Time  `json:"fecha_procesamiento" db:"fecha_procesamiento"`
+	EstadoConciliacion    string     `json:"estado_conciliacion" db:"estado_conciliacion"`
+	FechaConciliacion     *time.Time `json:"fecha_conciliacion,omitempty" db:"fecha_conciliacion"`
+	LoteConciliacion      *string    `json:"lote_conciliacion,omitempty" db:"lote_conciliacion"`
+	Comision              *float64   `json:"comision,omitempty" db:"comision"`
+}
+
+// NotaVenta modelo de nota de venta (flujo POS Tienda -> Caja)
+type NotaVenta struct {
+	ID                  uuid.UUID          `json:"id" db:"id"`
+	NumeroNota          int64              `json:"numero_nota" db:"numero_nota"`
+	SucursalID          uuid.UUID          `json:"sucursal_id" db:"sucursal_id"`
+	TerminalID          *uuid.UUID         `json:"terminal_id,omitempty" db:"terminal_id"`
+	VendedorID          *uuid.UUID         `json:"vendedor_id,omitempty" db:"vendedor_id"`
+	ClienteRUT          *string            `json:"cliente_rut,omitempty" db:"cliente_rut"`
+	ClienteNombre       *string            `json:"cliente_nombre,omitempty" db:"cliente_nombre"`
+	Subtotal            float64            `json:"subtotal" db:"subtotal"`
+	DescuentoTotal      float64            `json:"descuento_total" db:"descuento_total"`
+	Total               float64            `json:"total" db:"total"`
+	Estado              string             `json:"estado" db:"estado"`
+	Fecha               time.Time          `json:"fecha" db:"fecha"`
+	FechaVencimiento    *time.Time         `json:"fecha_vencimiento,omitempty" db:"fecha_vencimiento"`
+	VentaID             *uuid.UUID         `json:"venta_id,omitempty" db:"venta_id"`
+	FechaPago           *time.Time         `json:"fecha_pago,omitempty" db:"fecha_pago"`
+	Sincronizada        bool               `json:"sincronizada" db:"sincronizada"`
+	Observaciones       *string            `json:"observaciones,omitempty" db:"observaciones"`
+	QRCode              *string            `json:"qr_code,omitempty" db:"qr_code"`
+	HashValidacion      *string            `json:"hash_validacion,omitempty" db:"hash_validacion"`
+	TiempoVigenciaHoras int                `json:"tiempo_vigencia_horas" db:"tiempo_vigencia_horas"`
+	PrioridadAtencion   int                `json:"prioridad_atencion" db:"prioridad_atencion"`
+	Detalles            []DetalleNotaVenta `json:"detalles,omitempty"`
+}
+
+// DetalleNotaVenta modelo de detalle de nota de venta
+type DetalleNotaVenta struct {
+	ID                       uuid.UUID  `json:"id" db:"id"`
+	NotaVentaID              uuid.UUID  `json:"nota_venta_id" db:"nota_venta_id"`
+	ProductoID               uuid.UUID  `json:"producto_id" db:"producto_id"`
+	Cantidad                 float64    `json:"cantidad" db:"cantidad"`
+	PrecioUnitario           float64    `json:"precio_unitario" db:"precio_unitario"`
+	DescuentoUnitario        float64    `json:"descuento_unitario" db:"descuento_unitario"`
+	TotalItem                float64    `json:"total_item" db:"total_item"`
+	Observaciones            *string    `json:"observaciones,omitempty" db:"observaciones"`
+	DisponibilidadVerificada bool       `json:"disponibilidad_verificada" db:"disponibilidad_verificada"`
+	FechaVerificacionStock   *time.Time `json:"fecha_verificacion_stock,omitempty" db:"fecha_verificacion_stock"`
+}
+
+// SesionCaja modelo de sesión (turno) de caja en un terminal
+type SesionCaja struct {
+	ID                uuid.UUID  `json:"id" db:"id"`
+	NumeroSesion      int64      `json:"numero_sesion" db:"numero_sesion"`
+	TerminalID        uuid.UUID  `json:"terminal_id" db:"terminal_id"`
+	SucursalID        uuid.UUID  `json:"sucursal_id" db:"sucursal_id"`
+	UsuarioAperturaID uuid.UUID  `json:"usuario_apertura_id" db:"usuario_apertura_id"`
+	UsuarioCierreID   *uuid.UUID `json:"usuario_cierre_id,omitempty" db:"usuario_cierre_id"`
+	MontoApertura     float64    `json:"monto_apertura" db:"monto_apertura"`
+	Estado            string     `json:"estado" db:"estado"`
+	FechaApertura     time.Time  `json:"fecha_apertura" db:"fecha_apertura"`
+	FechaCierre       *time.Time `json:"fecha_cierre,omitempty" db:"fecha_cierre"`
+	TotalEsperado     *float64   `json:"total_esperado,omitempty" db:"total_esperado"`
+	TotalContado      *float64   `json:"total_contado,omitempty" db:"total_contado"`
+	Diferencia        *float64   `json:"diferencia,omitempty" db:"diferencia"`
+	Arqueo            JSONB      `json:"arqueo,omitempty" db:"arqueo"`
+	ReporteZ          JSONB      `json:"reporte_z,omitempty" db:"reporte_z"`
+	Observaciones     *string    `json:"observaciones,omitempty" db:"observaciones"`
+}
+
+// MovimientoCaja modelo de retiro o depósito de efectivo durante una sesión de caja
+type MovimientoCaja struct {
+	ID            uuid.UUID  `json:"id" db:"id"`
+	SesionCajaID  uuid.UUID  `json:"sesion_caja_id" db:"sesion_caja_id"`
+	Tipo          string     `json:"tipo" db:"tipo"`
+	Monto         float64    `json:"monto" db:"monto"`
+	Motivo        string     `json:"motivo" db:"motivo"`
+	UsuarioID     uuid.UUID  `json:"usuario_id" db:"usuario_id"`
+	AutorizadoPor *uuid.UUID `json:"autorizado_por,omitempty" db:"autorizado_por"`
+	Fecha         time.Time  `json:"fecha" db:"fecha"`
+}
+
+// ResumenMedioPagoCaja comparación esperado vs contado de un medio de pago en el cierre
+type ResumenMedioPagoCaja struct {
+	MedioPago  string  `json:"medio_pago"`
+	Esperado   float64 `json:"esperado"`
+	Contado    float64 `json:"contado"`
+	Diferencia float64 `json:"diferencia"`
+}
+
+// ReporteZ reporte de cierre de una sesión de caja
+type ReporteZ struct {
+	SesionCajaID      uuid.UUID              `json:"sesion_caja_id"`
+	NumeroSesion      int64                  `json:"numero_sesion"`
+	TerminalID        uuid.UUID              `json:"terminal_id"`
+	SucursalID        uuid.UUID              `json:"sucursal_id"`
+	FechaApertura     time.Time              `json:"fecha_apertura"`
+	FechaCierre       time.Time              `json:"fecha_cierre"`
+	MontoApertura     float64                `json:"monto_apertura"`
+	CantidadVentas    int                    `json:"cantidad_ventas"`
+	CantidadAnuladas  int                    `json:"cantidad_anuladas"`
+	TotalVentas       float64                `json:"total_ventas"`
+	TotalAnulado      float64                `json:"total_anulado"`
+	TotalDepositos    float64                `json:"total_depositos"`
+	TotalRetiros      float64                `json:"total_retiros"`
+	TotalReembolsos   float64                `json:"total_reembolsos"`
+	TotalAnulaciones  float64                `json:"total_anulaciones"` // Efectivo devuelto por ventas de turnos anteriores anuladas en el turno
+	MediosPago        []ResumenMedioPagoCaja `json:"medios_pago"`
+	ConteoEfectivo    []ConteoDenominacion   `json:"conteo_efectivo"`
+	TotalEsperado     float64                `json:"total_esperado"`
+	TotalContado      float64                `json:"total_contado"`
+	Diferencia        float64                `json:"diferencia"`
+}
+
+// PoliticaDescuento límite de descuento permitido para un rol, opcionalmente por categoría
+type PoliticaDescuento struct {
+	ID                uuid.UUID  `json:"id" db:"id"`
+	Rol               RolUsuario `json:"rol" db:"rol"`
+	CategoriaID       *uuid.UUID `json:"categoria_id,omitempty" db:"categoria_id"`
+	PorcentajeMaximo  float64    `json:"porcentaje_maximo" db:"porcentaje_maximo"`
+	MontoMaximo       *float64   `json:"monto_maximo,omitempty" db:"monto_maximo"`
+	Activo            bool       `json:"activo" db:"activo"`
+	FechaCreacion     time.Time  `json:"fecha_creacion" db:"fecha_creacion"`
+	FechaModificacion time.Time  `json:"fecha_modificacion" db:"fecha_modificacion"`
+}
+
+// AutorizacionDescuento código de un solo uso emitido por un supervisor para descuentos sobre el límite
+type AutorizacionDescuento struct {
+	ID              uuid.UUID  `json:"id" db:"id"`
+	CodigoHash      string     `json:"-" db:"codigo_hash"`
+	SupervisorID    uuid.UUID  `json:"supervisor_id" db:"supervisor_id"`
+	SucursalID      uuid.UUID  `json:"sucursal_id" db:"sucursal_id"`
+	Motivo          string     `json:"motivo" db:"motivo"`
+	FechaCreacion   time.Time  `json:"fecha_creacion" db:"fecha_creacion"`
+	FechaExpiracion time.Time  `json:"fecha_expiracion" db:"fecha_expiracion"`
+	Usada           bool       `json:"usada" db:"usada"`
+	VentaID         *uuid.UUID `json:"venta_id,omitempty" db:"venta_id"`
+	FechaUso        *time.Time `json:"fecha_uso,omitempty" db:"fecha_uso"`
+}
+
+// ReimpresionDocumento registro de auditoría de una reimpresión
+type ReimpresionDocumento struct {
+	ID                    uuid.UUID  `json:"id" db:"id"`
+	DocumentoID           uuid.UUID  `json:"documento_id" db:"documento_id"`
+	UsuarioID             uuid.UUID  `json:"usuario_id" db:"usuario_id"`
+	SucursalID            uuid.UUID  `json:"sucursal_id" db:"sucursal_id"`
+	TipoDocumento         string     `json:"tipo_documento" db:"tipo_documento"`
+	Motivo                string     `json:"motivo" db:"motivo"`
+	Fecha                 time.Time  `json:"fecha" db:"fecha"`
+	IPOrigen              *string    `json:"ip_origen,omitempty" db:"ip_origen"`
+	Dispositivo           *string    `json:"dispositivo,omitempty" db:"dispositivo"`
+	ReimpresionesPrevias  int        `json:"reimpresiones_previas" db:"reimpresiones_previas"`
+	AutorizadoPor         *uuid.UUID `json:"autorizado_por,omitempty" db:"autorizado_por"`
+	DatosAdicionales      JSONB      `json:"datos_adicionales,omitempty" db:"datos_adicionales"`
+	HashDocumentoOriginal *string    `json:"hash_documento_original,omitempty" db:"hash_documento_original"`
+	TiempoProcesamiento   *int       `json:"tiempo_procesamiento_ms,omitempty" db:"tiempo_procesamiento_ms"`
+	TamanoArchivoBytes    *int       `json:"tamano_archivo_bytes,omitempty" db:"tamaño_archivo_bytes"`
+}
+
+// AutorizacionReimpresion código de un solo uso para reimprimir un documento sobre el límite libre
+type AutorizacionReimpresion struct {
+	ID              uuid.UUID  `json:"id" db:"id"`
+	CodigoHash      string     `json:"-" db:"codigo_hash"`
+	SupervisorID    uuid.UUID  `json:"supervisor_id" db:"supervisor_id"`
+	SucursalID      uuid.UUID  `json:"sucursal_id" db:"sucursal_id"`
+	TipoDocumento   string     `json:"tipo_documento" db:"tipo_documento"`
+	DocumentoID     uuid.UUID  `json:"documento_id" db:"documento_id"`
+	Motivo          string     `json:"motivo" db:"motivo"`
+	FechaCreacion   time.Time  `json:"fecha_creacion" db:"fecha_creacion"`
+	FechaExpiracion time.Time  `json:"fecha_expiracion" db:"fecha_expiracion"`
+	Usada           bool       `json:"usada" db:"usada"`
+	ReimpresionID   *uuid.UUID `json:"reimpresion_id,omitempty" db:"reimpresion_id"`
+	FechaUso        *time.Time `json:"fecha_uso,omitempty" db:"fecha_uso"`
+}
+
+// Promocion modelo de promoción aplicada automáticamente en caja
+type Promocion struct {
+	ID                uuid.UUID           `json:"id" db:"id"`
+	Codigo            string              `json:"codigo" db:"codigo"`
+	Nombre            string              `json:"nombre" db:"nombre"`
+	Descripcion       *string             `json:"descripcion,omitempty" db:"descripcion"`
+	Tipo              string              `json:"tipo" db:"tipo"`
+	ProductoID        *uuid.UUID          `json:"producto_id,omitempty" db:"producto_id"`
+	CategoriaID       *uuid.UUID          `json:"categoria_id,omitempty" db:"categoria_id"`
+	LlevaCantidad     *int                `json:"lleva_cantidad,omitempty" db:"lleva_cantidad"`
+	PagaCantidad      *int                `json:"paga_cantidad,omitempty" db:"paga_cantidad"`
+	Porcentaje        *float64            `json:"porcentaje,omitempty" db:"porcentaje"`
+	Tramos            []TramoPromocion    `json:"tramos,omitempty" db:"tramos"`
+	Prioridad         int                 `json:"prioridad" db:"prioridad"`
+	Activa            bool                `json:"activa" db:"activa"`
+	UsuarioCreacionID *uuid.UUID          `json:"usuario_creacion_id,omitempty" db:"usuario_creacion_id"`
+	FechaCreacion     time.Time           `json:"fecha_creacion" db:"fecha_creacion"`
+	FechaModificacion time.Time           `json:"fecha_modificacion" db:"fecha_modificacion"`
+	Sucursales        []PromocionSucursal `json:"sucursales,omitempty"`
+}
+
+// TramoPromocion tramo de descuento por volumen
+type TramoPromocion struct {
+	CantidadMinima float64 `json:"cantidad_minima" validate:"required,gt=0"`
+	Porcentaje     float64 `json:"porcentaje" validate:"required,gt=0,lte=100"`
+}
+
+// PromocionSucursal ventana de vigencia de una promoción en una sucursal
+type PromocionSucursal struct {
+	PromocionID uuid.UUID `json:"promocion_id" db:"promocion_id"`
+	SucursalID  uuid.UUID `json:"sucursal_id" db:"sucursal_id" validate:"required"`
+	FechaInicio time.Time `json:"fecha_inicio" db:"fecha_inicio" validate:"required"`
+	FechaFin    time.Time `json:"fecha_fin" db:"fecha_fin" validate:"required,gtfield=FechaInicio"`
+}
+
+// PromocionAplicada descuento de una promoción aplicado a una línea de venta
+type PromocionAplicada struct {
+	PromocionID       uuid.UUID `json:"promocion_id"`
+	Codigo            string    `json:"codigo"`
+	Nombre            string    `json:"nombre"`
+	Tipo              string    `json:"tipo"`
+	ProductoID        uuid.UUID `json:"producto_id"`
+	Cantidad          float64   `json:"cantidad"`
+	DescuentoUnitario float64   `json:"descuento_unitario"`
+	DescuentoTotal    float64   `json:"descuento_total"`
+}
+
+// Cotizacion modelo de cotización formal con precios congelados al cotizar
+type Cotizacion struct {
+	ID                  uuid.UUID           `json:"id" db:"id"`
+	NumeroCotizacion    int64               `json:"numero_cotizacion" db:"numero_cotizacion"`
+	SucursalID          uuid.UUID           `json:"sucursal_id" db:"sucursal_id"`
+	VendedorID          *uuid.UUID          `json:"vendedor_id,omitempty" db:"vendedor_id"`
+	ClienteRUT          string              `json:"cliente_rut" db:"cliente_rut"`
+	ClienteNombre       *string             `json:"cliente_nombre,omitempty" db:"cliente_nombre"`
+	ClienteEmail        *string             `json:"cliente_email,omitempty" db:"cliente_email"`
+	Subtotal            float64             `json:"subtotal" db:"subtotal"`
+	DescuentoTotal      float64             `json:"descuento_total" db:"descuento_total"`
+	ImpuestoTotal       float64             `json:"impuesto_total" db:"impuesto_total"`
+	Total               float64             `json:"total" db:"total"`
+	Estado              string              `json:"estado" db:"estado"`
+	Fecha               time.Time           `json:"fecha" db:"fecha"`
+	FechaValidez        time.Time           `json:"fecha_validez" db:"fecha_validez"`
+	Observaciones       *string             `json:"observaciones,omitempty" db:"observaciones"`
+	NotaVentaID         *uuid.UUID          `json:"nota_venta_id,omitempty" db:"nota_venta_id"`
+	VentaID             *uuid.UUID          `json:"venta_id,omitempty" db:"venta_id"`
+	FechaConversion     *time.Time          `json:"fecha_conversion,omitempty" db:"fecha_conversion"`
+	UsuarioConversionID *uuid.UUID          `json:"usuario_conversion_id,omitempty" db:"usuario_conversion_id"`
+	Detalles            []DetalleCotizacion `json:"detalles,omitempty"`
+}
+
+// DetalleCotizacion modelo de línea de cotización
+type DetalleCotizacion struct {
+	ID                uuid.UUID `json:"id" db:"id"`
+	CotizacionID      uuid.UUID `json:"cotizacion_id" db:"cotizacion_id"`
+	ProductoID        uuid.UUID `json:"producto_id" db:"producto_id"`
+	CodigoInterno     string    `json:"codigo_interno" db:"codigo_interno"`
+	Descripcion       string    `json:"descripcion" db:"descripcion"`
+	Cantidad          float64   `json:"cantidad" db:"cantidad"`
+	PrecioUnitario    float64   `json:"precio_unitario" db:"precio_unitario"`
+	DescuentoUnitario float64   `json:"descuento_unitario" db:"descuento_unitario"`
+	TotalItem         float64   `json:"total_item" db:"total_item"`
+}
+
+// CuentaCorriente modelo de cuenta de crédito de un cliente empresa
+type CuentaCorriente struct {
+	ID                uuid.UUID  `json:"id" db:"id"`
+	ClienteRUT        string     `json:"cliente_rut" db:"cliente_rut"`
+	RazonSocial       string     `json:"razon_social" db:"razon_social"`
+	Giro              *string    `json:"giro,omitempty" db:"giro"`
+	Direccion         *string    `json:"direccion,omitempty" db:"direccion"`
+	Email             *string    `json:"email,omitempty" db:"email"`
+	Telefono          *string    `json:"telefono,omitempty" db:"telefono"`
+	LimiteCredito     float64    `json:"limite_credito" db:"limite_credito"`
+	DiasPlazo         int        `json:"dias_plazo" db:"dias_plazo"`
+	Saldo             float64    `json:"saldo" db:"saldo"`
+	CreditoDisponible float64    `json:"credito_disponible"`
+	Estado            string     `json:"estado" db:"estado"`
+	UsuarioCreacionID *uuid.UUID `json:"usuario_creacion_id,omitempty" db:"usuario_creacion_id"`
+	FechaCreacion     time.Time  `json:"fecha_creacion" db:"fecha_creacion"`
+	FechaModificacion time.Time  `json:"fecha_modificacion" db:"fecha_modificacion"`
+}
+
+// MovimientoCuentaCorriente modelo de cargo o abono en una cuenta corriente
+type MovimientoCuentaCorriente struct {
+	ID               uuid.UUID  `json:"id" db:"id"`
+	CuentaID         uuid.UUID  `json:"cuenta_id" db:"cuenta_id"`
+	SucursalID       *uuid.UUID `json:"sucursal_id,omitempty" db:"sucursal_id"`
+	Tipo             string     `json:"tipo" db:"tipo"`
+	Monto            float64    `json:"monto" db:"monto"`
+	SaldoAnterior    float64    `json:"saldo_anterior" db:"saldo_anterior"`
+	SaldoNuevo       float64    `json:"saldo_nuevo" db:"saldo_nuevo"`
+	SaldoPendiente   float64    `json:"saldo_pendiente" db:"saldo_pendiente"`
+	Fecha            time.Time  `json:"fecha" db:"fecha"`
+	FechaVencimiento *time.Time `json:"fecha_vencimiento,omitempty" db:"fecha_vencimiento"`
+	VentaID          *uuid.UUID `json:"venta_id,omitempty" db:"venta_id"`
+	MedioPago        *string    `json:"medio_pago,omitempty" db:"medio_pago"`
+	Referencia       *string    `json:"referencia,omitempty" db:"referencia"`
+	Observaciones    *string    `json:"observaciones,omitempty" db:"observaciones"`
+	UsuarioID        *uuid.UUID `json:"usuario_id,omitempty" db:"usuario_id"`
+}
+
+// AntiguedadDeuda deuda pendiente agrupada por días de atraso
+type AntiguedadDeuda struct {
+	PorVencer float64 `json:"por_vencer"`
+	Dias1a30  float64 `json:"dias_1_30"`
+	Dias31a60 float64 `json:"dias_31_60"`
+	Dias61a90 float64 `json:"dias_61_90"`
+	Mas90     float64 `json:"mas_90"`
+	Total     float64 `json:"total"`
+}
+
+// EstadoCuentaCorriente estado de cuenta de un período
+type EstadoCuentaCorriente struct {
+	Cuenta       CuentaCorriente             `json:"cuenta"`
+	Desde        time.Time                   `json:"desde"`
+	Hasta        time.Time                   `json:"hasta"`
+	SaldoInicial float64                     `json:"saldo_inicial"`
+	TotalCargos  float64                     `json:"total_cargos"`
+	TotalAbonos  float64                     `json:"total_abonos"`
+	SaldoFinal   float64                     `json:"saldo_final"`
+	Antiguedad   AntiguedadDeuda             `json:"antiguedad"`
+	Movimientos  []MovimientoCuentaCorriente `json:"movimientos"`
+}
+
+// CuentaPorCobrar deuda de una cuenta corriente por tramo de antigüedad
+type CuentaPorCobrar struct {
+	CuentaID      uuid.UUID       `json:"cuenta_id"`
+	ClienteRUT    string          `json:"cliente_rut"`
+	RazonSocial   string          `json:"razon_social"`
+	LimiteCredito float64         `json:"limite_credito"`
+	Saldo         float64         `json:"saldo"`
+	Estado        string          `json:"estado"`
+	Antiguedad    AntiguedadDeuda `json:"antiguedad"`
+}
+
+// ClienteFidelizacion modelo de cliente inscrito en el programa de fidelización
+type ClienteFidelizacion struct {
+	ID                            uuid.UUID  `json:"id" db:"id"`
+	RUT                           string     `json:"rut" db:"rut"`
+	Nombre                        string     `json:"nombre" db:"nombre"`
+	Apellido                      *string    `json:"apellido,omitempty" db:"apellido"`
+	Email                         *string    `json:"email,omitempty" db:"email"`
+	Telefono                      *string    `json:"telefono,omitempty" db:"telefono"`
+	FechaNacimiento               *time.Time `json:"fecha_nacimiento,omitempty" db:"fecha_nacimiento"`
+	Direccion                     *string    `json:"direccion,omitempty" db:"direccion"`
+	Comuna                        *string    `json:"comuna,omitempty" db:"comuna"`
+	Region                        *string    `json:"region,omitempty" db:"region"`
+	PuntosActuales                int        `json:"puntos_actuales" db:"puntos_actuales"`
+	PuntosAcumuladosTotal         int        `json:"puntos_acumulados_total" db:"puntos_acumulados_total"`
+	ValorPuntos                   float64    `json:"valor_puntos"`
+	NivelFidelizacion             string     `json:"nivel_fidelizacion" db:"nivel_fidelizacion"`
+	FechaUltimaCompra             *time.Time `json:"fecha_ultima_compra,omitempty" db:"fecha_ultima_compra"`
+	FechaUltimaActividad          *time.Time `json:"fecha_ultima_actividad,omitempty" db:"fecha_ultima_actividad"`
+	Activo                        bool       `json:"activo" db:"activo"`
+	AceptaMarketing               bool       `json:"acepta_marketing" db:"acepta_marketing"`
+	QRFidelizacion                *string    `json:"qr_fidelizacion,omitempty" db:"qr_fidelizacion"`
+	FechaProximoVencimientoPuntos *time.Time `json:"fecha_proximo_vencimiento_puntos,omitempty" db:"fecha_proximo_vencimiento_puntos"`
+	PuntosPorVencer               int        `json:"puntos_por_vencer" db:"puntos_por_vencer"`
+	FechaCreacion                 time.Time  `json:"fecha_creacion" db:"fecha_creacion"`
+	FechaModificacion             time.Time  `json:"fecha_modificacion" db:"fecha_modificacion"`
+}
+
+// MovimientoFidelizacion modelo de acumulación, canje, ajuste o expiración de puntos
+type MovimientoFidelizacion struct {
+	ID               uuid.UUID  `json:"id" db:"id"`
+	ClienteID        uuid.UUID  `json:"cliente_id" db:"cliente_id"`
+	SucursalID       *uuid.UUID `json:"sucursal_id,omitempty" db:"sucursal_id"`
+	VentaID          *uuid.UUID `json:"venta_id,omitempty" db:"venta_id"`
+	Tipo             string     `json:"tipo" db:"tipo"`
+	Puntos           int        `json:"puntos" db:"puntos"`
+	PuntosAnteriores int        `json:"puntos_anteriores" db:"puntos_anteriores"`
+	PuntosNuevos     int        `json:"puntos_nuevos" db:"puntos_nuevos"`
+	Multiplicador    float64    `json:"multiplicador" db:"multiplicador"`
+	Detalle          *string    `json:"detalle,omitempty" db:"detalle"`
+	Fecha            time.Time  `json:"fecha" db:"fecha"`
+	FechaVencimiento *time.Time `json:"fecha_vencimiento,omitempty" db:"fecha_vencimiento"`
+	UsuarioID        *uuid.UUID `json:"usuario_id,omitempty" db:"usuario_id"`
+	DatosAdicionales JSONB      `json:"datos_adicionales,omitempty" db:"datos_adicionales"`
+	ReglaAplicadaID  *uuid.UUID `json:"regla_aplicada_id,omitempty" db:"regla_aplicada_id"`
+}
+
+// ResultadoMantencionFidelizacion resumen de una ejecución del vencimiento de puntos y recálculo de niveles
+type ResultadoMantencionFidelizacion struct {
+	LoteProcesamiento      uuid.UUID `json:"lote_procesamiento"`
+	FechaEjecucion         time.Time `json:"fecha_ejecucion"`
+	ClientesConVencimiento int       `json:"clientes_con_vencimiento"`
+	PuntosVencidos         int       `json:"puntos_vencidos"`
+	ClientesRecalculados   int       `json:"clientes_recalculados"`
+	CambiosNivel           int       `json:"cambios_nivel"`
+}
+
+// ReglaFidelizacion modelo de regla de acumulación, canje, promoción o nivel del programa de fidelización
+type ReglaFidelizacion struct {
+	ID                   uuid.UUID  `json:"id" db:"id"`
+	Nombre               string     `json:"nombre" db:"nombre"`
+	Descripcion          *string    `json:"descripcion,omitempty" db:"descripcion"`
+	TipoRegla            string     `json:"tipo_regla" db:"tipo_regla"`
+	Condiciones          JSONB      `json:"condiciones" db:"condiciones"`
+	Acciones             JSONB      `json:"acciones" db:"acciones"`
+	Activa               bool       `json:"activa" db:"activa"`
+	FechaInicio          *time.Time `json:"fecha_inicio,omitempty" db:"fecha_inicio"`
+	FechaFin             *time.Time `json:"fecha_fin,omitempty" db:"fecha_fin"`
+	Prioridad            int        `json:"prioridad" db:"prioridad"`
+	TotalAplicaciones    int        `json:"total_aplicaciones" db:"total_aplicaciones"`
+	TotalPuntosOtorgados int        `json:"total_puntos_otorgados" db:"total_puntos_otorgados"`
+	FechaCreacion        time.Time  `json:"fecha_creacion" db:"fecha_creacion"`
+	FechaModificacion    time.Time  `json:"fecha_modificacion" db:"fecha_modificacion"`
+	Compilada            []byte     `json:"-" db:"condiciones_compiladas"`
+}
+
+// HistorialFidelizacion saldo del cliente con sus movimientos de puntos
+type HistorialFidelizacion struct {
+	Cliente     ClienteFidelizacion      `json:"cliente"`
+	Movimientos []MovimientoFidelizacion `json:"movimientos"`
 }
 
 // Modelos específicos para etiquetas
@@ -412,9 +1458,10 @@ type ProductoSearchRequest struct {
 
 // VentaRequest request de creación de venta
 type VentaRequest struct {
+	VentaID         *uuid.UUID           `json:"venta_id,omitempty"` // UUID generado por el terminal para reintentos idempotentes
 	SucursalID      uuid.UUID            `json:"sucursal_id" validate:"required"`
 	TerminalID      uuid.UUID            `json:"terminal_id" validate:"required"`
-	CajeroID        uuid.UUID            `json:"cajero_id" validate:"required"`
+	CajeroID        uuid.UUID            `json:"cajero_id,omitempty"` // Se toma del token; si se informa debe coincidir con el usuario autenticado
 	VendedorID      *uuid.UUID           `json:"vendedor_id,omitempty"`
 	ClienteRUT      *string              `json:"cliente_rut,omitempty"`
 	ClienteNombre   *string              `json:"cliente_nombre,omitempty"`
@@ -422,32 +1469,605 @@ type VentaRequest struct {
 	Items           []VentaItemRequest   `json:"items" validate:"required,min=1,dive"`
 	MediosPago      []MedioPagoRequest   `json:"medios_pago" validate:"required,min=1,dive"`
 	DatosAdicionales JSONB               `json:"datos_adicionales,omitempty"`
+	CodigoAutorizacionDescuento *string  `json:"codigo_autorizacion_descuento,omitempty"` // Código de supervisor para descuentos sobre el límite del rol
+	Despacho         *DespachoVentaRequest `json:"despacho,omitempty"` // Si se informa, la venta genera un despacho pendiente para bodega
+	CarritoID        *uuid.UUID           `json:"carrito_id,omitempty"` // Carrito del terminal cuyas reservas de stock consume la venta
 }
 
 // VentaItemRequest item de venta
 type VentaItemRequest struct {
 	ProductoID        uuid.UUID `json:"producto_id" validate:"required"`
 	Cantidad          float64   `json:"cantidad" validate:"required,gt=0"`
-	PrecioUnitario    float64   `json:"precio_unitario" validate:"required,gte=0"`
-	DescuentoUnitario float64   `json:"descuento_unitario,omitempty"`
+	PrecioUnitario    float64   `json:"precio_unitario" validate:"required,gte=0"` // Precio cobrado; bajo el precio de lista la diferencia es descuento manual
+	DescuentoUnitario float64   `json:"descuento_unitario,omitempty" validate:"gte=0"`
 	NumeroSerie       *string   `json:"numero_serie,omitempty"`
 	Lote              *string   `json:"lote,omitempty"`
+	UnidadMedida      *string   `json:"unidad_medida,omitempty"` // Unidad de venta configurada; por defecto la unidad base del producto
 }
 
 // MedioPagoRequest medio de pago
 type MedioPagoRequest struct {
-	MedioPago             string  `json:"medio_pago" validate:"required,oneof=efectivo tarjeta_debito tarjeta_credito transferencia cheque puntos_fidelizacion otro"`
+	MedioPago             string  `json:"medio_pago" validate:"required,oneof=efectivo tarjeta_debito tarjeta_credito transferencia cheque puntos_fidelizacion cuenta_corriente otro"`
 	Monto                 float64 `json:"monto" validate:"required,gt=0"`
 	ReferenciaTransaccion *string `json:"referencia_transaccion,omitempty"`
 	CodigoAutorizacion    *string `json:"codigo_autorizacion,omitempty"`
 }
 
+// DespachoVentaRequest datos de entrega de una venta con despacho
+type DespachoVentaRequest struct {
+	FechaProgramada       *time.Time `json:"fecha_programada,omitempty"`
+	Prioridad             int        `json:"prioridad,omitempty" validate:"omitempty,min=1,max=10"` // 1 es la más urgente; por defecto 5
+	DireccionEntrega      *string    `json:"direccion_entrega,omitempty" validate:"omitempty,max=300"`
+	TiempoEstimadoMinutos *int       `json:"tiempo_estimado_minutos,omitempty" validate:"omitempty,min=1"`
+	Observaciones         *string    `json:"observaciones,omitempty" validate:"omitempty,max=500"`
+}
+
+// AnularVentaRequest request de anulación de venta
+type AnularVentaRequest struct {
+	Motivo string `json:"motivo" validate:"required,min=5,max=500"`
+}
+
+// NotaVentaRequest request de creación de nota de venta
+type NotaVentaRequest struct {
+	SucursalID          uuid.UUID              `json:"sucursal_id" validate:"required"`
+	TerminalID          *uuid.UUID             `json:"terminal_id,omitempty"`
+	ClienteRUT          *string                `json:"cliente_rut,omitempty"`
+	ClienteNombre       *string                `json:"cliente_nombre,omitempty"`
+	Observaciones       *string                `json:"observaciones,omitempty"`
+	TiempoVigenciaHoras int                    `json:"tiempo_vigencia_horas,omitempty" validate:"omitempty,min=1,max=720"`
+	PrioridadAtencion   int                    `json:"prioridad_atencion,omitempty" validate:"omitempty,min=1,max=10"`
+	Items               []NotaVentaItemRequest `json:"items" validate:"required,min=1,dive"`
+}
+
+// NotaVentaItemRequest item de nota de venta
+type NotaVentaItemRequest struct {
+	ProductoID        uuid.UUID `json:"producto_id" validate:"required"`
+	Cantidad          float64   `json:"cantidad" validate:"required,gt=0"`
+	DescuentoUnitario float64   `json:"descuento_unitario,omitempty" validate:"gte=0"`
+	Observaciones     *string   `json:"observaciones,omitempty"`
+}
+
+// ConvertirNotaVentaRequest request de conversión de nota de venta en venta
+type ConvertirNotaVentaRequest struct {
+	TerminalID                  uuid.UUID          `json:"terminal_id" validate:"required"`
+	TipoDocumento               string             `json:"tipo_documento" validate:"required,oneof=boleta factura guia"`
+	ClienteRUT                  *string            `json:"cliente_rut,omitempty"`
+	ClienteNombre               *string            `json:"cliente_nombre,omitempty"`
+	MediosPago                  []MedioPagoRequest    `json:"medios_pago" validate:"required,min=1,dive"`
+	CodigoAutorizacionDescuento *string               `json:"codigo_autorizacion_descuento,omitempty"`
+	Despacho                    *DespachoVentaRequest `json:"despacho,omitempty"`
+}
+
+// AbrirCajaRequest request de apertura de sesión de caja
+type AbrirCajaRequest struct {
+	TerminalID    uuid.UUID `json:"terminal_id" validate:"required"`
+	MontoApertura float64   `json:"monto_apertura" validate:"gte=0"`
+	Observaciones *string   `json:"observaciones,omitempty"`
+}
+
+// MovimientoCajaRequest request de retiro o depósito de efectivo
+type MovimientoCajaRequest struct {
+	Tipo          string     `json:"tipo" validate:"required,oneof=retiro deposito"`
+	Monto         float64    `json:"monto" validate:"required,gt=0"`
+	Motivo        string     `json:"motivo" validate:"required,max=500"`
+	AutorizadoPor *uuid.UUID `json:"autorizado_por,omitempty"`
+}
+
+// ConteoDenominacion cantidad contada de billetes o monedas de una denominación
+type ConteoDenominacion struct {
+	Denominacion int `json:"denominacion" validate:"required,oneof=20000 10000 5000 2000 1000 500 100 50 10 5 1"`
+	Cantidad     int `json:"cantidad" validate:"gte=0"`
+}
+
+// CerrarCajaRequest request de cierre ciego de sesión de caja
+type CerrarCajaRequest struct {
+	ConteoEfectivo    []ConteoDenominacion `json:"conteo_efectivo" validate:"dive"`
+	ConteoOtrosMedios map[string]float64   `json:"conteo_otros_medios,omitempty"`
+	Observaciones     *string              `json:"observaciones,omitempty"`
+}
+
+// PoliticaDescuentoRequest request de creación o actualización de política de descuento
+type PoliticaDescuentoRequest struct {
+	Rol              string     `json:"rol" validate:"required,oneof=cajero vendedor despacho supervisor admin operador_etiquetas"`
+	CategoriaID      *uuid.UUID `json:"categoria_id,omitempty"`
+	PorcentajeMaximo float64    `json:"porcentaje_maximo" validate:"gte=0,lte=100"`
+	MontoMaximo      *float64   `json:"monto_maximo,omitempty" validate:"omitempty,gte=0"`
+	Activo           *bool      `json:"activo,omitempty"`
+}
+
+// AutorizacionDescuentoRequest request de emisión de código de autorización de descuento
+type AutorizacionDescuentoRequest struct {
+	SucursalID      *uuid.UUID `json:"sucursal_id,omitempty"`
+	Motivo          string     `json:"motivo" validate:"required,min=5,max=500"`
+	VigenciaMinutos int        `json:"vigencia_minutos,omitempty" validate:"omitempty,min=1,max=60"`
+}
+
+// ReimpresionRequest request de reimpresión de boleta, factura o comprobante de despacho
+type ReimpresionRequest struct {
+	TipoDocumento      string    `json:"tipo_documento" validate:"required,oneof=boleta factura comprobante_despacho"`
+	DocumentoID        uuid.UUID `json:"documento_id" validate:"required"` // Venta o despacho
+	Motivo             string    `json:"motivo" validate:"required,min=5,max=500"`
+	Dispositivo        *string   `json:"dispositivo,omitempty" validate:"omitempty,max=200"`
+	CodigoAutorizacion *string   `json:"codigo_autorizacion,omitempty"`
+}
+
+// AutorizacionReimpresionRequest request de emisión de código de autorización de reimpresión
+type AutorizacionReimpresionRequest struct {
+	TipoDocumento   string    `json:"tipo_documento" validate:"required,oneof=boleta factura comprobante_despacho"`
+	DocumentoID     uuid.UUID `json:"documento_id" validate:"required"`
+	Motivo          string    `json:"motivo" validate:"required,min=5,max=500"`
+	VigenciaMinutos int       `json:"vigencia_minutos,omitempty" validate:"omitempty,min=1,max=60"`
+}
+
+// PromocionRequest request de creación o actualización de promoción
+type PromocionRequest struct {
+	Codigo        string              `json:"codigo" validate:"required,max=50"`
+	Nombre        string              `json:"nombre" validate:"required,max=200"`
+	Descripcion   *string             `json:"descripcion,omitempty"`
+	Tipo          string              `json:"tipo" validate:"required,oneof=lleva_paga volumen porcentaje"`
+	ProductoID    *uuid.UUID          `json:"producto_id,omitempty"`
+	CategoriaID   *uuid.UUID          `json:"categoria_id,omitempty"`
+	LlevaCantidad *int                `json:"lleva_cantidad,omitempty" validate:"omitempty,min=2"`
+	PagaCantidad  *int                `json:"paga_cantidad,omitempty" validate:"omitempty,min=1"`
+	Porcentaje    *float64            `json:"porcentaje,omitempty" validate:"omitempty,gt=0,lte=100"`
+	Tramos        []TramoPromocion    `json:"tramos,omitempty" validate:"dive"`
+	Prioridad     int                 `json:"prioridad,omitempty" validate:"gte=0,lte=100"`
+	Activa        *bool               `json:"activa,omitempty"`
+	Sucursales    []PromocionSucursal `json:"sucursales" validate:"required,min=1,dive"`
+}
+
+// VentaPreviewRequest request de cálculo previo de una venta con promociones
+type VentaPreviewRequest struct {
+	SucursalID uuid.UUID          `json:"sucursal_id" validate:"required"`
+	Items      []VentaItemRequest `json:"items" validate:"required,min=1,dive"`
+}
+
+// CotizacionRequest request de creación de cotización
+type CotizacionRequest struct {
+	SucursalID    uuid.UUID               `json:"sucursal_id" validate:"required"`
+	ClienteRUT    string                  `json:"cliente_rut" validate:"required,max=20"`
+	ClienteNombre *string                 `json:"cliente_nombre,omitempty"`
+	ClienteEmail  *string                 `json:"cliente_email,omitempty" validate:"omitempty,email"`
+	DiasValidez   int                     `json:"dias_validez,omitempty" validate:"omitempty,min=1,max=90"`
+	Observaciones *string                 `json:"observaciones,omitempty"`
+	Items         []CotizacionItemRequest `json:"items" validate:"required,min=1,dive"`
+}
+
+// CotizacionItemRequest item de cotización
+type CotizacionItemRequest struct {
+	ProductoID        uuid.UUID `json:"producto_id" validate:"required"`
+	Cantidad          float64   `json:"cantidad" validate:"required,gt=0"`
+	DescuentoUnitario float64   `json:"descuento_unitario,omitempty" validate:"gte=0"`
+}
+
+// ConvertirCotizacionRequest request de conversión de cotización en nota de venta o venta
+type ConvertirCotizacionRequest struct {
+	Destino                     string             `json:"destino" validate:"required,oneof=nota_venta venta"`
+	TerminalID                  *uuid.UUID         `json:"terminal_id,omitempty"`
+	TipoDocumento               string             `json:"tipo_documento,omitempty" validate:"omitempty,oneof=boleta factura guia"`
+	MediosPago                  []MedioPagoRequest `json:"medios_pago,omitempty" validate:"dive"`
+	CodigoAutorizacionDescuento *string               `json:"codigo_autorizacion_descuento,omitempty"`
+	Observaciones               *string               `json:"observaciones,omitempty"`
+	Despacho                    *DespachoVentaRequest `json:"despacho,omitempty"` // Solo para destino venta
+}
+
+// CuentaCorrienteRequest request de creación o actualización de cuenta corriente
+type CuentaCorrienteRequest struct {
+	ClienteRUT    string  `json:"cliente_rut" validate:"required,max=20"`
+	RazonSocial   string  `json:"razon_social" validate:"required,max=200"`
+	Giro          *string `json:"giro,omitempty"`
+	Direccion     *string `json:"direccion,omitempty"`
+	Email         *string `json:"email,omitempty" validate:"omitempty,email"`
+	Telefono      *string `json:"telefono,omitempty"`
+	LimiteCredito float64 `json:"limite_credito" validate:"gte=0"`
+	DiasPlazo     *int    `json:"dias_plazo,omitempty" validate:"omitempty,min=0,max=180"`
+	Estado        *string `json:"estado,omitempty" validate:"omitempty,oneof=activa bloqueada cerrada"`
+}
+
+// PagoCuentaCorrienteRequest request de registro de pago a cuenta corriente
+type PagoCuentaCorrienteRequest struct {
+	SucursalID    *uuid.UUID `json:"sucursal_id,omitempty"`
+	Monto         float64    `json:"monto" validate:"required,gt=0"`
+	MedioPago     string     `json:"medio_pago" validate:"required,oneof=efectivo tarjeta_debito tarjeta_credito transferencia cheque"`
+	Referencia    *string    `json:"referencia,omitempty"`
+	Observaciones *string    `json:"observaciones,omitempty"`
+}
+
+// ClienteFidelizacionRequest request de inscripción o actualización de cliente de fidelización
+type ClienteFidelizacionRequest struct {
+	RUT             string     `json:"rut" validate:"required,rut"`
+	Nombre          string     `json:"nombre" validate:"required,max=100"`
+	Apellido        *string    `json:"apellido,omitempty" validate:"omitempty,max=100"`
+	Email           *string    `json:"email,omitempty" validate:"omitempty,email"`
+	Telefono        *string    `json:"telefono,omitempty" validate:"omitempty,max=20"`
+	FechaNacimiento *time.Time `json:"fecha_nacimiento,omitempty"`
+	Direccion       *string    `json:"direccion,omitempty" validate:"omitempty,max=200"`
+	Comuna          *string    `json:"comuna,omitempty" validate:"omitempty,max=100"`
+	Region          *string    `json:"region,omitempty" validate:"omitempty,max=100"`
+	AceptaMarketing bool       `json:"acepta_marketing"`
+	Activo          *bool      `json:"activo,omitempty"`
+}
+
+// ReglaFidelizacionRequest request de creación o actualización de regla de fidelización
+type ReglaFidelizacionRequest struct {
+	Nombre      string     `json:"nombre" validate:"required,max=200"`
+	Descripcion *string    `json:"descripcion,omitempty" validate:"omitempty,max=500"`
+	TipoRegla   string     `json:"tipo_regla" validate:"required,oneof=acumulacion canje promocion nivel"`
+	Condiciones JSONB      `json:"condiciones" validate:"required"`
+	Acciones    JSONB      `json:"acciones" validate:"required"`
+	Activa      *bool      `json:"activa,omitempty"`
+	FechaInicio *time.Time `json:"fecha_inicio,omitempty"`
+	FechaFin    *time.Time `json:"fecha_fin,omitempty"`
+	Prioridad   int        `json:"prioridad"`
+}
+
+// VentaResponse respuesta de creación o consulta de venta
+type VentaResponse struct {
+	Venta                Venta               `json:"venta"`
+	Detalles             []DetalleVenta      `json:"detalles"`
+	MediosPago           []MedioPagoVenta    `json:"medios_pago"`
+	Vuelto               float64             `json:"vuelto"`
+	PromocionesAplicadas []PromocionAplicada `json:"promociones_aplicadas,omitempty"`
+}
+
+// VentaPreview totales calculados de una venta antes del pago
+type VentaPreview struct {
+	Subtotal             float64             `json:"subtotal"`
+	DescuentoTotal       float64             `json:"descuento_total"`
+	ImpuestoTotal        float64             `json:"impuesto_total"`
+	Total                float64             `json:"total"`
+	Detalles             []DetalleVenta      `json:"detalles"`
+	PromocionesAplicadas []PromocionAplicada `json:"promociones_aplicadas"`
+}
+
+// UnidadesMedidaRequest request de configuración de las unidades de venta de un producto
+type UnidadesMedidaRequest struct {
+	Unidades []UnidadMedidaRequest `json:"unidades" validate:"dive"`
+}
+
+// UnidadMedidaRequest unidad de venta alternativa
+type UnidadMedidaRequest struct {
+	Unidad           string   `json:"unidad" validate:"required,max=20"`
+	FactorConversion float64  `json:"factor_conversion" validate:"required,gt=0"`
+	CodigoBarra      *string  `json:"codigo_barra,omitempty" validate:"omitempty,max=50"`
+	PrecioUnitario   *float64 `json:"precio_unitario,omitempty" validate:"omitempty,gte=0"`
+}
+
+// EntradaStockRequest request de ingreso de mercadería con series y lotes
+type EntradaStockRequest struct {
+	SucursalID          uuid.UUID                 `json:"sucursal_id" validate:"required"`
+	DocumentoReferencia *string                   `json:"documento_referencia,omitempty" validate:"omitempty,max=100"`
+	Observaciones       *string                   `json:"observaciones,omitempty" validate:"omitempty,max=500"`
+	Items               []EntradaStockItemRequest `json:"items" validate:"required,min=1,dive"`
+}
+
+// EntradaStockItemRequest producto ingresado; series para productos con requiere_serie, lote para productos con vencimiento
+type EntradaStockItemRequest struct {
+	ProductoID       uuid.UUID  `json:"producto_id" validate:"required"`
+	Cantidad         float64    `json:"cantidad" validate:"required,gt=0"`
+	CostoUnitario    *float64   `json:"costo_unitario,omitempty" validate:"omitempty,gte=0"`
+	NumerosSerie     []string   `json:"numeros_serie,omitempty" validate:"omitempty,dive,required,max=100"`
+	Lote             *string    `json:"lote,omitempty" validate:"omitempty,max=100"`
+	FechaVencimiento *time.Time `json:"fecha_vencimiento,omitempty"`
+}
+
+// TransferenciaStockRequest request de solicitud de traslado de mercadería entre sucursales
+type TransferenciaStockRequest struct {
+	SucursalOrigenID  uuid.UUID                  `json:"sucursal_origen_id" validate:"required"`
+	SucursalDestinoID uuid.UUID                  `json:"sucursal_destino_id" validate:"required"`
+	Motivo            *string                    `json:"motivo,omitempty" validate:"omitempty,max=200"`
+	Observaciones     *string                    `json:"observaciones,omitempty" validate:"omitempty,max=500"`
+	Items             []TransferenciaItemRequest `json:"items" validate:"required,min=1,max=500,dive"`
+}
+
+// TransferenciaItemRequest producto a trasladar, en unidad base
+type TransferenciaItemRequest struct {
+	ProductoID uuid.UUID `json:"producto_id" validate:"required"`
+	Cantidad   float64   `json:"cantidad" validate:"required,gt=0"`
+}
+
+// DespacharTransferenciaRequest request de despacho; sin ítems se despacha lo solicitado.
+// Los productos con requiere_serie deben informar sus números de serie
+type DespacharTransferenciaRequest struct {
+	Items         []DespachoTransferenciaItemRequest `json:"items,omitempty" validate:"omitempty,max=500,dive"`
+	Observaciones *string                            `json:"observaciones,omitempty" validate:"omitempty,max=500"`
+}
+
+// DespachoTransferenciaItemRequest cantidad despachada de un producto; cero si no se envía
+type DespachoTransferenciaItemRequest struct {
+	ProductoID   uuid.UUID `json:"producto_id" validate:"required"`
+	Cantidad     float64   `json:"cantidad" validate:"min=0"`
+	NumerosSerie []string  `json:"numeros_serie,omitempty" validate:"omitempty,dive,required,max=100"`
+}
+
+// RecibirTransferenciaRequest conteo en destino de cada producto despachado
+type RecibirTransferenciaRequest struct {
+	Items         []RecepcionTransferenciaItemRequest `json:"items" validate:"required,min=1,max=500,dive"`
+	Observaciones *string                             `json:"observaciones,omitempty" validate:"omitempty,max=500"`
+}
+
+// RecepcionTransferenciaItemRequest cantidad contada de un producto; las diferencias requieren motivo
+type RecepcionTransferenciaItemRequest struct {
+	ProductoID       uuid.UUID `json:"producto_id" validate:"required"`
+	CantidadRecibida float64   `json:"cantidad_recibida" validate:"min=0"`
+	NumerosSerie     []string  `json:"numeros_serie,omitempty" validate:"omitempty,dive,required,max=100"` // Series recibidas si faltan unidades
+	MotivoDiferencia *string   `json:"motivo_diferencia,omitempty" validate:"omitempty,max=200"`
+}
+
+// RechazarTransferenciaRequest request de rechazo o cancelación de una transferencia
+type RechazarTransferenciaRequest struct {
+	Motivo string `json:"motivo" validate:"required,min=3,max=200"`
+}
+
+// AbrirConteoRequest request de apertura de un conteo; sin alcance se cuenta toda la sucursal
+type AbrirConteoRequest struct {
+	SucursalID    uuid.UUID  `json:"sucursal_id" validate:"required"`
+	Tipo          string     `json:"tipo" validate:"required,oneof=ciclico anual"`
+	CategoriaID   *uuid.UUID `json:"categoria_id,omitempty"`
+	Pasillo       *string    `json:"pasillo,omitempty" validate:"omitempty,max=20"`
+	Estante       *string    `json:"estante,omitempty" validate:"omitempty,max=20"`
+	Observaciones *string    `json:"observaciones,omitempty" validate:"omitempty,max=500"`
+}
+
+// LecturasConteoRequest request de lecturas enviadas por un terminal de conteo
+type LecturasConteoRequest struct {
+	TerminalID *uuid.UUID                 `json:"terminal_id,omitempty"`
+	Lecturas   []LecturaConteoItemRequest `json:"lecturas" validate:"required,min=1,max=500,dive"`
+}
+
+// LecturaConteoItemRequest lectura de un producto por código de barras o ID
+type LecturaConteoItemRequest struct {
+	CodigoBarra *string    `json:"codigo_barra,omitempty" validate:"required_without=ProductoID,omitempty,max=50"`
+	ProductoID  *uuid.UUID `json:"producto_id,omitempty"`
+	Cantidad    float64    `json:"cantidad" validate:"gt=0"` // En la unidad del código leído
+}
+
+// ReconteoRequest request de reconteo de líneas en disputa
+type ReconteoRequest struct {
+	ProductoIDs []uuid.UUID `json:"producto_ids" validate:"required,min=1,max=500"`
+}
+
+// AprobarConteoRequest request de aprobación de un conteo
+type AprobarConteoRequest struct {
+	AjustarSinContar bool    `json:"ajustar_sin_contar"` // Las líneas sin contar se ajustan a cero
+	Observaciones    *string `json:"observaciones,omitempty" validate:"omitempty,max=500"`
+}
+
+// CancelarConteoRequest request de cancelación de un conteo
+type CancelarConteoRequest struct {
+	Motivo string `json:"motivo" validate:"required,min=5,max=500"`
+}
+
+// ProveedorRequest request de creación o actualización de proveedor
+type ProveedorRequest struct {
+	RUT            string  `json:"rut" validate:"required,rut"`
+	RazonSocial    string  `json:"razon_social" validate:"required,max=200"`
+	NombreFantasia *string `json:"nombre_fantasia,omitempty" validate:"omitempty,max=200"`
+	Giro           *string `json:"giro,omitempty" validate:"omitempty,max=200"`
+	Direccion      *string `json:"direccion,omitempty" validate:"omitempty,max=300"`
+	Comuna         *string `json:"comuna,omitempty" validate:"omitempty,max=100"`
+	Telefono       *string `json:"telefono,omitempty" validate:"omitempty,max=30"`
+	Email          *string `json:"email,omitempty" validate:"omitempty,email"`
+	Contacto       *string `json:"contacto,omitempty" validate:"omitempty,max=200"`
+	DiasCredito    *int    `json:"dias_credito,omitempty" validate:"omitempty,min=0,max=180"`
+	DiasEntrega    *int    `json:"dias_entrega,omitempty" validate:"omitempty,min=0,max=180"`
+	Activo         *bool   `json:"activo,omitempty"`
+}
+
+// OrdenCompraRequest request de creación o reemplazo de una orden de compra en borrador
+type OrdenCompraRequest struct {
+	ProveedorID          uuid.UUID                `json:"proveedor_id" validate:"required"`
+	SucursalID           uuid.UUID                `json:"sucursal_id" validate:"required"`
+	FechaEntregaEstimada *time.Time               `json:"fecha_entrega_estimada,omitempty"`
+	Observaciones        *string                  `json:"observaciones,omitempty" validate:"omitempty,max=500"`
+	Items                []OrdenCompraItemRequest `json:"items" validate:"required,min=1,max=500,dive"`
+}
+
+// OrdenCompraItemRequest producto pedido en unidad base
+type OrdenCompraItemRequest struct {
+	ProductoID    uuid.UUID `json:"producto_id" validate:"required"`
+	Cantidad      float64   `json:"cantidad" validate:"required,gt=0"`
+	CostoUnitario float64   `json:"costo_unitario" validate:"gte=0"`
+}
+
+// CancelarOrdenCompraRequest request de cancelación de una orden de compra
+type CancelarOrdenCompraRequest struct {
+	Motivo string `json:"motivo" validate:"required,min=5,max=500"`
+}
+
+// RecepcionCompraRequest request de recepción de mercadería, con o sin orden de compra
+type RecepcionCompraRequest struct {
+	ProveedorID      uuid.UUID                    `json:"proveedor_id" validate:"required"`
+	SucursalID       uuid.UUID                    `json:"sucursal_id" validate:"required"`
+	OrdenCompraID    *uuid.UUID                   `json:"orden_compra_id,omitempty"`
+	TipoDocumento    string                       `json:"tipo_documento" validate:"required,oneof=factura guia_despacho"`
+	NumeroDocumento  string                       `json:"numero_documento" validate:"required,max=50"`
+	FechaDocumento   *time.Time                   `json:"fecha_documento,omitempty"` // Por defecto la fecha de recepción
+	Observaciones    *string                      `json:"observaciones,omitempty" validate:"omitempty,max=500"`
+	GenerarEtiquetas bool                         `json:"generar_etiquetas"`      // Etiquetas de precio para productos cuyo costo cambió
+	PlantillaID      *uuid.UUID                   `json:"plantilla_id,omitempty"` // Por defecto la plantilla predeterminada
+	Items            []RecepcionCompraItemRequest `json:"items" validate:"required,min=1,max=500,dive"`
+}
+
+// RecepcionCompraItemRequest producto recibido; sin costo se usa el pactado en la orden
+type RecepcionCompraItemRequest struct {
+	ProductoID       uuid.UUID  `json:"producto_id" validate:"required"`
+	Cantidad         float64    `json:"cantidad" validate:"required,gt=0"`
+	CostoUnitario    *float64   `json:"costo_unitario,omitempty" validate:"omitempty,gte=0"`
+	NumerosSerie     []string   `json:"numeros_serie,omitempty" validate:"omitempty,dive,required,max=100"`
+	Lote             *string    `json:"lote,omitempty" validate:"omitempty,max=100"`
+	FechaVencimiento *time.Time `json:"fecha_vencimiento,omitempty"`
+}
+
+// PagoProveedorRequest request de abono a un documento de proveedor
+type PagoProveedorRequest struct {
+	Monto      float64 `json:"monto" validate:"required,gt=0"`
+	MedioPago  string  `json:"medio_pago" validate:"required,oneof=efectivo transferencia cheque"`
+	Referencia *string `json:"referencia,omitempty" validate:"omitempty,max=100"`
+}
+
+// ProductosProveedorRequest request para registrar o actualizar productos del catálogo de un proveedor
+type ProductosProveedorRequest struct {
+	Items []ProductoProveedorRequest `json:"items" validate:"required,min=1,dive"`
+}
+
+// ProductoProveedorRequest condiciones de compra de un producto
+type ProductoProveedorRequest struct {
+	ProductoID      uuid.UUID `json:"producto_id" validate:"required"`
+	CodigoProveedor *string   `json:"codigo_proveedor,omitempty" validate:"omitempty,max=50"`
+	UnidadCompra    *float64  `json:"unidad_compra,omitempty" validate:"omitempty,gt=0"`
+	CostoReferencia *float64  `json:"costo_referencia,omitempty" validate:"omitempty,gte=0"`
+	DiasEntrega     *int      `json:"dias_entrega,omitempty" validate:"omitempty,min=0,max=180"`
+	Preferido       bool      `json:"preferido"`
+	Activo          *bool     `json:"activo,omitempty"`
+}
+
+// OrdenesSugeridasRequest request para convertir las sugerencias de reposición en órdenes de compra en borrador
+type OrdenesSugeridasRequest struct {
+	SucursalID    uuid.UUID   `json:"sucursal_id" validate:"required"`
+	ProveedorIDs  []uuid.UUID `json:"proveedor_ids,omitempty"` // Vacío convierte todos los proveedores
+	DiasHistoria  *int        `json:"dias_historia,omitempty" validate:"omitempty,min=7,max=365"`
+	DiasCobertura *int        `json:"dias_cobertura,omitempty" validate:"omitempty,min=1,max=180"`
+	NivelServicio *int        `json:"nivel_servicio,omitempty" validate:"omitempty,oneof=90 95 98 99"`
+}
+
+// AlertasProductoRequest request para configurar las alertas de stock de un producto en una sucursal
+type AlertasProductoRequest struct {
+	SucursalID    uuid.UUID                 `json:"sucursal_id" validate:"required"`
+	Configuracion ConfiguracionAlertasStock `json:"configuracion"`
+}
+
+// ResolverAlertaStockRequest request para cerrar una alerta de stock
+type ResolverAlertaStockRequest struct {
+	Observaciones *string `json:"observaciones,omitempty" validate:"omitempty,max=500"`
+}
+
+// ReservarStockRequest request para reservar stock a nombre de una nota de venta, cotización o carrito.
+// Cada ítem fija la cantidad reservada del producto para el origen; cantidad 0 libera su reserva
+type ReservarStockRequest struct {
+	SucursalID    uuid.UUID                 `json:"sucursal_id" validate:"required"`
+	TipoOrigen    string                    `json:"tipo_origen" validate:"required,oneof=nota_venta cotizacion carrito"`
+	OrigenID      uuid.UUID                 `json:"origen_id" validate:"required"` // Para carritos, UUID generado por el terminal
+	TerminalID    *uuid.UUID                `json:"terminal_id,omitempty"`
+	TTLMinutos    int                       `json:"ttl_minutos,omitempty" validate:"omitempty,min=1,max=10080"` // Por defecto la vigencia del documento o la de carrito
+	Observaciones *string                   `json:"observaciones,omitempty" validate:"omitempty,max=500"`
+	Items         []ReservaStockItemRequest `json:"items" validate:"required,min=1,max=200,dive"`
+}
+
+// ReservaStockItemRequest cantidad a reservar de un producto, en unidad base
+type ReservaStockItemRequest struct {
+	ProductoID uuid.UUID `json:"producto_id" validate:"required"`
+	Cantidad   float64   `json:"cantidad" validate:"min=0"`
+}
+
+// LiberarStockRequest request para liberar reservas por ID o todas las activas de un origen
+type LiberarStockRequest struct {
+	ReservaIDs []uuid.UUID `json:"reserva_ids,omitempty" validate:"omitempty,max=200"`
+	TipoOrigen *string     `json:"tipo_origen,omitempty" validate:"omitempty,oneof=nota_venta cotizacion carrito"`
+	OrigenID   *uuid.UUID  `json:"origen_id,omitempty"`
+	Motivo     *string     `json:"motivo,omitempty" validate:"omitempty,max=200"`
+}
+
+// NotaCreditoRequest request de solicitud de devolución con nota de crédito
+type NotaCreditoRequest struct {
+	VentaID    uuid.UUID                `json:"venta_id" validate:"required"`
+	TerminalID uuid.UUID                `json:"terminal_id" validate:"required"`
+	Motivo     string                   `json:"motivo" validate:"required,min=5,max=500"`
+	Items      []NotaCreditoItemRequest `json:"items" validate:"required,min=1,dive"`
+	Reembolsos []ReembolsoRequest       `json:"reembolsos" validate:"required,min=1,dive"`
+}
+
+// NotaCreditoItemRequest línea de la venta a devolver
+type NotaCreditoItemRequest struct {
+	DetalleVentaID uuid.UUID `json:"detalle_venta_id" validate:"required"`
+	Cantidad       float64   `json:"cantidad" validate:"required,gt=0"`
+	AfectaStock    *bool     `json:"afecta_stock,omitempty"` // Por defecto true; false para mercadería dañada que no vuelve a la venta
+	Motivo         *string   `json:"motivo,omitempty" validate:"omitempty,max=500"`
+}
+
+// ReembolsoRequest medio de devolución del dinero
+type ReembolsoRequest struct {
+	MedioPago             string  `json:"medio_pago" validate:"required,oneof=efectivo tarjeta_debito tarjeta_credito transferencia cuenta_corriente"`
+	Monto                 float64 `json:"monto" validate:"required,gt=0"`
+	ReferenciaTransaccion *string `json:"referencia_transaccion,omitempty"`
+}
+
+// RechazarNotaCreditoRequest request de rechazo de una devolución
+type RechazarNotaCreditoRequest struct {
+	Motivo string `json:"motivo" validate:"required,min=5,max=500"`
+}
+
+// EscanearDespachoRequest request de confirmación de una línea de despacho por código de barras
+type EscanearDespachoRequest struct {
+	CodigoBarra string  `json:"codigo_barra" validate:"required,max=50"`
+	Cantidad    float64 `json:"cantidad,omitempty" validate:"omitempty,gt=0"` // En la presentación escaneada; por defecto 1
+	NumeroSerie *string `json:"numero_serie,omitempty"`
+	Lote        *string `json:"lote,omitempty"`
+}
+
+// LineaDespachoRequest request de registro de la cantidad despachada de una línea
+type LineaDespachoRequest struct {
+	CantidadDespachada float64 `json:"cantidad_despachada" validate:"gte=0"`
+	NumeroSerie        *string `json:"numero_serie,omitempty"`
+	Lote               *string `json:"lote,omitempty"`
+	Observaciones      *string `json:"observaciones,omitempty" validate:"omitempty,max=500"`
+}
+
+// IncidenciaDespachoRequest request de registro de incidencia de despacho
+type IncidenciaDespachoRequest struct {
+	DetalleDespachoID *uuid.UUID `json:"detalle_despacho_id,omitempty"`
+	Tipo              string     `json:"tipo" validate:"required,oneof=producto_faltante producto_danado producto_incorrecto cliente_ausente direccion_incorrecta rechazo_cliente otro"`
+	Descripcion       string     `json:"descripcion" validate:"required,min=3,max=500"`
+	CantidadAfectada  *float64   `json:"cantidad_afectada,omitempty" validate:"omitempty,gt=0"`
+}
+
+// FirmaDespachoRequest request de captura de la firma de quien recibe
+type FirmaDespachoRequest struct {
+	Imagen string  `json:"imagen" validate:"required"` // PNG o JPEG en base64, con o sin prefijo data:
+	Nombre string  `json:"nombre" validate:"required,min=3,max=200"`
+	RUT    *string `json:"rut,omitempty"`
+}
+
+// CompletarDespachoRequest request de cierre de un despacho
+type CompletarDespachoRequest struct {
+	Observaciones *string `json:"observaciones,omitempty" validate:"omitempty,max=500"`
+}
+
+// UbicacionesProductoRequest request de carga de ubicaciones de bodega de una sucursal
+type UbicacionesProductoRequest struct {
+	SucursalID  uuid.UUID                  `json:"sucursal_id" validate:"required"`
+	Ubicaciones []UbicacionProductoRequest `json:"ubicaciones" validate:"required,min=1,max=1000,dive"`
+}
+
+// UbicacionProductoRequest ubicación de un producto; reemplaza la registrada
+type UbicacionProductoRequest struct {
+	ProductoID    uuid.UUID `json:"producto_id" validate:"required"`
+	Pasillo       string    `json:"pasillo" validate:"required,max=20"`
+	Estante       string    `json:"estante" validate:"required,max=20"`
+	Nivel         string    `json:"nivel" validate:"required,max=20"`
+	Observaciones *string   `json:"observaciones,omitempty" validate:"omitempty,max=200"`
+}
+
+// ListaPickingRequest request de generación de lista de picking
+type ListaPickingRequest struct {
+	SucursalID             uuid.UUID   `json:"sucursal_id" validate:"required"`
+	DespachoIDs            []uuid.UUID `json:"despacho_ids,omitempty" validate:"omitempty,max=50"` // Por defecto los pendientes de mayor prioridad
+	MaxDespachos           int         `json:"max_despachos,omitempty" validate:"omitempty,min=1,max=50"`
+	UbicacionConsolidacion *string     `json:"ubicacion_consolidacion,omitempty" validate:"omitempty,max=50"`
+}
+
+// RechazarDespachoRequest request de rechazo de un despacho
+type RechazarDespachoRequest struct {
+	Motivo string `json:"motivo" validate:"required,min=5,max=500"`
+}
+
 // EtiquetaGenerarRequest request de generación de etiquetas
 type EtiquetaGenerarRequest struct {
 	PlantillaID       uuid.UUID   `json:"plantilla_id" validate:"required"`
 	ProductosIDs      []uuid.UUID `json:"productos_ids" validate:"required,min=1"`
 	Cantidad          int         `json:"cantidad" validate:"required,min=1,max=1000"`
 	FormatoSalida     string      `json:"formato_salida" validate:"required,oneof=pdf png zpl"`
+	UnidadMedida      *string     `json:"unidad_medida,omitempty"` // Presentación a etiquetar (precio y código de la unidad); por defecto la unidad base
 	ParametrosEspeciales JSONB    `json:"parametros_especiales,omitempty"`
 }
 
@@ -471,6 +2091,39 @@ func (MovimientoStock) TableName() string             { return "movimientos_stoc
 func (Terminal) TableName() string                    { return "terminales" }
 func (Venta) TableName() string                       { return "ventas" }
 func (DetalleVenta) TableName() string                { return "detalle_ventas" }
+func (MedioPagoVenta) TableName() string              { return "medios_pago_venta" }
+func (NotaVenta) TableName() string                   { return "notas_venta" }
+func (DetalleNotaVenta) TableName() string            { return "detalle_notas_venta" }
+func (SesionCaja) TableName() string                  { return "sesiones_caja" }
+func (MovimientoCaja) TableName() string              { return "movimientos_caja" }
+func (PoliticaDescuento) TableName() string           { return "politicas_descuento" }
+func (AutorizacionDescuento) TableName() string       { return "autorizaciones_descuento" }
+func (Promocion) TableName() string                   { return "promociones" }
+func (PromocionSucursal) TableName() string           { return "promociones_sucursales" }
+func (Cotizacion) TableName() string                  { return "cotizaciones" }
+func (DetalleCotizacion) TableName() string           { return "detalle_cotizaciones" }
+func (CuentaCorriente) TableName() string             { return "cuentas_corrientes" }
+func (MovimientoCuentaCorriente) TableName() string   { return "movimientos_cuenta_corriente" }
+func (ClienteFidelizacion) TableName() string         { return "fidelizacion_clientes" }
+func (MovimientoFidelizacion) TableName() string      { return "movimientos_fidelizacion" }
+func (ReglaFidelizacion) TableName() string           { return "reglas_fidelizacion" }
+func (Despacho) TableName() string                    { return "despachos" }
+func (DetalleDespacho) TableName() string             { return "detalle_despacho" }
+func (IncidenciaDespacho) TableName() string          { return "incidencias_despacho" }
+func (UbicacionProducto) TableName() string           { return "ubicaciones_producto" }
+func (ListaPicking) TableName() string                { return "listas_picking" }
+func (TransferenciaStock) TableName() string          { return "transferencias_stock" }
+func (DetalleTransferenciaStock) TableName() string   { return "detalle_transferencias_stock" }
+func (AlertaStock) TableName() string                 { return "alertas_stock" }
+func (ReservaStock) TableName() string                { return "reservas_stock" }
+func (ConteoInventario) TableName() string            { return "conteos_inventario" }
+func (DetalleConteoInventario) TableName() string     { return "detalle_conteo_inventario" }
+func (Proveedor) TableName() string                   { return "proveedores" }
+func (ProductoProveedor) TableName() string           { return "productos_proveedores" }
+func (OrdenCompra) TableName() string                 { return "ordenes_compra" }
+func (DetalleOrdenCompra) TableName() string          { return "detalle_ordenes_compra" }
+func (RecepcionCompra) TableName() string             { return "recepciones_compra" }
+func (DetalleRecepcionCompra) TableName() string      { return "detalle_recepciones_compra" }
 func (EtiquetaPlantilla) TableName() string           { return "etiquetas_plantillas" }
 func (EtiquetaTrabajoImpresion) TableName() string    { return "etiquetas_trabajos_impresion" }
 
@@ -0,0 +1,185 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"strings"
+)
+
+const (
+	// AnchoA4 ancho de página A4 en puntos
+	AnchoA4 = 595.0
+	// AltoA4 alto de página A4 en puntos
+	AltoA4 = 842.0
+)
+
+// Documento documento PDF de texto sobre páginas A4 con las fuentes estándar Helvetica
+type Documento struct {
+	paginas  []*bytes.Buffer
+	imagenes []imagenPDF
+}
+
+// imagenPDF imagen RGB comprimida para insertarse como XObject
+type imagenPDF struct {
+	ancho int
+	alto  int
+	datos []byte
+}
+
+// New crea un documento con una página en blanco
+func New() *Documento {
+	d := &Documento{}
+	d.NuevaPagina()
+	return d
+}
+
+// NuevaPagina agrega una página en blanco; lo siguiente se dibuja en ella
+func (d *Documento) NuevaPagina() {
+	d.paginas = append(d.paginas, &bytes.Buffer{})
+}
+
+// Texto escribe texto con la esquina inferior izquierda en (x, y), con el origen abajo a la izquierda
+func (d *Documento) Texto(x, y, tamano float64, negrita bool, texto string) {
+	fuente := "F1"
+	if negrita {
+		fuente = "F2"
+	}
+	fmt.Fprintf(d.actual(), "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", fuente, tamano, x, y, escapar(texto))
+}
+
+// TextoDerecha escribe texto alineado a la derecha en x
+func (d *Documento) TextoDerecha(x, y, tamano float64, negrita bool, texto string) {
+	d.Texto(x-AnchoTexto(texto, tamano), y, tamano, negrita, texto)
+}
+
+// Linea dibuja una línea entre dos puntos
+func (d *Documento) Linea(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(d.actual(), "%.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+// Imagen dibuja la imagen escalada al rectángulo con esquina inferior izquierda en (x, y).
+// La transparencia se compone sobre fondo blanco
+func (d *Documento) Imagen(x, y, ancho, alto float64, img image.Image) {
+	limites := img.Bounds()
+	rgb := make([]byte, 0, limites.Dx()*limites.Dy()*3)
+	for py := limites.Min.Y; py < limites.Max.Y; py++ {
+		for px := limites.Min.X; px < limites.Max.X; px++ {
+			r, g, b, a := img.At(px, py).RGBA()
+			fondo := 0xffff - a
+			rgb = append(rgb, byte((r+fondo)>>8), byte((g+fondo)>>8), byte((b+fondo)>>8))
+		}
+	}
+
+	var datos bytes.Buffer
+	w := zlib.NewWriter(&datos)
+	w.Write(rgb)
+	w.Close()
+
+	d.imagenes = append(d.imagenes, imagenPDF{ancho: limites.Dx(), alto: limites.Dy(), datos: datos.Bytes()})
+	fmt.Fprintf(d.actual(), "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", ancho, alto, x, y, len(d.imagenes))
+}
+
+// Bytes serializa el documento en formato PDF 1.4
+func (d *Documento) Bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+
+	objeto := func(contenido string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), contenido)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objetos fijos: 1 catálogo, 2 páginas, 3 y 4 fuentes; luego página y contenido alternados
+	// y al final las imágenes, compartidas por todas las páginas
+	kids := make([]string, len(d.paginas))
+	for i := range d.paginas {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+i*2)
+	}
+	xobjects := ""
+	if len(d.imagenes) > 0 {
+		refs := make([]string, len(d.imagenes))
+		for i := range d.imagenes {
+			refs[i] = fmt.Sprintf("/Im%d %d 0 R", i+1, 5+len(d.paginas)*2+i)
+		}
+		xobjects = fmt.Sprintf(" /XObject << %s >>", strings.Join(refs, " "))
+	}
+	objeto("<< /Type /Catalog /Pages 2 0 R >>")
+	objeto(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.paginas)))
+	objeto("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	objeto("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, p := range d.paginas {
+		objeto(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >>%s >> /Contents %d 0 R >>",
+			AnchoA4, AltoA4, xobjects, 6+i*2))
+		objeto(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", p.Len(), p.String()))
+	}
+	for _, img := range d.imagenes {
+		objeto(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB "+
+			"/BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream",
+			img.ancho, img.alto, len(img.datos), img.datos))
+	}
+
+	inicioXref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, inicioXref)
+
+	return out.Bytes()
+}
+
+// AnchoTexto estima el ancho en puntos de un texto en Helvetica
+func AnchoTexto(texto string, tamano float64) float64 {
+	unidades := 0
+	for _, r := range texto {
+		switch {
+		case r == ' ' || r == '.' || r == ',' || r == ':' || r == ';' || r == 'i' || r == 'l' || r == 'j' || r == '!' || r == '|':
+			unidades += 278
+		case r == '-' || r == '(' || r == ')' || r == 'f' || r == 't' || r == 'r':
+			unidades += 333
+		case r == 'm' || r == 'M' || r == 'W':
+			unidades += 833
+		case r == 'w' || r == '%':
+			unidades += 722
+		case r >= 'A' && r <= 'Z':
+			unidades += 667
+		default:
+			unidades += 556
+		}
+	}
+	return float64(unidades) * tamano / 1000
+}
+
+func (d *Documento) actual() *bytes.Buffer {
+	return d.paginas[len(d.paginas)-1]
+}
+
+// escapar convierte el texto a WinAnsi escapando los delimitadores de cadena PDF
+func escapar(texto string) string {
+	var b strings.Builder
+	for _, r := range texto {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n' || r == '\r' || r == '\t':
+			b.WriteByte(' ')
+		case r < 0x80:
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			// Latin-1 coincide con WinAnsi en este rango (tildes, ñ, ¿, ¡, °)
+			fmt.Fprintf(&b, "\\%03o", r)
+		case r == '€':
+			b.WriteString("\\200")
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,62 @@
+package pdf_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ferre_pos_apis/pkg/pdf"
+)
+
+func TestPDFEstructura(t *testing.T) {
+	doc := pdf.New()
+	doc.Texto(40, 800, 12, true, "Cotización")
+	doc.NuevaPagina()
+	doc.Texto(40, 800, 12, false, "Página 2")
+
+	out := doc.Bytes()
+	assert.True(t, bytes.HasPrefix(out, []byte("%PDF-1.4")))
+	assert.True(t, bytes.HasSuffix(out, []byte("%%EOF\n")))
+	assert.Contains(t, string(out), "/Count 2")
+
+	// startxref debe apuntar a la tabla xref
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	require.NotNil(t, m)
+	offset, err := strconv.Atoi(string(m[1]))
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(out[offset:], []byte("xref\n")))
+}
+
+func TestPDFEscapaTexto(t *testing.T) {
+	doc := pdf.New()
+	doc.Texto(40, 800, 12, false, "Pala (acero) N° 5 ñ")
+
+	out := string(doc.Bytes())
+	assert.Contains(t, out, `(Pala \(acero\) N\260 5 \361)`)
+}
+
+func TestPDFAnchoTexto(t *testing.T) {
+	assert.Equal(t, 0.0, pdf.AnchoTexto("", 10))
+	assert.Greater(t, pdf.AnchoTexto("MMMM", 10), pdf.AnchoTexto("iiii", 10))
+	assert.InDelta(t, 2*pdf.AnchoTexto("Total", 10), pdf.AnchoTexto("Total", 20), 0.001)
+}
+
+func TestPDFImagen(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	img.Set(0, 0, color.RGBA{A: 255})
+
+	doc := pdf.New()
+	doc.Imagen(40, 100, 200, 100, img)
+	doc.NuevaPagina()
+
+	out := string(doc.Bytes())
+	assert.Contains(t, out, "/Im1 Do")
+	assert.Contains(t, out, "/XObject << /Im1 9 0 R >>")
+	assert.Contains(t, out, "9 0 obj\n<< /Type /XObject /Subtype /Image /Width 4 /Height 2")
+}